@@ -2,14 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/tinkerbell/smee/internal/backend/file"
 	"github.com/tinkerbell/smee/internal/backend/kube"
 	"github.com/tinkerbell/smee/internal/backend/noop"
+	"github.com/tinkerbell/smee/internal/backend/replay"
 	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/pluginhost"
+	"github.com/tinkerbell/smee/internal/settings"
 	"github.com/tinkerbell/tink/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/scale/scheme"
 	"k8s.io/client-go/tools/clientcmd"
@@ -27,6 +36,17 @@ type Kube struct {
 	// The default is the Namespace the pod is running in.
 	Namespace string
 	Enabled   bool
+	// AdditionalConfigFilePaths are paths to kubeconfig files of additional clusters to read Hardware objects from.
+	// Results from all clusters are merged, a machine must only be defined in one cluster.
+	AdditionalConfigFilePaths []string
+	// Namespaced restricts the backend to namespaced get, list and watch of Hardware objects in a single namespace,
+	// the Namespace override or, if not set, the namespace from the kubeconfig context or in-cluster service account.
+	// No cluster-scoped permissions are needed in this mode.
+	Namespaced bool
+	// EnrollDiscovered creates a minimal Hardware object for unknown machines that fetch the static iPXE script.
+	EnrollDiscovered bool
+	// ValidateInterval is how often the Hardware cache is validated with the Kubernetes API, see kube.Backend.Validate.
+	ValidateInterval time.Duration
 }
 type File struct {
 	// FilePath is the path to a JSON FilePath containing hardware data.
@@ -36,13 +56,73 @@ type File struct {
 
 type Noop struct {
 	Enabled bool
+	// TemplateFile is the path to a YAML file with the netboot data that every machine gets.
+	TemplateFile string
+	// IPXEScriptFile is the path to an iPXE script that every machine is served, it takes precedence over the
+	// iPXE script of TemplateFile.
+	IPXEScriptFile string
 }
 
-func (n *Noop) backend() handler.BackendReader {
-	return &noop.Backend{}
+type Plugin struct {
+	// Path is the path to the plugin executable.
+	Path    string
+	Enabled bool
+}
+
+// Replay is the backend that serves a recording of the lookups of another backend, see replay.Backend.
+type Replay struct {
+	// FilePath is the path to the JSON lines file of the recording.
+	FilePath string
+	Enabled  bool
+}
+
+func (r *Replay) backend() (handler.BackendReader, error) {
+	if r.FilePath == "" {
+		return nil, errors.New("a recording file path is required")
+	}
+
+	return replay.Load(r.FilePath)
+}
+
+func (p *Plugin) backend(ctx context.Context, log logr.Logger, h *pluginhost.Host) (handler.BackendReader, error) {
+	if p.Path == "" {
+		return nil, errors.New("a plugin path is required")
+	}
+	c, err := h.Client(ctx, log, p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Backend()
+}
+
+func (n *Noop) backend() (handler.BackendReader, error) {
+	var t *noop.Template
+	if n.TemplateFile != "" {
+		var err error
+		if t, err = noop.LoadTemplate(n.TemplateFile); err != nil {
+			return nil, err
+		}
+	}
+	if n.IPXEScriptFile != "" {
+		b, err := os.ReadFile(n.IPXEScriptFile)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			t = &noop.Template{}
+		}
+		t.IPXEScript = string(b)
+	}
+
+	return &noop.Backend{Template: t}, nil
 }
 
 func (k *Kube) getClient() (*rest.Config, error) {
+	return k.clientConfig().ClientConfig()
+}
+
+func (k *Kube) clientConfig() clientcmd.ClientConfig {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	loadingRules.ExplicitPath = k.ConfigFilePath
 
@@ -54,16 +134,106 @@ func (k *Kube) getClient() (*rest.Config, error) {
 			Namespace: k.Namespace,
 		},
 	}
-	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
 
-	return loader.ClientConfig()
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
+
+// settingsWatcher returns a ConfigMap watcher for the named ConfigMap.
+// The ConfigMap is expected to be in the backend namespace, this is the Namespace override
+// or, if not set, the namespace from the kubeconfig context or in-cluster service account.
+func (k *Kube) settingsWatcher(name string, log logr.Logger) (*settings.ConfigMapWatcher, error) {
+	config, err := k.getClient()
+	if err != nil {
+		return nil, err
+	}
+	ns, _, err := k.clientConfig().Namespace()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings.ConfigMapWatcher{Client: cs, Namespace: ns, Name: name, Log: log}, nil
+}
+
+// backend returns a kube.Lazy backend, the backend is created in the background and creation is retried
+// until it succeeds, so that a briefly unavailable Kubernetes API does not stop Smee from starting.
+func (k *Kube) backend(ctx context.Context, log logr.Logger) *kube.Lazy {
+	l := &kube.Lazy{New: k.start, Log: log.WithName("kube"), InitialInterval: time.Second, MaxInterval: time.Minute}
+	go func() {
+		_ = l.Start(ctx)
+	}()
+
+	return l
 }
 
-func (k *Kube) backend(ctx context.Context) (handler.BackendReader, error) {
+// start creates and starts the backend and returns once its cache is synced.
+func (k *Kube) start(ctx context.Context) (kube.Reader, error) {
+	kb, err := k.newBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var r interface {
+		kube.Reader
+		Start(context.Context) error
+		WaitForCacheSync(context.Context) bool
+	} = kb
+	if len(k.AdditionalConfigFilePaths) > 0 {
+		m := &kube.Multi{Backends: []*kube.Backend{kb}}
+		for _, p := range k.AdditionalConfigFilePaths {
+			// additional clusters are only configured from their kubeconfig file.
+			ak := &Kube{ConfigFilePath: p, Namespace: k.Namespace, Namespaced: k.Namespaced, ValidateInterval: k.ValidateInterval}
+			b, err := ak.newBackend(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig %s: %w", p, err)
+			}
+			m.Backends = append(m.Backends, b)
+		}
+		r = m
+	}
+
+	// a start that fails before the cache is synced stops the wait, so that creation is retried.
+	var synced atomic.Bool
+	sctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		if err := r.Start(ctx); err != nil {
+			if synced.Load() {
+				panic(err)
+			}
+			cancel(err)
+		}
+	}()
+	if !r.WaitForCacheSync(sctx) {
+		return nil, fmt.Errorf("failed to sync the backend cache: %w", context.Cause(sctx))
+	}
+	synced.Store(true)
+
+	return r, nil
+}
+
+func (k *Kube) newBackend(ctx context.Context) (*kube.Backend, error) {
 	config, err := k.getClient()
 	if err != nil {
 		return nil, err
 	}
+	ns, _, err := k.clientConfig().Namespace()
+	if err != nil {
+		return nil, err
+	}
+	cacheNamespace := k.Namespace
+	if k.Namespaced {
+		cs, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := kube.CheckNamespacedAccess(ctx, cs, ns); err != nil {
+			return nil, err
+		}
+		cacheNamespace = ns
+	}
 
 	rs := runtime.NewScheme()
 
@@ -77,8 +247,8 @@ func (k *Kube) backend(ctx context.Context) (handler.BackendReader, error) {
 
 	conf := func(opts *cluster.Options) {
 		opts.Scheme = rs
-		if k.Namespace != "" {
-			opts.Cache.DefaultNamespaces = map[string]cache.Config{k.Namespace: {}}
+		if cacheNamespace != "" {
+			opts.Cache.DefaultNamespaces = map[string]cache.Config{cacheNamespace: {}}
 		}
 	}
 
@@ -86,18 +256,14 @@ func (k *Kube) backend(ctx context.Context) (handler.BackendReader, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	go func() {
-		err = kb.Start(ctx)
-		if err != nil {
-			panic(err)
-		}
-	}()
+	kb.Namespace = ns
+	kb.WatchNamespace = cacheNamespace
+	kb.ValidateInterval = k.ValidateInterval
 
 	return kb, nil
 }
 
-func (s *File) backend(ctx context.Context, logger logr.Logger) (handler.BackendReader, error) {
+func (s *File) backend(ctx context.Context, logger logr.Logger) (*file.Watcher, error) {
 	f, err := file.NewWatcher(logger, s.FilePath)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tinkerbell/smee/internal/bench"
+)
+
+// benchConfig is the configuration of the bench subcommand.
+type benchConfig struct {
+	dhcpAddr      string
+	tftpAddr      string
+	tftpFile      string
+	httpURL       string
+	isoURL        string
+	isoRangeBytes int64
+	clients       int
+	iterations    int
+	timeout       time.Duration
+	macPrefix     string
+}
+
+func benchFlags(c *benchConfig, fs *flag.FlagSet) {
+	fs.StringVar(&c.dhcpAddr, "dhcp-addr", "", "[dhcp] IP:Port of the DHCP server, DHCP is not benchmarked when not set")
+	fs.StringVar(&c.tftpAddr, "tftp-addr", "", "[tftp] IP:Port of the TFTP server, TFTP is not benchmarked when not set")
+	fs.StringVar(&c.tftpFile, "tftp-file", "ipxe.efi", "[tftp] file to fetch from the TFTP server")
+	fs.StringVar(&c.httpURL, "http-url", "", "[http] base URL of the iPXE script, for example http://192.168.2.111:8080, iPXE scripts are not benchmarked when not set")
+	fs.StringVar(&c.isoURL, "iso-url", "", "[iso] base URL of the ISO, for example http://192.168.2.111:8080/iso, ISOs are not benchmarked when not set")
+	fs.Int64Var(&c.isoRangeBytes, "iso-range-bytes", 1024*1024, "[iso] number of bytes to fetch from the start of the ISO, 0 fetches the whole ISO")
+	fs.IntVar(&c.clients, "clients", 10, "number of concurrent clients")
+	fs.IntVar(&c.iterations, "iterations", 1, "number of network boots each client simulates")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Second, "timeout of each request")
+	fs.StringVar(&c.macPrefix, "mac-prefix", "02:00:00", "first 3 bytes of the client MAC addresses, the last 3 bytes are the client number")
+}
+
+func newBenchCommand() *ffcli.Command {
+	c := &benchConfig{}
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	benchFlags(c, fs)
+	return &ffcli.Command{
+		Name:       "bench",
+		ShortUsage: "smee bench [flags]",
+		ShortHelp:  "simulate concurrent network booting clients against a running Smee",
+		LongHelp:   "Bench simulates concurrent network booting clients against a running Smee and reports latencies and error rates.",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix(name + "_BENCH")},
+		UsageFunc:  customUsageFunc,
+		Exec: func(ctx context.Context, _ []string) error {
+			return c.run(ctx)
+		},
+	}
+}
+
+func (c *benchConfig) run(ctx context.Context) error {
+	cfg, err := c.bench()
+	if err != nil {
+		return err
+	}
+	rep, err := bench.Run(ctx, cfg)
+	if err != nil && rep == nil {
+		return err
+	}
+	if werr := rep.Write(os.Stdout); werr != nil {
+		return werr
+	}
+
+	return err
+}
+
+// bench returns the bench.Config from the flag values.
+func (c *benchConfig) bench() (bench.Config, error) {
+	cfg := bench.Config{
+		TFTPFile:      c.tftpFile,
+		ISORangeBytes: c.isoRangeBytes,
+		Clients:       c.clients,
+		Iterations:    c.iterations,
+		Timeout:       c.timeout,
+	}
+	var err error
+	if c.dhcpAddr != "" {
+		if cfg.DHCPAddr, err = netip.ParseAddrPort(c.dhcpAddr); err != nil {
+			return bench.Config{}, fmt.Errorf("invalid DHCP address: %w", err)
+		}
+	}
+	if c.tftpAddr != "" {
+		if cfg.TFTPAddr, err = netip.ParseAddrPort(c.tftpAddr); err != nil {
+			return bench.Config{}, fmt.Errorf("invalid TFTP address: %w", err)
+		}
+	}
+	if c.httpURL != "" {
+		if cfg.ScriptURL, err = url.Parse(c.httpURL); err != nil {
+			return bench.Config{}, fmt.Errorf("invalid HTTP URL: %w", err)
+		}
+	}
+	if c.isoURL != "" {
+		if cfg.ISOURL, err = url.Parse(c.isoURL); err != nil {
+			return bench.Config{}, fmt.Errorf("invalid ISO URL: %w", err)
+		}
+	}
+	if cfg.MACPrefix, err = net.ParseMAC(c.macPrefix + ":00:00:00"); err != nil {
+		return bench.Config{}, fmt.Errorf("invalid MAC address prefix: %w", err)
+	}
+	cfg.MACPrefix = cfg.MACPrefix[:3]
+	if cfg.ScriptURL == nil && cfg.ISOURL == nil && !cfg.DHCPAddr.IsValid() && !cfg.TFTPAddr.IsValid() {
+		return bench.Config{}, errors.New("at least one of -dhcp-addr, -tftp-addr, -http-url or -iso-url is required")
+	}
+
+	return cfg, nil
+}
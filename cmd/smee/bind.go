@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tinkerbell/smee/internal/sockets"
+	"github.com/tinkerbell/smee/internal/vlan"
+)
+
+// bindConfig is the configuration of the bind subcommand.
+type bindConfig struct {
+	uid int
+	gid int
+}
+
+func bindFlags(c *bindConfig, fs *flag.FlagSet) {
+	fs.IntVar(&c.uid, "uid", 65532, "[bind] user ID that Smee runs as")
+	fs.IntVar(&c.gid, "gid", 65532, "[bind] group ID that Smee runs as")
+}
+
+// newBindCommand returns the bind subcommand, it binds the sockets of the services of the smee flags in cfg, that
+// are parsed by root, and runs Smee with them as an unprivileged user.
+func newBindCommand(cfg *config, root *flag.FlagSet) *ffcli.Command {
+	c := &bindConfig{}
+	fs := flag.NewFlagSet("bind", flag.ExitOnError)
+	bindFlags(c, fs)
+	return &ffcli.Command{
+		Name:       "bind",
+		ShortUsage: "smee [flags] bind [flags]",
+		ShortHelp:  "bind the privileged sockets of Smee and run it as an unprivileged user",
+		LongHelp:   "Bind binds the DHCP, TFTP, HTTP and syslog sockets of the services that the smee flags enable, including the raw DHCP socket, the PXE boot server discovery socket and the DHCP sockets of the VLANs, creating the VLAN subinterfaces that don't exist, then runs Smee with the smee flags as the -uid user and -gid group, without any capability. Smee serves on the inherited sockets. Bind waits for Smee and forwards the interrupt and termination signals to it. See docs/Non-Root.md.",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix(name + "_BIND")},
+		UsageFunc:  customUsageFunc,
+		Exec: func(ctx context.Context, _ []string) error {
+			// the smee flags are the arguments before the bind subcommand.
+			args := os.Args[1 : len(os.Args)-len(root.Args())]
+			return c.run(ctx, cfg, args)
+		},
+	}
+}
+
+func (c *bindConfig) run(ctx context.Context, cfg *config, args []string) error {
+	names, files, remove, err := cfg.bindSockets()
+	if err != nil {
+		return err
+	}
+	// the vlan subinterfaces that were created for Smee are removed once it exits.
+	defer remove()
+	if len(files) == 0 {
+		return errors.New("no socket to bind, the smee flags don't enable any of the dhcp, tftp, http and syslog services")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), fmt.Sprintf("LISTEN_FDS=%d", len(files)), "LISTEN_FDNAMES="+strings.Join(names, ":"))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(c.uid), Gid: uint32(c.gid), Groups: []uint32{}}}
+	// Smee shuts down gracefully on SIGTERM, it is killed when it doesn't within a minute.
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = time.Minute
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run smee: %w", err)
+	}
+	// the sockets are Smee's now.
+	for _, f := range files {
+		f.Close()
+	}
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	return nil
+}
+
+// fileSocket is a bound socket whose file descriptor is passed to Smee.
+type fileSocket interface {
+	File() (*os.File, error)
+	Close() error
+}
+
+// bindSockets binds the sockets of the services that are enabled and returns them with their socket names. The
+// subinterfaces of the DHCP VLANs that don't exist are created to bind their sockets, remove removes them.
+func (c *config) bindSockets() ([]string, []*os.File, func(), error) {
+	var created []vlan.VLAN
+	remove := func() {
+		for _, v := range created {
+			_ = vlan.Remove(v)
+		}
+	}
+	binds := map[string]func() (fileSocket, error){}
+	order := []string{sockets.DHCP, sockets.DHCPRaw, sockets.PXE}
+	for _, st := range c.services() {
+		if !st.enabled {
+			continue
+		}
+		switch st.name {
+		case sockets.DHCP:
+			if dhcpMode(c.dhcp.mode) == dhcpModeKea {
+				continue
+			}
+			addr, err := netip.ParseAddrPort(c.dhcp.bindAddr)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid dhcp address: %w", err)
+			}
+			if c.dhcp.raw {
+				binds[sockets.DHCPRaw] = func() (fileSocket, error) {
+					return c.dhcpLink(int(addr.Port()))
+				}
+			} else {
+				binds[sockets.DHCP] = func() (fileSocket, error) {
+					return server4.NewIPv4UDPConn(c.dhcp.bindInterface, net.UDPAddrFromAddrPort(addr))
+				}
+			}
+			if c.dhcp.windowsCompat {
+				binds[sockets.PXE] = func() (fileSocket, error) {
+					return server4.NewIPv4UDPConn(c.dhcp.bindInterface, net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr.Addr(), pxeBootServerPort)))
+				}
+			}
+			if c.dhcp.vlanFile == "" {
+				continue
+			}
+			vlans, err := vlan.Load(c.dhcp.vlanFile)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to load vlans: %w", err)
+			}
+			for _, v := range vlans.VLANs {
+				binds[sockets.VLAN(v.ID)] = func() (fileSocket, error) {
+					ok, err := vlan.Ensure(v)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						created = append(created, v)
+					}
+					return vlanConn(v, addr.Port())
+				}
+				order = append(order, sockets.VLAN(v.ID))
+			}
+		case sockets.TFTP:
+			binds[sockets.TFTP] = func() (fileSocket, error) {
+				addr, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", c.tftp.bindAddr, c.tftp.bindPort))
+				if err != nil {
+					return nil, err
+				}
+				return net.ListenUDP("udp", net.UDPAddrFromAddrPort(addr))
+			}
+		case sockets.HTTP:
+			binds[sockets.HTTP] = func() (fileSocket, error) {
+				addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", c.ipxeHTTPScript.bindAddr, c.ipxeHTTPScript.bindPort))
+				if err != nil {
+					return nil, err
+				}
+				return net.ListenTCP("tcp", addr)
+			}
+		case sockets.Syslog:
+			binds[sockets.Syslog] = func() (fileSocket, error) {
+				addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%d", c.syslog.bindAddr, c.syslog.bindPort))
+				if err != nil {
+					return nil, err
+				}
+				return net.ListenUDP("udp4", addr)
+			}
+		}
+	}
+	var names []string
+	var files []*os.File
+	for _, name := range append(order, sockets.TFTP, sockets.HTTP, sockets.Syslog) {
+		bind, ok := binds[name]
+		if !ok {
+			continue
+		}
+		f, err := bindFile(bind)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			remove()
+			return nil, nil, nil, fmt.Errorf("failed to bind the %s socket: %w", name, err)
+		}
+		names, files = append(names, name), append(files, f)
+	}
+
+	return names, files, remove, nil
+}
+
+// bindFile returns a copy of the file descriptor of the socket that bind binds.
+func bindFile(bind func() (fileSocket, error)) (*os.File, error) {
+	s, err := bind()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return s.File()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBindSockets(t *testing.T) {
+	cfg := &config{
+		tftp:           tftp{enabled: true, bindAddr: "127.0.0.1"},
+		ipxeHTTPScript: ipxeHTTPScript{enabled: true, bindAddr: "127.0.0.1"},
+		syslog:         syslogConfig{enabled: true, bindAddr: "127.0.0.1"},
+	}
+	names, files, remove, err := cfg.bindSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+	if diff := cmp.Diff([]string{"tftp", "http", "syslog"}, names); diff != "" {
+		t.Fatal(diff)
+	}
+	for i, f := range files {
+		var addr net.Addr
+		if names[i] == "http" {
+			l, err := net.FileListener(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr = l.Addr()
+			l.Close()
+		} else {
+			c, err := net.FilePacketConn(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr = c.LocalAddr()
+			c.Close()
+		}
+		f.Close()
+		if !strings.HasPrefix(addr.String(), "127.0.0.1:") {
+			t.Fatalf("got %s socket address %v, want one on 127.0.0.1", names[i], addr)
+		}
+	}
+}
+
+func TestBindDHCPSockets(t *testing.T) {
+	cfg := &config{
+		dhcp: dhcpConfig{enabled: true, mode: string(dhcpModeProxy), bindAddr: "127.0.0.1:0", windowsCompat: true},
+	}
+	names, files, remove, err := cfg.bindSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remove()
+	for _, f := range files {
+		f.Close()
+	}
+	if diff := cmp.Diff([]string{"dhcp", "dhcp-pxe"}, names); diff != "" {
+		t.Fatal(diff)
+	}
+}
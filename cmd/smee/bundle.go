@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/admin"
+)
+
+// bundleFile is a file of a support bundle.
+type bundleFile struct {
+	name string
+	body []byte
+}
+
+// bundle writes a support bundle of the running Smee, a tarball with its status, effective configuration, services,
+// machines and their last boot events, metrics and goroutine stacks, to the file of args.
+func (c *ctlConfig) bundle(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) > 1 {
+		return errors.New("at most one file is accepted")
+	}
+	now := time.Now()
+	file := "smee-bundle-" + now.Format("20060102-150405") + ".tar.gz"
+	if len(args) == 1 {
+		file = args[0]
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	dir := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".gz"), ".tar")
+	if err := writeBundle(f, dir, now, c.gather(ctx, cl)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "wrote %s\n", file)
+
+	return err
+}
+
+// gather returns the files of a support bundle. A file whose call failed holds the error instead, so that a bundle
+// is written for a Smee that is partly broken, or that is too old to return its debugging information.
+func (c *ctlConfig) gather(ctx context.Context, cl *admin.Client) []bundleFile {
+	output := func(run func(*ctlConfig, context.Context, *admin.Client, []string) error) []byte {
+		b := &bytes.Buffer{}
+		cc := *c
+		cc.out = b
+		if err := run(&cc, ctx, cl, nil); err != nil {
+			fmt.Fprintf(b, "error: %v\n", err)
+		}
+		return b.Bytes()
+	}
+	files := []bundleFile{
+		{name: "status.txt", body: output((*ctlConfig).status)},
+		{name: "machines.txt", body: output((*ctlConfig).machines)},
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	info, err := cl.GetDebugInfo(dctx, &admin.GetDebugInfoRequest{})
+	if err != nil {
+		return append(files, bundleFile{name: "debug-info.txt", body: []byte(fmt.Sprintf("error: %v\n", err))})
+	}
+	version := fmt.Sprintf("go version: %s\nctl version: %s\nctl go version: %s\n", info.GoVersion, GitRev, runtime.Version())
+	services := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(services, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tRESTARTS\tERROR")
+	for _, s := range info.Services {
+		msg := s.Error
+		if msg == "" {
+			msg = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", s.Name, s.State, s.Restarts, msg)
+	}
+	_ = tw.Flush()
+
+	return append(files,
+		bundleFile{name: "version.txt", body: []byte(version)},
+		bundleFile{name: "config.txt", body: []byte(strings.Join(info.Config, "\n") + "\n")},
+		bundleFile{name: "services.txt", body: services.Bytes()},
+		bundleFile{name: "metrics.txt", body: []byte(info.Metrics)},
+		bundleFile{name: "goroutines.txt", body: []byte(info.Goroutines)},
+	)
+}
+
+// writeBundle writes files as a gzipped tarball to w, in the directory dir.
+func writeBundle(w io.Writer, dir string, modTime time.Time, files []bundleFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		hdr := &tar.Header{Name: path.Join(dir, f.name), Mode: 0o644, Size: int64(len(f.body)), ModTime: modTime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.body); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
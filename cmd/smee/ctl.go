@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tinkerbell/smee/internal/admin"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ctlConfig is the configuration of the ctl subcommand.
+type ctlConfig struct {
+	addr      string
+	tokenFile string
+	timeout   time.Duration
+	// out is where the output of the subcommands is written.
+	out io.Writer
+}
+
+func ctlFlags(c *ctlConfig, fs *flag.FlagSet) {
+	fs.StringVar(&c.addr, "addr", "unix:/run/smee/admin.sock", "[admin] address of the admin api of the running Smee, unix:<path> for a unix socket or host:port for TCP")
+	fs.StringVar(&c.tokenFile, "token-file", "", "[admin] path to a file with the admin api token")
+	fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "[admin] timeout of each call, tail-syslog streams until interrupted")
+}
+
+func newCtlCommand() *ffcli.Command {
+	c := &ctlConfig{out: os.Stdout}
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	ctlFlags(c, fs)
+	return &ffcli.Command{
+		Name:       "ctl",
+		ShortUsage: "smee ctl [flags] <subcommand> [args]",
+		ShortHelp:  "control a running Smee with its admin api",
+		LongHelp:   "Ctl talks to the admin api of a running Smee, see -admin-addr.",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix(name + "_CTL")},
+		UsageFunc:  customUsageFunc,
+		Subcommands: []*ffcli.Command{
+			c.command("status", "smee ctl status", "show the status of Smee", c.status),
+			c.command("machines", "smee ctl machines [mac]", "list the machines seen by Smee, or show a machine", c.machines),
+			c.command("render", "smee ctl render <mac>", "render the auto.ipxe script of a machine", c.render),
+			c.command("boot-config", "smee ctl boot-config <mac> [arch]", "show what a machine does when it network boots: the DHCP replies to its PXE firmware and to iPXE, and its auto.ipxe script", c.bootConfig),
+			c.command("snapshots", "smee ctl snapshots <mac>", "list the snapshots of the last boot scripts that a machine was served, see -snapshot-count", c.snapshots),
+			c.command("snapshot-diff", "smee ctl snapshot-diff <mac> [from to]", "show the differences between two snapshots of a machine, the one before the latest and the latest by default", c.snapshotDiff),
+			c.command("pin", "smee ctl pin <mac> <version>", "pin a machine to a snapshot, it is served the snapshot in place of a new rendering of its boot script", c.pin),
+			c.command("unpin", "smee ctl unpin <mac>", "unpin a machine, it is served new renderings of its boot scripts again", c.unpin),
+			c.command("flush-cache", "smee ctl flush-cache [name...]", "flush the named caches, all caches when no names are given", c.flushCache),
+			c.command("tail-syslog", "smee ctl tail-syslog [host]", "stream the syslog messages received from machines, of a single host IP address when given", c.tailSyslog),
+			c.command("faults", "smee ctl faults", "show the faults that are injected, see -chaos-enabled", c.faults),
+			c.command("set-faults", "smee ctl set-faults [dhcp-drop-percent=<n>] [script-delay=<duration>] [iso-corrupt-bytes=<n>]", "replace the faults that are injected, the faults that are not given are not injected", c.setFaults),
+			c.command("maintenance", "smee ctl maintenance", "show what is in maintenance, see -maintenance-enabled", c.maintenance),
+			c.command("set-maintenance", "smee ctl set-maintenance on|off|<cidr>...", "replace the maintenance state, on puts every machine in maintenance, CIDRs put the machines of the subnets in maintenance, off ends the maintenance", c.setMaintenance),
+			c.command("self-test", "smee ctl self-test", "run the self-test of the canary machine now, see -self-test-mac, it fails when a check fails", c.selfTest),
+			c.command("leases", "smee ctl leases [table|csv|json]", "list the DHCP leases and the reservations of the backend, as a table, CSV or JSON", c.leases),
+			c.command("bundle", "smee ctl bundle [file]", "write a support bundle, a tarball with the status, configuration, services, machines, metrics and goroutines of Smee", c.bundle),
+		},
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+	}
+}
+
+// command returns a subcommand of ctl that calls run with a client of the admin api.
+func (c *ctlConfig) command(name, usage, help string, run func(context.Context, *admin.Client, []string) error) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       name,
+		ShortUsage: usage,
+		ShortHelp:  help,
+		FlagSet:    flag.NewFlagSet(name, flag.ExitOnError),
+		UsageFunc:  customUsageFunc,
+		Exec: func(ctx context.Context, args []string) error {
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+			defer cl.Close()
+
+			return run(ctx, cl, args)
+		},
+	}
+}
+
+// client returns a client of the admin api.
+func (c *ctlConfig) client() (*admin.Client, error) {
+	var token string
+	if c.tokenFile != "" {
+		t, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin token: %w", err)
+		}
+		token = string(bytes.TrimSpace(t))
+	}
+
+	return admin.Dial(c.addr, token)
+}
+
+func (c *ctlConfig) status(ctx context.Context, cl *admin.Client, _ []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	s, err := cl.Status(ctx, &admin.StatusRequest{})
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "version:\t%s\n", s.Version)
+	fmt.Fprintf(tw, "started:\t%s\n", formatTime(s.StartTime))
+	fmt.Fprintf(tw, "ready:\t%t\n", s.Ready)
+	fmt.Fprintf(tw, "dhcp dry-run:\t%t\n", s.DryRun)
+	fmt.Fprintf(tw, "caches:\t%s\n", joinOrNone(s.Caches))
+
+	return tw.Flush()
+}
+
+func (c *ctlConfig) machines(ctx context.Context, cl *admin.Client, args []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	switch len(args) {
+	case 0:
+		resp, err := cl.ListMachines(ctx, &admin.ListMachinesRequest{})
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+		fmt.Fprintln(tw, "MAC\tLAST EVENT\tTIME\tDIAGNOSTICS")
+		for _, m := range resp.Machines {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.Mac, formatEvent(m.LastEvent), formatTime(m.LastEvent.GetTime()), joinOrNone(m.Diagnostics))
+		}
+		return tw.Flush()
+	case 1:
+		m, err := cl.GetMachine(ctx, &admin.GetMachineRequest{Mac: args[0]})
+		if err != nil {
+			return err
+		}
+		labels := make([]string, 0, len(m.Labels))
+		for k, v := range m.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+		fmt.Fprintf(tw, "mac:\t%s\n", m.Mac)
+		fmt.Fprintf(tw, "ip:\t%s\n", m.Ip)
+		fmt.Fprintf(tw, "hostname:\t%s\n", m.Hostname)
+		fmt.Fprintf(tw, "arch:\t%s\n", m.Arch)
+		fmt.Fprintf(tw, "allow netboot:\t%t\n", m.AllowNetboot)
+		fmt.Fprintf(tw, "facility:\t%s\n", m.Facility)
+		fmt.Fprintf(tw, "labels:\t%s\n", joinOrNone(labels))
+		fmt.Fprintf(tw, "last event:\t%s\n", formatEvent(m.LastEvent))
+		fmt.Fprintf(tw, "diagnostics:\t%s\n", joinOrNone(m.Diagnostics))
+		if len(m.Fetches) == 0 {
+			fmt.Fprintln(tw, "fetches:\t-")
+		}
+		for i, f := range m.Fetches {
+			label := ""
+			if i == 0 {
+				label = "fetches:"
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", label, formatFetch(f))
+		}
+		return tw.Flush()
+	default:
+		return errors.New("at most one MAC address is accepted")
+	}
+}
+
+func (c *ctlConfig) render(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("a MAC address is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := cl.RenderScript(ctx, &admin.RenderScriptRequest{Mac: args[0]})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(c.out, resp.Script)
+
+	return err
+}
+
+func (c *ctlConfig) bootConfig(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("a MAC address, and optionally an architecture, are required")
+	}
+	req := &admin.GetBootConfigRequest{Mac: args[0]}
+	if len(args) == 2 {
+		req.Arch = args[1]
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	b, err := cl.GetBootConfig(ctx, req)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "mac:\t%s\n", b.Mac)
+	fmt.Fprintf(tw, "arch:\t%s\n", b.Arch)
+	fmt.Fprintf(tw, "allow netboot:\t%t\n", b.AllowNetboot)
+	fmt.Fprintf(tw, "firmware dhcp:\t%s\n", formatDHCPReply(b.Firmware))
+	fmt.Fprintf(tw, "ipxe dhcp:\t%s\n", formatDHCPReply(b.Ipxe))
+	fmt.Fprintf(tw, "custom script:\t%t\n", b.CustomScript)
+	fmt.Fprintf(tw, "osie url:\t%s\n", orNone(b.OsieUrl))
+	fmt.Fprintf(tw, "tink server:\t%s\n", orNone(b.TinkServer))
+	fmt.Fprintf(tw, "kernel params:\t%s\n", orNone(strings.Join(b.KernelParams, " ")))
+	fmt.Fprintf(tw, "notes:\t%s\n", joinOrNone(b.Notes))
+
+	return tw.Flush()
+}
+
+func (c *ctlConfig) snapshots(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("a MAC address is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := cl.ListSnapshots(ctx, &admin.ListSnapshotsRequest{Mac: args[0]})
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSCRIPT\tTIME\tPINNED")
+	for _, sn := range resp.Snapshots {
+		pinned := ""
+		if resp.Pinned != nil && resp.Pinned.Version == sn.Version {
+			pinned = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", sn.Version, sn.Name, formatTime(sn.Time), pinned)
+	}
+	if p := resp.Pinned; p != nil && !slices.ContainsFunc(resp.Snapshots, func(sn *admin.Snapshot) bool { return sn.Version == p.Version }) {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t*\n", p.Version, p.Name, formatTime(p.Time))
+	}
+
+	return tw.Flush()
+}
+
+func (c *ctlConfig) snapshotDiff(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) != 1 && len(args) != 3 {
+		return errors.New("a MAC address, and optionally the from and to versions, are required")
+	}
+	req := &admin.DiffSnapshotsRequest{Mac: args[0]}
+	if len(args) == 3 {
+		var err error
+		if req.From, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return fmt.Errorf("invalid from version: %w", err)
+		}
+		if req.To, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return fmt.Errorf("invalid to version: %w", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := cl.DiffSnapshots(ctx, req)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "--- %d %s\n+++ %d %s\n", resp.From.Version, resp.From.Name, resp.To.Version, resp.To.Name)
+	_, err = io.WriteString(c.out, resp.Diff)
+
+	return err
+}
+
+func (c *ctlConfig) pin(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) != 2 {
+		return errors.New("a MAC address and a version are required")
+	}
+	v, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := cl.PinSnapshot(ctx, &admin.PinSnapshotRequest{Mac: args[0], Version: v})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "pinned to %d (%s)\n", resp.Pinned.Version, resp.Pinned.Name)
+
+	return err
+}
+
+func (c *ctlConfig) unpin(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("a MAC address is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if _, err := cl.PinSnapshot(ctx, &admin.PinSnapshotRequest{Mac: args[0], Unpin: true}); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(c.out, "unpinned")
+
+	return err
+}
+
+func (c *ctlConfig) flushCache(ctx context.Context, cl *admin.Client, args []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := cl.FlushCaches(ctx, &admin.FlushCachesRequest{Names: args})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "flushed: %s\n", joinOrNone(resp.Flushed))
+
+	return err
+}
+
+func (c *ctlConfig) tailSyslog(ctx context.Context, cl *admin.Client, args []string) error {
+	if len(args) > 1 {
+		return errors.New("at most one host IP address is accepted")
+	}
+	req := &admin.WatchSyslogRequest{}
+	if len(args) == 1 {
+		req.Host = args[0]
+	}
+	stream, err := cl.WatchSyslog(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		m, err := stream.Recv()
+		if err != nil {
+			if errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if _, err := fmt.Fprintln(c.out, formatSyslog(m)); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *ctlConfig) faults(ctx context.Context, cl *admin.Client, _ []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	f, err := cl.GetFaults(ctx, &admin.GetFaultsRequest{})
+	if err != nil {
+		return err
+	}
+
+	return c.printFaults(f)
+}
+
+func (c *ctlConfig) setFaults(ctx context.Context, cl *admin.Client, args []string) error {
+	f, err := parseFaults(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if f, err = cl.SetFaults(ctx, &admin.SetFaultsRequest{Faults: f}); err != nil {
+		return err
+	}
+
+	return c.printFaults(f)
+}
+
+func (c *ctlConfig) maintenance(ctx context.Context, cl *admin.Client, _ []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	m, err := cl.GetMaintenance(ctx, &admin.GetMaintenanceRequest{})
+	if err != nil {
+		return err
+	}
+
+	return c.printMaintenance(m)
+}
+
+func (c *ctlConfig) setMaintenance(ctx context.Context, cl *admin.Client, args []string) error {
+	req, err := parseMaintenance(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	m, err := cl.SetMaintenance(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.printMaintenance(m)
+}
+
+func (c *ctlConfig) selfTest(ctx context.Context, cl *admin.Client, _ []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := cl.RunSelfTest(ctx, &admin.RunSelfTestRequest{})
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDURATION\tERROR")
+	var failed []string
+	for _, r := range resp.Results {
+		result := "pass"
+		switch {
+		case r.Skipped:
+			result = "skipped"
+		case !r.Passed:
+			result = "fail"
+			failed = append(failed, r.Check)
+		}
+		msg := r.Error
+		if msg == "" {
+			msg = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Check, result, r.Duration.AsDuration().Round(time.Millisecond), msg)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("self-test failed: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// leaseRecord is a lease in the CSV and JSON output of leases.
+type leaseRecord struct {
+	MAC          string     `json:"mac"`
+	IP           string     `json:"ip"`
+	Hostname     string     `json:"hostname,omitempty"`
+	State        string     `json:"state"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	LastActivity *time.Time `json:"lastActivity,omitempty"`
+}
+
+func (c *ctlConfig) leases(ctx context.Context, cl *admin.Client, args []string) error {
+	format := "table"
+	switch len(args) {
+	case 0:
+	case 1:
+		format = args[0]
+	default:
+		return errors.New("at most one format is accepted")
+	}
+	if format != "table" && format != "csv" && format != "json" {
+		return fmt.Errorf("unknown format %q, must be table, csv or json", format)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := cl.ListLeases(ctx, &admin.ListLeasesRequest{})
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "csv":
+		w := csv.NewWriter(c.out)
+		_ = w.Write([]string{"mac", "ip", "hostname", "state", "expires", "last_activity"})
+		for _, l := range resp.Leases {
+			_ = w.Write([]string{l.Mac, l.Ip, l.Hostname, l.State, formatUTC(l.Expires), formatUTC(l.LastActivity)})
+		}
+		w.Flush()
+		return w.Error()
+	case "json":
+		recs := make([]leaseRecord, 0, len(resp.Leases))
+		for _, l := range resp.Leases {
+			r := leaseRecord{MAC: l.Mac, IP: l.Ip, Hostname: l.Hostname, State: l.State}
+			if l.Expires != nil {
+				t := l.Expires.AsTime()
+				r.Expires = &t
+			}
+			if l.LastActivity != nil {
+				t := l.LastActivity.AsTime()
+				r.LastActivity = &t
+			}
+			recs = append(recs, r)
+		}
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(recs)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAC\tIP\tHOSTNAME\tSTATE\tEXPIRES\tLAST ACTIVITY")
+	for _, l := range resp.Leases {
+		hostname := l.Hostname
+		if hostname == "" {
+			hostname = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", l.Mac, l.Ip, hostname, l.State, formatTime(l.Expires), formatTime(l.LastActivity))
+	}
+
+	return tw.Flush()
+}
+
+func (c *ctlConfig) printFaults(f *admin.Faults) error {
+	tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "dhcp drop percent:\t%d\n", f.DhcpDropPercent)
+	fmt.Fprintf(tw, "script delay:\t%s\n", f.ScriptDelay.AsDuration())
+	fmt.Fprintf(tw, "iso corrupt bytes:\t%d\n", f.IsoCorruptBytes)
+
+	return tw.Flush()
+}
+
+func (c *ctlConfig) printMaintenance(m *admin.Maintenance) error {
+	tw := tabwriter.NewWriter(c.out, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "enabled:\t%t\n", m.Enabled)
+	fmt.Fprintf(tw, "subnets:\t%s\n", joinOrNone(m.Subnets))
+	fmt.Fprintf(tw, "local boot:\t%t\n", m.LocalBoot)
+	fmt.Fprintf(tw, "settings enabled:\t%t\n", m.SettingsEnabled)
+	fmt.Fprintf(tw, "settings subnets:\t%s\n", joinOrNone(m.SettingsSubnets))
+
+	return tw.Flush()
+}
+
+// parseMaintenance parses the arguments of set-maintenance: on, off, or the CIDRs of subnets.
+func parseMaintenance(args []string) (*admin.SetMaintenanceRequest, error) {
+	if len(args) == 0 {
+		return nil, errors.New("on, off or the CIDRs of subnets are required")
+	}
+	req := &admin.SetMaintenanceRequest{}
+	for _, a := range args {
+		switch a {
+		case "on":
+			req.Enabled = true
+		case "off":
+			if len(args) > 1 {
+				return nil, errors.New("off ends the maintenance of every machine, it takes no other argument")
+			}
+		default:
+			if _, err := netip.ParsePrefix(a); err != nil {
+				return nil, fmt.Errorf("invalid subnet: %w", err)
+			}
+			req.Subnets = append(req.Subnets, a)
+		}
+	}
+
+	return req, nil
+}
+
+// parseFaults parses the name=value arguments of set-faults.
+func parseFaults(args []string) (*admin.Faults, error) {
+	f := &admin.Faults{}
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q, want name=value", a)
+		}
+		switch k {
+		case "dhcp-drop-percent":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", k, err)
+			}
+			f.DhcpDropPercent = uint32(n)
+		case "script-delay":
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", k, err)
+			}
+			f.ScriptDelay = durationpb.New(d)
+		case "iso-corrupt-bytes":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", k, err)
+			}
+			f.IsoCorruptBytes = uint32(n)
+		default:
+			return nil, fmt.Errorf("unknown fault %q", k)
+		}
+	}
+
+	return f, nil
+}
+
+// formatUTC formats t in RFC 3339 in UTC, it is empty when t is nil.
+func formatUTC(t *timestamppb.Timestamp) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.AsTime().UTC().Format(time.RFC3339)
+}
+
+func formatTime(t *timestamppb.Timestamp) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.AsTime().Local().Format(time.RFC3339)
+}
+
+// formatSyslog formats a syslog message as "<time> <host> [(<mac>)] <severity> [<app name>:] <msg>".
+func formatSyslog(m *admin.SyslogMessage) string {
+	host := m.Hostname
+	if host == "" {
+		host = m.Host
+	}
+	f := []string{formatTime(m.Time), host}
+	if m.Mac != "" {
+		f = append(f, "("+m.Mac+")")
+	}
+	f = append(f, m.Severity)
+	if m.AppName != "" {
+		f = append(f, m.AppName+":")
+	}
+
+	return strings.Join(append(f, m.Msg), " ")
+}
+
+func formatEvent(ev *admin.BootEvent) string {
+	if ev == nil {
+		return "-"
+	}
+
+	return ev.Type + " " + ev.Detail
+}
+
+// formatFetch formats an HTTP fetch as "<time> <artifact> <status> <bytes>[/<size>] <path>".
+func formatFetch(f *admin.HTTPFetch) string {
+	sent := strconv.FormatInt(f.Bytes, 10)
+	if f.Size >= 0 && f.Size != f.Bytes {
+		sent += "/" + strconv.FormatInt(f.Size, 10)
+	}
+
+	return fmt.Sprintf("%s %s %d %s %s", formatTime(f.Time), f.Artifact, f.Status, sent, f.Path)
+}
+
+// formatDHCPReply formats a DHCP reply as "<message type> ip=<ip> next-server=<ip> file=<boot file name>".
+func formatDHCPReply(r *admin.DHCPReply) string {
+	if r == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%s ip=%s next-server=%s file=%s", r.MessageType, orNone(r.Ip), orNone(r.NextServer), orNone(r.BootFileName))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func joinOrNone(s []string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+
+	return strings.Join(s, ", ")
+}
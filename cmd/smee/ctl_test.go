@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/admin"
+	"github.com/tinkerbell/smee/internal/chaos"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/maintenance"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/selftest"
+	"github.com/tinkerbell/smee/internal/snapshot"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+// notFoundError is the error of a backend lookup of an unknown machine.
+type notFoundError struct{}
+
+func (notFoundError) NotFound() bool { return true }
+
+func (notFoundError) Error() string { return "not found" }
+
+// reservations is a backend with the reservation of a single machine.
+type reservations struct{}
+
+func (reservations) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, notFoundError{}
+}
+
+func (reservations) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (reservations) List(context.Context) ([]data.Record, error) {
+	d := &data.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, IPAddress: netip.MustParseAddr("192.168.2.10"), Hostname: "node1"}
+
+	return []data.Record{{DHCP: d, Netboot: &data.Netboot{}}}, nil
+}
+
+func TestCtl(t *testing.T) {
+	addr := "unix://" + filepath.Join(t.TempDir(), "admin.sock")
+	l, err := admin.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &admin.Server{Log: logr.Discard(), Version: "v1.2.3", StartTime: time.Now(), Caches: &admin.Caches{}, Events: &admin.Events{}, Faults: &chaos.Injector{}, Maintenance: &maintenance.Switch{}, Backend: reservations{}}
+	s.Caches.Add("machines", s.Events.Flush)
+	s.SelfTest = &selftest.Runner{Log: logr.Discard(), Checks: []selftest.Check{
+		{Name: "dhcp", Run: func(context.Context) error { return fmt.Errorf("%w: -dhcp-enabled=false", selftest.ErrSkipped) }},
+	}}
+	if s.Snapshots, err = snapshot.NewStore(5, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range []string{"#!ipxe\nset download-url http://10.1.0.5/v1", "#!ipxe\nset download-url http://10.1.0.5/v2"} {
+		if _, err := s.Snapshots.Record(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, "auto.ipxe", sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, l) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	tests := map[string]struct {
+		run  func(*ctlConfig) func(context.Context, *admin.Client, []string) error
+		args []string
+		want string
+	}{
+		"flush all caches": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.flushCache },
+			want: "flushed: machines\n",
+		},
+		"no machines": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.machines },
+			want: "MAC  LAST EVENT  TIME  DIAGNOSTICS\n",
+		},
+		"boot config": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.bootConfig },
+			args: []string{"00:01:02:03:04:05", "aarch64"},
+			want: "mac:            00:01:02:03:04:05\narch:           EFI ARM64\nallow netboot:  false\nfirmware dhcp:  -\nipxe dhcp:      -\ncustom script:  false\nosie url:       -\ntink server:    -\nkernel params:  -\nnotes:          the machine is not in the backend, the DHCP server is not enabled, the HTTP iPXE script server is not enabled\n",
+		},
+		"snapshot diff": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.snapshotDiff },
+			args: []string{"00:01:02:03:04:05"},
+			want: "--- 1 auto.ipxe\n+++ 2 auto.ipxe\n  #!ipxe\n- set download-url http://10.1.0.5/v1\n+ set download-url http://10.1.0.5/v2\n",
+		},
+		"pin": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.pin },
+			args: []string{"00:01:02:03:04:05", "1"},
+			want: "pinned to 1 (auto.ipxe)\n",
+		},
+		"set faults": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.setFaults },
+			args: []string{"dhcp-drop-percent=25", "script-delay=3s"},
+			want: "dhcp drop percent:  25\nscript delay:       3s\niso corrupt bytes:  0\n",
+		},
+		"set maintenance": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.setMaintenance },
+			args: []string{"192.168.2.0/24", "10.0.0.0/8"},
+			want: "enabled:           false\nsubnets:           192.168.2.0/24, 10.0.0.0/8\nlocal boot:        false\nsettings enabled:  false\nsettings subnets:  -\n",
+		},
+		"self-test": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.selfTest },
+			want: "CHECK  RESULT   DURATION  ERROR\ndhcp   skipped  0s        skipped: -dhcp-enabled=false\n",
+		},
+		"leases": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.leases },
+			want: "MAC                IP            HOSTNAME  STATE     EXPIRES  LAST ACTIVITY\n00:01:02:03:04:05  192.168.2.10  node1     reserved  -        -\n",
+		},
+		"leases csv": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.leases },
+			args: []string{"csv"},
+			want: "mac,ip,hostname,state,expires,last_activity\n00:01:02:03:04:05,192.168.2.10,node1,reserved,,\n",
+		},
+		"leases json": {
+			run:  func(c *ctlConfig) func(context.Context, *admin.Client, []string) error { return c.leases },
+			args: []string{"json"},
+			want: "[\n  {\n    \"mac\": \"00:01:02:03:04:05\",\n    \"ip\": \"192.168.2.10\",\n    \"hostname\": \"node1\",\n    \"state\": \"reserved\"\n  }\n]\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			c := &ctlConfig{addr: addr, timeout: time.Second, out: out}
+			cl, err := c.client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cl.Close()
+			if err := tt.run(c)(context.Background(), cl, tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, out.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestParseFaults(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		want    *admin.Faults
+		wantErr bool
+	}{
+		"none": {want: &admin.Faults{}},
+		"all": {
+			args: []string{"dhcp-drop-percent=10", "script-delay=1m", "iso-corrupt-bytes=64"},
+			want: &admin.Faults{DhcpDropPercent: 10, ScriptDelay: durationpb.New(time.Minute), IsoCorruptBytes: 64},
+		},
+		"not name=value": {args: []string{"dhcp-drop-percent"}, wantErr: true},
+		"unknown fault":  {args: []string{"tftp-drop-percent=10"}, wantErr: true},
+		"invalid delay":  {args: []string{"script-delay=10"}, wantErr: true},
+		"negative bytes": {args: []string{"iso-corrupt-bytes=-1"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseFaults(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, protocmp.Transform()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestParseMaintenance(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		want    *admin.SetMaintenanceRequest
+		wantErr bool
+	}{
+		"on":             {args: []string{"on"}, want: &admin.SetMaintenanceRequest{Enabled: true}},
+		"off":            {args: []string{"off"}, want: &admin.SetMaintenanceRequest{}},
+		"subnets":        {args: []string{"192.168.2.0/24", "fd00::/64"}, want: &admin.SetMaintenanceRequest{Subnets: []string{"192.168.2.0/24", "fd00::/64"}}},
+		"none":           {wantErr: true},
+		"off and subnet": {args: []string{"off", "192.168.2.0/24"}, wantErr: true},
+		"not a CIDR":     {args: []string{"192.168.2.1"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseMaintenance(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMaintenance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, protocmp.Transform()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	tests := map[string]struct {
+		msg  *admin.SyslogMessage
+		want string
+	}{
+		"hostname and app name": {
+			msg:  &admin.SyslogMessage{Host: "192.168.2.10", Hostname: "node1", Severity: "INFO", AppName: "kernel", Msg: "booting"},
+			want: "- node1 INFO kernel: booting",
+		},
+		"ip address only": {
+			msg:  &admin.SyslogMessage{Host: "192.168.2.10", Severity: "ERR", Msg: "failed"},
+			want: "- 192.168.2.10 ERR failed",
+		},
+		"resolved mac address": {
+			msg:  &admin.SyslogMessage{Host: "192.168.2.10", Mac: "00:01:02:03:04:05", Severity: "INFO", Msg: "booting"},
+			want: "- 192.168.2.10 (00:01:02:03:04:05) INFO booting",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, formatSyslog(tt.msg)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestBundle(t *testing.T) {
+	addr := "unix://" + filepath.Join(t.TempDir(), "admin.sock")
+	l, err := admin.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &admin.Server{Log: logr.Discard(), Version: "v1.2.3", StartTime: time.Now(), Events: &admin.Events{}, Config: []string{"admin-token=[REDACTED]"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, l) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	out := &bytes.Buffer{}
+	c := &ctlConfig{addr: addr, timeout: time.Second, out: out}
+	cl, err := c.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	file := filepath.Join(t.TempDir(), "support.tar.gz")
+	if err := c.bundle(context.Background(), cl, []string{file}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	got := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.Name] = string(b)
+	}
+	for _, name := range []string{"status.txt", "machines.txt", "version.txt", "config.txt", "services.txt", "metrics.txt", "goroutines.txt"} {
+		if _, ok := got["support/"+name]; !ok {
+			t.Errorf("expected %s in the bundle", name)
+		}
+	}
+	if want := "admin-token=[REDACTED]\n"; got["support/config.txt"] != want {
+		t.Fatalf("got config %q, want %q", got["support/config.txt"], want)
+	}
+	if !strings.Contains(got["support/status.txt"], "v1.2.3") {
+		t.Fatalf("got status %q, want the version", got["support/status.txt"])
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/export"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// exportConfig is the configuration of the export subcommand.
+type exportConfig struct {
+	format  string
+	timeout time.Duration
+	// out is where the exported configuration is written.
+	out io.Writer
+}
+
+func exportFlags(c *exportConfig, fs *flag.FlagSet) {
+	fs.StringVar(&c.format, "format", "dnsmasq", "[export] format of the exported configuration (dnsmasq, kea)")
+	fs.DurationVar(&c.timeout, "timeout", time.Minute, "[export] timeout for reading the backend data")
+}
+
+// newExportCommand returns the export subcommand, it reads the backend and DHCP settings from the smee flags in cfg.
+func newExportCommand(cfg *config) *ffcli.Command {
+	c := &exportConfig{out: os.Stdout}
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	exportFlags(c, fs)
+	return &ffcli.Command{
+		Name:       "export",
+		ShortUsage: "smee [flags] export [flags]",
+		ShortHelp:  "export the backend data as the configuration of an external DHCP server",
+		LongHelp:   "Export renders the backend data into dnsmasq or Kea configuration, host reservations and boot options pointing at the Smee TFTP (-dhcp-tftp-ip) and iPXE script (-dhcp-http-ipxe-script-url) services.",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix(name + "_EXPORT")},
+		UsageFunc:  customUsageFunc,
+		Exec: func(ctx context.Context, _ []string) error {
+			return c.run(ctx, cfg)
+		},
+	}
+}
+
+func (c *exportConfig) run(ctx context.Context, cfg *config) error {
+	write := map[string]func(io.Writer, export.Config, []data.Record) error{
+		"dnsmasq": export.Dnsmasq,
+		"kea":     export.Kea,
+	}[c.format]
+	if write == nil {
+		return fmt.Errorf("unknown export format %q", c.format)
+	}
+	cfg.advertise()
+	ec, err := cfg.exportConfig()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	// logs go to stderr, stdout is the exported configuration.
+	log := logr.FromSlogHandler(slog.NewJSONHandler(os.Stderr, nil))
+	records, err := cfg.list(ctx, log)
+	if err != nil {
+		return err
+	}
+
+	return write(c.out, ec, records)
+}
+
+// exportConfig returns the Smee services that exported configuration points machines at.
+func (c *config) exportConfig() (export.Config, error) {
+	tftp, err := netip.ParseAddr(c.dhcp.tftpIP)
+	if err != nil {
+		return export.Config{}, fmt.Errorf("invalid tftp address: %w", err)
+	}
+	u, err := c.ipxeScriptURL()
+	if err != nil {
+		return export.Config{}, err
+	}
+
+	return export.Config{TFTPServer: tftp, IPXEScriptURL: u}, nil
+}
+
+// list returns all the records of the backend, it waits for the kubernetes backend to be ready.
+func (c *config) list(ctx context.Context, log logr.Logger) ([]data.Record, error) {
+	br, err := c.backend(ctx, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backend: %w", err)
+	}
+	l, ok := br.(handler.BackendLister)
+	if !ok {
+		return nil, errors.New("only the file and kubernetes backends can be exported")
+	}
+	if r, ok := br.(interface{ Ready() bool }); ok {
+		if err := wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(context.Context) (bool, error) { return r.Ready(), nil }); err != nil {
+			return nil, fmt.Errorf("backend is not ready: %w", err)
+		}
+	}
+
+	return l.List(ctx)
+}
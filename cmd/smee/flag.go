@@ -16,6 +16,16 @@ import (
 
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tinkerbell/smee/internal/cluster"
+	"github.com/tinkerbell/smee/internal/dhcp"
+	"github.com/tinkerbell/smee/internal/ipxe/script"
+	"github.com/tinkerbell/smee/internal/iso"
+	"github.com/tinkerbell/smee/internal/localmac"
+	"github.com/tinkerbell/smee/internal/redact"
+	"github.com/tinkerbell/smee/internal/rollout"
+	"github.com/tinkerbell/smee/internal/shadow"
+	"github.com/tinkerbell/smee/internal/staleness"
+	"github.com/tinkerbell/smee/internal/tlsconfig"
 	"github.com/vishvananda/netlink"
 )
 
@@ -78,6 +88,24 @@ func customUsageFunc(c *ffcli.Command) string {
 	return strings.TrimSpace(b.String()) + "\n"
 }
 
+// effectiveConfig returns a name=value line for every flag of fs, sorted by name, with the values of secrets masked.
+// The values of the -file flags are paths, only the secrets in them are masked.
+func effectiveConfig(fs *flag.FlagSet) []string {
+	var r redact.Redactor
+	var lines []string
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if strings.HasSuffix(f.Name, "-file") {
+			v = r.String(v)
+		} else {
+			v = r.Value(f.Name, v)
+		}
+		lines = append(lines, f.Name+"="+v)
+	})
+
+	return lines
+}
+
 func countFlags(fs *flag.FlagSet) (n int) {
 	fs.VisitAll(func(*flag.Flag) { n++ })
 
@@ -85,56 +113,84 @@ func countFlags(fs *flag.FlagSet) (n int) {
 }
 
 func syslogFlags(c *config, fs *flag.FlagSet) {
-	fs.BoolVar(&c.syslog.enabled, "syslog-enabled", true, "[syslog] enable Syslog server(receiver)")
+	fs.BoolVar(&c.syslog.enabled, "syslog-enabled", true, "[syslog] enable Syslog server(receiver), deprecated, use -services")
 	fs.StringVar(&c.syslog.bindAddr, "syslog-addr", detectPublicIPv4(), "[syslog] local IP to listen on for Syslog messages")
 	fs.IntVar(&c.syslog.bindPort, "syslog-port", 514, "[syslog] local port to listen on for Syslog messages")
 }
 
 func tftpFlags(c *config, fs *flag.FlagSet) {
-	fs.BoolVar(&c.tftp.enabled, "tftp-enabled", true, "[tftp] enable iPXE TFTP binary server)")
+	fs.BoolVar(&c.tftp.enabled, "tftp-enabled", true, "[tftp] enable iPXE TFTP binary server), deprecated, use -services")
 	fs.StringVar(&c.tftp.bindAddr, "tftp-addr", detectPublicIPv4(), "[tftp] local IP to listen on for iPXE TFTP binary requests")
 	fs.IntVar(&c.tftp.bindPort, "tftp-port", 69, "[tftp] local port to listen on for iPXE TFTP binary requests")
 	fs.DurationVar(&c.tftp.timeout, "tftp-timeout", time.Second*5, "[tftp] iPXE TFTP binary server requests timeout")
-	fs.StringVar(&c.tftp.ipxeScriptPatch, "ipxe-script-patch", "", "[tftp/http] iPXE script fragment to patch into served iPXE binaries served via TFTP or HTTP")
+	fs.StringVar(&c.tftp.ipxeScriptPatch, "ipxe-script-patch", "", "[tftp/http] iPXE script fragment to patch into served iPXE binaries served via TFTP or HTTP, it is a template of the addresses of this Smee, like {{ .ScriptURL }}, and must be at most 131 bytes once executed")
+	fs.BoolVar(&c.tftp.ipxeScriptBootstrap, "ipxe-script-bootstrap", false, "[tftp/http] patch a bootstrap script that chains to the iPXE script URL of this Smee into served iPXE binaries, so that machines whose firmware mishandles the DHCP boot file name still boot, ignored when ipxe-script-patch is set")
+	fs.IntVar(&c.tftp.maxSessions, "tftp-max-sessions", 0, "[tftp] maximum number of concurrent TFTP sessions, new sessions over the limit are rejected and retried by the client, 0 is unlimited")
+	fs.StringVar(&c.tftp.binaryDir, "ipxe-binary-dir", "", "[tftp/http] directory of additional iPXE binaries, for example snp-riscv64.efi, served via TFTP and HTTP in front of the embedded ones")
 	fs.IntVar(&c.tftp.blockSize, "tftp-block-size", 512, "[tftp] TFTP block size a value between 512 (the default block size for TFTP) and 65456 (the max size a UDP packet payload can be)")
 }
 
 func ipxeHTTPBinaryFlags(c *config, fs *flag.FlagSet) {
-	fs.BoolVar(&c.ipxeHTTPBinary.enabled, "http-ipxe-binary-enabled", true, "[http] enable iPXE HTTP binary server")
+	fs.BoolVar(&c.ipxeHTTPBinary.enabled, "http-ipxe-binary-enabled", true, "[http] enable iPXE HTTP binary server, deprecated, use -services")
 }
 
 func ipxeHTTPScriptFlags(c *config, fs *flag.FlagSet) {
-	fs.BoolVar(&c.ipxeHTTPScript.enabled, "http-ipxe-script-enabled", true, "[http] enable iPXE HTTP script server")
+	fs.BoolVar(&c.ipxeHTTPScript.enabled, "http-ipxe-script-enabled", true, "[http] enable iPXE HTTP script server, deprecated, use -services")
 	fs.StringVar(&c.ipxeHTTPScript.bindAddr, "http-addr", detectPublicIPv4(), "[http] local IP to listen on for iPXE HTTP script requests")
 	fs.IntVar(&c.ipxeHTTPScript.bindPort, "http-port", 8080, "[http] local port to listen on for iPXE HTTP script requests")
 	fs.StringVar(&c.ipxeHTTPScript.extraKernelArgs, "extra-kernel-args", "", "[http] extra set of kernel args (k=v k=v) that are appended to the kernel cmdline iPXE script")
+	fs.IntVar(&c.ipxeHTTPScript.maxConnections, "http-max-connections", 0, "[http] maximum number of open HTTP connections, connections over the limit wait to be accepted, 0 is unlimited")
 	fs.StringVar(&c.ipxeHTTPScript.trustedProxies, "trusted-proxies", "", "[http] comma separated list of trusted proxies in CIDR notation")
 	fs.StringVar(&c.ipxeHTTPScript.hookURL, "osie-url", "", "[http] URL where OSIE (HookOS) images are located")
+	fs.StringVar(&c.ipxeHTTPScript.osieDir, "osie-dir", "", "[http] directory of OSIE (HookOS) versions, like v0.10.0/vmlinuz-x86_64, that are served at /osie/<version>/<file> with SHA-256 checksum sidecars, the latest version is the highest one, osie-url can point at http://<smee>/osie/<version>")
 	fs.StringVar(&c.ipxeHTTPScript.tinkServer, "tink-server", "", "[http] IP:Port for the Tink server")
 	fs.BoolVar(&c.ipxeHTTPScript.tinkServerUseTLS, "tink-server-tls", false, "[http] use TLS for Tink server")
 	fs.BoolVar(&c.ipxeHTTPScript.tinkServerInsecureTLS, "tink-server-insecure-tls", false, "[http] use insecure TLS for Tink server")
 	fs.IntVar(&c.ipxeHTTPScript.retries, "ipxe-script-retries", 0, "[http] number of retries to attempt when fetching kernel and initrd files in the iPXE script")
 	fs.IntVar(&c.ipxeHTTPScript.retryDelay, "ipxe-script-retry-delay", 2, "[http] delay (in seconds) between retries when fetching kernel and initrd files in the iPXE script")
+	fs.BoolVar(&c.ipxeHTTPScript.twoStage, "ipxe-script-two-stage", false, "[http] serve a first stage auto.ipxe script that chains to the hook.ipxe script with the system UUID, serial number, hostname and architecture of the machine")
+	fs.BoolVar(&c.ipxeHTTPScript.consoleDetect, "ipxe-script-console-detect", false, "[http] serve a first stage auto.ipxe script that detects the console of the machine from its platform, architecture and manufacturer, for the machines without a console in the backend, see docs/Console.md")
+	fs.StringVar(&c.ipxeHTTPScript.consoleRulesFile, "ipxe-script-console-rules-file", "", "[http] path to a YAML file of the console rules of ipxe-script-console-detect, in place of the built-in rules")
+	fs.StringVar(&c.ipxeHTTPScript.clientIdentifiers, "ipxe-script-client-identifiers", "url,query,ip", "[http] comma separated, ordered list of the strategies that identify the machine of an iPXE script request (url, query, xff, lease, ip)")
+	fs.BoolVar(&c.ipxeHTTPScript.validate, "ipxe-script-validate", false, "[http] check the auto.ipxe and hook.ipxe scripts against the syntax rules of iPXE and ipxe-script-max-size before they are served, an invalid script is not served, the fallback script or an HTTP error is")
+	fs.IntVar(&c.ipxeHTTPScript.maxSize, "ipxe-script-max-size", script.DefaultMaxScriptSize, "[http] maximum size in bytes of the iPXE scripts checked by ipxe-script-validate, some firmware truncates larger scripts, 0 is unlimited")
+	fs.StringVar(&c.ipxeHTTPScript.fallbackFile, "ipxe-script-fallback-file", "", "[http] file with the template of the iPXE script that is served, in place of an HTTP error, when the backend lookup or the rendering of the iPXE script of a machine fails")
+	fs.DurationVar(&c.ipxeHTTPScript.tinkHandoffTimeout, "tink-handoff-timeout", 0, "[http] verify that pending Workflows start within this duration of a machine being served a boot script, 0 disables (kubernetes backend only)")
 }
 
 func dhcpFlags(c *config, fs *flag.FlagSet) {
-	fs.BoolVar(&c.dhcp.enabled, "dhcp-enabled", true, "[dhcp] enable DHCP server")
-	fs.StringVar(&c.dhcp.mode, "dhcp-mode", dhcpModeReservation.String(), fmt.Sprintf("[dhcp] DHCP mode (%s, %s, %s)", dhcpModeReservation, dhcpModeProxy, dhcpModeAutoProxy))
+	fs.BoolVar(&c.dhcp.enabled, "dhcp-enabled", true, "[dhcp] enable DHCP server, deprecated, use -services")
+	fs.StringVar(&c.dhcp.mode, "dhcp-mode", dhcpModeReservation.String(), fmt.Sprintf("[dhcp] DHCP mode (%s, %s, %s, %s)", dhcpModeReservation, dhcpModeProxy, dhcpModeAutoProxy, dhcpModeKea))
 	fs.StringVar(&c.dhcp.bindAddr, "dhcp-addr", "0.0.0.0:67", "[dhcp] local IP:Port to listen on for DHCP requests")
 	fs.StringVar(&c.dhcp.bindInterface, "dhcp-iface", "", "[dhcp] interface to bind to for DHCP requests")
-	fs.StringVar(&c.dhcp.ipForPacket, "dhcp-ip-for-packet", detectPublicIPv4(), "[dhcp] IP address to use in DHCP packets (opt 54, etc)")
-	fs.StringVar(&c.dhcp.syslogIP, "dhcp-syslog-ip", detectPublicIPv4(), "[dhcp] Syslog server IP address to use in DHCP packets (opt 7)")
-	fs.StringVar(&c.dhcp.tftpIP, "dhcp-tftp-ip", detectPublicIPv4(), "[dhcp] TFTP server IP address to use in DHCP packets (opt 66, etc)")
+	fs.StringVar(&c.dhcp.ipForPacket, "dhcp-ip-for-packet", "", "[dhcp] IP address to use in DHCP packets (opt 54, etc), defaults to the advertised-ip")
+	fs.StringVar(&c.dhcp.serverID, "dhcp-server-id", "", "[dhcp] IPv4 address to use as the server identifier (opt 54) of DHCP replies, independent of dhcp-ip-for-packet, DHCP requests for another server identifier are ignored (reservation dhcp mode only), defaults to dhcp-ip-for-packet, in proxy modes to the next server")
+	fs.StringVar(&c.dhcp.syslogIP, "dhcp-syslog-ip", "", "[dhcp] Syslog server IP address to use in DHCP packets (opt 7), defaults to the advertised-ip")
+	fs.StringVar(&c.dhcp.tftpIP, "dhcp-tftp-ip", "", "[dhcp] TFTP server IP address to use in DHCP packets (opt 66, etc), defaults to the advertised-ip")
 	fs.IntVar(&c.dhcp.tftpPort, "dhcp-tftp-port", 69, "[dhcp] TFTP server port to use in DHCP packets (opt 66, etc)")
 	fs.StringVar(&c.dhcp.httpIpxeBinaryURL.Scheme, "dhcp-http-ipxe-binary-scheme", "http", "[dhcp] HTTP iPXE binaries scheme to use in DHCP packets")
-	fs.StringVar(&c.dhcp.httpIpxeBinaryURL.Host, "dhcp-http-ipxe-binary-host", detectPublicIPv4(), "[dhcp] HTTP iPXE binaries host or IP to use in DHCP packets")
+	fs.StringVar(&c.dhcp.httpIpxeBinaryURL.Host, "dhcp-http-ipxe-binary-host", "", "[dhcp] HTTP iPXE binaries host or IP to use in DHCP packets, defaults to the advertised-ip")
 	fs.IntVar(&c.dhcp.httpIpxeBinaryURL.Port, "dhcp-http-ipxe-binary-port", 8080, "[dhcp] HTTP iPXE binaries port to use in DHCP packets")
 	fs.StringVar(&c.dhcp.httpIpxeBinaryURL.Path, "dhcp-http-ipxe-binary-path", "/ipxe/", "[dhcp] HTTP iPXE binaries path to use in DHCP packets")
 	fs.StringVar(&c.dhcp.httpIpxeScript.Scheme, "dhcp-http-ipxe-script-scheme", "http", "[dhcp] HTTP iPXE script scheme to use in DHCP packets")
-	fs.StringVar(&c.dhcp.httpIpxeScript.Host, "dhcp-http-ipxe-script-host", detectPublicIPv4(), "[dhcp] HTTP iPXE script host or IP to use in DHCP packets")
+	fs.StringVar(&c.dhcp.httpIpxeScript.Host, "dhcp-http-ipxe-script-host", "", "[dhcp] HTTP iPXE script host or IP to use in DHCP packets, defaults to the advertised-ip")
 	fs.IntVar(&c.dhcp.httpIpxeScript.Port, "dhcp-http-ipxe-script-port", 8080, "[dhcp] HTTP iPXE script port to use in DHCP packets")
 	fs.StringVar(&c.dhcp.httpIpxeScript.Path, "dhcp-http-ipxe-script-path", "/auto.ipxe", "[dhcp] HTTP iPXE script path to use in DHCP packets")
 	fs.StringVar(&c.dhcp.httpIpxeScriptURL, "dhcp-http-ipxe-script-url", "", "[dhcp] HTTP iPXE script URL to use in DHCP packets, this overrides the flags for dhcp-http-ipxe-script-{scheme, host, port, path}")
+	fs.IntVar(&c.dhcp.workers, "dhcp-workers", 0, "[dhcp] number of DHCP packets handled concurrently, 0 handles every packet in its own goroutine")
+	fs.IntVar(&c.dhcp.queueSize, "dhcp-queue-size", 1000, "[dhcp] number of received DHCP packets that can wait for a free worker, packets received while the queue is full are dropped, only used when dhcp-workers is greater than 0")
+	fs.BoolVar(&c.dhcp.dryRun, "dhcp-dry-run", false, "[dhcp] handle DHCP messages without sending any replies, can be toggled at runtime with the admin api")
+	fs.StringVar(&c.dhcp.ipxeBinaries, "dhcp-ipxe-binaries", "", "[dhcp] comma separated arch=binary overrides of the iPXE binary served per client architecture, arch is x86, x86_64, arm32, arm64, riscv64 or an option 93 number, for example arm64=snp-arm64.efi")
+	fs.StringVar(&c.dhcp.pxeMenuFile, "dhcp-pxe-menu-file", "", "[dhcp] path to a YAML file of a PXE boot menu, it is sent in the vendor options (opt 43) of DHCP replies so that the firmware of PXE clients shows its items before iPXE is loaded")
+	fs.StringVar(&c.dhcp.replyMode, "dhcp-reply-mode", string(dhcp.ReplyModeAuto), fmt.Sprintf("[dhcp] how replies to clients that are not behind a relay agent are addressed (%s, %s, %s, %s), replies to clients behind a relay agent are always sent to the relay agent", dhcp.ReplyModeAuto, dhcp.ReplyModeRFC2131, dhcp.ReplyModeBroadcast, dhcp.ReplyModeUnicast))
+	fs.StringVar(&c.dhcp.replyBroadcastFlag, "dhcp-reply-broadcast-flag", string(dhcp.BroadcastFlagKeep), fmt.Sprintf("[dhcp] override the broadcast flag of replies (%s, %s, %s), keep uses the broadcast flag of the client message", dhcp.BroadcastFlagKeep, dhcp.BroadcastFlagSet, dhcp.BroadcastFlagClear))
+	fs.StringVar(&c.dhcp.localMACAction, "dhcp-local-mac-action", string(localmac.Allow), "[dhcp] what is done with locally administered, often randomized, MAC addresses: allow treats them like any other, ignore drops their DHCP messages silently, deny logs and drops them, map looks the machine up by the MAC address of option 61 or the UUID of option 97, see docs/Local-MAC-Addresses.md")
+	fs.BoolVar(&c.dhcp.windowsCompat, "dhcp-windows-compat", false, "[dhcp] tune the ProxyDHCP replies for a Microsoft DHCP server and answer PXE boot server discovery on port 4011, proxy and auto-proxy modes only, see docs/Windows-DHCP.md")
+	fs.StringVar(&c.dhcp.vlanFile, "dhcp-vlan-file", "", "[dhcp] path to a YAML file of VLANs to also serve DHCP on, each on its subinterface, that is created when it doesn't exist, and with the address of Smee on the VLAN in its replies, see docs/VLAN.md")
+	fs.BoolVar(&c.dhcp.raw, "dhcp-raw-enabled", false, "[dhcp] serve DHCP on a raw (AF_PACKET) socket of dhcp-iface, replies are sent as ethernet frames from dhcp-raw-src-mac and dhcp-raw-src-ip, requires CAP_NET_RAW, see docs/Raw-Sockets.md")
+	fs.StringVar(&c.dhcp.rawSrcMAC, "dhcp-raw-src-mac", "", "[dhcp] source MAC address of the DHCP replies sent on the raw socket, defaults to the MAC address of dhcp-iface")
+	fs.StringVar(&c.dhcp.rawSrcIP, "dhcp-raw-src-ip", "", "[dhcp] source IP address of the DHCP replies sent on the raw socket, defaults to dhcp-ip-for-packet")
+	fs.DurationVar(&c.dhcp.transactionTTL, "dhcp-transaction-ttl", dhcp.DefaultTransactionTTL, "[dhcp] duration the reply to a DHCP transaction is reused for the retransmissions of its client message, in place of a backend lookup and a fresh reply, 0 disables it")
 	fs.BoolVar(&c.dhcp.httpIpxeScript.injectMacAddress, "dhcp-http-ipxe-script-prepend-mac", true, "[dhcp] prepend the hardware MAC address to iPXE script URL base, http://1.2.3.4/auto.ipxe -> http://1.2.3.4/40:15:ff:89:cc:0e/auto.ipxe")
 }
 
@@ -145,23 +201,283 @@ func backendFlags(c *config, fs *flag.FlagSet) {
 	fs.StringVar(&c.backends.kubernetes.ConfigFilePath, "backend-kube-config", "", "[backend] the Kubernetes config file location, kube backend only")
 	fs.StringVar(&c.backends.kubernetes.APIURL, "backend-kube-api", "", "[backend] the Kubernetes API URL, used for in-cluster client construction, kube backend only")
 	fs.StringVar(&c.backends.kubernetes.Namespace, "backend-kube-namespace", "", "[backend] an optional Kubernetes namespace override to query hardware data from, kube backend only")
+	fs.Func("backend-kube-additional-configs", "[backend] comma separated list of Kubernetes config file locations of additional clusters to read hardware data from, kube backend only", func(s string) error {
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				c.backends.kubernetes.AdditionalConfigFilePaths = append(c.backends.kubernetes.AdditionalConfigFilePaths, p)
+			}
+		}
+		return nil
+	})
+	fs.BoolVar(&c.backends.kubernetes.Namespaced, "backend-kube-namespaced", false, "[backend] only get, list and watch hardware in a single namespace, backend-kube-namespace or the in-cluster namespace, no cluster-scoped RBAC is required, kube backend only")
+	fs.BoolVar(&c.backends.kubernetes.EnrollDiscovered, "backend-kube-enroll-discovered", false, "[backend] create a minimal, unenrolled, Hardware object for unknown machines that fetch the static iPXE script, auto-proxy mode only, kube backend only")
+	fs.DurationVar(&c.backends.kubernetes.ValidateInterval, "backend-kube-validate-interval", time.Minute, "[backend] how often the hardware cache is validated with a list from the Kubernetes API, the records are as old as the last validation, see backend-max-age, kube backend only")
+	fs.DurationVar(&c.staleness.maxAge, "backend-max-age", 0, "[backend] maximum time since the backend last validated a record with its source, like the Kubernetes API, older records are stale and backend-stale-action applies, 0 disables it, kube backend only, see docs/Backend-Staleness.md")
+	fs.StringVar(&c.staleness.action, "backend-stale-action", string(staleness.Continue), "[backend] what is done with stale records: continue serves them, refuse fails their lookups, fallback serves them with netboot disabled")
 	fs.BoolVar(&c.backends.Noop.Enabled, "backend-noop-enabled", false, "[backend] enable the noop backend for DHCP and the HTTP iPXE script")
+	fs.StringVar(&c.backends.Noop.TemplateFile, "backend-noop-template-file", "", "[backend] path to a YAML file with the netboot data of every machine, like the iPXE script URL, OSIE URL, console and labels, no machine is netbooted when neither it nor backend-noop-ipxe-script-file is set, noop backend only")
+	fs.StringVar(&c.backends.Noop.IPXEScriptFile, "backend-noop-ipxe-script-file", "", "[backend] path to an iPXE script that every machine is served in place of the auto.ipxe script, takes precedence over the script of backend-noop-template-file, noop backend only")
+	fs.BoolVar(&c.backends.plugin.Enabled, "backend-plugin-enabled", false, "[backend] enable the plugin backend for DHCP and the HTTP iPXE script")
+	fs.StringVar(&c.backends.plugin.Path, "backend-plugin-path", "", "[backend] path to the executable of a Smee plugin that serves a backend, plugin backend only")
+	fs.BoolVar(&c.backends.replay.Enabled, "backend-replay-enabled", false, "[backend] enable the replay backend, that serves the lookups recorded with backend-record-file, see docs/Backend-Replay.md")
+	fs.StringVar(&c.backends.replay.FilePath, "backend-replay-file", "", "[backend] path to the recording that the replay backend serves, replay backend only")
+	fs.StringVar(&c.backends.recordFile, "backend-record-file", "", "[backend] path to a JSON lines file that the lookups of the DHCP and HTTP handlers and their responses are appended to, with their secrets masked, see docs/Backend-Replay.md")
 }
 
 func otelFlags(c *config, fs *flag.FlagSet) {
 	fs.StringVar(&c.otel.endpoint, "otel-endpoint", "", "[otel] OpenTelemetry collector endpoint")
 	fs.BoolVar(&c.otel.insecure, "otel-insecure", true, "[otel] OpenTelemetry collector insecure")
+	fs.BoolVar(&c.otel.bootTrace, "otel-boot-trace", false, "[otel] start a trace for every DHCP message, that is continued by the iPXE script, GRUB config and ISO and passed to Hook in the traceparent kernel arg")
+	fs.StringVar(&c.otel.resourceAttributes, "otel-resource-attributes", "", "[otel] comma separated key=value resource attributes of the traces and logs, like deployment.environment=prod,k8s.cluster.name=lab")
+	fs.BoolVar(&c.otel.logs, "otel-logs", false, "[otel] export the logs to the OpenTelemetry collector of otel-endpoint with the OTLP logs exporter, in addition to stdout")
+	fs.IntVar(&c.otel.logBatchSize, "otel-logs-batch-size", 512, "[otel] maximum number of log records in an export")
+	fs.DurationVar(&c.otel.logExportInterval, "otel-logs-export-interval", time.Second, "[otel] how long log records are batched before they are exported")
 }
 
 func isoFlags(c *config, fs *flag.FlagSet) {
-	fs.BoolVar(&c.iso.enabled, "iso-enabled", false, "[iso] enable patching an OSIE ISO")
+	fs.BoolVar(&c.iso.enabled, "iso-enabled", false, "[iso] enable patching an OSIE ISO, deprecated, use -services")
 	fs.StringVar(&c.iso.url, "iso-url", "", "[iso] an ISO source URL target for patching")
+	fs.StringVar(&c.iso.indexURL, "iso-index-url", "", "[iso] URL of a JSON index of ISO releases, for example of HookOS, the source ISO is resolved from it in place of iso-url")
+	fs.StringVar(&c.iso.indexVersion, "iso-index-version", "", "[iso] version of the release in iso-index-url to use as the source ISO, takes precedence over iso-index-channel")
+	fs.StringVar(&c.iso.indexChannel, "iso-index-channel", iso.LatestChannel, "[iso] channel of the release in iso-index-url to use as the source ISO, latest is the first release of the index when none lists it")
+	fs.DurationVar(&c.iso.indexInterval, "iso-index-interval", iso.DefaultIndexInterval, "[iso] how often iso-index-url is resolved, machines keep the release they were first served until they stop requesting the ISO")
 	fs.StringVar(&c.iso.magicString, "iso-magic-string", "", "[iso] the string pattern to match for in the source ISO, defaults to the one defined in HookOS")
 	fs.BoolVar(&c.iso.staticIPAMEnabled, "iso-static-ipam-enabled", false, "[iso] enable static IPAM for HookOS")
+	fs.StringVar(&c.iso.signingKeyFile, "iso-url-signing-key-file", "", "[iso] path to a file with the key, at least 32 bytes, used to sign expiring, MAC bound, ISO URLs, when set only signed URLs are served, the signed URL is set as the iso-url variable in auto.ipxe")
+	fs.DurationVar(&c.iso.signedURLTTL, "iso-url-ttl", time.Hour, "[iso] how long a signed ISO URL is valid for")
+	fs.IntVar(&c.iso.maxStreams, "iso-max-streams", 0, "[iso] maximum number of ISOs streamed concurrently, requests over the limit are queued fairly per machine, 0 is unlimited")
+	fs.DurationVar(&c.iso.streamWait, "iso-stream-wait", 30*time.Second, "[iso] how long a request waits in the ISO stream queue before a 503 Service Unavailable is returned")
+	fs.IntVar(&c.iso.upstreamParallelism, "iso-upstream-parallelism", 1, "[iso] number of concurrent sub-range fetches of the source ISO for client range requests larger than iso-upstream-chunk-size, 1 proxies range requests as is")
+	fs.Int64Var(&c.iso.upstreamChunkSize, "iso-upstream-chunk-size", iso.DefaultChunkSize, "[iso] size in bytes of the sub-range fetches of the source ISO, each parallel fetch buffers up to this many bytes")
+	fs.BoolVar(&c.iso.verifyUpstream, "iso-verify-upstream", false, "[iso] require a strong ETag and a Content-Length from the source ISO and don't serve inconsistent responses, the ETag of the patched ISO is derived from them so downloads can resume on any replica")
+	fs.StringVar(&c.iso.replicaURLs, "iso-replica-urls", "", "[iso] comma separated list of the base URLs of all the replicas of Smee, ISO requests are redirected to the replica the MAC address hashes to, every replica must have the same list")
+	fs.StringVar(&c.iso.replicaSelf, "iso-replica-self", "", "[iso] the base URL of this replica in iso-replica-urls")
+	fs.StringVar(&c.iso.buildKernel, "iso-build-kernel", "", "[iso] path to the kernel of an OSIE without an ISO, Smee builds a UEFI bootable ISO from it in place of patching a source ISO, see docs/ISO-Build.md")
+	fs.StringVar(&c.iso.buildInitrd, "iso-build-initrd", "", "[iso] path to the initrd of the built ISO")
+	fs.StringVar(&c.iso.buildLoader, "iso-build-loader", "", "[iso] path to the EFI boot loader of the built ISO, it must read Boot Loader Specification entries, like systemd-bootx64.efi")
+	fs.StringVar(&c.iso.buildCmdline, "iso-build-cmdline", "", "[iso] template of the kernel cmdline of the built ISO, executed per machine, the kernel args of HookOS when empty")
+	fs.IntVar(&c.iso.bufferSize, "iso-buffer-size", iso.DefaultBufferSize, "[iso] size in bytes of the pooled buffers used to stream the patched ISO to clients")
+}
+
+func settingsFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.settings.kubeConfigMap, "settings-kube-configmap", "", "[settings] name of a Kubernetes ConfigMap, in the backend-kube-namespace, to live-reload settings (osie-url, extra-kernel-args, allowed-macs, maintenance, maintenance-subnets) from")
+}
+
+func bmcFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.bmc.enabled, "bmc-enabled", false, "[bmc] enable the /bmc/<mac>/netboot HTTP endpoint that uses Rufio to set the next boot device to PXE and power cycle a machine, kube backend only, deprecated, use -services")
+	fs.StringVar(&c.bmc.allowedCIDRs, "bmc-allowed-cidrs", "127.0.0.1/32,::1/128", "[bmc] comma separated list of client CIDRs allowed to use the bmc HTTP endpoint")
+}
+
+func metadataFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.metadata.enabled, "metadata-enabled", false, "[metadata] enable the EC2 compatible /2009-04-04/ instance metadata HTTP endpoint, machines are identified by their source IP, and the cloud-init NoCloud seed at /nocloud/, with a network-config of the machine, deprecated, use -services")
+	fs.StringVar(&c.metadata.vendorDataFile, "metadata-vendor-data-file", "", "[metadata] path to a template of the NoCloud vendor-data, executed per machine")
+}
+
+func bootstrapFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.bootstrap.dir, "bootstrap-dir", "", "[bootstrap] directory of templates of cluster bootstrap documents, like a Talos machine config or a k3s config.yaml, each file is served per machine at /bootstrap/<file name>, machines are identified by their source IP, see docs/Cluster-Bootstrap.md")
+	fs.StringVar(&c.bootstrap.secretsDir, "bootstrap-secrets-dir", "", "[bootstrap] directory of secret files, like a mounted Kubernetes Secret, that the bootstrap templates read with {{ secret \"<file name>\" }}")
+}
+
+func inventoryFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.inventory.enabled, "inventory-enabled", false, "[inventory] enable the /inventory/<mac> HTTP endpoint where in-band agents, like HookOS, POST the hardware facts of a machine, deprecated, use -services")
+	fs.StringVar(&c.inventory.factsDir, "inventory-facts-dir", "", "[inventory] directory to write a JSON facts file per machine to, when empty the facts are written to an annotation of the Hardware object (kube backend only)")
+	fs.StringVar(&c.inventory.allowedCIDRs, "inventory-allowed-cidrs", "", "[inventory] comma separated list of client CIDRs allowed to submit facts, required with -inventory-enabled")
+}
+
+func phoneHomeFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.phoneHome.enabled, "phone-home-enabled", false, "[phone-home] enable the /phone-home/<token> HTTP endpoint that HookOS, or the installed OS, POSTs to at the end of provisioning, the URL is passed to Hook in the phone_home_url kernel arg, deprecated, use -services")
+	fs.StringVar(&c.phoneHome.keyFile, "phone-home-key-file", "", "[phone-home] path to a file with the key, at least 32 bytes, used to sign the MAC bound phone home tokens")
+	fs.DurationVar(&c.phoneHome.tokenTTL, "phone-home-token-ttl", 24*time.Hour, "[phone-home] how long a phone home token is valid for, it must outlast provisioning")
+	fs.BoolVar(&c.phoneHome.disableNetboot, "phone-home-disable-netboot", false, "[phone-home] set allowPXE to false on the Hardware of machines that phone home, so they boot from disk from then on, kube backend only")
+	fs.IntVar(&c.phoneHome.bootLogEntries, "phone-home-boot-log-entries", 0, "[phone-home] number of the last boot events and syslog messages of each machine that are kept and served to the machine on /bootlog?mac=<mac>&token=<phone home token>, 0 disables the boot log")
+}
+
+func writebackFlags(c *config, fs *flag.FlagSet) {
+	fs.IntVar(&c.writeback.netbootDisableAfter, "netboot-disable-after", 0, "[writeback] set allowPXE to false on the Hardware of a machine once it has been served its boot script, or has started an ISO mount, this many times, a phone home resets the count, 0 is never, kube backend only")
+}
+
+func selfTestFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.selfTest.mac, "self-test-mac", "", "[self-test] MAC address of a canary machine record, when set Smee checks itself end to end as that machine on start up (a DHCP transaction through the handler, its auto.ipxe and a range of the ISO) and is not ready until the checks pass")
+	fs.DurationVar(&c.selfTest.interval, "self-test-interval", 0, "[self-test] how often to run the self-test again after it passed, 0 is only on start up")
+	fs.BoolVar(&c.selfTest.firmware, "self-test-firmware", false, "[self-test] also check the DHCP replies to the requests of the synthetic firmware fixtures (Dell, HPE, Supermicro, OVMF, U-Boot) as the canary machine, see docs/Firmware-Fixtures.md")
+}
+
+func dnsFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.dns.enabled, "dns-enabled", false, "[dns] create external-dns DNSEndpoint records for the hostname of machines served a DHCP reservation, reservation mode only, kube backend only, deprecated, use -services")
+	fs.StringVar(&c.dns.domain, "dns-domain", "", "[dns] domain appended to hostnames that are not fully qualified")
+}
+
+func mdnsFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.mdns.enabled, "mdns-enabled", false, "[mdns] advertise the http endpoints (_http._tcp) and the admin api over TCP (_smee-admin._tcp) with mDNS/DNS-SD on the provisioning network, see docs/mDNS.md, deprecated, use -services")
+	fs.StringVar(&c.mdns.iface, "mdns-iface", "", "[mdns] interface to advertise the services on, the interface of the default multicast route when empty")
+	fs.StringVar(&c.mdns.instance, "mdns-instance", "", "[mdns] instance name of the advertised services, the hostname when empty")
+}
+
+func policyFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.policy.file, "policy-file", "", "[policy] path to a YAML netboot policy file, its rules decide whether machines can netboot across DHCP, iPXE script and ISO requests")
+}
+
+func facilityFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.facility.file, "facility-file", "", "[facility] path to a YAML file of per facility overrides of the OSIE URL, Tink server, syslog IP and extra kernel args, machines get the overrides of the facility in their backend record")
+}
+
+func tenantFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.tenant.file, "tenant-file", "", "[tenant] path to a YAML file of tenants, requests are mapped to a tenant by their DHCP relay, subnet or URL prefix, and only see the machines with the smee.tinkerbell.org/tenant label of their tenant, which boot with the OSIE URL and Tink server of the tenant")
+}
+
+func rolloutFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.rollout.file, "rollout-file", "", "[rollout] path to a YAML file of weighted OSIE URL tracks, machines are assigned to a track by their MAC address or the smee.tinkerbell.org/osie-track label in their backend record")
+	fs.IntVar(&c.rollout.maxChanges, "rollout-max-changes", 0, "[rollout] maximum number of machines per -rollout-window that boot a changed boot configuration, the others are served the configuration that they last booted with, 0 disables the limit")
+	fs.DurationVar(&c.rollout.window, "rollout-window", rollout.DefaultWindow, "[rollout] duration that the machines booting a changed boot configuration are counted over for -rollout-max-changes")
+	fs.StringVar(&c.rollout.stateFile, "rollout-state-file", "", "[rollout] path to a file that the boot configuration that each machine last booted with is saved to, so that -rollout-max-changes holds changes across restarts")
+}
+
+func clusterFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.cluster.peers, "cluster-peers", "", "[cluster] comma separated host:port addresses of the Smee replicas to share the DHCP leases, the addresses that machines use and the cache flushes with over a gossip protocol, for replicas without a shared kubernetes backend, the cluster is disabled when empty")
+	fs.StringVar(&c.cluster.bindAddr, "cluster-bind-addr", "0.0.0.0", "[cluster] local IP to listen on for the gossip of the other replicas")
+	fs.IntVar(&c.cluster.bindPort, "cluster-bind-port", cluster.DefaultPort, "[cluster] local TCP and UDP port to listen on for the gossip of the other replicas")
+	fs.StringVar(&c.cluster.advertiseAddr, "cluster-advertise-addr", "", "[cluster] IP that the other replicas reach this replica on, detected from the interfaces when empty")
+	fs.StringVar(&c.cluster.nodeName, "cluster-node-name", "", "[cluster] unique name of this replica in the cluster, the hostname when empty")
+	fs.StringVar(&c.cluster.keyFile, "cluster-key-file", "", "[cluster] path to a file with a 16, 24 or 32 byte key that the gossip is encrypted with, the same on every replica")
+}
+
+func snapshotFlags(c *config, fs *flag.FlagSet) {
+	fs.IntVar(&c.snapshot.count, "snapshot-count", 0, "[snapshot] number of the last auto.ipxe, hook.ipxe and grub.cfg scripts served to each machine that are kept, to diff and pin them with the admin api, 0 disables the snapshots")
+	fs.StringVar(&c.snapshot.dir, "snapshot-dir", "", "[snapshot] path to a directory that the snapshots are saved to, so that they, and the pins, survive restarts")
+}
+
+func profileFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.profile.file, "profile-file", "", "[profile] path to a YAML file of boot profiles, clients are matched by the user class (DHCP option 77) or vendor class (DHCP option 60) of their DHCP messages, or by the profile name of their backend record, and get the iPXE binary, iPXE script URL, OSIE URL and kernel args of their profile")
+}
+
+func campaignFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.campaign.file, "campaign-file", "", "[campaign] path to a YAML file of reprovisioning campaigns, the machines of a campaign, by MAC address or by the labels of their Hardware, get their netboot enabled and their boot profile set and are power cycled into a netboot with Rufio from its start time, a few at a time, requires bmc-enabled, see docs/Campaigns.md")
+	fs.StringVar(&c.campaign.stateFile, "campaign-state-file", "", "[campaign] path to a file that the progress of the machines of the campaigns is saved to, so that a restart doesn't reprovision them again")
+}
+
+func ouiFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.oui.file, "oui-file", "", "[oui] path to a YAML file of default boot settings by the vendor prefix (OUI) of MAC addresses, machines without a backend record get the iPXE script URL, OSIE URL and kernel args of their rule (auto-proxy dhcp mode only)")
+}
+
+func mirrorFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.mirror.file, "mirror-file", "", "[mirror] path to a YAML file of groups of health checked boot server candidates, an OSIE URL, Tink server or iPXE script URL that is a candidate of a group is answered with a healthy candidate of the group by priority and weight, see docs/Mirrors.md")
+}
+
+func templateFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.template.envAllowlist, "template-env-allowlist", "", "[template] comma separated list of the environment variables that the env function of the extra kernel args and fallback iPXE script templates can read")
+}
+
+func shadowFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.shadow.dhcpAddr, "shadow-dhcp-addr", "", "[shadow] IP:Port of the DHCP server of a shadow Smee, DHCPDISCOVER and DHCPREQUEST messages are mirrored to it and its replies are compared and logged, never sent (reservation dhcp mode only)")
+	fs.StringVar(&c.shadow.httpURL, "shadow-http-url", "", "[shadow] base URL of the HTTP server of a shadow Smee, iPXE script requests are mirrored to it and its responses are compared and logged, never sent")
+	fs.StringVar(&c.shadow.ignore, "shadow-ignore", "", "[shadow] comma separated list of regular expressions, their matches are removed from the responses of Smee and the shadow before they are compared")
+	fs.DurationVar(&c.shadow.timeout, "shadow-timeout", shadow.DefaultTimeout, "[shadow] how long to wait for a response of the shadow Smee")
+}
+
+func chaosFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.chaos.enabled, "chaos-enabled", false, "[chaos] allow faults (dropped DHCP replies, delayed iPXE scripts, corrupted ISO bytes) to be injected with the admin api, to test the resilience of provisioning, no faults are injected until they are set")
+}
+
+func superviseFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.supervise.restart, "supervise-restart", false, "[supervise] restart a failed service (dhcp, tftp, http, syslog, admin) with exponential backoff while the other services keep running, Smee stops when a service fails otherwise")
+	fs.DurationVar(&c.supervise.initialInterval, "supervise-initial-interval", time.Second, "[supervise] delay before the first restart of a failed service, it doubles on every consecutive failure")
+	fs.DurationVar(&c.supervise.maxInterval, "supervise-max-interval", time.Minute, "[supervise] maximum delay between the restarts of a failed service, a service that has run for this long is no longer considered failing")
+	fs.IntVar(&c.supervise.maxRestarts, "supervise-max-restarts", 0, "[supervise] number of consecutive restarts of a failed service after which Smee stops, 0 is unlimited")
+}
+
+func secureBootFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.secureBoot.enabled, "secure-boot-enabled", false, "[secure boot] point UEFI clients at shim instead of iPXE, serve shim, GRUB and MOK manager binaries over TFTP and HTTP and generate per machine GRUB configs")
+	fs.StringVar(&c.secureBoot.dir, "secure-boot-dir", "", "[secure boot] directory holding the signed shimx64.efi, grubx64.efi and mmx64.efi binaries (shimaa64.efi, grubaa64.efi and mmaa64.efi for arm64)")
+}
+
+func esxiFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.esxi.dir, "esxi-dir", "", "[esxi] directory holding the files of a VMware ESXi installer ISO, mboot.efi, boot.cfg and the modules, UEFI clients of a boot profile with esxi: true are sent mboot.efi and a boot.cfg per machine that loads the modules over HTTP, see docs/ESXi.md")
+}
+
+func ubootFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.uboot.enabled, "uboot-enabled", false, "[u-boot] point U-Boot DHCP clients at a boot script per machine, served over TFTP and from /uboot/ over HTTP, the default boot script chain loads iPXE, see docs/U-Boot.md, deprecated, use -services")
+	fs.StringVar(&c.uboot.scriptFile, "uboot-script-file", "", "[u-boot] path to a template of the U-Boot boot script, executed per machine")
+	fs.StringVar(&c.uboot.extlinuxFile, "uboot-extlinux-file", "", "[u-boot] path to a template of the extlinux.conf style PXE config that pxe get loads from pxelinux.cfg/01-<mac>, executed per machine")
+}
+
+func maintenanceFlags(c *config, fs *flag.FlagSet) {
+	fs.BoolVar(&c.maintenance.enabled, "maintenance-enabled", false, "[maintenance] start in maintenance, no machine is netbooted until the maintenance is ended with the admin API, see docs/Maintenance-Mode.md")
+	fs.StringVar(&c.maintenance.subnets, "maintenance-subnets", "", "[maintenance] comma separated list of subnet CIDRs whose machines start in maintenance")
+	fs.BoolVar(&c.maintenance.localBoot, "maintenance-local-boot", false, "[maintenance] answer the DHCP requests of machines in maintenance without netboot options, so that they boot from their local disks, they are not answered by default")
+}
+
+func sloFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.slo.thresholds, "slo-thresholds", "", "[slo] comma separated list of <stage>=<duration> service level objectives of the boot stages of machines (offer, script, iso or boot), for example offer=2s,script=30s,iso=10m, every stage is counted as met, late or timeout, see docs/Boot-SLOs.md")
+	fs.StringVar(&c.slo.webhookURL, "slo-webhook-url", "", "[slo] URL that the boot stages that miss their objective are POSTed to as JSON")
+}
+
+func quotaFlags(c *config, fs *flag.FlagSet) {
+	fs.IntVar(&c.quota.netboots, "quota-netboots", 0, "[quota] number of boot scripts a machine is served within quota-window before it is switched to local boot, to catch machines stuck in boot loops, 0 disables it, see docs/Netboot-Quota.md")
+	fs.DurationVar(&c.quota.window, "quota-window", 24*time.Hour, "[quota] window of time of quota-netboots, a machine is netbooted again once its oldest netboot leaves it")
+}
+
+func httpsFlags(c *config, fs *flag.FlagSet) {
+	fs.IntVar(&c.https.port, "https-port", 0, "[https] port of an HTTPS server on the http-addr that serves the same handlers as the HTTP server, 0 disables it, set -dhcp-http-ipxe-script-scheme https and -dhcp-http-ipxe-script-port to send its script URLs, see docs/HTTPS.md")
+	fs.StringVar(&c.https.certFile, "https-cert-file", "", "[https] PEM file of the certificate chain of the HTTPS server")
+	fs.StringVar(&c.https.keyFile, "https-key-file", "", "[https] PEM file of the private key of the HTTPS server")
+	fs.StringVar(&c.https.ipxeCAFile, "https-ipxe-ca-file", "", "[https] PEM file of a CA certificate that the served iPXE binaries are patched to trust in place of the iPXE root CA, the HTTPS server certificate must be issued by it")
+}
+
+func pluginFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.plugin.dhcpHandler, "plugin-dhcp-handler", "", "[plugin] path to the executable of a Smee plugin that serves a DHCP handler, it replaces the built-in DHCP handler of the dhcp-mode")
+	fs.StringVar(&c.plugin.scriptGenerator, "plugin-script-generator", "", "[plugin] path to the executable of a Smee plugin that serves a script generator, it generates the auto.ipxe scripts in place of the Hook script")
+}
+
+func adminFlags(c *config, fs *flag.FlagSet) {
+	fs.StringVar(&c.admin.addr, "admin-addr", "", "[admin] address to serve the gRPC admin api on, unix:<path> for a unix socket or host:port for TCP, disabled when empty")
+	fs.StringVar(&c.admin.tokenFile, "admin-token-file", "", "[admin] path to a file with the token that admin api clients must send, required when the admin api is served over TCP")
+}
+
+func tlsFlags(c *config, fs *flag.FlagSet) {
+	tlsConfigFlags(fs, "tls-", "all outbound connections", &c.tls.global)
+	tlsConfigFlags(fs, "tls-iso-", "the source ISO, overrides the global setting", &c.tls.iso)
+	tlsConfigFlags(fs, "tls-otel-", "the OpenTelemetry collector, overrides the global setting", &c.tls.otel)
+}
+
+func tlsConfigFlags(fs *flag.FlagSet, prefix, dest string, tc *tlsconfig.Config) {
+	fs.StringVar(&tc.CAFile, prefix+"ca-file", "", fmt.Sprintf("[tls] PEM encoded CA bundle, trusted in addition to the system CAs, for %s", dest))
+	fs.StringVar(&tc.CertFile, prefix+"cert-file", "", fmt.Sprintf("[tls] PEM encoded client certificate for %s", dest))
+	fs.StringVar(&tc.KeyFile, prefix+"key-file", "", fmt.Sprintf("[tls] PEM encoded client key for %s", dest))
+	fs.StringVar(&tc.MinVersion, prefix+"min-version", "", fmt.Sprintf("[tls] minimum TLS version (1.2, 1.3) for %s, 1.2 when not set", dest))
+	fs.BoolVar(&tc.InsecureSkipVerify, prefix+"insecure-skip-verify", false, fmt.Sprintf("[tls] skip server certificate verification for %s", dest))
+}
+
+func upstreamFlags(c *config, fs *flag.FlagSet) {
+	fs.IntVar(&c.upstream.global.Retries, "upstream-retries", 2, "[upstream] number of retries of failed idempotent outbound HTTP requests, like those of the source ISO, 0 disables retries")
+	fs.DurationVar(&c.upstream.global.Backoff, "upstream-retry-backoff", 250*time.Millisecond, "[upstream] wait before the first retry of an outbound HTTP request, doubled on every retry")
+	fs.DurationVar(&c.upstream.global.MaxBackoff, "upstream-retry-max-backoff", 5*time.Second, "[upstream] maximum wait between two retries of an outbound HTTP request")
+	fs.IntVar(&c.upstream.global.BreakerFailures, "upstream-breaker-failures", 0, "[upstream] number of consecutive failed outbound HTTP requests to a destination that opens its circuit breaker, 0 disables it")
+	fs.DurationVar(&c.upstream.global.BreakerOpen, "upstream-breaker-open", 30*time.Second, "[upstream] how long an open circuit breaker fails the requests to its destination before it lets a trial request through")
+	fs.IntVar(&c.upstream.iso.Retries, "upstream-iso-retries", -1, "[upstream] upstream-retries for the source ISO and the ISO index, the global setting when negative")
+	fs.DurationVar(&c.upstream.iso.Backoff, "upstream-iso-retry-backoff", 0, "[upstream] upstream-retry-backoff for the source ISO and the ISO index, the global setting when 0")
+	fs.DurationVar(&c.upstream.iso.MaxBackoff, "upstream-iso-retry-max-backoff", 0, "[upstream] upstream-retry-max-backoff for the source ISO and the ISO index, the global setting when 0")
+	fs.IntVar(&c.upstream.iso.BreakerFailures, "upstream-iso-breaker-failures", -1, "[upstream] upstream-breaker-failures for the source ISO and the ISO index, the global setting when negative")
+	fs.DurationVar(&c.upstream.iso.BreakerOpen, "upstream-iso-breaker-open", 0, "[upstream] upstream-breaker-open for the source ISO and the ISO index, the global setting when 0")
+	fs.DurationVar(&c.upstream.global.Timeout, "upstream-timeout", 30*time.Second, "[upstream] how long every attempt of an outbound HTTP request waits for the response headers, 0 disables the timeout")
+	fs.DurationVar(&c.upstream.iso.Timeout, "upstream-iso-timeout", 0, "[upstream] upstream-timeout for the source ISO and the ISO index, the global setting when 0")
+}
+
+func timeoutFlags(c *config, fs *flag.FlagSet) {
+	fs.DurationVar(&c.timeout.dhcp, "timeout-dhcp", 5*time.Second, "[timeout] deadline of the handling of a DHCP packet, including its backend lookups, 0 disables it")
+	fs.DurationVar(&c.timeout.http, "timeout-http", 30*time.Second, "[timeout] deadline of the handling of an HTTP request for an iPXE script, metadata, inventory, BMC or phone home, the OSIE and ISO downloads are not bound, 0 disables it")
+	fs.DurationVar(&c.timeout.backend, "timeout-backend", 10*time.Second, "[timeout] deadline of a backend lookup, 0 disables it")
 }
 
 func setFlags(c *config, fs *flag.FlagSet) {
 	fs.StringVar(&c.logLevel, "log-level", "info", "log level (debug, info)")
+	fs.StringVar(&c.serviceList, "services", "", "comma separated services that run, the others don't: "+serviceNames()+", it replaces the deprecated -<service>-enabled flags, the default services of those flags run when unset")
+	fs.BoolVar(&c.logHashMACs, "log-hash-macs", false, "replace MAC addresses in logs with a stable hash, secrets are always masked")
+	fs.StringVar(&c.logHashMACsKeyFile, "log-hash-macs-key-file", "", "path to a file holding the key, at least 32 bytes, of the MAC address hashes of -log-hash-macs, the hashes are only stable for the same key, a random key that changes on every restart is used when unset")
+	fs.BoolVar(&c.advertisedIPStrict, "advertised-ip-strict", false, "fail at start up when an advertised address is not bound to a local interface, is not on the network of dhcp-iface or differs from the address its server listens on, they are logged otherwise, see smee validate")
+	fs.BoolVar(&c.strict, "strict", false, "fail at start up when the configuration has lint warnings, suspicious but legal settings like a loopback advertised ip, see smee validate and docs/Config-Lint.md")
+	fs.StringVar(&c.lintIgnore, "lint-ignore", "", "comma separated codes of the configuration lint warnings that are not reported, like W002")
+	fs.StringVar(&c.advertisedIP, "advertised-ip", "", "IP address that machines use to reach Smee, the default of the IP addresses and hosts used in DHCP packets, detected from the dhcp-iface interface, the SMEE_PUBLIC_IP_INTERFACE interface or the interface of the default route when not set")
+	fs.DurationVar(&c.ipMACTTL, "ip-mac-cache-ttl", time.Hour, "how long the MAC address of a machine, seen using an IP address in its DHCP or iPXE script requests, is remembered to attribute its requests that don't carry it, like iPXE script requests of the lease client identifier and syslog messages, 0 disables the cache")
 	dhcpFlags(c, fs)
 	tftpFlags(c, fs)
 	ipxeHTTPBinaryFlags(c, fs)
@@ -170,6 +486,42 @@ func setFlags(c *config, fs *flag.FlagSet) {
 	backendFlags(c, fs)
 	otelFlags(c, fs)
 	isoFlags(c, fs)
+	settingsFlags(c, fs)
+	bmcFlags(c, fs)
+	dnsFlags(c, fs)
+	mdnsFlags(c, fs)
+	policyFlags(c, fs)
+	facilityFlags(c, fs)
+	tenantFlags(c, fs)
+	rolloutFlags(c, fs)
+	snapshotFlags(c, fs)
+	clusterFlags(c, fs)
+	profileFlags(c, fs)
+	campaignFlags(c, fs)
+	ouiFlags(c, fs)
+	mirrorFlags(c, fs)
+	templateFlags(c, fs)
+	shadowFlags(c, fs)
+	chaosFlags(c, fs)
+	superviseFlags(c, fs)
+	tlsFlags(c, fs)
+	upstreamFlags(c, fs)
+	timeoutFlags(c, fs)
+	secureBootFlags(c, fs)
+	esxiFlags(c, fs)
+	ubootFlags(c, fs)
+	maintenanceFlags(c, fs)
+	sloFlags(c, fs)
+	quotaFlags(c, fs)
+	httpsFlags(c, fs)
+	pluginFlags(c, fs)
+	adminFlags(c, fs)
+	metadataFlags(c, fs)
+	bootstrapFlags(c, fs)
+	inventoryFlags(c, fs)
+	phoneHomeFlags(c, fs)
+	writebackFlags(c, fs)
+	selfTestFlags(c, fs)
 }
 
 func newCLI(cfg *config, fs *flag.FlagSet) *ffcli.Command {
@@ -181,7 +533,40 @@ func newCLI(cfg *config, fs *flag.FlagSet) *ffcli.Command {
 		FlagSet:    fs,
 		Options:    []ff.Option{ff.WithEnvVarPrefix(name)},
 		UsageFunc:  customUsageFunc,
+		Subcommands: []*ffcli.Command{
+			newBenchCommand(),
+			newBindCommand(cfg, fs),
+			newCtlCommand(),
+			newExportCommand(cfg),
+			newValidateCommand(cfg),
+		},
+	}
+}
+
+// advertise sets the IP addresses and hosts used in DHCP packets that are not set to the advertised IP, so that
+// they can't disagree. The advertised IP is advertisedIP, or else the IP of the dhcp-iface interface, or else the
+// detected public IPv4 address. It returns the advertised IP, empty when it can't be detected.
+func (c *config) advertise() string {
+	ip := c.advertisedIP
+	if ip == "" && c.dhcp.bindInterface != "" {
+		ip = ipByInterface(c.dhcp.bindInterface)
+	}
+	if ip == "" {
+		ip = detectPublicIPv4()
 	}
+	for _, s := range []*string{
+		&c.dhcp.ipForPacket,
+		&c.dhcp.syslogIP,
+		&c.dhcp.tftpIP,
+		&c.dhcp.httpIpxeBinaryURL.Host,
+		&c.dhcp.httpIpxeScript.Host,
+	} {
+		if *s == "" {
+			*s = ip
+		}
+	}
+
+	return ip
 }
 
 // ipByInterface returns the first IPv4 address on the named network interface.
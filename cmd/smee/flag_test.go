@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/upstream"
 )
 
 func TestParser(t *testing.T) {
@@ -27,19 +28,26 @@ func TestParser(t *testing.T) {
 			enabled: true,
 		},
 		ipxeHTTPScript: ipxeHTTPScript{
-			enabled:    true,
-			bindAddr:   "192.168.2.4",
-			bindPort:   8080,
-			retryDelay: 2,
+			enabled:           true,
+			bindAddr:          "192.168.2.4",
+			bindPort:          8080,
+			retryDelay:        2,
+			clientIdentifiers: "url,query,ip",
+			maxSize:           65536,
 		},
 		dhcp: dhcpConfig{
-			enabled:     true,
-			mode:        "reservation",
-			bindAddr:    "0.0.0.0:67",
-			ipForPacket: "192.168.2.4",
-			syslogIP:    "192.168.2.4",
-			tftpIP:      "192.168.2.4",
-			tftpPort:    69,
+			enabled:            true,
+			mode:               "reservation",
+			bindAddr:           "0.0.0.0:67",
+			ipForPacket:        "192.168.2.4",
+			syslogIP:           "192.168.2.4",
+			tftpIP:             "192.168.2.4",
+			tftpPort:           69,
+			queueSize:          1000,
+			replyMode:          "auto",
+			replyBroadcastFlag: "keep",
+			transactionTTL:     10 * time.Second,
+			localMACAction:     "allow",
 			httpIpxeBinaryURL: urlBuilder{
 				Scheme: "http",
 				Host:   "192.168.2.4",
@@ -57,17 +65,62 @@ func TestParser(t *testing.T) {
 			},
 		},
 		iso: isoConfig{
-			enabled:     true,
-			url:         "http://10.10.10.10:8787/hook.iso",
-			magicString: magicString,
+			enabled:             true,
+			url:                 "http://10.10.10.10:8787/hook.iso",
+			magicString:         magicString,
+			signedURLTTL:        time.Hour,
+			bufferSize:          32 * 1024,
+			streamWait:          30 * time.Second,
+			upstreamParallelism: 1,
+			upstreamChunkSize:   4 * 1024 * 1024,
+			indexChannel:        "latest",
+			indexInterval:       time.Hour,
 		},
 		logLevel: "info",
+		ipMACTTL: time.Hour,
 		backends: dhcpBackends{
 			file:       File{},
-			kubernetes: Kube{Enabled: true},
+			kubernetes: Kube{Enabled: true, ValidateInterval: time.Minute},
+		},
+		staleness: stalenessConfig{
+			action: "continue",
+		},
+		quota: quotaConfig{
+			window: 24 * time.Hour,
 		},
 		otel: otelConfig{
-			insecure: true,
+			insecure:          true,
+			logBatchSize:      512,
+			logExportInterval: time.Second,
+		},
+		bmc: bmcConfig{
+			allowedCIDRs: "127.0.0.1/32,::1/128",
+		},
+		phoneHome: phoneHomeConfig{
+			tokenTTL: 24 * time.Hour,
+		},
+		shadow: shadowConfig{
+			timeout: 5 * time.Second,
+		},
+		rollout: rolloutConfig{
+			window: time.Hour,
+		},
+		cluster: clusterConfig{
+			bindAddr: "0.0.0.0",
+			bindPort: 7946,
+		},
+		supervise: superviseConfig{
+			initialInterval: time.Second,
+			maxInterval:     time.Minute,
+		},
+		upstream: upstreamConfig{
+			global: upstream.Policy{Retries: 2, Backoff: 250 * time.Millisecond, MaxBackoff: 5 * time.Second, BreakerOpen: 30 * time.Second, Timeout: 30 * time.Second},
+			iso:    upstream.Policy{Retries: -1, BreakerFailures: -1},
+		},
+		timeout: timeoutConfig{
+			dhcp:    5 * time.Second,
+			http:    30 * time.Second,
+			backend: 10 * time.Second,
 		},
 	}
 	got := config{}
@@ -99,6 +152,43 @@ func TestParser(t *testing.T) {
 		cmp.AllowUnexported(httpIpxeScript{}),
 		cmp.AllowUnexported(isoConfig{}),
 		cmp.AllowUnexported(otelConfig{}),
+		cmp.AllowUnexported(settingsConfig{}),
+		cmp.AllowUnexported(bmcConfig{}),
+		cmp.AllowUnexported(dnsConfig{}),
+		cmp.AllowUnexported(policyConfig{}),
+		cmp.AllowUnexported(facilityConfig{}),
+		cmp.AllowUnexported(tenantConfig{}),
+		cmp.AllowUnexported(rolloutConfig{}),
+		cmp.AllowUnexported(snapshotConfig{}),
+		cmp.AllowUnexported(clusterConfig{}),
+		cmp.AllowUnexported(mdnsConfig{}),
+		cmp.AllowUnexported(campaignConfig{}),
+		cmp.AllowUnexported(mirrorConfig{}),
+		cmp.AllowUnexported(profileConfig{}),
+		cmp.AllowUnexported(ouiConfig{}),
+		cmp.AllowUnexported(templateConfig{}),
+		cmp.AllowUnexported(shadowConfig{}),
+		cmp.AllowUnexported(chaosConfig{}),
+		cmp.AllowUnexported(superviseConfig{}),
+		cmp.AllowUnexported(tlsConfig{}),
+		cmp.AllowUnexported(upstreamConfig{}),
+		cmp.AllowUnexported(timeoutConfig{}),
+		cmp.AllowUnexported(secureBootConfig{}),
+		cmp.AllowUnexported(esxiConfig{}),
+		cmp.AllowUnexported(ubootConfig{}),
+		cmp.AllowUnexported(httpsConfig{}),
+		cmp.AllowUnexported(stalenessConfig{}),
+		cmp.AllowUnexported(maintenanceConfig{}),
+		cmp.AllowUnexported(pluginConfig{}),
+		cmp.AllowUnexported(adminConfig{}),
+		cmp.AllowUnexported(metadataConfig{}),
+		cmp.AllowUnexported(bootstrapConfig{}),
+		cmp.AllowUnexported(sloConfig{}),
+		cmp.AllowUnexported(quotaConfig{}),
+		cmp.AllowUnexported(inventoryConfig{}),
+		cmp.AllowUnexported(phoneHomeConfig{}),
+		cmp.AllowUnexported(writebackConfig{}),
+		cmp.AllowUnexported(selfTestConfig{}),
 		cmp.AllowUnexported(urlBuilder{}),
 	}
 
@@ -114,60 +204,244 @@ func TestCustomUsageFunc(t *testing.T) {
 USAGE
   smee [flags]
 
+SUBCOMMANDS
+  bench     simulate concurrent network booting clients against a running Smee
+  bind      bind the privileged sockets of Smee and run it as an unprivileged user
+  ctl       control a running Smee with its admin api
+  export    export the backend data as the configuration of an external DHCP server
+  validate  check the service configuration and that the addresses advertised to machines are served by this host
+
 FLAGS
+  -advertised-ip                      IP address that machines use to reach Smee, the default of the IP addresses and hosts used in DHCP packets, detected from the dhcp-iface interface, the SMEE_PUBLIC_IP_INTERFACE interface or the interface of the default route when not set
+  -advertised-ip-strict               fail at start up when an advertised address is not bound to a local interface, is not on the network of dhcp-iface or differs from the address its server listens on, they are logged otherwise, see smee validate (default "false")
+  -ip-mac-cache-ttl                   how long the MAC address of a machine, seen using an IP address in its DHCP or iPXE script requests, is remembered to attribute its requests that don't carry it, like iPXE script requests of the lease client identifier and syslog messages, 0 disables the cache (default "1h0m0s")
+  -lint-ignore                        comma separated codes of the configuration lint warnings that are not reported, like W002
+  -log-hash-macs                      replace MAC addresses in logs with a stable hash, secrets are always masked (default "false")
+  -log-hash-macs-key-file             path to a file holding the key, at least 32 bytes, of the MAC address hashes of -log-hash-macs, the hashes are only stable for the same key, a random key that changes on every restart is used when unset
   -log-level                          log level (debug, info) (default "info")
+  -services                           comma separated services that run, the others don't: dhcp, tftp, syslog, http-ipxe-binary, http-ipxe-script, iso, metadata, inventory, phone-home, bmc, uboot, mdns, dns, it replaces the deprecated -<service>-enabled flags, the default services of those flags run when unset
+  -strict                             fail at start up when the configuration has lint warnings, suspicious but legal settings like a loopback advertised ip, see smee validate and docs/Config-Lint.md (default "false")
+  -admin-addr                         [admin] address to serve the gRPC admin api on, unix:<path> for a unix socket or host:port for TCP, disabled when empty
+  -admin-token-file                   [admin] path to a file with the token that admin api clients must send, required when the admin api is served over TCP
   -backend-file-enabled               [backend] enable the file backend for DHCP and the HTTP iPXE script (default "false")
   -backend-file-path                  [backend] the hardware yaml file path for the file backend
+  -backend-kube-additional-configs    [backend] comma separated list of Kubernetes config file locations of additional clusters to read hardware data from, kube backend only
   -backend-kube-api                   [backend] the Kubernetes API URL, used for in-cluster client construction, kube backend only
   -backend-kube-config                [backend] the Kubernetes config file location, kube backend only
   -backend-kube-enabled               [backend] enable the kubernetes backend for DHCP and the HTTP iPXE script (default "true")
+  -backend-kube-enroll-discovered     [backend] create a minimal, unenrolled, Hardware object for unknown machines that fetch the static iPXE script, auto-proxy mode only, kube backend only (default "false")
   -backend-kube-namespace             [backend] an optional Kubernetes namespace override to query hardware data from, kube backend only
+  -backend-kube-namespaced            [backend] only get, list and watch hardware in a single namespace, backend-kube-namespace or the in-cluster namespace, no cluster-scoped RBAC is required, kube backend only (default "false")
+  -backend-kube-validate-interval     [backend] how often the hardware cache is validated with a list from the Kubernetes API, the records are as old as the last validation, see backend-max-age, kube backend only (default "1m0s")
+  -backend-max-age                    [backend] maximum time since the backend last validated a record with its source, like the Kubernetes API, older records are stale and backend-stale-action applies, 0 disables it, kube backend only, see docs/Backend-Staleness.md (default "0s")
   -backend-noop-enabled               [backend] enable the noop backend for DHCP and the HTTP iPXE script (default "false")
+  -backend-noop-ipxe-script-file      [backend] path to an iPXE script that every machine is served in place of the auto.ipxe script, takes precedence over the script of backend-noop-template-file, noop backend only
+  -backend-noop-template-file         [backend] path to a YAML file with the netboot data of every machine, like the iPXE script URL, OSIE URL, console and labels, no machine is netbooted when neither it nor backend-noop-ipxe-script-file is set, noop backend only
+  -backend-plugin-enabled             [backend] enable the plugin backend for DHCP and the HTTP iPXE script (default "false")
+  -backend-plugin-path                [backend] path to the executable of a Smee plugin that serves a backend, plugin backend only
+  -backend-record-file                [backend] path to a JSON lines file that the lookups of the DHCP and HTTP handlers and their responses are appended to, with their secrets masked, see docs/Backend-Replay.md
+  -backend-replay-enabled             [backend] enable the replay backend, that serves the lookups recorded with backend-record-file, see docs/Backend-Replay.md (default "false")
+  -backend-replay-file                [backend] path to the recording that the replay backend serves, replay backend only
+  -backend-stale-action               [backend] what is done with stale records: continue serves them, refuse fails their lookups, fallback serves them with netboot disabled (default "continue")
+  -bmc-allowed-cidrs                  [bmc] comma separated list of client CIDRs allowed to use the bmc HTTP endpoint (default "127.0.0.1/32,::1/128")
+  -bmc-enabled                        [bmc] enable the /bmc/<mac>/netboot HTTP endpoint that uses Rufio to set the next boot device to PXE and power cycle a machine, kube backend only, deprecated, use -services (default "false")
+  -bootstrap-dir                      [bootstrap] directory of templates of cluster bootstrap documents, like a Talos machine config or a k3s config.yaml, each file is served per machine at /bootstrap/<file name>, machines are identified by their source IP, see docs/Cluster-Bootstrap.md
+  -bootstrap-secrets-dir              [bootstrap] directory of secret files, like a mounted Kubernetes Secret, that the bootstrap templates read with {{ secret "<file name>" }}
+  -campaign-file                      [campaign] path to a YAML file of reprovisioning campaigns, the machines of a campaign, by MAC address or by the labels of their Hardware, get their netboot enabled and their boot profile set and are power cycled into a netboot with Rufio from its start time, a few at a time, requires bmc-enabled, see docs/Campaigns.md
+  -campaign-state-file                [campaign] path to a file that the progress of the machines of the campaigns is saved to, so that a restart doesn't reprovision them again
+  -chaos-enabled                      [chaos] allow faults (dropped DHCP replies, delayed iPXE scripts, corrupted ISO bytes) to be injected with the admin api, to test the resilience of provisioning, no faults are injected until they are set (default "false")
+  -cluster-advertise-addr             [cluster] IP that the other replicas reach this replica on, detected from the interfaces when empty
+  -cluster-bind-addr                  [cluster] local IP to listen on for the gossip of the other replicas (default "0.0.0.0")
+  -cluster-bind-port                  [cluster] local TCP and UDP port to listen on for the gossip of the other replicas (default "7946")
+  -cluster-key-file                   [cluster] path to a file with a 16, 24 or 32 byte key that the gossip is encrypted with, the same on every replica
+  -cluster-node-name                  [cluster] unique name of this replica in the cluster, the hostname when empty
+  -cluster-peers                      [cluster] comma separated host:port addresses of the Smee replicas to share the DHCP leases, the addresses that machines use and the cache flushes with over a gossip protocol, for replicas without a shared kubernetes backend, the cluster is disabled when empty
   -dhcp-addr                          [dhcp] local IP:Port to listen on for DHCP requests (default "0.0.0.0:67")
-  -dhcp-enabled                       [dhcp] enable DHCP server (default "true")
-  -dhcp-http-ipxe-binary-host         [dhcp] HTTP iPXE binaries host or IP to use in DHCP packets (default "%[1]v")
+  -dhcp-dry-run                       [dhcp] handle DHCP messages without sending any replies, can be toggled at runtime with the admin api (default "false")
+  -dhcp-enabled                       [dhcp] enable DHCP server, deprecated, use -services (default "true")
+  -dhcp-http-ipxe-binary-host         [dhcp] HTTP iPXE binaries host or IP to use in DHCP packets, defaults to the advertised-ip
   -dhcp-http-ipxe-binary-path         [dhcp] HTTP iPXE binaries path to use in DHCP packets (default "/ipxe/")
   -dhcp-http-ipxe-binary-port         [dhcp] HTTP iPXE binaries port to use in DHCP packets (default "8080")
   -dhcp-http-ipxe-binary-scheme       [dhcp] HTTP iPXE binaries scheme to use in DHCP packets (default "http")
-  -dhcp-http-ipxe-script-host         [dhcp] HTTP iPXE script host or IP to use in DHCP packets (default "%[1]v")
+  -dhcp-http-ipxe-script-host         [dhcp] HTTP iPXE script host or IP to use in DHCP packets, defaults to the advertised-ip
   -dhcp-http-ipxe-script-path         [dhcp] HTTP iPXE script path to use in DHCP packets (default "/auto.ipxe")
   -dhcp-http-ipxe-script-port         [dhcp] HTTP iPXE script port to use in DHCP packets (default "8080")
   -dhcp-http-ipxe-script-prepend-mac  [dhcp] prepend the hardware MAC address to iPXE script URL base, http://1.2.3.4/auto.ipxe -> http://1.2.3.4/40:15:ff:89:cc:0e/auto.ipxe (default "true")
   -dhcp-http-ipxe-script-scheme       [dhcp] HTTP iPXE script scheme to use in DHCP packets (default "http")
   -dhcp-http-ipxe-script-url          [dhcp] HTTP iPXE script URL to use in DHCP packets, this overrides the flags for dhcp-http-ipxe-script-{scheme, host, port, path}
   -dhcp-iface                         [dhcp] interface to bind to for DHCP requests
-  -dhcp-ip-for-packet                 [dhcp] IP address to use in DHCP packets (opt 54, etc) (default "%[1]v")
-  -dhcp-mode                          [dhcp] DHCP mode (reservation, proxy, auto-proxy) (default "reservation")
-  -dhcp-syslog-ip                     [dhcp] Syslog server IP address to use in DHCP packets (opt 7) (default "%[1]v")
-  -dhcp-tftp-ip                       [dhcp] TFTP server IP address to use in DHCP packets (opt 66, etc) (default "%[1]v")
+  -dhcp-ip-for-packet                 [dhcp] IP address to use in DHCP packets (opt 54, etc), defaults to the advertised-ip
+  -dhcp-ipxe-binaries                 [dhcp] comma separated arch=binary overrides of the iPXE binary served per client architecture, arch is x86, x86_64, arm32, arm64, riscv64 or an option 93 number, for example arm64=snp-arm64.efi
+  -dhcp-local-mac-action              [dhcp] what is done with locally administered, often randomized, MAC addresses: allow treats them like any other, ignore drops their DHCP messages silently, deny logs and drops them, map looks the machine up by the MAC address of option 61 or the UUID of option 97, see docs/Local-MAC-Addresses.md (default "allow")
+  -dhcp-mode                          [dhcp] DHCP mode (reservation, proxy, auto-proxy, kea) (default "reservation")
+  -dhcp-pxe-menu-file                 [dhcp] path to a YAML file of a PXE boot menu, it is sent in the vendor options (opt 43) of DHCP replies so that the firmware of PXE clients shows its items before iPXE is loaded
+  -dhcp-queue-size                    [dhcp] number of received DHCP packets that can wait for a free worker, packets received while the queue is full are dropped, only used when dhcp-workers is greater than 0 (default "1000")
+  -dhcp-raw-enabled                   [dhcp] serve DHCP on a raw (AF_PACKET) socket of dhcp-iface, replies are sent as ethernet frames from dhcp-raw-src-mac and dhcp-raw-src-ip, requires CAP_NET_RAW, see docs/Raw-Sockets.md (default "false")
+  -dhcp-raw-src-ip                    [dhcp] source IP address of the DHCP replies sent on the raw socket, defaults to dhcp-ip-for-packet
+  -dhcp-raw-src-mac                   [dhcp] source MAC address of the DHCP replies sent on the raw socket, defaults to the MAC address of dhcp-iface
+  -dhcp-reply-broadcast-flag          [dhcp] override the broadcast flag of replies (keep, set, clear), keep uses the broadcast flag of the client message (default "keep")
+  -dhcp-reply-mode                    [dhcp] how replies to clients that are not behind a relay agent are addressed (auto, rfc2131, broadcast, unicast), replies to clients behind a relay agent are always sent to the relay agent (default "auto")
+  -dhcp-server-id                     [dhcp] IPv4 address to use as the server identifier (opt 54) of DHCP replies, independent of dhcp-ip-for-packet, DHCP requests for another server identifier are ignored (reservation dhcp mode only), defaults to dhcp-ip-for-packet, in proxy modes to the next server
+  -dhcp-syslog-ip                     [dhcp] Syslog server IP address to use in DHCP packets (opt 7), defaults to the advertised-ip
+  -dhcp-tftp-ip                       [dhcp] TFTP server IP address to use in DHCP packets (opt 66, etc), defaults to the advertised-ip
   -dhcp-tftp-port                     [dhcp] TFTP server port to use in DHCP packets (opt 66, etc) (default "69")
+  -dhcp-transaction-ttl               [dhcp] duration the reply to a DHCP transaction is reused for the retransmissions of its client message, in place of a backend lookup and a fresh reply, 0 disables it (default "10s")
+  -dhcp-vlan-file                     [dhcp] path to a YAML file of VLANs to also serve DHCP on, each on its subinterface, that is created when it doesn't exist, and with the address of Smee on the VLAN in its replies, see docs/VLAN.md
+  -dhcp-windows-compat                [dhcp] tune the ProxyDHCP replies for a Microsoft DHCP server and answer PXE boot server discovery on port 4011, proxy and auto-proxy modes only, see docs/Windows-DHCP.md (default "false")
+  -dhcp-workers                       [dhcp] number of DHCP packets handled concurrently, 0 handles every packet in its own goroutine (default "0")
+  -dns-domain                         [dns] domain appended to hostnames that are not fully qualified
+  -dns-enabled                        [dns] create external-dns DNSEndpoint records for the hostname of machines served a DHCP reservation, reservation mode only, kube backend only, deprecated, use -services (default "false")
+  -esxi-dir                           [esxi] directory holding the files of a VMware ESXi installer ISO, mboot.efi, boot.cfg and the modules, UEFI clients of a boot profile with esxi: true are sent mboot.efi and a boot.cfg per machine that loads the modules over HTTP, see docs/ESXi.md
+  -facility-file                      [facility] path to a YAML file of per facility overrides of the OSIE URL, Tink server, syslog IP and extra kernel args, machines get the overrides of the facility in their backend record
   -extra-kernel-args                  [http] extra set of kernel args (k=v k=v) that are appended to the kernel cmdline iPXE script
   -http-addr                          [http] local IP to listen on for iPXE HTTP script requests (default "%[1]v")
-  -http-ipxe-binary-enabled           [http] enable iPXE HTTP binary server (default "true")
-  -http-ipxe-script-enabled           [http] enable iPXE HTTP script server (default "true")
+  -http-ipxe-binary-enabled           [http] enable iPXE HTTP binary server, deprecated, use -services (default "true")
+  -http-ipxe-script-enabled           [http] enable iPXE HTTP script server, deprecated, use -services (default "true")
+  -http-max-connections               [http] maximum number of open HTTP connections, connections over the limit wait to be accepted, 0 is unlimited (default "0")
   -http-port                          [http] local port to listen on for iPXE HTTP script requests (default "8080")
+  -ipxe-script-client-identifiers     [http] comma separated, ordered list of the strategies that identify the machine of an iPXE script request (url, query, xff, lease, ip) (default "url,query,ip")
+  -ipxe-script-console-detect         [http] serve a first stage auto.ipxe script that detects the console of the machine from its platform, architecture and manufacturer, for the machines without a console in the backend, see docs/Console.md (default "false")
+  -ipxe-script-console-rules-file     [http] path to a YAML file of the console rules of ipxe-script-console-detect, in place of the built-in rules
+  -ipxe-script-fallback-file          [http] file with the template of the iPXE script that is served, in place of an HTTP error, when the backend lookup or the rendering of the iPXE script of a machine fails
+  -ipxe-script-max-size               [http] maximum size in bytes of the iPXE scripts checked by ipxe-script-validate, some firmware truncates larger scripts, 0 is unlimited (default "65536")
   -ipxe-script-retries                [http] number of retries to attempt when fetching kernel and initrd files in the iPXE script (default "0")
   -ipxe-script-retry-delay            [http] delay (in seconds) between retries when fetching kernel and initrd files in the iPXE script (default "2")
+  -ipxe-script-two-stage              [http] serve a first stage auto.ipxe script that chains to the hook.ipxe script with the system UUID, serial number, hostname and architecture of the machine (default "false")
+  -ipxe-script-validate               [http] check the auto.ipxe and hook.ipxe scripts against the syntax rules of iPXE and ipxe-script-max-size before they are served, an invalid script is not served, the fallback script or an HTTP error is (default "false")
+  -osie-dir                           [http] directory of OSIE (HookOS) versions, like v0.10.0/vmlinuz-x86_64, that are served at /osie/<version>/<file> with SHA-256 checksum sidecars, the latest version is the highest one, osie-url can point at http://<smee>/osie/<version>
   -osie-url                           [http] URL where OSIE (HookOS) images are located
+  -tink-handoff-timeout               [http] verify that pending Workflows start within this duration of a machine being served a boot script, 0 disables (kubernetes backend only) (default "0s")
   -tink-server                        [http] IP:Port for the Tink server
   -tink-server-insecure-tls           [http] use insecure TLS for Tink server (default "false")
   -tink-server-tls                    [http] use TLS for Tink server (default "false")
   -trusted-proxies                    [http] comma separated list of trusted proxies in CIDR notation
-  -iso-enabled                        [iso] enable patching an OSIE ISO (default "false")
+  -https-cert-file                    [https] PEM file of the certificate chain of the HTTPS server
+  -https-ipxe-ca-file                 [https] PEM file of a CA certificate that the served iPXE binaries are patched to trust in place of the iPXE root CA, the HTTPS server certificate must be issued by it
+  -https-key-file                     [https] PEM file of the private key of the HTTPS server
+  -https-port                         [https] port of an HTTPS server on the http-addr that serves the same handlers as the HTTP server, 0 disables it, set -dhcp-http-ipxe-script-scheme https and -dhcp-http-ipxe-script-port to send its script URLs, see docs/HTTPS.md (default "0")
+  -inventory-allowed-cidrs            [inventory] comma separated list of client CIDRs allowed to submit facts, required with -inventory-enabled
+  -inventory-enabled                  [inventory] enable the /inventory/<mac> HTTP endpoint where in-band agents, like HookOS, POST the hardware facts of a machine, deprecated, use -services (default "false")
+  -inventory-facts-dir                [inventory] directory to write a JSON facts file per machine to, when empty the facts are written to an annotation of the Hardware object (kube backend only)
+  -iso-buffer-size                    [iso] size in bytes of the pooled buffers used to stream the patched ISO to clients (default "32768")
+  -iso-build-cmdline                  [iso] template of the kernel cmdline of the built ISO, executed per machine, the kernel args of HookOS when empty
+  -iso-build-initrd                   [iso] path to the initrd of the built ISO
+  -iso-build-kernel                   [iso] path to the kernel of an OSIE without an ISO, Smee builds a UEFI bootable ISO from it in place of patching a source ISO, see docs/ISO-Build.md
+  -iso-build-loader                   [iso] path to the EFI boot loader of the built ISO, it must read Boot Loader Specification entries, like systemd-bootx64.efi
+  -iso-enabled                        [iso] enable patching an OSIE ISO, deprecated, use -services (default "false")
+  -iso-index-channel                  [iso] channel of the release in iso-index-url to use as the source ISO, latest is the first release of the index when none lists it (default "latest")
+  -iso-index-interval                 [iso] how often iso-index-url is resolved, machines keep the release they were first served until they stop requesting the ISO (default "1h0m0s")
+  -iso-index-url                      [iso] URL of a JSON index of ISO releases, for example of HookOS, the source ISO is resolved from it in place of iso-url
+  -iso-index-version                  [iso] version of the release in iso-index-url to use as the source ISO, takes precedence over iso-index-channel
   -iso-magic-string                   [iso] the string pattern to match for in the source ISO, defaults to the one defined in HookOS
+  -iso-max-streams                    [iso] maximum number of ISOs streamed concurrently, requests over the limit are queued fairly per machine, 0 is unlimited (default "0")
+  -iso-replica-self                   [iso] the base URL of this replica in iso-replica-urls
+  -iso-replica-urls                   [iso] comma separated list of the base URLs of all the replicas of Smee, ISO requests are redirected to the replica the MAC address hashes to, every replica must have the same list
   -iso-static-ipam-enabled            [iso] enable static IPAM for HookOS (default "false")
+  -iso-stream-wait                    [iso] how long a request waits in the ISO stream queue before a 503 Service Unavailable is returned (default "30s")
+  -iso-upstream-chunk-size            [iso] size in bytes of the sub-range fetches of the source ISO, each parallel fetch buffers up to this many bytes (default "4194304")
+  -iso-upstream-parallelism           [iso] number of concurrent sub-range fetches of the source ISO for client range requests larger than iso-upstream-chunk-size, 1 proxies range requests as is (default "1")
   -iso-url                            [iso] an ISO source URL target for patching
+  -iso-url-signing-key-file           [iso] path to a file with the key, at least 32 bytes, used to sign expiring, MAC bound, ISO URLs, when set only signed URLs are served, the signed URL is set as the iso-url variable in auto.ipxe
+  -iso-url-ttl                        [iso] how long a signed ISO URL is valid for (default "1h0m0s")
+  -iso-verify-upstream                [iso] require a strong ETag and a Content-Length from the source ISO and don't serve inconsistent responses, the ETag of the patched ISO is derived from them so downloads can resume on any replica (default "false")
+  -maintenance-enabled                [maintenance] start in maintenance, no machine is netbooted until the maintenance is ended with the admin API, see docs/Maintenance-Mode.md (default "false")
+  -maintenance-local-boot             [maintenance] answer the DHCP requests of machines in maintenance without netboot options, so that they boot from their local disks, they are not answered by default (default "false")
+  -maintenance-subnets                [maintenance] comma separated list of subnet CIDRs whose machines start in maintenance
+  -mdns-enabled                       [mdns] advertise the http endpoints (_http._tcp) and the admin api over TCP (_smee-admin._tcp) with mDNS/DNS-SD on the provisioning network, see docs/mDNS.md, deprecated, use -services (default "false")
+  -mdns-iface                         [mdns] interface to advertise the services on, the interface of the default multicast route when empty
+  -mdns-instance                      [mdns] instance name of the advertised services, the hostname when empty
+  -metadata-enabled                   [metadata] enable the EC2 compatible /2009-04-04/ instance metadata HTTP endpoint, machines are identified by their source IP, and the cloud-init NoCloud seed at /nocloud/, with a network-config of the machine, deprecated, use -services (default "false")
+  -metadata-vendor-data-file          [metadata] path to a template of the NoCloud vendor-data, executed per machine
+  -mirror-file                        [mirror] path to a YAML file of groups of health checked boot server candidates, an OSIE URL, Tink server or iPXE script URL that is a candidate of a group is answered with a healthy candidate of the group by priority and weight, see docs/Mirrors.md
+  -otel-boot-trace                    [otel] start a trace for every DHCP message, that is continued by the iPXE script, GRUB config and ISO and passed to Hook in the traceparent kernel arg (default "false")
   -otel-endpoint                      [otel] OpenTelemetry collector endpoint
   -otel-insecure                      [otel] OpenTelemetry collector insecure (default "true")
+  -otel-logs                          [otel] export the logs to the OpenTelemetry collector of otel-endpoint with the OTLP logs exporter, in addition to stdout (default "false")
+  -otel-logs-batch-size               [otel] maximum number of log records in an export (default "512")
+  -otel-logs-export-interval          [otel] how long log records are batched before they are exported (default "1s")
+  -otel-resource-attributes           [otel] comma separated key=value resource attributes of the traces and logs, like deployment.environment=prod,k8s.cluster.name=lab
+  -oui-file                           [oui] path to a YAML file of default boot settings by the vendor prefix (OUI) of MAC addresses, machines without a backend record get the iPXE script URL, OSIE URL and kernel args of their rule (auto-proxy dhcp mode only)
+  -phone-home-boot-log-entries        [phone-home] number of the last boot events and syslog messages of each machine that are kept and served to the machine on /bootlog?mac=<mac>&token=<phone home token>, 0 disables the boot log (default "0")
+  -phone-home-disable-netboot         [phone-home] set allowPXE to false on the Hardware of machines that phone home, so they boot from disk from then on, kube backend only (default "false")
+  -phone-home-enabled                 [phone-home] enable the /phone-home/<token> HTTP endpoint that HookOS, or the installed OS, POSTs to at the end of provisioning, the URL is passed to Hook in the phone_home_url kernel arg, deprecated, use -services (default "false")
+  -phone-home-key-file                [phone-home] path to a file with the key, at least 32 bytes, used to sign the MAC bound phone home tokens
+  -phone-home-token-ttl               [phone-home] how long a phone home token is valid for, it must outlast provisioning (default "24h0m0s")
+  -plugin-dhcp-handler                [plugin] path to the executable of a Smee plugin that serves a DHCP handler, it replaces the built-in DHCP handler of the dhcp-mode
+  -plugin-script-generator            [plugin] path to the executable of a Smee plugin that serves a script generator, it generates the auto.ipxe scripts in place of the Hook script
+  -policy-file                        [policy] path to a YAML netboot policy file, its rules decide whether machines can netboot across DHCP, iPXE script and ISO requests
+  -profile-file                       [profile] path to a YAML file of boot profiles, clients are matched by the user class (DHCP option 77) or vendor class (DHCP option 60) of their DHCP messages, or by the profile name of their backend record, and get the iPXE binary, iPXE script URL, OSIE URL and kernel args of their profile
+  -quota-netboots                     [quota] number of boot scripts a machine is served within quota-window before it is switched to local boot, to catch machines stuck in boot loops, 0 disables it, see docs/Netboot-Quota.md (default "0")
+  -quota-window                       [quota] window of time of quota-netboots, a machine is netbooted again once its oldest netboot leaves it (default "24h0m0s")
+  -rollout-file                       [rollout] path to a YAML file of weighted OSIE URL tracks, machines are assigned to a track by their MAC address or the smee.tinkerbell.org/osie-track label in their backend record
+  -rollout-max-changes                [rollout] maximum number of machines per -rollout-window that boot a changed boot configuration, the others are served the configuration that they last booted with, 0 disables the limit (default "0")
+  -rollout-state-file                 [rollout] path to a file that the boot configuration that each machine last booted with is saved to, so that -rollout-max-changes holds changes across restarts
+  -rollout-window                     [rollout] duration that the machines booting a changed boot configuration are counted over for -rollout-max-changes (default "1h0m0s")
+  -secure-boot-dir                    [secure boot] directory holding the signed shimx64.efi, grubx64.efi and mmx64.efi binaries (shimaa64.efi, grubaa64.efi and mmaa64.efi for arm64)
+  -secure-boot-enabled                [secure boot] point UEFI clients at shim instead of iPXE, serve shim, GRUB and MOK manager binaries over TFTP and HTTP and generate per machine GRUB configs (default "false")
+  -self-test-firmware                 [self-test] also check the DHCP replies to the requests of the synthetic firmware fixtures (Dell, HPE, Supermicro, OVMF, U-Boot) as the canary machine, see docs/Firmware-Fixtures.md (default "false")
+  -self-test-interval                 [self-test] how often to run the self-test again after it passed, 0 is only on start up (default "0s")
+  -self-test-mac                      [self-test] MAC address of a canary machine record, when set Smee checks itself end to end as that machine on start up (a DHCP transaction through the handler, its auto.ipxe and a range of the ISO) and is not ready until the checks pass
+  -settings-kube-configmap            [settings] name of a Kubernetes ConfigMap, in the backend-kube-namespace, to live-reload settings (osie-url, extra-kernel-args, allowed-macs, maintenance, maintenance-subnets) from
+  -shadow-dhcp-addr                   [shadow] IP:Port of the DHCP server of a shadow Smee, DHCPDISCOVER and DHCPREQUEST messages are mirrored to it and its replies are compared and logged, never sent (reservation dhcp mode only)
+  -shadow-http-url                    [shadow] base URL of the HTTP server of a shadow Smee, iPXE script requests are mirrored to it and its responses are compared and logged, never sent
+  -shadow-ignore                      [shadow] comma separated list of regular expressions, their matches are removed from the responses of Smee and the shadow before they are compared
+  -shadow-timeout                     [shadow] how long to wait for a response of the shadow Smee (default "5s")
+  -slo-thresholds                     [slo] comma separated list of <stage>=<duration> service level objectives of the boot stages of machines (offer, script, iso or boot), for example offer=2s,script=30s,iso=10m, every stage is counted as met, late or timeout, see docs/Boot-SLOs.md
+  -slo-webhook-url                    [slo] URL that the boot stages that miss their objective are POSTed to as JSON
+  -snapshot-count                     [snapshot] number of the last auto.ipxe, hook.ipxe and grub.cfg scripts served to each machine that are kept, to diff and pin them with the admin api, 0 disables the snapshots (default "0")
+  -snapshot-dir                       [snapshot] path to a directory that the snapshots are saved to, so that they, and the pins, survive restarts
+  -supervise-initial-interval         [supervise] delay before the first restart of a failed service, it doubles on every consecutive failure (default "1s")
+  -supervise-max-interval             [supervise] maximum delay between the restarts of a failed service, a service that has run for this long is no longer considered failing (default "1m0s")
+  -supervise-max-restarts             [supervise] number of consecutive restarts of a failed service after which Smee stops, 0 is unlimited (default "0")
+  -supervise-restart                  [supervise] restart a failed service (dhcp, tftp, http, syslog, admin) with exponential backoff while the other services keep running, Smee stops when a service fails otherwise (default "false")
   -syslog-addr                        [syslog] local IP to listen on for Syslog messages (default "%[1]v")
-  -syslog-enabled                     [syslog] enable Syslog server(receiver) (default "true")
+  -syslog-enabled                     [syslog] enable Syslog server(receiver), deprecated, use -services (default "true")
   -syslog-port                        [syslog] local port to listen on for Syslog messages (default "514")
-  -ipxe-script-patch                  [tftp/http] iPXE script fragment to patch into served iPXE binaries served via TFTP or HTTP
+  -template-env-allowlist             [template] comma separated list of the environment variables that the env function of the extra kernel args and fallback iPXE script templates can read
+  -tenant-file                        [tenant] path to a YAML file of tenants, requests are mapped to a tenant by their DHCP relay, subnet or URL prefix, and only see the machines with the smee.tinkerbell.org/tenant label of their tenant, which boot with the OSIE URL and Tink server of the tenant
+  -ipxe-binary-dir                    [tftp/http] directory of additional iPXE binaries, for example snp-riscv64.efi, served via TFTP and HTTP in front of the embedded ones
+  -ipxe-script-bootstrap              [tftp/http] patch a bootstrap script that chains to the iPXE script URL of this Smee into served iPXE binaries, so that machines whose firmware mishandles the DHCP boot file name still boot, ignored when ipxe-script-patch is set (default "false")
+  -ipxe-script-patch                  [tftp/http] iPXE script fragment to patch into served iPXE binaries served via TFTP or HTTP, it is a template of the addresses of this Smee, like {{ .ScriptURL }}, and must be at most 131 bytes once executed
   -tftp-addr                          [tftp] local IP to listen on for iPXE TFTP binary requests (default "%[1]v")
   -tftp-block-size                    [tftp] TFTP block size a value between 512 (the default block size for TFTP) and 65456 (the max size a UDP packet payload can be) (default "512")
-  -tftp-enabled                       [tftp] enable iPXE TFTP binary server) (default "true")
+  -tftp-enabled                       [tftp] enable iPXE TFTP binary server), deprecated, use -services (default "true")
+  -tftp-max-sessions                  [tftp] maximum number of concurrent TFTP sessions, new sessions over the limit are rejected and retried by the client, 0 is unlimited (default "0")
   -tftp-port                          [tftp] local port to listen on for iPXE TFTP binary requests (default "69")
   -tftp-timeout                       [tftp] iPXE TFTP binary server requests timeout (default "5s")
+  -timeout-backend                    [timeout] deadline of a backend lookup, 0 disables it (default "10s")
+  -timeout-dhcp                       [timeout] deadline of the handling of a DHCP packet, including its backend lookups, 0 disables it (default "5s")
+  -timeout-http                       [timeout] deadline of the handling of an HTTP request for an iPXE script, metadata, inventory, BMC or phone home, the OSIE and ISO downloads are not bound, 0 disables it (default "30s")
+  -tls-ca-file                        [tls] PEM encoded CA bundle, trusted in addition to the system CAs, for all outbound connections
+  -tls-cert-file                      [tls] PEM encoded client certificate for all outbound connections
+  -tls-insecure-skip-verify           [tls] skip server certificate verification for all outbound connections (default "false")
+  -tls-iso-ca-file                    [tls] PEM encoded CA bundle, trusted in addition to the system CAs, for the source ISO, overrides the global setting
+  -tls-iso-cert-file                  [tls] PEM encoded client certificate for the source ISO, overrides the global setting
+  -tls-iso-insecure-skip-verify       [tls] skip server certificate verification for the source ISO, overrides the global setting (default "false")
+  -tls-iso-key-file                   [tls] PEM encoded client key for the source ISO, overrides the global setting
+  -tls-iso-min-version                [tls] minimum TLS version (1.2, 1.3) for the source ISO, overrides the global setting, 1.2 when not set
+  -tls-key-file                       [tls] PEM encoded client key for all outbound connections
+  -tls-min-version                    [tls] minimum TLS version (1.2, 1.3) for all outbound connections, 1.2 when not set
+  -tls-otel-ca-file                   [tls] PEM encoded CA bundle, trusted in addition to the system CAs, for the OpenTelemetry collector, overrides the global setting
+  -tls-otel-cert-file                 [tls] PEM encoded client certificate for the OpenTelemetry collector, overrides the global setting
+  -tls-otel-insecure-skip-verify      [tls] skip server certificate verification for the OpenTelemetry collector, overrides the global setting (default "false")
+  -tls-otel-key-file                  [tls] PEM encoded client key for the OpenTelemetry collector, overrides the global setting
+  -tls-otel-min-version               [tls] minimum TLS version (1.2, 1.3) for the OpenTelemetry collector, overrides the global setting, 1.2 when not set
+  -uboot-enabled                      [u-boot] point U-Boot DHCP clients at a boot script per machine, served over TFTP and from /uboot/ over HTTP, the default boot script chain loads iPXE, see docs/U-Boot.md, deprecated, use -services (default "false")
+  -uboot-extlinux-file                [u-boot] path to a template of the extlinux.conf style PXE config that pxe get loads from pxelinux.cfg/01-<mac>, executed per machine
+  -uboot-script-file                  [u-boot] path to a template of the U-Boot boot script, executed per machine
+  -upstream-breaker-failures          [upstream] number of consecutive failed outbound HTTP requests to a destination that opens its circuit breaker, 0 disables it (default "0")
+  -upstream-breaker-open              [upstream] how long an open circuit breaker fails the requests to its destination before it lets a trial request through (default "30s")
+  -upstream-iso-breaker-failures      [upstream] upstream-breaker-failures for the source ISO and the ISO index, the global setting when negative (default "-1")
+  -upstream-iso-breaker-open          [upstream] upstream-breaker-open for the source ISO and the ISO index, the global setting when 0 (default "0s")
+  -upstream-iso-retries               [upstream] upstream-retries for the source ISO and the ISO index, the global setting when negative (default "-1")
+  -upstream-iso-retry-backoff         [upstream] upstream-retry-backoff for the source ISO and the ISO index, the global setting when 0 (default "0s")
+  -upstream-iso-retry-max-backoff     [upstream] upstream-retry-max-backoff for the source ISO and the ISO index, the global setting when 0 (default "0s")
+  -upstream-iso-timeout               [upstream] upstream-timeout for the source ISO and the ISO index, the global setting when 0 (default "0s")
+  -upstream-retries                   [upstream] number of retries of failed idempotent outbound HTTP requests, like those of the source ISO, 0 disables retries (default "2")
+  -upstream-retry-backoff             [upstream] wait before the first retry of an outbound HTTP request, doubled on every retry (default "250ms")
+  -upstream-retry-max-backoff         [upstream] maximum wait between two retries of an outbound HTTP request (default "5s")
+  -upstream-timeout                   [upstream] how long every attempt of an outbound HTTP request waits for the response headers, 0 disables the timeout (default "30s")
+  -netboot-disable-after              [writeback] set allowPXE to false on the Hardware of a machine once it has been served its boot script, or has started an ISO mount, this many times, a phone home resets the count, 0 is never, kube backend only (default "0")
 `, defaultIP)
 
 	c := &config{}
@@ -178,3 +452,35 @@ FLAGS
 		t.Fatal(diff)
 	}
 }
+
+func TestAdvertise(t *testing.T) {
+	c := &config{advertisedIP: "10.0.0.5", dhcp: dhcpConfig{syslogIP: "10.0.0.6"}}
+	if got := c.advertise(); got != "10.0.0.5" {
+		t.Fatalf("advertise() = %q, want %q", got, "10.0.0.5")
+	}
+	want := dhcpConfig{
+		ipForPacket:       "10.0.0.5",
+		syslogIP:          "10.0.0.6",
+		tftpIP:            "10.0.0.5",
+		httpIpxeBinaryURL: urlBuilder{Host: "10.0.0.5"},
+		httpIpxeScript:    httpIpxeScript{urlBuilder: urlBuilder{Host: "10.0.0.5"}},
+	}
+	if diff := cmp.Diff(want, c.dhcp, cmp.AllowUnexported(dhcpConfig{}, httpIpxeScript{}, urlBuilder{})); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.String("admin-token-file", "/etc/smee/admin-token", "")
+	fs.String("ipxe-extra-kernel-args", "tink_worker_token=abc123 console=ttyS0", "")
+	fs.String("registry-password", "hunter2", "")
+	want := []string{
+		"admin-token-file=/etc/smee/admin-token",
+		"ipxe-extra-kernel-args=tink_worker_token=[REDACTED] console=ttyS0",
+		"registry-password=[REDACTED]",
+	}
+	if diff := cmp.Diff(want, effectiveConfig(fs)); diff != "" {
+		t.Fatal(diff)
+	}
+}
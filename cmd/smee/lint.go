@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// Diagnostic codes of the lint warnings, they are stable and documented in docs/Config-Lint.md.
+const (
+	lintLoopbackAddr    = "W001"
+	lintOSIEURLNotHTTPS = "W002"
+	lintTFTPTimeout     = "W003"
+	lintTrustAllProxies = "W004"
+)
+
+// lintTFTPBlockLatency is the round trip of a TFTP block on a LAN that W003 assumes.
+const lintTFTPBlockLatency = time.Millisecond
+
+// diagnostic is a suspicious but legal configuration.
+type diagnostic struct {
+	code    string
+	message string
+}
+
+func (d diagnostic) String() string {
+	return d.code + ": " + d.message
+}
+
+// lint returns the warnings of suspicious but legal configurations, but those whose code is in -lint-ignore.
+func (c *config) lint() []diagnostic {
+	var ds []diagnostic
+	addrs := []struct{ flag, host string }{
+		{flag: "advertised-ip", host: c.advertisedIP},
+		{flag: "dhcp-ip-for-packet", host: c.dhcp.ipForPacket},
+		{flag: "dhcp-tftp-ip", host: c.dhcp.tftpIP},
+		{flag: "dhcp-syslog-ip", host: c.dhcp.syslogIP},
+		{flag: "dhcp-http-ipxe-binary-host", host: c.dhcp.httpIpxeBinaryURL.Host},
+		{flag: "dhcp-http-ipxe-script-host", host: c.dhcp.httpIpxeScript.Host},
+	}
+	for _, a := range addrs {
+		if ip, err := netip.ParseAddr(a.host); (err == nil && ip.IsLoopback()) || strings.EqualFold(a.host, "localhost") {
+			ds = append(ds, diagnostic{code: lintLoopbackAddr, message: fmt.Sprintf("-%s is %s, a loopback address that machines can't reach, set -advertised-ip", a.flag, a.host)})
+		}
+	}
+	if u, err := url.Parse(c.ipxeHTTPScript.hookURL); err == nil && u.Scheme == "http" {
+		ds = append(ds, diagnostic{code: lintOSIEURLNotHTTPS, message: fmt.Sprintf("-osie-url %s is not HTTPS, the OSIE kernel and initrd are downloaded without transport security", c.ipxeHTTPScript.hookURL)})
+	}
+	if c.tftp.enabled && c.tftp.blockSize > 0 && c.tftp.timeout > 0 {
+		if need := c.tftp.transferTime(); c.tftp.timeout < need {
+			ds = append(ds, diagnostic{code: lintTFTPTimeout, message: fmt.Sprintf("-tftp-timeout %s is lower than the %s that the largest iPXE binary takes to transfer in blocks of -tftp-block-size %d, raise the timeout or the block size", c.tftp.timeout, need, c.tftp.blockSize)})
+		}
+	}
+	for _, s := range strings.Split(c.ipxeHTTPScript.trustedProxies, ",") {
+		if p, err := netip.ParsePrefix(strings.TrimSpace(s)); err == nil && p.Bits() == 0 {
+			ds = append(ds, diagnostic{code: lintTrustAllProxies, message: fmt.Sprintf("-trusted-proxies has %s, every client can set its address with the X-Forwarded-For header", p)})
+		}
+	}
+
+	ignore := strings.Split(c.lintIgnore, ",")
+	return slices.DeleteFunc(ds, func(d diagnostic) bool {
+		return slices.Contains(ignore, d.code)
+	})
+}
+
+// transferTime returns how long the largest embedded iPXE binary takes to transfer over TFTP, one block per round
+// trip of lintTFTPBlockLatency.
+func (t tftp) transferTime() time.Duration {
+	var size int
+	for _, b := range binary.Files {
+		size = max(size, len(b))
+	}
+	blocks := (size + t.blockSize - 1) / t.blockSize
+
+	return time.Duration(blocks) * lintTFTPBlockLatency
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLint(t *testing.T) {
+	tests := map[string]struct {
+		modify func(*config)
+		want   []string
+	}{
+		"no warnings": {modify: func(*config) {}},
+		"loopback advertised ip": {
+			modify: func(c *config) { c.advertisedIP = "127.0.0.1" },
+			want:   []string{"W001: -advertised-ip is 127.0.0.1, a loopback address that machines can't reach, set -advertised-ip"},
+		},
+		"localhost script host": {
+			modify: func(c *config) { c.dhcp.httpIpxeScript.Host = "localhost" },
+			want:   []string{"W001: -dhcp-http-ipxe-script-host is localhost, a loopback address that machines can't reach, set -advertised-ip"},
+		},
+		"osie url not https": {
+			modify: func(c *config) { c.ipxeHTTPScript.hookURL = "http://10.1.0.5/hook" },
+			want:   []string{"W002: -osie-url http://10.1.0.5/hook is not HTTPS, the OSIE kernel and initrd are downloaded without transport security"},
+		},
+		"tftp timeout": {
+			modify: func(c *config) { c.tftp.timeout = time.Second },
+			want:   []string{"W003: -tftp-timeout 1s is lower than the 4.096s that the largest iPXE binary takes to transfer in blocks of -tftp-block-size 512, raise the timeout or the block size"},
+		},
+		"tftp timeout with a large block size": {
+			modify: func(c *config) { c.tftp.timeout, c.tftp.blockSize = 2*time.Second, 1468 },
+		},
+		"trust all proxies": {
+			modify: func(c *config) { c.ipxeHTTPScript.trustedProxies = "10.0.0.0/8, 0.0.0.0/0" },
+			want:   []string{"W004: -trusted-proxies has 0.0.0.0/0, every client can set its address with the X-Forwarded-For header"},
+		},
+		"ignored": {
+			modify: func(c *config) { c.ipxeHTTPScript.hookURL, c.lintIgnore = "http://10.1.0.5/hook", "W001,W002" },
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &config{
+				advertisedIP:   "192.168.2.4",
+				tftp:           tftp{enabled: true, timeout: 5 * time.Second, blockSize: 512},
+				ipxeHTTPScript: ipxeHTTPScript{hookURL: "https://10.1.0.5/hook"},
+			}
+			tt.modify(c)
+			var got []string
+			for _, d := range c.lint() {
+				got = append(got, d.String())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestValidateStrict(t *testing.T) {
+	out := &bytes.Buffer{}
+	v := &validateConfig{out: out, interfaces: func() (interfaceAddrs, error) {
+		return interfaceAddrs{"eth0": {netip.MustParsePrefix("192.168.2.4/24")}}, nil
+	}}
+	c := &config{advertisedIP: "192.168.2.4", ipxeHTTPScript: ipxeHTTPScript{trustedProxies: "::/0"}}
+	if err := v.run(c); err != nil {
+		t.Fatalf("expected a warning not to fail without -strict, got %v", err)
+	}
+	if !strings.Contains(out.String(), "W004: -trusted-proxies has ::/0") {
+		t.Fatalf("expected the warning, got %s", out.String())
+	}
+	c.strict = true
+	if err := v.run(c); err == nil {
+		t.Fatal("expected a warning to fail with -strict")
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/netip"
@@ -13,26 +16,87 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	ptftp "github.com/pin/tftp/v3"
 	"github.com/tinkerbell/ipxedust"
+	"github.com/tinkerbell/ipxedust/binary"
 	"github.com/tinkerbell/ipxedust/ihttp"
+	"github.com/tinkerbell/ipxedust/itftp"
+	"github.com/tinkerbell/smee/internal/admin"
+	"github.com/tinkerbell/smee/internal/backend/replay"
+	"github.com/tinkerbell/smee/internal/bmc"
+	"github.com/tinkerbell/smee/internal/bootlog"
+	"github.com/tinkerbell/smee/internal/bootstrap"
+	"github.com/tinkerbell/smee/internal/campaign"
+	"github.com/tinkerbell/smee/internal/chaos"
+	"github.com/tinkerbell/smee/internal/cluster"
+	"github.com/tinkerbell/smee/internal/deadline"
+	"github.com/tinkerbell/smee/internal/dhcp"
+	"github.com/tinkerbell/smee/internal/dhcp/firmware"
 	"github.com/tinkerbell/smee/internal/dhcp/handler"
 	"github.com/tinkerbell/smee/internal/dhcp/handler/proxy"
 	"github.com/tinkerbell/smee/internal/dhcp/handler/reservation"
+	"github.com/tinkerbell/smee/internal/dhcp/lease"
 	"github.com/tinkerbell/smee/internal/dhcp/server"
+	"github.com/tinkerbell/smee/internal/dns"
+	"github.com/tinkerbell/smee/internal/esxi"
+	"github.com/tinkerbell/smee/internal/facility"
+	"github.com/tinkerbell/smee/internal/handoff"
+	"github.com/tinkerbell/smee/internal/httpfile"
+	"github.com/tinkerbell/smee/internal/inventory"
+	"github.com/tinkerbell/smee/internal/ipmac"
+	"github.com/tinkerbell/smee/internal/ipxe/bindir"
 	"github.com/tinkerbell/smee/internal/ipxe/http"
 	"github.com/tinkerbell/smee/internal/ipxe/script"
+	"github.com/tinkerbell/smee/internal/ipxe/trust"
 	"github.com/tinkerbell/smee/internal/iso"
+	"github.com/tinkerbell/smee/internal/iso/build"
+	"github.com/tinkerbell/smee/internal/kea"
+	"github.com/tinkerbell/smee/internal/limit"
+	"github.com/tinkerbell/smee/internal/localmac"
+	"github.com/tinkerbell/smee/internal/maintenance"
+	"github.com/tinkerbell/smee/internal/mdns"
+	"github.com/tinkerbell/smee/internal/metadata"
 	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/mirror"
+	"github.com/tinkerbell/smee/internal/osie"
 	"github.com/tinkerbell/smee/internal/otel"
+	"github.com/tinkerbell/smee/internal/oui"
+	"github.com/tinkerbell/smee/internal/phonehome"
+	"github.com/tinkerbell/smee/internal/pluginhost"
+	"github.com/tinkerbell/smee/internal/policy"
+	"github.com/tinkerbell/smee/internal/profile"
+	"github.com/tinkerbell/smee/internal/pxemenu"
+	"github.com/tinkerbell/smee/internal/quota"
+	"github.com/tinkerbell/smee/internal/redact"
+	"github.com/tinkerbell/smee/internal/rollout"
+	"github.com/tinkerbell/smee/internal/secureboot"
+	"github.com/tinkerbell/smee/internal/selftest"
+	"github.com/tinkerbell/smee/internal/settings"
+	"github.com/tinkerbell/smee/internal/shadow"
+	"github.com/tinkerbell/smee/internal/slo"
+	"github.com/tinkerbell/smee/internal/snapshot"
+	"github.com/tinkerbell/smee/internal/sockets"
+	"github.com/tinkerbell/smee/internal/staleness"
+	"github.com/tinkerbell/smee/internal/supervise"
 	"github.com/tinkerbell/smee/internal/syslog"
-	"golang.org/x/sync/errgroup"
+	"github.com/tinkerbell/smee/internal/tenant"
+	"github.com/tinkerbell/smee/internal/tlsconfig"
+	"github.com/tinkerbell/smee/internal/tmpl"
+	"github.com/tinkerbell/smee/internal/uboot"
+	"github.com/tinkerbell/smee/internal/upstream"
+	"github.com/tinkerbell/smee/internal/vlan"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var (
@@ -47,9 +111,12 @@ const (
 	dhcpModeProxy       dhcpMode = "proxy"
 	dhcpModeReservation dhcpMode = "reservation"
 	dhcpModeAutoProxy   dhcpMode = "auto-proxy"
+	dhcpModeKea         dhcpMode = "kea"
 	// magicString comes from the HookOS repo
 	// ref: https://github.com/tinkerbell/hook/blob/main/linuxkit-templates/hook.template.yaml
 	magicString = `464vn90e7rbj08xbwdjejmdf4it17c5zfzjyfhthbh19eij201hjgit021bmpdb9ctrc87x2ymc8e7icu4ffi15x1hah9iyaiz38ckyap8hwx2vt5rm44ixv4hau8iw718q5yd019um5dt2xpqqa2rjtdypzr5v1gun8un110hhwp8cex7pqrh2ivh0ynpm4zkkwc8wcn367zyethzy7q8hzudyeyzx3cgmxqbkh825gcak7kxzjbgjajwizryv7ec1xm2h0hh7pz29qmvtgfjj1vphpgq1zcbiiehv52wrjy9yq473d9t1rvryy6929nk435hfx55du3ih05kn5tju3vijreru1p6knc988d4gfdz28eragvryq5x8aibe5trxd0t6t7jwxkde34v6pj1khmp50k6qqj3nzgcfzabtgqkmeqhdedbvwf3byfdma4nkv3rcxugaj2d0ru30pa2fqadjqrtjnv8bu52xzxv7irbhyvygygxu1nt5z4fh9w1vwbdcmagep26d298zknykf2e88kumt59ab7nq79d8amnhhvbexgh48e8qc61vq2e9qkihzt1twk1ijfgw70nwizai15iqyted2dt9gfmf2gg7amzufre79hwqkddc1cd935ywacnkrnak6r7xzcz7zbmq3kt04u2hg1iuupid8rt4nyrju51e6uejb2ruu36g9aibmz3hnmvazptu8x5tyxk820g2cdpxjdij766bt2n3djur7v623a2v44juyfgz80ekgfb9hkibpxh3zgknw8a34t4jifhf116x15cei9hwch0fye3xyq0acuym8uhitu5evc4rag3ui0fny3qg4kju7zkfyy8hwh537urd5uixkzwu5bdvafz4jmv7imypj543xg5em8jk8cgk7c4504xdd5e4e71ihaumt6u5u2t1w7um92fepzae8p0vq93wdrd1756npu1pziiur1payc7kmdwyxg3hj5n4phxbc29x0tcddamjrwt260b0w`
+	// pxeBootServerPort is the port that PXE clients discover boot servers on, in place of the DHCP port.
+	pxeBootServerPort = 4011
 )
 
 type config struct {
@@ -60,10 +127,165 @@ type config struct {
 	dhcp           dhcpConfig
 	iso            isoConfig
 
+	// strict fails the start up when the configuration has lint warnings, see config.lint.
+	strict bool
+	// lintIgnore are the comma separated codes of the lint warnings that are not reported.
+	lintIgnore string
 	// loglevel is the log level for smee.
 	logLevel string
-	backends dhcpBackends
-	otel     otelConfig
+	// serviceList is the comma separated list of the services that run, see applyServices.
+	serviceList string
+	// logHashMACs replaces MAC addresses in logs with a stable hash.
+	logHashMACs bool
+	// logHashMACsKeyFile is the path to a file holding the key of the MAC address hashes, a random key is used when unset.
+	logHashMACsKeyFile string
+	// ipMACTTL is how long the addresses cache remembers the MAC address of an IP address.
+	ipMACTTL time.Duration
+	// advertisedIP is the IP address that machines use to reach Smee, see config.advertise.
+	advertisedIP string
+	// advertisedIPStrict fails the start up when the advertised addresses have problems, see config.checkAddrs.
+	advertisedIPStrict bool
+	backends           dhcpBackends
+	staleness          stalenessConfig
+	maintenance        maintenanceConfig
+	slo                sloConfig
+	quota              quotaConfig
+	otel               otelConfig
+	settings           settingsConfig
+	bmc                bmcConfig
+	dns                dnsConfig
+	mdns               mdnsConfig
+	policy             policyConfig
+	tls                tlsConfig
+	upstream           upstreamConfig
+	timeout            timeoutConfig
+	secureBoot         secureBootConfig
+	esxi               esxiConfig
+	uboot              ubootConfig
+	https              httpsConfig
+	plugin             pluginConfig
+	admin              adminConfig
+	metadata           metadataConfig
+	bootstrap          bootstrapConfig
+	inventory          inventoryConfig
+	phoneHome          phoneHomeConfig
+	writeback          writebackConfig
+	selfTest           selfTestConfig
+	facility           facilityConfig
+	tenant             tenantConfig
+	rollout            rolloutConfig
+	snapshot           snapshotConfig
+	cluster            clusterConfig
+	profile            profileConfig
+	campaign           campaignConfig
+	oui                ouiConfig
+	mirror             mirrorConfig
+	template           templateConfig
+	shadow             shadowConfig
+	chaos              chaosConfig
+	supervise          superviseConfig
+	// readiness holds the readiness checks of the backends that are created.
+	readiness *readiness
+	// plugins holds the plugin processes that are started.
+	plugins *pluginhost.Host
+	// events records the boot events of machines for the admin API.
+	events *admin.Events
+	// caches holds the caches that can be flushed with the admin API.
+	caches *admin.Caches
+	// syslogMessages streams the received syslog messages to the admin API.
+	syslogMessages *admin.Syslog
+	// facilities holds the per facility overrides that are loaded from facility.file.
+	facilities *facility.Config
+	// vlans holds the VLANs that DHCP is served on that are loaded from dhcp.vlanFile.
+	vlans *vlan.Config
+	// tenants holds the tenants that are loaded from tenant.file.
+	tenants *tenant.Config
+	// osieTracks holds the weighted OSIE URL tracks that are loaded from rollout.file.
+	osieTracks *rollout.Config
+	// bootLog keeps the recent boot events and syslog messages of machines, it is nil unless phoneHome.bootLogEntries is set.
+	bootLog *bootlog.Log
+	// replicas shares the leases, the seen addresses and the cache flushes with the other replicas, it is nil unless
+	// cluster.peers is set.
+	replicas *cluster.Cluster
+	// snapshots keeps the last boot scripts that machines were served, it is nil unless snapshot.count is set.
+	snapshots *snapshot.Store
+	// stale is the staleness policy of the backend records, it is nil unless staleness.maxAge is set.
+	stale *staleness.Policy
+	// maintenanceSwitch is the maintenance switch of the backend records, it is set with the maintenance flags, the admin
+	// API and the runtime settings.
+	maintenanceSwitch *maintenance.Switch
+	// localMACs is the policy of the locally administered MAC addresses, it is nil with the allow action.
+	localMACs *localmac.Policy
+	// recorder records the lookups of the backend, it is nil unless backends.recordFile is set.
+	recorder *replay.Recorder
+	// bootSLOs tracks the boot stages of machines against their objectives, it is nil unless slo.thresholds is set.
+	bootSLOs *slo.Tracker
+	// netbootQuota switches the machines that are netbooted too often to local boot, it is nil unless quota.netboots is set.
+	netbootQuota *quota.Quota
+	// profiles holds the boot profiles that are loaded from profile.file.
+	profiles *profile.Config
+	// campaigns holds the reprovisioning campaigns that are loaded from campaign.file.
+	campaigns *campaign.Config
+	// ouiRules holds the default boot settings by OUI that are loaded from oui.file.
+	ouiRules *oui.Config
+	// mirrors holds the groups of boot server candidates that are loaded from mirror.file.
+	mirrors *mirror.Config
+	// registrar registers the DNS records of the reservations that are leased, it is nil unless dns.enabled is set.
+	registrar *dns.Registrar
+	// bootTraces records the trace of the DHCP replies, it is nil unless otel.bootTrace is enabled.
+	bootTraces *otel.BootTraces
+	// leases records the IP addresses leased by DHCP, it is nil unless the lease iPXE script client identifier or the
+	// admin API is used.
+	leases *lease.Table
+	// addresses resolves the IP address of requests to the MAC address of their machine, from the leases and the
+	// addresses seen in DHCP and iPXE script requests, it is nil when ipMACTTL is 0.
+	addresses *ipmac.Cache
+	// dryRun is the DHCP dry-run mode, it starts as dhcp.dryRun and can be toggled with the admin API.
+	dryRun *atomic.Bool
+	// faults injects the faults that are set with the admin API, it is nil unless chaos.enabled is set.
+	faults *chaos.Injector
+	// effective is the effective configuration, a name=value line for every flag, returned by the admin API.
+	effective []string
+	// sockets are the sockets inherited from the process that started Smee, the services bind those that are not.
+	sockets *sockets.Set
+	// shared is the backend that is shared by all of the services, so that the kubernetes backend and its informer
+	// are only created once.
+	shared *sharedBackend
+}
+
+// sharedBackend is a backend that is created once, on first use.
+type sharedBackend struct {
+	once sync.Once
+	br   handler.BackendReader
+	err  error
+}
+
+// readiness is the readiness of Smee, it is ready once all of its checks are.
+type readiness struct {
+	mu     sync.Mutex
+	checks []func() bool
+}
+
+func (r *readiness) add(check func() bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check)
+}
+
+// Ready returns whether all checks are ready.
+func (r *readiness) Ready() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.checks {
+		if !c() {
+			return false
+		}
+	}
+
+	return true
 }
 
 type syslogConfig struct {
@@ -78,7 +300,17 @@ type tftp struct {
 	blockSize       int
 	enabled         bool
 	ipxeScriptPatch string
-	timeout         time.Duration
+	// ipxeScriptBootstrap patches bootstrapPatch into the served binaries when ipxeScriptPatch is not set.
+	ipxeScriptBootstrap bool
+	timeout             time.Duration
+	// maxSessions is the maximum number of concurrent TFTP sessions, 0 is unlimited.
+	maxSessions int
+	// binaryDir is a directory of iPXE binaries that are served in front of the embedded ones.
+	binaryDir string
+	// ipxeTrust is the CA that the served iPXE binaries are patched to trust, see httpsConfig.ipxeCAFile.
+	ipxeTrust *trust.CA
+	// uboot serves the U-Boot boot scripts and PXE configs in front of the iPXE binaries, see ubootConfig.
+	uboot *uboot.Handler
 }
 
 type ipxeHTTPBinary struct {
@@ -97,22 +329,69 @@ type ipxeHTTPScript struct {
 	trustedProxies        string
 	retries               int
 	retryDelay            int
+	// tinkHandoffTimeout is how long a machine has to start its pending Workflows after being served a boot script.
+	// A zero value disables the verification.
+	tinkHandoffTimeout time.Duration
+	// maxConnections is the maximum number of open HTTP connections, 0 is unlimited.
+	maxConnections int
+	// fallbackFile is the template of the iPXE script that is served when the backend lookup or the script rendering fails.
+	fallbackFile string
+	// twoStage serves a first stage auto.ipxe script that chains to the hook.ipxe second stage script.
+	twoStage bool
+	// consoleDetect serves a first stage auto.ipxe script that detects the console of the machine, see script.ConsoleStageScript.
+	consoleDetect bool
+	// consoleRulesFile is the YAML file of the console rules of consoleDetect, script.DefaultConsoleRules when empty.
+	consoleRulesFile string
+	// clientIdentifiers is the ordered list of strategies that identify the machine of a script request, see script.ParseClientIdentifiers.
+	clientIdentifiers string
+	// osieDir is a directory of OSIE versions that is served at /osie/, see osie.Handler.
+	osieDir string
+	// validate checks the iPXE scripts before they are served, see script.ValidateScript.
+	validate bool
+	// maxSize is the maximum size in bytes of the validated iPXE scripts.
+	maxSize int
 }
 
 type dhcpMode string
 
 type dhcpConfig struct {
-	enabled           bool
-	mode              string
-	bindAddr          string
-	bindInterface     string
-	ipForPacket       string
+	enabled       bool
+	mode          string
+	bindAddr      string
+	bindInterface string
+	ipForPacket   string
+	// serverID is the server identifier (option 54) of the replies, it defaults to ipForPacket.
+	serverID          string
 	syslogIP          string
 	tftpIP            string
 	tftpPort          int
 	httpIpxeBinaryURL urlBuilder
 	httpIpxeScript    httpIpxeScript
 	httpIpxeScriptURL string
+	// workers and queueSize bound the number of DHCP packets handled concurrently.
+	workers   int
+	queueSize int
+	// dryRun handles DHCP messages without sending any replies.
+	dryRun bool
+	// ipxeBinaries overrides the iPXE binary served per client architecture, see dhcp.ParseBinaries.
+	ipxeBinaries string
+	// pxeMenuFile is the path to a PXE boot menu file, see pxemenu.Load.
+	pxeMenuFile string
+	// replyMode and replyBroadcastFlag are the reply policy, see dhcp.ParseReplyPolicy.
+	replyMode          string
+	replyBroadcastFlag string
+	// transactionTTL is how long the reply to a DHCP transaction is reused for its retransmissions, 0 disables it.
+	transactionTTL time.Duration
+	// windowsCompat tunes the ProxyDHCP replies for Microsoft DHCP and answers PXE boot server discovery on port 4011.
+	windowsCompat bool
+	// localMACAction is what is done with the locally administered MAC addresses, see localmac.Action.
+	localMACAction string
+	// vlanFile is the path to a file of the VLANs that DHCP is served on, see vlan.Load.
+	vlanFile string
+	// raw serves DHCP on a raw socket of bindInterface, with rawSrcMAC and rawSrcIP as the source of the replies.
+	raw       bool
+	rawSrcMAC string
+	rawSrcIP  string
 }
 
 type urlBuilder struct {
@@ -134,11 +413,321 @@ type dhcpBackends struct {
 	file       File
 	kubernetes Kube
 	Noop       Noop
+	plugin     Plugin
+	replay     Replay
+	// recordFile is the path to the JSON lines file that the lookups of the backend are recorded to.
+	recordFile string
+}
+
+// stalenessConfig is the maximum age of the backend records and what is done with the older records, see
+// staleness.Policy.
+type stalenessConfig struct {
+	maxAge time.Duration
+	action string
+}
+
+// maintenanceConfig is the maintenance state on start up, see maintenance.Switch.
+type maintenanceConfig struct {
+	enabled bool
+	// subnets are the comma separated CIDRs of the subnets in maintenance.
+	subnets string
+	// localBoot keeps answering the DHCP requests of the machines in maintenance, without netboot options.
+	localBoot bool
+}
+
+// quotaConfig is the netboot quota of every machine, see quota.Quota.
+type quotaConfig struct {
+	// netboots is the number of netboots of a machine within the window, 0 disables the quota.
+	netboots int
+	window   time.Duration
+}
+
+// sloConfig is the service level objectives of the boot stages, see docs/Boot-SLOs.md.
+type sloConfig struct {
+	// thresholds are the comma separated <stage>=<duration> objectives, the stages are not tracked when empty.
+	thresholds string
+	// webhookURL is the URL that the violations are POSTed to.
+	webhookURL string
+}
+
+// state returns the maintenance state of the flags.
+func (m maintenanceConfig) state() (maintenance.State, error) {
+	st := maintenance.State{Enabled: m.enabled}
+	for _, cidr := range strings.Split(m.subnets, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return maintenance.State{}, fmt.Errorf("invalid maintenance subnet %q: %w", cidr, err)
+		}
+		st.Subnets = append(st.Subnets, p.Masked())
+	}
+
+	return st, nil
 }
 
 type otelConfig struct {
 	endpoint string
 	insecure bool
+	// bootTrace starts a trace for every DHCP message that is continued by the boot chain of the machine, into Hook.
+	bootTrace bool
+	// resourceAttributes are the comma separated key=value resource attributes of the traces and logs.
+	resourceAttributes string
+	// logs exports the logs to the collector, in addition to stdout.
+	logs              bool
+	logBatchSize      int
+	logExportInterval time.Duration
+}
+
+// resource returns the resource attributes of the traces and logs.
+func (o otelConfig) resource() (map[string]string, error) {
+	attrs := map[string]string{}
+	for _, kv := range strings.Split(o.resourceAttributes, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return nil, fmt.Errorf("-otel-resource-attributes %q is not a key=value pair", kv)
+		}
+		attrs[k] = strings.TrimSpace(v)
+	}
+
+	return attrs, nil
+}
+
+type settingsConfig struct {
+	// kubeConfigMap is the name of a Kubernetes ConfigMap to live-reload settings from.
+	kubeConfigMap string
+}
+
+// tlsConfig holds the TLS configuration of outbound connections.
+// The per-destination configuration is merged over the global configuration.
+type tlsConfig struct {
+	global tlsconfig.Config
+	iso    tlsconfig.Config
+	otel   tlsconfig.Config
+}
+
+// upstreamConfig is the retry and circuit breaker policy of outbound HTTP requests, iso overrides global for the
+// source ISO and the ISO index.
+type upstreamConfig struct {
+	global upstream.Policy
+	iso    upstream.Policy
+}
+
+// timeoutConfig is the deadline of every DHCP packet and HTTP request that Smee handles, and of every backend lookup
+// they make.
+type timeoutConfig struct {
+	dhcp    time.Duration
+	http    time.Duration
+	backend time.Duration
+}
+
+type secureBootConfig struct {
+	enabled bool
+	// dir is the directory holding the signed shim, GRUB and MOK manager binaries.
+	dir string
+}
+
+type esxiConfig struct {
+	// dir is the directory holding the files of the ESXi installer ISO.
+	dir string
+}
+
+type ubootConfig struct {
+	enabled bool
+	// scriptFile is the path to the template of the boot script, uboot.DefaultScript when empty.
+	scriptFile string
+	// extlinuxFile is the path to the template of the PXE config, it is not served when empty.
+	extlinuxFile string
+}
+
+// httpsConfig is the HTTPS server that serves the handlers of the HTTP server, see docs/HTTPS.md.
+type httpsConfig struct {
+	// port is the port of the HTTPS server, 0 disables it.
+	port     int
+	certFile string
+	keyFile  string
+	// ipxeCAFile is the CA certificate that the served iPXE binaries are patched to trust.
+	ipxeCAFile string
+}
+
+// pluginConfig holds the paths of the plugin executables that replace built-in functionality.
+type pluginConfig struct {
+	// dhcpHandler replaces the built-in DHCP handler.
+	dhcpHandler string
+	// scriptGenerator generates the auto.ipxe scripts in place of the Hook script.
+	scriptGenerator string
+}
+
+// adminConfig is the configuration of the admin API.
+type adminConfig struct {
+	// addr is a unix:<path> socket or TCP host:port address, the admin API is disabled when it is empty.
+	addr string
+	// tokenFile is the path to a file holding the token that clients must send.
+	tokenFile string
+}
+
+type policyConfig struct {
+	// file is the path to a netboot policy file.
+	file string
+}
+
+type facilityConfig struct {
+	// file is the path to a per facility overrides file.
+	file string
+}
+
+type tenantConfig struct {
+	// file is the path to a tenants file.
+	file string
+}
+
+type templateConfig struct {
+	// envAllowlist is the comma separated list of the environment variables that templates can read.
+	envAllowlist string
+}
+
+type shadowConfig struct {
+	// dhcpAddr is the IP:Port of the DHCP server of the shadow Smee.
+	dhcpAddr string
+	// httpURL is the base URL of the HTTP server of the shadow Smee.
+	httpURL string
+	// ignore is the comma separated list of regular expressions that are removed from the responses before they are compared.
+	ignore  string
+	timeout time.Duration
+}
+
+type chaosConfig struct {
+	// enabled allows faults to be injected with the admin API.
+	enabled bool
+}
+
+type superviseConfig struct {
+	// restart restarts a failed service with backoff, instead of stopping Smee.
+	restart         bool
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	maxRestarts     int
+}
+
+type rolloutConfig struct {
+	// file is the path to a weighted OSIE URL tracks file.
+	file string
+	// maxChanges is the maximum number of machines per window that boot a changed boot configuration, 0 disables it.
+	maxChanges int
+	// window is the duration that the machines booting a changed boot configuration are counted over.
+	window time.Duration
+	// stateFile is the path to the file that the boot configuration of each machine is saved to.
+	stateFile string
+}
+
+type clusterConfig struct {
+	// peers are the comma separated host:port addresses of the replicas to join, the cluster is disabled when empty.
+	peers         string
+	bindAddr      string
+	bindPort      int
+	advertiseAddr string
+	// nodeName is the unique name of the replica in the cluster, the hostname when empty.
+	nodeName string
+	// keyFile is the path to a file holding the key that the gossip is encrypted with.
+	keyFile string
+}
+
+type snapshotConfig struct {
+	// count is the number of boot scripts that are kept per machine, 0 disables the snapshots.
+	count int
+	// dir is the path to the directory that the snapshots are saved to.
+	dir string
+}
+
+type profileConfig struct {
+	// file is the path to a boot profiles file.
+	file string
+}
+
+type campaignConfig struct {
+	// file is the path to a reprovisioning campaigns file.
+	file string
+	// stateFile is the path to the file that the progress of the machines of the campaigns is saved to.
+	stateFile string
+}
+
+type ouiConfig struct {
+	// file is the path to a per OUI rules file.
+	file string
+}
+
+type mirrorConfig struct {
+	// file is the path to a boot server candidates file.
+	file string
+}
+
+type dnsConfig struct {
+	enabled bool
+	domain  string
+}
+
+type mdnsConfig struct {
+	enabled bool
+	// iface is the interface that the services are advertised on, the interface of the default multicast route when empty.
+	iface string
+	// instance is the instance name of the advertised services, the hostname when empty.
+	instance string
+}
+
+type metadataConfig struct {
+	enabled bool
+	// vendorDataFile is the path to the template of the NoCloud vendor-data.
+	vendorDataFile string
+}
+
+// bootstrapConfig serves the cluster bootstrap documents of machines, see docs/Cluster-Bootstrap.md.
+type bootstrapConfig struct {
+	// dir is the directory of the templates of the documents, the documents are not served when empty.
+	dir string
+	// secretsDir is the directory that the secret function of the templates reads from.
+	secretsDir string
+}
+
+type inventoryConfig struct {
+	enabled bool
+	// factsDir is the directory the facts are written to, the Hardware objects of the kubernetes backend are annotated when empty.
+	factsDir     string
+	allowedCIDRs string
+}
+
+type phoneHomeConfig struct {
+	enabled bool
+	// keyFile is the path to a file holding the key used to sign the phone home tokens.
+	keyFile  string
+	tokenTTL time.Duration
+	// disableNetboot sets allowPXE to false on the Hardware of the machines that phone home.
+	disableNetboot bool
+	// bootLogEntries is the number of boot events and syslog messages kept per machine for /bootlog, 0 disables it.
+	bootLogEntries int
+}
+
+type writebackConfig struct {
+	// netbootDisableAfter is the number of boot script and ISO mount serves after which allowPXE is set to false, 0 is never.
+	netbootDisableAfter int
+}
+
+type selfTestConfig struct {
+	// mac is the MAC address of the canary machine of the self-test, the self-test is disabled when empty.
+	mac string
+	// interval is how often the self-test is run again after it passed, 0 is only at start up.
+	interval time.Duration
+	// firmware enables the check of the dhcp replies to the requests of the synthetic firmware fixtures.
+	firmware bool
+}
+
+type bmcConfig struct {
+	enabled      bool
+	allowedCIDRs string
 }
 
 type isoConfig struct {
@@ -146,23 +735,207 @@ type isoConfig struct {
 	url               string
 	magicString       string
 	staticIPAMEnabled bool
+	// signingKeyFile is the path to a file holding the key used to sign expiring, MAC-bound, ISO URLs.
+	signingKeyFile string
+	signedURLTTL   time.Duration
+	bufferSize     int
+	// maxStreams is the maximum number of ISOs streamed concurrently, 0 is unlimited.
+	maxStreams int
+	streamWait time.Duration
+	// upstreamParallelism is the number of concurrent sub-range fetches of the source ISO for a large range request.
+	upstreamParallelism int
+	upstreamChunkSize   int64
+	// indexURL is the URL of a release index the source ISO is resolved from, in place of url.
+	indexURL      string
+	indexVersion  string
+	indexChannel  string
+	indexInterval time.Duration
+	// verifyUpstream requires consistent responses of the source ISO, so that downloads can resume on any replica.
+	verifyUpstream bool
+	// replicaURLs is a comma separated list of the base URLs of the replicas of Smee, replicaSelf is the one of this replica.
+	replicaURLs string
+	replicaSelf string
+	// buildKernel, buildInitrd and buildLoader are the paths of the files of an ISO built by Smee, in place of a source ISO.
+	buildKernel string
+	buildInitrd string
+	buildLoader string
+	// buildCmdline is the template of the kernel cmdline of machines, in place of the kernel args of HookOS.
+	buildCmdline string
+}
+
+// replicas returns the parsed replicaURLs and replicaSelf, self must be one of the replicas.
+func (c isoConfig) replicas() ([]*url.URL, *url.URL, error) {
+	if c.replicaURLs == "" {
+		return nil, nil, nil
+	}
+	var replicas []*url.URL
+	var self *url.URL
+	for _, s := range strings.Split(c.replicaURLs, ",") {
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, nil, fmt.Errorf("invalid replica URL %q", s)
+		}
+		replicas = append(replicas, u)
+		if c.replicaSelf != "" && u.String() == strings.TrimSpace(c.replicaSelf) {
+			self = u
+		}
+	}
+	if self == nil {
+		return nil, nil, fmt.Errorf("-iso-replica-self %q is not one of -iso-replica-urls", c.replicaSelf)
+	}
+
+	return replicas, self, nil
 }
 
 func main() {
-	cfg := &config{}
-	cli := newCLI(cfg, flag.NewFlagSet(name, flag.ExitOnError))
-	_ = cli.Parse(os.Args[1:])
+	cfg := &config{readiness: &readiness{}, plugins: &pluginhost.Host{}, events: &admin.Events{}, caches: &admin.Caches{}, syslogMessages: &admin.Syslog{}, dryRun: &atomic.Bool{}, shared: &sharedBackend{}}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	cli := newCLI(cfg, fs)
+	// Parse returns NoExecError when no subcommand is selected, the smee service is then run.
+	parseErr := cli.Parse(os.Args[1:])
+	deprecated, err := cfg.applyServices(fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if parseErr == nil {
+		ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := cli.Run(ctx)
+		done()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	log := defaultLogger(cfg.logLevel)
+	cfg.effective = effectiveConfig(fs)
+	redactor, err := cfg.redactor()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	log := defaultLogger(cfg.logLevel, redactor, cfg.otel.logs)
 	log.Info("starting", "version", GitRev)
+	for _, f := range deprecated {
+		log.Info("deprecated flag, list the services that run with -services instead", "flag", f)
+	}
+	if ip := cfg.advertise(); ip == "" {
+		log.Info("unable to detect the advertised ip, set -advertised-ip or the addresses used in DHCP packets")
+	} else {
+		log.Info("advertising", "ip", ip, "ipForPacket", cfg.dhcp.ipForPacket, "syslogIP", cfg.dhcp.syslogIP, "tftpIP", cfg.dhcp.tftpIP)
+	}
+	if ifs, err := localInterfaceAddrs(); err != nil {
+		log.Info("unable to check the advertised addresses", "error", err.Error())
+	} else if problems := cfg.checkAddrs(ifs); len(problems) > 0 {
+		for _, p := range problems {
+			log.Info("advertised address problem, machines may get DHCP offers but fail to boot", "error", p.Error())
+		}
+		if cfg.advertisedIPStrict {
+			panic(fmt.Errorf("found %d problems with the advertised addresses", len(problems)))
+		}
+	}
+	for _, s := range cfg.services() {
+		log.Info("service", "name", s.name, "enabled", s.enabled, "reason", s.reason)
+	}
+	if problems := cfg.checkServices(); len(problems) > 0 {
+		for _, p := range problems {
+			log.Info("invalid service configuration", "error", p.Error())
+		}
+		panic(fmt.Errorf("found %d problems with the service configuration: %w", len(problems), errors.Join(problems...)))
+	}
+	if ds := cfg.lint(); len(ds) > 0 {
+		for _, d := range ds {
+			log.Info("configuration warning", "code", d.code, "warning", d.message)
+		}
+		if cfg.strict {
+			panic(fmt.Errorf("found %d configuration warnings with -strict", len(ds)))
+		}
+	}
+	if s, err := sockets.FromEnv(); err != nil {
+		panic(fmt.Errorf("invalid inherited sockets: %w", err))
+	} else if names := s.Names(); len(names) > 0 {
+		log.Info("serving on inherited sockets", "names", names)
+		cfg.sockets = s
+	}
+	cfg.dryRun.Store(cfg.dhcp.dryRun)
+	if cfg.chaos.enabled {
+		log.Info("fault injection is enabled, faults are injected once they are set with the admin api")
+		cfg.faults = &chaos.Injector{}
+	}
+	cfg.caches.Add("machines", cfg.events.Flush)
+	if p, err := cfg.ipxeScriptPatch(); err != nil {
+		panic(fmt.Errorf("invalid ipxe script patch: %w", err))
+	} else if p != "" {
+		log.Info("patching served ipxe binaries", "patch", p)
+		// the rendered patch is served by the tftp and http binary handlers.
+		cfg.tftp.ipxeScriptPatch = p
+	}
+	if cfg.https.ipxeCAFile != "" {
+		ca, err := trust.LoadCA(cfg.https.ipxeCAFile)
+		if err != nil {
+			panic(fmt.Errorf("failed to load the ipxe ca: %w", err))
+		}
+		log.Info("patching served ipxe binaries to trust the ca", "ca", ca.Cert.Subject.String())
+		// the tftp and http binary handlers serve the embedded binaries themselves, patched to trust the ca.
+		cfg.tftp.ipxeTrust = ca
+	}
+	if cfg.staleness.maxAge > 0 {
+		a, err := staleness.ParseAction(cfg.staleness.action)
+		if err != nil {
+			panic(err)
+		}
+		log.Info("enforcing the max age of the backend records", "maxAge", cfg.staleness.maxAge.String(), "action", a)
+		cfg.stale = &staleness.Policy{MaxAge: cfg.staleness.maxAge, Action: a, Log: log.WithName("staleness")}
+	}
+	ms, err := cfg.maintenance.state()
+	if err != nil {
+		panic(err)
+	}
+	cfg.maintenanceSwitch = &maintenance.Switch{LocalBoot: cfg.maintenance.localBoot, Log: log.WithName("maintenance")}
+	cfg.maintenanceSwitch.Set(ms)
+	if cfg.dhcp.localMACAction != "" && cfg.dhcp.localMACAction != string(localmac.Allow) {
+		a, err := localmac.ParseAction(cfg.dhcp.localMACAction)
+		if err != nil {
+			panic(err)
+		}
+		log.Info("applying the locally administered MAC address policy", "action", a)
+		cfg.localMACs = &localmac.Policy{Action: a, Log: log.WithName("localmac")}
+	}
+	if cfg.backends.recordFile != "" {
+		r, err := replay.NewRecorder(cfg.backends.recordFile)
+		if err != nil {
+			panic(fmt.Errorf("failed to open the backend recording: %w", err))
+		}
+		defer r.Close()
+		log.Info("recording the lookups of the backend", "file", cfg.backends.recordFile)
+		r.Log = log.WithName("record")
+		cfg.recorder = r
+	}
 
 	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGHUP, syscall.SIGTERM)
 	defer done()
+	resAttrs, err := cfg.otel.resource()
+	if err != nil {
+		panic(err)
+	}
 	oCfg := otel.Config{
-		Servicename: "smee",
-		Endpoint:    cfg.otel.endpoint,
-		Insecure:    cfg.otel.insecure,
-		Logger:      log,
+		Servicename:        "smee",
+		Endpoint:           cfg.otel.endpoint,
+		Insecure:           cfg.otel.insecure,
+		ResourceAttributes: resAttrs,
+		Logs:               cfg.otel.logs,
+		LogBatchSize:       cfg.otel.logBatchSize,
+		LogExportInterval:  cfg.otel.logExportInterval,
+		// the errors of the exporters are only written to stdout, an export error must not be exported.
+		Logger: defaultLogger(cfg.logLevel, redactor, false),
+	}
+	if tc := cfg.tls.global.Merge(cfg.tls.otel); !tc.IsZero() {
+		t, err := tc.TLS()
+		if err != nil {
+			panic(fmt.Errorf("invalid OpenTelemetry TLS configuration: %w", err))
+		}
+		oCfg.TLS = t
 	}
 	ctx, otelShutdown, err := otel.Init(ctx, oCfg)
 	if err != nil {
@@ -170,15 +943,100 @@ func main() {
 		panic(err)
 	}
 	defer otelShutdown()
+	if cfg.otel.bootTrace {
+		cfg.bootTraces = &otel.BootTraces{}
+	}
+	clientIdentifiers, err := script.ParseClientIdentifiers(cfg.ipxeHTTPScript.clientIdentifiers)
+	if err != nil {
+		panic(fmt.Errorf("invalid ipxe script client identifiers: %w", err))
+	}
+	if slices.Contains(clientIdentifiers, script.IdentifyLease) || cfg.admin.addr != "" || cfg.cluster.peers != "" {
+		cfg.leases = &lease.Table{}
+	}
+	if cfg.ipMACTTL > 0 {
+		cfg.addresses = &ipmac.Cache{TTL: cfg.ipMACTTL}
+		if cfg.leases != nil {
+			cfg.addresses.Resolvers = []ipmac.Resolver{cfg.leases}
+		}
+	}
+	cfg.bootLog = bootlog.NewLog(cfg.phoneHome.bootLogEntries)
+	if cfg.slo.thresholds != "" {
+		thresholds, err := slo.ParseThresholds(cfg.slo.thresholds)
+		if err != nil {
+			panic(fmt.Errorf("invalid boot stage thresholds: %w", err))
+		}
+		cfg.bootSLOs = &slo.Tracker{Thresholds: thresholds, Webhook: cfg.slo.webhookURL, Log: log.WithName("slo")}
+		if cfg.slo.webhookURL != "" {
+			ut := upstream.New("slo-webhook", cfg.upstream.global, nil)
+			if !cfg.tls.global.IsZero() {
+				t, err := cfg.tls.global.Transport()
+				if err != nil {
+					panic(fmt.Errorf("invalid SLO webhook TLS configuration: %w", err))
+				}
+				ut.Base = t
+			}
+			cfg.bootSLOs.Transport = ut
+		}
+	}
+	if cfg.quota.netboots > 0 {
+		log.Info("enforcing the netboot quota of machines", "netboots", cfg.quota.netboots, "window", cfg.quota.window.String())
+		cfg.netbootQuota = &quota.Quota{Max: cfg.quota.netboots, Window: cfg.quota.window, Log: log.WithName("quota")}
+		cfg.caches.Add("netboot-quota", cfg.netbootQuota.Flush)
+	}
+	if cfg.cluster.peers != "" {
+		if cfg.replicas, err = cfg.clusterMember(log); err != nil {
+			panic(fmt.Errorf("failed to join the cluster: %w", err))
+		}
+	}
 	metric.Init()
 
-	g, ctx := errgroup.WithContext(ctx)
+	g, ctx := supervise.WithContext(ctx, supervise.Options{
+		Restart:         cfg.supervise.restart,
+		InitialInterval: cfg.supervise.initialInterval,
+		MaxInterval:     cfg.supervise.maxInterval,
+		MaxRestarts:     cfg.supervise.maxRestarts,
+		Log:             log,
+	})
+	cfg.readiness.add(g.Ready)
+	if cfg.replicas != nil {
+		log.Info("sharing state with the other replicas", "peers", cfg.cluster.peers, "bind_port", cfg.cluster.bindPort)
+		g.Go("cluster", func() error {
+			return cfg.replicas.Run(ctx)
+		})
+	}
+	if cfg.bootSLOs != nil {
+		g.Go("slo", func() error {
+			return cfg.bootSLOs.Run(ctx)
+		})
+	}
 	// syslog
 	if cfg.syslog.enabled {
 		addr := fmt.Sprintf("%s:%d", cfg.syslog.bindAddr, cfg.syslog.bindPort)
 		log.Info("starting syslog server", "bind_addr", addr)
-		g.Go(func() error {
-			if err := syslog.StartReceiver(ctx, log, addr, 1); err != nil {
+		var observers []syslog.Observer
+		if cfg.admin.addr != "" {
+			observers = append(observers, cfg.syslogMessages)
+		}
+		if cfg.bootLog != nil {
+			observers = append(observers, cfg.bootLog)
+		}
+		var resolver syslog.Resolver
+		if cfg.addresses != nil {
+			resolver = cfg.addresses
+		}
+		g.Go("syslog", func() error {
+			c, err := cfg.sockets.PacketConn(sockets.Syslog, cfg.syslog.bindPort, func() (net.PacketConn, error) {
+				return net.ListenPacket("udp4", addr)
+			})
+			if err != nil {
+				return fmt.Errorf("listen on syslog udp address: %w", err)
+			}
+			uc, ok := c.(*net.UDPConn)
+			if !ok {
+				c.Close()
+				return errors.New("the syslog socket is not a udp socket")
+			}
+			if err := syslog.StartReceiverConn(ctx, log, uc, 1, resolver, observers...); err != nil {
 				log.Error(err, "syslog server failure")
 				return err
 			}
@@ -188,8 +1046,46 @@ func main() {
 		})
 	}
 
-	// tftp
-	if cfg.tftp.enabled {
+	// u-boot boot scripts, served by every tftp server in front of the ipxe binaries.
+	if cfg.uboot.enabled {
+		br, err := cfg.backend(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to create backend: %w", err))
+		}
+		uh := &uboot.Handler{
+			Backend:    cfg.handlerBackend(br),
+			Addresses:  cfg.addresses,
+			TFTPServer: cfg.dhcp.tftpIP,
+			HTTPServer: (&url.URL{
+				Scheme: cfg.dhcp.httpIpxeScript.Scheme,
+				Host:   net.JoinHostPort(cfg.dhcp.httpIpxeScript.Host, strconv.Itoa(cfg.dhcp.httpIpxeScript.Port)),
+			}).String(),
+			TemplateEnv: cfg.template.env(),
+			Log:         log.WithValues("service", "github.com/tinkerbell/smee").WithName("uboot"),
+		}
+		binaries, err := dhcp.ParseBinaries(cfg.dhcp.ipxeBinaries)
+		if err != nil {
+			panic(fmt.Errorf("invalid ipxe binaries: %w", err))
+		}
+		uh.IPXEBinary = binaries[dhcp.ArchNames["arm64"][0]]
+		for _, f := range []struct {
+			path string
+			text *string
+		}{{cfg.uboot.scriptFile, &uh.Script}, {cfg.uboot.extlinuxFile, &uh.Extlinux}} {
+			if f.path == "" {
+				continue
+			}
+			b, err := os.ReadFile(f.path)
+			if err != nil {
+				panic(fmt.Errorf("failed to read the u-boot template: %w", err))
+			}
+			*f.text = string(b)
+		}
+		cfg.tftp.uboot = uh
+	}
+
+	// tftp, with secure boot or esxi enabled the tftp server is started below, as it also serves their files.
+	if cfg.tftp.enabled && !cfg.secureBoot.enabled && cfg.esxi.dir == "" {
 		tftpServer := &ipxedust.Server{
 			Log:                  log.WithValues("service", "github.com/tinkerbell/smee").WithName("github.com/tinkerbell/ipxedust"),
 			HTTP:                 ipxedust.ServerSpec{Disabled: true}, // disabled because below we use the http handlerfunc instead.
@@ -206,8 +1102,15 @@ func main() {
 				BlockSize: cfg.tftp.blockSize,
 			}
 			// start the ipxe binary tftp server
-			log.Info("starting tftp server", "bind_addr", addr)
-			g.Go(func() error {
+			log.Info("starting tftp server", "bind_addr", addr, "maxSessions", cfg.tftp.maxSessions)
+			g.Go("tftp", func() error {
+				if cfg.tftp.maxSessions > 0 || cfg.tftp.binaryDir != "" || cfg.tftp.ipxeTrust != nil || cfg.tftp.uboot != nil || cfg.sockets.Has(sockets.TFTP) {
+					conn, err := cfg.tftp.listen(cfg.sockets, ip)
+					if err != nil {
+						return err
+					}
+					return cfg.tftp.serve(ctx, tftpServer.Log, conn)
+				}
 				return tftpServer.ListenAndServe(ctx)
 			})
 		} else {
@@ -216,25 +1119,379 @@ func main() {
 		}
 	}
 
+	// netboot policy
+	var pol *policy.Policy
+	if cfg.policy.file != "" {
+		p, err := policy.Load(cfg.policy.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load netboot policy: %w", err))
+		}
+		log.Info("loaded netboot policy", "file", cfg.policy.file, "rules", len(p.Rules))
+		pol = p
+	}
+
+	// per facility overrides
+	if cfg.facility.file != "" {
+		f, err := facility.Load(cfg.facility.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load facility overrides: %w", err))
+		}
+		log.Info("loaded facility overrides", "file", cfg.facility.file, "facilities", len(f.Facilities))
+		cfg.facilities = f
+	}
+
+	// dhcp on vlan subinterfaces
+	if cfg.dhcp.vlanFile != "" {
+		v, err := vlan.Load(cfg.dhcp.vlanFile)
+		if err != nil {
+			panic(fmt.Errorf("failed to load vlans: %w", err))
+		}
+		log.Info("loaded vlans", "file", cfg.dhcp.vlanFile, "vlans", len(v.VLANs))
+		cfg.vlans = v
+	}
+
+	// tenants
+	if cfg.tenant.file != "" {
+		t, err := tenant.Load(cfg.tenant.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load tenants: %w", err))
+		}
+		log.Info("loaded tenants", "file", cfg.tenant.file, "tenants", len(t.Tenants))
+		cfg.tenants = t
+	}
+
+	// weighted OSIE URL tracks
+	if cfg.rollout.file != "" {
+		r, err := rollout.Load(cfg.rollout.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load osie rollout tracks: %w", err))
+		}
+		log.Info("loaded osie rollout tracks", "file", cfg.rollout.file, "tracks", len(r.Tracks))
+		cfg.osieTracks = r
+	}
+
+	// blast radius of boot configuration changes
+	rolloutGuard, err := rollout.NewGuard(cfg.rollout.maxChanges, cfg.rollout.window, cfg.rollout.stateFile)
+	if err != nil {
+		panic(fmt.Errorf("failed to load the rollout guard state: %w", err))
+	}
+	if rolloutGuard != nil {
+		log.Info("limiting the machines that boot a changed configuration", "max", cfg.rollout.maxChanges, "window", cfg.rollout.window, "stateFile", cfg.rollout.stateFile)
+		g.Go("rollout-guard", func() error {
+			return rolloutGuard.Persist(ctx, 10*time.Second)
+		})
+	}
+
+	// snapshots of the boot scripts of machines
+	if cfg.snapshots, err = snapshot.NewStore(cfg.snapshot.count, cfg.snapshot.dir); err != nil {
+		panic(fmt.Errorf("failed to load the boot script snapshots: %w", err))
+	}
+	if cfg.snapshots != nil {
+		log.Info("keeping snapshots of the boot scripts of machines", "count", cfg.snapshot.count, "dir", cfg.snapshot.dir)
+	}
+
+	// boot profiles by DHCP user class and vendor class
+	if cfg.profile.file != "" {
+		p, err := profile.Load(cfg.profile.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load boot profiles: %w", err))
+		}
+		log.Info("loaded boot profiles", "file", cfg.profile.file, "profiles", len(p.Profiles))
+		cfg.profiles = p
+	}
+
+	// reprovisioning campaigns
+	if cfg.campaign.file != "" {
+		c, err := campaign.Load(cfg.campaign.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load campaigns: %w", err))
+		}
+		for _, name := range c.Profiles() {
+			if _, ok := cfg.profiles.Named(name); !ok {
+				panic(fmt.Errorf("failed to load campaigns: the boot profile %q is not in -profile-file", name))
+			}
+		}
+		log.Info("loaded campaigns", "file", cfg.campaign.file, "campaigns", len(c.Campaigns))
+		cfg.campaigns = c
+	}
+
+	// default boot settings by the OUI of MAC addresses
+	if cfg.oui.file != "" {
+		o, err := oui.Load(cfg.oui.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load OUI rules: %w", err))
+		}
+		log.Info("loaded OUI rules", "file", cfg.oui.file, "rules", len(o.Rules))
+		cfg.ouiRules = o
+	}
+
+	// console detection rules of the first stage auto.ipxe script
+	var consoleRules []script.ConsoleRule
+	if cfg.ipxeHTTPScript.consoleDetect {
+		consoleRules = script.DefaultConsoleRules
+		if cfg.ipxeHTTPScript.consoleRulesFile != "" {
+			r, err := script.LoadConsoleRules(cfg.ipxeHTTPScript.consoleRulesFile)
+			if err != nil {
+				panic(fmt.Errorf("failed to load console rules: %w", err))
+			}
+			log.Info("loaded console rules", "file", cfg.ipxeHTTPScript.consoleRulesFile, "rules", len(r))
+			consoleRules = r
+		}
+	}
+
+	// health checked boot server candidates
+	if cfg.mirror.file != "" {
+		m, err := mirror.Load(cfg.mirror.file)
+		if err != nil {
+			panic(fmt.Errorf("failed to load mirror groups: %w", err))
+		}
+		// the candidates are health checked like the ISO handler proxies to them.
+		if tc := cfg.tls.global.Merge(cfg.tls.iso); !tc.IsZero() {
+			t, err := tc.Transport()
+			if err != nil {
+				panic(fmt.Errorf("invalid mirror TLS configuration: %w", err))
+			}
+			m.Transport = t
+		}
+		log.Info("loaded mirror groups", "file", cfg.mirror.file, "groups", len(m.Groups))
+		cfg.mirrors = m
+		g.Go("mirror", func() error {
+			return m.Run(ctx, log.WithName("mirror"))
+		})
+	}
+
+	// runtime settings
+	var sr settings.Reader
+	if cfg.settings.kubeConfigMap != "" {
+		sw, err := cfg.backends.kubernetes.settingsWatcher(cfg.settings.kubeConfigMap, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to create settings watcher: %w", err))
+		}
+		g.Go("settings", func() error {
+			return sw.Start(ctx)
+		})
+		sr = sw
+		cfg.maintenanceSwitch.UseSettings(sw)
+	}
+
 	handlers := http.HandlerMapping{}
+	// fetches records the files machines fetch over HTTP in their boot sessions, for the admin API.
+	fetches := &admin.FetchRecorder{Events: cfg.events}
 	// http ipxe binaries
 	if cfg.ipxeHTTPBinary.enabled {
 		// serve ipxe binaries from the "/ipxe/" URI.
-		handlers["/ipxe/"] = ihttp.Handler{
-			Log:   log.WithValues("service", "github.com/tinkerbell/smee").WithName("github.com/tinkerbell/ipxedust"),
+		// the binaries in the binary directory take precedence over the embedded ones,
+		// the bindir handler also sets the ETag of the embedded ones so that their downloads can be resumed.
+		handlers["/ipxe/"] = (&bindir.Handler{
+			Dir:   cfg.tftp.binaryDir,
 			Patch: []byte(cfg.tftp.ipxeScriptPatch),
-		}.Handle
+			Trust: cfg.tftp.ipxeTrust,
+			Log:   log.WithValues("service", "github.com/tinkerbell/smee").WithName("bindir"),
+			HTTPFallback: ihttp.Handler{
+				Log:   log.WithValues("service", "github.com/tinkerbell/smee").WithName("github.com/tinkerbell/ipxedust"),
+				Patch: []byte(cfg.tftp.ipxeScriptPatch),
+			}.Handle,
+		}).ServeHTTP
+	}
+
+	// OSIE kernels and initrds of several versions and architectures
+	if cfg.ipxeHTTPScript.osieDir != "" {
+		handlers["/osie/"] = (&osie.Handler{
+			Dir: cfg.ipxeHTTPScript.osieDir,
+			Log: log.WithValues("service", "github.com/tinkerbell/smee").WithName("osie"),
+		}).ServeHTTP
+	}
+
+	// bmc netboot orchestration
+	var orchestrator *bmc.Orchestrator
+	if cfg.bmc.enabled {
+		o, err := cfg.orchestrator(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to create bmc orchestrator: %w", err))
+		}
+		orchestrator = o
+		handlers["/bmc/"] = orchestrator.HandlerFunc(parsePrefixes(cfg.bmc.allowedCIDRs))
+	}
+
+	// reprovisioning campaigns
+	var campaigns *campaign.Runner
+	if cfg.campaigns != nil && orchestrator != nil {
+		kc, err := cfg.kubeClient(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to run the campaigns: %w", err))
+		}
+		k := &campaign.Kube{Client: kc, Namespace: cfg.backends.kubernetes.Namespace}
+		campaigns = &campaign.Runner{
+			Config:    cfg.campaigns,
+			Preparer:  k,
+			Netbooter: orchestrator,
+			Lister:    k,
+			PhoneHome: cfg.phoneHome.enabled,
+			StateFile: cfg.campaign.stateFile,
+			Log:       log.WithName("campaign"),
+		}
+		handlers["/campaigns"] = campaigns.HandlerFunc(parsePrefixes(cfg.bmc.allowedCIDRs))
+		handlers["/campaigns/"] = handlers["/campaigns"]
+		g.Go("campaign", func() error {
+			return campaigns.Run(ctx)
+		})
+	}
+
+	// instance metadata
+	if cfg.metadata.enabled {
+		br, err := cfg.backend(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to create backend: %w", err))
+		}
+		mh := &metadata.Handler{Backend: cfg.handlerBackend(br), Log: log.WithName("metadata"), TemplateEnv: cfg.template.env()}
+		if cfg.metadata.vendorDataFile != "" {
+			b, err := os.ReadFile(cfg.metadata.vendorDataFile)
+			if err != nil {
+				panic(fmt.Errorf("failed to read the vendor-data template: %w", err))
+			}
+			mh.VendorData = string(b)
+		}
+		handlers[metadata.Prefix] = mh.HandlerFunc()
+		handlers[metadata.NoCloudPrefix] = mh.NoCloudHandlerFunc()
+	}
+
+	// cluster bootstrap documents
+	if cfg.bootstrap.dir != "" {
+		br, err := cfg.backend(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to create backend: %w", err))
+		}
+		docs, err := bootstrap.Load(cfg.bootstrap.dir)
+		if err != nil {
+			panic(fmt.Errorf("failed to load the bootstrap documents: %w", err))
+		}
+		handlers[bootstrap.Prefix] = (&bootstrap.Handler{
+			Backend:    cfg.handlerBackend(br),
+			Documents:  docs,
+			SecretsDir: cfg.bootstrap.secretsDir,
+			HTTPServer: (&url.URL{
+				Scheme: cfg.dhcp.httpIpxeScript.Scheme,
+				Host:   net.JoinHostPort(cfg.dhcp.httpIpxeScript.Host, strconv.Itoa(cfg.dhcp.httpIpxeScript.Port)),
+			}).String(),
+			TemplateEnv: cfg.template.env(),
+			Log:         log.WithName("bootstrap"),
+		}).ServeHTTP
+	}
+
+	// u-boot boot scripts over http
+	if cfg.tftp.uboot != nil {
+		handlers[uboot.Prefix] = cfg.tftp.uboot.ServeHTTP
+	}
+
+	// netboot disabled after a number of serves
+	var serveLimit *phonehome.ServeLimit
+	if cfg.writeback.netbootDisableAfter > 0 {
+		kc, err := cfg.kubeClient(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to enable disabling netboot after %d serves: %w", cfg.writeback.netbootDisableAfter, err))
+		}
+		serveLimit = &phonehome.ServeLimit{Max: cfg.writeback.netbootDisableAfter, Disabler: &phonehome.Kube{Client: kc}, Log: log.WithName("writeback")}
+	}
+
+	// boot success signal
+	var phoneHomeTokens *phonehome.Tokens
+	if cfg.phoneHome.enabled {
+		key, err := readKey(cfg.phoneHome.keyFile, "phone home token key")
+		if err != nil {
+			panic(err)
+		}
+		phoneHomeTokens = &phonehome.Tokens{Key: key, TTL: cfg.phoneHome.tokenTTL}
+		ph := &phonehome.Handler{Tokens: phoneHomeTokens, Observers: []phonehome.Observer{cfg.events}, Log: log.WithName("phone-home")}
+		if cfg.phoneHome.disableNetboot {
+			kc, err := cfg.kubeClient(ctx, log)
+			if err != nil {
+				panic(fmt.Errorf("failed to enable disabling netboot on phone home: %w", err))
+			}
+			ph.Disabler = &phonehome.Kube{Client: kc}
+		}
+		if serveLimit != nil {
+			ph.Observers = append(ph.Observers, serveLimit)
+		}
+		if campaigns != nil {
+			ph.Observers = append(ph.Observers, campaigns)
+		}
+		if cfg.bootLog != nil {
+			ph.Observers = append(ph.Observers, cfg.bootLog)
+			bh := &bootlog.Handler{Log: cfg.bootLog, Tokens: phoneHomeTokens, Logger: log.WithName("bootlog")}
+			handlers["/bootlog"] = bh.HandlerFunc()
+		}
+		if cfg.bootSLOs != nil {
+			ph.Observers = append(ph.Observers, cfg.bootSLOs)
+		}
+		handlers["/phone-home/"] = ph.HandlerFunc()
+	}
+
+	// inventory fact ingestion, the phone home token of a machine authorizes the submission of its facts.
+	if cfg.inventory.enabled {
+		iw, err := cfg.inventoryWriter(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to enable inventory fact ingestion: %w", err))
+		}
+		ih := &inventory.Handler{Writer: iw, Tokens: phoneHomeTokens, Log: log.WithName("inventory")}
+		handlers["/inventory/"] = ih.HandlerFunc(parsePrefixes(cfg.inventory.allowedCIDRs))
+	}
+
+	// selfTestDHCP is the dhcp handler that the self-test runs its dhcp transaction through.
+	var selfTestDHCP selftest.Replier
+	// bootDHCP is the dhcp handler whose replies the admin api returns in the boot configuration of a machine.
+	var bootDHCP admin.DHCPReplier
+
+	// dns records of the leased reservations
+	if cfg.dhcp.enabled && cfg.dns.enabled {
+		r, err := cfg.dnsRegistrar(ctx, log)
+		if err != nil {
+			panic(fmt.Errorf("failed to enable dns registration: %w", err))
+		}
+		cfg.registrar = r
+		g.Go("dns-registrar", func() error {
+			r.Start(ctx, time.Minute)
+			return nil
+		})
+	}
+
+	// kea host reservation lookups, smee doesn't serve dhcp itself in this mode.
+	if cfg.dhcp.enabled && dhcpMode(cfg.dhcp.mode) == dhcpModeKea {
+		dh, err := cfg.dhcpHandler(ctx, log, pol)
+		if err != nil {
+			panic(fmt.Errorf("failed to create kea handler: %w", err))
+		}
+		r, ok := dh.(kea.Replier)
+		if !ok {
+			panic(errors.New("the dhcp handler does not support the kea dhcp mode"))
+		}
+		// the self-test expects a leased address, only the reservation handler leases them.
+		if rh, ok := dh.(*reservation.Handler); ok {
+			selfTestDHCP = rh
+		}
+		bootDHCP, _ = dh.(admin.DHCPReplier)
+		kh := &kea.Handler{Replier: r, Log: log.WithName("kea")}
+		handlers[kea.Prefix] = kh.HandlerFunc()
+	}
+
+	// signed iso urls
+	isoSigner, err := cfg.isoSigner()
+	if err != nil {
+		panic(err)
 	}
 
 	// http ipxe script
+	var grubConfig secureboot.Configurer
+	var esxiConfig esxi.Configurer
+	var renderer admin.Renderer
 	if cfg.ipxeHTTPScript.enabled {
 		br, err := cfg.backend(ctx, log)
 		if err != nil {
 			panic(fmt.Errorf("failed to create backend: %w", err))
 		}
+		fetches.Backend = br
 		jh := script.Handler{
 			Logger:                log,
-			Backend:               br,
+			Backend:               cfg.handlerBackend(br),
 			OSIEURL:               cfg.ipxeHTTPScript.hookURL,
 			ExtraKernelParams:     strings.Split(cfg.ipxeHTTPScript.extraKernelArgs, " "),
 			PublicSyslogFQDN:      cfg.dhcp.syslogIP,
@@ -243,11 +1500,194 @@ func main() {
 			TinkServerGRPCAddr:    cfg.ipxeHTTPScript.tinkServer,
 			IPXEScriptRetries:     cfg.ipxeHTTPScript.retries,
 			IPXEScriptRetryDelay:  cfg.ipxeHTTPScript.retryDelay,
+			Validate:              cfg.ipxeHTTPScript.validate,
+			MaxScriptSize:         cfg.ipxeHTTPScript.maxSize,
 			StaticIPXEEnabled:     (dhcpMode(cfg.dhcp.mode) == dhcpModeAutoProxy),
+			Settings:              sr,
+			Policy:                pol,
+			Facilities:            cfg.facilities,
+			Tenants:               cfg.tenants,
+			Rollout:               cfg.osieTracks,
+			Guard:                 rolloutGuard,
+			Snapshots:             cfg.snapshots,
+			Profiles:              cfg.profiles,
+			OUIRules:              cfg.ouiRules,
+			Mirrors:               cfg.mirrors,
+			BootTraces:            cfg.bootTraces,
+			TwoStage:              cfg.ipxeHTTPScript.twoStage,
+			ConsoleRules:          consoleRules,
+			ClientIdentifiers:     clientIdentifiers,
+			TemplateEnv:           cfg.template.env(),
+		}
+		switch {
+		case cfg.addresses != nil:
+			jh.Leases = cfg.addresses
+		case cfg.leases != nil:
+			jh.Leases = cfg.leases
+		}
+		if isoSigner != nil {
+			base := &url.URL{
+				Scheme: cfg.dhcp.httpIpxeScript.Scheme,
+				Host:   net.JoinHostPort(cfg.dhcp.httpIpxeScript.Host, strconv.Itoa(cfg.dhcp.httpIpxeScript.Port)),
+				Path:   "/iso",
+			}
+			jh.ISOURL = func(mac net.HardwareAddr) string {
+				return isoSigner.URL(base, mac, time.Now()).String()
+			}
+		}
+		if phoneHomeTokens != nil {
+			base := &url.URL{
+				Scheme: cfg.dhcp.httpIpxeScript.Scheme,
+				Host:   net.JoinHostPort(cfg.dhcp.httpIpxeScript.Host, strconv.Itoa(cfg.dhcp.httpIpxeScript.Port)),
+				Path:   "/phone-home",
+			}
+			jh.PhoneHomeURL = func(mac net.HardwareAddr) string {
+				return base.JoinPath(phoneHomeTokens.Token(mac, time.Now())).String()
+			}
+		}
+		if orchestrator != nil {
+			jh.Observers = append(jh.Observers, orchestrator)
+		}
+		if serveLimit != nil {
+			jh.Observers = append(jh.Observers, serveLimit)
+		}
+		if campaigns != nil {
+			jh.Observers = append(jh.Observers, campaigns)
+		}
+		if cfg.ipxeHTTPScript.tinkHandoffTimeout > 0 {
+			v, err := cfg.handoffVerifier(ctx, log)
+			if err != nil {
+				panic(fmt.Errorf("failed to enable tink server handoff verification: %w", err))
+			}
+			jh.Observers = append(jh.Observers, v)
+		}
+		if cfg.admin.addr != "" {
+			jh.Observers = append(jh.Observers, cfg.events)
+		}
+		if cfg.bootLog != nil {
+			jh.Observers = append(jh.Observers, cfg.bootLog)
+		}
+		if cfg.bootSLOs != nil {
+			jh.Observers = append(jh.Observers, cfg.bootSLOs)
+		}
+		if cfg.netbootQuota != nil {
+			jh.Observers = append(jh.Observers, cfg.netbootQuota)
+		}
+		if cfg.plugin.scriptGenerator != "" {
+			pc, err := cfg.plugins.Client(ctx, log, cfg.plugin.scriptGenerator)
+			if err != nil {
+				panic(fmt.Errorf("failed to start script generator plugin: %w", err))
+			}
+			sg, err := pc.ScriptGenerator()
+			if err != nil {
+				panic(fmt.Errorf("failed to start script generator plugin: %w", err))
+			}
+			jh.Generator = sg
+		}
+		if cfg.backends.kubernetes.EnrollDiscovered {
+			e, err := cfg.enroller(br)
+			if err != nil {
+				panic(fmt.Errorf("failed to enable enrollment of discovered machines: %w", err))
+			}
+			jh.Enroller = e
+		}
+		if cfg.ipxeHTTPScript.fallbackFile != "" {
+			b, err := os.ReadFile(cfg.ipxeHTTPScript.fallbackFile)
+			if err != nil {
+				panic(fmt.Errorf("failed to read the fallback ipxe script: %w", err))
+			}
+			if err := script.ValidateFallback(string(b), cfg.template.env()); err != nil {
+				panic(fmt.Errorf("invalid fallback ipxe script: %w", err))
+			}
+			jh.FallbackScript = string(b)
 		}
 
 		// serve ipxe script from the "/" URI.
-		handlers["/"] = jh.HandlerFunc()
+		// the scripts are buffered to serve them with a Content-Length, and HEAD and Range support.
+		handlers["/"] = httpfile.Buffered(jh.HandlerFunc())
+		if cfg.shadow.httpURL != "" {
+			sh, err := cfg.shadowHTTP(log)
+			if err != nil {
+				panic(fmt.Errorf("failed to enable http shadowing: %w", err))
+			}
+			handlers["/"] = sh.Handler(handlers["/"])
+		}
+		if cfg.faults != nil {
+			handlers["/"] = cfg.faults.DelayScript(handlers["/"])
+		}
+		grubConfig = &jh
+		esxiConfig = &jh
+		renderer = &jh
+	}
+
+	// secure boot
+	var sb *secureboot.Handler
+	if cfg.secureBoot.enabled {
+		if cfg.secureBoot.dir == "" {
+			panic(errors.New("a secure boot directory is required when secure boot is enabled"))
+		}
+		sb = &secureboot.Handler{
+			Dir:          cfg.secureBoot.dir,
+			Log:          log.WithValues("service", "github.com/tinkerbell/smee").WithName("secureboot"),
+			HTTPFallback: handlers["/ipxe/"],
+			TFTPFallback: cfg.tftp.readHandler(log.WithValues("service", "github.com/tinkerbell/smee").WithName("github.com/tinkerbell/ipxedust")),
+			Config:       grubConfig,
+			TFTPSessions: limit.NewLimiter(cfg.tftp.maxSessions),
+		}
+		// shim, GRUB and the GRUB configs are served alongside the ipxe binaries from the "/ipxe/" URI.
+		handlers["/ipxe/"] = sb.ServeHTTP
+		if cfg.tftp.enabled && cfg.esxi.dir == "" {
+			addr, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", cfg.tftp.bindAddr, cfg.tftp.bindPort))
+			if err != nil {
+				panic(fmt.Errorf("invalid bind address: %w", err))
+			}
+			log.Info("starting tftp server", "bind_addr", addr, "secureBoot", true)
+			g.Go("tftp", func() error {
+				conn, err := cfg.tftp.listen(cfg.sockets, addr)
+				if err != nil {
+					return err
+				}
+				return sb.ServeTFTP(ctx, conn, cfg.tftp.timeout, cfg.tftp.blockSize)
+			})
+		}
+	}
+
+	// esxi
+	if cfg.esxi.dir != "" {
+		eh := &esxi.Handler{
+			Dir: cfg.esxi.dir,
+			Prefix: (&url.URL{
+				Scheme: cfg.dhcp.httpIpxeScript.Scheme,
+				Host:   net.JoinHostPort(cfg.dhcp.httpIpxeScript.Host, strconv.Itoa(cfg.dhcp.httpIpxeScript.Port)),
+				Path:   "/esxi/",
+			}).String(),
+			Config:       esxiConfig,
+			Log:          log.WithValues("service", "github.com/tinkerbell/smee").WithName("esxi"),
+			HTTPFallback: handlers["/ipxe/"],
+			TFTPFallback: cfg.tftp.readHandler(log.WithValues("service", "github.com/tinkerbell/smee").WithName("github.com/tinkerbell/ipxedust")),
+			TFTPSessions: limit.NewLimiter(cfg.tftp.maxSessions),
+		}
+		if sb != nil {
+			eh.TFTPFallback = sb.HandleRead
+		}
+		// mboot.efi and the boot configs are served alongside the ipxe binaries from the "/ipxe/" URI,
+		// the kernel and the modules from the "/esxi/" URI, the prefix of the boot configs.
+		handlers["/ipxe/"] = eh.ServeHTTP
+		handlers["/esxi/"] = eh.ServeHTTP
+		if cfg.tftp.enabled {
+			addr, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", cfg.tftp.bindAddr, cfg.tftp.bindPort))
+			if err != nil {
+				panic(fmt.Errorf("invalid bind address: %w", err))
+			}
+			log.Info("starting tftp server", "bind_addr", addr, "esxi", true)
+			g.Go("tftp", func() error {
+				conn, err := cfg.tftp.listen(cfg.sockets, addr)
+				if err != nil {
+					return err
+				}
+				return eh.ServeTFTP(ctx, conn, cfg.tftp.timeout, cfg.tftp.blockSize)
+			})
+		}
 	}
 
 	if cfg.iso.enabled {
@@ -257,13 +1697,27 @@ func main() {
 		}
 		ih := iso.Handler{
 			Logger:             log,
-			Backend:            br,
+			Backend:            cfg.handlerBackend(br),
 			SourceISO:          cfg.iso.url,
 			ExtraKernelParams:  strings.Split(cfg.ipxeHTTPScript.extraKernelArgs, " "),
 			Syslog:             cfg.dhcp.syslogIP,
 			TinkServerTLS:      cfg.ipxeHTTPScript.tinkServerUseTLS,
 			TinkServerGRPCAddr: cfg.ipxeHTTPScript.tinkServer,
 			StaticIPAMEnabled:  cfg.iso.staticIPAMEnabled,
+			Settings:           sr,
+			Policy:             pol,
+			Facilities:         cfg.facilities,
+			Tenants:            cfg.tenants,
+			BootTraces:         cfg.bootTraces,
+			Signer:             isoSigner,
+			BufferSize:         cfg.iso.bufferSize,
+			Streams:            limit.NewFairQueue(cfg.iso.maxStreams),
+			StreamWait:         cfg.iso.streamWait,
+			Parallelism:        cfg.iso.upstreamParallelism,
+			ChunkSize:          cfg.iso.upstreamChunkSize,
+			Verify:             cfg.iso.verifyUpstream,
+			TemplateEnv:        cfg.template.env(),
+			Cmdline:            cfg.iso.buildCmdline,
 			MagicString: func() string {
 				if cfg.iso.magicString == "" {
 					return magicString
@@ -271,14 +1725,68 @@ func main() {
 				return cfg.iso.magicString
 			}(),
 		}
+		if serveLimit != nil {
+			ih.Observers = append(ih.Observers, serveLimit)
+		}
+		if cfg.bootSLOs != nil {
+			ih.Observers = append(ih.Observers, cfg.bootSLOs)
+		}
+		if cfg.iso.buildKernel != "" {
+			built, err := build.Build(build.Config{Kernel: cfg.iso.buildKernel, Initrd: cfg.iso.buildInitrd, Loader: cfg.iso.buildLoader})
+			if err != nil {
+				panic(fmt.Errorf("failed to build the ISO: %w", err))
+			}
+			defer built.Close()
+			log.Info("built the ISO", "kernel", cfg.iso.buildKernel, "initrd", cfg.iso.buildInitrd, "loader", cfg.iso.buildLoader, "size", built.Size())
+			ih.Built = built
+		}
+		if ih.Replicas, ih.Self, err = cfg.iso.replicas(); err != nil {
+			panic(fmt.Errorf("invalid ISO replicas: %w", err))
+		}
+		ut := upstream.New("iso", cfg.upstream.global.Merge(cfg.upstream.iso), nil)
+		if tc := cfg.tls.global.Merge(cfg.tls.iso); !tc.IsZero() {
+			t, err := tc.Transport()
+			if err != nil {
+				panic(fmt.Errorf("invalid ISO TLS configuration: %w", err))
+			}
+			ut.Base = t
+		}
+		ih.Transport = ut
+		if cfg.iso.indexURL != "" {
+			ih.Index = &iso.Index{
+				URL:       cfg.iso.indexURL,
+				Version:   cfg.iso.indexVersion,
+				Channel:   cfg.iso.indexChannel,
+				Interval:  cfg.iso.indexInterval,
+				Transport: ih.Transport,
+				Logger:    log,
+			}
+			g.Go("iso-index", func() error {
+				return ih.Index.Start(ctx)
+			})
+		}
 		isoHandler, err := ih.HandlerFunc()
 		if err != nil {
 			panic(fmt.Errorf("failed to create iso handler: %w", err))
 		}
 		handlers["/iso/"] = isoHandler
+		if cfg.faults != nil {
+			handlers["/iso/"] = cfg.faults.CorruptISO(handlers["/iso/"])
+		}
 	}
 
 	if len(handlers) > 0 {
+		// the OSIE and ISO downloads, and the iPXE binaries, are not bound, they take as long as the network needs.
+		for _, prefix := range []string{"/", metadata.Prefix, metadata.NoCloudPrefix, "/inventory/", "/bmc/", "/phone-home/", kea.Prefix, uboot.Prefix, bootstrap.Prefix} {
+			if h, ok := handlers[prefix]; ok {
+				handlers[prefix] = deadline.Handler(h, cfg.timeout.http)
+			}
+		}
+		for _, prefix := range []string{"/", "/ipxe/", "/osie/", "/iso/"} {
+			if h, ok := handlers[prefix]; ok {
+				handlers[prefix] = fetches.Handler(h)
+			}
+		}
 		// start the http server for ipxe binaries and scripts
 		tp := parseTrustedProxies(cfg.ipxeHTTPScript.trustedProxies)
 		httpServer := &http.Config{
@@ -286,39 +1794,164 @@ func main() {
 			StartTime:      startTime,
 			Logger:         log,
 			TrustedProxies: tp,
+			Ready:          cfg.readiness.Ready,
+			Services:       g.Status,
+			MaxConnections: cfg.ipxeHTTPScript.maxConnections,
+			Wrap:           cfg.tenants.Handler,
+			Listen: func(addr string) (net.Listener, error) {
+				return cfg.sockets.Listener(sockets.HTTP, cfg.ipxeHTTPScript.bindPort, func() (net.Listener, error) {
+					return net.Listen("tcp", addr)
+				})
+			},
 		}
 		bindAddr := fmt.Sprintf("%s:%d", cfg.ipxeHTTPScript.bindAddr, cfg.ipxeHTTPScript.bindPort)
 		log.Info("serving http", "addr", bindAddr, "trusted_proxies", tp)
-		g.Go(func() error {
+		g.Go("http", func() error {
 			return httpServer.ServeHTTP(ctx, bindAddr, handlers)
 		})
+		if cfg.https.port > 0 {
+			// the chain that is served holds the ipxe ca, the patched ipxe binaries only validate chains that hold it.
+			cert, err := cfg.tftp.ipxeTrust.ServerCertificate(cfg.https.certFile, cfg.https.keyFile)
+			if err != nil {
+				panic(fmt.Errorf("failed to load the https certificate: %w", err))
+			}
+			httpsServer := *httpServer
+			httpsServer.Listen = nil
+			httpsServer.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+			httpsAddr := fmt.Sprintf("%s:%d", cfg.ipxeHTTPScript.bindAddr, cfg.https.port)
+			log.Info("serving https", "addr", httpsAddr)
+			g.Go("https", func() error {
+				return httpsServer.ServeHTTP(ctx, httpsAddr, handlers)
+			})
+		}
 	}
 
 	// dhcp serving
-	if cfg.dhcp.enabled {
-		dh, err := cfg.dhcpHandler(ctx, log)
+	if cfg.dhcp.enabled && dhcpMode(cfg.dhcp.mode) != dhcpModeKea {
+		dh, err := cfg.dhcpHandler(ctx, log, pol)
 		if err != nil {
 			log.Error(err, "failed to create dhcp listener")
 			panic(fmt.Errorf("failed to create dhcp listener: %w", err))
 		}
+		if rh, ok := dh.(*reservation.Handler); ok {
+			selfTestDHCP = rh
+		}
+		bootDHCP, _ = dh.(admin.DHCPReplier)
+		dhs := []server.Handler{dh}
+		if cfg.shadow.dhcpAddr != "" {
+			sd, err := cfg.shadowDHCP(log, dh)
+			if err != nil {
+				panic(fmt.Errorf("failed to enable dhcp shadowing: %w", err))
+			}
+			dhs = append(dhs, sd)
+		}
 		log.Info("starting dhcp server", "bind_addr", cfg.dhcp.bindAddr)
-		g.Go(func() error {
+		g.Go("dhcp", func() error {
 			bindAddr, err := netip.ParseAddrPort(cfg.dhcp.bindAddr)
 			if err != nil {
 				panic(fmt.Errorf("invalid tftp address for DHCP server: %w", err))
 			}
-			conn, err := server4.NewIPv4UDPConn(cfg.dhcp.bindInterface, net.UDPAddrFromAddrPort(bindAddr))
+			if cfg.dhcp.raw {
+				link, err := cfg.dhcpLink(int(bindAddr.Port()))
+				if err != nil {
+					return fmt.Errorf("failed to listen for dhcp on a raw socket: %w", err)
+				}
+				ds := &server.DHCP{Logger: log, Link: link, Handlers: dhs, Workers: cfg.dhcp.workers, QueueSize: cfg.dhcp.queueSize, Timeout: cfg.timeout.dhcp}
+
+				return ds.Serve(ctx)
+			}
+			conn, err := cfg.sockets.PacketConn(sockets.DHCP, int(bindAddr.Port()), func() (net.PacketConn, error) {
+				return server4.NewIPv4UDPConn(cfg.dhcp.bindInterface, net.UDPAddrFromAddrPort(bindAddr))
+			})
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("failed to listen for dhcp: %w", err)
 			}
 			defer conn.Close()
-			ds := &server.DHCP{Logger: log, Conn: conn, Handlers: []server.Handler{dh}}
+			ds := &server.DHCP{Logger: log, Conn: conn, Handlers: dhs, Workers: cfg.dhcp.workers, QueueSize: cfg.dhcp.queueSize, Timeout: cfg.timeout.dhcp}
+
+			return ds.Serve(ctx)
+		})
+		if cfg.dhcp.windowsCompat {
+			// PXE clients that were sent a boot server by the Windows DHCP server discover it on port 4011.
+			log.Info("answering PXE boot server discovery", "port", pxeBootServerPort)
+			g.Go("dhcp-pxe", func() error {
+				bindAddr, err := netip.ParseAddrPort(cfg.dhcp.bindAddr)
+				if err != nil {
+					return fmt.Errorf("invalid address for PXE boot server discovery: %w", err)
+				}
+				conn, err := cfg.sockets.PacketConn(sockets.PXE, pxeBootServerPort, func() (net.PacketConn, error) {
+					return server4.NewIPv4UDPConn(cfg.dhcp.bindInterface, net.UDPAddrFromAddrPort(netip.AddrPortFrom(bindAddr.Addr(), pxeBootServerPort)))
+				})
+				if err != nil {
+					return fmt.Errorf("failed to listen for PXE boot server discovery: %w", err)
+				}
+				defer conn.Close()
+				ds := &server.DHCP{Logger: log, Conn: conn, Handlers: []server.Handler{dh}, Workers: cfg.dhcp.workers, QueueSize: cfg.dhcp.queueSize, Timeout: cfg.timeout.dhcp}
+
+				return ds.Serve(ctx)
+			})
+		}
+		if cfg.vlans != nil {
+			if err := cfg.serveVLANs(ctx, g, log, pol); err != nil {
+				panic(fmt.Errorf("failed to create dhcp listeners on vlans: %w", err))
+			}
+		}
+	}
+
+	// self-test
+	var selfTest *selftest.Runner
+	if cfg.selfTest.mac != "" {
+		selfTest = cfg.selfTestRunner(log, selfTestDHCP, isoSigner)
+		cfg.readiness.add(selfTest.Ready)
+		g.Go("self-test", func() error {
+			return selfTest.Start(ctx)
+		})
+	}
+
+	// admin api
+	if cfg.admin.addr != "" {
+		as, err := cfg.adminServer(ctx, log, renderer)
+		if err != nil {
+			panic(fmt.Errorf("failed to create admin api: %w", err))
+		}
+		as.Services = g.Status
+		as.DHCP = bootDHCP
+		if selfTest != nil {
+			as.SelfTest = selfTest
+		}
+		l, err := admin.Listen(cfg.admin.addr)
+		if err != nil {
+			panic(fmt.Errorf("failed to listen for the admin api: %w", err))
+		}
+		log.Info("serving admin api", "addr", cfg.admin.addr)
+		g.Go("admin", func() error {
+			// Serve closes the listener, a restarted admin api listens again.
+			if l == nil {
+				nl, err := admin.Listen(cfg.admin.addr)
+				if err != nil {
+					return fmt.Errorf("failed to listen for the admin api: %w", err)
+				}
+				l = nl
+			}
+			defer func() { l = nil }()
+			return as.Serve(ctx, l)
+		})
+	}
 
-			return ds.Serve(ctx)
+	// mdns
+	if cfg.mdns.enabled {
+		r, err := cfg.mdnsResponder(log, len(handlers) > 0)
+		if err != nil {
+			panic(fmt.Errorf("failed to advertise the services with mdns: %w", err))
+		}
+		g.Go("mdns", func() error {
+			return r.Run(ctx)
 		})
 	}
 
-	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+	err = g.Wait()
+	cfg.plugins.Close()
+	if err != nil && !errors.Is(err, context.Canceled) {
 		log.Error(err, "failed running all Smee services")
 		panic(err)
 	}
@@ -335,8 +1968,18 @@ func numTrue(b ...bool) int {
 	return n
 }
 
+// backend returns the backend that is shared by all of the services, it is created on the first call with ctx.
 func (c *config) backend(ctx context.Context, log logr.Logger) (handler.BackendReader, error) {
-	if c.backends.file.Enabled || c.backends.Noop.Enabled {
+	if c.shared == nil {
+		return c.newBackend(ctx, log)
+	}
+	c.shared.once.Do(func() { c.shared.br, c.shared.err = c.newBackend(ctx, log) })
+
+	return c.shared.br, c.shared.err
+}
+
+func (c *config) newBackend(ctx context.Context, log logr.Logger) (handler.BackendReader, error) {
+	if c.backends.file.Enabled || c.backends.Noop.Enabled || c.backends.plugin.Enabled || c.backends.replay.Enabled {
 		// the kubernetes backend is enabled by default so we disable it
 		// if another backend is enabled so that users don't have to explicitly
 		// set the CLI flag to disable it when using another backend.
@@ -344,57 +1987,361 @@ func (c *config) backend(ctx context.Context, log logr.Logger) (handler.BackendR
 	}
 	var be handler.BackendReader
 	switch {
-	case numTrue(c.backends.file.Enabled, c.backends.kubernetes.Enabled, c.backends.Noop.Enabled) > 1:
+	case numTrue(c.backends.file.Enabled, c.backends.kubernetes.Enabled, c.backends.Noop.Enabled, c.backends.plugin.Enabled, c.backends.replay.Enabled) > 1:
 		return nil, errors.New("only one backend can be enabled at a time")
 	case c.backends.Noop.Enabled:
 		if c.dhcp.mode != string(dhcpModeAutoProxy) {
 			return nil, errors.New("noop backend can only be used with --dhcp-mode=auto-proxy")
 		}
-		be = c.backends.Noop.backend()
+		b, err := c.backends.Noop.backend()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create noop backend: %w", err)
+		}
+		be = b
 	case c.backends.file.Enabled:
 		b, err := c.backends.file.backend(ctx, log)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create file backend: %w", err)
 		}
+		c.caches.Add("backend-file", b.Reload)
 		be = b
-	default: // default backend is kubernetes
-		b, err := c.backends.kubernetes.backend(ctx)
+	case c.backends.plugin.Enabled:
+		b, err := c.backends.plugin.backend(ctx, log, c.plugins)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create plugin backend: %w", err)
+		}
+		be = b
+	case c.backends.replay.Enabled:
+		b, err := c.backends.replay.backend()
 		if err != nil {
-			return nil, fmt.Errorf("failed to create kubernetes backend: %w", err)
+			return nil, fmt.Errorf("failed to create replay backend: %w", err)
 		}
 		be = b
+	default: // default backend is kubernetes
+		b := c.backends.kubernetes.backend(ctx, log)
+		c.readiness.add(b.Ready)
+		be = b
 	}
 
 	return be, nil
 }
 
-func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (server.Handler, error) {
-	// 1. create the handler
-	// 2. create the backend
-	// 3. add the backend to the handler
-	pktIP, err := netip.ParseAddr(c.dhcp.ipForPacket)
+// enroller returns the backend as a script.Enroller.
+// Enrollment is only supported with the kubernetes backend in auto-proxy mode.
+func (c *config) enroller(br handler.BackendReader) (script.Enroller, error) {
+	if dhcpMode(c.dhcp.mode) != dhcpModeAutoProxy {
+		return nil, errors.New("enrollment of discovered machines can only be used with --dhcp-mode=auto-proxy")
+	}
+	e, ok := br.(script.Enroller)
+	if !ok || !c.backends.kubernetes.Enabled {
+		return nil, errors.New("enrollment of discovered machines can only be used with the kubernetes backend")
+	}
+
+	return e, nil
+}
+
+// handlerBackend returns br as the DHCP and HTTP handlers use it, with its lookups recorded, the policy of the locally
+// administered MAC addresses, the staleness policy, the netboot quota and the maintenance of its records, scoped to the tenant of the request and with the deadline of the backend lookups.
+func (c *config) handlerBackend(br handler.BackendReader) handler.BackendReader {
+	return deadline.Backend(c.tenants.Scope(c.maintenanceSwitch.Backend(c.netbootQuota.Backend(c.stale.Backend(c.localMACs.Backend(c.recorder.Backend(br)))))), c.timeout.backend)
+}
+
+// orchestrator returns a bmc.Orchestrator that uses the kubernetes backend client.
+func (c *config) orchestrator(ctx context.Context, log logr.Logger) (*bmc.Orchestrator, error) {
+	kc, err := c.kubeClient(ctx, log)
 	if err != nil {
-		return nil, fmt.Errorf("invalid bind address: %w", err)
+		return nil, fmt.Errorf("bmc netboot orchestration: %w", err)
 	}
-	tftpIP, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", c.dhcp.tftpIP, c.dhcp.tftpPort))
+
+	return &bmc.Orchestrator{Client: kc, Log: log.WithName("bmc")}, nil
+}
+
+// inventoryWriter returns the inventory.Writer of the facts directory, when set, otherwise one that uses the kubernetes backend client.
+func (c *config) inventoryWriter(ctx context.Context, log logr.Logger) (inventory.Writer, error) {
+	if c.inventory.factsDir != "" {
+		return &inventory.Dir{Path: c.inventory.factsDir}, nil
+	}
+	kc, err := c.kubeClient(ctx, log)
 	if err != nil {
-		return nil, fmt.Errorf("invalid tftp address for DHCP server: %w", err)
+		return nil, fmt.Errorf("set inventory-facts-dir or use the kubernetes backend: %w", err)
 	}
-	httpBinaryURL := &url.URL{
-		Scheme: c.dhcp.httpIpxeBinaryURL.Scheme,
-		Host:   fmt.Sprintf("%s:%d", c.dhcp.httpIpxeBinaryURL.Host, c.dhcp.httpIpxeBinaryURL.Port),
-		Path:   c.dhcp.httpIpxeBinaryURL.Path,
+
+	return &inventory.Kube{Client: kc}, nil
+}
+
+// handoffVerifier returns a handoff.Verifier that uses the kubernetes backend client.
+func (c *config) handoffVerifier(ctx context.Context, log logr.Logger) (*handoff.Verifier, error) {
+	kc, err := c.kubeClient(ctx, log)
+	if err != nil {
+		return nil, fmt.Errorf("tink server handoff verification: %w", err)
 	}
-	if _, err := url.Parse(httpBinaryURL.String()); err != nil {
-		return nil, fmt.Errorf("invalid http ipxe binary url: %w", err)
+
+	return &handoff.Verifier{Client: kc, Timeout: c.ipxeHTTPScript.tinkHandoffTimeout, Log: log.WithName("handoff")}, nil
+}
+
+// dnsRegistrar returns a dns.Registrar that creates DNSEndpoint objects in the kubernetes backend namespace.
+func (c *config) dnsRegistrar(ctx context.Context, log logr.Logger) (*dns.Registrar, error) {
+	kc, err := c.kubeClient(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+	ns, _, err := c.backends.kubernetes.clientConfig().Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dns.Registrar{Client: kc, Namespace: ns, Domain: c.dns.domain, Log: log.WithName("dns")}, nil
+}
+
+// mdnsResponder returns the responder that advertises the http endpoints, when http is served, and the admin api,
+// when it is served over TCP on an address that isn't a loopback address.
+func (c *config) mdnsResponder(log logr.Logger, http bool) (*mdns.Responder, error) {
+	ip, err := netip.ParseAddr(c.advertise())
+	if err != nil {
+		return nil, errors.New("the advertised ip is required to advertise the services with mdns, set -advertised-ip")
+	}
+	r := &mdns.Responder{Instance: c.mdns.instance, IPs: []netip.Addr{ip}, Interface: c.mdns.iface, Log: log.WithName("mdns")}
+	if http {
+		r.Services = append(r.Services, mdns.Service{
+			Type: "_http._tcp",
+			Port: c.ipxeHTTPScript.bindPort,
+			TXT:  []string{"app=smee", "version=" + GitRev, "path=/auto.ipxe"},
+		})
+	}
+	if c.admin.addr != "" && !admin.IsUnix(c.admin.addr) {
+		host, port, err := net.SplitHostPort(c.admin.addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin address: %w", err)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin port: %w", err)
+		}
+		if a, err := netip.ParseAddr(host); err != nil || !a.IsLoopback() {
+			r.Services = append(r.Services, mdns.Service{Type: "_smee-admin._tcp", Port: p, TXT: []string{"app=smee", "version=" + GitRev}})
+		}
+	}
+	if len(r.Services) == 0 {
+		return nil, errors.New("there is no service to advertise with mdns, neither http nor the admin api over TCP is served")
+	}
+
+	return r, nil
+}
+
+// kubeClient returns the client of the shared kubernetes backend.
+func (c *config) kubeClient(ctx context.Context, log logr.Logger) (client.Client, error) {
+	br, err := c.backend(ctx, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backend: %w", err)
+	}
+	kc, ok := br.(interface{ Client() client.Client })
+	if !ok || !c.backends.kubernetes.Enabled {
+		return nil, errors.New("only the kubernetes backend is supported")
+	}
+
+	return kc.Client(), nil
+}
+
+// selfTestRunner returns the self-test of the canary machine selfTest.mac. Its dhcp transaction runs through the dhcp
+// handler r, and its auto.ipxe and ISO are fetched from the http server over loopback when it listens on all addresses.
+// The checks of the services that are not enabled are skipped.
+func (c *config) selfTestRunner(log logr.Logger, r selftest.Replier, signer *iso.Signer) *selftest.Runner {
+	mac, err := net.ParseMAC(c.selfTest.mac)
+	if err != nil {
+		panic(fmt.Errorf("invalid self-test MAC address: %w", err))
+	}
+	host := c.ipxeHTTPScript.bindAddr
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	base := &url.URL{Scheme: "http", Host: net.JoinHostPort(host, strconv.Itoa(c.ipxeHTTPScript.bindPort))}
+	skip := func(name, reason string) selftest.Check {
+		return selftest.Check{Name: name, Run: func(context.Context) error {
+			return fmt.Errorf("%w: %s", selftest.ErrSkipped, reason)
+		}}
+	}
+
+	dhcpCheck := skip("dhcp", "only the reservation -dhcp-mode leases addresses to check")
+	switch {
+	case !c.dhcp.enabled:
+		dhcpCheck = skip("dhcp", "-dhcp-enabled=false")
+	case r != nil:
+		dhcpCheck = selftest.DHCP(r, mac)
+	}
+	firmwareCheck := skip("firmware", "-self-test-firmware=false")
+	switch {
+	case !c.selfTest.firmware:
+	case !c.dhcp.enabled:
+		firmwareCheck = skip("firmware", "-dhcp-enabled=false")
+	case r == nil:
+		firmwareCheck = skip("firmware", "only the reservation -dhcp-mode leases addresses to check")
+	default:
+		cs, err := firmware.Fixtures()
+		if err != nil {
+			panic(fmt.Errorf("failed to load the firmware fixtures: %w", err))
+		}
+		firmwareCheck = selftest.Firmware(r, mac, cs)
+	}
+	scriptCheck := skip("script", "-http-ipxe-script-enabled=false")
+	if c.ipxeHTTPScript.enabled {
+		scriptCheck = selftest.Script(nil, func() string {
+			return base.JoinPath(mac.String(), "auto.ipxe").String()
+		})
+	}
+	isoCheck := skip("iso", "-iso-enabled=false")
+	if c.iso.enabled {
+		isoCheck = selftest.ISO(nil, func() string {
+			if signer != nil {
+				return signer.URL(base.JoinPath("iso"), mac, time.Now()).String()
+			}
+			return base.JoinPath("iso", mac.String(), "hook.iso").String()
+		})
+	}
+
+	return &selftest.Runner{
+		Checks:   []selftest.Check{dhcpCheck, firmwareCheck, scriptCheck, isoCheck},
+		Log:      log.WithName("self-test"),
+		Interval: c.selfTest.interval,
+	}
+}
+
+// adminServer returns the admin API server, the token is required for TCP addresses.
+func (c *config) adminServer(ctx context.Context, log logr.Logger, r admin.Renderer) (*admin.Server, error) {
+	var token string
+	if c.admin.tokenFile != "" {
+		t, err := os.ReadFile(c.admin.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin token: %w", err)
+		}
+		token = string(bytes.TrimSpace(t))
+	}
+	if token == "" && !admin.IsUnix(c.admin.addr) {
+		return nil, errors.New("an admin token is required when the admin api is served over TCP")
+	}
+	br, err := c.backend(ctx, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backend: %w", err)
+	}
+	s := &admin.Server{
+		Log:       log.WithName("admin"),
+		Token:     token,
+		Version:   GitRev,
+		StartTime: startTime,
+		Ready:     c.readiness.Ready,
+		Backend:   br,
+		Renderer:  r,
+		Caches:    c.caches,
+		Events:    c.events,
+		Config:    c.effective,
+		Leases:    c.leases,
+		Snapshots: c.snapshots,
+	}
+	if c.dhcp.enabled {
+		s.DryRun = c.dryRun
+	}
+	if c.syslog.enabled {
+		s.Syslog = c.syslogMessages
+	}
+	s.Faults = c.faults
+	s.Maintenance = c.maintenanceSwitch
+	if c.replicas != nil {
+		s.Peers = c.replicas
+	}
+
+	return s, nil
+}
+
+// clusterMember returns the membership of Smee in the cluster of its replicas.
+func (c *config) clusterMember(log logr.Logger) (*cluster.Cluster, error) {
+	cl := &cluster.Cluster{
+		Name:          c.cluster.nodeName,
+		BindAddr:      c.cluster.bindAddr,
+		BindPort:      c.cluster.bindPort,
+		AdvertiseAddr: c.cluster.advertiseAddr,
+		Leases:        c.leases,
+		Addresses:     c.addresses,
+		Caches:        c.caches,
+		Log:           log.WithName("cluster"),
+	}
+	for _, p := range strings.Split(c.cluster.peers, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cl.Peers = append(cl.Peers, p)
+		}
+	}
+	if c.cluster.keyFile != "" {
+		key, err := os.ReadFile(c.cluster.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the cluster key: %w", err)
+		}
+		cl.Key = bytes.TrimSpace(key)
+		if n := len(cl.Key); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("the cluster key must be 16, 24 or 32 bytes, it is %d bytes", n)
+		}
+	}
+
+	return cl, nil
+}
+
+// bootstrapPatch is the iPXE script fragment that chains to the iPXE script of this Smee, without relying on
+// the boot file name that the firmware passes to iPXE.
+const bootstrapPatch = "dhcp && chain --autofree {{ .ScriptURL }} ||"
+
+// patchData holds the addresses of this Smee that the iPXE script patch template is executed with.
+type patchData struct {
+	// ScriptURL is the iPXE script URL, with the ${mac} iPXE setting in place of the MAC address.
+	ScriptURL     string
+	HTTPBinaryURL string
+	TFTPAddr      string
+	IP            string
+	SyslogIP      string
+}
+
+// ipxeScriptPatch returns the iPXE script fragment that is patched into the served iPXE binaries. It is
+// executed as a template with the patchData of this Smee, and it must fit in the patch region of the binaries.
+func (c *config) ipxeScriptPatch() (string, error) {
+	text := c.tftp.ipxeScriptPatch
+	if text == "" && c.tftp.ipxeScriptBootstrap {
+		text = bootstrapPatch
+	}
+	if text == "" {
+		return "", nil
+	}
+	scriptURL, err := c.ipxeScriptURL()
+	if err != nil {
+		return "", err
+	}
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0}
+	d := patchData{
+		ScriptURL: strings.Replace(scriptURL(mac).String(), mac.String(), "${mac}", 1),
+		HTTPBinaryURL: (&url.URL{
+			Scheme: c.dhcp.httpIpxeBinaryURL.Scheme,
+			Host:   fmt.Sprintf("%s:%d", c.dhcp.httpIpxeBinaryURL.Host, c.dhcp.httpIpxeBinaryURL.Port),
+			Path:   c.dhcp.httpIpxeBinaryURL.Path,
+		}).String(),
+		TFTPAddr: fmt.Sprintf("%s:%d", c.dhcp.tftpIP, c.dhcp.tftpPort),
+		IP:       c.dhcp.ipForPacket,
+		SyslogIP: c.dhcp.syslogIP,
+	}
+	p, err := tmpl.Execute("ipxe-script-patch", text, d, tmpl.Funcs(tmpl.Options{Env: c.template.env()}))
+	if err != nil {
+		return "", fmt.Errorf("executing the ipxe script patch: %w", err)
+	}
+	if _, err := binary.Patch(binary.IpxeEFI, []byte(p)); err != nil {
+		return "", fmt.Errorf("the ipxe script patch %q is %d bytes: %w", p, len(p), err)
 	}
 
+	return p, nil
+}
+
+// ipxeScriptURL returns the func that returns the iPXE script URL served to a machine in DHCP.
+func (c *config) ipxeScriptURL() (func(net.HardwareAddr) *url.URL, error) {
 	var httpScriptURL *url.URL
 	if c.dhcp.httpIpxeScriptURL != "" {
-		httpScriptURL, err = url.Parse(c.dhcp.httpIpxeScriptURL)
+		u, err := url.Parse(c.dhcp.httpIpxeScriptURL)
 		if err != nil {
 			return nil, fmt.Errorf("invalid http ipxe script url: %w", err)
 		}
+		httpScriptURL = u
 	} else {
 		httpScriptURL = &url.URL{
 			Scheme: c.dhcp.httpIpxeScript.Scheme,
@@ -418,16 +2365,201 @@ func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (server.Handl
 	if _, err := url.Parse(httpScriptURL.String()); err != nil {
 		return nil, fmt.Errorf("invalid http ipxe script url: %w", err)
 	}
-	ipxeScript := func(*dhcpv4.DHCPv4) *url.URL {
-		return httpScriptURL
-	}
 	if c.dhcp.httpIpxeScript.injectMacAddress {
-		ipxeScript = func(d *dhcpv4.DHCPv4) *url.URL {
+		return func(mac net.HardwareAddr) *url.URL {
 			u := *httpScriptURL
 			p := path.Base(u.Path)
-			u.Path = path.Join(path.Dir(u.Path), d.ClientHWAddr.String(), p)
+			u.Path = path.Join(path.Dir(u.Path), mac.String(), p)
 			return &u
+		}, nil
+	}
+
+	return func(net.HardwareAddr) *url.URL {
+		return httpScriptURL
+	}, nil
+}
+
+// dhcpLink returns the raw socket DHCP listener on the dhcp interface, on the inherited raw socket when there is one.
+// The source IP of its replies defaults to the IP in the DHCP packets, and then to the IP of the interface.
+func (c *config) dhcpLink(port int) (*server.Link, error) {
+	var mac net.HardwareAddr
+	if c.dhcp.rawSrcMAC != "" {
+		m, err := net.ParseMAC(c.dhcp.rawSrcMAC)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dhcp raw source mac: %w", err)
+		}
+		mac = m
+	}
+	ip, err := netip.ParseAddr(c.dhcp.rawSrcIP)
+	if c.dhcp.rawSrcIP == "" {
+		ip, err = netip.ParseAddr(c.dhcp.ipForPacket)
+		if err != nil || !ip.Is4() {
+			ip, err = netip.Addr{}, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid dhcp raw source ip: %w", err)
+	}
+
+	if f, ok := c.sockets.Inherited(sockets.DHCPRaw); ok {
+		return server.FileLink(f, c.dhcp.bindInterface, port, mac, ip)
+	}
+
+	return server.ListenLink(c.dhcp.bindInterface, port, mac, ip)
+}
+
+// shadowDHCP returns the DHCP handler that mirrors DHCP messages to the shadow Smee, dh must be able to reply without sending.
+func (c *config) shadowDHCP(log logr.Logger, dh server.Handler) (*shadow.DHCP, error) {
+	r, ok := dh.(*reservation.Handler)
+	if !ok {
+		return nil, errors.New("the dhcp handler does not support shadowing, only the reservation dhcp mode does")
+	}
+	addr, err := netip.ParseAddrPort(c.shadow.dhcpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow dhcp address: %w", err)
+	}
+	ignore, err := shadow.ParseIgnore(c.shadow.ignore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow ignore expression: %w", err)
+	}
+
+	return &shadow.DHCP{Addr: addr, Primary: r, Timeout: c.shadow.timeout, Ignore: ignore, Logger: log.WithName("shadow")}, nil
+}
+
+// shadowHTTP returns the middleware that mirrors iPXE script requests to the shadow Smee.
+func (c *config) shadowHTTP(log logr.Logger) (*shadow.HTTP, error) {
+	u, err := url.Parse(c.shadow.httpURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow http url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid shadow http url scheme: %q", u.Scheme)
+	}
+	ignore, err := shadow.ParseIgnore(c.shadow.ignore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow ignore expression: %w", err)
+	}
+	sh := &shadow.HTTP{URL: u, Timeout: c.shadow.timeout, Ignore: ignore, Logger: log.WithName("shadow")}
+	ut := upstream.New("shadow", c.upstream.global, nil)
+	if !c.tls.global.IsZero() {
+		t, err := c.tls.global.Transport()
+		if err != nil {
+			return nil, err
+		}
+		ut.Base = t
+	}
+	sh.Transport = ut
+
+	return sh, nil
+}
+
+// env returns the environment variables of the allowlist.
+func (t templateConfig) env() []string {
+	var env []string
+	for _, e := range strings.Split(t.envAllowlist, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			env = append(env, e)
+		}
+	}
+
+	return env
+}
+
+// dhcpObservers returns the observers of the DHCP replies that are sent.
+func (c *config) dhcpObservers() []handler.Observer {
+	var o []handler.Observer
+	if c.admin.addr != "" {
+		o = append(o, c.events)
+	}
+	if c.bootTraces != nil {
+		o = append(o, c.bootTraces)
+	}
+	if c.bootLog != nil {
+		o = append(o, c.bootLog)
+	}
+	if c.replicas != nil {
+		o = append(o, c.replicas)
+	}
+	if c.leases != nil {
+		o = append(o, c.leases)
+	}
+	if c.addresses != nil {
+		o = append(o, c.addresses)
+	}
+	if c.bootSLOs != nil {
+		o = append(o, c.bootSLOs)
+	}
+
+	return o
+}
+
+func (c *config) dhcpHandler(ctx context.Context, log logr.Logger, pol *policy.Policy) (server.Handler, error) {
+	if c.plugin.dhcpHandler != "" {
+		pc, err := c.plugins.Client(ctx, log, c.plugin.dhcpHandler)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start dhcp handler plugin: %w", err)
+		}
+		dh, err := pc.DHCPHandler()
+		if err != nil {
+			return nil, err
+		}
+		dh.DryRun = c.dryRun
+		dh.Faults = c.faults
+		dh.Observers = c.dhcpObservers()
+		return dh, nil
+	}
+	// 1. create the handler
+	// 2. create the backend
+	// 3. add the backend to the handler
+	pktIP, err := netip.ParseAddr(c.dhcp.ipForPacket)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bind address: %w", err)
+	}
+	var serverID netip.Addr
+	if c.dhcp.serverID != "" {
+		if serverID, err = netip.ParseAddr(c.dhcp.serverID); err != nil || !serverID.Is4() {
+			return nil, fmt.Errorf("invalid dhcp server identifier, it must be an IPv4 address: %q", c.dhcp.serverID)
+		}
+	}
+	tftpIP, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", c.dhcp.tftpIP, c.dhcp.tftpPort))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tftp address for DHCP server: %w", err)
+	}
+	httpBinaryURL := &url.URL{
+		Scheme: c.dhcp.httpIpxeBinaryURL.Scheme,
+		Host:   fmt.Sprintf("%s:%d", c.dhcp.httpIpxeBinaryURL.Host, c.dhcp.httpIpxeBinaryURL.Port),
+		Path:   c.dhcp.httpIpxeBinaryURL.Path,
+	}
+	if _, err := url.Parse(httpBinaryURL.String()); err != nil {
+		return nil, fmt.Errorf("invalid http ipxe binary url: %w", err)
+	}
+
+	scriptURL, err := c.ipxeScriptURL()
+	if err != nil {
+		return nil, err
+	}
+	ipxeScript := func(d *dhcpv4.DHCPv4) *url.URL {
+		return scriptURL(d.ClientHWAddr)
+	}
+	binaries, err := dhcp.ParseBinaries(c.dhcp.ipxeBinaries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ipxe binaries: %w", err)
+	}
+	var menu *pxemenu.Menu
+	if c.dhcp.pxeMenuFile != "" {
+		if menu, err = pxemenu.Load(c.dhcp.pxeMenuFile); err != nil {
+			return nil, fmt.Errorf("failed to load the pxe menu: %w", err)
 		}
+		log.Info("loaded pxe menu", "file", c.dhcp.pxeMenuFile, "items", len(menu.Items))
+	}
+	replyPolicy, err := dhcp.ParseReplyPolicy(c.dhcp.replyMode, c.dhcp.replyBroadcastFlag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dhcp reply policy: %w", err)
+	}
+	var transactions *dhcp.Transactions
+	if c.dhcp.transactionTTL > 0 {
+		transactions = &dhcp.Transactions{TTL: c.dhcp.transactionTTL}
+		c.caches.Add("dhcp-transactions", transactions.Flush)
 	}
 	backend, err := c.backend(ctx, log)
 	if err != nil {
@@ -435,53 +2567,106 @@ func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (server.Handl
 	}
 
 	switch dhcpMode(c.dhcp.mode) {
-	case dhcpModeReservation:
+	case dhcpModeReservation, dhcpModeKea:
 		syslogIP, err := netip.ParseAddr(c.dhcp.syslogIP)
 		if err != nil {
 			return nil, fmt.Errorf("invalid syslog address: %w", err)
 		}
 		dh := &reservation.Handler{
-			Backend: backend,
-			IPAddr:  pktIP,
-			Log:     log,
+			Backend:  c.handlerBackend(backend),
+			IPAddr:   pktIP,
+			ServerID: serverID,
+			Log:      log,
 			Netboot: reservation.Netboot{
 				IPXEBinServerTFTP: tftpIP,
 				IPXEBinServerHTTP: httpBinaryURL,
 				IPXEScriptURL:     ipxeScript,
 				Enabled:           true,
+				SecureBoot:        c.secureBoot.enabled,
+				Binaries:          binaries,
+				UBoot:             c.uboot.enabled,
 			},
-			OTELEnabled: true,
-			SyslogAddr:  syslogIP,
+			OTELEnabled:  true,
+			OTELNewRoot:  c.otel.bootTrace,
+			SyslogAddr:   syslogIP,
+			Facilities:   c.facilities,
+			Tenants:      c.tenants,
+			Profiles:     c.profiles,
+			Mirrors:      c.mirrors,
+			Menu:         menu,
+			Policy:       pol,
+			DryRun:       c.dryRun,
+			Faults:       c.faults,
+			LocalMACs:    c.localMACs,
+			Transactions: transactions,
+			ReplyPolicy:  replyPolicy,
+			Observers:    c.dhcpObservers(),
+		}
+		if c.registrar != nil {
+			dh.DNS = c.registrar
 		}
 		return dh, nil
 	case dhcpModeProxy:
 		dh := &proxy.Handler{
-			Backend: backend,
-			IPAddr:  pktIP,
-			Log:     log,
+			Backend:  c.handlerBackend(backend),
+			IPAddr:   pktIP,
+			ServerID: serverID,
+			Log:      log,
 			Netboot: proxy.Netboot{
 				IPXEBinServerTFTP: tftpIP,
 				IPXEBinServerHTTP: httpBinaryURL,
 				IPXEScriptURL:     ipxeScript,
 				Enabled:           true,
+				SecureBoot:        c.secureBoot.enabled,
+				Binaries:          binaries,
 			},
 			OTELEnabled:      true,
+			OTELNewRoot:      c.otel.bootTrace,
 			AutoProxyEnabled: false,
+			Tenants:          c.tenants,
+			Profiles:         c.profiles,
+			Mirrors:          c.mirrors,
+			Menu:             menu,
+			Policy:           pol,
+			DryRun:           c.dryRun,
+			Faults:           c.faults,
+			LocalMACs:        c.localMACs,
+			Transactions:     transactions,
+			ReplyPolicy:      replyPolicy,
+			Observers:        c.dhcpObservers(),
+			WindowsCompat:    c.dhcp.windowsCompat,
 		}
 		return dh, nil
 	case dhcpModeAutoProxy:
 		dh := &proxy.Handler{
-			Backend: backend,
-			IPAddr:  pktIP,
-			Log:     log,
+			Backend:  c.handlerBackend(backend),
+			IPAddr:   pktIP,
+			ServerID: serverID,
+			Log:      log,
 			Netboot: proxy.Netboot{
 				IPXEBinServerTFTP: tftpIP,
 				IPXEBinServerHTTP: httpBinaryURL,
 				IPXEScriptURL:     ipxeScript,
 				Enabled:           true,
+				SecureBoot:        c.secureBoot.enabled,
+				Binaries:          binaries,
 			},
 			OTELEnabled:      true,
+			OTELNewRoot:      c.otel.bootTrace,
 			AutoProxyEnabled: true,
+			Tenants:          c.tenants,
+			Profiles:         c.profiles,
+			Menu:             menu,
+			OUIRules:         c.ouiRules,
+			Mirrors:          c.mirrors,
+			Policy:           pol,
+			DryRun:           c.dryRun,
+			Faults:           c.faults,
+			LocalMACs:        c.localMACs,
+			Transactions:     transactions,
+			ReplyPolicy:      replyPolicy,
+			Observers:        c.dhcpObservers(),
+			WindowsCompat:    c.dhcp.windowsCompat,
 		}
 		return dh, nil
 	}
@@ -489,11 +2674,58 @@ func (c *config) dhcpHandler(ctx context.Context, log logr.Logger) (server.Handl
 	return nil, errors.New("invalid dhcp mode")
 }
 
+// isoSigner returns the iso.Signer of the ISO URLs, nil when iso.signingKeyFile is not set.
+func (c *config) isoSigner() (*iso.Signer, error) {
+	if !c.iso.enabled || c.iso.signingKeyFile == "" {
+		return nil, nil
+	}
+	key, err := readKey(c.iso.signingKeyFile, "ISO URL signing key")
+	if err != nil {
+		return nil, err
+	}
+
+	return &iso.Signer{Key: key, TTL: c.iso.signedURLTTL}, nil
+}
+
+// redactor returns the redact.Redactor of the logs, with the MAC address hash key of logHashMACsKeyFile.
+func (c *config) redactor() (redact.Redactor, error) {
+	r := redact.Redactor{HashMACs: c.logHashMACs}
+	if c.logHashMACs && c.logHashMACsKeyFile != "" {
+		key, err := readKey(c.logHashMACsKeyFile, "log MAC address hash key")
+		if err != nil {
+			return redact.Redactor{}, err
+		}
+		r.MACKey = key
+	}
+
+	return r, nil
+}
+
+// minKeySize is the minimum size of the HMAC keys that are read with readKey.
+const minKeySize = 32
+
+// readKey reads an HMAC key from file, without its surrounding white space. A key that is shorter than
+// minKeySize, like an empty file, is an error: the HMACs of a short key can be forged.
+func readKey(file, name string) ([]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s: %w", name, err)
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf("the %s in %s must be at least %d bytes, it is %d bytes", name, file, minKeySize, len(key))
+	}
+
+	return key, nil
+}
+
 // defaultLogger uses the slog logr implementation.
-func defaultLogger(level string) logr.Logger {
+// Secrets are always masked, MAC addresses are hashed when r.HashMACs is true.
+// With otelLogs, the logs are also emitted to the OpenTelemetry logs pipeline, once otel.Init sets it up.
+func defaultLogger(level string, r redact.Redactor, otelLogs bool) logr.Logger {
 	// source file and function can be long. This makes the logs less readable.
 	// truncate source file and function to last 3 parts for improved readability.
-	customAttr := func(_ []string, a slog.Attr) slog.Attr {
+	customAttr := func(groups []string, a slog.Attr) slog.Attr {
 		if a.Key == slog.SourceKey {
 			ss, ok := a.Value.Any().(*slog.Source)
 			if !ok || ss == nil {
@@ -511,7 +2743,7 @@ func defaultLogger(level string) logr.Logger {
 			return a
 		}
 
-		return a
+		return r.ReplaceAttr(groups, a)
 	}
 	opts := &slog.HandlerOptions{AddSource: true, ReplaceAttr: customAttr}
 	switch level {
@@ -520,9 +2752,12 @@ func defaultLogger(level string) logr.Logger {
 	default:
 		opts.Level = slog.LevelInfo
 	}
-	log := slog.New(slog.NewJSONHandler(os.Stdout, opts))
+	var h slog.Handler = slog.NewJSONHandler(os.Stdout, opts)
+	if otelLogs {
+		h = &otel.LogHandler{Next: h, ReplaceAttr: r.ReplaceAttr}
+	}
 
-	return logr.FromSlogHandler(log.Handler())
+	return logr.FromSlogHandler(h)
 }
 
 func parseTrustedProxies(trustedProxies string) (result []string) {
@@ -551,6 +2786,61 @@ func parseTrustedProxies(trustedProxies string) (result []string) {
 	return result
 }
 
+// parsePrefixes parses a comma separated list of IPs or CIDRs.
+func parsePrefixes(s string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, cidr := range parseTrustedProxies(s) {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+
+	return prefixes
+}
+
 func (d dhcpMode) String() string {
 	return string(d)
 }
+
+// listen returns the inherited TFTP socket, or else binds addr.
+func (t tftp) listen(inherited *sockets.Set, addr netip.AddrPort) (net.PacketConn, error) {
+	return inherited.PacketConn(sockets.TFTP, int(addr.Port()), func() (net.PacketConn, error) {
+		return net.ListenUDP("udp", net.UDPAddrFromAddrPort(addr))
+	})
+}
+
+// serve serves the iPXE binaries over TFTP on conn, like the ipxedust TFTP server, with at most maxSessions concurrent sessions.
+func (t tftp) serve(ctx context.Context, log logr.Logger, conn net.PacketConn) error {
+	h := itftp.Handler{Log: log, Patch: []byte(t.ipxeScriptPatch)}
+	ts := ptftp.NewServer(limit.TFTPReadHandler(limit.NewLimiter(t.maxSessions), t.readHandler(log)), h.HandleWrite)
+	ts.SetTimeout(t.timeout)
+	ts.SetBlockSize(t.blockSize)
+	ts.EnableSinglePort()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+		ts.Shutdown()
+	}()
+
+	return itftp.Serve(ctx, conn, ts)
+}
+
+// readHandler returns the TFTP read handler of the iPXE binaries.
+// The binaries in binaryDir are served in front of the embedded ones, with ipxeTrust the embedded ones are served
+// patched to trust it. With uboot, the U-Boot boot scripts and PXE configs are served in front of the binaries.
+func (t tftp) readHandler(log logr.Logger) func(string, io.ReaderFrom) error {
+	h := itftp.Handler{Log: log, Patch: []byte(t.ipxeScriptPatch)}
+	read := h.HandleRead
+	if t.binaryDir != "" || t.ipxeTrust != nil {
+		read = (&bindir.Handler{
+			Dir:          t.binaryDir,
+			Patch:        []byte(t.ipxeScriptPatch),
+			Trust:        t.ipxeTrust,
+			Log:          log.WithName("bindir"),
+			TFTPFallback: h.HandleRead,
+		}).HandleRead
+	}
+	if t.uboot != nil {
+		return t.uboot.TFTPReadHandler(read)
+	}
+
+	return read
+}
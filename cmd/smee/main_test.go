@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/admin"
+	"github.com/tinkerbell/smee/internal/dns"
+	"github.com/tinkerbell/smee/internal/vlan"
+)
+
+func TestIPXEScriptPatch(t *testing.T) {
+	dhcp := dhcpConfig{
+		ipForPacket:       "192.168.2.4",
+		syslogIP:          "192.168.2.5",
+		tftpIP:            "192.168.2.4",
+		tftpPort:          69,
+		httpIpxeBinaryURL: urlBuilder{Scheme: "http", Host: "192.168.2.4", Port: 8080, Path: "/ipxe/"},
+		httpIpxeScript:    httpIpxeScript{urlBuilder: urlBuilder{Scheme: "http", Host: "192.168.2.4", Port: 8080, Path: "/auto.ipxe"}, injectMacAddress: true},
+	}
+	tests := map[string]struct {
+		patch     string
+		bootstrap bool
+		want      string
+		wantErr   bool
+	}{
+		"none":                 {},
+		"static":               {patch: "set user-class Custom", want: "set user-class Custom"},
+		"bootstrap":            {bootstrap: true, want: "dhcp && chain --autofree http://192.168.2.4:8080/${mac}/auto.ipxe ||"},
+		"patch over bootstrap": {patch: "echo {{ .IP }}", bootstrap: true, want: "echo 192.168.2.4"},
+		"addresses":            {patch: "set syslog {{ .SyslogIP }} && set tftp {{ .TFTPAddr }} && set bin {{ .HTTPBinaryURL }}", want: "set syslog 192.168.2.5 && set tftp 192.168.2.4:69 && set bin http://192.168.2.4:8080/ipxe/"},
+		"too long":             {patch: "echo {{ .ScriptURL }} " + strings.Repeat("x", 100), wantErr: true},
+		"invalid template":     {patch: "echo {{ .IP", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &config{dhcp: dhcp, tftp: tftp{ipxeScriptPatch: tt.patch, ipxeScriptBootstrap: tt.bootstrap}}
+			got, err := c.ipxeScriptPatch()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ipxeScriptPatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestVLANDHCP(t *testing.T) {
+	c := &config{dhcp: dhcpConfig{
+		mode:              string(dhcpModeReservation),
+		ipForPacket:       "192.168.2.4",
+		syslogIP:          "192.168.2.4",
+		tftpIP:            "192.168.2.4",
+		httpIpxeBinaryURL: urlBuilder{Host: "192.168.2.4"},
+		httpIpxeScript:    httpIpxeScript{urlBuilder: urlBuilder{Host: "192.168.2.4"}},
+	}, registrar: &dns.Registrar{}}
+	vc := c.vlanDHCP(vlan.VLAN{ID: 100, Parent: "eth0", Address: "10.100.0.2/24", Mode: string(dhcpModeProxy), SyslogIP: "10.100.0.7"})
+	want := dhcpConfig{
+		mode:              string(dhcpModeProxy),
+		ipForPacket:       "10.100.0.2",
+		syslogIP:          "10.100.0.7",
+		tftpIP:            "10.100.0.2",
+		httpIpxeBinaryURL: urlBuilder{Host: "10.100.0.2"},
+		httpIpxeScript:    httpIpxeScript{urlBuilder: urlBuilder{Host: "10.100.0.2"}},
+	}
+	if diff := cmp.Diff(want, vc.dhcp, cmp.AllowUnexported(dhcpConfig{}, httpIpxeScript{})); diff != "" {
+		t.Fatal(diff)
+	}
+	if c.dhcp.ipForPacket != "192.168.2.4" {
+		t.Fatal("expected the config of Smee not to change")
+	}
+	if vc.registrar != c.registrar {
+		t.Fatal("expected the vlan to share the dns registrar of Smee")
+	}
+}
+
+func TestMDNSResponder(t *testing.T) {
+	c := &config{
+		advertisedIP:   "192.168.2.4",
+		ipxeHTTPScript: ipxeHTTPScript{bindPort: 8080},
+		admin:          adminConfig{addr: "0.0.0.0:50061"},
+	}
+	r, err := c.mdnsResponder(logr.Discard(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range r.Services {
+		got = append(got, fmt.Sprintf("%s:%d", s.Type, s.Port))
+	}
+	if diff := cmp.Diff([]string{"_http._tcp:8080", "_smee-admin._tcp:50061"}, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// an admin api on a loopback address or a unix socket can't be reached from the network.
+	c.admin.addr = "127.0.0.1:50061"
+	if _, err := c.mdnsResponder(logr.Discard(), false); err == nil {
+		t.Fatal("expected an error when there is no service to advertise")
+	}
+}
+
+func TestSharedBackend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &config{
+		backends: dhcpBackends{file: File{Enabled: true, FilePath: "../../internal/backend/file/testdata/example.yaml"}},
+		caches:   &admin.Caches{},
+		shared:   &sharedBackend{},
+	}
+	first, err := c.backend(ctx, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.backend(ctx, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("want the same backend for every service")
+	}
+	if diff := cmp.Diff([]string{"backend-file"}, c.caches.Names()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestISOSigner(t *testing.T) {
+	tests := map[string]struct {
+		key     string
+		wantErr bool
+	}{
+		"key":         {key: "0123456789abcdef0123456789abcdef\n"},
+		"empty":       {key: "", wantErr: true},
+		"white space": {key: " \n\t\n", wantErr: true},
+		"short":       {key: "0123456789abcdef\n", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := filepath.Join(t.TempDir(), "key")
+			if err := os.WriteFile(f, []byte(tt.key), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			c := &config{iso: isoConfig{enabled: true, signingKeyFile: f}}
+			s, err := c.isoSigner()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(s.Key) != "0123456789abcdef0123456789abcdef" {
+				t.Fatalf("got key %q, want it without the surrounding white space", s.Key)
+			}
+		})
+	}
+	if s, err := (&config{iso: isoConfig{enabled: true}}).isoSigner(); s != nil || err != nil {
+		t.Fatalf("got %v, %v, want no signer without a key file", s, err)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tinkerbell/smee/internal/localmac"
+	"github.com/tinkerbell/smee/internal/slo"
+	"github.com/tinkerbell/smee/internal/staleness"
+)
+
+// serviceStatus is whether a service of Smee runs, and why.
+type serviceStatus struct {
+	name    string
+	enabled bool
+	reason  string
+}
+
+func (s serviceStatus) String() string {
+	state := "disabled"
+	if s.enabled {
+		state = "enabled"
+	}
+
+	return fmt.Sprintf("%s: %s, %s", s.name, state, s.reason)
+}
+
+// serviceFlags are the services of the -services flag and their enabled flags. The enabled flags are deprecated
+// aliases of -services, they are still honored when -services is not set.
+var serviceFlags = []struct {
+	service string
+	flag    string
+}{
+	{service: "dhcp", flag: "dhcp-enabled"},
+	{service: "tftp", flag: "tftp-enabled"},
+	{service: "syslog", flag: "syslog-enabled"},
+	{service: "http-ipxe-binary", flag: "http-ipxe-binary-enabled"},
+	{service: "http-ipxe-script", flag: "http-ipxe-script-enabled"},
+	{service: "iso", flag: "iso-enabled"},
+	{service: "metadata", flag: "metadata-enabled"},
+	{service: "inventory", flag: "inventory-enabled"},
+	{service: "phone-home", flag: "phone-home-enabled"},
+	{service: "bmc", flag: "bmc-enabled"},
+	{service: "uboot", flag: "uboot-enabled"},
+	{service: "mdns", flag: "mdns-enabled"},
+	{service: "dns", flag: "dns-enabled"},
+}
+
+// applyServices sets the enabled flags of fs from the -services list, when it is set: the services in the list are
+// enabled and the others disabled. An enabled flag that is set, like -tftp-enabled=false, must agree with the list.
+// It returns the deprecated enabled flags that are set, so that their use can be reported.
+func (c *config) applyServices(fs *flag.FlagSet) ([]string, error) {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var deprecated []string
+	for _, sf := range serviceFlags {
+		if set[sf.flag] {
+			deprecated = append(deprecated, "-"+sf.flag)
+		}
+	}
+	if c.serviceList == "" {
+		return deprecated, nil
+	}
+	listed := map[string]bool{}
+	for _, name := range strings.Split(c.serviceList, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.ContainsFunc(serviceFlags, func(sf struct{ service, flag string }) bool { return sf.service == name }) {
+			return nil, fmt.Errorf("-services: unknown service %q, the services are %s", name, serviceNames())
+		}
+		listed[name] = true
+	}
+	var errs []error
+	for _, sf := range serviceFlags {
+		f := fs.Lookup(sf.flag)
+		if set[sf.flag] && f.Value.String() != strconv.FormatBool(listed[sf.service]) {
+			errs = append(errs, fmt.Errorf("-%s=%s disagrees with -services %q, use -services only", sf.flag, f.Value, c.serviceList))
+			continue
+		}
+		if err := f.Value.Set(strconv.FormatBool(listed[sf.service])); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return deprecated, errors.Join(errs...)
+}
+
+// serviceNames returns the comma separated services of the -services flag.
+func serviceNames() string {
+	names := make([]string, 0, len(serviceFlags))
+	for _, sf := range serviceFlags {
+		names = append(names, sf.service)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// disabledReason returns why the service with the enabled flag is disabled, not being in the -services list or the
+// deprecated flag.
+func (c *config) disabledReason(service, flag string) string {
+	if c.serviceList != "" {
+		return fmt.Sprintf("%s is not in -services", service)
+	}
+
+	return "-" + flag + "=false"
+}
+
+// backendName returns the name of the backend that is used, see backend.
+func (c *config) backendName() string {
+	switch {
+	case c.backends.Noop.Enabled:
+		return "noop"
+	case c.backends.file.Enabled:
+		return "file"
+	case c.backends.plugin.Enabled:
+		return "plugin"
+	case c.backends.replay.Enabled:
+		return "replay"
+	default:
+		return "kubernetes"
+	}
+}
+
+// services returns the status of the services of Smee, as set by their flags.
+func (c *config) services() []serviceStatus {
+	var st []serviceStatus
+
+	dhcpSt := serviceStatus{name: "dhcp", reason: c.disabledReason("dhcp", "dhcp-enabled")}
+	switch {
+	case c.dhcp.enabled && dhcpMode(c.dhcp.mode) == dhcpModeKea:
+		dhcpSt = serviceStatus{name: "dhcp", enabled: true, reason: fmt.Sprintf("kea mode, host reservations are served over http from the %s backend", c.backendName())}
+	case c.dhcp.enabled:
+		dhcpSt = serviceStatus{name: "dhcp", enabled: true, reason: fmt.Sprintf("%s mode on %s, %s backend", c.dhcp.mode, c.dhcp.bindAddr, c.backendName())}
+		if dhcpMode(c.dhcp.mode) == dhcpModeAutoProxy && !c.backends.Noop.Enabled {
+			dhcpSt.reason = fmt.Sprintf("%s mode on %s, the backend is not used for DHCP", c.dhcp.mode, c.dhcp.bindAddr)
+		}
+	}
+	st = append(st, dhcpSt)
+
+	tftpSt := serviceStatus{name: "tftp", reason: c.disabledReason("tftp", "tftp-enabled")}
+	if c.tftp.enabled {
+		tftpSt = serviceStatus{name: "tftp", enabled: true, reason: fmt.Sprintf("on %s:%d", c.tftp.bindAddr, c.tftp.bindPort)}
+	}
+	st = append(st, tftpSt)
+
+	var endpoints []string
+	if c.ipxeHTTPBinary.enabled {
+		endpoints = append(endpoints, "ipxe binaries")
+	}
+	if c.ipxeHTTPScript.enabled {
+		endpoints = append(endpoints, "ipxe script")
+	}
+	if c.iso.enabled {
+		endpoints = append(endpoints, "iso")
+	}
+	if c.metadata.enabled {
+		endpoints = append(endpoints, "metadata")
+	}
+	if c.bootstrap.dir != "" {
+		endpoints = append(endpoints, "bootstrap")
+	}
+	if c.inventory.enabled {
+		endpoints = append(endpoints, "inventory")
+	}
+	if c.phoneHome.enabled {
+		endpoints = append(endpoints, "phone-home")
+	}
+	if c.phoneHome.enabled && c.phoneHome.bootLogEntries > 0 {
+		endpoints = append(endpoints, "bootlog")
+	}
+	if c.bmc.enabled {
+		endpoints = append(endpoints, "bmc")
+	}
+	if c.bmc.enabled && c.campaign.file != "" {
+		endpoints = append(endpoints, "campaigns")
+	}
+	if c.dhcp.enabled && dhcpMode(c.dhcp.mode) == dhcpModeKea {
+		endpoints = append(endpoints, "kea")
+	}
+	httpSt := serviceStatus{name: "http", reason: "no http endpoint is enabled"}
+	if len(endpoints) > 0 {
+		httpSt = serviceStatus{name: "http", enabled: true, reason: fmt.Sprintf("on %s:%d for %s", c.ipxeHTTPScript.bindAddr, c.ipxeHTTPScript.bindPort, strings.Join(endpoints, ", "))}
+	}
+	st = append(st, httpSt)
+
+	syslogSt := serviceStatus{name: "syslog", reason: c.disabledReason("syslog", "syslog-enabled")}
+	if c.syslog.enabled {
+		syslogSt = serviceStatus{name: "syslog", enabled: true, reason: fmt.Sprintf("on %s:%d", c.syslog.bindAddr, c.syslog.bindPort)}
+	}
+	st = append(st, syslogSt)
+
+	adminSt := serviceStatus{name: "admin", reason: "-admin-addr is not set"}
+	if c.admin.addr != "" {
+		adminSt = serviceStatus{name: "admin", enabled: true, reason: "on " + c.admin.addr}
+	}
+	st = append(st, adminSt)
+
+	return st
+}
+
+// checkServices returns the problems with the dependencies between the services of Smee and their options,
+// options that have no effect, or fail, with the services and modes that are enabled.
+func (c *config) checkServices() []error {
+	var problems []error
+	mode := dhcpMode(c.dhcp.mode)
+	backends := numTrue(c.backends.file.Enabled, c.backends.kubernetes.Enabled, c.backends.Noop.Enabled, c.backends.plugin.Enabled, c.backends.replay.Enabled)
+	// the kubernetes backend is enabled by default, it is disabled when another backend is enabled.
+	if numTrue(c.backends.file.Enabled, c.backends.Noop.Enabled, c.backends.plugin.Enabled, c.backends.replay.Enabled) > 1 {
+		problems = append(problems, errors.New("only one of -backend-file-enabled, -backend-noop-enabled, -backend-plugin-enabled and -backend-replay-enabled can be set"))
+	}
+	if c.backends.replay.Enabled && c.backends.replay.FilePath == "" {
+		problems = append(problems, errors.New("-backend-replay-enabled requires -backend-replay-file"))
+	}
+	if c.backends.replay.Enabled && c.backends.recordFile != "" {
+		problems = append(problems, errors.New("-backend-record-file can't be used with -backend-replay-enabled, the replayed lookups would be recorded again"))
+	}
+	if c.dhcp.enabled {
+		switch mode {
+		case dhcpModeReservation, dhcpModeKea, dhcpModeProxy, dhcpModeAutoProxy:
+		default:
+			problems = append(problems, fmt.Errorf("-dhcp-mode %q is not one of %s, %s, %s, %s", c.dhcp.mode, dhcpModeReservation, dhcpModeProxy, dhcpModeAutoProxy, dhcpModeKea))
+		}
+		if (mode == dhcpModeReservation || mode == dhcpModeKea) && backends == 0 {
+			problems = append(problems, fmt.Errorf("-dhcp-mode %s requires a data backend for its host reservations, enable one of the backends", mode))
+		}
+		if mode != dhcpModeReservation && c.dns.enabled {
+			problems = append(problems, fmt.Errorf("-dns-enabled requires -dhcp-mode %s, there are no reservations to register in %s mode", dhcpModeReservation, mode))
+		}
+		if mode == dhcpModeKea && c.dhcp.vlanFile != "" {
+			problems = append(problems, fmt.Errorf("-dhcp-vlan-file can't be used with -dhcp-mode %s, smee doesn't listen for dhcp in this mode", mode))
+		}
+		if c.dhcp.vlanFile != "" && c.dhcp.bindInterface == "" {
+			problems = append(problems, errors.New("-dhcp-vlan-file requires -dhcp-iface, a dhcp listener that isn't bound to an interface also receives the broadcasts of the vlans"))
+		}
+		if mode == dhcpModeKea && c.dhcp.raw {
+			problems = append(problems, fmt.Errorf("-dhcp-raw-enabled can't be used with -dhcp-mode %s, smee doesn't listen for dhcp in this mode", mode))
+		}
+		if c.dhcp.raw && c.dhcp.bindInterface == "" {
+			problems = append(problems, errors.New("-dhcp-raw-enabled requires -dhcp-iface, the raw socket is opened on an interface"))
+		}
+		if mode != dhcpModeReservation && c.shadow.dhcpAddr != "" {
+			problems = append(problems, fmt.Errorf("-shadow-dhcp-addr requires -dhcp-mode %s", dhcpModeReservation))
+		}
+		if mode != dhcpModeAutoProxy && c.oui.file != "" {
+			problems = append(problems, fmt.Errorf("-oui-file requires -dhcp-mode %s", dhcpModeAutoProxy))
+		}
+		if mode != dhcpModeProxy && mode != dhcpModeAutoProxy && c.dhcp.windowsCompat {
+			problems = append(problems, fmt.Errorf("-dhcp-windows-compat requires -dhcp-mode %s or %s", dhcpModeProxy, dhcpModeAutoProxy))
+		}
+	}
+	if c.rollout.stateFile != "" && c.rollout.maxChanges <= 0 {
+		problems = append(problems, errors.New("-rollout-state-file requires -rollout-max-changes"))
+	}
+	if c.cluster.keyFile != "" && c.cluster.peers == "" {
+		problems = append(problems, errors.New("-cluster-key-file requires -cluster-peers"))
+	}
+	if c.snapshot.dir != "" && c.snapshot.count <= 0 {
+		problems = append(problems, errors.New("-snapshot-dir requires -snapshot-count"))
+	}
+	if c.backends.Noop.Enabled && mode != dhcpModeAutoProxy {
+		problems = append(problems, fmt.Errorf("-backend-noop-enabled requires -dhcp-mode %s, the noop backend has no host reservations", dhcpModeAutoProxy))
+	}
+	if c.backendName() != "kubernetes" || !c.backends.kubernetes.Enabled {
+		kubeOnly := []struct {
+			flag string
+			set  bool
+		}{
+			{flag: "-bmc-enabled", set: c.bmc.enabled},
+			{flag: "-dns-enabled", set: c.dns.enabled},
+			{flag: "-inventory-enabled without -inventory-facts-dir", set: c.inventory.enabled && c.inventory.factsDir == ""},
+			{flag: "-tink-handoff-timeout", set: c.ipxeHTTPScript.tinkHandoffTimeout > 0},
+			{flag: "-phone-home-disable-netboot", set: c.phoneHome.disableNetboot},
+			{flag: "-netboot-disable-after", set: c.writeback.netbootDisableAfter > 0},
+		}
+		for _, k := range kubeOnly {
+			if k.set {
+				problems = append(problems, fmt.Errorf("%s requires the kubernetes backend", k.flag))
+			}
+		}
+	}
+	if c.iso.enabled && c.iso.url == "" && c.iso.indexURL == "" && c.iso.buildKernel == "" {
+		problems = append(problems, errors.New("-iso-enabled requires -iso-url or -iso-index-url, the source ISO to patch"))
+	}
+	if files := []string{c.iso.buildKernel, c.iso.buildInitrd, c.iso.buildLoader}; slices.Contains(files, "") && slices.ContainsFunc(files, func(s string) bool { return s != "" }) {
+		problems = append(problems, errors.New("-iso-build-kernel, -iso-build-initrd and -iso-build-loader must be set together"))
+	}
+	if c.iso.buildKernel != "" && (c.iso.url != "" || c.iso.indexURL != "") {
+		problems = append(problems, errors.New("-iso-build-kernel can't be used with -iso-url or -iso-index-url, the ISO is either built or patched"))
+	}
+	if c.iso.buildCmdline != "" && c.iso.buildKernel == "" {
+		problems = append(problems, errors.New("-iso-build-cmdline requires -iso-build-kernel"))
+	}
+	if c.iso.enabled {
+		if _, _, err := c.iso.replicas(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if !c.ipxeHTTPScript.enabled && c.ipxeHTTPScript.tinkHandoffTimeout > 0 {
+		problems = append(problems, errors.New("-tink-handoff-timeout requires -http-ipxe-script-enabled, the handoff is verified once a boot script is served"))
+	}
+	if c.ipxeHTTPScript.consoleRulesFile != "" && !c.ipxeHTTPScript.consoleDetect {
+		problems = append(problems, errors.New("-ipxe-script-console-rules-file requires -ipxe-script-console-detect"))
+	}
+	if c.esxi.dir != "" && !c.ipxeHTTPScript.enabled {
+		problems = append(problems, errors.New("-esxi-dir requires -http-ipxe-script-enabled, the boot configs are generated with the boot profile of the machines"))
+	}
+	if c.staleness.maxAge > 0 {
+		if _, err := staleness.ParseAction(c.staleness.action); err != nil {
+			problems = append(problems, fmt.Errorf("-backend-stale-action: %w", err))
+		}
+		switch {
+		case c.backendName() != "kubernetes":
+			problems = append(problems, fmt.Errorf("-backend-max-age requires the kubernetes backend, the %s backend reads its source on every lookup", c.backendName()))
+		case c.backends.kubernetes.ValidateInterval <= 0 || c.backends.kubernetes.ValidateInterval >= c.staleness.maxAge:
+			problems = append(problems, errors.New("-backend-kube-validate-interval must be greater than 0 and less than -backend-max-age, or the records go stale between two validations"))
+		}
+	}
+	if c.dhcp.localMACAction != "" {
+		if _, err := localmac.ParseAction(c.dhcp.localMACAction); err != nil {
+			problems = append(problems, fmt.Errorf("-dhcp-local-mac-action: %w", err))
+		}
+	}
+	if _, err := c.maintenance.state(); err != nil {
+		problems = append(problems, fmt.Errorf("-maintenance-subnets: %w", err))
+	}
+	if _, err := slo.ParseThresholds(c.slo.thresholds); err != nil {
+		problems = append(problems, fmt.Errorf("-slo-thresholds: %w", err))
+	}
+	if c.slo.webhookURL != "" {
+		if c.slo.thresholds == "" {
+			problems = append(problems, errors.New("-slo-webhook-url requires -slo-thresholds"))
+		}
+		if u, err := url.Parse(c.slo.webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Errorf("-slo-webhook-url must be an http or https URL, got %q", c.slo.webhookURL))
+		}
+	}
+	if c.quota.netboots > 0 && c.quota.window <= 0 {
+		problems = append(problems, errors.New("-quota-window must be positive with -quota-netboots"))
+	}
+	if c.quota.netboots > 0 && !c.ipxeHTTPScript.enabled {
+		problems = append(problems, errors.New("-quota-netboots requires -http-ipxe-script-enabled, the netboots are counted by the boot scripts served"))
+	}
+	if c.https.port > 0 && (c.https.certFile == "" || c.https.keyFile == "") {
+		problems = append(problems, errors.New("-https-port requires -https-cert-file and -https-key-file, the certificate chain and key of the HTTPS server"))
+	}
+	if c.phoneHome.enabled && c.phoneHome.keyFile == "" {
+		problems = append(problems, errors.New("-phone-home-enabled requires -phone-home-key-file, the key the phone home tokens are signed with"))
+	}
+	if c.phoneHome.enabled && !c.ipxeHTTPScript.enabled {
+		problems = append(problems, errors.New("-phone-home-enabled requires -http-ipxe-script-enabled, the phone home URL is passed to Hook in the boot script"))
+	}
+	if !c.phoneHome.enabled && c.phoneHome.disableNetboot {
+		problems = append(problems, errors.New("-phone-home-disable-netboot requires -phone-home-enabled"))
+	}
+	if c.inventory.enabled && !c.phoneHome.enabled {
+		problems = append(problems, errors.New("-inventory-enabled requires -phone-home-enabled, the phone home token authorizes the submission of facts"))
+	}
+	if c.inventory.enabled && c.inventory.allowedCIDRs == "" {
+		problems = append(problems, errors.New("-inventory-enabled requires -inventory-allowed-cidrs, the networks of the machines that submit facts"))
+	}
+	if !c.phoneHome.enabled && c.phoneHome.bootLogEntries > 0 {
+		problems = append(problems, errors.New("-phone-home-boot-log-entries requires -phone-home-enabled, the phone home token authorizes the boot log requests"))
+	}
+	if !c.supervise.restart && c.supervise.maxRestarts > 0 {
+		problems = append(problems, errors.New("-supervise-max-restarts requires -supervise-restart"))
+	}
+	if c.selfTest.mac != "" {
+		if _, err := net.ParseMAC(c.selfTest.mac); err != nil {
+			problems = append(problems, fmt.Errorf("-self-test-mac %q is not a MAC address", c.selfTest.mac))
+		}
+	}
+	if c.dhcp.rawSrcMAC != "" {
+		if _, err := net.ParseMAC(c.dhcp.rawSrcMAC); err != nil {
+			problems = append(problems, fmt.Errorf("-dhcp-raw-src-mac %q is not a MAC address", c.dhcp.rawSrcMAC))
+		}
+	}
+	if c.dhcp.rawSrcIP != "" {
+		if ip, err := netip.ParseAddr(c.dhcp.rawSrcIP); err != nil || !ip.Is4() {
+			problems = append(problems, fmt.Errorf("-dhcp-raw-src-ip %q is not an IPv4 address", c.dhcp.rawSrcIP))
+		}
+	}
+	if !c.dhcp.raw && (c.dhcp.rawSrcMAC != "" || c.dhcp.rawSrcIP != "") {
+		problems = append(problems, errors.New("-dhcp-raw-src-mac and -dhcp-raw-src-ip require -dhcp-raw-enabled"))
+	}
+	if c.campaign.file != "" && !c.bmc.enabled {
+		problems = append(problems, errors.New("-campaign-file requires -bmc-enabled, the machines of the campaigns are netbooted with Rufio"))
+	}
+	if c.campaign.file == "" && c.campaign.stateFile != "" {
+		problems = append(problems, errors.New("-campaign-state-file requires -campaign-file"))
+	}
+	if !c.mdns.enabled && (c.mdns.iface != "" || c.mdns.instance != "") {
+		problems = append(problems, errors.New("-mdns-iface and -mdns-instance require -mdns-enabled"))
+	}
+	if !c.metadata.enabled && c.metadata.vendorDataFile != "" {
+		problems = append(problems, errors.New("-metadata-vendor-data-file requires -metadata-enabled"))
+	}
+	if c.bootstrap.dir == "" && c.bootstrap.secretsDir != "" {
+		problems = append(problems, errors.New("-bootstrap-secrets-dir requires -bootstrap-dir"))
+	}
+	if !c.uboot.enabled && (c.uboot.scriptFile != "" || c.uboot.extlinuxFile != "") {
+		problems = append(problems, errors.New("-uboot-script-file and -uboot-extlinux-file require -uboot-enabled"))
+	}
+	if c.selfTest.mac == "" && c.selfTest.interval > 0 {
+		problems = append(problems, errors.New("-self-test-interval requires -self-test-mac"))
+	}
+	if c.selfTest.mac == "" && c.selfTest.firmware {
+		problems = append(problems, errors.New("-self-test-firmware requires -self-test-mac"))
+	}
+	if err := c.upstream.global.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("invalid -upstream policy: %w", err))
+	} else if err := c.upstream.global.Merge(c.upstream.iso).Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("invalid -upstream-iso policy: %w", err))
+	}
+	if c.otel.logs && c.otel.endpoint == "" {
+		problems = append(problems, errors.New("-otel-logs requires -otel-endpoint, the collector the logs are exported to"))
+	}
+	if c.otel.logBatchSize < 0 || c.otel.logExportInterval < 0 {
+		problems = append(problems, errors.New("-otel-logs-batch-size and -otel-logs-export-interval must not be negative"))
+	}
+	if _, err := c.otel.resource(); err != nil {
+		problems = append(problems, err)
+	}
+	if c.timeout.dhcp < 0 || c.timeout.http < 0 || c.timeout.backend < 0 {
+		problems = append(problems, errors.New("-timeout-dhcp, -timeout-http and -timeout-backend must not be negative"))
+	}
+
+	return problems
+}
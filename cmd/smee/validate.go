@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// validateConfig is the configuration of the validate subcommand.
+type validateConfig struct {
+	// out is where the findings are written.
+	out io.Writer
+	// interfaces returns the IP prefixes of the local network interfaces.
+	interfaces func() (interfaceAddrs, error)
+}
+
+// newValidateCommand returns the validate subcommand, it checks the addresses in the smee flags in cfg.
+func newValidateCommand(cfg *config) *ffcli.Command {
+	c := &validateConfig{out: os.Stdout, interfaces: localInterfaceAddrs}
+	return &ffcli.Command{
+		Name:       "validate",
+		ShortUsage: "smee [flags] validate",
+		ShortHelp:  "check the service configuration and that the addresses advertised to machines are served by this host",
+		LongHelp:   "Validate lists the services that run and checks the dependencies between the services, DHCP modes, backends and their options. It checks that the TFTP, HTTP, syslog and DHCP server addresses advertised in DHCP packets are bound to a local network interface, are on the network of the dhcp-iface interface and match the addresses the servers listen on. It lists the warnings of suspicious but legal settings, with their diagnostic code. It exits with an error when it finds a problem, or a warning with -strict.",
+		FlagSet:    flag.NewFlagSet("validate", flag.ExitOnError),
+		UsageFunc:  customUsageFunc,
+		Exec: func(_ context.Context, _ []string) error {
+			return c.run(cfg)
+		},
+	}
+}
+
+func (c *validateConfig) run(cfg *config) error {
+	cfg.advertise()
+	ifs, err := c.interfaces()
+	if err != nil {
+		return fmt.Errorf("failed to list the local network interfaces: %w", err)
+	}
+	for _, s := range cfg.services() {
+		fmt.Fprintln(c.out, s)
+	}
+	problems := cfg.checkServices()
+	for _, p := range problems {
+		fmt.Fprintln(c.out, p)
+	}
+	addrProblems := cfg.checkAddrs(ifs)
+	for _, p := range addrProblems {
+		fmt.Fprintln(c.out, p)
+	}
+	warnings := cfg.lint()
+	for _, d := range warnings {
+		fmt.Fprintln(c.out, d)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems with the service configuration and %d with the advertised addresses", len(problems), len(addrProblems))
+	}
+	if len(addrProblems) > 0 {
+		return fmt.Errorf("found %d problems with the advertised addresses", len(addrProblems))
+	}
+	if len(warnings) > 0 && cfg.strict {
+		return fmt.Errorf("found %d configuration warnings with -strict", len(warnings))
+	}
+	fmt.Fprintln(c.out, "the advertised addresses are served by this host")
+
+	return nil
+}
+
+// interfaceAddrs holds the IP prefixes of the local network interfaces by interface name.
+type interfaceAddrs map[string][]netip.Prefix
+
+// localInterfaceAddrs returns the IP prefixes of the local network interfaces.
+func localInterfaceAddrs() (interfaceAddrs, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	ifs := interfaceAddrs{}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			ones, _ := ipNet.Mask.Size()
+			ifs[iface.Name] = append(ifs[iface.Name], netip.PrefixFrom(ip.Unmap(), ones))
+		}
+	}
+
+	return ifs, nil
+}
+
+// bound returns the name of the interface that ip is bound to.
+func (ifs interfaceAddrs) bound(ip netip.Addr) (string, bool) {
+	for name, prefixes := range ifs {
+		for _, p := range prefixes {
+			if p.Addr() == ip {
+				return name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// onNetwork returns whether ip is in one of the networks of the interface name.
+func (ifs interfaceAddrs) onNetwork(name string, ip netip.Addr) bool {
+	for _, p := range ifs[name] {
+		if p.Masked().Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// advertisedAddr is an address that is sent to machines in DHCP packets, and the address its server listens on.
+type advertisedAddr struct {
+	flag   string
+	host   string
+	listen string
+}
+
+// checkAddrs returns the problems with the addresses that are advertised to machines: addresses that machines can't
+// reach, that are not bound to one of the local interfaces ifs, that are not on the network of dhcp-iface or that
+// differ from the address their server listens on. Host names are not checked.
+func (c *config) checkAddrs(ifs interfaceAddrs) []error {
+	var addrs []advertisedAddr
+	if c.dhcp.enabled && dhcpMode(c.dhcp.mode) != dhcpModeKea {
+		addrs = append(addrs, advertisedAddr{flag: "dhcp-ip-for-packet", host: c.dhcp.ipForPacket})
+	}
+	if c.tftp.enabled {
+		addrs = append(addrs, advertisedAddr{flag: "dhcp-tftp-ip", host: c.dhcp.tftpIP, listen: c.tftp.bindAddr})
+	}
+	if c.ipxeHTTPBinary.enabled {
+		addrs = append(addrs, advertisedAddr{flag: "dhcp-http-ipxe-binary-host", host: c.dhcp.httpIpxeBinaryURL.Host, listen: c.ipxeHTTPScript.bindAddr})
+	}
+	if c.ipxeHTTPScript.enabled {
+		a := advertisedAddr{flag: "dhcp-http-ipxe-script-host", host: c.dhcp.httpIpxeScript.Host, listen: c.ipxeHTTPScript.bindAddr}
+		if c.dhcp.httpIpxeScriptURL != "" {
+			a.flag = "dhcp-http-ipxe-script-url"
+			if u, err := url.Parse(c.dhcp.httpIpxeScriptURL); err == nil {
+				a.host = u.Hostname()
+			}
+		}
+		addrs = append(addrs, a)
+	}
+	if c.syslog.enabled {
+		addrs = append(addrs, advertisedAddr{flag: "dhcp-syslog-ip", host: c.dhcp.syslogIP, listen: c.syslog.bindAddr})
+	}
+
+	var problems []error
+	for _, a := range addrs {
+		if a.host == "" {
+			problems = append(problems, fmt.Errorf("-%s is not set and the advertised ip could not be detected", a.flag))
+			continue
+		}
+		ip, err := netip.ParseAddr(a.host)
+		if err != nil {
+			// host names can resolve to a load balancer, they are not checked.
+			continue
+		}
+		if ip.IsUnspecified() || ip.IsLoopback() {
+			problems = append(problems, fmt.Errorf("-%s is %s, machines can't reach it", a.flag, ip))
+			continue
+		}
+		name, ok := ifs.bound(ip)
+		if !ok {
+			problems = append(problems, fmt.Errorf("-%s %s is not bound to a local interface, machines only reach it when it is forwarded to this host", a.flag, ip))
+			continue
+		}
+		if c.dhcp.bindInterface != "" && name != c.dhcp.bindInterface && !ifs.onNetwork(c.dhcp.bindInterface, ip) {
+			problems = append(problems, fmt.Errorf("-%s %s is on interface %s, not on the network of -dhcp-iface %s, machines only reach it through a router", a.flag, ip, name, c.dhcp.bindInterface))
+		}
+		if l, err := netip.ParseAddr(a.listen); err == nil && !l.IsUnspecified() && l != ip {
+			problems = append(problems, fmt.Errorf("-%s is %s, but its server listens on %s", a.flag, ip, l))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tinkerbell/smee/internal/upstream"
+)
+
+func TestCheckAddrs(t *testing.T) {
+	ifs := interfaceAddrs{
+		"lo":   {netip.MustParsePrefix("127.0.0.1/8")},
+		"eth0": {netip.MustParsePrefix("192.168.2.4/24")},
+		"eth1": {netip.MustParsePrefix("10.0.0.4/24")},
+	}
+	base := func() config {
+		return config{
+			tftp:           tftp{enabled: true, bindAddr: "192.168.2.4"},
+			ipxeHTTPScript: ipxeHTTPScript{enabled: true, bindAddr: "0.0.0.0"},
+			dhcp: dhcpConfig{
+				enabled:        true,
+				mode:           string(dhcpModeReservation),
+				ipForPacket:    "192.168.2.4",
+				tftpIP:         "192.168.2.4",
+				httpIpxeScript: httpIpxeScript{urlBuilder: urlBuilder{Host: "192.168.2.4"}},
+			},
+		}
+	}
+	tests := map[string]struct {
+		modify func(*config)
+		want   []string
+	}{
+		"valid": {modify: func(*config) {}},
+		"not detected": {
+			modify: func(c *config) { c.dhcp.tftpIP = "" },
+			want:   []string{"-dhcp-tftp-ip is not set and the advertised ip could not be detected"},
+		},
+		"loopback": {
+			modify: func(c *config) { c.dhcp.ipForPacket = "127.0.0.1" },
+			want:   []string{"-dhcp-ip-for-packet is 127.0.0.1, machines can't reach it"},
+		},
+		"not bound": {
+			modify: func(c *config) { c.dhcp.httpIpxeScript.Host = "192.168.2.100" },
+			want:   []string{"-dhcp-http-ipxe-script-host 192.168.2.100 is not bound to a local interface, machines only reach it when it is forwarded to this host"},
+		},
+		"script url": {
+			modify: func(c *config) { c.dhcp.httpIpxeScriptURL = "http://192.168.2.100:8080/auto.ipxe" },
+			want:   []string{"-dhcp-http-ipxe-script-url 192.168.2.100 is not bound to a local interface, machines only reach it when it is forwarded to this host"},
+		},
+		"host name": {
+			modify: func(c *config) { c.dhcp.httpIpxeScript.Host = "smee.example.com" },
+		},
+		"other interface": {
+			modify: func(c *config) {
+				c.dhcp.bindInterface = "eth0"
+				c.dhcp.tftpIP = "10.0.0.4"
+				c.tftp.bindAddr = "0.0.0.0"
+			},
+			want: []string{"-dhcp-tftp-ip 10.0.0.4 is on interface eth1, not on the network of -dhcp-iface eth0, machines only reach it through a router"},
+		},
+		"listen address": {
+			modify: func(c *config) { c.tftp.bindAddr = "10.0.0.4" },
+			want:   []string{"-dhcp-tftp-ip is 192.168.2.4, but its server listens on 10.0.0.4"},
+		},
+		"disabled services": {
+			modify: func(c *config) {
+				c.tftp.enabled = false
+				c.dhcp.tftpIP = "127.0.0.1"
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := base()
+			tt.modify(&c)
+			var got []string
+			for _, p := range c.checkAddrs(ifs) {
+				got = append(got, p.Error())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	out := &bytes.Buffer{}
+	v := &validateConfig{out: out, interfaces: func() (interfaceAddrs, error) {
+		return interfaceAddrs{"eth0": {netip.MustParsePrefix("192.168.2.4/24")}}, nil
+	}}
+	c := &config{advertisedIP: "192.168.2.4", tftp: tftp{enabled: true}}
+	if err := v.run(c); err != nil {
+		t.Fatal(err)
+	}
+	want := `dhcp: disabled, -dhcp-enabled=false
+tftp: enabled, on :0
+http: disabled, no http endpoint is enabled
+syslog: disabled, -syslog-enabled=false
+admin: disabled, -admin-addr is not set
+the advertised addresses are served by this host
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	out.Reset()
+	c = &config{advertisedIP: "192.168.2.5", tftp: tftp{enabled: true}}
+	if err := v.run(c); err == nil {
+		t.Fatal("expected an error for an advertised address that is not bound to a local interface")
+	}
+	if !strings.HasSuffix(out.String(), "-dhcp-tftp-ip 192.168.2.5 is not bound to a local interface, machines only reach it when it is forwarded to this host\n") {
+		t.Fatalf("expected the address problem, got %s", out.String())
+	}
+
+	out.Reset()
+	c = &config{advertisedIP: "192.168.2.4", tftp: tftp{enabled: true}, iso: isoConfig{enabled: true}}
+	if err := v.run(c); err == nil {
+		t.Fatal("expected an error for a service configuration problem")
+	}
+	if !strings.Contains(out.String(), "-iso-enabled requires -iso-url or -iso-index-url, the source ISO to patch\n") {
+		t.Fatalf("expected the service configuration problem, got %s", out.String())
+	}
+}
+
+func TestCheckServices(t *testing.T) {
+	base := func() config {
+		return config{
+			dhcp:     dhcpConfig{enabled: true, mode: string(dhcpModeReservation)},
+			backends: dhcpBackends{kubernetes: Kube{Enabled: true}},
+		}
+	}
+	tests := map[string]struct {
+		modify func(*config)
+		want   []string
+	}{
+		"valid": {modify: func(*config) {}},
+		"two backends": {
+			modify: func(c *config) {
+				c.backends.file.Enabled = true
+				c.backends.plugin.Enabled = true
+			},
+			want: []string{"only one of -backend-file-enabled, -backend-noop-enabled, -backend-plugin-enabled and -backend-replay-enabled can be set"},
+		},
+		"record the replay": {
+			modify: func(c *config) {
+				c.backends.replay.Enabled = true
+				c.backends.replay.FilePath = "recording.jsonl"
+				c.backends.recordFile = "again.jsonl"
+			},
+			want: []string{"-backend-record-file can't be used with -backend-replay-enabled, the replayed lookups would be recorded again"},
+		},
+		"reservation without a backend": {
+			modify: func(c *config) { c.backends.kubernetes.Enabled = false },
+			want:   []string{"-dhcp-mode reservation requires a data backend for its host reservations, enable one of the backends"},
+		},
+		"auto-proxy with reservation options": {
+			modify: func(c *config) {
+				c.dhcp.mode = string(dhcpModeAutoProxy)
+				c.dns.enabled = true
+				c.shadow.dhcpAddr = "192.168.2.5:67"
+			},
+			want: []string{
+				"-dns-enabled requires -dhcp-mode reservation, there are no reservations to register in auto-proxy mode",
+				"-shadow-dhcp-addr requires -dhcp-mode reservation",
+			},
+		},
+		"oui file in reservation mode": {
+			modify: func(c *config) { c.oui.file = "oui.yaml" },
+			want:   []string{"-oui-file requires -dhcp-mode auto-proxy"},
+		},
+		"windows compat in reservation mode": {
+			modify: func(c *config) { c.dhcp.windowsCompat = true },
+			want:   []string{"-dhcp-windows-compat requires -dhcp-mode proxy or auto-proxy"},
+		},
+		"rollout state file without a limit": {
+			modify: func(c *config) { c.rollout.stateFile = "rollout.json" },
+			want:   []string{"-rollout-state-file requires -rollout-max-changes"},
+		},
+		"vlans without a dhcp interface": {
+			modify: func(c *config) { c.dhcp.vlanFile = "/etc/smee/vlans.yaml" },
+			want:   []string{"-dhcp-vlan-file requires -dhcp-iface, a dhcp listener that isn't bound to an interface also receives the broadcasts of the vlans"},
+		},
+		"raw dhcp without a dhcp interface": {
+			modify: func(c *config) { c.dhcp.raw = true },
+			want:   []string{"-dhcp-raw-enabled requires -dhcp-iface, the raw socket is opened on an interface"},
+		},
+		"raw dhcp source without raw dhcp": {
+			modify: func(c *config) { c.dhcp.rawSrcMAC = "00:01:02:03:04:05" },
+			want:   []string{"-dhcp-raw-src-mac and -dhcp-raw-src-ip require -dhcp-raw-enabled"},
+		},
+		"invalid raw dhcp source": {
+			modify: func(c *config) {
+				c.dhcp.raw, c.dhcp.bindInterface = true, "eth0"
+				c.dhcp.rawSrcMAC, c.dhcp.rawSrcIP = "not-a-mac", "::1"
+			},
+			want: []string{`-dhcp-raw-src-mac "not-a-mac" is not a MAC address`, `-dhcp-raw-src-ip "::1" is not an IPv4 address`},
+		},
+		"esxi without the ipxe script": {
+			modify: func(c *config) { c.esxi.dir = "/var/lib/smee/esxi" },
+			want:   []string{"-esxi-dir requires -http-ipxe-script-enabled, the boot configs are generated with the boot profile of the machines"},
+		},
+		"netboot quota without the script server": {
+			modify: func(c *config) { c.quota = quotaConfig{netboots: 3, window: 0} },
+			want: []string{
+				"-quota-window must be positive with -quota-netboots",
+				"-quota-netboots requires -http-ipxe-script-enabled, the netboots are counted by the boot scripts served",
+			},
+		},
+		"console rules without detection": {
+			modify: func(c *config) { c.ipxeHTTPScript.consoleRulesFile = "/etc/smee/consoles.yaml" },
+			want:   []string{"-ipxe-script-console-rules-file requires -ipxe-script-console-detect"},
+		},
+		"stale records with an unknown action": {
+			modify: func(c *config) {
+				c.staleness.maxAge, c.staleness.action, c.backends.kubernetes.ValidateInterval = time.Hour, "ignore", time.Minute
+			},
+			want: []string{`-backend-stale-action: unknown staleness action "ignore", must be one of continue, refuse or fallback`},
+		},
+		"stale records of the file backend": {
+			modify: func(c *config) {
+				c.staleness.maxAge, c.staleness.action, c.backends.file.Enabled = time.Hour, "refuse", true
+			},
+			want: []string{"-backend-max-age requires the kubernetes backend, the file backend reads its source on every lookup"},
+		},
+		"stale records between validations": {
+			modify: func(c *config) {
+				c.staleness.maxAge, c.staleness.action, c.backends.kubernetes.ValidateInterval = time.Minute, "refuse", time.Minute
+			},
+			want: []string{"-backend-kube-validate-interval must be greater than 0 and less than -backend-max-age, or the records go stale between two validations"},
+		},
+		"unknown local mac action": {
+			modify: func(c *config) { c.dhcp.localMACAction = "drop" },
+			want:   []string{`-dhcp-local-mac-action: unknown locally administered MAC address action "drop", must be one of allow, ignore, map or deny`},
+		},
+		"maintenance subnet that is not a CIDR": {
+			modify: func(c *config) { c.maintenance.subnets = "192.168.2.0/24, 10.0.0.1" },
+			want:   []string{`-maintenance-subnets: invalid maintenance subnet "10.0.0.1": netip.ParsePrefix("10.0.0.1"): no '/'`},
+		},
+		"https without a certificate": {
+			modify: func(c *config) { c.https.port, c.https.certFile = 8443, "/etc/smee/tls.crt" },
+			want:   []string{"-https-port requires -https-cert-file and -https-key-file, the certificate chain and key of the HTTPS server"},
+		},
+		"campaigns without bmc": {
+			modify: func(c *config) { c.campaign.file = "/etc/smee/campaigns.yaml" },
+			want:   []string{"-campaign-file requires -bmc-enabled, the machines of the campaigns are netbooted with Rufio"},
+		},
+		"campaign state without campaigns": {
+			modify: func(c *config) { c.campaign.stateFile = "/var/lib/smee/campaigns.json" },
+			want:   []string{"-campaign-state-file requires -campaign-file"},
+		},
+		"mdns instance without mdns": {
+			modify: func(c *config) { c.mdns.instance = "smee-lab" },
+			want:   []string{"-mdns-iface and -mdns-instance require -mdns-enabled"},
+		},
+		"otel logs without endpoint": {
+			modify: func(c *config) { c.otel.logs = true },
+			want:   []string{"-otel-logs requires -otel-endpoint, the collector the logs are exported to"},
+		},
+		"invalid otel resource attributes": {
+			modify: func(c *config) { c.otel.resourceAttributes = "deployment.environment=prod,lab" },
+			want:   []string{`-otel-resource-attributes "lab" is not a key=value pair`},
+		},
+		"cluster key without peers": {
+			modify: func(c *config) { c.cluster.keyFile = "/etc/smee/cluster.key" },
+			want:   []string{"-cluster-key-file requires -cluster-peers"},
+		},
+		"snapshot dir without a count": {
+			modify: func(c *config) { c.snapshot.dir = "/var/lib/smee/snapshots" },
+			want:   []string{"-snapshot-dir requires -snapshot-count"},
+		},
+		"noop backend in reservation mode": {
+			modify: func(c *config) { c.backends.Noop.Enabled = true },
+			want:   []string{"-backend-noop-enabled requires -dhcp-mode auto-proxy, the noop backend has no host reservations"},
+		},
+		"kubernetes only options": {
+			modify: func(c *config) {
+				c.backends.file.Enabled = true
+				c.bmc.enabled = true
+				c.inventory = inventoryConfig{enabled: true, allowedCIDRs: "192.168.2.0/24"}
+				c.phoneHome = phoneHomeConfig{enabled: true, keyFile: "/etc/smee/phone-home.key"}
+				c.ipxeHTTPScript.enabled = true
+			},
+			want: []string{"-bmc-enabled requires the kubernetes backend", "-inventory-enabled without -inventory-facts-dir requires the kubernetes backend"},
+		},
+		"inventory facts dir": {
+			modify: func(c *config) {
+				c.backends.file.Enabled = true
+				c.inventory = inventoryConfig{enabled: true, factsDir: "/var/lib/smee/facts", allowedCIDRs: "192.168.2.0/24"}
+				c.phoneHome = phoneHomeConfig{enabled: true, keyFile: "/etc/smee/phone-home.key"}
+				c.ipxeHTTPScript.enabled = true
+			},
+		},
+		"inventory without phone home": {
+			modify: func(c *config) {
+				c.backends.file.Enabled = true
+				c.inventory = inventoryConfig{enabled: true, factsDir: "/var/lib/smee/facts"}
+			},
+			want: []string{
+				"-inventory-enabled requires -phone-home-enabled, the phone home token authorizes the submission of facts",
+				"-inventory-enabled requires -inventory-allowed-cidrs, the networks of the machines that submit facts",
+			},
+		},
+		"handoff without the script server": {
+			modify: func(c *config) { c.ipxeHTTPScript.tinkHandoffTimeout = time.Minute },
+			want:   []string{"-tink-handoff-timeout requires -http-ipxe-script-enabled, the handoff is verified once a boot script is served"},
+		},
+		"iso replicas without self": {
+			modify: func(c *config) {
+				c.iso = isoConfig{enabled: true, url: "http://127.0.0.1/hook.iso", replicaURLs: "http://smee-0:7171,http://smee-1:7171", replicaSelf: "http://smee-2:7171"}
+			},
+			want: []string{`-iso-replica-self "http://smee-2:7171" is not one of -iso-replica-urls`},
+		},
+		"iso replicas": {
+			modify: func(c *config) {
+				c.iso = isoConfig{enabled: true, url: "http://127.0.0.1/hook.iso", replicaURLs: "http://smee-0:7171, http://smee-1:7171", replicaSelf: "http://smee-1:7171"}
+			},
+		},
+		"phone home without a key": {
+			modify: func(c *config) {
+				c.phoneHome = phoneHomeConfig{enabled: true}
+				c.ipxeHTTPScript.enabled = true
+			},
+			want: []string{"-phone-home-enabled requires -phone-home-key-file, the key the phone home tokens are signed with"},
+		},
+		"phone home disable netboot": {
+			modify: func(c *config) {
+				c.backends.file.Enabled = true
+				c.phoneHome = phoneHomeConfig{disableNetboot: true}
+			},
+			want: []string{"-phone-home-disable-netboot requires the kubernetes backend", "-phone-home-disable-netboot requires -phone-home-enabled"},
+		},
+		"boot log without phone home": {
+			modify: func(c *config) { c.phoneHome.bootLogEntries = 100 },
+			want:   []string{"-phone-home-boot-log-entries requires -phone-home-enabled, the phone home token authorizes the boot log requests"},
+		},
+		"netboot disable after without kubernetes": {
+			modify: func(c *config) {
+				c.backends.file.Enabled = true
+				c.writeback.netbootDisableAfter = 1
+			},
+			want: []string{"-netboot-disable-after requires the kubernetes backend"},
+		},
+		"max restarts without restart": {
+			modify: func(c *config) { c.supervise.maxRestarts = 3 },
+			want:   []string{"-supervise-max-restarts requires -supervise-restart"},
+		},
+		"self-test mac": {
+			modify: func(c *config) { c.selfTest = selfTestConfig{mac: "00:00:00:00:00:zz", interval: time.Minute} },
+			want:   []string{`-self-test-mac "00:00:00:00:00:zz" is not a MAC address`},
+		},
+		"self-test interval without mac": {
+			modify: func(c *config) { c.selfTest.interval = time.Minute },
+			want:   []string{"-self-test-interval requires -self-test-mac"},
+		},
+		"iso build files": {
+			modify: func(c *config) { c.iso.buildKernel = "/vmlinuz" },
+			want:   []string{"-iso-build-kernel, -iso-build-initrd and -iso-build-loader must be set together"},
+		},
+		"iso build and source": {
+			modify: func(c *config) {
+				c.iso = isoConfig{url: "http://10.0.0.1/hook.iso", buildKernel: "/vmlinuz", buildInitrd: "/initrd.img", buildLoader: "/systemd-bootx64.efi"}
+			},
+			want: []string{"-iso-build-kernel can't be used with -iso-url or -iso-index-url, the ISO is either built or patched"},
+		},
+		"iso build cmdline without kernel": {
+			modify: func(c *config) { c.iso.buildCmdline = "console=ttyS0" },
+			want:   []string{"-iso-build-cmdline requires -iso-build-kernel"},
+		},
+		"vendor-data without metadata": {
+			modify: func(c *config) { c.metadata.vendorDataFile = "/etc/smee/vendor-data.yaml" },
+			want:   []string{"-metadata-vendor-data-file requires -metadata-enabled"},
+		},
+		"slo unknown stage": {
+			modify: func(c *config) { c.slo.thresholds = "pxe=2s" },
+			want:   []string{`-slo-thresholds: unknown boot stage "pxe", must be one of offer, script, iso or boot`},
+		},
+		"slo webhook without thresholds": {
+			modify: func(c *config) { c.slo.webhookURL = "ftp://alerts" },
+			want:   []string{"-slo-webhook-url requires -slo-thresholds", `-slo-webhook-url must be an http or https URL, got "ftp://alerts"`},
+		},
+		"bootstrap secrets without documents": {
+			modify: func(c *config) { c.bootstrap.secretsDir = "/etc/smee/secrets" },
+			want:   []string{"-bootstrap-secrets-dir requires -bootstrap-dir"},
+		},
+		"u-boot script without u-boot": {
+			modify: func(c *config) { c.uboot.scriptFile = "/etc/smee/boot.scr.tmpl" },
+			want:   []string{"-uboot-script-file and -uboot-extlinux-file require -uboot-enabled"},
+		},
+		"self-test firmware without mac": {
+			modify: func(c *config) { c.selfTest.firmware = true },
+			want:   []string{"-self-test-firmware requires -self-test-mac"},
+		},
+		"upstream retries without backoff": {
+			modify: func(c *config) { c.upstream.iso = upstream.Policy{Retries: 3, BreakerFailures: -1} },
+			want:   []string{"invalid -upstream-iso policy: retry backoff must be positive"},
+		},
+		"negative timeout": {
+			modify: func(c *config) { c.timeout.backend = -time.Second },
+			want:   []string{"-timeout-dhcp, -timeout-http and -timeout-backend must not be negative"},
+		},
+		"dhcp disabled": {
+			modify: func(c *config) {
+				c.dhcp = dhcpConfig{mode: "unknown"}
+				c.backends.kubernetes.Enabled = false
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := base()
+			tt.modify(&c)
+			var got []string
+			for _, p := range c.checkServices() {
+				got = append(got, p.Error())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestServices(t *testing.T) {
+	c := &config{
+		dhcp:           dhcpConfig{enabled: true, mode: string(dhcpModeAutoProxy), bindAddr: "0.0.0.0:67"},
+		ipxeHTTPScript: ipxeHTTPScript{enabled: true, bindAddr: "192.168.2.4", bindPort: 8080},
+		iso:            isoConfig{enabled: true},
+		admin:          adminConfig{addr: "unix:/run/smee.sock"},
+	}
+	var got []string
+	for _, s := range c.services() {
+		got = append(got, s.String())
+	}
+	want := []string{
+		"dhcp: enabled, auto-proxy mode on 0.0.0.0:67, the backend is not used for DHCP",
+		"tftp: disabled, -tftp-enabled=false",
+		"http: enabled, on 192.168.2.4:8080 for ipxe script, iso",
+		"syslog: disabled, -syslog-enabled=false",
+		"admin: enabled, on unix:/run/smee.sock",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestApplyServices(t *testing.T) {
+	tests := map[string]struct {
+		args           []string
+		want           []string
+		wantDeprecated []string
+		wantErr        bool
+	}{
+		"default": {
+			want: []string{"dhcp", "tftp", "syslog", "http-ipxe-binary", "http-ipxe-script"},
+		},
+		"services": {
+			args: []string{"-services", "dhcp, http-ipxe-script,iso"},
+			want: []string{"dhcp", "http-ipxe-script", "iso"},
+		},
+		"deprecated flags": {
+			args:           []string{"-tftp-enabled=false", "-iso-enabled"},
+			want:           []string{"dhcp", "syslog", "http-ipxe-binary", "http-ipxe-script", "iso"},
+			wantDeprecated: []string{"-tftp-enabled", "-iso-enabled"},
+		},
+		"deprecated flag that agrees": {
+			args:           []string{"-services", "dhcp", "-tftp-enabled=false"},
+			want:           []string{"dhcp"},
+			wantDeprecated: []string{"-tftp-enabled"},
+		},
+		"deprecated flag that disagrees": {
+			args:    []string{"-services", "dhcp", "-tftp-enabled"},
+			wantErr: true,
+		},
+		"unknown service": {
+			args:    []string{"-services", "dhcp,pxe"},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := &config{}
+			fs := flag.NewFlagSet(name, flag.ContinueOnError)
+			if err := newCLI(c, fs).Parse(tt.args); !errors.As(err, &ffcli.NoExecError{}) {
+				t.Fatal(err)
+			}
+			deprecated, err := c.applyServices(fs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.wantDeprecated, deprecated); diff != "" {
+				t.Fatal(diff)
+			}
+			var got []string
+			for _, sf := range serviceFlags {
+				if fs.Lookup(sf.flag).Value.String() == "true" {
+					got = append(got, sf.service)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	c := &config{serviceList: "dhcp"}
+	if got, want := c.services()[1].String(), "tftp: disabled, tftp is not in -services"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
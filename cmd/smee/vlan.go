@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/tinkerbell/smee/internal/dhcp/server"
+	"github.com/tinkerbell/smee/internal/policy"
+	"github.com/tinkerbell/smee/internal/sockets"
+	"github.com/tinkerbell/smee/internal/supervise"
+	"github.com/tinkerbell/smee/internal/vlan"
+)
+
+// vlanDHCP returns the config of the DHCP handler of v, the config of Smee with the addresses in its DHCP replies
+// replaced by the IP of Smee on the VLAN, and with the overrides of v. The state of Smee, like the backend and the DNS
+// registrar, is shared with the handlers of the other VLANs.
+func (c *config) vlanDHCP(v vlan.VLAN) *config {
+	vc := *c
+	if ip := v.IP(); ip.IsValid() {
+		vc.dhcp.ipForPacket = ip.String()
+		vc.dhcp.syslogIP = ip.String()
+		vc.dhcp.tftpIP = ip.String()
+		vc.dhcp.httpIpxeBinaryURL.Host = ip.String()
+		vc.dhcp.httpIpxeScript.Host = ip.String()
+	}
+	if v.Mode != "" {
+		vc.dhcp.mode = v.Mode
+	}
+	if v.SyslogIP != "" {
+		vc.dhcp.syslogIP = v.SyslogIP
+	}
+	if v.TFTPIP != "" {
+		vc.dhcp.tftpIP = v.TFTPIP
+	}
+	if v.HTTPHost != "" {
+		vc.dhcp.httpIpxeBinaryURL.Host = v.HTTPHost
+		vc.dhcp.httpIpxeScript.Host = v.HTTPHost
+	}
+
+	return &vc
+}
+
+// serveVLANs serves DHCP on the subinterface of every VLAN, with the handlers in dhs by VLAN ID. A subinterface that
+// doesn't exist is created, and removed when the listener stops.
+func (c *config) serveVLANs(ctx context.Context, g *supervise.Group, log logr.Logger, pol *policy.Policy) error {
+	bindAddr, err := netip.ParseAddrPort(c.dhcp.bindAddr)
+	if err != nil {
+		return fmt.Errorf("invalid dhcp address: %w", err)
+	}
+	for _, v := range c.vlans.VLANs {
+		vc := c.vlanDHCP(v)
+		vlog := log.WithValues("vlan", v.ID, "interface", v.Name())
+		dh, err := vc.dhcpHandler(ctx, vlog, pol)
+		if err != nil {
+			return fmt.Errorf("vlan %d: %w", v.ID, err)
+		}
+		vlog.Info("starting dhcp server on vlan", "mode", vc.dhcp.mode, "ipForPacket", vc.dhcp.ipForPacket)
+		g.Go("dhcp-vlan-"+strconv.Itoa(v.ID), func() error {
+			created, err := vlan.Ensure(v)
+			if err != nil {
+				return err
+			}
+			if created {
+				vlog.Info("created vlan interface", "parent", v.Parent, "address", v.Address)
+				defer func() {
+					if err := vlan.Remove(v); err != nil {
+						vlog.Info("unable to remove the vlan interface", "error", err)
+					}
+				}()
+			}
+			conn, err := c.sockets.PacketConn(sockets.VLAN(v.ID), int(bindAddr.Port()), func() (net.PacketConn, error) {
+				return vlanConn(v, bindAddr.Port())
+			})
+			if err != nil {
+				return fmt.Errorf("failed to listen for dhcp on vlan %d: %w", v.ID, err)
+			}
+			defer conn.Close()
+			ds := &server.DHCP{Logger: vlog, Conn: conn, Handlers: []server.Handler{dh}, Workers: c.dhcp.workers, QueueSize: c.dhcp.queueSize, Timeout: c.timeout.dhcp}
+
+			return ds.Serve(ctx)
+		})
+	}
+
+	return nil
+}
+
+// vlanConn binds the DHCP socket of the subinterface of v.
+func vlanConn(v vlan.VLAN, port uint16) (*net.UDPConn, error) {
+	return server4.NewIPv4UDPConn(v.Name(), net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.IPv4Unspecified(), port)))
+}
@@ -0,0 +1,478 @@
+// Package admin is the gRPC admin API of Smee. It lets operators and orchestration systems query machine state,
+// flush caches, toggle the DHCP dry-run mode, render boot scripts, roll back the boot scripts of machines, inject
+// faults, stream boot events and gather debugging information of a running Smee.
+// The service is defined in admin.proto.
+package admin
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative admin.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/smee/internal/chaos"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/dhcp/lease"
+	"github.com/tinkerbell/smee/internal/ipxe/script"
+	"github.com/tinkerbell/smee/internal/maintenance"
+	"github.com/tinkerbell/smee/internal/selftest"
+	"github.com/tinkerbell/smee/internal/snapshot"
+	"github.com/tinkerbell/smee/internal/supervise"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Renderer renders the auto.ipxe script of a machine.
+type Renderer interface {
+	Render(ctx context.Context, mac net.HardwareAddr) (string, error)
+}
+
+// CachePeers flushes caches on the other replicas of Smee.
+type CachePeers interface {
+	FlushCaches(names []string)
+}
+
+// Server serves the admin API.
+type Server struct {
+	UnimplementedAdminServer
+
+	Log logr.Logger
+	// Token, when set, must be sent as a bearer token in the authorization metadata of every call.
+	Token     string
+	Version   string
+	StartTime time.Time
+	// Ready returns whether Smee is ready.
+	Ready func() bool
+	// Backend is used to get the data of machines.
+	Backend handler.BackendReader
+	// Renderer, when set, renders the auto.ipxe scripts of machines.
+	Renderer Renderer
+	// DryRun, when set, is the DHCP dry-run mode toggle.
+	DryRun *atomic.Bool
+	Caches *Caches
+	// Peers, when set, flushes the caches that are flushed on the other replicas of the cluster.
+	Peers  CachePeers
+	Events *Events
+	// Syslog, when set, streams the syslog messages that Smee receives.
+	Syslog *Syslog
+	// Faults, when set, injects the faults that are set with the admin API.
+	Faults *chaos.Injector
+	// Maintenance, when set, is the maintenance switch that is set with the admin API.
+	Maintenance *maintenance.Switch
+	// Config is the effective configuration of Smee returned by GetDebugInfo, a name=value line for every flag, with secrets masked.
+	Config []string
+	// Services, when set, returns the status of the services of Smee.
+	Services func() []supervise.Status
+	// Gatherer gathers the metrics returned by GetDebugInfo, prometheus.DefaultGatherer when nil.
+	Gatherer prometheus.Gatherer
+	// SelfTest, when set, is the self-test that RunSelfTest runs.
+	SelfTest *selftest.Runner
+	// Leases, when set, is the lease table of the DHCP server that ListLeases returns.
+	Leases *lease.Table
+	// DHCP, when set, returns the DHCP replies that GetBootConfig returns.
+	DHCP DHCPReplier
+	// Snapshots, when set, is the store of the snapshots of the boot scripts of machines that the snapshot calls use.
+	Snapshots *snapshot.Store
+}
+
+// Serve serves the admin API on l until ctx is done.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := s.authorize(ss.Context()); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	)
+	RegisterAdminServer(gs, s)
+	go func() {
+		<-ctx.Done()
+		// streams of boot events don't end by themselves, a graceful stop would wait forever.
+		gs.Stop()
+	}()
+
+	return gs.Serve(l)
+}
+
+// authorize checks the bearer token of a call.
+func (s *Server) authorize(ctx context.Context) error {
+	if s.Token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		t, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(t), []byte(s.Token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "a valid admin token is required")
+}
+
+// Status implements AdminServer.
+func (s *Server) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	resp := &StatusResponse{
+		Version:   s.Version,
+		StartTime: timestamppb.New(s.StartTime),
+		Ready:     s.Ready == nil || s.Ready(),
+		DryRun:    s.DryRun != nil && s.DryRun.Load(),
+	}
+	if s.Caches != nil {
+		resp.Caches = s.Caches.Names()
+	}
+
+	return resp, nil
+}
+
+// GetMachine implements AdminServer.
+func (s *Server) GetMachine(ctx context.Context, req *GetMachineRequest) (*Machine, error) {
+	mac, err := net.ParseMAC(req.Mac)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mac %q: %v", req.Mac, err)
+	}
+	if s.Backend == nil {
+		return nil, status.Error(codes.Unimplemented, "no backend is configured")
+	}
+	d, n, err := s.Backend.GetByMac(ctx, mac)
+	if err != nil {
+		return nil, backendError(err)
+	}
+	m := &Machine{
+		Mac:          mac.String(),
+		Hostname:     d.Hostname,
+		Arch:         d.Arch,
+		AllowNetboot: n.AllowNetboot,
+		Facility:     n.Facility,
+		Labels:       n.Labels,
+	}
+	if d.IPAddress.IsValid() {
+		m.Ip = d.IPAddress.String()
+	}
+	if s.Events != nil {
+		if ev, ok := s.Events.Last(mac); ok {
+			m.LastEvent = bootEvent(ev)
+		}
+		fetches := s.Events.Fetches(mac)
+		for _, f := range fetches {
+			m.Fetches = append(m.Fetches, httpFetch(f))
+		}
+		m.Diagnostics = Diagnose(fetches)
+	}
+
+	return m, nil
+}
+
+// ListMachines implements AdminServer.
+func (s *Server) ListMachines(context.Context, *ListMachinesRequest) (*ListMachinesResponse, error) {
+	resp := &ListMachinesResponse{}
+	if s.Events == nil {
+		return resp, nil
+	}
+	for _, ev := range s.Events.Machines() {
+		resp.Machines = append(resp.Machines, &Machine{Mac: ev.MAC.String(), LastEvent: bootEvent(ev), Diagnostics: Diagnose(s.Events.Fetches(ev.MAC))})
+	}
+
+	return resp, nil
+}
+
+// RenderScript implements AdminServer.
+func (s *Server) RenderScript(ctx context.Context, req *RenderScriptRequest) (*RenderScriptResponse, error) {
+	mac, err := net.ParseMAC(req.Mac)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mac %q: %v", req.Mac, err)
+	}
+	if s.Renderer == nil {
+		return nil, status.Error(codes.Unimplemented, "the HTTP iPXE script server is not enabled")
+	}
+	sc, err := s.Renderer.Render(ctx, mac)
+	if errors.Is(err, script.ErrNetbootNotAllowed) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	return &RenderScriptResponse{Script: sc}, nil
+}
+
+// FlushCaches implements AdminServer.
+func (s *Server) FlushCaches(_ context.Context, req *FlushCachesRequest) (*FlushCachesResponse, error) {
+	if s.Caches == nil {
+		return &FlushCachesResponse{}, nil
+	}
+	flushed, err := s.Caches.Flush(req.Names...)
+	if flushed == nil && err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.Log.Info("flushed caches", "caches", flushed)
+	if s.Peers != nil {
+		s.Peers.FlushCaches(flushed)
+	}
+
+	return &FlushCachesResponse{Flushed: flushed}, nil
+}
+
+// SetDryRun implements AdminServer.
+func (s *Server) SetDryRun(_ context.Context, req *SetDryRunRequest) (*SetDryRunResponse, error) {
+	if s.DryRun == nil {
+		return nil, status.Error(codes.Unimplemented, "the DHCP server is not enabled")
+	}
+	s.DryRun.Store(req.Enabled)
+	s.Log.Info("set DHCP dry-run mode", "enabled", req.Enabled)
+
+	return &SetDryRunResponse{Enabled: req.Enabled}, nil
+}
+
+// WatchBootEvents implements AdminServer.
+func (s *Server) WatchBootEvents(req *WatchBootEventsRequest, stream grpc.ServerStreamingServer[BootEvent]) error {
+	var mac net.HardwareAddr
+	if req.Mac != "" {
+		m, err := net.ParseMAC(req.Mac)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid mac %q: %v", req.Mac, err)
+		}
+		mac = m
+	}
+	if s.Events == nil {
+		return status.Error(codes.Unimplemented, "boot events are not recorded")
+	}
+	evs, cancel := s.Events.Subscribe(mac)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-evs:
+			if err := stream.Send(bootEvent(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// WatchSyslog implements AdminServer.
+func (s *Server) WatchSyslog(req *WatchSyslogRequest, stream grpc.ServerStreamingServer[SyslogMessage]) error {
+	var host string
+	if req.Host != "" {
+		ip := net.ParseIP(req.Host)
+		if ip == nil {
+			return status.Errorf(codes.InvalidArgument, "invalid host %q", req.Host)
+		}
+		host = ip.String()
+	}
+	if s.Syslog == nil {
+		return status.Error(codes.Unimplemented, "the syslog server is not enabled")
+	}
+	msgs, cancel := s.Syslog.Subscribe(host)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case m := <-msgs:
+			if err := stream.Send(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetFaults implements AdminServer.
+func (s *Server) GetFaults(_ context.Context, _ *GetFaultsRequest) (*Faults, error) {
+	if s.Faults == nil {
+		return nil, status.Error(codes.Unimplemented, "fault injection is not enabled")
+	}
+
+	return faults(s.Faults.Get()), nil
+}
+
+// SetFaults implements AdminServer.
+func (s *Server) SetFaults(_ context.Context, req *SetFaultsRequest) (*Faults, error) {
+	if s.Faults == nil {
+		return nil, status.Error(codes.Unimplemented, "fault injection is not enabled")
+	}
+	f := chaos.Faults{
+		DHCPDropPercent: int(req.GetFaults().GetDhcpDropPercent()),
+		ScriptDelay:     req.GetFaults().GetScriptDelay().AsDuration(),
+		ISOCorruptBytes: int(req.GetFaults().GetIsoCorruptBytes()),
+	}
+	if err := s.Faults.Set(f); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.Log.Info("set injected faults", "dhcpDropPercent", f.DHCPDropPercent, "scriptDelay", f.ScriptDelay.String(), "isoCorruptBytes", f.ISOCorruptBytes)
+
+	return faults(f), nil
+}
+
+// GetMaintenance implements AdminServer.
+func (s *Server) GetMaintenance(_ context.Context, _ *GetMaintenanceRequest) (*Maintenance, error) {
+	if s.Maintenance == nil {
+		return nil, status.Error(codes.Unimplemented, "the maintenance switch is not enabled")
+	}
+
+	return s.maintenance(), nil
+}
+
+// SetMaintenance implements AdminServer.
+func (s *Server) SetMaintenance(_ context.Context, req *SetMaintenanceRequest) (*Maintenance, error) {
+	if s.Maintenance == nil {
+		return nil, status.Error(codes.Unimplemented, "the maintenance switch is not enabled")
+	}
+	st := maintenance.State{Enabled: req.GetEnabled()}
+	for _, cidr := range req.GetSubnets() {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid subnet %q: %v", cidr, err)
+		}
+		st.Subnets = append(st.Subnets, p.Masked())
+	}
+	s.Maintenance.Set(st)
+
+	return s.maintenance(), nil
+}
+
+// maintenance returns the maintenance state of the switch and of the runtime settings.
+func (s *Server) maintenance() *Maintenance {
+	st, set := s.Maintenance.Get(), s.Maintenance.Settings()
+
+	return &Maintenance{
+		Enabled:         st.Enabled,
+		Subnets:         prefixes(st.Subnets),
+		LocalBoot:       s.Maintenance.LocalBoot,
+		SettingsEnabled: set.Enabled,
+		SettingsSubnets: prefixes(set.Subnets),
+	}
+}
+
+func prefixes(ps []netip.Prefix) []string {
+	var res []string
+	for _, p := range ps {
+		res = append(res, p.String())
+	}
+
+	return res
+}
+
+// backendError returns the gRPC status error of a backend error.
+func backendError(err error) error {
+	type notFound interface {
+		NotFound() bool
+	}
+	var nf notFound
+	if (errors.As(err, &nf) && nf.NotFound()) || apierrors.IsNotFound(err) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func bootEvent(ev Event) *BootEvent {
+	return &BootEvent{Time: timestamppb.New(ev.Time), Mac: ev.MAC.String(), Type: ev.Type, Detail: ev.Detail}
+}
+
+func httpFetch(f Fetch) *HTTPFetch {
+	return &HTTPFetch{
+		Time:     timestamppb.New(f.Time),
+		Artifact: f.Artifact,
+		Path:     f.Path,
+		Status:   uint32(f.Status), //nolint:gosec // HTTP status codes are positive.
+		Bytes:    f.Bytes,
+		Size:     f.Size,
+	}
+}
+
+func faults(f chaos.Faults) *Faults {
+	res := &Faults{DhcpDropPercent: uint32(f.DHCPDropPercent), IsoCorruptBytes: uint32(f.ISOCorruptBytes)} //nolint:gosec // validated by chaos.Faults.
+	if f.ScriptDelay > 0 {
+		res.ScriptDelay = durationpb.New(f.ScriptDelay)
+	}
+
+	return res
+}
+
+// RunSelfTest implements AdminServer.
+func (s *Server) RunSelfTest(ctx context.Context, _ *RunSelfTestRequest) (*SelfTestResults, error) {
+	if s.SelfTest == nil {
+		return nil, status.Error(codes.Unimplemented, "the self-test is not enabled")
+	}
+	res := &SelfTestResults{}
+	for _, r := range s.SelfTest.Run(ctx) {
+		res.Results = append(res.Results, selfTestResult(r))
+	}
+
+	return res, nil
+}
+
+func selfTestResult(r selftest.Result) *SelfTestResult {
+	res := &SelfTestResult{Check: r.Check, Passed: r.Passed(), Skipped: errors.Is(r.Err, selftest.ErrSkipped), Duration: durationpb.New(r.Duration)}
+	if r.Err != nil {
+		res.Error = r.Err.Error()
+	}
+
+	return res
+}
+
+// Listen listens on addr, a unix socket when it is in the form unix:<path> or unix://<path>, otherwise a TCP host:port.
+// A stale unix socket is removed and the socket is only accessible by the user running Smee.
+func Listen(addr string) (net.Listener, error) {
+	network, address := splitAddr(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+	if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("removing stale socket: %w", err)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(address, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// IsUnix returns whether addr is a unix socket address.
+func IsUnix(addr string) bool {
+	n, _ := splitAddr(addr)
+	return n == "unix"
+}
+
+func splitAddr(addr string) (network, address string) {
+	if p, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return "unix", p
+	}
+	if p, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", p
+	}
+
+	return "tcp", addr
+}
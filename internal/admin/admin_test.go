@@ -0,0 +1,601 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/smee/internal/chaos"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/lease"
+	"github.com/tinkerbell/smee/internal/ipxe/script"
+	"github.com/tinkerbell/smee/internal/maintenance"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/selftest"
+	"github.com/tinkerbell/smee/internal/snapshot"
+	"github.com/tinkerbell/smee/internal/supervise"
+	"github.com/tinkerbell/smee/internal/syslog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+var known = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+
+type notFoundError struct{}
+
+func (notFoundError) NotFound() bool { return true }
+
+func (notFoundError) Error() string { return "hardware not found" }
+
+type fakeBackend struct{}
+
+func (fakeBackend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if mac.String() != known.String() {
+		return nil, nil, notFoundError{}
+	}
+	return &data.DHCP{MACAddress: mac, IPAddress: netip.MustParseAddr("192.168.2.10"), Hostname: "node1", Arch: "x86_64"},
+		&data.Netboot{AllowNetboot: true, Labels: map[string]string{"rack": "a"}}, nil
+}
+
+func (fakeBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, notFoundError{}
+}
+
+func (b fakeBackend) List(ctx context.Context) ([]data.Record, error) {
+	d, n, _ := b.GetByMac(ctx, known)
+	other := &data.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}, IPAddress: netip.MustParseAddr("192.168.2.11"), Hostname: "node2"}
+
+	return []data.Record{{DHCP: d, Netboot: n}, {DHCP: other, Netboot: &data.Netboot{}}}, nil
+}
+
+type fakeRenderer struct{}
+
+func (fakeRenderer) Render(_ context.Context, mac net.HardwareAddr) (string, error) {
+	if mac.String() != known.String() {
+		return "", script.ErrNetbootNotAllowed
+	}
+	return "#!ipxe\nexit\n", nil
+}
+
+// serve serves s on a unix socket and returns a client of it.
+func serve(t *testing.T, s *Server, token string) *Client {
+	t.Helper()
+	addr := "unix://" + filepath.Join(t.TempDir(), "admin.sock")
+	l, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, l) }()
+	c, err := Dial(addr, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	return c
+}
+
+func newServer() *Server {
+	return &Server{
+		Log:       logr.Discard(),
+		Token:     "secret",
+		Version:   "v1.2.3",
+		StartTime: time.Unix(1700000000, 0),
+		Backend:   fakeBackend{},
+		Renderer:  fakeRenderer{},
+		DryRun:    &atomic.Bool{},
+		Caches:    &Caches{},
+		Events:    &Events{},
+	}
+}
+
+func TestToken(t *testing.T) {
+	for name, token := range map[string]string{"no token": "", "wrong token": "guess"} {
+		t.Run(name, func(t *testing.T) {
+			c := serve(t, newServer(), token)
+			_, err := c.Status(context.Background(), &StatusRequest{})
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("got %v, want an unauthenticated error", err)
+			}
+		})
+	}
+}
+
+func TestStatusAndDryRun(t *testing.T) {
+	s := newServer()
+	s.Caches.Add("machines", s.Events.Flush)
+	c := serve(t, s, "secret")
+	if _, err := c.SetDryRun(context.Background(), &SetDryRunRequest{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Status(context.Background(), &StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StartTime.AsTime().Unix() != 1700000000 {
+		t.Fatalf("got start time %v", got.StartTime.AsTime())
+	}
+	got.StartTime = nil
+	want := &StatusResponse{Version: "v1.2.3", Ready: true, DryRun: true, Caches: []string{"machines"}}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+	if !s.DryRun.Load() {
+		t.Fatal("expected dry-run to be enabled")
+	}
+}
+
+func TestGetMachine(t *testing.T) {
+	s := newServer()
+	s.Events.Record(Event{Time: time.Unix(1700000000, 0), MAC: known, Type: "dhcp", Detail: "OFFER"})
+	c := serve(t, s, "secret")
+
+	got, err := c.GetMachine(context.Background(), &GetMachineRequest{Mac: known.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastEvent == nil || got.LastEvent.Detail != "OFFER" {
+		t.Fatalf("got last event %v, want the OFFER event", got.LastEvent)
+	}
+	got.LastEvent = nil
+	want := &Machine{Mac: known.String(), Ip: "192.168.2.10", Hostname: "node1", Arch: "x86_64", AllowNetboot: true, Labels: map[string]string{"rack": "a"}}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	tests := map[string]struct {
+		mac  string
+		want codes.Code
+	}{
+		"unknown machine": {mac: "00:00:00:00:00:01", want: codes.NotFound},
+		"invalid mac":     {mac: "nope", want: codes.InvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.GetMachine(context.Background(), &GetMachineRequest{Mac: tt.mac})
+			if status.Code(err) != tt.want {
+				t.Fatalf("got %v, want code %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderScript(t *testing.T) {
+	c := serve(t, newServer(), "secret")
+	got, err := c.RenderScript(context.Background(), &RenderScriptRequest{Mac: known.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("#!ipxe\nexit\n", got.Script); diff != "" {
+		t.Fatal(diff)
+	}
+	_, err = c.RenderScript(context.Background(), &RenderScriptRequest{Mac: "00:00:00:00:00:01"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("got %v, want a failed precondition error", err)
+	}
+}
+
+func TestFlushCaches(t *testing.T) {
+	s := newServer()
+	var flushed atomic.Int32
+	s.Caches.Add("backend-file", func() error { flushed.Add(1); return nil })
+	s.Caches.Add("machines", s.Events.Flush)
+	s.Events.Record(Event{MAC: known, Type: "script", Detail: "auto.ipxe"})
+	p := &peers{}
+	s.Peers = p
+	c := serve(t, s, "secret")
+
+	got, err := c.FlushCaches(context.Background(), &FlushCachesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"backend-file", "machines"}, got.Flushed); diff != "" {
+		t.Fatal(diff)
+	}
+	if flushed.Load() != 1 || len(s.Events.Machines()) != 0 {
+		t.Fatal("expected all caches to be flushed")
+	}
+	if diff := cmp.Diff(got.Flushed, p.flushed); diff != "" {
+		t.Fatalf("expected the flushed caches to be flushed on the peers: %s", diff)
+	}
+	_, err = c.FlushCaches(context.Background(), &FlushCachesRequest{Names: []string{"nope"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got %v, want an invalid argument error", err)
+	}
+}
+
+type peers struct {
+	flushed []string
+}
+
+func (p *peers) FlushCaches(names []string) { p.flushed = append(p.flushed, names...) }
+
+func TestCachesFlushError(t *testing.T) {
+	c := &Caches{}
+	c.Add("a", func() error { return errors.New("boom") })
+	c.Add("b", func() error { return nil })
+	got, err := c.Flush()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if diff := cmp.Diff([]string{"b"}, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestWatchBootEvents(t *testing.T) {
+	s := newServer()
+	c := serve(t, s, "secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// events are recorded until the stream receives one, as the stream subscribes asynchronously.
+	go func() {
+		other := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+		for ctx.Err() == nil {
+			s.Events.DHCPServed(ctx, other, "OFFER")
+			s.Events.ScriptServed(ctx, known, "auto.ipxe")
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	stream, err := c.WatchBootEvents(ctx, &WatchBootEventsRequest{Mac: known.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Time = nil
+	if diff := cmp.Diff(&BootEvent{Mac: known.String(), Type: "script", Detail: "auto.ipxe"}, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestWatchSyslog(t *testing.T) {
+	s := newServer()
+	s.Syslog = &Syslog{}
+	c := serve(t, s, "secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// messages are received until the stream receives one, as the stream subscribes asynchronously.
+	go func() {
+		for ctx.Err() == nil {
+			s.Syslog.SyslogReceived(syslog.Message{Host: net.IPv4(192, 168, 2, 11), Msg: "other"})
+			s.Syslog.SyslogReceived(syslog.Message{Host: net.IPv4(192, 168, 2, 10), Severity: "INFO", Msg: "booting"})
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	stream, err := c.WatchSyslog(ctx, &WatchSyslogRequest{Host: "192.168.2.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Time = nil
+	if diff := cmp.Diff(&SyslogMessage{Host: "192.168.2.10", Severity: "INFO", Msg: "booting"}, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	c := serve(t, newServer(), "secret")
+	if _, err := c.GetFaults(context.Background(), &GetFaultsRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("got %v, want an unimplemented error when fault injection is not enabled", err)
+	}
+
+	s := newServer()
+	s.Faults = &chaos.Injector{}
+	c = serve(t, s, "secret")
+	want := &Faults{DhcpDropPercent: 20, ScriptDelay: durationpb.New(2 * time.Second), IsoCorruptBytes: 16}
+	got, err := c.SetFaults(context.Background(), &SetFaultsRequest{Faults: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+	if f := s.Faults.Get(); f != (chaos.Faults{DHCPDropPercent: 20, ScriptDelay: 2 * time.Second, ISOCorruptBytes: 16}) {
+		t.Fatalf("got injected faults %+v", f)
+	}
+	if got, err = c.GetFaults(context.Background(), &GetFaultsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	_, err = c.SetFaults(context.Background(), &SetFaultsRequest{Faults: &Faults{DhcpDropPercent: 101}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got %v, want an invalid argument error", err)
+	}
+	if _, err := c.SetFaults(context.Background(), &SetFaultsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if f := s.Faults.Get(); f != (chaos.Faults{}) {
+		t.Fatalf("got injected faults %+v, want none", f)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	c := serve(t, newServer(), "secret")
+	if _, err := c.GetMaintenance(context.Background(), &GetMaintenanceRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("got %v, want an unimplemented error when the maintenance switch is not enabled", err)
+	}
+
+	s := newServer()
+	s.Maintenance = &maintenance.Switch{LocalBoot: true}
+	c = serve(t, s, "secret")
+	got, err := c.SetMaintenance(context.Background(), &SetMaintenanceRequest{Subnets: []string{"192.168.2.7/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Maintenance{Subnets: []string{"192.168.2.0/24"}, LocalBoot: true}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+	if !s.Maintenance.Active(netip.MustParseAddr("192.168.2.10")) || s.Maintenance.Active(netip.MustParseAddr("192.168.3.10")) {
+		t.Fatal("want only the machines of 192.168.2.0/24 in maintenance")
+	}
+	if got, err = c.GetMaintenance(context.Background(), &GetMaintenanceRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	_, err = c.SetMaintenance(context.Background(), &SetMaintenanceRequest{Subnets: []string{"192.168.2.0"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got %v, want an invalid argument error", err)
+	}
+	if _, err := c.SetMaintenance(context.Background(), &SetMaintenanceRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Maintenance.Active(netip.MustParseAddr("192.168.2.10")) {
+		t.Fatal("want no machine in maintenance")
+	}
+}
+
+func TestGetDebugInfo(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "dhcp_requests_total", Help: "DHCP requests."})
+	reg.MustRegister(requests)
+	requests.Add(3)
+	s := newServer()
+	s.Config = []string{"admin-addr=unix:/run/smee/admin.sock"}
+	s.Services = func() []supervise.Status {
+		return []supervise.Status{{Name: "dhcp", State: supervise.StateRestarting, Restarts: 2, Err: errors.New("address in use")}}
+	}
+	s.Gatherer = reg
+	c := serve(t, s, "secret")
+
+	got, err := c.GetDebugInfo(context.Background(), &GetDebugInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.Metrics, "dhcp_requests_total 3") {
+		t.Fatalf("got metrics %q, want dhcp_requests_total", got.Metrics)
+	}
+	if !strings.Contains(got.Goroutines, "goroutine ") {
+		t.Fatal("expected the goroutine stacks")
+	}
+	want := &DebugInfo{
+		Config:    s.Config,
+		Services:  []*ServiceStatus{{Name: "dhcp", State: "restarting", Restarts: 2, Error: "address in use"}},
+		GoVersion: runtime.Version(),
+	}
+	got.Metrics, got.Goroutines = "", ""
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestRunSelfTest(t *testing.T) {
+	s := newServer()
+	c := serve(t, s, "secret")
+	if _, err := c.RunSelfTest(context.Background(), &RunSelfTestRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("got %v, want Unimplemented", err)
+	}
+
+	s.SelfTest = &selftest.Runner{Log: logr.Discard(), Checks: []selftest.Check{
+		{Name: "dhcp", Run: func(context.Context) error { return fmt.Errorf("%w: -dhcp-enabled=false", selftest.ErrSkipped) }},
+		{Name: "script", Run: func(context.Context) error { return errors.New("got status code 404, want 200") }},
+	}}
+	got, err := c.RunSelfTest(context.Background(), &RunSelfTestRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SelfTestResults{Results: []*SelfTestResult{
+		{Check: "dhcp", Passed: true, Skipped: true, Error: "skipped: -dhcp-enabled=false"},
+		{Check: "script", Error: "got status code 404, want 200"},
+	}}
+	if diff := cmp.Diff(want, got, protocmp.Transform(), protocmp.IgnoreFields(&SelfTestResult{}, "duration")); diff != "" {
+		t.Fatal(diff)
+	}
+	if s.SelfTest.Ready() {
+		t.Fatal("expected the self-test to fail")
+	}
+}
+
+func TestListLeases(t *testing.T) {
+	s := newServer()
+	s.Leases = &lease.Table{}
+	s.Leases.DHCPLeased(context.Background(), known, net.IPv4(192, 168, 2, 10), time.Hour)
+	s.Leases.DHCPLeased(context.Background(), net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x07}, net.IPv4(192, 168, 2, 20), time.Nanosecond)
+	s.Events.Record(Event{Time: time.Unix(1700000000, 0), MAC: known, Type: "dhcp", Detail: "ACK"})
+	time.Sleep(time.Millisecond)
+	c := serve(t, s, "secret")
+
+	got, err := c.ListLeases(context.Background(), &ListLeasesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ListLeasesResponse{Leases: []*Lease{
+		{Mac: known.String(), Ip: "192.168.2.10", Hostname: "node1", State: "active", LastActivity: timestamppb.New(time.Unix(1700000000, 0))},
+		{Mac: "00:01:02:03:04:06", Ip: "192.168.2.11", Hostname: "node2", State: "reserved"},
+		{Mac: "00:01:02:03:04:07", Ip: "192.168.2.20", State: "expired"},
+	}}
+	if diff := cmp.Diff(want, got, protocmp.Transform(), protocmp.IgnoreFields(&Lease{}, "expires")); diff != "" {
+		t.Fatal(diff)
+	}
+	if got.Leases[0].Expires == nil || got.Leases[1].Expires != nil {
+		t.Fatal("expected only the leases to expire")
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	s := newServer()
+	c := serve(t, s, "secret")
+	if _, err := c.ListSnapshots(context.Background(), &ListSnapshotsRequest{Mac: known.String()}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("got %v, want unimplemented without snapshots", err)
+	}
+
+	var err error
+	if s.Snapshots, err = snapshot.NewStore(5, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range []string{"#!ipxe\nset download-url http://10.1.0.5/v1", "#!ipxe\nset download-url http://10.1.0.5/v2"} {
+		if _, err := s.Snapshots.Record(known, "auto.ipxe", sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := c.DiffSnapshots(context.Background(), &DiffSnapshotsRequest{Mac: known.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.From.Version != 1 || d.To.Version != 2 || d.Diff != "  #!ipxe\n- set download-url http://10.1.0.5/v1\n+ set download-url http://10.1.0.5/v2\n" {
+		t.Fatalf("got diff of %d to %d:\n%s", d.From.Version, d.To.Version, d.Diff)
+	}
+	if _, err := c.DiffSnapshots(context.Background(), &DiffSnapshotsRequest{Mac: known.String(), From: 9}); status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want not found", err)
+	}
+
+	p, err := c.PinSnapshot(context.Background(), &PinSnapshotRequest{Mac: known.String(), Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Pinned.Version != 1 || p.Pinned.Content != "#!ipxe\nset download-url http://10.1.0.5/v1" {
+		t.Fatalf("got pinned %v, want version 1", p.Pinned)
+	}
+	l, err := c.ListSnapshots(context.Background(), &ListSnapshotsRequest{Mac: known.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Snapshots) != 2 || l.Pinned.GetVersion() != 1 || l.Snapshots[0].Content != "" {
+		t.Fatalf("got %v, want 2 snapshots without their content, pinned to 1", l)
+	}
+	if _, err := c.PinSnapshot(context.Background(), &PinSnapshotRequest{Mac: known.String(), Unpin: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Snapshots.Pinned(known, "auto.ipxe"); ok {
+		t.Fatal("expected the machine to be unpinned")
+	}
+}
+
+// fakeDHCP replies to the PXE firmware with the iPXE binary and to iPXE with the auto.ipxe script.
+type fakeDHCP struct{}
+
+func (fakeDHCP) Reply(_ context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	file := "snp.efi"
+	if dhcpv4.GetString(dhcpv4.OptionUserClassInformation, pkt.Options) != "" {
+		file = "http://192.168.2.1/auto.ipxe"
+	}
+
+	return dhcpv4.NewReplyFromRequest(pkt,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.IPv4(192, 168, 2, 10)),
+		dhcpv4.WithServerIP(net.IPv4(192, 168, 2, 1)),
+		dhcpv4.WithOption(dhcpv4.OptBootFileName(file)),
+	)
+}
+
+type fakeBootRenderer struct{ fakeRenderer }
+
+func (fakeBootRenderer) Boot(ctx context.Context, mac net.HardwareAddr) (script.Boot, error) {
+	s, err := fakeBootRenderer{}.Render(ctx, mac)
+	if err != nil {
+		return script.Boot{}, err
+	}
+
+	return script.Boot{Script: s, OSIEURL: "http://192.168.2.1/osie", TinkServer: "192.168.2.1:42113", KernelParams: []string{"console=ttyS0"}}, nil
+}
+
+func TestGetBootConfig(t *testing.T) {
+	s := newServer()
+	s.DHCP = fakeDHCP{}
+	s.Renderer = fakeBootRenderer{}
+	c := serve(t, s, "secret")
+
+	tests := map[string]struct {
+		req  *GetBootConfigRequest
+		want *BootConfig
+		code codes.Code
+	}{
+		"known machine": {
+			req: &GetBootConfigRequest{Mac: known.String(), Arch: "arm64"},
+			want: &BootConfig{
+				Mac: known.String(), Arch: "EFI ARM64", AllowNetboot: true,
+				Firmware:     &DHCPReply{MessageType: "OFFER", Ip: "192.168.2.10", NextServer: "192.168.2.1", BootFileName: "snp.efi", Options: []string{"DHCP Message Type: OFFER", "Bootfile Name: snp.efi"}},
+				Ipxe:         &DHCPReply{MessageType: "OFFER", Ip: "192.168.2.10", NextServer: "192.168.2.1", BootFileName: "http://192.168.2.1/auto.ipxe", Options: []string{"DHCP Message Type: OFFER", "Bootfile Name: http://192.168.2.1/auto.ipxe"}},
+				Script:       "#!ipxe\nexit\n",
+				OsieUrl:      "http://192.168.2.1/osie",
+				TinkServer:   "192.168.2.1:42113",
+				KernelParams: []string{"console=ttyS0"},
+			},
+		},
+		"unknown machine": {
+			req: &GetBootConfigRequest{Mac: "00:00:00:00:00:01"},
+			want: &BootConfig{
+				Mac: "00:00:00:00:00:01", Arch: "EFI x86-64",
+				Firmware: &DHCPReply{MessageType: "OFFER", Ip: "192.168.2.10", NextServer: "192.168.2.1", BootFileName: "snp.efi", Options: []string{"DHCP Message Type: OFFER", "Bootfile Name: snp.efi"}},
+				Ipxe:     &DHCPReply{MessageType: "OFFER", Ip: "192.168.2.10", NextServer: "192.168.2.1", BootFileName: "http://192.168.2.1/auto.ipxe", Options: []string{"DHCP Message Type: OFFER", "Bootfile Name: http://192.168.2.1/auto.ipxe"}},
+				Notes:    []string{"the machine is not in the backend", "no auto.ipxe script: " + script.ErrNetbootNotAllowed.Error()},
+			},
+		},
+		"invalid mac":  {req: &GetBootConfigRequest{Mac: "nope"}, code: codes.InvalidArgument},
+		"invalid arch": {req: &GetBootConfigRequest{Mac: known.String(), Arch: "sparc"}, code: codes.InvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := c.GetBootConfig(context.Background(), tt.req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("got %v, want code %v", err, tt.code)
+			}
+			if diff := cmp.Diff(tt.want, got, protocmp.Transform()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/dhcp"
+	"github.com/tinkerbell/smee/internal/ipxe/script"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DHCPReplier returns the reply of the DHCP handler to a DHCP message, without sending it.
+type DHCPReplier interface {
+	Reply(ctx context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error)
+}
+
+// BootRenderer is a Renderer that also returns what the auto.ipxe script of a machine boots.
+type BootRenderer interface {
+	Renderer
+	Boot(ctx context.Context, mac net.HardwareAddr) (script.Boot, error)
+}
+
+// GetBootConfig implements AdminServer. The DHCP replies are those to a DHCPDISCOVER of the PXE firmware, and of the
+// iPXE binaries of Smee, of the requested architecture.
+func (s *Server) GetBootConfig(ctx context.Context, req *GetBootConfigRequest) (*BootConfig, error) {
+	mac, err := net.ParseMAC(req.Mac)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mac %q: %v", req.Mac, err)
+	}
+	bc := &BootConfig{Mac: mac.String()}
+	arch := req.Arch
+	if s.Backend != nil {
+		d, n, err := s.Backend.GetByMac(ctx, mac)
+		switch {
+		case err == nil:
+			bc.AllowNetboot = n.AllowNetboot
+			if arch == "" {
+				arch = d.Arch
+			}
+		case status.Code(backendError(err)) == codes.NotFound:
+			bc.Notes = append(bc.Notes, "the machine is not in the backend")
+		default:
+			return nil, backendError(err)
+		}
+	}
+	a, err := parseArch(arch)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	bc.Arch = a.String()
+
+	if s.DHCP == nil {
+		bc.Notes = append(bc.Notes, "the DHCP server is not enabled")
+	} else {
+		if bc.Firmware, err = s.previewDHCP(ctx, mac, a, ""); err != nil {
+			bc.Notes = append(bc.Notes, fmt.Sprintf("no DHCP reply to the PXE firmware: %v", err))
+		}
+		if bc.Ipxe, err = s.previewDHCP(ctx, mac, a, dhcp.Tinkerbell); err != nil {
+			bc.Notes = append(bc.Notes, fmt.Sprintf("no DHCP reply to iPXE: %v", err))
+		}
+	}
+
+	switch r := s.Renderer.(type) {
+	case nil:
+		bc.Notes = append(bc.Notes, "the HTTP iPXE script server is not enabled")
+	case BootRenderer:
+		b, err := r.Boot(ctx, mac)
+		if err != nil {
+			bc.Notes = append(bc.Notes, fmt.Sprintf("no auto.ipxe script: %v", err))
+			break
+		}
+		bc.Script, bc.CustomScript = b.Script, b.Custom
+		bc.OsieUrl, bc.TinkServer, bc.KernelParams = b.OSIEURL, b.TinkServer, b.KernelParams
+		if b.Pinned > 0 {
+			bc.Notes = append(bc.Notes, fmt.Sprintf("the machine is pinned to the snapshot %d of its auto.ipxe script, it is served the snapshot", b.Pinned))
+		}
+		if b.Held {
+			bc.Notes = append(bc.Notes, "the changed boot configuration of the machine is held by the rollout guard, it boots the configuration it last booted with")
+		}
+	default:
+		if bc.Script, err = r.Render(ctx, mac); err != nil {
+			bc.Notes = append(bc.Notes, fmt.Sprintf("no auto.ipxe script: %v", err))
+		}
+	}
+
+	return bc, nil
+}
+
+// previewDHCP returns the reply of the DHCP handler to a DHCPDISCOVER of mac, as a PXE client of arch with the user class.
+func (s *Server) previewDHCP(ctx context.Context, mac net.HardwareAddr, arch iana.Arch, uc dhcp.UserClass) (*DHCPReply, error) {
+	client := "PXEClient"
+	if slices.Contains([]iana.Arch{iana.EFI_X86_HTTP, iana.EFI_X86_64_HTTP, iana.EFI_ARM32_HTTP, iana.EFI_ARM64_HTTP, iana.EFI_RISCV64_HTTP}, arch) {
+		client = "HTTPClient"
+	}
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier(fmt.Sprintf("%s:Arch:%05d:UNDI:003001", client, arch))),
+		dhcpv4.WithOption(dhcpv4.OptClientArch(arch)),
+		dhcpv4.WithGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 1}),
+	}
+	if uc != "" {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptUserClass(uc.String())))
+	}
+	discover, err := dhcpv4.NewDiscovery(mac, mods...)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.DHCP.Reply(ctx, discover)
+	if err != nil {
+		return nil, err
+	}
+	r := &DHCPReply{MessageType: reply.MessageType().String(), BootFileName: reply.BootFileName}
+	if r.BootFileName == "" {
+		r.BootFileName = reply.BootFileNameOption()
+	}
+	if ip := reply.YourIPAddr; ip != nil && !ip.IsUnspecified() {
+		r.Ip = ip.String()
+	}
+	if ns := reply.ServerIPAddr; ns != nil && !ns.IsUnspecified() {
+		r.NextServer = ns.String()
+	}
+	codes := make([]uint8, 0, len(reply.Options))
+	for c := range reply.Options {
+		codes = append(codes, c)
+	}
+	slices.Sort(codes)
+	for _, c := range codes {
+		r.Options = append(r.Options, strings.TrimSpace(dhcpv4.Options{c: reply.Options[c]}.String()))
+	}
+
+	return r, nil
+}
+
+// parseArch returns the PXE firmware client architecture of a name of dhcp.ArchNames, a backend architecture, like
+// aarch64, or a DHCP option 93 number. It is x86_64 when name is empty.
+func parseArch(name string) (iana.Arch, error) {
+	switch name {
+	case "":
+		name = "x86_64"
+	case "aarch64":
+		name = "arm64"
+	}
+	if archs, ok := dhcp.ArchNames[name]; ok {
+		return archs[0], nil
+	}
+	n, err := strconv.ParseUint(name, 10, 16)
+	if err != nil {
+		return 0, errors.New("unknown arch " + strconv.Quote(name))
+	}
+
+	return iana.Arch(n), nil
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Caches holds the caches that can be flushed with the admin API, by name. The zero value is ready to use.
+type Caches struct {
+	mu sync.Mutex
+	// flush holds the flush funcs of every cache name, more than one instance of a cache can share a name.
+	flush map[string][]func() error
+}
+
+// Add adds a cache, flush is called when the named cache is flushed.
+func (c *Caches) Add(name string, flush func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.flush == nil {
+		c.flush = map[string][]func() error{}
+	}
+	c.flush[name] = append(c.flush[name], flush)
+}
+
+// Names returns the names of the caches, sorted.
+func (c *Caches) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.flush))
+	for n := range c.flush {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Flush flushes the named caches, all caches when no names are given. It returns the names of the flushed caches.
+// Unknown names are an error, no cache is flushed then.
+func (c *Caches) Flush(names ...string) ([]string, error) {
+	if len(names) == 0 {
+		names = c.Names()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, n := range names {
+		if _, ok := c.flush[n]; !ok {
+			return nil, fmt.Errorf("unknown cache %q", n)
+		}
+	}
+	var errs []error
+	flushed := make([]string, 0, len(names))
+	for _, n := range names {
+		var failed bool
+		for _, f := range c.flush[n] {
+			if err := f(); err != nil {
+				errs = append(errs, fmt.Errorf("flushing cache %q: %w", n, err))
+				failed = true
+			}
+		}
+		if !failed {
+			flushed = append(flushed, n)
+		}
+	}
+
+	return flushed, errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client is a client of the admin API.
+type Client struct {
+	AdminClient
+	conn *grpc.ClientConn
+}
+
+// Dial returns a client of the admin API at addr, see Listen for the address format.
+// The token, when set, is sent as a bearer token with every call.
+func Dial(addr, token string) (*Client, error) {
+	target := "passthrough:///" + addr
+	if network, address := splitAddr(addr); network == "unix" {
+		target = "unix://" + address
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(token)))
+	}
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{AdminClient: NewAdminClient(conn), conn: conn}, nil
+}
+
+// Close closes the connection to the admin API.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// bearerToken is a token sent in the authorization metadata of every call.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false, the admin API is served without TLS.
+func (bearerToken) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/tinkerbell/smee/internal/supervise"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetDebugInfo implements AdminServer.
+func (s *Server) GetDebugInfo(context.Context, *GetDebugInfoRequest) (*DebugInfo, error) {
+	info := &DebugInfo{Config: s.Config, GoVersion: runtime.Version()}
+	if s.Services != nil {
+		for _, st := range s.Services() {
+			info.Services = append(info.Services, serviceStatus(st))
+		}
+	}
+	g := s.Gatherer
+	if g == nil {
+		g = prometheus.DefaultGatherer
+	}
+	mfs, err := g.Gather()
+	if err != nil && len(mfs) == 0 {
+		return nil, status.Errorf(codes.Internal, "gathering metrics: %v", err)
+	}
+	var b bytes.Buffer
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(&b, mf); err != nil {
+			return nil, status.Errorf(codes.Internal, "encoding metrics: %v", err)
+		}
+	}
+	info.Metrics = b.String()
+	b.Reset()
+	if err := pprof.Lookup("goroutine").WriteTo(&b, 2); err != nil {
+		return nil, status.Errorf(codes.Internal, "dumping goroutines: %v", err)
+	}
+	info.Goroutines = b.String()
+
+	return info, nil
+}
+
+func serviceStatus(st supervise.Status) *ServiceStatus {
+	res := &ServiceStatus{Name: st.Name, State: st.State, Restarts: uint32(st.Restarts)} //nolint:gosec // restarts are positive.
+	if st.Err != nil {
+		res.Error = st.Err.Error()
+	}
+
+	return res
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is the number of events buffered per subscriber, events are dropped for slower subscribers.
+const subscriberBuffer = 64
+
+// Event is a boot event of a machine.
+type Event struct {
+	Time time.Time
+	MAC  net.HardwareAddr
+	// Type is the kind of event, "dhcp", "script", "http" or "phone-home".
+	Type string
+	// Detail is the DHCP message type, the script name or the URL path of an HTTP fetch.
+	Detail string
+}
+
+// Events records the last boot event and the HTTP fetches of the boot session of every machine, and streams the
+// events to subscribers. It implements script.Observer, handler.Observer and phonehome.Observer. The zero value is ready to use.
+type Events struct {
+	mu      sync.Mutex
+	last    map[string]Event
+	fetches map[string][]Fetch
+	// completed holds the machines whose boot session was completed by a phone home, their next fetch starts a new session.
+	completed map[string]bool
+	// subs holds the channel of every subscriber and the MAC address it subscribed to, empty for all machines.
+	subs map[chan Event]string
+}
+
+// ScriptServed implements script.Observer.
+func (e *Events) ScriptServed(_ context.Context, mac net.HardwareAddr, name string) {
+	e.Record(Event{MAC: mac, Type: "script", Detail: name})
+}
+
+// DHCPServed implements handler.Observer.
+func (e *Events) DHCPServed(_ context.Context, mac net.HardwareAddr, msgType string) {
+	e.Record(Event{MAC: mac, Type: "dhcp", Detail: msgType})
+}
+
+// BootCompleted implements phonehome.Observer, it completes the boot session of the machine.
+func (e *Events) BootCompleted(_ context.Context, mac net.HardwareAddr) {
+	e.mu.Lock()
+	if e.completed == nil {
+		e.completed = map[string]bool{}
+	}
+	e.completed[mac.String()] = true
+	e.mu.Unlock()
+	e.Record(Event{MAC: mac, Type: "phone-home", Detail: "boot completed"})
+}
+
+// Record records ev and sends it to the subscribers. The time of ev is set when it is zero.
+func (e *Events) Record(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.last == nil {
+		e.last = map[string]Event{}
+	}
+	e.last[ev.MAC.String()] = ev
+	for c, mac := range e.subs {
+		if mac != "" && mac != ev.MAC.String() {
+			continue
+		}
+		select {
+		case c <- ev:
+		default:
+			// the subscriber is too slow, the event is dropped for it.
+		}
+	}
+}
+
+// Subscribe returns a channel that receives the events of the machine with the MAC address, of all machines when mac is nil.
+// The returned func ends the subscription.
+func (e *Events) Subscribe(mac net.HardwareAddr) (<-chan Event, func()) {
+	c := make(chan Event, subscriberBuffer)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.subs == nil {
+		e.subs = map[chan Event]string{}
+	}
+	e.subs[c] = mac.String()
+
+	return c, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subs, c)
+	}
+}
+
+// Last returns the last event of the machine with the MAC address.
+func (e *Events) Last(mac net.HardwareAddr) (Event, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev, ok := e.last[mac.String()]
+
+	return ev, ok
+}
+
+// Machines returns the last event of every machine, sorted by MAC address.
+func (e *Events) Machines() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	evs := make([]Event, 0, len(e.last))
+	for _, ev := range e.last {
+		evs = append(evs, ev)
+	}
+	slices.SortFunc(evs, func(a, b Event) int { return strings.Compare(a.MAC.String(), b.MAC.String()) })
+
+	return evs
+}
+
+// Flush forgets the last events and the fetches of all machines.
+func (e *Events) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	clear(e.last)
+	clear(e.fetches)
+	clear(e.completed)
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package admin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+)
+
+// maxFetches is the maximum number of fetches that are kept per boot session, the oldest ones are dropped.
+const maxFetches = 64
+
+// The artifacts that machines fetch over HTTP.
+const (
+	ArtifactBinary = "binary"
+	ArtifactScript = "script"
+	ArtifactKernel = "kernel"
+	ArtifactInitrd = "initrd"
+	ArtifactISO    = "iso"
+	ArtifactOther  = "other"
+)
+
+// Fetch is a file that a machine fetched over HTTP.
+type Fetch struct {
+	Time time.Time
+	MAC  net.HardwareAddr
+	// Artifact is the kind of the file, one of the Artifact constants.
+	Artifact string
+	// Path is the URL path of the request.
+	Path   string
+	Status int
+	// Bytes is the number of body bytes that were sent.
+	Bytes int64
+	// Size is the Content-Length of a complete response, -1 when it is unknown.
+	Size int64
+}
+
+// incomplete returns whether the download of a complete response stopped before all of it was sent.
+func (f Fetch) incomplete() bool {
+	return f.Status == http.StatusOK && f.Size >= 0 && f.Bytes < f.Size
+}
+
+// Fetched records the fetch f in the boot session of its machine, as a boot event of type "http" with the path as detail.
+// A boot session starts with the fetch of an iPXE binary, or with the fetch of a script after a kernel, initrd or ISO,
+// when the machine booted again from a TFTP served binary, or with any fetch after the machine phoned home.
+func (e *Events) Fetched(f Fetch) {
+	if f.Time.IsZero() {
+		f.Time = time.Now()
+	}
+	e.mu.Lock()
+	if e.fetches == nil {
+		e.fetches = map[string][]Fetch{}
+	}
+	session := e.fetches[f.MAC.String()]
+	if e.completed[f.MAC.String()] || newSession(session, f) {
+		session = nil
+		delete(e.completed, f.MAC.String())
+	}
+	if len(session) == maxFetches {
+		session = session[1:]
+	}
+	e.fetches[f.MAC.String()] = append(session, f)
+	e.mu.Unlock()
+	e.Record(Event{Time: f.Time, MAC: f.MAC, Type: "http", Detail: f.Path})
+}
+
+// newSession returns whether the fetch f starts a new boot session after the fetches of session.
+func newSession(session []Fetch, f Fetch) bool {
+	if len(session) == 0 {
+		return false
+	}
+	switch f.Artifact {
+	case ArtifactBinary:
+		return session[len(session)-1].Artifact != ArtifactBinary
+	case ArtifactScript:
+		for _, s := range session {
+			if s.Artifact == ArtifactKernel || s.Artifact == ArtifactInitrd || s.Artifact == ArtifactISO {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Fetches returns the fetches of the current boot session of the machine with the MAC address, oldest first.
+func (e *Events) Fetches(mac net.HardwareAddr) []Fetch {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]Fetch(nil), e.fetches[mac.String()]...)
+}
+
+// Diagnose returns the problems that the fetches of a boot session point at, like a kernel that was fetched
+// without an initrd, or downloads that failed or stopped early.
+func Diagnose(fetches []Fetch) []string {
+	var problems []string
+	fetched := map[string]bool{}
+	for _, f := range fetches {
+		switch {
+		case f.Status >= http.StatusBadRequest:
+			problems = append(problems, fmt.Sprintf("fetching %s failed with status %d", f.Path, f.Status))
+		case f.incomplete():
+			problems = append(problems, fmt.Sprintf("the download of %s stopped after %d of %d bytes", f.Path, f.Bytes, f.Size))
+		default:
+			fetched[f.Artifact] = true
+		}
+	}
+	switch {
+	case fetched[ArtifactKernel] && !fetched[ArtifactInitrd]:
+		problems = append(problems, "fetched the kernel but not the initrd")
+	case fetched[ArtifactInitrd] && !fetched[ArtifactKernel]:
+		problems = append(problems, "fetched the initrd but not the kernel")
+	case fetched[ArtifactScript] && !fetched[ArtifactKernel] && !fetched[ArtifactISO]:
+		problems = append(problems, "fetched the script but not the kernel")
+	case fetched[ArtifactBinary] && !fetched[ArtifactScript]:
+		problems = append(problems, "fetched the iPXE binary but not the script")
+	}
+
+	return problems
+}
+
+// artifact returns the kind of the file at the URL path p.
+func artifact(p string) string {
+	name := path.Base(p)
+	switch {
+	case strings.HasSuffix(name, ".sha256"):
+		return ArtifactOther
+	case strings.HasPrefix(p, "/iso/"):
+		return ArtifactISO
+	case strings.HasSuffix(name, ".ipxe"), strings.HasPrefix(name, "grub.cfg"):
+		return ArtifactScript
+	case strings.HasPrefix(p, "/ipxe/"):
+		return ArtifactBinary
+	case strings.Contains(name, "vmlinuz"), strings.Contains(name, "kernel"):
+		return ArtifactKernel
+	case strings.Contains(name, "initramfs"), strings.Contains(name, "initrd"):
+		return ArtifactInitrd
+	}
+
+	return ArtifactOther
+}
+
+// FetchRecorder records the HTTP fetches of machines in Events.
+// The machine of a request is identified by a MAC address in the URL path, like /ipxe/<mac>/snp.efi,
+// or by the client IP address of an earlier request with a MAC address, or by the backend.
+type FetchRecorder struct {
+	Events *Events
+	// Backend, when set, looks up the machines of client IP addresses that weren't seen with a MAC address.
+	Backend handler.BackendReader
+
+	mu  sync.Mutex
+	ips map[netip.Addr]net.HardwareAddr
+}
+
+// Handler returns a http.HandlerFunc that calls next and records the fetch of the machine of the request, if identified.
+func (fr *FetchRecorder) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingWriter{ResponseWriter: w}
+		next(cw, r)
+		if fr == nil || fr.Events == nil {
+			return
+		}
+		mac, ok := fr.machine(r)
+		if !ok {
+			return
+		}
+		f := Fetch{MAC: mac, Artifact: artifact(r.URL.Path), Path: r.URL.Path, Status: cw.status, Bytes: cw.written, Size: -1}
+		if f.Status == 0 {
+			f.Status = http.StatusOK
+		}
+		if cl, err := strconv.ParseInt(cw.Header().Get("Content-Length"), 10, 64); err == nil && r.Method != http.MethodHead {
+			f.Size = cl
+		}
+		fr.Events.Fetched(f)
+	}
+}
+
+// machine returns the MAC address of the machine of r.
+func (fr *FetchRecorder) machine(r *http.Request) (net.HardwareAddr, bool) {
+	var ip netip.Addr
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ip = ap.Addr().Unmap()
+	}
+	for _, elem := range strings.Split(r.URL.Path, "/") {
+		if mac, err := net.ParseMAC(elem); err == nil {
+			if ip.IsValid() {
+				fr.learn(ip, mac)
+			}
+			return mac, true
+		}
+	}
+	if !ip.IsValid() {
+		return nil, false
+	}
+	fr.mu.Lock()
+	mac, ok := fr.ips[ip]
+	fr.mu.Unlock()
+	if ok || fr.Backend == nil {
+		return mac, ok
+	}
+	d, _, err := fr.Backend.GetByIP(r.Context(), net.IP(ip.AsSlice()))
+	if err != nil || d == nil || d.MACAddress == nil {
+		return nil, false
+	}
+	fr.learn(ip, d.MACAddress)
+
+	return d.MACAddress, true
+}
+
+// learn remembers that the machine with the MAC address uses the client IP address ip.
+func (fr *FetchRecorder) learn(ip netip.Addr, mac net.HardwareAddr) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.ips == nil {
+		fr.ips = map[netip.Addr]net.HardwareAddr{}
+	}
+	fr.ips[ip] = mac
+}
+
+// countingWriter records the status and the number of body bytes of a response.
+type countingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (c *countingWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	n, err := c.ResponseWriter.Write(b)
+	c.written += int64(n)
+
+	return n, err
+}
+
+// Unwrap returns the ResponseWriter, for http.ResponseController.
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestFetchRecorder(t *testing.T) {
+	e := &Events{}
+	fr := &FetchRecorder{Events: e, Backend: fakeBackend{}}
+	h := fr.Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/osie/v0.10.0/initramfs-x86_64" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", "4")
+		_, _ = w.Write([]byte("boot"))
+	})
+	requests := []struct {
+		client string
+		path   string
+	}{
+		{client: "192.168.2.10:1000", path: "/ipxe/" + known.String() + "/snp.efi"},
+		{client: "192.168.2.10:1001", path: "/auto.ipxe"},
+		{client: "192.168.2.10:1002", path: "/osie/v0.10.0/vmlinuz-x86_64"},
+		{client: "192.168.2.10:1003", path: "/osie/v0.10.0/initramfs-x86_64"},
+		// the machine of the client isn't known, the fetch isn't recorded.
+		{client: "192.168.2.11:1000", path: "/osie/v0.10.0/vmlinuz-x86_64"},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(http.MethodGet, req.path, nil)
+		r.RemoteAddr = req.client
+		h(httptest.NewRecorder(), r)
+	}
+
+	got := e.Fetches(known)
+	for i := range got {
+		got[i].Time = time.Time{}
+	}
+	want := []Fetch{
+		{MAC: known, Artifact: ArtifactBinary, Path: "/ipxe/" + known.String() + "/snp.efi", Status: http.StatusOK, Bytes: 4, Size: 4},
+		{MAC: known, Artifact: ArtifactScript, Path: "/auto.ipxe", Status: http.StatusOK, Bytes: 4, Size: 4},
+		{MAC: known, Artifact: ArtifactKernel, Path: "/osie/v0.10.0/vmlinuz-x86_64", Status: http.StatusOK, Bytes: 4, Size: 4},
+		{MAC: known, Artifact: ArtifactInitrd, Path: "/osie/v0.10.0/initramfs-x86_64", Status: http.StatusNotFound, Bytes: 19, Size: -1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if ev, ok := e.Last(known); !ok || ev.Type != "http" || ev.Detail != "/osie/v0.10.0/initramfs-x86_64" {
+		t.Fatalf("got last event %v, want the initrd fetch", ev)
+	}
+	if len(e.Machines()) != 1 {
+		t.Fatalf("got %d machines, want 1", len(e.Machines()))
+	}
+
+	// a new boot starts with the fetch of the binary.
+	r := httptest.NewRequest(http.MethodGet, "/ipxe/"+known.String()+"/snp.efi", nil)
+	h(httptest.NewRecorder(), r)
+	if got := e.Fetches(known); len(got) != 1 {
+		t.Fatalf("got %d fetches in the new boot session, want 1", len(got))
+	}
+}
+
+func TestBootCompleted(t *testing.T) {
+	mac := net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+	e := &Events{}
+	e.Fetched(Fetch{MAC: mac, Artifact: ArtifactKernel, Path: "/vmlinuz", Status: http.StatusOK})
+	e.BootCompleted(context.Background(), mac)
+	if ev, ok := e.Last(mac); !ok || ev.Type != "phone-home" {
+		t.Fatalf("got last event %v, want the phone home", ev)
+	}
+	if got := e.Fetches(mac); len(got) != 1 {
+		t.Fatalf("got %d fetches in the completed boot session, want 1", len(got))
+	}
+
+	// the installed OS fetching a file starts a new session.
+	e.Fetched(Fetch{MAC: mac, Artifact: ArtifactOther, Path: "/metadata", Status: http.StatusOK})
+	e.Fetched(Fetch{MAC: mac, Artifact: ArtifactOther, Path: "/userdata", Status: http.StatusOK})
+	if got := e.Fetches(mac); len(got) != 2 {
+		t.Fatalf("got %d fetches in the new boot session, want 2", len(got))
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	ok := func(artifact, path string) Fetch {
+		return Fetch{Artifact: artifact, Path: path, Status: http.StatusOK, Bytes: 10, Size: 10}
+	}
+	tests := map[string]struct {
+		fetches []Fetch
+		want    []string
+	}{
+		"complete boot": {
+			fetches: []Fetch{ok(ArtifactBinary, "/ipxe/snp.efi"), ok(ArtifactScript, "/auto.ipxe"), ok(ArtifactKernel, "/vmlinuz"), ok(ArtifactInitrd, "/initramfs")},
+		},
+		"iso boot": {
+			fetches: []Fetch{ok(ArtifactScript, "/auto.ipxe"), {Artifact: ArtifactISO, Path: "/iso/hook.iso", Status: http.StatusPartialContent, Bytes: 5, Size: 5}},
+		},
+		"kernel without initrd": {
+			fetches: []Fetch{ok(ArtifactScript, "/auto.ipxe"), ok(ArtifactKernel, "/vmlinuz")},
+			want:    []string{"fetched the kernel but not the initrd"},
+		},
+		"binary without script": {
+			fetches: []Fetch{ok(ArtifactBinary, "/ipxe/snp.efi")},
+			want:    []string{"fetched the iPXE binary but not the script"},
+		},
+		"script without kernel": {
+			fetches: []Fetch{ok(ArtifactBinary, "/ipxe/snp.efi"), ok(ArtifactScript, "/auto.ipxe")},
+			want:    []string{"fetched the script but not the kernel"},
+		},
+		"failed initrd": {
+			fetches: []Fetch{ok(ArtifactKernel, "/vmlinuz"), {Artifact: ArtifactInitrd, Path: "/initramfs", Status: http.StatusNotFound, Size: -1}},
+			want:    []string{"fetching /initramfs failed with status 404", "fetched the kernel but not the initrd"},
+		},
+		"incomplete initrd": {
+			fetches: []Fetch{ok(ArtifactKernel, "/vmlinuz"), {Artifact: ArtifactInitrd, Path: "/initramfs", Status: http.StatusOK, Bytes: 3, Size: 10}},
+			want:    []string{"the download of /initramfs stopped after 3 of 10 bytes", "fetched the kernel but not the initrd"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, Diagnose(tt.fetches)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	tests := map[string]string{
+		"/ipxe/snp.efi":                         ArtifactBinary,
+		"/ipxe/00:01:02:03:04:05/ipxe.efi":      ArtifactBinary,
+		"/ipxe/grub.cfg-01-00-01-02-03-04-05":   ArtifactScript,
+		"/00:01:02:03:04:05/auto.ipxe":          ArtifactScript,
+		"/osie/v0.10.0/vmlinuz-x86_64":          ArtifactKernel,
+		"/osie/v0.10.0/initramfs-x86_64":        ArtifactInitrd,
+		"/osie/v0.10.0/initramfs-x86_64.sha256": ArtifactOther,
+		"/iso/00:01:02:03:04:05/hook.iso":       ArtifactISO,
+		"/metadata":                             ArtifactOther,
+	}
+	for p, want := range tests {
+		if got := artifact(p); got != want {
+			t.Errorf("artifact(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestGetMachineFetches(t *testing.T) {
+	s := newServer()
+	at := time.Unix(1700000000, 0)
+	s.Events.Fetched(Fetch{Time: at, MAC: known, Artifact: ArtifactKernel, Path: "/vmlinuz", Status: http.StatusOK, Bytes: 10, Size: 10})
+	c := serve(t, s, "secret")
+
+	got, err := c.GetMachine(context.Background(), &GetMachineRequest{Mac: known.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*HTTPFetch{{Time: timestamppb.New(at), Artifact: ArtifactKernel, Path: "/vmlinuz", Status: http.StatusOK, Bytes: 10, Size: 10}}
+	if diff := cmp.Diff(want, got.Fetches, protocmp.Transform()); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff([]string{"fetched the kernel but not the initrd"}, got.Diagnostics); diff != "" {
+		t.Fatal(diff)
+	}
+
+	list, err := c.ListMachines(context.Background(), &ListMachinesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Machines) != 1 || len(list.Machines[0].Diagnostics) != 1 {
+		t.Fatalf("got machines %v, want one with a diagnostic", list.Machines)
+	}
+}
@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ListLeases implements AdminServer. The leases of the lease table are merged with the reservations of the backend,
+// when the backend is a handler.BackendLister. A reservation of an IP address that is leased to another machine is
+// not returned, the lease is.
+func (s *Server) ListLeases(ctx context.Context, _ *ListLeasesRequest) (*ListLeasesResponse, error) {
+	now := time.Now()
+	byIP := map[netip.Addr]*Lease{}
+	for _, l := range s.Leases.List() {
+		state := "active"
+		if now.After(l.Expires) {
+			state = "expired"
+		}
+		byIP[l.IP] = &Lease{Mac: l.MAC.String(), Ip: l.IP.String(), State: state, Expires: timestamppb.New(l.Expires)}
+	}
+	if bl, ok := s.Backend.(handler.BackendLister); ok {
+		recs, err := bl.List(ctx)
+		if err != nil {
+			return nil, backendError(err)
+		}
+		for _, r := range recs {
+			if r.DHCP == nil || !r.DHCP.IPAddress.IsValid() {
+				continue
+			}
+			ip, mac := r.DHCP.IPAddress.Unmap(), r.DHCP.MACAddress.String()
+			l, ok := byIP[ip]
+			if !ok {
+				l = &Lease{Mac: mac, Ip: ip.String(), State: "reserved"}
+				byIP[ip] = l
+			}
+			if l.Mac == mac {
+				l.Hostname = r.DHCP.Hostname
+			}
+		}
+	}
+	ips := make([]netip.Addr, 0, len(byIP))
+	for ip := range byIP {
+		ips = append(ips, ip)
+	}
+	slices.SortFunc(ips, netip.Addr.Compare)
+	resp := &ListLeasesResponse{Leases: make([]*Lease, 0, len(ips))}
+	for _, ip := range ips {
+		l := byIP[ip]
+		if mac, err := net.ParseMAC(l.Mac); err == nil && s.Events != nil {
+			if ev, ok := s.Events.Last(mac); ok {
+				l.LastActivity = timestamppb.New(ev.Time)
+			}
+		}
+		resp.Leases = append(resp.Leases, l)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/tinkerbell/smee/internal/snapshot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ListSnapshots implements AdminServer.
+func (s *Server) ListSnapshots(_ context.Context, req *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	mac, err := s.snapshotMAC(req.Mac)
+	if err != nil {
+		return nil, err
+	}
+	list, pinned := s.Snapshots.List(mac)
+	res := &ListSnapshotsResponse{}
+	for _, sn := range list {
+		p := toSnapshot(sn)
+		p.Content = ""
+		res.Snapshots = append(res.Snapshots, p)
+	}
+	if pinned != nil {
+		res.Pinned = toSnapshot(*pinned)
+		res.Pinned.Content = ""
+	}
+
+	return res, nil
+}
+
+// DiffSnapshots implements AdminServer. The default versions, both 0, compare the one before the latest to the latest.
+func (s *Server) DiffSnapshots(_ context.Context, req *DiffSnapshotsRequest) (*DiffSnapshotsResponse, error) {
+	mac, err := s.snapshotMAC(req.Mac)
+	if err != nil {
+		return nil, err
+	}
+	from := req.From
+	if from == 0 && req.To == 0 {
+		from = -1
+	}
+	f, err := s.Snapshots.Get(mac, int(from))
+	if err != nil {
+		return nil, snapshotError(err, from)
+	}
+	t, err := s.Snapshots.Get(mac, int(req.To))
+	if err != nil {
+		return nil, snapshotError(err, req.To)
+	}
+
+	return &DiffSnapshotsResponse{From: toSnapshot(f), To: toSnapshot(t), Diff: snapshot.Diff(f, t)}, nil
+}
+
+// PinSnapshot implements AdminServer.
+func (s *Server) PinSnapshot(_ context.Context, req *PinSnapshotRequest) (*PinSnapshotResponse, error) {
+	mac, err := s.snapshotMAC(req.Mac)
+	if err != nil {
+		return nil, err
+	}
+	if req.Unpin {
+		if err := s.Snapshots.Unpin(mac); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		s.Log.Info("machine unpinned from its snapshot", "mac", mac.String())
+
+		return &PinSnapshotResponse{}, nil
+	}
+	sn, err := s.Snapshots.Pin(mac, int(req.Version))
+	if err != nil {
+		return nil, snapshotError(err, req.Version)
+	}
+	s.Log.Info("machine pinned to a snapshot", "mac", mac.String(), "script", sn.Name, "version", sn.Version)
+
+	return &PinSnapshotResponse{Pinned: toSnapshot(sn)}, nil
+}
+
+// snapshotMAC returns the parsed mac of a snapshot request.
+func (s *Server) snapshotMAC(mac string) (net.HardwareAddr, error) {
+	if s.Snapshots == nil {
+		return nil, status.Error(codes.Unimplemented, "snapshots are not enabled")
+	}
+	m, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mac %q: %v", mac, err)
+	}
+
+	return m, nil
+}
+
+func snapshotError(err error, version int64) error {
+	if errors.Is(err, snapshot.ErrNotFound) {
+		return status.Errorf(codes.NotFound, "snapshot %d not found", version)
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toSnapshot(sn snapshot.Snapshot) *Snapshot {
+	return &Snapshot{Version: int64(sn.Version), Name: sn.Name, Time: timestamppb.New(sn.Time), Content: sn.Content}
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"sync"
+
+	"github.com/tinkerbell/smee/internal/syslog"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Syslog streams the syslog messages that Smee receives to subscribers.
+// It implements syslog.Observer. The zero value is ready to use.
+type Syslog struct {
+	mu sync.Mutex
+	// subs holds the channel of every subscriber and the host IP address it subscribed to, empty for all hosts.
+	subs map[chan *SyslogMessage]string
+}
+
+// SyslogReceived implements syslog.Observer.
+func (s *Syslog) SyslogReceived(m syslog.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subs) == 0 {
+		return
+	}
+	msg := &SyslogMessage{
+		Time:     timestamppb.New(m.Time),
+		Host:     m.Host.String(),
+		Hostname: m.Hostname,
+		Facility: m.Facility,
+		Severity: m.Severity,
+		AppName:  m.AppName,
+		ProcId:   m.ProcID,
+		MsgId:    m.MsgID,
+		Msg:      m.Msg,
+	}
+	if m.MAC != nil {
+		msg.Mac = m.MAC.String()
+	}
+	for c, host := range s.subs {
+		if host != "" && host != msg.Host {
+			continue
+		}
+		select {
+		case c <- msg:
+		default:
+			// the subscriber is too slow, the message is dropped for it.
+		}
+	}
+}
+
+// Subscribe returns a channel that receives the messages sent from the host IP address, from all hosts when host is empty.
+// The returned func ends the subscription.
+func (s *Syslog) Subscribe(host string) (<-chan *SyslogMessage, func()) {
+	c := make(chan *SyslogMessage, subscriberBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = map[chan *SyslogMessage]string{}
+	}
+	s.subs[c] = host
+
+	return c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, c)
+	}
+}
@@ -0,0 +1,72 @@
+// Package conformance runs the conformance test suite of plugin/backendtest against the backends built into Smee.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/plugin"
+	"github.com/tinkerbell/smee/plugin/backendtest"
+)
+
+// Reader is the interface of the backends built into Smee, see handler.BackendReader.
+type Reader interface {
+	GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error)
+	GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error)
+}
+
+// Run runs the suite against r, which must have the backendtest.Machines fixtures.
+// The not found error of the backends built into Smee is an error with a NotFound() bool method that returns true,
+// the not found error that the metadata and admin APIs look for, unless opts sets another one.
+func Run(t *testing.T, r Reader, opts backendtest.Options) {
+	t.Helper()
+	if opts.NotFound == nil {
+		opts.NotFound = NotFound
+	}
+	backendtest.Run(t, reader{r: r}, opts)
+}
+
+// NotFound reports whether err is a not found error of a backend built into Smee.
+func NotFound(err error) bool {
+	type notFound interface {
+		NotFound() bool
+	}
+	var nf notFound
+
+	return errors.As(err, &nf) && nf.NotFound()
+}
+
+// reader converts the data of a Reader to the data of a plugin.Backend.
+type reader struct {
+	r Reader
+}
+
+func (r reader) GetByMac(ctx context.Context, mac net.HardwareAddr) (*plugin.DHCP, *plugin.Netboot, error) {
+	return convert(r.r.GetByMac(ctx, mac))
+}
+
+func (r reader) GetByIP(ctx context.Context, ip net.IP) (*plugin.DHCP, *plugin.Netboot, error) {
+	return convert(r.r.GetByIP(ctx, ip))
+}
+
+// convert keeps the data that is returned with an error, so that the suite can check that there is none.
+func convert(d *data.DHCP, n *data.Netboot, err error) (*plugin.DHCP, *plugin.Netboot, error) {
+	var pn *plugin.Netboot
+	if n != nil {
+		pn = &plugin.Netboot{
+			AllowNetboot:  n.AllowNetboot,
+			IPXEScriptURL: n.IPXEScriptURL,
+			IPXEScript:    n.IPXEScript,
+			Console:       n.Console,
+			Facility:      n.Facility,
+			Profile:       n.Profile,
+			OSIE:          plugin.OSIE(n.OSIE),
+			Labels:        n.Labels,
+		}
+	}
+
+	return (*plugin.DHCP)(d), pn, err
+}
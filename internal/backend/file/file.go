@@ -2,13 +2,16 @@
 package file
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"maps"
 	"net"
 	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
@@ -19,6 +22,7 @@ import (
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const tracerName = "github.com/tinkerbell/smee/dhcp"
@@ -27,12 +31,20 @@ const tracerName = "github.com/tinkerbell/smee/dhcp"
 var (
 	// errFileFormat is returned when the file is not in the correct format, e.g. not valid YAML.
 	errFileFormat     = fmt.Errorf("invalid file format")
-	errRecordNotFound = fmt.Errorf("record not found")
+	errRecordNotFound = recordNotFoundError{}
 	errParseIP        = fmt.Errorf("failed to parse IP from File")
 	errParseSubnet    = fmt.Errorf("failed to parse subnet mask from File")
 	errParseURL       = fmt.Errorf("failed to parse URL")
 )
 
+// recordNotFoundError is returned when there is no record for a machine.
+// The metadata and admin APIs respond with not found to it, as to the not found error of the kubernetes backend.
+type recordNotFoundError struct{}
+
+func (recordNotFoundError) NotFound() bool { return true }
+
+func (recordNotFoundError) Error() string { return "record not found" }
+
 // netboot is the structure for the data expected in a file.
 type netboot struct {
 	AllowPXE      bool   `yaml:"allowPxe"`      // If true, the client will be provided netboot options in the DHCP offer/ack.
@@ -40,6 +52,7 @@ type netboot struct {
 	IPXEScript    string `yaml:"ipxeScript"`    // Overrides a default value that is passed into DHCP on startup.
 	Console       string `yaml:"console"`
 	Facility      string `yaml:"facility"`
+	Profile       string `yaml:"profile"` // Name of the boot profile of the machine.
 }
 
 // dhcp is the structure for the data expected in a file.
@@ -59,6 +72,8 @@ type dhcp struct {
 	DomainSearch     []string         `yaml:"domainSearch"`     // DHCP option 119.
 	Disabled         bool             // If true, no DHCP response should be sent.
 	Netboot          netboot          `yaml:"netboot"`
+	SystemUUID       string           `yaml:"systemUUID"` // SMBIOS system UUID of the machine, used for lookups by identity.
+	Serial           string           `yaml:"serial"`     // Serial number of the machine, used for lookups by identity.
 }
 
 // Watcher represents the backend for watching a file for changes and updating the in memory DHCP data.
@@ -130,8 +145,10 @@ func (w *Watcher) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHC
 
 				return nil, nil, err
 			}
-			span.SetAttributes(d.EncodeToAttributes()...)
-			span.SetAttributes(n.EncodeToAttributes()...)
+			if span.IsRecording() {
+				span.SetAttributes(d.EncodeToAttributes()...)
+				span.SetAttributes(n.EncodeToAttributes()...)
+			}
 			span.SetStatus(codes.Ok, "")
 
 			return d, n, nil
@@ -182,8 +199,10 @@ func (w *Watcher) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Net
 
 				return nil, nil, err
 			}
-			span.SetAttributes(d.EncodeToAttributes()...)
-			span.SetAttributes(n.EncodeToAttributes()...)
+			if span.IsRecording() {
+				span.SetAttributes(d.EncodeToAttributes()...)
+				span.SetAttributes(n.EncodeToAttributes()...)
+			}
 			span.SetStatus(codes.Ok, "")
 
 			return d, n, nil
@@ -196,6 +215,128 @@ func (w *Watcher) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Net
 	return nil, nil, err
 }
 
+// GetByUUID implements the handler.BackendIdentityReader interface.
+// It reads the record with the systemUUID, compared case insensitively, from the in memory data (w.data).
+// A machine with multiple network interfaces has a record for each, the first record by mac address is returned.
+func (w *Watcher) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.file.GetByUUID")
+	defer span.End()
+
+	return w.getBy(span, "uuid="+uuid, func(v dhcp) bool { return uuid != "" && strings.EqualFold(v.SystemUUID, uuid) })
+}
+
+// GetBySerial implements the handler.BackendIdentityReader interface.
+// It reads the record with the serial from the in memory data (w.data).
+// A machine with multiple network interfaces has a record for each, the first record by mac address is returned.
+func (w *Watcher) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.file.GetBySerial")
+	defer span.End()
+
+	return w.getBy(span, "serial="+serial, func(v dhcp) bool { return serial != "" && v.Serial == serial })
+}
+
+// GetByHostname implements the handler.BackendIdentityReader interface.
+// It reads the record with the hostname from the in memory data (w.data).
+func (w *Watcher) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.file.GetByHostname")
+	defer span.End()
+
+	return w.getBy(span, "hostname="+hostname, func(v dhcp) bool { return hostname != "" && v.Hostname == hostname })
+}
+
+// getBy reads the first record, by mac address, for which match returns true from the in memory data (w.data).
+func (w *Watcher) getBy(span trace.Span, desc string, match func(dhcp) bool) (*data.DHCP, *data.Netboot, error) {
+	w.dataMu.RLock()
+	d := w.data
+	w.dataMu.RUnlock()
+	r := make(map[string]dhcp)
+	if err := yaml.Unmarshal(d, &r); err != nil {
+		err := fmt.Errorf("%w: %w", err, errFileFormat)
+		w.Log.Error(err, "failed to unmarshal file data")
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	for _, k := range slices.Sorted(maps.Keys(r)) {
+		v := r[k]
+		if !match(v) {
+			continue
+		}
+		mac, err := net.ParseMAC(k)
+		if err != nil {
+			err := fmt.Errorf("%w: %w", err, errFileFormat)
+			w.Log.Error(err, "failed to parse mac address")
+			span.SetStatus(codes.Error, err.Error())
+
+			return nil, nil, err
+		}
+		v.MACAddress = mac
+		d, n, err := w.translate(v)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+
+			return nil, nil, err
+		}
+		if span.IsRecording() {
+			span.SetAttributes(d.EncodeToAttributes()...)
+			span.SetAttributes(n.EncodeToAttributes()...)
+		}
+		span.SetStatus(codes.Ok, "")
+
+		return d, n, nil
+	}
+
+	err := fmt.Errorf("%w: %s", errRecordNotFound, desc)
+	span.SetStatus(codes.Error, err.Error())
+
+	return nil, nil, err
+}
+
+// List implements the handler.BackendLister interface.
+// It returns every valid record of the in memory data (w.data), sorted by mac address.
+// Records that fail to translate are logged and skipped.
+func (w *Watcher) List(ctx context.Context) ([]data.Record, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.file.List")
+	defer span.End()
+
+	w.dataMu.RLock()
+	d := w.data
+	w.dataMu.RUnlock()
+	r := make(map[string]dhcp)
+	if err := yaml.Unmarshal(d, &r); err != nil {
+		err := fmt.Errorf("%w: %w", err, errFileFormat)
+		w.Log.Error(err, "failed to unmarshal file data")
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, err
+	}
+	records := make([]data.Record, 0, len(r))
+	for k, v := range r {
+		mac, err := net.ParseMAC(k)
+		if err != nil {
+			w.Log.Error(fmt.Errorf("%w: %w", err, errFileFormat), "skipping record", "mac", k)
+			continue
+		}
+		v.MACAddress = mac
+		d, n, err := w.translate(v)
+		if err != nil {
+			w.Log.Error(err, "skipping record", "mac", mac)
+			continue
+		}
+		records = append(records, data.Record{DHCP: d, Netboot: n})
+	}
+	slices.SortFunc(records, func(a, b data.Record) int {
+		return bytes.Compare(a.DHCP.MACAddress, b.DHCP.MACAddress)
+	})
+	span.SetStatus(codes.Ok, "")
+
+	return records, nil
+}
+
 // Start starts watching a file for changes and updates the in memory data (w.data) on changes.
 // Start is a blocking method. Use a context cancellation to exit.
 func (w *Watcher) Start(ctx context.Context) {
@@ -210,16 +351,9 @@ func (w *Watcher) Start(ctx context.Context) {
 			}
 			if event.Op&fsnotify.Write == fsnotify.Write {
 				w.Log.Info("file changed, updating cache")
-				w.fileMu.RLock()
-				d, err := os.ReadFile(w.FilePath)
-				w.fileMu.RUnlock()
-				if err != nil {
+				if err := w.Reload(); err != nil {
 					w.Log.Error(err, "failed to read file", "file", w.FilePath)
-					break
 				}
-				w.dataMu.Lock()
-				w.data = d
-				w.dataMu.Unlock()
 			}
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
@@ -230,6 +364,21 @@ func (w *Watcher) Start(ctx context.Context) {
 	}
 }
 
+// Reload replaces the in memory data (w.data) with the contents of the file.
+func (w *Watcher) Reload() error {
+	w.fileMu.RLock()
+	d, err := os.ReadFile(w.FilePath)
+	w.fileMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	w.dataMu.Lock()
+	w.data = d
+	w.dataMu.Unlock()
+
+	return nil
+}
+
 // translate converts the data from the file into a data.DHCP and data.Netboot structs.
 func (w *Watcher) translate(r dhcp) (*data.DHCP, *data.Netboot, error) {
 	d := new(data.DHCP)
@@ -336,5 +485,10 @@ func (w *Watcher) translate(r dhcp) (*data.DHCP, *data.Netboot, error) {
 		n.Facility = r.Netboot.Facility
 	}
 
+	// boot profile
+	if r.Netboot.Profile != "" {
+		n.Profile = r.Netboot.Profile
+	}
+
 	return d, n, nil
 }
@@ -15,11 +15,14 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/ghodss/yaml"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/stdr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/smee/internal/backend/conformance"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/plugin/backendtest"
 )
 
 func TestNewWatcher(t *testing.T) {
@@ -242,6 +245,7 @@ func TestTranslate(t *testing.T) {
 			IPXEScript:    "#!ipxe\nchain http://boot.netboot.xyz",
 			Console:       "ttyS0",
 			Facility:      "onprem",
+			Profile:       "burnin",
 		},
 	}
 	wantDHCP := &data.DHCP{
@@ -265,6 +269,7 @@ func TestTranslate(t *testing.T) {
 		IPXEScript:    "#!ipxe\nchain http://boot.netboot.xyz",
 		Console:       "ttyS0",
 		Facility:      "onprem",
+		Profile:       "burnin",
 	}
 	w := &Watcher{Log: logr.Discard()}
 	gotDHCP, gotNetboot, err := w.translate(input)
@@ -337,6 +342,41 @@ func TestGetByMac(t *testing.T) {
 	}
 }
 
+func TestGetByIdentity(t *testing.T) {
+	w, err := NewWatcher(logr.Discard(), "testdata/example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		get     func(context.Context, string) (*data.DHCP, *data.Netboot, error)
+		value   string
+		wantMAC string
+		wantErr error
+	}{
+		"by uuid":            {get: w.GetByUUID, value: "4C4C4544-0051-3410-8058-B4C04F4A5032", wantMAC: "08:00:27:29:4e:67"},
+		"by serial":          {get: w.GetBySerial, value: "CZ2D3S0ABC", wantMAC: "52:54:00:aa:88:2a"},
+		"by hostname":        {get: w.GetByHostname, value: "pxe-proxmox", wantMAC: "86:96:b0:6e:ca:36"},
+		"uuid not found":     {get: w.GetByUUID, value: "00000000-0000-0000-0000-000000000001", wantErr: errRecordNotFound},
+		"hostname not found": {get: w.GetByHostname, value: "pxe-unknown", wantErr: errRecordNotFound},
+		"empty":              {get: w.GetBySerial, wantErr: errRecordNotFound},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, _, err := tt.get(context.Background(), tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatal(err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.wantMAC, d.MACAddress.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestGetByIP(t *testing.T) {
 	tests := map[string]struct {
 		ip      net.IP
@@ -370,3 +410,44 @@ func TestGetByIP(t *testing.T) {
 		})
 	}
 }
+
+func TestList(t *testing.T) {
+	w, err := NewWatcher(logr.Discard(), "testdata/example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := w.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var macs []string
+	for _, r := range got {
+		macs = append(macs, r.DHCP.MACAddress.String())
+	}
+	// the record with bad data is skipped.
+	want := []string{"08:00:27:29:4e:67", "52:54:00:aa:88:2a", "86:96:b0:6e:ca:36", "b4:96:91:6f:33:d0"}
+	if diff := cmp.Diff(want, macs); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	records := make(map[string]dhcp)
+	for _, m := range backendtest.Machines() {
+		records[m.DHCP.MACAddress.String()] = dhcp{
+			IPAddress:      m.DHCP.IPAddress.String(),
+			SubnetMask:     net.IP(m.DHCP.SubnetMask).String(),
+			DefaultGateway: m.DHCP.DefaultGateway.String(),
+			NameServers:    []string{m.DHCP.NameServers[0].String()},
+			Hostname:       m.DHCP.Hostname,
+			LeaseTime:      int(m.DHCP.LeaseTime),
+			Arch:           m.DHCP.Arch,
+			Netboot:        netboot{AllowPXE: m.Netboot.AllowNetboot, Facility: m.Netboot.Facility},
+		}
+	}
+	b, err := yaml.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformance.Run(t, &Watcher{Log: logr.Discard(), data: b}, backendtest.Options{})
+}
@@ -0,0 +1,69 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// EnrollmentLabel is the label key set on Hardware objects created for discovered machines.
+	EnrollmentLabel = "smee.tinkerbell.org/enrollment"
+	// EnrollmentUnenrolled is the EnrollmentLabel value for discovered machines that have not been enrolled.
+	EnrollmentUnenrolled = "unenrolled"
+	// DiscoveredAtAnnotation is the annotation key holding the RFC3339 timestamp of when a machine was discovered.
+	DiscoveredAtAnnotation = "smee.tinkerbell.org/discovered-at"
+)
+
+// Enroll creates a minimal Hardware object for a machine that is unknown to the backend.
+// The Hardware object is created in the Backend Namespace, labeled as unenrolled and annotated
+// with the discovery timestamp. An already existing Hardware object is not an error.
+func (b *Backend) Enroll(ctx context.Context, mac net.HardwareAddr, arch string) error {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.Enroll")
+	defer span.End()
+
+	hw := discoveredHardware(b.Namespace, mac, arch, time.Now())
+	if err := b.cluster.GetClient().Create(ctx, hw); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			span.SetStatus(codes.Ok, "hardware already exists")
+			return nil
+		}
+		span.SetStatus(codes.Error, err.Error())
+
+		return fmt.Errorf("failed creating hardware for (%v): %w", mac, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return nil
+}
+
+// discoveredHardware returns the Hardware object used to enroll a discovered machine.
+func discoveredHardware(namespace string, mac net.HardwareAddr, arch string, now time.Time) *v1alpha1.Hardware {
+	return &v1alpha1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("discovered-%s", strings.ReplaceAll(mac.String(), ":", "")),
+			Namespace:   namespace,
+			Labels:      map[string]string{EnrollmentLabel: EnrollmentUnenrolled},
+			Annotations: map[string]string{DiscoveredAtAnnotation: now.UTC().Format(time.RFC3339)},
+		},
+		Spec: v1alpha1.HardwareSpec{
+			Interfaces: []v1alpha1.Interface{
+				{
+					DHCP: &v1alpha1.DHCP{
+						MAC:  mac.String(),
+						Arch: arch,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,71 @@
+package kube
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+)
+
+func TestEnroll(t *testing.T) {
+	rs := runtime.NewScheme()
+	if err := scheme.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	cl := fake.NewClientBuilder().WithScheme(rs).Build()
+	fn := func(o *cluster.Options) {
+		o.NewClient = func(*rest.Config, client.Options) (client.Client, error) {
+			return cl, nil
+		}
+		o.MapperProvider = func(*rest.Config, *http.Client) (meta.RESTMapper, error) {
+			return cl.RESTMapper(), nil
+		}
+		o.NewCache = func(*rest.Config, cache.Options) (cache.Cache, error) {
+			return &informertest.FakeInformers{Scheme: cl.Scheme()}, nil
+		}
+	}
+	b, err := NewBackend(new(rest.Config), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Namespace = "tink-system"
+
+	mac := net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+	if err := b.Enroll(context.Background(), mac, "x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	// enrolling an already discovered machine is not an error.
+	if err := b.Enroll(context.Background(), mac, "x86_64"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &v1alpha1.Hardware{}
+	if err := cl.Get(context.Background(), client.ObjectKey{Namespace: "tink-system", Name: "discovered-3cecef4c4f54"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{EnrollmentLabel: EnrollmentUnenrolled}, got.Labels); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := time.Parse(time.RFC3339, got.Annotations[DiscoveredAtAnnotation]); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&v1alpha1.DHCP{MAC: mac.String(), Arch: "x86_64"}, got.Spec.Interfaces[0].DHCP); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -51,3 +51,27 @@ func GetIPs(h *v1alpha1.Hardware) []string {
 	}
 	return ips
 }
+
+// HostnameIndex is an index used with a controller-runtime client to lookup hardware by hostname.
+const HostnameIndex = ".Spec.Interfaces.DHCP.Hostname"
+
+// Hostnames returns a list of hostnames for a Hardware object.
+func Hostnames(obj client.Object) []string {
+	hw, ok := obj.(*v1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+	var hostnames []string
+	for _, i := range hw.Spec.Interfaces {
+		if i.DHCP != nil && i.DHCP.Hostname != "" {
+			hostnames = append(hostnames, i.DHCP.Hostname)
+		}
+	}
+	return hostnames
+}
+
+// SystemUUIDLabel is the label of a Hardware object with the SMBIOS system UUID of the machine, used to lookup hardware by system UUID.
+const SystemUUIDLabel = "smee.tinkerbell.org/system-uuid"
+
+// SerialLabel is the label of a Hardware object with the serial number of the machine, used to lookup hardware by serial number.
+const SerialLabel = "smee.tinkerbell.org/serial"
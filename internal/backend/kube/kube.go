@@ -2,18 +2,23 @@
 package kube
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"github.com/ccoveille/go-safecast"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 	"github.com/tinkerbell/tink/api/v1alpha1"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/cluster"
@@ -21,15 +26,34 @@ import (
 
 const tracerName = "github.com/tinkerbell/smee/dhcp"
 
+// ConsoleAnnotation is the annotation of a Hardware object with the kernel consoles of the machine, space separated,
+// for example "tty0 ttyS1,115200n8". It is an annotation as console settings are not valid label values.
+const ConsoleAnnotation = "smee.tinkerbell.org/console"
+
+// ProfileAnnotation is the annotation of a Hardware object with the name of the boot profile of the machine, for
+// example "burnin".
+const ProfileAnnotation = "smee.tinkerbell.org/boot-profile"
+
 // Backend is a backend implementation that uses the Tinkerbell CRDs to get DHCP data.
 type Backend struct {
 	cluster cluster.Cluster
+	// Namespace is the namespace in which Hardware objects for discovered machines are created.
+	Namespace string
+	// WatchNamespace is the namespace of the client-side cache, all namespaces when empty. Validate lists the Hardware
+	// objects in it.
+	WatchNamespace string
+	// ValidateInterval, when greater than 0, is how often Start validates the client-side cache, see Validate.
+	ValidateInterval time.Duration
+
+	// validated is the unix time in nanoseconds of the last sync or Validate of the client-side cache.
+	validated atomic.Int64
 }
 
 // NewBackend returns a controller-runtime cluster.Cluster with the Tinkerbell runtime
 // scheme registered, and indexers for:
 // * Hardware by MAC address
 // * Hardware by IP address
+// * Hardware by hostname
 //
 // Callers must instantiate the client-side cache by calling Start() before use.
 func NewBackend(conf *rest.Config, opts ...cluster.Option) (*Backend, error) {
@@ -46,14 +70,70 @@ func NewBackend(conf *rest.Config, opts ...cluster.Option) (*Backend, error) {
 		return nil, fmt.Errorf("failed to setup indexer(.spec.interfaces.dhcp.ip.address): %w", err)
 	}
 
+	if err := c.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.Hardware{}, HostnameIndex, Hostnames); err != nil {
+		return nil, fmt.Errorf("failed to setup indexer(.spec.interfaces.dhcp.hostname): %w", err)
+	}
+
 	return &Backend{cluster: c}, nil
 }
 
-// Start starts the client-side cache.
+// Start starts the client-side cache, and validates it every ValidateInterval.
 func (b *Backend) Start(ctx context.Context) error {
+	if b.ValidateInterval > 0 {
+		go func() {
+			t := time.NewTicker(b.ValidateInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					_ = b.Validate(ctx)
+				}
+			}
+		}()
+	}
+
 	return b.cluster.Start(ctx)
 }
 
+// WaitForCacheSync waits for the client-side cache to sync. It returns false if ctx is done first.
+func (b *Backend) WaitForCacheSync(ctx context.Context) bool {
+	if !b.cluster.GetCache().WaitForCacheSync(ctx) {
+		return false
+	}
+	b.validated.Store(time.Now().UnixNano())
+
+	return true
+}
+
+// Validate confirms that the Hardware objects can still be read from the Kubernetes API, with a list that bypasses
+// the client-side cache. The cache is kept in sync by its watches while the API is reachable, the records are
+// returned with the time of the last sync or Validate that succeeded as their Validated time.
+func (b *Backend) Validate(ctx context.Context) error {
+	if err := b.cluster.GetAPIReader().List(ctx, &v1alpha1.HardwareList{}, client.InNamespace(b.WatchNamespace), client.Limit(1)); err != nil {
+		return fmt.Errorf("failed validating the hardware cache: %w", err)
+	}
+	b.validated.Store(time.Now().UnixNano())
+
+	return nil
+}
+
+// Validated returns when the client-side cache was last synced or validated, the zero time when it never was.
+func (b *Backend) Validated() time.Time {
+	v := b.validated.Load()
+	if v == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, v)
+}
+
+// Client returns the controller-runtime client backed by the client-side cache.
+func (b *Backend) Client() client.Client {
+	return b.cluster.GetClient()
+}
+
 // GetByMac implements the handler.BackendReader interface and returns DHCP and netboot data based on a mac address.
 func (b *Backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
 	tracer := otel.Tracer(tracerName)
@@ -95,9 +175,16 @@ func (b *Backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHC
 
 		return nil, nil, err
 	}
-
-	span.SetAttributes(d.EncodeToAttributes()...)
-	span.SetAttributes(n.EncodeToAttributes()...)
+	n.Labels = hardwareList.Items[0].Labels
+	n.Console = hardwareList.Items[0].Annotations[ConsoleAnnotation]
+	n.Profile = hardwareList.Items[0].Annotations[ProfileAnnotation]
+	n.Instance = toInstance(hardwareList.Items[0].Spec)
+	n.Validated = b.Validated()
+
+	if span.IsRecording() {
+		span.SetAttributes(d.EncodeToAttributes()...)
+		span.SetAttributes(n.EncodeToAttributes()...)
+	}
 	span.SetStatus(codes.Ok, "")
 
 	return d, n, nil
@@ -144,9 +231,16 @@ func (b *Backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Net
 
 		return nil, nil, err
 	}
-
-	span.SetAttributes(d.EncodeToAttributes()...)
-	span.SetAttributes(n.EncodeToAttributes()...)
+	n.Labels = hardwareList.Items[0].Labels
+	n.Console = hardwareList.Items[0].Annotations[ConsoleAnnotation]
+	n.Profile = hardwareList.Items[0].Annotations[ProfileAnnotation]
+	n.Instance = toInstance(hardwareList.Items[0].Spec)
+	n.Validated = b.Validated()
+
+	if span.IsRecording() {
+		span.SetAttributes(d.EncodeToAttributes()...)
+		span.SetAttributes(n.EncodeToAttributes()...)
+	}
 	span.SetStatus(codes.Ok, "")
 
 	return d, n, nil
@@ -273,6 +367,163 @@ func toNetbootData(i *v1alpha1.Netboot, facility string) (*data.Netboot, error)
 	return n, nil
 }
 
+// GetByUUID implements the handler.BackendIdentityReader interface and returns DHCP and netboot data based on
+// the SystemUUIDLabel of a Hardware object. The data is of the first interface of the Hardware object with a MAC address.
+func (b *Backend) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.GetByUUID")
+	defer span.End()
+
+	return b.getBy(ctx, span, client.MatchingLabels{SystemUUIDLabel: uuid}, uuid, func(v1alpha1.Interface) bool { return true })
+}
+
+// GetBySerial implements the handler.BackendIdentityReader interface and returns DHCP and netboot data based on
+// the SerialLabel of a Hardware object. The data is of the first interface of the Hardware object with a MAC address.
+func (b *Backend) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.GetBySerial")
+	defer span.End()
+
+	return b.getBy(ctx, span, client.MatchingLabels{SerialLabel: serial}, serial, func(v1alpha1.Interface) bool { return true })
+}
+
+// GetByHostname implements the handler.BackendIdentityReader interface and returns DHCP and netboot data based on
+// the DHCP hostname of a Hardware interface.
+func (b *Backend) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.GetByHostname")
+	defer span.End()
+
+	return b.getBy(ctx, span, &client.MatchingFields{HostnameIndex: hostname}, hostname, func(i v1alpha1.Interface) bool {
+		return i.DHCP.Hostname == hostname
+	})
+}
+
+// getBy returns the DHCP and netboot data of the Hardware object listed with opt.
+// The data is of the first interface of the Hardware object with a MAC address for which match returns true.
+func (b *Backend) getBy(ctx context.Context, span trace.Span, opt client.ListOption, value string, match func(v1alpha1.Interface) bool) (*data.DHCP, *data.Netboot, error) {
+	if value == "" {
+		err := hardwareNotFoundError{}
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	hardwareList := &v1alpha1.HardwareList{}
+	if err := b.cluster.GetClient().List(ctx, hardwareList, opt); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed listing hardware for (%v): %w", value, err)
+	}
+
+	if len(hardwareList.Items) == 0 {
+		err := hardwareNotFoundError{}
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+
+	if len(hardwareList.Items) > 1 {
+		err := fmt.Errorf("got %d hardware objects for %v, expected only 1", len(hardwareList.Items), value)
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	hw := hardwareList.Items[0]
+
+	i := v1alpha1.Interface{}
+	for _, iface := range hw.Spec.Interfaces {
+		if iface.DHCP != nil && iface.DHCP.MAC != "" && match(iface) {
+			i = iface
+			break
+		}
+	}
+
+	d, n, err := transform(i, hw.Spec.Metadata)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, err
+	}
+	n.Labels = hw.Labels
+	n.Console = hw.Annotations[ConsoleAnnotation]
+	n.Profile = hw.Annotations[ProfileAnnotation]
+	n.Instance = toInstance(hw.Spec)
+	n.Validated = b.Validated()
+
+	if span.IsRecording() {
+		span.SetAttributes(d.EncodeToAttributes()...)
+		span.SetAttributes(n.EncodeToAttributes()...)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// List implements the handler.BackendLister interface and returns the DHCP and netboot data of every Hardware interface.
+func (b *Backend) List(ctx context.Context) ([]data.Record, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.List")
+	defer span.End()
+	hardwareList := &v1alpha1.HardwareList{}
+
+	if err := b.cluster.GetClient().List(ctx, hardwareList); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, fmt.Errorf("failed listing hardware: %w", err)
+	}
+
+	var records []data.Record
+	for _, hw := range hardwareList.Items {
+		for _, iface := range hw.Spec.Interfaces {
+			if iface.DHCP == nil || iface.DHCP.MAC == "" {
+				continue
+			}
+			d, n, err := transform(iface, hw.Spec.Metadata)
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+
+				return nil, fmt.Errorf("hardware %s/%s: %w", hw.Namespace, hw.Name, err)
+			}
+			n.Labels = hw.Labels
+			n.Console = hw.Annotations[ConsoleAnnotation]
+			n.Profile = hw.Annotations[ProfileAnnotation]
+			n.Instance = toInstance(hw.Spec)
+			n.Validated = b.Validated()
+			records = append(records, data.Record{DHCP: d, Netboot: n})
+		}
+	}
+	sortRecords(records)
+	span.SetStatus(codes.Ok, "")
+
+	return records, nil
+}
+
+func sortRecords(r []data.Record) {
+	slices.SortFunc(r, func(a, b data.Record) int {
+		return bytes.Compare(a.DHCP.MACAddress, b.DHCP.MACAddress)
+	})
+}
+
+// toInstance returns the instance data of a Hardware spec, nil when it has none.
+// The spec.userData field takes precedence over the user data of the instance metadata.
+func toInstance(s v1alpha1.HardwareSpec) *data.Instance {
+	i := &data.Instance{}
+	if s.Metadata != nil && s.Metadata.Instance != nil {
+		i.ID = s.Metadata.Instance.ID
+		i.Hostname = s.Metadata.Instance.Hostname
+		i.UserData = s.Metadata.Instance.Userdata
+		i.PublicKeys = s.Metadata.Instance.SSHKeys
+	}
+	if s.UserData != nil {
+		i.UserData = *s.UserData
+	}
+	if i.ID == "" && i.Hostname == "" && i.UserData == "" && len(i.PublicKeys) == 0 {
+		return nil
+	}
+
+	return i
+}
+
 // transform returns data.DHCP and data.Netboot from part a v1alpha1.Interface and *v1alpha1.HardwareMetadata.
 func transform(i v1alpha1.Interface, m *v1alpha1.HardwareMetadata) (*data.DHCP, *data.Netboot, error) {
 	d, err := toDHCPData(i.DHCP)
@@ -10,7 +10,9 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/smee/internal/backend/conformance"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/plugin/backendtest"
 	"github.com/tinkerbell/tink/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -169,6 +171,31 @@ func TestToNetbootData(t *testing.T) {
 	}
 }
 
+func TestToInstance(t *testing.T) {
+	userData := "#cloud-config"
+	tests := map[string]struct {
+		in   v1alpha1.HardwareSpec
+		want *data.Instance
+	}{
+		"no metadata": {in: v1alpha1.HardwareSpec{}, want: nil},
+		"instance metadata": {
+			in:   v1alpha1.HardwareSpec{Metadata: &v1alpha1.HardwareMetadata{Instance: &v1alpha1.MetadataInstance{ID: "i-1", Hostname: "node1", Userdata: "old", SSHKeys: []string{"ssh-ed25519 AAAA"}}}},
+			want: &data.Instance{ID: "i-1", Hostname: "node1", UserData: "old", PublicKeys: []string{"ssh-ed25519 AAAA"}},
+		},
+		"spec user data takes precedence": {
+			in:   v1alpha1.HardwareSpec{UserData: &userData, Metadata: &v1alpha1.HardwareMetadata{Instance: &v1alpha1.MetadataInstance{Userdata: "old"}}},
+			want: &data.Instance{UserData: userData},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, toInstance(tt.in)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestGetByIP(t *testing.T) {
 	tests := map[string]struct {
 		hwObject    []v1alpha1.Hardware
@@ -268,6 +295,8 @@ func TestGetByIP(t *testing.T) {
 }
 
 func TestGetByMac(t *testing.T) {
+	hwConsole := hwObject1.DeepCopy()
+	hwConsole.Annotations = map[string]string{ConsoleAnnotation: "tty0 ttyS1,115200n8", ProfileAnnotation: "burnin"}
 	tests := map[string]struct {
 		hwObject    []v1alpha1.Hardware
 		wantDHCP    *data.DHCP
@@ -299,6 +328,27 @@ func TestGetByMac(t *testing.T) {
 			},
 			Facility: "onprem",
 		}},
+		"annotations": {hwObject: []v1alpha1.Hardware{*hwConsole}, wantDHCP: &data.DHCP{
+			MACAddress:     net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54},
+			IPAddress:      netip.MustParseAddr("172.16.10.100"),
+			SubnetMask:     []byte{0xff, 0xff, 0xff, 0x00},
+			DefaultGateway: netip.MustParseAddr("255.255.255.0"),
+			NameServers: []net.IP{
+				{0x1, 0x1, 0x1, 0x1},
+			},
+			Hostname:  "sm01",
+			LeaseTime: 86400,
+			Arch:      "x86_64",
+		}, wantNetboot: &data.Netboot{
+			AllowNetboot: true,
+			IPXEScriptURL: &url.URL{
+				Scheme: "http",
+				Host:   "netboot.xyz",
+			},
+			Console:  "tty0 ttyS1,115200n8",
+			Facility: "onprem",
+			Profile:  "burnin",
+		}},
 	}
 
 	for name, tc := range tests {
@@ -365,6 +415,119 @@ func TestGetByMac(t *testing.T) {
 	}
 }
 
+func TestList(t *testing.T) {
+	rs := runtime.NewScheme()
+	if err := scheme.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	cl := fake.NewClientBuilder().WithScheme(rs).WithLists(&v1alpha1.HardwareList{Items: []v1alpha1.Hardware{hwObject2, hwObject1}}).Build()
+	fn := func(o *cluster.Options) {
+		o.NewClient = func(*rest.Config, client.Options) (client.Client, error) {
+			return cl, nil
+		}
+		o.MapperProvider = func(*rest.Config, *http.Client) (meta.RESTMapper, error) {
+			return cl.RESTMapper(), nil
+		}
+		o.NewCache = func(*rest.Config, cache.Options) (cache.Cache, error) {
+			return &informertest.FakeInformers{Scheme: cl.Scheme()}, nil
+		}
+	}
+	b, err := NewBackend(new(rest.Config), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var macs []string
+	for _, r := range got {
+		macs = append(macs, r.DHCP.MACAddress.String())
+	}
+	if diff := cmp.Diff([]string{"3c:ec:ef:4c:4f:54", "3c:ec:ef:4c:4f:55"}, macs); diff != "" {
+		t.Fatal(diff)
+	}
+	if got[0].Netboot.Facility != "onprem" {
+		t.Fatalf("facility = %q, want onprem", got[0].Netboot.Facility)
+	}
+	if !got[0].Netboot.Validated.IsZero() {
+		t.Fatalf("expected the zero validated time before the cache is synced, got %v", got[0].Netboot.Validated)
+	}
+	if !b.WaitForCacheSync(context.Background()) {
+		t.Fatal("expected the cache to sync")
+	}
+	got, err = b.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got[0].Netboot.Validated; v.IsZero() || !v.Equal(b.Validated()) {
+		t.Fatalf("expected the records to be validated when the cache synced, got %v", v)
+	}
+}
+
+func TestGetByIdentity(t *testing.T) {
+	rs := runtime.NewScheme()
+	if err := scheme.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	hw1 := hwObject1.DeepCopy()
+	hw1.Labels = map[string]string{SystemUUIDLabel: "4c4c4544-0051-3410-8058-b4c04f4a5032"}
+	hw2 := hwObject2.DeepCopy()
+	hw2.Labels = map[string]string{SerialLabel: "CZ2D3S0ABC"}
+	hw2.Spec.Interfaces[0].DHCP.Hostname = "sm02"
+	cl := fake.NewClientBuilder().WithScheme(rs).WithIndex(&v1alpha1.Hardware{}, HostnameIndex, Hostnames).WithLists(&v1alpha1.HardwareList{Items: []v1alpha1.Hardware{*hw1, *hw2}}).Build()
+	fn := func(o *cluster.Options) {
+		o.NewClient = func(*rest.Config, client.Options) (client.Client, error) {
+			return cl, nil
+		}
+		o.MapperProvider = func(*rest.Config, *http.Client) (meta.RESTMapper, error) {
+			return cl.RESTMapper(), nil
+		}
+		o.NewCache = func(*rest.Config, cache.Options) (cache.Cache, error) {
+			return &informertest.FakeInformers{Scheme: cl.Scheme()}, nil
+		}
+	}
+	b, err := NewBackend(new(rest.Config), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		get     func(context.Context, string) (*data.DHCP, *data.Netboot, error)
+		value   string
+		wantMAC string
+		wantErr bool
+	}{
+		"by uuid":            {get: b.GetByUUID, value: "4c4c4544-0051-3410-8058-b4c04f4a5032", wantMAC: "3c:ec:ef:4c:4f:54"},
+		"by serial":          {get: b.GetBySerial, value: "CZ2D3S0ABC", wantMAC: "3c:ec:ef:4c:4f:55"},
+		"by hostname":        {get: b.GetByHostname, value: "sm02", wantMAC: "3c:ec:ef:4c:4f:55"},
+		"uuid not found":     {get: b.GetByUUID, value: "00000000-0000-0000-0000-000000000001", wantErr: true},
+		"hostname not found": {get: b.GetByHostname, value: "sm03", wantErr: true},
+		"empty":              {get: b.GetBySerial, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, _, err := tt.get(context.Background(), tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, want err %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.wantMAC, d.MACAddress.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 var hwObject1 = v1alpha1.Hardware{
 	TypeMeta: v1.TypeMeta{
 		Kind:       "Hardware",
@@ -588,3 +751,57 @@ var badNetbootObject2 = v1alpha1.Hardware{
 		},
 	},
 }
+
+func TestConformance(t *testing.T) {
+	rs := runtime.NewScheme()
+	if err := scheme.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	var hw []v1alpha1.Hardware
+	for _, m := range backendtest.Machines() {
+		hw = append(hw, v1alpha1.Hardware{
+			ObjectMeta: v1.ObjectMeta{Name: m.DHCP.Hostname, Namespace: "default"},
+			Spec: v1alpha1.HardwareSpec{
+				Metadata: &v1alpha1.HardwareMetadata{Facility: &v1alpha1.MetadataFacility{FacilityCode: m.Netboot.Facility}},
+				Interfaces: []v1alpha1.Interface{{
+					Netboot: &v1alpha1.Netboot{AllowPXE: &m.Netboot.AllowNetboot},
+					DHCP: &v1alpha1.DHCP{
+						Arch:     m.DHCP.Arch,
+						Hostname: m.DHCP.Hostname,
+						IP: &v1alpha1.IP{
+							Address: m.DHCP.IPAddress.String(),
+							Gateway: m.DHCP.DefaultGateway.String(),
+							Netmask: net.IP(m.DHCP.SubnetMask).String(),
+						},
+						LeaseTime:   int64(m.DHCP.LeaseTime),
+						MAC:         m.DHCP.MACAddress.String(),
+						NameServers: []string{m.DHCP.NameServers[0].String()},
+					},
+				}},
+			},
+		})
+	}
+	cl := fake.NewClientBuilder().WithScheme(rs).
+		WithIndex(&v1alpha1.Hardware{}, MACAddrIndex, MACAddrs).
+		WithIndex(&v1alpha1.Hardware{}, IPAddrIndex, IPAddrs).
+		WithLists(&v1alpha1.HardwareList{Items: hw}).Build()
+	fn := func(o *cluster.Options) {
+		o.NewClient = func(*rest.Config, client.Options) (client.Client, error) {
+			return cl, nil
+		}
+		o.MapperProvider = func(*rest.Config, *http.Client) (meta.RESTMapper, error) {
+			return cl.RESTMapper(), nil
+		}
+		o.NewCache = func(*rest.Config, cache.Options) (cache.Cache, error) {
+			return &informertest.FakeInformers{Scheme: cl.Scheme()}, nil
+		}
+	}
+	b, err := NewBackend(new(rest.Config), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformance.Run(t, b, backendtest.Options{})
+}
@@ -0,0 +1,310 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrNotReady is returned by a Lazy backend that has not been created yet.
+var ErrNotReady = errors.New("kubernetes backend is not ready")
+
+// Reader is the backend that a Lazy backend creates, a *Backend or a *Multi.
+type Reader interface {
+	GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error)
+	GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error)
+	GetByUUID(context.Context, string) (*data.DHCP, *data.Netboot, error)
+	GetBySerial(context.Context, string) (*data.DHCP, *data.Netboot, error)
+	GetByHostname(context.Context, string) (*data.DHCP, *data.Netboot, error)
+	List(context.Context) ([]data.Record, error)
+	Enroll(ctx context.Context, mac net.HardwareAddr, arch string) error
+	Client() client.Client
+}
+
+// Lazy is a backend that is created in the background, retrying with exponential backoff until it succeeds.
+// This allows Smee to start, and DHCP and TFTP to serve, while the Kubernetes API is unavailable.
+// Until the backend is created all lookups return ErrNotReady.
+type Lazy struct {
+	// New creates and starts the backend. It should return once the backend cache is synced.
+	New func(context.Context) (Reader, error)
+	Log logr.Logger
+	// InitialInterval is the delay before the first retry, it doubles on every failed attempt up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+
+	mu sync.RWMutex
+	r  Reader
+}
+
+// Start creates the backend, retrying until it succeeds or ctx is done.
+func (l *Lazy) Start(ctx context.Context) error {
+	b := wait.Backoff{
+		Duration: l.InitialInterval,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      l.MaxInterval,
+	}
+	if b.Duration <= 0 {
+		b.Duration = time.Second
+	}
+	if b.Cap <= 0 {
+		b.Cap = time.Minute
+	}
+	for attempt := 1; ; attempt++ {
+		r, err := l.New(ctx)
+		if err == nil {
+			l.mu.Lock()
+			l.r = r
+			l.mu.Unlock()
+			l.log().Info("kubernetes backend is ready", "attempts", attempt)
+
+			return nil
+		}
+		d := b.Step()
+		l.log().Error(err, "failed to create kubernetes backend, retrying", "attempt", attempt, "retryIn", d.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// Ready returns whether the backend has been created.
+func (l *Lazy) Ready() bool {
+	return l.reader() != nil
+}
+
+func (l *Lazy) reader() Reader {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.r
+}
+
+// GetByMac implements the handler.BackendReader interface.
+func (l *Lazy) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	r := l.reader()
+	if r == nil {
+		return nil, nil, ErrNotReady
+	}
+
+	return r.GetByMac(ctx, mac)
+}
+
+// GetByIP implements the handler.BackendReader interface.
+func (l *Lazy) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	r := l.reader()
+	if r == nil {
+		return nil, nil, ErrNotReady
+	}
+
+	return r.GetByIP(ctx, ip)
+}
+
+// GetByUUID implements the handler.BackendIdentityReader interface.
+func (l *Lazy) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	r := l.reader()
+	if r == nil {
+		return nil, nil, ErrNotReady
+	}
+
+	return r.GetByUUID(ctx, uuid)
+}
+
+// GetBySerial implements the handler.BackendIdentityReader interface.
+func (l *Lazy) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	r := l.reader()
+	if r == nil {
+		return nil, nil, ErrNotReady
+	}
+
+	return r.GetBySerial(ctx, serial)
+}
+
+// GetByHostname implements the handler.BackendIdentityReader interface.
+func (l *Lazy) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	r := l.reader()
+	if r == nil {
+		return nil, nil, ErrNotReady
+	}
+
+	return r.GetByHostname(ctx, hostname)
+}
+
+// List implements the handler.BackendLister interface.
+func (l *Lazy) List(ctx context.Context) ([]data.Record, error) {
+	r := l.reader()
+	if r == nil {
+		return nil, ErrNotReady
+	}
+
+	return r.List(ctx)
+}
+
+// Enroll creates a Hardware object for a discovered machine, see Backend.Enroll.
+func (l *Lazy) Enroll(ctx context.Context, mac net.HardwareAddr, arch string) error {
+	r := l.reader()
+	if r == nil {
+		return ErrNotReady
+	}
+
+	return r.Enroll(ctx, mac, arch)
+}
+
+// Client returns a client that uses the backend client once it is created.
+// Until then all its calls return ErrNotReady.
+func (l *Lazy) Client() client.Client {
+	return lazyClient{l: l}
+}
+
+// lazyClient is a client.Client that delegates to the client of a Lazy backend.
+type lazyClient struct {
+	l *Lazy
+}
+
+func (c lazyClient) current() client.Client {
+	if r := c.l.reader(); r != nil {
+		return r.Client()
+	}
+
+	return notReadyClient{}
+}
+
+func (c lazyClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.current().Get(ctx, key, obj, opts...)
+}
+
+func (c lazyClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.current().List(ctx, list, opts...)
+}
+
+func (c lazyClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.current().Create(ctx, obj, opts...)
+}
+
+func (c lazyClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.current().Delete(ctx, obj, opts...)
+}
+
+func (c lazyClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.current().Update(ctx, obj, opts...)
+}
+
+func (c lazyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.current().Patch(ctx, obj, patch, opts...)
+}
+
+func (c lazyClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	return c.current().DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c lazyClient) Status() client.SubResourceWriter {
+	return c.current().Status()
+}
+
+func (c lazyClient) SubResource(subResource string) client.SubResourceClient {
+	return c.current().SubResource(subResource)
+}
+
+func (c lazyClient) Scheme() *runtime.Scheme {
+	return c.current().Scheme()
+}
+
+func (c lazyClient) RESTMapper() meta.RESTMapper {
+	return c.current().RESTMapper()
+}
+
+func (c lazyClient) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKind, error) {
+	return c.current().GroupVersionKindFor(obj)
+}
+
+func (c lazyClient) IsObjectNamespaced(obj runtime.Object) (bool, error) {
+	return c.current().IsObjectNamespaced(obj)
+}
+
+// notReadyClient is a client.Client whose calls all return ErrNotReady.
+type notReadyClient struct{}
+
+func (notReadyClient) Get(context.Context, client.ObjectKey, client.Object, ...client.GetOption) error {
+	return ErrNotReady
+}
+
+func (notReadyClient) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return ErrNotReady
+}
+
+func (notReadyClient) Create(context.Context, client.Object, ...client.CreateOption) error {
+	return ErrNotReady
+}
+
+func (notReadyClient) Delete(context.Context, client.Object, ...client.DeleteOption) error {
+	return ErrNotReady
+}
+
+func (notReadyClient) Update(context.Context, client.Object, ...client.UpdateOption) error {
+	return ErrNotReady
+}
+
+func (notReadyClient) Patch(context.Context, client.Object, client.Patch, ...client.PatchOption) error {
+	return ErrNotReady
+}
+
+func (notReadyClient) DeleteAllOf(context.Context, client.Object, ...client.DeleteAllOfOption) error {
+	return ErrNotReady
+}
+
+func (notReadyClient) Status() client.SubResourceWriter { return notReadySubResource{} }
+
+func (notReadyClient) SubResource(string) client.SubResourceClient { return notReadySubResource{} }
+
+func (notReadyClient) Scheme() *runtime.Scheme { return runtime.NewScheme() }
+
+func (notReadyClient) RESTMapper() meta.RESTMapper { return meta.NewDefaultRESTMapper(nil) }
+
+func (notReadyClient) GroupVersionKindFor(runtime.Object) (schema.GroupVersionKind, error) {
+	return schema.GroupVersionKind{}, ErrNotReady
+}
+
+func (notReadyClient) IsObjectNamespaced(runtime.Object) (bool, error) {
+	return false, ErrNotReady
+}
+
+// notReadySubResource is a client.SubResourceClient whose calls all return ErrNotReady.
+type notReadySubResource struct{}
+
+func (notReadySubResource) Get(context.Context, client.Object, client.Object, ...client.SubResourceGetOption) error {
+	return ErrNotReady
+}
+
+func (notReadySubResource) Create(context.Context, client.Object, client.Object, ...client.SubResourceCreateOption) error {
+	return ErrNotReady
+}
+
+func (notReadySubResource) Update(context.Context, client.Object, ...client.SubResourceUpdateOption) error {
+	return ErrNotReady
+}
+
+func (notReadySubResource) Patch(context.Context, client.Object, client.Patch, ...client.SubResourcePatchOption) error {
+	return ErrNotReady
+}
+
+func (l *Lazy) log() logr.Logger {
+	if l.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return l.Log
+}
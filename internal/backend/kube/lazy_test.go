@@ -0,0 +1,71 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/tink/api/v1alpha1"
+)
+
+func TestLazy(t *testing.T) {
+	mac := net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+	var attempts int
+	fb := newFakeBackend(t, false, hwObject1)
+	l := &Lazy{
+		New: func(context.Context) (Reader, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("kubernetes API unavailable")
+			}
+			return fb, nil
+		},
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+	}
+
+	if l.Ready() {
+		t.Fatal("expected the backend to not be ready before it is started")
+	}
+	if _, _, err := l.GetByMac(context.Background(), mac); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("got err %v, want %v", err, ErrNotReady)
+	}
+	if err := l.Client().List(context.Background(), &v1alpha1.HardwareList{}); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("got client err %v, want %v", err, ErrNotReady)
+	}
+
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Fatalf("got %d attempts, want 3", attempts)
+	}
+	if !l.Ready() {
+		t.Fatal("expected the backend to be ready")
+	}
+	if _, _, err := l.GetByMac(context.Background(), mac); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Client().List(context.Background(), &v1alpha1.HardwareList{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLazyStartCanceled(t *testing.T) {
+	l := &Lazy{
+		New: func(context.Context) (Reader, error) {
+			return nil, errors.New("kubernetes API unavailable")
+		},
+		InitialInterval: time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got err %v, want %v", err, context.Canceled)
+	}
+	if l.Ready() {
+		t.Fatal("expected the backend to not be ready")
+	}
+}
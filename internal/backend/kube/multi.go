@@ -0,0 +1,198 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/sync/errgroup"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Multi is a backend implementation that merges the Hardware objects of multiple clusters.
+// A machine must be found in exactly one cluster. The first Backend is the primary cluster,
+// it is used to create Hardware objects for discovered machines and by Client.
+type Multi struct {
+	Backends []*Backend
+}
+
+// Start starts the client-side cache of all clusters.
+// It returns when any of the caches fails to start or ctx is done.
+func (m *Multi) Start(ctx context.Context) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, b := range m.Backends {
+		g.Go(func() error {
+			return b.Start(ctx)
+		})
+	}
+
+	return g.Wait()
+}
+
+// WaitForCacheSync waits for the client-side cache of all clusters to sync. It returns false if ctx is done first.
+func (m *Multi) WaitForCacheSync(ctx context.Context) bool {
+	for _, b := range m.Backends {
+		if !b.WaitForCacheSync(ctx) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Client returns the client of the primary cluster.
+func (m *Multi) Client() client.Client {
+	return m.Backends[0].Client()
+}
+
+// Enroll creates a Hardware object for a discovered machine in the primary cluster.
+func (m *Multi) Enroll(ctx context.Context, mac net.HardwareAddr, arch string) error {
+	return m.Backends[0].Enroll(ctx, mac, arch)
+}
+
+// GetByMac implements the handler.BackendReader interface and returns DHCP and netboot data based on a mac address.
+func (m *Multi) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.Multi.GetByMac")
+	defer span.End()
+
+	d, n, err := m.get(func(b *Backend) (*data.DHCP, *data.Netboot, error) { return b.GetByMac(ctx, mac) })
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed getting hardware for (%v): %w", mac, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// GetByIP implements the handler.BackendReader interface and returns DHCP and netboot data based on an IP address.
+func (m *Multi) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.Multi.GetByIP")
+	defer span.End()
+
+	d, n, err := m.get(func(b *Backend) (*data.DHCP, *data.Netboot, error) { return b.GetByIP(ctx, ip) })
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed getting hardware for (%v): %w", ip, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// GetByUUID implements the handler.BackendIdentityReader interface and returns DHCP and netboot data based on a system UUID.
+func (m *Multi) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.Multi.GetByUUID")
+	defer span.End()
+
+	d, n, err := m.get(func(b *Backend) (*data.DHCP, *data.Netboot, error) { return b.GetByUUID(ctx, uuid) })
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed getting hardware for (uuid=%v): %w", uuid, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// GetBySerial implements the handler.BackendIdentityReader interface and returns DHCP and netboot data based on a serial number.
+func (m *Multi) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.Multi.GetBySerial")
+	defer span.End()
+
+	d, n, err := m.get(func(b *Backend) (*data.DHCP, *data.Netboot, error) { return b.GetBySerial(ctx, serial) })
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed getting hardware for (serial=%v): %w", serial, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// GetByHostname implements the handler.BackendIdentityReader interface and returns DHCP and netboot data based on a hostname.
+func (m *Multi) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.Multi.GetByHostname")
+	defer span.End()
+
+	d, n, err := m.get(func(b *Backend) (*data.DHCP, *data.Netboot, error) { return b.GetByHostname(ctx, hostname) })
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+
+		return nil, nil, fmt.Errorf("failed getting hardware for (hostname=%v): %w", hostname, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return d, n, nil
+}
+
+// List implements the handler.BackendLister interface and returns the records of all clusters.
+func (m *Multi) List(ctx context.Context) ([]data.Record, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "backend.kube.Multi.List")
+	defer span.End()
+
+	var records []data.Record
+	for i, b := range m.Backends {
+		r, err := b.List(ctx)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+
+			return nil, fmt.Errorf("cluster %d: %w", i, err)
+		}
+		records = append(records, r...)
+	}
+	sortRecords(records)
+	span.SetStatus(codes.Ok, "")
+
+	return records, nil
+}
+
+// get calls fn for every cluster and returns the single result that was found.
+// Clusters that do not have the machine are ignored. Errors from other clusters are only
+// returned when the machine was not found in any cluster, so that one unreachable cluster
+// does not stop machines in the other clusters from booting.
+func (m *Multi) get(fn func(*Backend) (*data.DHCP, *data.Netboot, error)) (*data.DHCP, *data.Netboot, error) {
+	var (
+		found int
+		d     *data.DHCP
+		n     *data.Netboot
+		errs  []error
+	)
+	for i, b := range m.Backends {
+		bd, bn, err := fn(b)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("cluster %d: %w", i, err))
+			}
+			continue
+		}
+		found++
+		d, n = bd, bn
+	}
+
+	switch {
+	case found == 1:
+		return d, n, nil
+	case found > 1:
+		return nil, nil, fmt.Errorf("found in %d clusters, expected only 1", found)
+	case len(errs) > 0:
+		return nil, nil, errors.Join(errs...)
+	default:
+		return nil, nil, hardwareNotFoundError{}
+	}
+}
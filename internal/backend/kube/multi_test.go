@@ -0,0 +1,123 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+)
+
+func newFakeBackend(t *testing.T, failToList bool, hw ...v1alpha1.Hardware) *Backend {
+	t.Helper()
+	rs := runtime.NewScheme()
+	if err := scheme.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	ct := fake.NewClientBuilder().WithScheme(rs).WithIndex(&v1alpha1.Hardware{}, MACAddrIndex, MACAddrs).WithIndex(&v1alpha1.Hardware{}, IPAddrIndex, IPAddrs)
+	for i := range hw {
+		ct = ct.WithObjects(&hw[i])
+	}
+	if failToList {
+		ct = ct.WithInterceptorFuncs(interceptor.Funcs{
+			List: func(context.Context, client.WithWatch, client.ObjectList, ...client.ListOption) error {
+				return errors.New("cluster unreachable")
+			},
+		})
+	}
+	cl := ct.Build()
+	fn := func(o *cluster.Options) {
+		o.NewClient = func(*rest.Config, client.Options) (client.Client, error) {
+			return cl, nil
+		}
+		o.MapperProvider = func(*rest.Config, *http.Client) (meta.RESTMapper, error) {
+			return cl.RESTMapper(), nil
+		}
+		o.NewCache = func(*rest.Config, cache.Options) (cache.Cache, error) {
+			return &informertest.FakeInformers{Scheme: cl.Scheme()}, nil
+		}
+	}
+	b, err := NewBackend(new(rest.Config), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestMultiGetByMac(t *testing.T) {
+	mac := net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+	tests := map[string]struct {
+		backends     func(t *testing.T) []*Backend
+		wantErr      bool
+		wantNotFound bool
+	}{
+		"found in second cluster": {
+			backends: func(t *testing.T) []*Backend {
+				return []*Backend{newFakeBackend(t, false), newFakeBackend(t, false, hwObject1)}
+			},
+		},
+		"found with another cluster unreachable": {
+			backends: func(t *testing.T) []*Backend {
+				return []*Backend{newFakeBackend(t, true), newFakeBackend(t, false, hwObject1)}
+			},
+		},
+		"found in more than one cluster": {
+			backends: func(t *testing.T) []*Backend {
+				return []*Backend{newFakeBackend(t, false, hwObject1), newFakeBackend(t, false, hwObject1)}
+			},
+			wantErr: true,
+		},
+		"not found in any cluster": {
+			backends: func(t *testing.T) []*Backend {
+				return []*Backend{newFakeBackend(t, false), newFakeBackend(t, false)}
+			},
+			wantErr:      true,
+			wantNotFound: true,
+		},
+		"not found with a cluster unreachable": {
+			backends: func(t *testing.T) []*Backend {
+				return []*Backend{newFakeBackend(t, false), newFakeBackend(t, true)}
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := &Multi{Backends: tc.backends(t)}
+			d, _, err := m.GetByMac(context.Background(), mac)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if got := apierrors.IsNotFound(err); got != tc.wantNotFound {
+					t.Fatalf("IsNotFound: got %v, want %v", got, tc.wantNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.MACAddress.String() != mac.String() {
+				t.Fatalf("got mac %v, want %v", d.MACAddress, mac)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// namespacedVerbs are the verbs, on Hardware objects, the backend needs in namespace-scoped mode.
+var namespacedVerbs = []string{"get", "list", "watch"}
+
+// CheckNamespacedAccess verifies that the caller is allowed to get, list and watch Hardware objects in namespace.
+// It is used at startup in namespace-scoped mode so that insufficient RBAC results in a clear error
+// instead of a client-side cache that never syncs.
+func CheckNamespacedAccess(ctx context.Context, cs kubernetes.Interface, namespace string) error {
+	if namespace == "" {
+		return errors.New("a namespace is required in namespace-scoped mode")
+	}
+	var denied []string
+	for _, verb := range namespacedVerbs {
+		r := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Group:     "tinkerbell.org",
+					Resource:  "hardware",
+				},
+			},
+		}
+		resp, err := cs.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, r, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to review access to hardware in namespace %q: %w", namespace, err)
+		}
+		if !resp.Status.Allowed {
+			denied = append(denied, verb)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("insufficient RBAC: not allowed to %v hardware.tinkerbell.org in namespace %q, a Role granting get, list and watch is required", denied, namespace)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckNamespacedAccess(t *testing.T) {
+	tests := map[string]struct {
+		namespace string
+		allowed   map[string]bool
+		wantErr   bool
+	}{
+		"all allowed":  {namespace: "tink", allowed: map[string]bool{"get": true, "list": true, "watch": true}},
+		"watch denied": {namespace: "tink", allowed: map[string]bool{"get": true, "list": true}, wantErr: true},
+		"all denied":   {namespace: "tink", wantErr: true},
+		"no namespace": {allowed: map[string]bool{"get": true, "list": true, "watch": true}, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset()
+			cs.PrependReactor("create", "selfsubjectaccessreviews", func(a k8stesting.Action) (bool, runtime.Object, error) {
+				r := a.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				if r.Spec.ResourceAttributes.Namespace != tc.namespace || r.Spec.ResourceAttributes.Resource != "hardware" {
+					t.Errorf("unexpected resource attributes: %+v", r.Spec.ResourceAttributes)
+				}
+				r.Status.Allowed = tc.allowed[r.Spec.ResourceAttributes.Verb]
+				return true, r, nil
+			})
+			err := CheckNamespacedAccess(context.Background(), cs, tc.namespace)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -1,21 +1,132 @@
+// Package noop is a backend for the auto-proxy DHCP mode, for environments without an inventory of machines.
+// Without a Template it knows no machine, with a Template every machine gets its netboot data, so that every machine
+// boots the same rescue or installer image.
+//
+//	ipxeScript: |
+//	  #!ipxe
+//	  chain http://10.1.0.5:8080/rescue.ipxe
+//	console: ttyS1,115200n8
+//	facility: onprem
+//	osieURL: http://10.1.0.5:8080/hook
+//	labels:
+//	  role: rescue
 package noop
 
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
 	"net"
+	"net/url"
+	"os"
 
+	"github.com/ghodss/yaml"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 )
 
 var errAlways = errors.New("noop backend always returns an error")
 
-type Backend struct{}
+// Backend returns the netboot data of Template for every machine, or an error for every machine when Template is nil.
+type Backend struct {
+	Template *Template
+}
 
-func (n Backend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
-	return nil, nil, errAlways
+// Template is the netboot data of every machine. Empty settings are left to the defaults of Smee.
+type Template struct {
+	// IPXEScript is served to every machine in place of the auto.ipxe script.
+	IPXEScript string `json:"ipxeScript,omitempty"`
+	// IPXEScriptURL is sent in DHCP in place of the auto.ipxe script URL.
+	IPXEScriptURL string `json:"ipxeScriptURL,omitempty"`
+	// Console are the kernel consoles of the auto.ipxe script, space separated, for example "tty0 ttyS1,115200n8".
+	Console  string `json:"console,omitempty"`
+	Facility string `json:"facility,omitempty"`
+	// OSIEURL is the URL where the OSIE (HookOS) images of the auto.ipxe script are located.
+	OSIEURL string `json:"osieURL,omitempty"`
+	// Labels are the labels of every machine, for netboot policies.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	ipxeScriptURL *url.URL
+	osieURL       *url.URL
+}
+
+// LoadTemplate reads and validates a YAML, or JSON, template file.
+func LoadTemplate(file string) (*Template, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTemplate(b)
+}
+
+// ParseTemplate parses and validates a YAML, or JSON, template.
+func ParseTemplate(b []byte) (*Template, error) {
+	t := &Template{}
+	if err := yaml.Unmarshal(b, t); err != nil {
+		return nil, fmt.Errorf("invalid noop backend template: %w", err)
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Template) validate() error {
+	var err error
+	if t.IPXEScriptURL != "" {
+		if t.ipxeScriptURL, err = parseURL(t.IPXEScriptURL); err != nil {
+			return fmt.Errorf("invalid ipxeScriptURL: %w", err)
+		}
+	}
+	if t.OSIEURL != "" {
+		if t.osieURL, err = parseURL(t.OSIEURL); err != nil {
+			return fmt.Errorf("invalid osieURL: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute URL", s)
+	}
+
+	return u, nil
+}
+
+// GetByMac returns the netboot data of Template for the machine with the MAC address.
+func (n Backend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if n.Template == nil {
+		return nil, nil, errAlways
+	}
+	t := n.Template
+	nb := &data.Netboot{
+		AllowNetboot: true,
+		IPXEScript:   t.IPXEScript,
+		Console:      t.Console,
+		Facility:     t.Facility,
+		Labels:       maps.Clone(t.Labels),
+	}
+	if t.ipxeScriptURL != nil {
+		u := *t.ipxeScriptURL
+		nb.IPXEScriptURL = &u
+	}
+	if t.osieURL != nil {
+		u := *t.osieURL
+		nb.OSIE.BaseURL = &u
+	}
+
+	return &data.DHCP{MACAddress: mac}, nb, nil
 }
 
+// GetByIP always returns an error, the MAC address of a machine is needed to identify it.
 func (n Backend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
 	return nil, nil, errAlways
 }
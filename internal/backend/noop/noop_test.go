@@ -3,7 +3,12 @@ package noop
 import (
 	"context"
 	"errors"
+	"net"
+	"net/url"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
 )
 
 func TestBackend(t *testing.T) {
@@ -24,3 +29,68 @@ func TestBackend(t *testing.T) {
 		t.Error("expected errAlways")
 	}
 }
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate([]byte(`
+ipxeScriptURL: http://10.1.0.5:8080/rescue.ipxe
+console: ttyS1,115200n8
+facility: onprem
+osieURL: http://10.1.0.5:8080/hook
+labels:
+  role: rescue
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := Backend{Template: tmpl}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	d, n, err := b.GetByMac(context.Background(), mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.MACAddress.String() != mac.String() {
+		t.Fatalf("got MAC address %v, want %v", d.MACAddress, mac)
+	}
+	want := &data.Netboot{
+		AllowNetboot:  true,
+		IPXEScriptURL: &url.URL{Scheme: "http", Host: "10.1.0.5:8080", Path: "/rescue.ipxe"},
+		Console:       "ttyS1,115200n8",
+		Facility:      "onprem",
+		OSIE:          data.OSIE{BaseURL: &url.URL{Scheme: "http", Host: "10.1.0.5:8080", Path: "/hook"}},
+		Labels:        map[string]string{"role": "rescue"},
+	}
+	if diff := cmp.Diff(want, n); diff != "" {
+		t.Fatal(diff)
+	}
+	// the returned data is a copy of the template.
+	n.Labels["role"] = "changed"
+	n.OSIE.BaseURL.Path = "/changed"
+	if _, n, _ = b.GetByMac(context.Background(), mac); n.Labels["role"] != "rescue" || n.OSIE.BaseURL.Path != "/hook" {
+		t.Fatal("expected the template not to be modified")
+	}
+	if _, _, err := b.GetByIP(context.Background(), net.IPv4(192, 168, 2, 10)); !errors.Is(err, errAlways) {
+		t.Fatalf("expected errAlways, got %v", err)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tests := map[string]struct {
+		template string
+		wantErr  bool
+	}{
+		"script":             {template: "ipxeScript: |\n  #!ipxe\n  exit\n"},
+		"empty":              {template: ""},
+		"relative script":    {template: "ipxeScriptURL: /rescue.ipxe", wantErr: true},
+		"invalid osie url":   {template: "osieURL: 'http://[::1'", wantErr: true},
+		"invalid yaml":       {template: "labels: [", wantErr: true},
+		"invalid label type": {template: "labels: rescue", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseTemplate([]byte(tt.template))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+// Package replay records the responses of a backend to a file, and serves them back as a backend, so that the boot
+// issues of production can be reproduced offline, and integration tests run with the records of real machines.
+//
+// A recording is a JSON lines file, one lookup per line, that can be edited by hand:
+//
+//	{"method":"GetByMac","key":"00:01:02:03:04:05","dhcp":{"macAddress":"00:01:02:03:04:05","ipAddress":"192.168.2.10"},"netboot":{"allowNetboot":true}}
+//	{"method":"GetByIP","key":"192.168.2.11","notFound":true}
+//
+// A lookup is only recorded when its response differs from the last one recorded for the same method and key, and
+// the replay Backend serves the last one. The secrets of the iPXE scripts and user data are masked, see redact, and
+// the passwords of the URLs.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/redact"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Entry is a recorded lookup.
+type Entry struct {
+	Time time.Time `json:"time,omitempty"`
+	// Method is the lookup, GetByMac, GetByIP, GetByUUID, GetBySerial or GetByHostname.
+	Method string `json:"method"`
+	// Key is the MAC address, IP address, UUID, serial number or hostname that is looked up.
+	Key     string   `json:"key"`
+	DHCP    *DHCP    `json:"dhcp,omitempty"`
+	Netboot *Netboot `json:"netboot,omitempty"`
+	// NotFound is set when the backend has no record for the key.
+	NotFound bool `json:"notFound,omitempty"`
+	// Error is the error of a lookup that failed, other than NotFound.
+	Error string `json:"error,omitempty"`
+}
+
+// DHCP is the recorded data.DHCP, with its addresses as strings.
+type DHCP struct {
+	MACAddress       string   `json:"macAddress"`
+	IPAddress        string   `json:"ipAddress,omitempty"`
+	SubnetMask       string   `json:"subnetMask,omitempty"`
+	DefaultGateway   string   `json:"defaultGateway,omitempty"`
+	NameServers      []string `json:"nameServers,omitempty"`
+	Hostname         string   `json:"hostname,omitempty"`
+	DomainName       string   `json:"domainName,omitempty"`
+	BroadcastAddress string   `json:"broadcastAddress,omitempty"`
+	NTPServers       []string `json:"ntpServers,omitempty"`
+	VLANID           string   `json:"vlanID,omitempty"`
+	LeaseTime        uint32   `json:"leaseTime,omitempty"`
+	Arch             string   `json:"arch,omitempty"`
+	DomainSearch     []string `json:"domainSearch,omitempty"`
+	Disabled         bool     `json:"disabled,omitempty"`
+}
+
+// Netboot is the recorded data.Netboot, without the time it was validated.
+type Netboot struct {
+	AllowNetboot  bool              `json:"allowNetboot,omitempty"`
+	IPXEScriptURL string            `json:"ipxeScriptURL,omitempty"`
+	IPXEScript    string            `json:"ipxeScript,omitempty"`
+	Console       string            `json:"console,omitempty"`
+	Facility      string            `json:"facility,omitempty"`
+	Profile       string            `json:"profile,omitempty"`
+	OSIEBaseURL   string            `json:"osieBaseURL,omitempty"`
+	OSIEKernel    string            `json:"osieKernel,omitempty"`
+	OSIEInitrd    string            `json:"osieInitrd,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Instance      *Instance         `json:"instance,omitempty"`
+}
+
+// Instance is the recorded data.Instance.
+type Instance struct {
+	ID         string   `json:"id,omitempty"`
+	Hostname   string   `json:"hostname,omitempty"`
+	UserData   string   `json:"userData,omitempty"`
+	PublicKeys []string `json:"publicKeys,omitempty"`
+}
+
+// fromData returns the recorded form of d and n, with their secrets masked.
+func fromData(d *data.DHCP, n *data.Netboot) (*DHCP, *Netboot) {
+	var r redact.Redactor
+	var rd *DHCP
+	if d != nil {
+		rd = &DHCP{
+			MACAddress:   d.MACAddress.String(),
+			NameServers:  ipStrings(d.NameServers),
+			Hostname:     d.Hostname,
+			DomainName:   d.DomainName,
+			NTPServers:   ipStrings(d.NTPServers),
+			VLANID:       d.VLANID,
+			LeaseTime:    d.LeaseTime,
+			Arch:         d.Arch,
+			DomainSearch: d.DomainSearch,
+			Disabled:     d.Disabled,
+		}
+		rd.IPAddress = addrString(d.IPAddress)
+		rd.DefaultGateway = addrString(d.DefaultGateway)
+		rd.BroadcastAddress = addrString(d.BroadcastAddress)
+		if d.SubnetMask != nil {
+			rd.SubnetMask = net.IP(d.SubnetMask).String()
+		}
+	}
+	var rn *Netboot
+	if n != nil {
+		rn = &Netboot{
+			AllowNetboot: n.AllowNetboot,
+			IPXEScript:   r.String(n.IPXEScript),
+			Console:      n.Console,
+			Facility:     n.Facility,
+			Profile:      n.Profile,
+			OSIEKernel:   n.OSIE.Kernel,
+			OSIEInitrd:   n.OSIE.Initrd,
+			Labels:       n.Labels,
+		}
+		if n.IPXEScriptURL != nil {
+			rn.IPXEScriptURL = n.IPXEScriptURL.Redacted()
+		}
+		if n.OSIE.BaseURL != nil {
+			rn.OSIEBaseURL = n.OSIE.BaseURL.Redacted()
+		}
+		if n.Instance != nil {
+			rn.Instance = &Instance{ID: n.Instance.ID, Hostname: n.Instance.Hostname, UserData: r.String(n.Instance.UserData), PublicKeys: n.Instance.PublicKeys}
+		}
+	}
+
+	return rd, rn
+}
+
+// toData returns the data of the recorded d and n.
+func toData(d *DHCP, n *Netboot) (*data.DHCP, *data.Netboot, error) {
+	if d == nil {
+		return nil, nil, errors.New("the recorded lookup has no DHCP data")
+	}
+	mac, err := net.ParseMAC(d.MACAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	dd := &data.DHCP{
+		MACAddress:   mac,
+		Hostname:     d.Hostname,
+		DomainName:   d.DomainName,
+		VLANID:       d.VLANID,
+		LeaseTime:    d.LeaseTime,
+		Arch:         d.Arch,
+		DomainSearch: d.DomainSearch,
+		Disabled:     d.Disabled,
+	}
+	for _, a := range []struct {
+		s string
+		a *netip.Addr
+	}{{d.IPAddress, &dd.IPAddress}, {d.DefaultGateway, &dd.DefaultGateway}, {d.BroadcastAddress, &dd.BroadcastAddress}} {
+		if a.s == "" {
+			continue
+		}
+		if *a.a, err = netip.ParseAddr(a.s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if d.SubnetMask != "" {
+		ip := net.ParseIP(d.SubnetMask).To4()
+		if ip == nil {
+			return nil, nil, fmt.Errorf("invalid subnet mask %q", d.SubnetMask)
+		}
+		dd.SubnetMask = net.IPMask(ip)
+	}
+	if dd.NameServers, err = parseIPs(d.NameServers); err != nil {
+		return nil, nil, err
+	}
+	if dd.NTPServers, err = parseIPs(d.NTPServers); err != nil {
+		return nil, nil, err
+	}
+	if n == nil {
+		n = &Netboot{}
+	}
+	dn := &data.Netboot{
+		AllowNetboot: n.AllowNetboot,
+		IPXEScript:   n.IPXEScript,
+		Console:      n.Console,
+		Facility:     n.Facility,
+		Profile:      n.Profile,
+		OSIE:         data.OSIE{Kernel: n.OSIEKernel, Initrd: n.OSIEInitrd},
+		Labels:       n.Labels,
+	}
+	if n.IPXEScriptURL != "" {
+		if dn.IPXEScriptURL, err = url.Parse(n.IPXEScriptURL); err != nil {
+			return nil, nil, err
+		}
+	}
+	if n.OSIEBaseURL != "" {
+		if dn.OSIE.BaseURL, err = url.Parse(n.OSIEBaseURL); err != nil {
+			return nil, nil, err
+		}
+	}
+	if n.Instance != nil {
+		dn.Instance = &data.Instance{ID: n.Instance.ID, Hostname: n.Instance.Hostname, UserData: n.Instance.UserData, PublicKeys: n.Instance.PublicKeys}
+	}
+
+	return dd, dn, nil
+}
+
+func addrString(a netip.Addr) string {
+	if !a.IsValid() {
+		return ""
+	}
+
+	return a.String()
+}
+
+func ipStrings(ips []net.IP) []string {
+	var s []string
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+
+	return s
+}
+
+func parseIPs(s []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, e := range s {
+		ip := net.ParseIP(e)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", e)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+// notFoundError is returned for the lookups that were recorded as not found, and that were not recorded.
+type notFoundError struct{}
+
+func (notFoundError) NotFound() bool { return true }
+
+func (notFoundError) Error() string { return "hardware not found" }
+
+// Status implements the APIStatus interface from apimachinery/pkg/api/errors so that IsNotFound function could be
+// used against this error type.
+func (notFoundError) Status() metav1.Status {
+	return metav1.Status{
+		Reason: metav1.StatusReasonNotFound,
+		Code:   http.StatusNotFound,
+	}
+}
+
+func notFound(err error) bool {
+	type notFound interface {
+		NotFound() bool
+	}
+	var nf notFound
+
+	return (errors.As(err, &nf) && nf.NotFound()) || apierrors.IsNotFound(err)
+}
+
+// Recorder records the lookups of backends to a file.
+type Recorder struct {
+	// Log logs the lookups that can't be recorded, the lookups are returned as is.
+	Log logr.Logger
+
+	mu   sync.Mutex
+	f    *os.File
+	last map[string]string
+	// now is time.Now, it is replaced in tests.
+	now func() time.Time
+}
+
+// NewRecorder returns a Recorder that appends to the file at path, it is created when it doesn't exist.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{f: f, last: map[string]string{}, now: time.Now}, nil
+}
+
+// Close closes the file of the recording.
+func (r *Recorder) Close() error {
+	return r.f.Close()
+}
+
+// record appends the lookup of key with method, unless its response is the last one recorded for them.
+func (r *Recorder) record(method, key string, d *data.DHCP, n *data.Netboot, err error) {
+	if err := r.append(method, key, d, n, err); err != nil && r.Log.GetSink() != nil {
+		r.Log.Error(err, "failed to record a backend lookup", "method", method, "key", key)
+	}
+}
+
+func (r *Recorder) append(method, key string, d *data.DHCP, n *data.Netboot, err error) error {
+	e := Entry{Method: method, Key: key}
+	switch {
+	case err != nil && notFound(err):
+		e.NotFound = true
+	case err != nil:
+		e.Error = err.Error()
+	default:
+		e.DHCP, e.Netboot = fromData(d, n)
+	}
+	b, merr := json.Marshal(e)
+	if merr != nil {
+		return merr
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := method + " " + key
+	if r.last[id] == string(b) {
+		return nil
+	}
+	r.last[id] = string(b)
+	// the time is not compared, it is set once the entry is known to be recorded.
+	e.Time = r.now().UTC()
+	if b, merr = json.Marshal(e); merr != nil {
+		return merr
+	}
+	_, werr := r.f.Write(append(b, '\n'))
+
+	return werr
+}
+
+// Backend returns br with its lookups recorded, br as is with a nil Recorder. The returned backend is a
+// handler.BackendIdentityReader when br is one.
+func (r *Recorder) Backend(br handler.BackendReader) handler.BackendReader {
+	if r == nil {
+		return br
+	}
+	b := &recording{reader: br, rec: r}
+	if ir, ok := br.(handler.BackendIdentityReader); ok {
+		return &identityRecording{recording: b, identity: ir}
+	}
+
+	return b
+}
+
+type recording struct {
+	reader handler.BackendReader
+	rec    *Recorder
+}
+
+func (b *recording) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	d, n, err := b.reader.GetByMac(ctx, mac)
+	b.rec.record("GetByMac", mac.String(), d, n, err)
+
+	return d, n, err
+}
+
+func (b *recording) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	d, n, err := b.reader.GetByIP(ctx, ip)
+	b.rec.record("GetByIP", ip.String(), d, n, err)
+
+	return d, n, err
+}
+
+type identityRecording struct {
+	*recording
+	identity handler.BackendIdentityReader
+}
+
+func (b *identityRecording) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	d, n, err := b.identity.GetByUUID(ctx, uuid)
+	b.rec.record("GetByUUID", uuid, d, n, err)
+
+	return d, n, err
+}
+
+func (b *identityRecording) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	d, n, err := b.identity.GetBySerial(ctx, serial)
+	b.rec.record("GetBySerial", serial, d, n, err)
+
+	return d, n, err
+}
+
+func (b *identityRecording) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	d, n, err := b.identity.GetByHostname(ctx, hostname)
+	b.rec.record("GetByHostname", hostname, d, n, err)
+
+	return d, n, err
+}
+
+// Backend serves the lookups of a recording, the last recorded response of every method and key. The lookups that
+// were not recorded are not found. It implements handler.BackendReader and handler.BackendIdentityReader.
+type Backend struct {
+	entries map[string]Entry
+}
+
+// Load returns the Backend of the recording at path.
+func Load(path string) (*Backend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := &Backend{entries: map[string]Entry{}}
+	s := bufio.NewScanner(f)
+	// a line holds the iPXE script and the user data of a machine.
+	s.Buffer(nil, 16<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !e.NotFound && e.Error == "" {
+			if _, _, err := toData(e.DHCP, e.Netboot); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		b.entries[e.Method+" "+e.Key] = e
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Backend) lookup(method, key string) (*data.DHCP, *data.Netboot, error) {
+	e, ok := b.entries[method+" "+key]
+	switch {
+	case !ok || e.NotFound:
+		return nil, nil, notFoundError{}
+	case e.Error != "":
+		return nil, nil, errors.New(e.Error)
+	}
+
+	return toData(e.DHCP, e.Netboot)
+}
+
+// GetByMac implements handler.BackendReader.
+func (b *Backend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup("GetByMac", mac.String())
+}
+
+// GetByIP implements handler.BackendReader.
+func (b *Backend) GetByIP(_ context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup("GetByIP", ip.String())
+}
+
+// GetByUUID implements handler.BackendIdentityReader.
+func (b *Backend) GetByUUID(_ context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup("GetByUUID", uuid)
+}
+
+// GetBySerial implements handler.BackendIdentityReader.
+func (b *Backend) GetBySerial(_ context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup("GetBySerial", serial)
+}
+
+// GetByHostname implements handler.BackendIdentityReader.
+func (b *Backend) GetByHostname(_ context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup("GetByHostname", hostname)
+}
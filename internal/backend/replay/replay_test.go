@@ -0,0 +1,206 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/smee/internal/backend/conformance"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/plugin/backendtest"
+)
+
+var known = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+
+type notFoundErr struct{}
+
+func (notFoundErr) Error() string  { return "not found" }
+func (notFoundErr) NotFound() bool { return true }
+
+// source is the backend that is recorded, it knows the machine with the MAC address known.
+type source struct {
+	allow bool
+}
+
+func (s *source) record() (*data.DHCP, *data.Netboot) {
+	return &data.DHCP{
+		MACAddress:     known,
+		IPAddress:      netip.MustParseAddr("192.168.2.10"),
+		SubnetMask:     net.IPv4Mask(255, 255, 255, 0),
+		DefaultGateway: netip.MustParseAddr("192.168.2.1"),
+		NameServers:    []net.IP{net.IPv4(1, 1, 1, 1)},
+		Hostname:       "sm01",
+		LeaseTime:      86400,
+		Arch:           "x86_64",
+	}, &data.Netboot{
+		AllowNetboot:  s.allow,
+		IPXEScriptURL: &url.URL{Scheme: "http", Host: "192.168.2.5", Path: "/auto.ipxe"},
+		IPXEScript:    "#!ipxe\nkernel vmlinuz tink_token=abcdef\n",
+		Facility:      "onprem",
+		OSIE:          data.OSIE{BaseURL: &url.URL{Scheme: "http", User: url.UserPassword("user", "hunter2"), Host: "mirror", Path: "/hook"}},
+		Labels:        map[string]string{"rack": "r1"},
+		Instance:      &data.Instance{ID: "i-1", Hostname: "sm01"},
+	}
+}
+
+func (s *source) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if mac.String() != known.String() {
+		return nil, nil, notFoundErr{}
+	}
+	d, n := s.record()
+
+	return d, n, nil
+}
+
+func (s *source) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("connection refused")
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC) }
+	src := &source{allow: true}
+	br := r.Backend(src)
+	if _, ok := br.(handler.BackendIdentityReader); ok {
+		t.Fatal("want no BackendIdentityReader for a backend that isn't one")
+	}
+	// the same response twice is recorded once.
+	for range 2 {
+		if _, _, err := br.GetByMac(ctx, known); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.allow = false
+	if _, _, err := br.GetByMac(ctx, known); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := br.GetByMac(ctx, net.HardwareAddr{0, 0, 0, 0, 0, 1}); err == nil {
+		t.Fatal("want the lookup of an unknown machine to fail")
+	}
+	if _, _, err := br.GetByIP(ctx, net.IPv4(192, 168, 2, 10)); err == nil {
+		t.Fatal("want the lookup error as is")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != 4 {
+		t.Fatalf("got %d recorded lookups, want 4:\n%s", got, b)
+	}
+	for _, secret := range []string{"abcdef", "hunter2"} {
+		if strings.Contains(string(b), secret) {
+			t.Fatalf("the recording holds the secret %q:\n%s", secret, b)
+		}
+	}
+
+	rb, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, n, err := rb.GetByMac(ctx, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantD, wantN := (&source{}).record()
+	wantN.IPXEScript = "#!ipxe\nkernel vmlinuz tink_token=[REDACTED]\n"
+	wantN.OSIE.BaseURL.User = url.UserPassword("user", "xxxxx")
+	if diff := cmp.Diff(wantD, d, cmpopts.IgnoreUnexported(netip.Addr{})); diff != "" {
+		t.Fatal(diff)
+	}
+	urls := cmp.Comparer(func(a, b *url.URL) bool { return a.String() == b.String() })
+	if diff := cmp.Diff(wantN, n, urls); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, _, err := rb.GetByMac(ctx, net.HardwareAddr{0, 0, 0, 0, 0, 1}); !notFound(err) {
+		t.Fatalf("got %v, want a not found error", err)
+	}
+	if _, _, err := rb.GetByIP(ctx, net.IPv4(192, 168, 2, 10)); err == nil || err.Error() != "connection refused" {
+		t.Fatalf("got %v, want the recorded error", err)
+	}
+	if _, _, err := rb.GetByUUID(ctx, "never-recorded"); !notFound(err) {
+		t.Fatalf("got %v, want a not found error", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := map[string]struct {
+		recording string
+		wantErr   string
+	}{
+		"hand written": {recording: `{"method":"GetByMac","key":"00:01:02:03:04:05","dhcp":{"macAddress":"00:01:02:03:04:05","ipAddress":"192.168.2.10"},"netboot":{"allowNetboot":true}}` + "\n\n"},
+		"not json":     {recording: "GetByMac 00:01:02:03:04:05\n", wantErr: "line 1"},
+		"bad address":  {recording: `{"method":"GetByMac","key":"00:01:02:03:04:05","dhcp":{"macAddress":"00:01:02:03:04:05","ipAddress":"192.168.2"}}`, wantErr: "line 1"},
+		"no dhcp data": {recording: `{"method":"GetByMac","key":"00:01:02:03:04:05"}`, wantErr: "no DHCP data"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "recording.jsonl")
+			if err := os.WriteFile(path, []byte(tt.recording), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got %v, want an error with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	var recording []byte
+	for _, m := range backendtest.Machines() {
+		d := &DHCP{
+			MACAddress:     m.DHCP.MACAddress.String(),
+			IPAddress:      m.DHCP.IPAddress.String(),
+			SubnetMask:     net.IP(m.DHCP.SubnetMask).String(),
+			DefaultGateway: m.DHCP.DefaultGateway.String(),
+			NameServers:    ipStrings(m.DHCP.NameServers),
+			Hostname:       m.DHCP.Hostname,
+			LeaseTime:      m.DHCP.LeaseTime,
+			Arch:           m.DHCP.Arch,
+		}
+		n := &Netboot{AllowNetboot: m.Netboot.AllowNetboot, Facility: m.Netboot.Facility}
+		for _, e := range []Entry{
+			{Method: "GetByMac", Key: d.MACAddress, DHCP: d, Netboot: n},
+			{Method: "GetByIP", Key: d.IPAddress, DHCP: d, Netboot: n},
+		} {
+			b, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recording = append(append(recording, b...), '\n')
+		}
+	}
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	if err := os.WriteFile(path, recording, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformance.Run(t, b, backendtest.Options{})
+}
@@ -0,0 +1,246 @@
+// Package bench simulates concurrent network booting clients against a running Smee and reports latencies and error rates.
+// It is used to size deployments and to catch performance regressions.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Steps of a simulated network boot, in the order they are run.
+const (
+	StepDHCP   = "dhcp"
+	StepTFTP   = "tftp"
+	StepScript = "ipxe-script"
+	StepISO    = "iso"
+)
+
+// Config is the configuration of a benchmark run. Steps whose target is not set are skipped.
+type Config struct {
+	// DHCPAddr is the address of the DHCP server. Every client does a DISCOVER/OFFER and REQUEST/ACK exchange.
+	DHCPAddr netip.AddrPort
+	// TFTPAddr is the address of the TFTP server, TFTPFile is fetched from it.
+	TFTPAddr netip.AddrPort
+	TFTPFile string
+	// ScriptURL is the base URL of the iPXE script, <mac address>/auto.ipxe is appended, for example http://192.168.2.111:8080.
+	ScriptURL *url.URL
+	// ISOURL is the base URL of the ISO, <mac address>/hook.iso is appended, for example http://192.168.2.111:8080/iso.
+	// The first ISORangeBytes of the ISO are fetched with a range request.
+	ISOURL        *url.URL
+	ISORangeBytes int64
+	// Clients is the number of concurrent clients.
+	Clients int
+	// Iterations is the number of network boots each client simulates.
+	Iterations int
+	// Timeout is the timeout of each step.
+	Timeout time.Duration
+	// MACPrefix is the first 3 bytes of the client MAC addresses, the last 3 bytes are the client number.
+	// Hardware records are needed for these MAC addresses, unless Smee runs in auto-proxy mode.
+	MACPrefix net.HardwareAddr
+	// HTTPClient is used for the iPXE script and ISO requests. The default is http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Result holds the outcome of all requests of a step.
+type Result struct {
+	Step     string
+	Requests int
+	Errors   int
+	// FirstError is the first error of the step, it helps to tell a misconfiguration from load.
+	FirstError error
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+// Report holds the results of a benchmark run.
+type Report struct {
+	Results  []*Result
+	Duration time.Duration
+}
+
+// Run simulates the network boot of cfg.Clients concurrent clients and returns the report once all have finished.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Clients <= 0 {
+		return nil, errors.New("the number of clients must be greater than 0")
+	}
+	if len(cfg.MACPrefix) != 3 {
+		return nil, errors.New("the MAC address prefix must be 3 bytes")
+	}
+	if cfg.Iterations <= 0 {
+		cfg.Iterations = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	steps := cfg.steps()
+	if len(steps) == 0 {
+		return nil, errors.New("no targets are configured, at least one of DHCP, TFTP, iPXE script or ISO is required")
+	}
+	rep := &Report{}
+	for _, s := range steps {
+		rep.Results = append(rep.Results, &Result{Step: s.name})
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range cfg.Clients {
+		mac := cfg.mac(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range cfg.Iterations {
+				for j, s := range steps {
+					if ctx.Err() != nil {
+						return
+					}
+					sctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+					t := time.Now()
+					err := s.run(sctx, mac)
+					cancel()
+					rep.Results[j].record(time.Since(t), err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	rep.Duration = time.Since(start)
+
+	return rep, ctx.Err()
+}
+
+type step struct {
+	name string
+	run  func(context.Context, net.HardwareAddr) error
+}
+
+func (c Config) steps() []step {
+	var s []step
+	if c.DHCPAddr.IsValid() {
+		s = append(s, step{name: StepDHCP, run: c.dhcp})
+	}
+	if c.TFTPAddr.IsValid() {
+		s = append(s, step{name: StepTFTP, run: c.tftp})
+	}
+	if c.ScriptURL != nil {
+		s = append(s, step{name: StepScript, run: c.script})
+	}
+	if c.ISOURL != nil {
+		s = append(s, step{name: StepISO, run: c.iso})
+	}
+
+	return s
+}
+
+// mac returns the MAC address of client i.
+func (c Config) mac(i int) net.HardwareAddr {
+	return net.HardwareAddr{c.MACPrefix[0], c.MACPrefix[1], c.MACPrefix[2], byte(i >> 16), byte(i >> 8), byte(i)}
+}
+
+func (c Config) script(ctx context.Context, mac net.HardwareAddr) error {
+	return c.get(ctx, c.ScriptURL.JoinPath(mac.String(), "auto.ipxe"), 0)
+}
+
+func (c Config) iso(ctx context.Context, mac net.HardwareAddr) error {
+	return c.get(ctx, c.ISOURL.JoinPath(mac.String(), "hook.iso"), c.ISORangeBytes)
+}
+
+// get requests u and reads the response body, the first rangeBytes bytes only when rangeBytes is greater than 0.
+func (c Config) get(ctx context.Context, u *url.URL, rangeBytes int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if rangeBytes > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", rangeBytes-1))
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, u)
+	}
+	var body io.Reader = resp.Body
+	if rangeBytes > 0 {
+		body = io.LimitReader(resp.Body, rangeBytes)
+	}
+	_, err = io.Copy(io.Discard, body)
+
+	return err
+}
+
+func (r *Result) record(d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests++
+	if err != nil {
+		r.Errors++
+		if r.FirstError == nil {
+			r.FirstError = err
+		}
+		return
+	}
+	r.latencies = append(r.latencies, d)
+}
+
+// Percentile returns the p-th percentile, 0 to 100, of the latencies of successful requests.
+func (r *Result) Percentile(p float64) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	l := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	i := int(float64(len(l)-1) * p / 100)
+
+	return l[i]
+}
+
+// ErrorRate returns the fraction of requests that failed.
+func (r *Result) ErrorRate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Requests == 0 {
+		return 0
+	}
+
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// Write writes the report as a table to w.
+func (rep *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "STEP\tREQUESTS\tERRORS\tERROR RATE\tP50\tP90\tP99\tMAX\n")
+	for _, r := range rep.Results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%v\t%v\t%v\t%v\n", r.Step, r.Requests, r.Errors, r.ErrorRate()*100,
+			r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\ncompleted in %v\n", rep.Duration)
+	for _, r := range rep.Results {
+		if r.FirstError != nil {
+			fmt.Fprintf(w, "first %s error: %v\n", r.Step, r.FirstError)
+		}
+	}
+
+	return nil
+}
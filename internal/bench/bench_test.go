@@ -0,0 +1,154 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestPercentile(t *testing.T) {
+	tests := map[string]struct {
+		latencies []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		"no latencies": {p: 50, want: 0},
+		"median":       {latencies: []time.Duration{3, 1, 2}, p: 50, want: 2},
+		"max":          {latencies: []time.Duration{3, 1, 2}, p: 100, want: 3},
+		"min":          {latencies: []time.Duration{3, 1, 2}, p: 0, want: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &Result{latencies: tt.latencies}
+			if got := r.Percentile(tt.p); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	r := &Result{}
+	r.record(time.Millisecond, nil)
+	r.record(time.Millisecond, errors.New("failed"))
+	if diff := cmp.Diff(0.5, r.ErrorRate()); diff != "" {
+		t.Fatal(diff)
+	}
+	if r.FirstError == nil || r.FirstError.Error() != "failed" {
+		t.Fatalf("got first error %v, want failed", r.FirstError)
+	}
+}
+
+func TestRunHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/auto.ipxe"):
+			_, _ = w.Write([]byte("#!ipxe"))
+		case strings.HasSuffix(r.URL.Path, "/hook.iso") && r.Header.Get("Range") == "bytes=0-1023":
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(make([]byte, 1024))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	rep, err := Run(context.Background(), Config{
+		ScriptURL:     u,
+		ISOURL:        u.JoinPath("iso"),
+		ISORangeBytes: 1024,
+		Clients:       4,
+		Iterations:    2,
+		MACPrefix:     net.HardwareAddr{0x02, 0, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rep.Results {
+		if r.Requests != 8 || r.Errors != 0 {
+			t.Fatalf("%s: got %d requests and %d errors (%v), want 8 requests and 0 errors", r.Step, r.Requests, r.Errors, r.FirstError)
+		}
+	}
+}
+
+func TestRunDHCP(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, peer, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req, err := dhcpv4.FromBytes(buf[:n])
+			if err != nil {
+				continue
+			}
+			mt := dhcpv4.MessageTypeOffer
+			if req.MessageType() == dhcpv4.MessageTypeRequest {
+				mt = dhcpv4.MessageTypeAck
+			}
+			reply, err := dhcpv4.NewReplyFromRequest(req,
+				dhcpv4.WithMessageType(mt),
+				dhcpv4.WithYourIP(net.IPv4(192, 168, 2, 10)),
+				dhcpv4.WithServerIP(net.IPv4(127, 0, 0, 1)),
+				dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(127, 0, 0, 1))),
+			)
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteToUDP(reply.ToBytes(), peer)
+		}
+	}()
+
+	rep, err := Run(context.Background(), Config{
+		DHCPAddr:  conn.LocalAddr().(*net.UDPAddr).AddrPort(),
+		Clients:   3,
+		Timeout:   time.Second,
+		MACPrefix: net.HardwareAddr{0x02, 0, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rep.Results[0]
+	if r.Step != StepDHCP || r.Requests != 3 || r.Errors != 0 {
+		t.Fatalf("%s: got %d requests and %d errors (%v), want 3 requests and 0 errors", r.Step, r.Requests, r.Errors, r.FirstError)
+	}
+}
+
+func TestRunConfig(t *testing.T) {
+	tests := map[string]Config{
+		"no clients":     {Clients: 0, MACPrefix: net.HardwareAddr{0x02, 0, 0}, DHCPAddr: netip.MustParseAddrPort("127.0.0.1:67")},
+		"bad mac prefix": {Clients: 1, MACPrefix: net.HardwareAddr{0x02}, DHCPAddr: netip.MustParseAddrPort("127.0.0.1:67")},
+		"no targets":     {Clients: 1, MACPrefix: net.HardwareAddr{0x02, 0, 0}},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Run(context.Background(), cfg); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestMAC(t *testing.T) {
+	c := Config{MACPrefix: net.HardwareAddr{0x02, 0, 0}}
+	if diff := cmp.Diff("02:00:00:01:02:03", c.mac(0x010203).String()); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,94 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/pin/tftp/v3"
+)
+
+// pxeClassIdentifier is the option 60 value of a UEFI x86_64 PXE client.
+const pxeClassIdentifier = "PXEClient:Arch:00007:UNDI:003001"
+
+// dhcp does a DISCOVER/OFFER and REQUEST/ACK exchange as a PXE client with the given MAC address.
+func (c Config) dhcp(ctx context.Context, mac net.HardwareAddr) error {
+	conn, err := net.DialUDP("udp4", nil, net.UDPAddrFromAddrPort(c.DHCPAddr))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(d); err != nil {
+			return err
+		}
+	}
+
+	pxe := []dhcpv4.Modifier{
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier(pxeClassIdentifier)),
+		dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+		dhcpv4.WithGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 0}),
+	}
+	discover, err := dhcpv4.NewDiscovery(mac, pxe...)
+	if err != nil {
+		return err
+	}
+	offer, err := exchange(conn, discover, dhcpv4.MessageTypeOffer)
+	if err != nil {
+		return fmt.Errorf("DISCOVER: %w", err)
+	}
+	request, err := dhcpv4.NewRequestFromOffer(offer, pxe...)
+	if err != nil {
+		return err
+	}
+	if _, err := exchange(conn, request, dhcpv4.MessageTypeAck); err != nil {
+		return fmt.Errorf("REQUEST: %w", err)
+	}
+
+	return nil
+}
+
+// exchange sends pkt and returns the first reply with the same transaction ID and the wanted message type.
+func exchange(conn *net.UDPConn, pkt *dhcpv4.DHCPv4, want dhcpv4.MessageType) (*dhcpv4.DHCPv4, error) {
+	if _, err := conn.Write(pkt.ToBytes()); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil || reply.TransactionID != pkt.TransactionID {
+			continue
+		}
+		if reply.MessageType() != want {
+			return nil, fmt.Errorf("got a %v reply, want %v", reply.MessageType(), want)
+		}
+
+		return reply, nil
+	}
+}
+
+// tftp fetches TFTPFile from the TFTP server.
+func (c Config) tftp(ctx context.Context, _ net.HardwareAddr) error {
+	tc, err := tftp.NewClient(c.TFTPAddr.String())
+	if err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		tc.SetTimeout(time.Until(d))
+	}
+	wt, err := tc.Receive(c.TFTPFile, "octet")
+	if err != nil {
+		return err
+	}
+	_, err = wt.WriteTo(io.Discard)
+
+	return err
+}
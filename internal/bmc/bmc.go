@@ -0,0 +1,177 @@
+// Package bmc orchestrates network booting a machine through Rufio BMC Jobs and tracks the resulting boot sessions.
+package bmc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const tracerName = "github.com/tinkerbell/smee/internal/bmc"
+
+// JobGVK is the Rufio Job GroupVersionKind.
+var JobGVK = schema.GroupVersionKind{Group: "bmc.tinkerbell.org", Version: "v1alpha1", Kind: "Job"}
+
+// Errors returned by the Orchestrator.
+var (
+	errHardwareNotFound = errors.New("hardware not found")
+	errNoBMCRef         = errors.New("hardware has no bmcRef")
+)
+
+// Session is a requested netboot of a machine.
+type Session struct {
+	// MAC is the MAC address of the machine.
+	MAC string `json:"mac"`
+	// Hardware is the namespace/name of the Hardware object.
+	Hardware string `json:"hardware"`
+	// Job is the namespace/name of the Rufio Job that was created.
+	Job string `json:"job"`
+	// RequestedAt is when the netboot was requested.
+	RequestedAt time.Time `json:"requestedAt"`
+	// BootedAt is when an iPXE script was first served to the machine after the netboot was requested.
+	BootedAt *time.Time `json:"bootedAt,omitempty"`
+}
+
+// Orchestrator creates Rufio Jobs that set the next boot device to PXE and power cycle a machine.
+type Orchestrator struct {
+	// Client is used to read Hardware objects and create Rufio Jobs.
+	// It must have the kube.MACAddrIndex field index registered.
+	Client client.Client
+	// Log is used to log messages.
+	Log logr.Logger
+
+	mu       sync.Mutex // protects sessions
+	sessions map[string]Session
+}
+
+// Netboot looks up the Hardware for mac and creates a Rufio Job, against the Hardware's bmcRef,
+// that sets the one time boot device to PXE and power cycles the machine.
+func (o *Orchestrator) Netboot(ctx context.Context, mac net.HardwareAddr) (Session, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "bmc.Netboot")
+	defer span.End()
+
+	hw, err := o.hardware(ctx, mac)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return Session{}, err
+	}
+	if hw.Spec.BMCRef == nil || hw.Spec.BMCRef.Name == "" {
+		span.SetStatus(codes.Error, errNoBMCRef.Error())
+		return Session{}, fmt.Errorf("%w: %s/%s", errNoBMCRef, hw.Namespace, hw.Name)
+	}
+
+	job := netbootJob(hw, efiBoot(hw, mac))
+	if err := o.Client.Create(ctx, job); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return Session{}, fmt.Errorf("failed creating rufio job for (%v): %w", mac, err)
+	}
+
+	s := Session{
+		MAC:         mac.String(),
+		Hardware:    fmt.Sprintf("%s/%s", hw.Namespace, hw.Name),
+		Job:         fmt.Sprintf("%s/%s", job.GetNamespace(), job.GetName()),
+		RequestedAt: time.Now().UTC(),
+	}
+	o.mu.Lock()
+	if o.sessions == nil {
+		o.sessions = make(map[string]Session)
+	}
+	o.sessions[s.MAC] = s
+	o.mu.Unlock()
+	span.SetStatus(codes.Ok, "")
+
+	return s, nil
+}
+
+// Session returns the latest netboot session for mac, if any.
+func (o *Orchestrator) Session(mac net.HardwareAddr) (Session, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s, ok := o.sessions[mac.String()]
+
+	return s, ok
+}
+
+// ScriptServed implements the script.Observer interface.
+// It marks the netboot session for mac, if any, as booted.
+func (o *Orchestrator) ScriptServed(_ context.Context, mac net.HardwareAddr, _ string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s, ok := o.sessions[mac.String()]
+	if !ok || s.BootedAt != nil {
+		return
+	}
+	now := time.Now().UTC()
+	s.BootedAt = &now
+	o.sessions[s.MAC] = s
+	if o.Log.GetSink() != nil {
+		o.Log.Info("netboot session booted", "mac", s.MAC, "job", s.Job, "duration", now.Sub(s.RequestedAt))
+	}
+}
+
+func (o *Orchestrator) hardware(ctx context.Context, mac net.HardwareAddr) (*v1alpha1.Hardware, error) {
+	hl := &v1alpha1.HardwareList{}
+	if err := o.Client.List(ctx, hl, &client.MatchingFields{kube.MACAddrIndex: mac.String()}); err != nil {
+		return nil, fmt.Errorf("failed listing hardware for (%v): %w", mac, err)
+	}
+	switch len(hl.Items) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", errHardwareNotFound, mac)
+	case 1:
+		return &hl.Items[0], nil
+	default:
+		return nil, fmt.Errorf("got %d hardware objects for mac %s, expected only 1", len(hl.Items), mac)
+	}
+}
+
+// efiBoot returns whether the interface with mac is marked as UEFI.
+func efiBoot(hw *v1alpha1.Hardware, mac net.HardwareAddr) bool {
+	for _, i := range hw.Spec.Interfaces {
+		if i.DHCP != nil && strings.EqualFold(i.DHCP.MAC, mac.String()) {
+			return i.DHCP.UEFI
+		}
+	}
+
+	return false
+}
+
+// netbootJob returns a Rufio Job that powers off the machine, sets the one time boot device to PXE and powers on the machine.
+func netbootJob(hw *v1alpha1.Hardware, efi bool) *unstructured.Unstructured {
+	j := &unstructured.Unstructured{}
+	j.SetGroupVersionKind(JobGVK)
+	j.SetGenerateName(fmt.Sprintf("%s-netboot-", hw.Name))
+	j.SetNamespace(hw.Namespace)
+	j.SetLabels(map[string]string{"smee.tinkerbell.org/hardware": hw.Name})
+	j.Object["spec"] = map[string]interface{}{
+		"machineRef": map[string]interface{}{
+			"name":      hw.Spec.BMCRef.Name,
+			"namespace": hw.Namespace,
+		},
+		"tasks": []interface{}{
+			map[string]interface{}{"powerAction": "off"},
+			map[string]interface{}{
+				"oneTimeBootDeviceAction": map[string]interface{}{
+					"device":  []interface{}{"pxe"},
+					"efiBoot": efi,
+				},
+			},
+			map[string]interface{}{"powerAction": "on"},
+		},
+	}
+
+	return j
+}
@@ -0,0 +1,128 @@
+package bmc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	rs := runtime.NewScheme()
+	if err := scheme.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+
+	return fake.NewClientBuilder().WithScheme(rs).WithObjects(objs...).WithIndex(&v1alpha1.Hardware{}, kube.MACAddrIndex, kube.MACAddrs).Build()
+}
+
+func hardware(bmcRef string) *v1alpha1.Hardware {
+	hw := &v1alpha1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine1", Namespace: "tink-system"},
+		Spec: v1alpha1.HardwareSpec{
+			Interfaces: []v1alpha1.Interface{{DHCP: &v1alpha1.DHCP{MAC: "3c:ec:ef:4c:4f:54", UEFI: true}}},
+		},
+	}
+	if bmcRef != "" {
+		hw.Spec.BMCRef = &corev1.TypedLocalObjectReference{Name: bmcRef, Kind: "Machine"}
+	}
+
+	return hw
+}
+
+func TestNetboot(t *testing.T) {
+	mac := net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+	tests := map[string]struct {
+		hw        *v1alpha1.Hardware
+		shouldErr bool
+	}{
+		"success":            {hw: hardware("bmc1")},
+		"no bmcRef":          {hw: hardware(""), shouldErr: true},
+		"hardware not found": {shouldErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var objs []client.Object
+			if tt.hw != nil {
+				objs = append(objs, tt.hw)
+			}
+			cl := newClient(t, objs...)
+			o := &Orchestrator{Client: cl}
+			s, err := o.Netboot(context.Background(), mac)
+			if tt.shouldErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tt.shouldErr, err)
+			}
+			if tt.shouldErr {
+				return
+			}
+
+			jobs := &unstructured.UnstructuredList{}
+			jobs.SetGroupVersionKind(JobGVK.GroupVersion().WithKind("JobList"))
+			if err := cl.List(context.Background(), jobs); err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs.Items) != 1 {
+				t.Fatalf("expected 1 job, got %d", len(jobs.Items))
+			}
+			ref, _, _ := unstructured.NestedStringMap(jobs.Items[0].Object, "spec", "machineRef")
+			if diff := cmp.Diff(map[string]string{"name": "bmc1", "namespace": "tink-system"}, ref); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff("tink-system/machine1", s.Hardware); diff != "" {
+				t.Fatal(diff)
+			}
+
+			o.ScriptServed(context.Background(), mac, "auto.ipxe")
+			got, ok := o.Session(mac)
+			if !ok || got.BootedAt == nil {
+				t.Fatalf("expected a booted session, got: %+v", got)
+			}
+		})
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+	allowed := []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	tests := map[string]struct {
+		method     string
+		path       string
+		remoteAddr string
+		want       int
+	}{
+		"forbidden":    {method: http.MethodPost, path: "/bmc/3c:ec:ef:4c:4f:54/netboot", remoteAddr: "10.1.1.1:1234", want: http.StatusForbidden},
+		"bad mac":      {method: http.MethodPost, path: "/bmc/bad/netboot", remoteAddr: "127.0.0.1:1234", want: http.StatusBadRequest},
+		"unknown path": {method: http.MethodPost, path: "/bmc/3c:ec:ef:4c:4f:54/other", remoteAddr: "127.0.0.1:1234", want: http.StatusNotFound},
+		"netboot":      {method: http.MethodPost, path: "/bmc/3c:ec:ef:4c:4f:54/netboot", remoteAddr: "127.0.0.1:1234", want: http.StatusAccepted},
+		"no session":   {method: http.MethodGet, path: "/bmc/3c:ec:ef:4c:4f:54/netboot", remoteAddr: "127.0.0.1:1234", want: http.StatusNotFound},
+		"bad method":   {method: http.MethodDelete, path: "/bmc/3c:ec:ef:4c:4f:54/netboot", remoteAddr: "127.0.0.1:1234", want: http.StatusMethodNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := &Orchestrator{Client: newClient(t, hardware("bmc1"))}
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			o.HandlerFunc(allowed)(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("want: %d, got: %d", tt.want, w.Code)
+			}
+		})
+	}
+}
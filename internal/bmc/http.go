@@ -0,0 +1,78 @@
+package bmc
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+)
+
+// HandlerFunc returns a http.HandlerFunc for requesting and inspecting netboot sessions.
+// It is expected that the request path is /bmc/<mac address>/netboot.
+//
+// POST creates a Rufio Job to netboot the machine and GET returns the latest netboot session.
+// Only clients with a source IP in allowed are served.
+func (o *Orchestrator) HandlerFunc(allowed []netip.Prefix) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !clientAllowed(r.RemoteAddr, allowed) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if path.Base(r.URL.Path) != "netboot" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mac, err := net.ParseMAC(path.Base(path.Dir(r.URL.Path)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			s, err := o.Netboot(r.Context(), mac)
+			if err != nil {
+				o.Log.Info("unable to netboot machine", "mac", mac, "error", err)
+				status := http.StatusInternalServerError
+				if errors.Is(err, errHardwareNotFound) || errors.Is(err, errNoBMCRef) {
+					status = http.StatusNotFound
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+			o.Log.Info("netboot requested", "mac", mac, "job", s.Job)
+			writeJSON(w, http.StatusAccepted, s)
+		case http.MethodGet:
+			s, ok := o.Session(mac)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, s)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func clientAllowed(remoteAddr string, allowed []netip.Prefix) bool {
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, p := range allowed {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
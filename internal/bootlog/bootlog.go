@@ -0,0 +1,95 @@
+// Package bootlog keeps the recent boot events and syslog messages of every machine, and serves them back to the
+// machine itself, so that diagnostics tooling running in HookOS can see what Smee saw of its boot.
+package bootlog
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/syslog"
+)
+
+// Entry is a boot event or a syslog message of a machine.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Type is the kind of entry, "dhcp", "script", "phone-home" or "syslog".
+	Type string `json:"type"`
+	// Detail is the DHCP message type, the script name, or the syslog message.
+	Detail string `json:"detail"`
+	// Severity and App are the severity and the app name of a syslog message.
+	Severity string `json:"severity,omitempty"`
+	App      string `json:"app,omitempty"`
+}
+
+// Log holds the last entries of every machine. It implements script.Observer, handler.Observer, phonehome.Observer
+// and syslog.Observer. A nil Log keeps no entry.
+type Log struct {
+	max int
+
+	mu       sync.Mutex
+	machines map[string][]Entry
+	now      func() time.Time
+}
+
+// NewLog returns a Log that keeps the last max entries of every machine, it returns nil when max is 0 or less.
+func NewLog(max int) *Log {
+	if max <= 0 {
+		return nil
+	}
+
+	return &Log{max: max, machines: map[string][]Entry{}, now: time.Now}
+}
+
+// ScriptServed implements script.Observer.
+func (l *Log) ScriptServed(_ context.Context, mac net.HardwareAddr, name string) {
+	l.Record(mac, Entry{Type: "script", Detail: name})
+}
+
+// DHCPServed implements handler.Observer.
+func (l *Log) DHCPServed(_ context.Context, mac net.HardwareAddr, msgType string) {
+	l.Record(mac, Entry{Type: "dhcp", Detail: msgType})
+}
+
+// BootCompleted implements phonehome.Observer.
+func (l *Log) BootCompleted(_ context.Context, mac net.HardwareAddr) {
+	l.Record(mac, Entry{Type: "phone-home", Detail: "boot completed"})
+}
+
+// SyslogReceived implements syslog.Observer. Messages from hosts that are not resolved to a machine are dropped.
+func (l *Log) SyslogReceived(m syslog.Message) {
+	if m.MAC == nil {
+		return
+	}
+	l.Record(m.MAC, Entry{Time: m.Time, Type: "syslog", Detail: m.Msg, Severity: m.Severity, App: m.AppName})
+}
+
+// Record adds e to the entries of mac, the oldest entries over the maximum are dropped. The time of e is set when it
+// is zero.
+func (l *Log) Record(mac net.HardwareAddr, e Entry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	entries := append(l.machines[mac.String()], e)
+	if len(entries) > l.max {
+		entries = entries[len(entries)-l.max:]
+	}
+	l.machines[mac.String()] = entries
+}
+
+// Entries returns the entries of mac, oldest first.
+func (l *Log) Entries(mac net.HardwareAddr) []Entry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Entry(nil), l.machines[mac.String()]...)
+}
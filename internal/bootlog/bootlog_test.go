@@ -0,0 +1,95 @@
+package bootlog
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/phonehome"
+	"github.com/tinkerbell/smee/internal/syslog"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+var mac = net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+
+func TestLog(t *testing.T) {
+	l := NewLog(2)
+	now := time.Unix(1700000000, 0).UTC()
+	l.now = func() time.Time { return now }
+	l.DHCPServed(context.Background(), mac, "DHCPOFFER")
+	l.ScriptServed(context.Background(), mac, "auto.ipxe")
+	l.SyslogReceived(syslog.Message{Time: now, MAC: mac, Severity: "ERR", AppName: "tink-worker", Msg: "unable to reach tink server"})
+	l.SyslogReceived(syslog.Message{Time: now, Msg: "from a host that is not resolved"})
+
+	want := []Entry{
+		{Time: now, Type: "script", Detail: "auto.ipxe"},
+		{Time: now, Type: "syslog", Detail: "unable to reach tink server", Severity: "ERR", App: "tink-worker"},
+	}
+	if diff := cmp.Diff(want, l.Entries(mac)); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var nl *Log
+	nl.BootCompleted(context.Background(), mac)
+	if e := nl.Entries(mac); e != nil {
+		t.Fatalf("got entries %v, want none for a nil Log", e)
+	}
+	if NewLog(0) != nil {
+		t.Fatal("expected no Log without a maximum")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Now()
+	tokens := &phonehome.Tokens{Key: []byte("key"), TTL: time.Hour}
+	l := NewLog(10)
+	l.Record(mac, Entry{Time: now.Add(-time.Minute), Type: "dhcp", Detail: "DHCPACK"})
+	l.Record(mac, Entry{Time: now, Type: "script", Detail: "auto.ipxe"})
+	h := &Handler{Log: l, Tokens: tokens, Logger: logr.Discard()}
+	token := tokens.Token(mac, now)
+
+	tests := map[string]struct {
+		method string
+		query  string
+		status int
+		want   int
+	}{
+		"entries":     {method: http.MethodGet, query: "?mac=3c:ec:ef:4c:4f:54&token=" + token, status: http.StatusOK, want: 2},
+		"since":       {method: http.MethodGet, query: "?mac=3c:ec:ef:4c:4f:54&token=" + token + "&since=" + now.Add(-time.Second).Format(time.RFC3339Nano), status: http.StatusOK, want: 1},
+		"other mac":   {method: http.MethodGet, query: "?mac=3c:ec:ef:4c:4f:55&token=" + token, status: http.StatusForbidden},
+		"no token":    {method: http.MethodGet, query: "?mac=3c:ec:ef:4c:4f:54", status: http.StatusForbidden},
+		"invalid mac": {method: http.MethodGet, query: "?mac=nope&token=" + token, status: http.StatusBadRequest},
+		"post":        {method: http.MethodPost, query: "?mac=3c:ec:ef:4c:4f:54&token=" + token, status: http.StatusMethodNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, httptest.NewRequest(tt.method, "/bootlog"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var res Response
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.MAC != mac.String() || len(res.Entries) != tt.want {
+				t.Fatalf("got %+v, want %d entries of %s", res, tt.want, mac)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package bootlog
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/phonehome"
+)
+
+// Response is the body of a boot log response.
+type Response struct {
+	MAC     string  `json:"mac"`
+	Entries []Entry `json:"entries"`
+}
+
+// Handler serves the boot log of a machine to the machine itself, the phone home token of the machine authorizes
+// the request.
+type Handler struct {
+	Log    *Log
+	Tokens *phonehome.Tokens
+	Logger logr.Logger
+}
+
+// HandlerFunc returns a http.HandlerFunc for the boot log requests.
+// It is expected that the request is GET /bootlog?mac=<mac>&token=<token>, where the token is the phone home token of
+// the machine, the last element of its phone_home_url kernel arg. The optional since parameter, an RFC 3339 time,
+// limits the entries to the ones after it.
+//
+// The entries are returned as a JSON Response, oldest first.
+func (h *Handler) HandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		labels := prometheus.Labels{"from": "http", "op": "bootlog"}
+		metric.JobsTotal.With(labels).Inc()
+		metric.JobsInProgress.With(labels).Inc()
+		defer metric.JobsInProgress.With(labels).Dec()
+		timer := prometheus.NewTimer(metric.JobDuration.With(labels))
+		defer timer.ObserveDuration()
+
+		q := r.URL.Query()
+		mac, err := net.ParseMAC(q.Get("mac"))
+		if err != nil {
+			http.Error(w, "invalid mac", http.StatusBadRequest)
+			return
+		}
+		var since time.Time
+		if s := q.Get("since"); s != "" {
+			if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				http.Error(w, "invalid since time", http.StatusBadRequest)
+				return
+			}
+		}
+		// the token is bound to the MAC address of a machine, a machine can only read its own boot log.
+		tm, err := h.Tokens.MAC(q.Get("token"), time.Now())
+		if err != nil || tm.String() != mac.String() {
+			h.Logger.Info("rejected boot log request", "client", r.RemoteAddr, "mac", mac, "error", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		res := Response{MAC: mac.String(), Entries: []Entry{}}
+		for _, e := range h.Log.Entries(mac) {
+			if e.Time.After(since) {
+				res.Entries = append(res.Entries, e)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			h.Logger.Info("unable to write the boot log", "mac", mac, "error", err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package bootstrap serves the cluster bootstrap documents of machines, like the machine config of Talos Linux and
+// the config.yaml or cloud-init user-data of a k3s join, so that Smee serves both the netboot and the bootstrap config
+// of Kubernetes on bare metal.
+//
+// The documents are operator defined templates, executed per machine with its backend data, served at
+// /bootstrap/<name>. The machine is identified by the source IP of the request, like the instance metadata. The
+// secret function of the templates reads the cluster secrets, like the join token or the Talos secrets bundle, from
+// the files of a directory, for example a mounted Kubernetes Secret, so that they are not kept in the templates.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/httpfile"
+	"github.com/tinkerbell/smee/internal/tmpl"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Prefix is the URI prefix that the bootstrap documents are served from, for example /bootstrap/talos.yaml.
+const Prefix = "/bootstrap/"
+
+// Data is what the document templates are executed with.
+type Data struct {
+	tmpl.Machine
+	// Name is the name of the document, for example talos.yaml.
+	Name string
+	// HTTPServer is the base URL of the HTTP server, for example http://192.168.2.111:8080.
+	HTTPServer string
+}
+
+// Handler serves the bootstrap documents of machines over HTTP.
+type Handler struct {
+	Backend handler.BackendReader
+	// Documents are the templates of the documents by name, see Load.
+	Documents map[string]string
+	// SecretsDir is the directory that the secret function reads from, the templates can't read secrets when empty.
+	SecretsDir string
+	// HTTPServer is the base URL of the HTTP server, see Data.
+	HTTPServer string
+	// TemplateEnv is the allow list of the environment variables that the templates can read, see tmpl.Options.
+	TemplateEnv []string
+	Log         logr.Logger
+}
+
+// Load returns the templates of the regular files in dir by file name, the files whose name starts with a dot are
+// skipped.
+func Load(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	docs := map[string]string{}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || !e.Type().IsRegular() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		docs[e.Name()] = string(b)
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no bootstrap documents in %v", dir)
+	}
+
+	return docs, nil
+}
+
+// secret returns the content of the file name in the secrets directory, without its trailing newlines. name must be
+// a file name, not a path, so that templates can only read the files of the secrets directory.
+func (h *Handler) secret(name string) (string, error) {
+	if h.SecretsDir == "" {
+		return "", errors.New("no secrets directory is configured")
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	b, err := os.ReadFile(filepath.Join(h.SecretsDir, name))
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret %q: %w", name, err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// render returns the document name of the machine with the IP address src.
+func (h *Handler) render(ctx context.Context, name string, src net.IP) ([]byte, error) {
+	d, n, err := h.Backend.GetByIP(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	data := Data{Machine: tmpl.FromBackend(d, n), Name: name, HTTPServer: h.HTTPServer}
+	funcs := tmpl.Funcs(tmpl.Options{Env: h.TemplateEnv})
+	funcs["secret"] = h.secret
+	s, err := tmpl.Execute(name, h.Documents[name], data, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(s), nil
+}
+
+// ServeHTTP serves the bootstrap documents of the machine that makes the request, under Prefix.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+	if _, ok := h.Documents[name]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !httpfile.Allowed(w, r) {
+		return
+	}
+	log := h.log().WithValues("path", r.URL.Path, "client", r.RemoteAddr)
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "unable to parse client address", http.StatusBadRequest)
+		return
+	}
+	b, err := h.render(r.Context(), name, net.IP(ap.Addr().Unmap().AsSlice()))
+	if err != nil {
+		log.Info("not serving bootstrap document", "error", err)
+		if notFound(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// the documents hold cluster secrets, they must not be kept by caches.
+	w.Header().Set("Cache-Control", "no-store")
+	httpfile.ServeBytes(w, r, name, time.Time{}, b)
+	log.Info("served bootstrap document", "name", name)
+}
+
+func (h *Handler) log() logr.Logger {
+	if h.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return h.Log
+}
+
+func notFound(err error) bool {
+	type notFound interface {
+		NotFound() bool
+	}
+	var nf notFound
+
+	return (errors.As(err, &nf) && nf.NotFound()) || apierrors.IsNotFound(err)
+}
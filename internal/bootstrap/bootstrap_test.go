@@ -0,0 +1,131 @@
+package bootstrap
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string  { return "not found" }
+func (notFoundError) NotFound() bool { return true }
+
+// fakeBackend has a machine with the IP address 192.168.2.10.
+type fakeBackend struct{}
+
+func (fakeBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, notFoundError{}
+}
+
+func (fakeBackend) GetByIP(_ context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	if !ip.Equal(net.IPv4(192, 168, 2, 10)) {
+		return nil, nil, notFoundError{}
+	}
+
+	return &data.DHCP{
+		MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		IPAddress:  netip.MustParseAddr("192.168.2.10"),
+		Hostname:   "cp1",
+	}, &data.Netboot{Labels: map[string]string{"role": "controlplane"}}, nil
+}
+
+func newHandler(t *testing.T) *Handler {
+	t.Helper()
+	secrets := t.TempDir()
+	if err := os.WriteFile(filepath.Join(secrets, "token"), []byte("K10abc::server:xyz\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Handler{
+		Backend: fakeBackend{},
+		Documents: map[string]string{
+			"k3s.yaml":    "token: {{ secret \"token\" }}\nnode-name: {{ .Hostname }}\nnode-ip: {{ .IP }}\n",
+			"talos.yaml":  "machine:\n  type: {{ .Labels.role }}\n",
+			"escape.yaml": "{{ secret \"../token\" }}",
+		},
+		SecretsDir: secrets,
+		HTTPServer: "http://192.168.2.5:8080",
+		Log:        logr.Discard(),
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := map[string]struct {
+		method     string
+		path       string
+		remoteAddr string
+		wantCode   int
+		wantBody   string
+	}{
+		"k3s":                 {path: "/bootstrap/k3s.yaml", remoteAddr: "192.168.2.10:4000", wantCode: http.StatusOK, wantBody: "token: K10abc::server:xyz\nnode-name: cp1\nnode-ip: 192.168.2.10\n"},
+		"talos":               {path: "/bootstrap/talos.yaml", remoteAddr: "192.168.2.10:4000", wantCode: http.StatusOK, wantBody: "machine:\n  type: controlplane\n"},
+		"ipv4 mapped address": {path: "/bootstrap/talos.yaml", remoteAddr: "[::ffff:192.168.2.10]:4000", wantCode: http.StatusOK, wantBody: "machine:\n  type: controlplane\n"},
+		"unknown machine":     {path: "/bootstrap/talos.yaml", remoteAddr: "192.168.2.11:4000", wantCode: http.StatusNotFound},
+		"unknown document":    {path: "/bootstrap/rke2.yaml", remoteAddr: "192.168.2.10:4000", wantCode: http.StatusNotFound},
+		"secret outside":      {path: "/bootstrap/escape.yaml", remoteAddr: "192.168.2.10:4000", wantCode: http.StatusInternalServerError},
+		"post":                {method: http.MethodPost, path: "/bootstrap/talos.yaml", remoteAddr: "192.168.2.10:4000", wantCode: http.StatusMethodNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			newHandler(t).ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Fatalf("got body %q, want %q", got, tt.wantBody)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Fatalf("got Cache-Control %q, want no-store", got)
+			}
+		})
+	}
+}
+
+func TestSecretWithoutDir(t *testing.T) {
+	h := newHandler(t)
+	h.SecretsDir = ""
+	req := httptest.NewRequest(http.MethodGet, "/bootstrap/k3s.yaml", nil)
+	req.RemoteAddr = "192.168.2.10:4000"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"talos.yaml": "machine: {}\n", ".hidden": "x"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	docs, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs["talos.yaml"] != "machine: {}\n" {
+		t.Fatalf("got %v, want only talos.yaml", docs)
+	}
+	if _, err := Load(t.TempDir()); err == nil {
+		t.Fatal("want an error for a directory without documents")
+	}
+}
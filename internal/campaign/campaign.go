@@ -0,0 +1,148 @@
+// Package campaign reprovisions fleets of machines on a schedule. A campaign is a declarative list of machines, by MAC
+// address or by the labels of their Hardware objects, that are reimaged with a boot profile from a start time, at
+// most a number of machines at once:
+//
+//	campaigns:
+//	- name: rack-a-reimage
+//	  selector:
+//	    rack: a
+//	  profile: burnin
+//	  start: 2026-11-01T02:00:00Z
+//	  concurrency: 5
+//	  timeout: 2h
+//	- name: replace-disks
+//	  machines: ["3c:ec:ef:4c:4f:54", "3c:ec:ef:4c:4f:55"]
+//
+// Every machine of a campaign is prepared, its netboot is enabled and its boot profile is set in its Hardware object,
+// and then power cycled into a netboot by a Rufio BMC Job. It is in progress until it phones home, or until it is
+// served its iPXE script when phone home is disabled, and failed when that takes longer than the timeout.
+package campaign
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Defaults of a Campaign.
+const (
+	DefaultConcurrency = 1
+	DefaultTimeout     = time.Hour
+)
+
+// Config holds the campaigns.
+type Config struct {
+	Campaigns []Campaign `json:"campaigns"`
+}
+
+// Campaign is a reprovisioning of machines.
+type Campaign struct {
+	// Name identifies the campaign, in logs and in the state file.
+	Name string `json:"name"`
+	// Machines are the MAC addresses of the machines of the campaign.
+	Machines []string `json:"machines,omitempty"`
+	// Selector are the labels of the Hardware objects of the machines of the campaign, their first interface with a
+	// DHCP MAC address is netbooted. It is resolved once, when the campaign starts.
+	Selector map[string]string `json:"selector,omitempty"`
+	// Profile is the name of the boot profile that the machines are set to, see the profile package. The profile of
+	// the machines is not changed when it is empty.
+	Profile string `json:"profile,omitempty"`
+	// Start is the time from which the campaign runs, it runs right away when it is not set.
+	Start time.Time `json:"start"`
+	// Concurrency is the number of machines that are reprovisioned at once, DefaultConcurrency when 0.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Timeout is how long a machine has to complete its reprovisioning, like 90m, DefaultTimeout when empty.
+	Timeout string `json:"timeout,omitempty"`
+
+	macs    []net.HardwareAddr
+	timeout time.Duration
+}
+
+// Load reads and validates a YAML, or JSON, campaigns file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, campaigns config.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse campaigns: %w", err)
+	}
+	var errs []error
+	names := map[string]bool{}
+	for i := range c.Campaigns {
+		cp := &c.Campaigns[i]
+		if err := cp.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("campaign %d (%s): %w", i, cp.Name, err))
+		}
+		if names[cp.Name] {
+			errs = append(errs, fmt.Errorf("campaign %d: duplicate name %q", i, cp.Name))
+		}
+		names[cp.Name] = true
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func (c *Campaign) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(c.Machines) == 0 && len(c.Selector) == 0 {
+		return errors.New("machines or selector is required")
+	}
+	for _, m := range c.Machines {
+		mac, err := net.ParseMAC(m)
+		if err != nil {
+			return fmt.Errorf("invalid machine mac %q: %w", m, err)
+		}
+		c.macs = append(c.macs, mac)
+	}
+	if c.Concurrency < 0 {
+		return errors.New("concurrency must not be negative")
+	}
+	c.timeout = DefaultTimeout
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q, must be a positive duration like 90m", c.Timeout)
+		}
+		c.timeout = d
+	}
+
+	return nil
+}
+
+func (c *Campaign) concurrency() int {
+	if c.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+
+	return c.Concurrency
+}
+
+// Profiles returns the names of the boot profiles of the campaigns, so that they can be checked against the
+// profiles that are loaded.
+func (c *Config) Profiles() []string {
+	var names []string
+	for _, cp := range c.Campaigns {
+		if cp.Profile != "" {
+			names = append(names, cp.Profile)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,62 @@
+package campaign
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+campaigns:
+- name: rack-a
+  selector:
+    rack: a
+  profile: burnin
+  start: 2026-11-01T02:00:00Z
+  concurrency: 5
+  timeout: 2h
+- name: disks
+  machines: ["3c:ec:ef:4c:4f:54"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"burnin"}, c.Profiles()); diff != "" {
+		t.Error(diff)
+	}
+	a, d := c.Campaigns[0], c.Campaigns[1]
+	if !a.Start.Equal(time.Date(2026, 11, 1, 2, 0, 0, 0, time.UTC)) || a.timeout != 2*time.Hour || a.concurrency() != 5 {
+		t.Errorf("unexpected campaign %+v", a)
+	}
+	if len(d.macs) != 1 || d.timeout != DefaultTimeout || d.concurrency() != DefaultConcurrency || !d.Start.IsZero() {
+		t.Errorf("expected the defaults, got %+v", d)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		want   string
+	}{
+		"no name":        {config: `campaigns: [{machines: ["3c:ec:ef:4c:4f:54"]}]`, want: "name is required"},
+		"no machines":    {config: `campaigns: [{name: a}]`, want: "machines or selector is required"},
+		"bad mac":        {config: `campaigns: [{name: a, machines: [nope]}]`, want: `invalid machine mac "nope"`},
+		"bad timeout":    {config: `campaigns: [{name: a, machines: ["3c:ec:ef:4c:4f:54"], timeout: soon}]`, want: `invalid timeout "soon"`},
+		"negative slots": {config: `campaigns: [{name: a, machines: ["3c:ec:ef:4c:4f:54"], concurrency: -1}]`, want: "concurrency must not be negative"},
+		"duplicate": {
+			config: `campaigns: [{name: a, machines: ["3c:ec:ef:4c:4f:54"]}, {name: a, machines: ["3c:ec:ef:4c:4f:55"]}]`,
+			want:   `duplicate name "a"`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error with %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package campaign
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// HandlerFunc returns a http.HandlerFunc that returns the progress of the campaigns. It is expected that the request
+// path is /campaigns, for every campaign, or /campaigns/<name>.
+// Only clients with a source IP in allowed are served.
+func (r *Runner) HandlerFunc(allowed []netip.Prefix) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !clientAllowed(req.RemoteAddr, allowed) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		st := r.Status()
+		name := strings.Trim(strings.TrimPrefix(req.URL.Path, "/campaigns"), "/")
+		if name == "" {
+			writeJSON(w, http.StatusOK, st)
+			return
+		}
+		for _, s := range st {
+			if s.Name == name {
+				writeJSON(w, http.StatusOK, s)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func clientAllowed(remoteAddr string, allowed []netip.Prefix) bool {
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, p := range allowed {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,84 @@
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kube prepares machines and resolves selectors with their Hardware objects.
+type Kube struct {
+	// Client is used to list and patch Hardware objects.
+	// It must have the kube.MACAddrIndex field index registered.
+	Client client.Client
+	// Namespace, when set, is the only namespace that selectors select Hardware objects in.
+	Namespace string
+}
+
+// MACs implements Lister, it returns the first DHCP MAC address of the Hardware objects with the labels of selector.
+func (k *Kube) MACs(ctx context.Context, selector map[string]string) ([]net.HardwareAddr, error) {
+	hl := &v1alpha1.HardwareList{}
+	opts := []client.ListOption{client.MatchingLabels(selector)}
+	if k.Namespace != "" {
+		opts = append(opts, client.InNamespace(k.Namespace))
+	}
+	if err := k.Client.List(ctx, hl, opts...); err != nil {
+		return nil, fmt.Errorf("failed listing hardware for the selector %v: %w", selector, err)
+	}
+	var macs []net.HardwareAddr
+	for _, hw := range hl.Items {
+		for _, iface := range hw.Spec.Interfaces {
+			if iface.DHCP == nil {
+				continue
+			}
+			if mac, err := net.ParseMAC(iface.DHCP.MAC); err == nil {
+				macs = append(macs, mac)
+				break
+			}
+		}
+	}
+
+	return macs, nil
+}
+
+// Prepare implements Preparer, it sets allowPXE to true on the interface of mac and, when profile is set, the boot
+// profile annotation of its Hardware object.
+func (k *Kube) Prepare(ctx context.Context, mac net.HardwareAddr, profile string) error {
+	hl := &v1alpha1.HardwareList{}
+	if err := k.Client.List(ctx, hl, &client.MatchingFields{kube.MACAddrIndex: mac.String()}); err != nil {
+		return fmt.Errorf("failed listing hardware for (%v): %w", mac, err)
+	}
+	if len(hl.Items) != 1 {
+		return fmt.Errorf("got %d hardware objects for mac %s, expected only 1", len(hl.Items), mac)
+	}
+	hw := &hl.Items[0]
+	patch := client.MergeFrom(hw.DeepCopy())
+	allow := true
+	for i, iface := range hw.Spec.Interfaces {
+		if iface.DHCP == nil {
+			continue
+		}
+		if m, err := net.ParseMAC(iface.DHCP.MAC); err != nil || m.String() != mac.String() {
+			continue
+		}
+		if iface.Netboot == nil {
+			hw.Spec.Interfaces[i].Netboot = &v1alpha1.Netboot{}
+		}
+		hw.Spec.Interfaces[i].Netboot.AllowPXE = &allow
+	}
+	if profile != "" {
+		if hw.Annotations == nil {
+			hw.Annotations = map[string]string{}
+		}
+		hw.Annotations[kube.ProfileAnnotation] = profile
+	}
+	if err := k.Client.Patch(ctx, hw, patch); err != nil {
+		return fmt.Errorf("failed patching hardware %s/%s: %w", hw.Namespace, hw.Name, err)
+	}
+
+	return nil
+}
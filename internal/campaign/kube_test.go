@@ -0,0 +1,70 @@
+package campaign
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	rs := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+
+	return fake.NewClientBuilder().WithScheme(rs).WithObjects(objs...).WithIndex(&v1alpha1.Hardware{}, kube.MACAddrIndex, kube.MACAddrs).Build()
+}
+
+func hardware(name, mac string, labels map[string]string) *v1alpha1.Hardware {
+	return &v1alpha1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tink-system", Labels: labels},
+		Spec: v1alpha1.HardwareSpec{
+			Interfaces: []v1alpha1.Interface{{DHCP: &v1alpha1.DHCP{MAC: mac}}},
+		},
+	}
+}
+
+func TestKubeMACs(t *testing.T) {
+	k := &Kube{Client: newClient(t,
+		hardware("machine1", "3c:ec:ef:4c:4f:54", map[string]string{"rack": "a"}),
+		hardware("machine2", "3c:ec:ef:4c:4f:55", map[string]string{"rack": "b"}),
+	)}
+	macs, err := k.MACs(context.Background(), map[string]string{"rack": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(macs) != 1 || macs[0].String() != "3c:ec:ef:4c:4f:54" {
+		t.Fatalf("expected the machine of rack a, got %v", macs)
+	}
+}
+
+func TestKubePrepare(t *testing.T) {
+	cl := newClient(t, hardware("machine1", "3c:ec:ef:4c:4f:54", nil))
+	k := &Kube{Client: cl}
+	if err := k.Prepare(context.Background(), net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}, "burnin"); err != nil {
+		t.Fatal(err)
+	}
+	hw := &v1alpha1.Hardware{}
+	if err := cl.Get(context.Background(), client.ObjectKey{Namespace: "tink-system", Name: "machine1"}, hw); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{kube.ProfileAnnotation: "burnin"}, hw.Annotations); diff != "" {
+		t.Error(diff)
+	}
+	if n := hw.Spec.Interfaces[0].Netboot; n == nil || n.AllowPXE == nil || !*n.AllowPXE {
+		t.Errorf("expected allowPXE to be true, got %+v", n)
+	}
+
+	if err := k.Prepare(context.Background(), net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x55}, ""); err == nil {
+		t.Error("expected an error for a machine without hardware")
+	}
+}
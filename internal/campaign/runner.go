@@ -0,0 +1,421 @@
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/bmc"
+)
+
+// DefaultInterval is the default interval between two steps of the campaigns.
+const DefaultInterval = 10 * time.Second
+
+// State is the state of a machine in a campaign.
+type State string
+
+// States of a machine.
+const (
+	// Pending machines wait for their campaign to start, or for a free slot.
+	Pending State = "pending"
+	// Netbooting machines were power cycled into a netboot and are not served their iPXE script yet.
+	Netbooting State = "netbooting"
+	// Booting machines were served their iPXE script and have not phoned home yet.
+	Booting State = "booting"
+	// Completed machines phoned home, or were served their iPXE script when phone home is disabled.
+	Completed State = "completed"
+	// Failed machines could not be prepared or netbooted, or timed out.
+	Failed State = "failed"
+)
+
+func (s State) done() bool {
+	return s == Completed || s == Failed
+}
+
+// Machine is the progress of a machine in a campaign.
+type Machine struct {
+	MAC   string `json:"mac"`
+	State State  `json:"state"`
+	// Job is the namespace/name of the Rufio Job that netbooted the machine.
+	Job        string     `json:"job,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Error is why the machine failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Status is the progress of a campaign.
+type Status struct {
+	Name    string    `json:"name"`
+	Profile string    `json:"profile,omitempty"`
+	Start   time.Time `json:"start"`
+	// Started is whether the machines of the campaign were resolved and the campaign runs.
+	Started bool `json:"started"`
+	// Counts are the number of machines per state.
+	Counts   map[State]int `json:"counts"`
+	Machines []Machine     `json:"machines"`
+}
+
+// Preparer prepares a machine to be reprovisioned with a boot profile.
+type Preparer interface {
+	Prepare(ctx context.Context, mac net.HardwareAddr, profile string) error
+}
+
+// Netbooter power cycles a machine into a netboot, like bmc.Orchestrator.
+type Netbooter interface {
+	Netboot(ctx context.Context, mac net.HardwareAddr) (bmc.Session, error)
+}
+
+// Lister lists the MAC addresses of the machines whose Hardware objects have the labels of a selector.
+type Lister interface {
+	MACs(ctx context.Context, selector map[string]string) ([]net.HardwareAddr, error)
+}
+
+// Runner runs the campaigns of Config. It implements script.Observer and phonehome.Observer to track the progress of
+// the machines.
+type Runner struct {
+	Config    *Config
+	Preparer  Preparer
+	Netbooter Netbooter
+	// Lister resolves the selectors of the campaigns, it is required when a campaign has a selector.
+	Lister Lister
+	// PhoneHome is whether machines complete when they phone home. They complete when they are served their iPXE
+	// script otherwise.
+	PhoneHome bool
+	// StateFile, when set, is the file that the progress of the machines is loaded from and saved to, so that a
+	// restart doesn't reprovision the machines again.
+	StateFile string
+	// Interval between two steps of the campaigns, DefaultInterval when 0.
+	Interval time.Duration
+	Log      logr.Logger
+
+	mu sync.Mutex
+	// machines are the machines of each campaign, by campaign name, in the order they are reprovisioned.
+	machines map[string][]*Machine
+	// started are the campaigns whose machines were resolved.
+	started map[string]bool
+	// finished are the campaigns whose every machine is done.
+	finished map[string]bool
+	dirty    bool
+	now      func() time.Time
+}
+
+// state is the content of the state file of a Runner.
+type state struct {
+	Campaigns map[string][]*Machine `json:"campaigns"`
+}
+
+// Run runs the campaigns until ctx is done, it saves their progress to the state file every step.
+func (r *Runner) Run(ctx context.Context) error {
+	if err := r.load(); err != nil {
+		return fmt.Errorf("failed to load the campaign state: %w", err)
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		r.step(ctx)
+		if err := r.save(); err != nil {
+			r.Log.Info("unable to save the campaign state", "file", r.StateFile, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// step starts the campaigns whose start time has passed, fails the machines that timed out, and reprovisions the
+// pending machines that the concurrency of their campaign has room for.
+func (r *Runner) step(ctx context.Context) {
+	for i := range r.Config.Campaigns {
+		c := &r.Config.Campaigns[i]
+		if r.clock().Before(c.Start) {
+			continue
+		}
+		if !r.isStarted(c.Name) {
+			if err := r.start(ctx, c); err != nil {
+				r.Log.Info("unable to start the campaign, retrying", "campaign", c.Name, "error", err)
+				continue
+			}
+		}
+		// the machines that fail to be reprovisioned free their slot right away.
+		for next := r.next(c); len(next) > 0; next = r.next(c) {
+			for _, m := range next {
+				r.reprovision(ctx, c, m)
+			}
+		}
+		r.finish(c)
+	}
+}
+
+// start resolves the machines of c, the machines that are already known from the state file keep their progress.
+func (r *Runner) start(ctx context.Context, c *Campaign) error {
+	macs := append([]net.HardwareAddr{}, c.macs...)
+	if len(c.Selector) > 0 {
+		if r.Lister == nil {
+			return errors.New("the campaign has a selector and there is no backend to resolve it")
+		}
+		selected, err := r.Lister.MACs(ctx, c.Selector)
+		if err != nil {
+			return err
+		}
+		macs = append(macs, selected...)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.machines == nil {
+		r.machines = map[string][]*Machine{}
+	}
+	known := map[string]bool{}
+	for _, m := range r.machines[c.Name] {
+		known[m.MAC] = true
+	}
+	for _, mac := range macs {
+		if !known[mac.String()] {
+			known[mac.String()] = true
+			r.machines[c.Name] = append(r.machines[c.Name], &Machine{MAC: mac.String(), State: Pending})
+		}
+	}
+	if r.started == nil {
+		r.started = map[string]bool{}
+	}
+	r.started[c.Name] = true
+	r.dirty = true
+	r.Log.Info("campaign started", "campaign", c.Name, "machines", len(r.machines[c.Name]), "profile", c.Profile, "concurrency", c.concurrency())
+
+	return nil
+}
+
+// next fails the machines of c that timed out and returns the pending machines that its concurrency has room for,
+// they are marked as netbooting.
+func (r *Runner) next(c *Campaign) []*Machine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock()
+	active := 0
+	for _, m := range r.machines[c.Name] {
+		if m.State != Netbooting && m.State != Booting {
+			continue
+		}
+		if m.StartedAt != nil && now.Sub(*m.StartedAt) > c.timeout {
+			r.fail(c, m, fmt.Errorf("timed out after %v while %s", c.timeout, m.State))
+			continue
+		}
+		active++
+	}
+	var next []*Machine
+	for _, m := range r.machines[c.Name] {
+		if active >= c.concurrency() {
+			break
+		}
+		if m.State == Pending {
+			m.State = Netbooting
+			m.StartedAt = &now
+			r.dirty = true
+			next = append(next, m)
+			active++
+		}
+	}
+
+	return next
+}
+
+// reprovision prepares and netboots the machine m of c.
+func (r *Runner) reprovision(ctx context.Context, c *Campaign, m *Machine) {
+	mac, err := net.ParseMAC(m.MAC)
+	if err == nil {
+		err = r.Preparer.Prepare(ctx, mac, c.Profile)
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.fail(c, m, fmt.Errorf("failed to prepare the machine: %w", err))
+		r.mu.Unlock()
+		return
+	}
+	s, err := r.Netbooter.Netboot(ctx, mac)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.fail(c, m, fmt.Errorf("failed to netboot the machine: %w", err))
+		return
+	}
+	m.Job = s.Job
+	r.dirty = true
+	r.Log.Info("machine netbooted", "campaign", c.Name, "mac", m.MAC, "job", s.Job)
+}
+
+// fail marks m as failed, r.mu must be held.
+func (r *Runner) fail(c *Campaign, m *Machine, err error) {
+	now := r.clock()
+	m.State = Failed
+	m.FinishedAt = &now
+	m.Error = err.Error()
+	r.dirty = true
+	r.Log.Info("machine failed", "campaign", c.Name, "mac", m.MAC, "error", err)
+}
+
+// finish logs when every machine of c is done, once.
+func (r *Runner) finish(c *Campaign) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished[c.Name] {
+		return
+	}
+	counts := map[State]int{}
+	for _, m := range r.machines[c.Name] {
+		if !m.State.done() {
+			return
+		}
+		counts[m.State]++
+	}
+	if r.finished == nil {
+		r.finished = map[string]bool{}
+	}
+	r.finished[c.Name] = true
+	r.Log.Info("campaign finished", "campaign", c.Name, "completed", counts[Completed], "failed", counts[Failed])
+}
+
+// ScriptServed implements script.Observer, a netbooting machine is booting, or completed when phone home is disabled.
+func (r *Runner) ScriptServed(_ context.Context, mac net.HardwareAddr, _ string) {
+	r.transition(mac, Netbooting, Booting)
+}
+
+// BootCompleted implements phonehome.Observer, a netbooting or booting machine is completed.
+func (r *Runner) BootCompleted(_ context.Context, mac net.HardwareAddr) {
+	r.transition(mac, Netbooting, Completed)
+	r.transition(mac, Booting, Completed)
+}
+
+// transition moves the machine mac from one state to another in every campaign that it is in.
+func (r *Runner) transition(mac net.HardwareAddr, from, to State) {
+	if to == Booting && !r.PhoneHome {
+		to = Completed
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, machines := range r.machines {
+		for _, m := range machines {
+			if m.MAC != mac.String() || m.State != from {
+				continue
+			}
+			m.State = to
+			if to.done() {
+				now := r.clock()
+				m.FinishedAt = &now
+			}
+			r.dirty = true
+			r.Log.Info("machine progressed", "campaign", name, "mac", m.MAC, "state", to)
+		}
+	}
+}
+
+// Status returns the progress of the campaigns.
+func (r *Runner) Status() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var st []Status
+	for _, c := range r.Config.Campaigns {
+		s := Status{Name: c.Name, Profile: c.Profile, Start: c.Start, Started: r.started[c.Name], Counts: map[State]int{}, Machines: []Machine{}}
+		for _, m := range r.machines[c.Name] {
+			s.Counts[m.State]++
+			s.Machines = append(s.Machines, *m)
+		}
+		st = append(st, s)
+	}
+
+	return st
+}
+
+func (r *Runner) isStarted(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.started[name]
+}
+
+func (r *Runner) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+
+	return time.Now().UTC()
+}
+
+// load loads the progress of the machines from the state file, when it exists. The campaigns are started again, so
+// that the machines that were added to their selector since are reprovisioned too.
+func (r *Runner) load() error {
+	if r.StateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(r.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s := state{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.machines = s.Campaigns
+
+	return nil
+}
+
+// save writes the progress of the machines to the state file when it changed. The file is replaced atomically so
+// that a crash never leaves a partial file.
+func (r *Runner) save() error {
+	if r.StateFile == "" {
+		return nil
+	}
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(state{Campaigns: r.machines})
+	r.dirty = false
+	r.mu.Unlock()
+	if err == nil {
+		err = writeFile(r.StateFile, b)
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+	}
+
+	return err
+}
+
+func writeFile(name string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".campaign-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,219 @@
+package campaign
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/bmc"
+)
+
+type machines struct {
+	mu        sync.Mutex
+	prepared  map[string]string
+	netbooted []string
+	fail      string
+}
+
+func (f *machines) Prepare(_ context.Context, mac net.HardwareAddr, profile string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if mac.String() == f.fail {
+		return errors.New("no hardware")
+	}
+	if f.prepared == nil {
+		f.prepared = map[string]string{}
+	}
+	f.prepared[mac.String()] = profile
+	return nil
+}
+
+func (f *machines) Netboot(_ context.Context, mac net.HardwareAddr) (bmc.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.netbooted = append(f.netbooted, mac.String())
+	return bmc.Session{MAC: mac.String(), Job: "tink-system/" + mac.String()}, nil
+}
+
+func (f *machines) MACs(context.Context, map[string]string) ([]net.HardwareAddr, error) {
+	return []net.HardwareAddr{{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x56}}, nil
+}
+
+func parse(t *testing.T, config string) *Config {
+	t.Helper()
+	c, err := Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// states returns the state of each machine of the campaign name.
+func states(r *Runner, name string) map[string]State {
+	got := map[string]State{}
+	for _, s := range r.Status() {
+		if s.Name != name {
+			continue
+		}
+		for _, m := range s.Machines {
+			got[m.MAC] = m.State
+		}
+	}
+	return got
+}
+
+func TestRunner(t *testing.T) {
+	now := time.Date(2026, 11, 1, 1, 0, 0, 0, time.UTC)
+	f := &machines{fail: "3c:ec:ef:4c:4f:55"}
+	r := &Runner{
+		Config: parse(t, `
+campaigns:
+- name: rack-a
+  machines: ["3c:ec:ef:4c:4f:54", "3c:ec:ef:4c:4f:55"]
+  selector: {rack: a}
+  profile: burnin
+  start: 2026-11-01T02:00:00Z
+  concurrency: 2
+  timeout: 1h
+`),
+		Preparer:  f,
+		Netbooter: f,
+		Lister:    f,
+		PhoneHome: true,
+		Log:       logr.Discard(),
+		now:       func() time.Time { return now },
+	}
+	ctx := context.Background()
+	a, b, c := net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}, net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x55}, net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x56}
+
+	// before the start time nothing happens.
+	r.step(ctx)
+	if st := r.Status(); st[0].Started || len(st[0].Machines) != 0 {
+		t.Fatalf("expected the campaign not to start before its start time, got %+v", st[0])
+	}
+
+	// the machines that the concurrency has room for are reprovisioned, a machine that fails frees its slot.
+	now = now.Add(time.Hour)
+	r.step(ctx)
+	want := map[string]State{a.String(): Netbooting, b.String(): Failed, c.String(): Netbooting}
+	if diff := cmp.Diff(want, states(r, "rack-a")); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(map[string]string{a.String(): "burnin", c.String(): "burnin"}, f.prepared); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// a served script boots the machine, a phone home completes it, and the other machine times out.
+	r.ScriptServed(ctx, a, "auto.ipxe")
+	if got := states(r, "rack-a")[a.String()]; got != Booting {
+		t.Fatalf("expected the machine to boot, got %v", got)
+	}
+	r.BootCompleted(ctx, a)
+	now = now.Add(2 * time.Hour)
+	r.step(ctx)
+	want = map[string]State{a.String(): Completed, b.String(): Failed, c.String(): Failed}
+	if diff := cmp.Diff(want, states(r, "rack-a")); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := r.Status()[0].Counts; got[Completed] != 1 || got[Failed] != 2 {
+		t.Fatalf("unexpected counts %v", got)
+	}
+}
+
+func TestRunnerConcurrency(t *testing.T) {
+	f := &machines{}
+	r := &Runner{
+		Config:    parse(t, `campaigns: [{name: a, machines: ["3c:ec:ef:4c:4f:54", "3c:ec:ef:4c:4f:55"]}]`),
+		Preparer:  f,
+		Netbooter: f,
+		Log:       logr.Discard(),
+	}
+	ctx := context.Background()
+	r.step(ctx)
+	if diff := cmp.Diff([]string{"3c:ec:ef:4c:4f:54"}, f.netbooted); diff != "" {
+		t.Fatal(diff)
+	}
+	// without phone home, the served script completes the machine and the next one starts.
+	r.ScriptServed(ctx, net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}, "auto.ipxe")
+	r.step(ctx)
+	if diff := cmp.Diff([]string{"3c:ec:ef:4c:4f:54", "3c:ec:ef:4c:4f:55"}, f.netbooted); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := states(r, "a")["3c:ec:ef:4c:4f:54"]; got != Completed {
+		t.Fatalf("expected the machine to complete, got %v", got)
+	}
+}
+
+func TestRunnerStateFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "campaigns.json")
+	config := `campaigns: [{name: a, machines: ["3c:ec:ef:4c:4f:54"]}]`
+	f := &machines{}
+	r := &Runner{Config: parse(t, config), Preparer: f, Netbooter: f, StateFile: file, Log: logr.Discard()}
+	ctx := context.Background()
+	r.step(ctx)
+	r.ScriptServed(ctx, net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}, "auto.ipxe")
+	if err := r.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted runner doesn't reprovision the completed machine again.
+	f = &machines{}
+	r = &Runner{Config: parse(t, config), Preparer: f, Netbooter: f, StateFile: file, Log: logr.Discard()}
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+	r.step(ctx)
+	if len(f.netbooted) != 0 {
+		t.Fatalf("expected no netboot, got %v", f.netbooted)
+	}
+	if got := states(r, "a")["3c:ec:ef:4c:4f:54"]; got != Completed {
+		t.Fatalf("expected the machine to stay completed, got %v", got)
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+	f := &machines{}
+	r := &Runner{Config: parse(t, `campaigns: [{name: a, machines: ["3c:ec:ef:4c:4f:54"]}]`), Preparer: f, Netbooter: f, Log: logr.Discard()}
+	r.step(context.Background())
+	h := r.HandlerFunc([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")})
+	tests := map[string]struct {
+		path       string
+		remoteAddr string
+		want       int
+	}{
+		"all":       {path: "/campaigns", remoteAddr: "127.0.0.1:1234", want: http.StatusOK},
+		"one":       {path: "/campaigns/a", remoteAddr: "127.0.0.1:1234", want: http.StatusOK},
+		"unknown":   {path: "/campaigns/b", remoteAddr: "127.0.0.1:1234", want: http.StatusNotFound},
+		"forbidden": {path: "/campaigns", remoteAddr: "10.1.1.1:1234", want: http.StatusForbidden},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			h(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, w.Code)
+			}
+			if name == "one" {
+				var s Status
+				if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+					t.Fatal(err)
+				}
+				if s.Counts[Netbooting] != 1 {
+					t.Fatalf("expected a netbooting machine, got %+v", s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Package chaos injects faults into the responses of Smee, so that provisioning pipelines can be tested for resilience
+// to realistic network failures. Fault injection is opt-in, no faults are injected until they are set with the admin API.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Faults are the faults that are injected. The zero value injects no faults.
+type Faults struct {
+	// DHCPDropPercent is the percentage, 0 to 100, of DHCP replies that are dropped.
+	DHCPDropPercent int
+	// ScriptDelay delays the iPXE script responses.
+	ScriptDelay time.Duration
+	// ISOCorruptBytes is the number of bytes of every ISO response that are corrupted, at random offsets.
+	ISOCorruptBytes int
+}
+
+// Validate returns an error when the faults are out of range.
+func (f Faults) Validate() error {
+	var errs []error
+	if f.DHCPDropPercent < 0 || f.DHCPDropPercent > 100 {
+		errs = append(errs, fmt.Errorf("dhcp drop percent %d is not between 0 and 100", f.DHCPDropPercent))
+	}
+	if f.ScriptDelay < 0 {
+		errs = append(errs, fmt.Errorf("script delay %s is negative", f.ScriptDelay))
+	}
+	if f.ISOCorruptBytes < 0 {
+		errs = append(errs, fmt.Errorf("iso corrupt bytes %d is negative", f.ISOCorruptBytes))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Injector injects the faults that are set. It is safe for concurrent use.
+// A nil Injector, or one without faults, injects no faults.
+type Injector struct {
+	mu     sync.RWMutex
+	faults Faults
+}
+
+// Set replaces the faults that are injected.
+func (i *Injector) Set(f Faults) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.faults = f
+
+	return nil
+}
+
+// Get returns the faults that are injected.
+func (i *Injector) Get() Faults {
+	if i == nil {
+		return Faults{}
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.faults
+}
+
+// DropDHCP returns whether a DHCP reply is dropped.
+func (i *Injector) DropDHCP() bool {
+	p := i.Get().DHCPDropPercent
+
+	return p > 0 && rand.IntN(100) < p //nolint:gosec // fault injection doesn't need a secure random number.
+}
+
+// DelayScript returns next with its responses delayed by the script delay.
+func (i *Injector) DelayScript(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d := i.Get().ScriptDelay; d > 0 {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-t.C:
+			}
+		}
+		next(w, r)
+	}
+}
+
+// CorruptISO returns next with bytes of its successful responses corrupted.
+func (i *Injector) CorruptISO(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n := i.Get().ISOCorruptBytes; n > 0 {
+			w = &corrupter{ResponseWriter: w, n: n}
+		}
+		next(w, r)
+	}
+}
+
+// corrupter inverts n bytes of a response body, at random offsets. The offsets are chosen in the Content-Length
+// of the response, or in the first write when it has none.
+type corrupter struct {
+	http.ResponseWriter
+	n       int
+	status  int
+	offsets map[int64]bool
+	written int64
+}
+
+func (c *corrupter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *corrupter) Write(b []byte) (int, error) {
+	if c.status != 0 && c.status != http.StatusOK && c.status != http.StatusPartialContent {
+		return c.ResponseWriter.Write(b)
+	}
+	if c.offsets == nil {
+		size := int64(len(b))
+		if cl, err := strconv.ParseInt(c.Header().Get("Content-Length"), 10, 64); err == nil && cl > 0 {
+			size = cl
+		}
+		c.offsets = offsets(c.n, size)
+	}
+	var out []byte
+	for j := range b {
+		if !c.offsets[c.written+int64(j)] {
+			continue
+		}
+		if out == nil {
+			out = make([]byte, len(b))
+			copy(out, b)
+		}
+		out[j] ^= 0xff
+	}
+	c.written += int64(len(b))
+	if out == nil {
+		return c.ResponseWriter.Write(b)
+	}
+
+	return c.ResponseWriter.Write(out)
+}
+
+// Unwrap returns the ResponseWriter, for http.ResponseController.
+func (c *corrupter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// offsets returns n distinct random offsets in [0, size), all of them when n is size or more.
+func offsets(n int, size int64) map[int64]bool {
+	res := map[int64]bool{}
+	if int64(n) >= size {
+		for o := range size {
+			res[o] = true
+		}
+		return res
+	}
+	for len(res) < n {
+		res[rand.Int64N(size)] = true //nolint:gosec // fault injection doesn't need a secure random number.
+	}
+
+	return res
+}
@@ -0,0 +1,143 @@
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSet(t *testing.T) {
+	tests := map[string]struct {
+		faults  Faults
+		wantErr bool
+	}{
+		"none":             {},
+		"all":              {faults: Faults{DHCPDropPercent: 100, ScriptDelay: time.Second, ISOCorruptBytes: 16}},
+		"drop over 100":    {faults: Faults{DHCPDropPercent: 101}, wantErr: true},
+		"negative drop":    {faults: Faults{DHCPDropPercent: -1}, wantErr: true},
+		"negative delay":   {faults: Faults{ScriptDelay: -time.Second}, wantErr: true},
+		"negative corrupt": {faults: Faults{ISOCorruptBytes: -1}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			i := &Injector{}
+			err := i.Set(tt.faults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := tt.faults
+			if tt.wantErr {
+				want = Faults{}
+			}
+			if got := i.Get(); got != want {
+				t.Fatalf("Get() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDropDHCP(t *testing.T) {
+	var nilInjector *Injector
+	if nilInjector.DropDHCP() {
+		t.Fatal("a nil injector dropped a DHCP reply")
+	}
+	i := &Injector{}
+	if i.DropDHCP() {
+		t.Fatal("an injector without faults dropped a DHCP reply")
+	}
+	if err := i.Set(Faults{DHCPDropPercent: 100}); err != nil {
+		t.Fatal(err)
+	}
+	for range 100 {
+		if !i.DropDHCP() {
+			t.Fatal("an injector dropping 100% didn't drop a DHCP reply")
+		}
+	}
+}
+
+func TestDelayScript(t *testing.T) {
+	i := &Injector{}
+	if err := i.Set(Faults{ScriptDelay: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	h := i.DelayScript(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("#!ipxe")) })
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/auto.ipxe", nil))
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("the response was delayed by %s, want at least 50ms", d)
+	}
+	if w.Body.String() != "#!ipxe" {
+		t.Fatalf("body = %q, want %q", w.Body.String(), "#!ipxe")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/auto.ipxe", nil).WithContext(ctx))
+	if w.Body.Len() != 0 {
+		t.Fatal("the script was served to a canceled request")
+	}
+}
+
+func TestCorruptISO(t *testing.T) {
+	body := bytes.Repeat([]byte{0x55}, 4096)
+	tests := map[string]struct {
+		corrupt       int
+		status        int
+		contentLength bool
+		want          int
+	}{
+		"no faults":              {want: 0},
+		"corrupt":                {corrupt: 16, contentLength: true, want: 16},
+		"without content length": {corrupt: 16, want: 16},
+		"more than the body":     {corrupt: 10000, contentLength: true, want: len(body)},
+		"partial content":        {corrupt: 8, status: http.StatusPartialContent, contentLength: true, want: 8},
+		"not found":              {corrupt: 16, status: http.StatusNotFound, want: 0},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			i := &Injector{}
+			if err := i.Set(Faults{ISOCorruptBytes: tt.corrupt}); err != nil {
+				t.Fatal(err)
+			}
+			src := bytes.Clone(body)
+			h := i.CorruptISO(func(w http.ResponseWriter, _ *http.Request) {
+				if tt.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(src)))
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				// write in chunks, like a streamed ISO.
+				for j := 0; j < len(src); j += 1000 {
+					w.Write(src[j:min(j+1000, len(src))])
+				}
+			})
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(http.MethodGet, "/iso/hook.iso", nil))
+
+			if !bytes.Equal(src, body) {
+				t.Fatal("the buffers written by the handler were modified")
+			}
+			got := w.Body.Bytes()
+			if len(got) != len(body) {
+				t.Fatalf("len(body) = %d, want %d", len(got), len(body))
+			}
+			corrupted := 0
+			for j := range got {
+				if got[j] != body[j] {
+					corrupted++
+				}
+			}
+			if corrupted != tt.want {
+				t.Fatalf("corrupted %d bytes, want %d", corrupted, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+// Package cluster shares the state of Smee between its replicas, when they don't share a Kubernetes cluster, over a
+// gossip protocol (hashicorp/memberlist). A machine can be served DHCP by one replica and fetch its iPXE script from
+// another, so the replicas share:
+//
+//   - the IP addresses that DHCP leased to machines, and that machines were seen using, the discovery records that
+//     attribute the HTTP and syslog requests of a machine to its MAC address.
+//   - the flushes of the caches with the admin API, so that every replica reloads its backend.
+//
+// A replica that joins the cluster gets the state of a member, and changes are gossiped to every member from then on.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/memberlist"
+	"github.com/tinkerbell/smee/internal/dhcp/lease"
+	"github.com/tinkerbell/smee/internal/ipmac"
+)
+
+// DefaultPort is the default port of the gossip protocol, TCP and UDP.
+const DefaultPort = 7946
+
+// joinRetryInterval is the time between two attempts to join the peers, when none of them answered.
+const joinRetryInterval = 10 * time.Second
+
+// Types of the messages that are gossiped.
+const (
+	typeLease   = "lease"
+	typeAddress = "address"
+	typeFlush   = "flush"
+)
+
+// Flusher flushes the named caches of a replica.
+type Flusher interface {
+	Names() []string
+	Flush(names ...string) ([]string, error)
+}
+
+// Cluster is the membership of a replica in the cluster. It implements handler.LeaseObserver and
+// handler.AddressObserver to gossip the leases and addresses of the DHCP server of the replica.
+type Cluster struct {
+	// Name is the unique name of the replica in the cluster, the hostname when empty.
+	Name string
+	// BindAddr and BindPort are the address the gossip protocol listens on.
+	BindAddr string
+	BindPort int
+	// AdvertiseAddr, when set, is the IP address that the other replicas reach this replica on.
+	AdvertiseAddr string
+	// Peers are the host:port addresses of the replicas to join, it can include this replica.
+	Peers []string
+	// Key, when set, encrypts the gossip, it must be 16, 24 or 32 bytes. Every replica must use the same key.
+	Key []byte
+	// Leases and Addresses, when set, are merged with the leases and addresses of the other replicas.
+	Leases    *lease.Table
+	Addresses *ipmac.Cache
+	// Caches, when set, are flushed when they are flushed on another replica.
+	Caches Flusher
+	Log    logr.Logger
+
+	mu    sync.Mutex
+	ml    *memberlist.Memberlist
+	queue *memberlist.TransmitLimitedQueue
+}
+
+// record is a lease or an address.
+type record struct {
+	IP      netip.Addr `json:"ip"`
+	MAC     string     `json:"mac"`
+	Expires time.Time  `json:"expires"`
+}
+
+// message is a change that is gossiped to the other replicas.
+type message struct {
+	Type   string   `json:"type"`
+	Record *record  `json:"record,omitempty"`
+	Caches []string `json:"caches,omitempty"`
+}
+
+// state is the state of a replica that is sent to a replica that joins the cluster, and periodically exchanged.
+type state struct {
+	Leases    []record `json:"leases"`
+	Addresses []record `json:"addresses"`
+}
+
+// Run joins the cluster and gossips until ctx is done, then it leaves the cluster. The peers are joined again until
+// one of them answers, so that the replicas can start in any order.
+func (c *Cluster) Run(ctx context.Context) error {
+	conf := memberlist.DefaultLANConfig()
+	if c.Name != "" {
+		conf.Name = c.Name
+	}
+	conf.BindAddr, conf.BindPort = c.BindAddr, c.BindPort
+	if c.AdvertiseAddr != "" {
+		conf.AdvertiseAddr, conf.AdvertisePort = c.AdvertiseAddr, c.BindPort
+	}
+	conf.SecretKey = c.Key
+	conf.Delegate = &delegate{c}
+	conf.Events = &events{c}
+	conf.Logger = log.New(&logWriter{log: c.Log}, "", 0)
+	ml, err := memberlist.Create(conf)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.ml = ml
+	c.mu.Unlock()
+	defer func() {
+		if err := ml.Leave(time.Second); err != nil {
+			c.Log.Info("unable to leave the cluster", "error", err)
+		}
+		_ = ml.Shutdown()
+	}()
+
+	for len(c.Peers) > 0 {
+		n, err := ml.Join(c.Peers)
+		if err == nil || n > 0 {
+			c.Log.Info("joined the cluster", "peers", c.Peers, "members", ml.NumMembers())
+			break
+		}
+		c.Log.Info("unable to join the cluster, retrying", "peers", c.Peers, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(joinRetryInterval):
+		}
+	}
+	<-ctx.Done()
+
+	return nil
+}
+
+// Members returns the names of the members of the cluster, sorted, with this replica.
+func (c *Cluster) Members() []string {
+	ml := c.memberlist()
+	if ml == nil {
+		return nil
+	}
+	var names []string
+	for _, n := range ml.Members() {
+		names = append(names, n.Name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// DHCPServed implements handler.Observer.
+func (c *Cluster) DHCPServed(context.Context, net.HardwareAddr, string) {}
+
+// DHCPLeased implements handler.LeaseObserver, it gossips the lease.
+func (c *Cluster) DHCPLeased(_ context.Context, mac net.HardwareAddr, ip net.IP, leaseTime time.Duration) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok || c.Leases == nil {
+		return
+	}
+	if leaseTime <= 0 {
+		leaseTime = lease.DefaultLeaseTime
+	}
+	c.broadcast(message{Type: typeLease, Record: &record{IP: addr.Unmap(), MAC: mac.String(), Expires: time.Now().Add(leaseTime)}})
+}
+
+// DHCPAddressSeen implements handler.AddressObserver, it gossips the address.
+func (c *Cluster) DHCPAddressSeen(_ context.Context, mac net.HardwareAddr, ip net.IP) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok || c.Addresses == nil || len(mac) == 0 || addr.IsUnspecified() {
+		return
+	}
+	ttl := c.Addresses.TTL
+	if ttl <= 0 {
+		ttl = ipmac.DefaultTTL
+	}
+	c.broadcast(message{Type: typeAddress, Record: &record{IP: addr.Unmap(), MAC: mac.String(), Expires: time.Now().Add(ttl)}})
+}
+
+// FlushCaches gossips the names of the caches that were flushed, the other replicas flush the ones they have.
+func (c *Cluster) FlushCaches(names []string) {
+	if len(names) > 0 {
+		c.broadcast(message{Type: typeFlush, Caches: names})
+	}
+}
+
+func (c *Cluster) memberlist() *memberlist.Memberlist {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ml
+}
+
+// broadcast queues m to be gossiped to the other replicas, it is dropped when the cluster wasn't joined.
+func (c *Cluster) broadcast(m message) {
+	ml := c.memberlist()
+	if ml == nil {
+		return
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		c.Log.Info("unable to encode a cluster message", "type", m.Type, "error", err)
+		return
+	}
+	c.broadcasts().QueueBroadcast(broadcast(b))
+}
+
+func (c *Cluster) broadcasts() *memberlist.TransmitLimitedQueue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queue == nil {
+		c.queue = &memberlist.TransmitLimitedQueue{NumNodes: c.numMembers, RetransmitMult: 3}
+	}
+
+	return c.queue
+}
+
+func (c *Cluster) numMembers() int {
+	if ml := c.memberlist(); ml != nil {
+		return ml.NumMembers()
+	}
+
+	return 1
+}
+
+// receive applies a message of another replica.
+func (c *Cluster) receive(m message) {
+	switch m.Type {
+	case typeLease:
+		if l, ok := m.Record.lease(); ok {
+			c.Leases.Merge(l)
+		}
+	case typeAddress:
+		if a, ok := m.Record.address(); ok {
+			c.Addresses.Merge(a)
+		}
+	case typeFlush:
+		if c.Caches == nil {
+			return
+		}
+		// the other replica can have caches that this one doesn't have, like the file backend.
+		var names []string
+		for _, n := range m.Caches {
+			if slices.Contains(c.Caches.Names(), n) {
+				names = append(names, n)
+			}
+		}
+		if len(names) == 0 {
+			return
+		}
+		flushed, err := c.Caches.Flush(names...)
+		if err != nil {
+			c.Log.Info("unable to flush the caches flushed by another replica", "caches", names, "error", err)
+		}
+		c.Log.Info("flushed the caches flushed by another replica", "caches", flushed)
+	}
+}
+
+func (r *record) lease() (lease.Lease, bool) {
+	if r == nil {
+		return lease.Lease{}, false
+	}
+	mac, err := net.ParseMAC(r.MAC)
+	if err != nil {
+		return lease.Lease{}, false
+	}
+
+	return lease.Lease{IP: r.IP, MAC: mac, Expires: r.Expires}, true
+}
+
+func (r *record) address() (ipmac.Address, bool) {
+	l, ok := r.lease()
+
+	return ipmac.Address{IP: l.IP, MAC: l.MAC, Expires: l.Expires}, ok
+}
+
+// delegate implements memberlist.Delegate for a Cluster.
+type delegate struct {
+	c *Cluster
+}
+
+func (d *delegate) NodeMeta(int) []byte { return nil }
+
+func (d *delegate) NotifyMsg(b []byte) {
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		d.c.Log.Info("unable to decode a cluster message", "error", err)
+		return
+	}
+	d.c.receive(m)
+}
+
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
+	return d.c.broadcasts().GetBroadcasts(overhead, limit)
+}
+
+func (d *delegate) LocalState(bool) []byte {
+	s := state{Leases: []record{}, Addresses: []record{}}
+	now := time.Now()
+	for _, l := range d.c.Leases.List() {
+		if l.Expires.After(now) {
+			s.Leases = append(s.Leases, record{IP: l.IP, MAC: l.MAC.String(), Expires: l.Expires})
+		}
+	}
+	for _, a := range d.c.Addresses.List() {
+		if a.Expires.After(now) {
+			s.Addresses = append(s.Addresses, record{IP: a.IP, MAC: a.MAC.String(), Expires: a.Expires})
+		}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		d.c.Log.Info("unable to encode the cluster state", "error", err)
+		return nil
+	}
+
+	return b
+}
+
+func (d *delegate) MergeRemoteState(buf []byte, _ bool) {
+	var s state
+	if err := json.Unmarshal(buf, &s); err != nil {
+		d.c.Log.Info("unable to decode the cluster state", "error", err)
+		return
+	}
+	for _, r := range s.Leases {
+		if l, ok := r.lease(); ok {
+			d.c.Leases.Merge(l)
+		}
+	}
+	for _, r := range s.Addresses {
+		if a, ok := r.address(); ok {
+			d.c.Addresses.Merge(a)
+		}
+	}
+}
+
+// events implements memberlist.EventDelegate for a Cluster.
+type events struct {
+	c *Cluster
+}
+
+func (e *events) NotifyJoin(n *memberlist.Node) {
+	e.c.Log.Info("replica joined the cluster", "name", n.Name, "addr", n.Address())
+}
+
+func (e *events) NotifyLeave(n *memberlist.Node) {
+	e.c.Log.Info("replica left the cluster", "name", n.Name, "addr", n.Address())
+}
+
+func (e *events) NotifyUpdate(*memberlist.Node) {}
+
+// broadcast implements memberlist.Broadcast, every message is gossiped on its own.
+type broadcast []byte
+
+func (b broadcast) Invalidates(memberlist.Broadcast) bool { return false }
+func (b broadcast) Message() []byte                       { return b }
+func (b broadcast) Finished()                             {}
+
+// logWriter writes the log lines of memberlist to a logr.Logger, the debug lines at verbosity 1.
+type logWriter struct {
+	log logr.Logger
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	line := string(bytes.TrimSpace(p))
+	if bytes.Contains(p, []byte("[DEBUG]")) || bytes.Contains(p, []byte("[INFO]")) {
+		w.log.V(1).Info(line)
+	} else {
+		w.log.Info(line)
+	}
+
+	return len(p), nil
+}
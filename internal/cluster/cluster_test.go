@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/lease"
+	"github.com/tinkerbell/smee/internal/ipmac"
+)
+
+type caches struct {
+	mu      sync.Mutex
+	flushed []string
+}
+
+func (c *caches) Names() []string { return []string{"machines"} }
+
+func (c *caches) Flush(names ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushed = append(c.flushed, names...)
+	return names, nil
+}
+
+func (c *caches) Flushed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.flushed)
+}
+
+// start runs c until the test ends and returns its host:port address once it is listening.
+func start(t *testing.T, c *Cluster) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if ml := c.memberlist(); ml != nil {
+			return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(ml.LocalNode().Port)))
+		}
+	}
+	t.Fatal("the cluster did not start")
+
+	return ""
+}
+
+// eventually fails the test when ok doesn't return true within a few seconds.
+func eventually(t *testing.T, msg string, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if ok() {
+			return
+		}
+	}
+	t.Fatal(msg)
+}
+
+func TestCluster(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	a := &Cluster{Name: "a", BindAddr: "127.0.0.1", Leases: &lease.Table{}, Addresses: &ipmac.Cache{}, Log: logr.Discard()}
+	addr := start(t, a)
+	// a lease of a before b joins is sent to b when it joins.
+	a.Leases.DHCPLeased(context.Background(), mac, net.IPv4(192, 168, 2, 153), time.Hour)
+
+	fc := &caches{}
+	b := &Cluster{Name: "b", BindAddr: "127.0.0.1", Peers: []string{addr}, Leases: &lease.Table{}, Addresses: &ipmac.Cache{}, Caches: fc, Log: logr.Discard()}
+	start(t, b)
+	eventually(t, "expected b to join a", func() bool { return slices.Equal(b.Members(), []string{"a", "b"}) })
+	eventually(t, "expected b to get the lease of a when it joined", func() bool {
+		got, ok := b.Leases.MAC(net.IPv4(192, 168, 2, 153))
+		return ok && got.String() == mac.String()
+	})
+
+	a.DHCPAddressSeen(context.Background(), mac, net.IPv4(192, 168, 2, 154))
+	eventually(t, "expected b to learn the address seen by a", func() bool {
+		got, ok := b.Addresses.MAC(net.IPv4(192, 168, 2, 154))
+		return ok && got.String() == mac.String()
+	})
+
+	a.FlushCaches([]string{"backend-file", "machines"})
+	eventually(t, "expected b to flush the caches that it has", func() bool { return slices.Equal(fc.Flushed(), []string{"machines"}) })
+}
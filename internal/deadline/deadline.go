@@ -0,0 +1,112 @@
+// Package deadline bounds the requests that Smee handles with deadlines, so that a stuck dependency, like a backend
+// that does not respond, fails the request instead of hanging it. The requests whose deadline is exceeded are counted
+// in the timeouts metric by stage.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// Backend returns br with a deadline of d for every lookup. A lookup that does not return by its deadline fails with
+// context.DeadlineExceeded, even when br does not honor the context.
+// br is returned when d is 0. The returned backend is a handler.BackendIdentityReader when br is one.
+func Backend(br handler.BackendReader, d time.Duration) handler.BackendReader {
+	if d <= 0 {
+		return br
+	}
+	b := &backend{reader: br, timeout: d}
+	if ir, ok := br.(handler.BackendIdentityReader); ok {
+		return &identityBackend{backend: b, identity: ir}
+	}
+
+	return b
+}
+
+type backend struct {
+	reader  handler.BackendReader
+	timeout time.Duration
+}
+
+func (b *backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup(ctx, func(ctx context.Context) (*data.DHCP, *data.Netboot, error) { return b.reader.GetByMac(ctx, mac) })
+}
+
+func (b *backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup(ctx, func(ctx context.Context) (*data.DHCP, *data.Netboot, error) { return b.reader.GetByIP(ctx, ip) })
+}
+
+type identityBackend struct {
+	*backend
+	identity handler.BackendIdentityReader
+}
+
+func (b *identityBackend) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup(ctx, func(ctx context.Context) (*data.DHCP, *data.Netboot, error) { return b.identity.GetByUUID(ctx, uuid) })
+}
+
+func (b *identityBackend) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup(ctx, func(ctx context.Context) (*data.DHCP, *data.Netboot, error) {
+		return b.identity.GetBySerial(ctx, serial)
+	})
+}
+
+func (b *identityBackend) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup(ctx, func(ctx context.Context) (*data.DHCP, *data.Netboot, error) {
+		return b.identity.GetByHostname(ctx, hostname)
+	})
+}
+
+type result struct {
+	d   *data.DHCP
+	n   *data.Netboot
+	err error
+}
+
+// lookup runs fn with the deadline of the backend and returns its result, or the error of the context when it is done
+// first. fn is left to return in the background then. Only the lookups that exceed the deadline of the backend, and
+// not that of their caller, are counted as backend timeouts.
+func (b *backend) lookup(parent context.Context, fn func(context.Context) (*data.DHCP, *data.Netboot, error)) (*data.DHCP, *data.Netboot, error) {
+	ctx, cancel := context.WithTimeout(parent, b.timeout)
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		d, n, err := fn(ctx)
+		done <- result{d: d, n: n, err: err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		r.err = ctx.Err()
+	}
+	if r.err != nil && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		metric.Timeouts.WithLabelValues("backend").Inc()
+	}
+
+	return r.d, r.n, r.err
+}
+
+// Handler returns next with a deadline of d for every request, the backend lookups and upstream requests of next
+// that use the context of the request fail when it is exceeded.
+// next is returned when d is 0. It is not meant for handlers that stream large files, like the OSIE or the ISO.
+func Handler(next http.HandlerFunc, d time.Duration) http.HandlerFunc {
+	if d <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metric.Timeouts.WithLabelValues("http").Inc()
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+// stuck is a backend whose lookups never return, it does not honor the context.
+type stuck struct{}
+
+func (stuck) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	select {}
+}
+
+func (stuck) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	select {}
+}
+
+type identity struct{ stuck }
+
+func (identity) GetByUUID(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return &data.DHCP{}, &data.Netboot{}, nil
+}
+
+func (identity) GetBySerial(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (identity) GetByHostname(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func timeouts(stage string) float64 {
+	return testutil.ToFloat64(metric.Timeouts.WithLabelValues(stage))
+}
+
+func TestBackend(t *testing.T) {
+	br := Backend(identity{}, 10*time.Millisecond)
+	before := timeouts("backend")
+	if _, _, err := br.GetByMac(context.Background(), net.HardwareAddr{0, 1, 2, 3, 4, 5}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline to be exceeded", err)
+	}
+	if got := timeouts("backend") - before; got != 1 {
+		t.Fatalf("got %v backend timeouts, want 1", got)
+	}
+
+	ir, ok := br.(handler.BackendIdentityReader)
+	if !ok {
+		t.Fatal("expected the backend to be an identity reader")
+	}
+	if _, _, err := ir.GetByUUID(context.Background(), "uuid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a lookup whose caller times out first is not a backend timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	before = timeouts("backend")
+	if _, _, err := Backend(stuck{}, time.Minute).GetByIP(ctx, net.IP{10, 0, 0, 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline to be exceeded", err)
+	}
+	if got := timeouts("backend") - before; got != 0 {
+		t.Fatalf("got %v backend timeouts, want 0", got)
+	}
+	if _, ok := Backend(stuck{}, time.Second).(handler.BackendIdentityReader); ok {
+		t.Fatal("expected the backend not to be an identity reader")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}, 10*time.Millisecond)
+	tests := map[string]struct {
+		path         string
+		wantCode     int
+		wantTimeouts float64
+	}{
+		"fast": {path: "/fast", wantCode: http.StatusOK},
+		"slow": {path: "/slow", wantCode: http.StatusGatewayTimeout, wantTimeouts: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			before := timeouts("http")
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status code %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := timeouts("http") - before; got != tt.wantTimeouts {
+				t.Fatalf("got %v http timeouts, want %v", got, tt.wantTimeouts)
+			}
+		})
+	}
+}
@@ -6,11 +6,19 @@ import (
 	"net/netip"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/ipv4"
 )
 
+// Conn sends the replies of a DHCP handler. It is implemented by *ipv4.PacketConn, for the UDP listeners, and by
+// the link layer listeners of the server package.
+type Conn interface {
+	WriteTo(b []byte, cm *ipv4.ControlMessage, dst net.Addr) (int, error)
+}
+
 // Packet holds the data that is passed to a DHCP handler.
 type Packet struct {
 	// Peer is the address of the client that sent the DHCP message.
@@ -53,9 +61,42 @@ type Netboot struct {
 	AllowNetboot  bool     // If true, the client will be provided netboot options in the DHCP offer/ack.
 	IPXEScriptURL *url.URL // Overrides a default value that is passed into DHCP on startup.
 	IPXEScript    string   // Overrides a default value that is passed into DHCP on startup.
-	Console       string
+	Console       string   // Kernel consoles of the client, space separated, for example "tty0 ttyS1,115200n8".
 	Facility      string
+	Profile       string // Name of the boot profile of the client, in place of the profile its DHCP classes match.
 	OSIE          OSIE
+	Labels        map[string]string // Labels of the backend record, used by netboot policies.
+	Instance      *Instance         // Instance provisioned on the client, served by the instance metadata endpoint.
+	// Validated is when the backend last confirmed the record with its source, for a backend that serves the record
+	// from a cache. It is the zero time for backends that read their source on every lookup.
+	Validated time.Time
+}
+
+// ConsoleArgs returns the Console of n as kernel args, a console without the console= prefix gets one.
+func (n *Netboot) ConsoleArgs() []string {
+	var args []string
+	for _, c := range strings.Fields(n.Console) {
+		if !strings.HasPrefix(c, "console=") {
+			c = "console=" + c
+		}
+		args = append(args, c)
+	}
+
+	return args
+}
+
+// Record holds the DHCP and netboot data of a single backend record, a network interface of a machine.
+type Record struct {
+	DHCP    *DHCP
+	Netboot *Netboot
+}
+
+// Instance holds the data of the instance that is provisioned on a client.
+type Instance struct {
+	ID         string
+	Hostname   string
+	UserData   string
+	PublicKeys []string // SSH public keys.
 }
 
 // OSIE or OS Installation Environment is the data about where the OSIE parts are located.
@@ -106,3 +106,22 @@ func TestNetbootEncodeToAttributes(t *testing.T) {
 		})
 	}
 }
+
+func TestNetbootConsoleArgs(t *testing.T) {
+	tests := map[string]struct {
+		console string
+		want    []string
+	}{
+		"empty":       {},
+		"devices":     {console: "tty0 ttyS1,115200n8", want: []string{"console=tty0", "console=ttyS1,115200n8"}},
+		"kernel args": {console: " console=ttyAMA0,115200  ttyS0", want: []string{"console=ttyAMA0,115200", "console=ttyS0"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			n := &Netboot{Console: tt.console}
+			if diff := cmp.Diff(tt.want, n.ConsoleArgs()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
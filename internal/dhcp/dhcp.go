@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
@@ -17,6 +18,8 @@ import (
 const (
 	PXEClient  ClientType = "PXEClient"
 	HTTPClient ClientType = "HTTPClient"
+	// UBoot is the option 60 prefix of the U-Boot DHCP client, its CONFIG_BOOTP_VCI_STRING, for example "U-Boot.armv8".
+	UBoot ClientType = "U-Boot"
 )
 
 // known user-class types. must correspond to DHCP option 77 - User-Class
@@ -59,6 +62,65 @@ var ArchToBootFile = map[iana.Arch]string{
 	iana.EFI_ARM32_HTTP:    "snp.efi",
 	iana.EFI_ARM64_HTTP:    "snp.efi",
 	iana.Arch(41):          "snp.efi", // arm rpiboot (0x29): https://www.iana.org/assignments/dhcpv6-parameters/dhcpv6-parameters.xhtml#processor-architecture
+	// riscv64 binaries are not embedded, they must be provided in the iPXE binary directory.
+	iana.EFI_RISCV64:      "snp-riscv64.efi",
+	iana.EFI_RISCV64_HTTP: "snp-riscv64.efi",
+}
+
+// ArchNames maps architecture names, as used in iPXE binary overrides, to their PXE and HTTP boot client architectures.
+var ArchNames = map[string][]iana.Arch{
+	"x86":     {iana.INTEL_X86PC},
+	"x86_64":  {iana.EFI_X86_64, iana.EFI_BC, iana.EFI_X86_64_HTTP},
+	"arm32":   {iana.EFI_ARM32, iana.EFI_ARM32_HTTP},
+	"arm64":   {iana.EFI_ARM64, iana.EFI_ARM64_HTTP},
+	"riscv64": {iana.EFI_RISCV64, iana.EFI_RISCV64_HTTP},
+}
+
+// ParseBinaries parses comma separated arch=binary iPXE binary overrides, for example "arm64=snp-arm64.efi,riscv64=ipxe-riscv64.efi".
+// arch is one of the ArchNames or a DHCP option 93 client architecture number.
+func ParseBinaries(s string) (map[iana.Arch]string, error) {
+	b := map[iana.Arch]string{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		arch, bin, ok := strings.Cut(kv, "=")
+		if !ok || bin == "" || strings.ContainsAny(bin, "/\\") {
+			return nil, fmt.Errorf("invalid iPXE binary override %q, must be arch=binary", kv)
+		}
+		archs, ok := ArchNames[arch]
+		if !ok {
+			n, err := strconv.ParseUint(arch, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid iPXE binary override %q, unknown arch %q", kv, arch)
+			}
+			archs = []iana.Arch{iana.Arch(n)}
+		}
+		for _, a := range archs {
+			b[a] = bin
+		}
+	}
+
+	return b, nil
+}
+
+// ArchToSecureBootFile maps the UEFI PXE architectures types that support Secure Boot to their shim binary file.
+// Shim loads grubx64.efi or grubaa64.efi from the same location it was loaded from.
+var ArchToSecureBootFile = map[iana.Arch]string{
+	iana.EFI_X86_64:      "shimx64.efi",
+	iana.EFI_BC:          "shimx64.efi",
+	iana.EFI_X86_64_HTTP: "shimx64.efi",
+	iana.EFI_ARM64:       "shimaa64.efi",
+	iana.EFI_ARM64_HTTP:  "shimaa64.efi",
+}
+
+// ArchToESXiFile maps the UEFI PXE architectures types that ESXi boots on to the ESXi boot loader.
+// mboot.efi loads boot.cfg from the same location it was loaded from.
+var ArchToESXiFile = map[iana.Arch]string{
+	iana.EFI_X86_64:      "mboot.efi",
+	iana.EFI_BC:          "mboot.efi",
+	iana.EFI_X86_64_HTTP: "mboot.efi",
 }
 
 // ErrUnknownArch is used when the PXE client request is from an unknown architecture.
@@ -160,6 +222,27 @@ func (i Info) IPXEBinaryFrom() string {
 	return bin
 }
 
+// UseBinaries replaces the iPXE binary with the override of the client architecture, if it has one.
+func (i *Info) UseBinaries(overrides map[iana.Arch]string) {
+	if bin, found := overrides[i.Arch]; found {
+		i.IPXEBinary = bin
+	}
+}
+
+// UseSecureBoot replaces the iPXE binary with the Secure Boot shim binary, if the client architecture has one.
+func (i *Info) UseSecureBoot() {
+	if bin, found := ArchToSecureBootFile[i.Arch]; found {
+		i.IPXEBinary = bin
+	}
+}
+
+// UseESXi replaces the iPXE binary with the ESXi boot loader, if the client architecture has one.
+func (i *Info) UseESXi() {
+	if bin, found := ArchToESXiFile[i.Arch]; found {
+		i.IPXEBinary = bin
+	}
+}
+
 // String function for clientType.
 func (c ClientType) String() string {
 	return string(c)
@@ -196,6 +279,26 @@ func (i Info) ClientTypeFrom() ClientType {
 	return c
 }
 
+// IsUBootClient returns whether pkt is a DHCP discovery/request message of the U-Boot DHCP client, identified by its
+// option 60 or by a U-Boot client architecture in option 93. U-Boot is not a netboot client, see IsNetbootClient,
+// its option 60 is not PXEClient.
+func IsUBootClient(pkt *dhcpv4.DHCPv4) bool {
+	if pkt.MessageType() != dhcpv4.MessageTypeDiscover && pkt.MessageType() != dhcpv4.MessageTypeRequest {
+		return false
+	}
+	if strings.HasPrefix(string(pkt.GetOneOption(dhcpv4.OptionClassIdentifier)), string(UBoot)) {
+		return true
+	}
+	for _, a := range pkt.ClientArch() {
+		switch a {
+		case iana.UBOOT_ARM32, iana.UBOOT_ARM64, iana.UBOOT_ARM32_HTTP, iana.UBOOT_ARM64_HTTP:
+			return true
+		}
+	}
+
+	return false
+}
+
 // IsNetbootClient returns nil if the client is a valid netboot client.	Otherwise it returns an error.
 //
 // A valid netboot client will have the following in its DHCP request:
@@ -158,6 +158,40 @@ func TestIsNetbootClient(t *testing.T) {
 	}
 }
 
+func TestIsUBootClient(t *testing.T) {
+	discover := dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover)
+	tests := map[string]struct {
+		mods []dhcpv4.Modifier
+		want bool
+	}{
+		"vendor class": {
+			mods: []dhcpv4.Modifier{discover, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("U-Boot.armv8"))},
+			want: true,
+		},
+		"u-boot arch": {
+			mods: []dhcpv4.Modifier{discover, dhcpv4.WithOption(dhcpv4.OptClientArch(iana.UBOOT_ARM32))},
+			want: true,
+		},
+		"pxe client": {
+			mods: []dhcpv4.Modifier{discover, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00011:UNDI:003000")), dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_ARM64))},
+		},
+		"release": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease), dhcpv4.WithOption(dhcpv4.OptClassIdentifier("U-Boot.armv8"))},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pkt, err := dhcpv4.New(tt.mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := IsUBootClient(pkt); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestBootfile(t *testing.T) {
 	type args struct {
 		customUC          UserClass
@@ -219,6 +253,96 @@ func TestBootfile(t *testing.T) {
 	}
 }
 
+func TestUseSecureBoot(t *testing.T) {
+	tests := map[string]struct {
+		info Info
+		want string
+	}{
+		"x86_64 uefi": {info: Info{Arch: iana.EFI_X86_64, IPXEBinary: "ipxe.efi"}, want: "shimx64.efi"},
+		"arm64 http":  {info: Info{Arch: iana.EFI_ARM64_HTTP, IPXEBinary: "snp.efi"}, want: "shimaa64.efi"},
+		"legacy bios": {info: Info{Arch: iana.INTEL_X86PC, IPXEBinary: "undionly.kpxe"}, want: "undionly.kpxe"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.info.UseSecureBoot()
+			if diff := cmp.Diff(tt.want, tt.info.IPXEBinary); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestUseESXi(t *testing.T) {
+	tests := map[string]struct {
+		info Info
+		want string
+	}{
+		"x86_64 uefi": {info: Info{Arch: iana.EFI_X86_64, IPXEBinary: "shimx64.efi"}, want: "mboot.efi"},
+		"x86_64 http": {info: Info{Arch: iana.EFI_X86_64_HTTP, IPXEBinary: "ipxe.efi"}, want: "mboot.efi"},
+		"arm64 uefi":  {info: Info{Arch: iana.EFI_ARM64, IPXEBinary: "snp.efi"}, want: "snp.efi"},
+		"legacy bios": {info: Info{Arch: iana.INTEL_X86PC, IPXEBinary: "undionly.kpxe"}, want: "undionly.kpxe"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.info.UseESXi()
+			if diff := cmp.Diff(tt.want, tt.info.IPXEBinary); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestParseBinaries(t *testing.T) {
+	tests := map[string]struct {
+		in      string
+		want    map[iana.Arch]string
+		wantErr bool
+	}{
+		"empty": {in: "", want: map[iana.Arch]string{}},
+		"arm64": {
+			in:   "arm64=snp-arm64.efi",
+			want: map[iana.Arch]string{iana.EFI_ARM64: "snp-arm64.efi", iana.EFI_ARM64_HTTP: "snp-arm64.efi"},
+		},
+		"arch number": {
+			in:   "x86=undionly.kpxe, 27=snp-riscv64.efi",
+			want: map[iana.Arch]string{iana.INTEL_X86PC: "undionly.kpxe", iana.EFI_RISCV64: "snp-riscv64.efi"},
+		},
+		"unknown arch":  {in: "sparc=snp.efi", wantErr: true},
+		"missing value": {in: "arm64=", wantErr: true},
+		"path":          {in: "arm64=../snp.efi", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseBinaries(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, want err %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestUseBinaries(t *testing.T) {
+	overrides := map[iana.Arch]string{iana.EFI_ARM64: "snp-arm64.efi"}
+	tests := map[string]struct {
+		info Info
+		want string
+	}{
+		"override": {info: Info{Arch: iana.EFI_ARM64, IPXEBinary: "snp.efi"}, want: "snp-arm64.efi"},
+		"default":  {info: Info{Arch: iana.EFI_X86_64, IPXEBinary: "ipxe.efi"}, want: "ipxe.efi"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.info.UseBinaries(overrides)
+			if diff := cmp.Diff(tt.want, tt.info.IPXEBinary); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
 func TestNextServer(t *testing.T) {
 	type args struct {
 		ipxeTFTPBinServer netip.AddrPort
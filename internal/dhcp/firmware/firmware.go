@@ -0,0 +1,249 @@
+// Package firmware holds synthetic DHCP requests modelled on those of network boot firmware, and checks the replies
+// of a DHCP handler to them, so that a change of the replies to the options a vendor sends is caught before a release.
+// The fixtures are hand written from the documented behavior of each firmware, their source is synthetic: a passing
+// check doesn't prove that the real firmware boots. A packet captured from a real machine is added with the capture
+// source and its provenance.
+//
+// Each fixture is a YAML file in the fixtures directory, with the hex encoded DHCP request and what its reply must be
+// for the firmware it models to boot:
+//
+//	firmware: HPE ProLiant UEFI HTTP boot
+//	source: synthetic
+//	expect:
+//	  bootfile: "ipxe.efi"
+//	  vendorClass: "HTTPClient"
+//	packet: |
+//	  0101060000003c15...
+package firmware
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/proxy"
+)
+
+//go:embed fixtures/*.yaml
+var fixtures embed.FS
+
+// The sources of the requests of the fixtures.
+const (
+	// SourceSynthetic is a hand written request, modelled on the documented behavior of the firmware.
+	SourceSynthetic = "synthetic"
+	// SourceCapture is a request captured from a real machine, its Provenance says where from.
+	SourceCapture = "capture"
+)
+
+// minMessageSize is the DHCP message size that every client must accept, RFC 2131 section 2. It is the maximum size
+// of the replies to requests without a maximum message size option.
+const minMessageSize = 576
+
+// Fixture is a synthetic DHCP request of a network boot firmware.
+type Fixture struct {
+	// Name is the name of the fixture file, without its extension.
+	Name string `json:"-"`
+	// Firmware describes the firmware that the request is modelled on.
+	Firmware string `json:"firmware"`
+	// Source is SourceSynthetic or SourceCapture.
+	Source string `json:"source"`
+	// Provenance is the machine, firmware version and date of a captured request, it is required for a capture.
+	Provenance string `json:"provenance,omitempty"`
+	Expect     Expect `json:"expect"`
+	// Packet is the hex encoded DHCP request, white space is ignored.
+	Packet string `json:"packet"`
+
+	pkt *dhcpv4.DHCPv4
+}
+
+// Expect is what the reply to the request of a fixture must be, for the firmware to boot.
+type Expect struct {
+	// Bootfile is the iPXE binary that the firmware must be sent, the last element of the boot file URL for HTTP boot
+	// clients. The firmware must not be sent a boot file when it is empty.
+	Bootfile string `json:"bootfile"`
+	// VendorClass is the class identifier, option 60, of the reply: PXEClient or HTTPClient. HTTP boot firmware
+	// ignores the offers without it, PXE firmware only the ProxyDHCP offers without it.
+	VendorClass string `json:"vendorClass"`
+}
+
+// Fixtures returns the fixtures, sorted by name.
+func Fixtures() ([]Fixture, error) {
+	files, err := fixtures.ReadDir("fixtures")
+	if err != nil {
+		return nil, err
+	}
+	var fs []Fixture
+	for _, f := range files {
+		b, err := fixtures.ReadFile(path.Join("fixtures", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		c, err := Parse(strings.TrimSuffix(f.Name(), ".yaml"), b)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, c)
+	}
+	slices.SortFunc(fs, func(a, b Fixture) int { return strings.Compare(a.Name, b.Name) })
+
+	return fs, nil
+}
+
+// Parse parses and validates the YAML fixture file b.
+func Parse(name string, b []byte) (Fixture, error) {
+	c := Fixture{Name: name}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return Fixture{}, fmt.Errorf("fixture %s: %w", name, err)
+	}
+	switch {
+	case c.Source != SourceSynthetic && c.Source != SourceCapture:
+		return Fixture{}, fmt.Errorf("fixture %s: source %q must be %s or %s", name, c.Source, SourceSynthetic, SourceCapture)
+	case c.Source == SourceCapture && strings.TrimSpace(c.Provenance) == "":
+		return Fixture{}, fmt.Errorf("fixture %s: a capture requires its provenance", name)
+	}
+	raw, err := hex.DecodeString(strings.Join(strings.Fields(c.Packet), ""))
+	if err != nil {
+		return Fixture{}, fmt.Errorf("fixture %s: invalid packet: %w", name, err)
+	}
+	if c.pkt, err = dhcpv4.FromBytes(raw); err != nil {
+		return Fixture{}, fmt.Errorf("fixture %s: invalid packet: %w", name, err)
+	}
+	if c.pkt.OpCode != dhcpv4.OpcodeBootRequest {
+		return Fixture{}, fmt.Errorf("fixture %s: the packet is not a DHCP request", name)
+	}
+
+	return c, nil
+}
+
+// Request returns a copy of the DHCP request of c, from mac when it is set.
+func (c Fixture) Request(mac net.HardwareAddr) *dhcpv4.DHCPv4 {
+	pkt, _ := dhcpv4.FromBytes(c.pkt.ToBytes())
+	if mac != nil {
+		pkt.ClientHWAddr = mac
+	}
+
+	return pkt
+}
+
+// Check returns the problems of reply, the reply of a DHCP handler to req, the Request of c.
+// The firmware quirks that break network boots are checked for every fixture:
+//   - the reply must not be larger than the maximum message size of the request, or 576 bytes without one.
+//   - the reply must have the transaction ID and the client hardware address of the request.
+//   - the reply to a broadcast request must have the broadcast flag set.
+//
+// The boot file and class identifier of the reply are checked against Expect.
+func (c Fixture) Check(req, reply *dhcpv4.DHCPv4) error {
+	if reply == nil {
+		return errors.New("no reply")
+	}
+	var problems []error
+	maxSize := minMessageSize
+	if m, err := dhcpv4.GetUint16(dhcpv4.OptionMaximumDHCPMessageSize, req.Options); err == nil && int(m) > maxSize {
+		maxSize = int(m)
+	}
+	if n := len(reply.ToBytes()); n > maxSize {
+		problems = append(problems, fmt.Errorf("the reply is %d bytes, larger than the maximum message size of %d bytes", n, maxSize))
+	}
+	if reply.TransactionID != req.TransactionID {
+		problems = append(problems, fmt.Errorf("got transaction ID %s, want %s", reply.TransactionID, req.TransactionID))
+	}
+	if !bytes.Equal(reply.ClientHWAddr, req.ClientHWAddr) {
+		problems = append(problems, fmt.Errorf("got client hardware address %s, want %s", reply.ClientHWAddr, req.ClientHWAddr))
+	}
+	if req.IsBroadcast() && !reply.IsBroadcast() {
+		problems = append(problems, errors.New("the reply to a broadcast request has no broadcast flag"))
+	}
+	if err := c.checkClassIdentifier(reply); err != nil {
+		problems = append(problems, err)
+	}
+	if err := c.checkBootfile(reply); err != nil {
+		problems = append(problems, err)
+	}
+
+	return errors.Join(problems...)
+}
+
+// checkClassIdentifier checks the class identifier of reply against Expect.VendorClass. PXE firmware accepts a DHCP
+// offer with an address and a boot file without a class identifier, it only requires one in ProxyDHCP offers.
+func (c Fixture) checkClassIdentifier(reply *dhcpv4.DHCPv4) error {
+	got := reply.ClassIdentifier()
+	proxyOffer := reply.YourIPAddr == nil || reply.YourIPAddr.IsUnspecified()
+	if got == "" && c.Expect.VendorClass != "HTTPClient" && !proxyOffer {
+		return nil
+	}
+	if got != c.Expect.VendorClass {
+		return fmt.Errorf("got class identifier %q, want %q", got, c.Expect.VendorClass)
+	}
+
+	return nil
+}
+
+// checkBootfile checks the boot file of reply against Expect.Bootfile.
+func (c Fixture) checkBootfile(reply *dhcpv4.DHCPv4) error {
+	bf := reply.BootFileName
+	switch {
+	case c.Expect.Bootfile == "" && bf != "":
+		return fmt.Errorf("got boot file %q, want none", bf)
+	case c.Expect.Bootfile == "":
+		return nil
+	case c.Expect.VendorClass == "HTTPClient":
+		u, err := url.Parse(bf)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("got boot file %q, want an HTTP URL of %s", bf, c.Expect.Bootfile)
+		}
+		bf = u.Path
+	default:
+		if reply.ServerIPAddr == nil || reply.ServerIPAddr.IsUnspecified() {
+			return errors.New("the reply has no next server to load the boot file from")
+		}
+	}
+	// the boot file can be suffixed with a traceparent, see the OTELEnabled option of the DHCP handlers.
+	if name := path.Base(bf); name != c.Expect.Bootfile && !strings.HasPrefix(name, c.Expect.Bootfile+"-00-") {
+		return fmt.Errorf("got boot file %q, want %s", reply.BootFileName, c.Expect.Bootfile)
+	}
+
+	return nil
+}
+
+// Replier returns the reply of the DHCP handler to a DHCP message, without sending it.
+type Replier interface {
+	Reply(ctx context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error)
+}
+
+// Replay replays the request of c, from mac when it is set, through r and checks the reply. It returns the reply, nil
+// when the ProxyDHCP handler does not reply to a firmware that must not be sent a boot file, and its problems.
+func (c Fixture) Replay(ctx context.Context, r Replier, mac net.HardwareAddr) (*dhcpv4.DHCPv4, error) {
+	req := c.Request(mac)
+	reply, err := r.Reply(ctx, req)
+	if err != nil {
+		if errors.Is(err, proxy.ErrNoReply) && c.Expect.Bootfile == "" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return reply, c.Check(req, reply)
+}
+
+// Replay replays the requests of the fixtures, from mac when it is set, through r and checks the replies. It returns
+// the problems of every fixture, joined, nil when all the replies are as expected.
+func Replay(ctx context.Context, r Replier, mac net.HardwareAddr, cs []Fixture) error {
+	var problems []error
+	for _, c := range cs {
+		if _, err := c.Replay(ctx, r, mac); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+
+	return errors.Join(problems...)
+}
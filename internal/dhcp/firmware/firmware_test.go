@@ -0,0 +1,179 @@
+package firmware
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/proxy"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/reservation"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// update rewrites the golden replies in testdata with the replies of the handlers.
+var update = flag.Bool("update", false, "update the golden replies in testdata")
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+// backend has a record, allowed to netboot, for every MAC address.
+type backend struct{}
+
+func (backend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	d := &data.DHCP{
+		MACAddress:     mac,
+		IPAddress:      netip.MustParseAddr("192.168.2.100"),
+		SubnetMask:     []byte{255, 255, 255, 0},
+		DefaultGateway: netip.MustParseAddr("192.168.2.1"),
+		NameServers:    []net.IP{{192, 168, 2, 1}},
+		Hostname:       "machine-" + strings.ReplaceAll(mac.String(), ":", ""),
+		LeaseTime:      86400,
+	}
+
+	return d, &data.Netboot{AllowNetboot: true}, nil
+}
+
+func (backend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, &net.AddrError{Err: "not found"}
+}
+
+func handlers() map[string]Replier {
+	scriptURL := func(*dhcpv4.DHCPv4) *url.URL {
+		return &url.URL{Scheme: "http", Host: "192.168.2.5:8080", Path: "/auto.ipxe"}
+	}
+	return map[string]Replier{
+		"reservation": &reservation.Handler{
+			Backend: backend{},
+			IPAddr:  netip.MustParseAddr("192.168.2.5"),
+			Log:     logr.Discard(),
+			Netboot: reservation.Netboot{
+				IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.5:69"),
+				IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "192.168.2.5:8080", Path: "/ipxe/"},
+				IPXEScriptURL:     scriptURL,
+				Enabled:           true,
+			},
+		},
+		"proxy": &proxy.Handler{
+			IPAddr: netip.MustParseAddr("192.168.2.5"),
+			Log:    logr.Discard(),
+			Netboot: proxy.Netboot{
+				IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.5:69"),
+				IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "192.168.2.5:8080", Path: "/ipxe/"},
+				IPXEScriptURL:     scriptURL,
+				Enabled:           true,
+			},
+			AutoProxyEnabled: true,
+		},
+	}
+}
+
+// TestFixtures replays the fixtures through the DHCP handlers, checks the replies and diffs them against the golden
+// replies in testdata. Run go test -update to accept a change of the replies.
+func TestFixtures(t *testing.T) {
+	cs, err := Fixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) == 0 {
+		t.Fatal("expected fixtures")
+	}
+	for mode, h := range handlers() {
+		for _, c := range cs {
+			t.Run(mode+"/"+c.Name, func(t *testing.T) {
+				reply, err := c.Replay(context.Background(), h, nil)
+				if err != nil {
+					t.Errorf("%s: %v", c.Firmware, err)
+				}
+				got := "no reply\n"
+				if reply != nil {
+					got = reply.Summary()
+				}
+				golden := filepath.Join("testdata", mode, c.Name+".txt")
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, []byte(got), 0o600); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(string(want), got); diff != "" {
+					t.Errorf("the reply changed, run go test -update to accept it:\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cs, err := Fixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Fixture
+	for _, cc := range cs {
+		if cc.Name == "u-boot-arm64" {
+			c = cc
+		}
+	}
+	req := c.Request(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	if req.ClientHWAddr.String() != "02:00:00:00:00:01" {
+		t.Fatalf("got client hardware address %s, want the replaced one", req.ClientHWAddr)
+	}
+	reply, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient")),
+		dhcpv4.WithOption(dhcpv4.OptBootFileName("snp.efi")),
+		dhcpv4.WithGeneric(dhcpv4.OptionVendorSpecificInformation, make([]byte, 255)),
+		dhcpv4.WithGeneric(dhcpv4.OptionRootPath, make([]byte, 200)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.BootFileName = "snp.efi"
+	reply.TransactionID = dhcpv4.TransactionID{}
+	err = c.Check(req, reply)
+	for _, want := range []string{"larger than the maximum message size of 576 bytes", "got transaction ID", `got class identifier "PXEClient", want ""`, `got boot file "snp.efi", want none`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the problem %q, got %v", want, err)
+		}
+	}
+	if err := c.Check(req, nil); err == nil {
+		t.Error("expected an error without a reply")
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse("invalid", []byte("source: synthetic\npacket: zz")); err == nil {
+		t.Fatal("expected an error for a packet that is not hex encoded")
+	}
+	for _, b := range []string{"packet: 01", "source: capture\npacket: 01"} {
+		if _, err := Parse("provenance", []byte(b)); err == nil || strings.Contains(err.Error(), "invalid packet") {
+			t.Fatalf("%q: expected an error for a fixture without a source or the provenance of its capture, got %v", b, err)
+		}
+	}
+	reply, err := dhcpv4.New(dhcpv4.WithReply(&dhcpv4.DHCPv4{OpCode: dhcpv4.OpcodeBootRequest}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse("reply", []byte("source: synthetic\npacket: "+hex.EncodeToString(reply.ToBytes()))); err == nil {
+		t.Fatal("expected an error for a packet that is not a request")
+	}
+}
@@ -4,7 +4,9 @@ package handler
 import (
 	"context"
 	"net"
+	"time"
 
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 )
 
@@ -17,3 +19,90 @@ type BackendReader interface {
 	GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error)
 	GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error)
 }
+
+// BackendIdentityReader is the interface for reading data by the identity that a machine reports,
+// its SMBIOS system UUID, serial number or hostname.
+//
+// Backends implement this interface so that machines can be identified when their MAC or IP address, as seen by Smee,
+// is obscured, for example by NAT or bonding.
+type BackendIdentityReader interface {
+	// GetByUUID returns the data of the machine with the SMBIOS system UUID.
+	GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error)
+	// GetBySerial returns the data of the machine with the serial number.
+	GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error)
+	// GetByHostname returns the data of the network interface with the hostname.
+	GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error)
+}
+
+// BackendLister is the interface for listing all the data of a backend.
+//
+// Backends implement this interface so that their data can be exported, for example to the configuration of an external DHCP server.
+type BackendLister interface {
+	// List returns the records of all network interfaces, sorted by mac address.
+	List(context.Context) ([]data.Record, error)
+}
+
+// Observer is notified after a DHCP reply has been sent to a machine.
+// ctx is the context of the DHCP message, implementations doing background work must not depend on it.
+type Observer interface {
+	DHCPServed(ctx context.Context, mac net.HardwareAddr, msgType string)
+}
+
+// LeaseObserver is an Observer that is also notified of the IP address that a DHCP ACK leased to a machine.
+type LeaseObserver interface {
+	Observer
+	DHCPLeased(ctx context.Context, mac net.HardwareAddr, ip net.IP, leaseTime time.Duration)
+}
+
+// AddressObserver is an Observer that is also notified of the IP address that a machine uses, as seen in the DHCP
+// messages that it sends: their client IP address or requested IP address option. In proxy mode, it is how Smee
+// learns the IP addresses that another DHCP server leased.
+type AddressObserver interface {
+	Observer
+	DHCPAddressSeen(ctx context.Context, mac net.HardwareAddr, ip net.IP)
+}
+
+// MessageObserver is an Observer that is also notified of the DHCP messages that machines send, before they are
+// handled, and of the replies that are sent to them, for example to time the boot stages of machines.
+type MessageObserver interface {
+	Observer
+	DHCPReceived(ctx context.Context, req *dhcpv4.DHCPv4)
+	DHCPReplied(ctx context.Context, reply *dhcpv4.DHCPv4)
+}
+
+// NotifyRequest notifies the MessageObservers of observers of req, and the AddressObservers of the IP address that
+// the machine of req uses, if req has one.
+func NotifyRequest(ctx context.Context, observers []Observer, req *dhcpv4.DHCPv4) {
+	for _, o := range observers {
+		if mo, ok := o.(MessageObserver); ok {
+			mo.DHCPReceived(ctx, req)
+		}
+	}
+	ip := req.ClientIPAddr
+	if ip == nil || ip.IsUnspecified() {
+		ip = req.RequestedIPAddress()
+	}
+	if ip == nil || ip.IsUnspecified() {
+		return
+	}
+	for _, o := range observers {
+		if ao, ok := o.(AddressObserver); ok {
+			ao.DHCPAddressSeen(ctx, req.ClientHWAddr, ip)
+		}
+	}
+}
+
+// Notify notifies the observers that reply has been sent.
+func Notify(ctx context.Context, observers []Observer, reply *dhcpv4.DHCPv4) {
+	for _, o := range observers {
+		o.DHCPServed(ctx, reply.ClientHWAddr, reply.MessageType().String())
+		if mo, ok := o.(MessageObserver); ok {
+			mo.DHCPReplied(ctx, reply)
+		}
+		lo, ok := o.(LeaseObserver)
+		if !ok || reply.MessageType() != dhcpv4.MessageTypeAck || reply.YourIPAddr == nil || reply.YourIPAddr.IsUnspecified() {
+			continue
+		}
+		lo.DHCPLeased(ctx, reply.ClientHWAddr, reply.YourIPAddr, reply.IPAddressLeaseTime(0))
+	}
+}
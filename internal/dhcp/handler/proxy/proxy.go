@@ -20,13 +20,25 @@ import (
 	"net"
 	"net/netip"
 	"net/url"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/chaos"
 	"github.com/tinkerbell/smee/internal/dhcp"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 	"github.com/tinkerbell/smee/internal/dhcp/handler"
 	oteldhcp "github.com/tinkerbell/smee/internal/dhcp/otel"
+	"github.com/tinkerbell/smee/internal/localmac"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/mirror"
+	"github.com/tinkerbell/smee/internal/oui"
+	"github.com/tinkerbell/smee/internal/policy"
+	"github.com/tinkerbell/smee/internal/profile"
+	"github.com/tinkerbell/smee/internal/pxemenu"
+	"github.com/tinkerbell/smee/internal/tenant"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -46,6 +58,11 @@ type Handler struct {
 	// This could be a load balancer IP address or an ingress IP address or a local IP address.
 	IPAddr netip.Addr
 
+	// ServerID, when valid, is the server identifier (option 54) of the replies, in place of the next server.
+	// The DHCPREQUESTs that ProxyDHCP answers name the DHCP server that leases the address, not the ProxyDHCP server,
+	// so they are not filtered by their server identifier.
+	ServerID netip.Addr
+
 	// Log is used to log messages.
 	// `logr.Discard()` can be used if no logging is desired.
 	Log logr.Logger
@@ -59,9 +76,58 @@ type Handler struct {
 	// <original filename>-00-<trace id>-<span id>-<trace flags>
 	OTELEnabled bool
 
+	// OTELNewRoot starts a new trace for every DHCP message, in place of continuing the trace of the server context.
+	// With an otel.BootTraces Observer, the later requests of the boot chain of a machine continue this trace.
+	OTELNewRoot bool
+
 	// AutoProxyEnabled is used to determine if the proxyDHCP handler should do any Backend calls or not.
 	// When enabled no Backend calls are made and responses are sent to all valid network boot clients.
 	AutoProxyEnabled bool
+
+	// Tenants, when set, maps DHCP messages to their tenant, see tenant.Config.DHCPContext, and adds the URL prefix
+	// of the tenant to the iPXE script URL. The Backend should be scoped to the tenants, see tenant.Config.Scope.
+	Tenants *tenant.Config
+
+	// Profiles, when set, overrides the iPXE binary and script URL of clients by their user class and vendor class.
+	Profiles *profile.Config
+
+	// OUIRules, when set in auto proxy mode, overrides the iPXE script URL of clients by the OUI of their MAC address.
+	// The script URL of a profile takes precedence.
+	OUIRules *oui.Config
+
+	// Mirrors, when set, answers the iPXE script URL of machines with a healthy candidate of its mirror group.
+	Mirrors *mirror.Config
+
+	// Menu, when set, is the PXE boot menu that the firmware of PXE clients shows before iPXE is loaded.
+	Menu *pxemenu.Menu
+
+	// Policy, when set, decides whether a machine is allowed to netboot and the iPXE script it is sent to.
+	// In auto proxy mode, machines that no rule matches are allowed.
+	Policy *policy.Policy
+
+	// LocalMACs, when set, is what is done with the DHCP messages of locally administered MAC addresses, see the
+	// localmac package. The Backend should have it applied too, see localmac.Policy.Backend.
+	LocalMACs *localmac.Policy
+
+	// DryRun, when set and true, handles DHCP messages as usual but doesn't send the replies.
+	DryRun *atomic.Bool
+
+	// Faults, when set, drops a percentage of the replies to test the resilience of provisioning.
+	Faults *chaos.Injector
+
+	// Transactions, when set, reuses the response to a DHCPDISCOVER or DHCPREQUEST for its retransmissions.
+	Transactions *dhcp.Transactions
+
+	// ReplyPolicy decides the broadcast flag of the replies and the address they are sent to.
+	ReplyPolicy dhcp.ReplyPolicy
+
+	// Observers are notified after a DHCP reply has been sent to a machine.
+	Observers []handler.Observer
+
+	// WindowsCompat tunes the replies for networks whose DHCP server is Microsoft DHCP, see docs/Windows-DHCP.md.
+	// The DHCPREQUESTs that name another server identifier, those of the lease of the DHCP server, are not answered,
+	// and the replies to boot server discovery requests echo their PXE boot item.
+	WindowsCompat bool
 }
 
 // Netboot holds the netboot configuration details used in running a DHCP server.
@@ -80,10 +146,16 @@ type Netboot struct {
 
 	// UserClass (for network booting) allows a custom DHCP option 77 to be used to break out of an iPXE loop.
 	UserClass dhcp.UserClass
+
+	// SecureBoot, when true, points UEFI clients at the Secure Boot shim binary instead of the iPXE binary.
+	SecureBoot bool
+
+	// Binaries, when set, overrides the iPXE binary of client architectures, see dhcp.ArchToBootFile.
+	Binaries map[iana.Arch]string
 }
 
 // Redirection name comes from section 2.5 of http://www.pix.net/software/pxeboot/archive/pxespec.pdf
-func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Packet) {
+func (h *Handler) Handle(ctx context.Context, conn data.Conn, dp data.Packet) {
 	// validations
 	if dp.Pkt == nil {
 		h.Log.Error(errors.New("incoming packet is nil"), "not able to respond when the incoming packet is nil")
@@ -102,6 +174,9 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		h.Log.Error(errors.New("connection is nil"), "not able to respond when the connection is nil")
 		return
 	}
+	if !h.LocalMACs.DHCP(dp.Pkt) {
+		return
+	}
 
 	var ifName string
 	if dp.Md != nil {
@@ -110,16 +185,94 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 	log := h.Log.WithValues("mac", dp.Pkt.ClientHWAddr.String(), "xid", dp.Pkt.TransactionID.String(), "interface", ifName)
 	tracer := otel.Tracer(tracerName)
 	var span trace.Span
-	ctx, span = tracer.Start(
-		ctx,
-		fmt.Sprintf("DHCP Packet Received: %v", dp.Pkt.MessageType().String()),
-		trace.WithAttributes(h.encodeToAttributes(dp.Pkt, "request")...),
-		trace.WithAttributes(attribute.String("DHCP.peer", dp.Peer.String())),
-		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
+	var spanOpts []trace.SpanStartOption
+	if h.OTELNewRoot {
+		spanOpts = append(spanOpts, trace.WithNewRoot())
+	}
+	ctx, span = tracer.Start(ctx, "DHCP Packet Received: "+dp.Pkt.MessageType().String(), spanOpts...)
+	defer span.End()
+	defer metric.ObserveDuration(ctx, metric.JobDuration.WithLabelValues("dhcp", "DHCP"+dp.Pkt.MessageType().String()), time.Now())
+	ctx = h.Tenants.DHCPContext(ctx, dp.Pkt)
+	handler.NotifyRequest(ctx, h.Observers, dp.Pkt)
+	// Encoding the packet allocates, it is skipped when the span is not recorded.
+	if span.IsRecording() {
+		span.SetAttributes(h.encodeToAttributes(dp.Pkt, "request")...)
+		span.SetAttributes(attribute.String("DHCP.peer", dp.Peer.String()), attribute.String("DHCP.server.ifname", ifName))
+	}
+
+	reply := h.Transactions.Get(dp)
+	if reply != nil {
+		log.V(1).Info("received retransmitted DHCP packet, reusing the ProxyDHCP response", "type", dp.Pkt.MessageType().String())
+		span.SetAttributes(attribute.Bool("DHCP.retransmission", true))
+	} else {
+		if reply, log = h.reply(ctx, log, span, dp); reply == nil {
+			return
+		}
+		h.Transactions.Put(dp, reply)
+	}
+
+	h.ReplyPolicy.SetBroadcastFlag(reply)
+	dst := h.ReplyPolicy.Destination(dp.Peer, dp.Pkt, reply)
+	cm := &ipv4.ControlMessage{}
+	if dp.Md != nil {
+		cm.IfIndex = dp.Md.IfIndex
+	}
+	log = log.WithValues(
+		"destination", dst.String(),
+		"bootFileName", reply.BootFileName,
+		"nextServer", reply.ServerIPAddr.String(),
+		"messageType", reply.MessageType().String(),
+		"serverHostname", reply.ServerHostName,
 	)
+	if h.DryRun != nil && h.DryRun.Load() {
+		log.Info("dry run, ProxyDHCP response not sent")
+		span.SetStatus(codes.Ok, "dry run, DHCP response not sent")
 
-	defer span.End()
+		return
+	}
+	if h.Faults.DropDHCP() {
+		log.Info("fault injection, ProxyDHCP response dropped")
+		span.SetStatus(codes.Ok, "fault injection, DHCP response dropped")
+
+		return
+	}
+	// send the DHCP packet
+	if _, err := conn.WriteTo(reply.ToBytes(), cm, dst); err != nil {
+		log.Error(err, "failed to send ProxyDHCP response")
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	log.Info("Sent ProxyDHCP response")
+	handler.Notify(ctx, h.Observers, reply)
+	if span.IsRecording() {
+		span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
+	}
+	span.SetStatus(codes.Ok, "sent DHCP response")
+}
+
+// ErrNoReply is returned by Reply when no ProxyDHCP response is sent for a DHCP message.
+var ErrNoReply = errors.New("no ProxyDHCP response")
 
+// Reply returns the ProxyDHCP response that is sent for a DHCP message, without sending it.
+// It returns an ErrNoReply error when the message is ignored, like when it isn't from a PXE client or the machine
+// isn't allowed to netboot.
+func (h *Handler) Reply(ctx context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	if !h.LocalMACs.DHCP(pkt) {
+		return nil, ErrNoReply
+	}
+	ctx = h.Tenants.DHCPContext(ctx, pkt)
+	reply, _ := h.reply(ctx, logr.Discard(), trace.SpanFromContext(ctx), data.Packet{Pkt: pkt})
+	if reply == nil {
+		return nil, ErrNoReply
+	}
+	h.ReplyPolicy.SetBroadcastFlag(reply)
+
+	return reply, nil
+}
+
+// reply returns the ProxyDHCP response to dp, nil when dp is ignored. The returned logger has the boot profile of the client.
+func (h *Handler) reply(ctx context.Context, log logr.Logger, span trace.Span, dp data.Packet) (*dhcpv4.DHCPv4, logr.Logger) {
 	// We ignore the error here because:
 	// 1. it's only non-nil if the generation of a transaction id (XID) fails.
 	// 2. We always use the clients transaction id (XID) in responses. See dhcpv4.WithReply().
@@ -129,38 +282,43 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		log.V(1).Info("Ignoring packet", "OpCode", dp.Pkt.OpCode)
 		span.SetStatus(codes.Ok, "Ignoring packet: OpCode not BootRequest")
 
-		return
+		return nil, log
 	}
 
 	if err := setMessageType(reply, dp.Pkt.MessageType()); err != nil {
 		log.V(1).Info("Ignoring packet", "error", err.Error())
 		span.SetStatus(codes.Ok, err.Error())
 
-		return
+		return nil, log
 	}
 
 	// Set option 97
 	reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientMachineIdentifier, dp.Pkt.GetOneOption(dhcpv4.OptionClientMachineIdentifier)))
 
-	i := dhcp.NewInfo(dp.Pkt)
+	prof, hasProfile := h.Profiles.Select(dp.Pkt.ClientHWAddr, dhcp.NewInfo(dp.Pkt).UserClassFrom().String(), string(dp.Pkt.GetOneOption(dhcpv4.OptionClassIdentifier)))
+	if hasProfile {
+		log = log.WithValues("profile", prof.Name)
+	}
+	i := h.info(dp.Pkt, prof)
 
 	if !h.Netboot.Enabled {
 		log.V(1).Info("Ignoring packet: netboot is not enabled")
 		span.SetStatus(codes.Ok, "Ignoring packet: netboot is not enabled")
 
-		return
+		return nil, log
 	}
 	if err := i.IsNetbootClient; err != nil {
 		log.V(1).Info("Ignoring packet: not from a PXE enabled client", "error", err.Error())
 		span.SetStatus(codes.Ok, fmt.Sprintf("Ignoring packet: not from a PXE enabled client: %s", err.Error()))
 
-		return
+		return nil, log
 	}
 	if i.IPXEBinary == "" {
-		log.V(1).Info("Ignoring packet: no iPXE binary was able to be determined")
+		log.Info("Ignoring packet: no iPXE binary for the client architecture", "arch", i.Arch.String(), "archCode", uint16(i.Arch))
+		metric.DHCPUnsupportedArch.WithLabelValues(i.Arch.String()).Inc()
 		span.SetStatus(codes.Ok, "Ignoring packet: no iPXE binary was able to be determined")
 
-		return
+		return nil, log
 	}
 
 	// Set option 43
@@ -174,11 +332,21 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 	// Set option 54, without this the pxe client will try to broadcast a request message to port 4011 for the ipxe binary. only found to be needed for PXEClient but not prohibitive for HTTPClient.
 	// probably will want this to be the public IP of the proxyDHCP server
 	ns := i.NextServer(h.Netboot.IPXEBinServerHTTP, h.Netboot.IPXEBinServerTFTP)
-	reply.UpdateOption(dhcpv4.OptServerIdentifier(ns))
+	if h.ServerID.IsValid() {
+		reply.UpdateOption(dhcpv4.OptServerIdentifier(h.ServerID.AsSlice()))
+	} else {
+		reply.UpdateOption(dhcpv4.OptServerIdentifier(ns))
+	}
 	// add the siaddr (IP address of next server) dhcp packet header to a given packet pkt.
 	// see https://datatracker.ietf.org/doc/html/rfc2131#section-2
 	// without this the pxe client will try to broadcast a request message to port 4011 for the ipxe script. The value doesnt seem to matter.
 	reply.ServerIPAddr = ns
+	if sid := dp.Pkt.ServerIdentifier(); h.WindowsCompat && dp.Pkt.MessageType() == dhcpv4.MessageTypeRequest && sid != nil && !sid.Equal(reply.ServerIdentifier()) {
+		log.V(1).Info("Ignoring packet: the DHCPREQUEST is for another DHCP server", "serverID", sid.String())
+		span.SetStatus(codes.Ok, "Ignoring packet: the DHCPREQUEST is for another DHCP server")
+
+		return nil, log
+	}
 
 	// set sname header
 	// see https://datatracker.ietf.org/doc/html/rfc2131#section-2
@@ -186,11 +354,26 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 	// setSNAME(reply, dp.Pkt.GetOneOption(dhcpv4.OptionClassIdentifier), h.Netboot.IPXEBinServerTFTP.Addr().AsSlice(), net.ParseIP(h.Netboot.IPXEBinServerHTTP.Hostname()))
 
 	// set bootfile header
-	reply.BootFileName = i.Bootfile("", h.Netboot.IPXEScriptURL(dp.Pkt), h.Netboot.IPXEBinServerHTTP, h.Netboot.IPXEBinServerTFTP)
+	name, _ := tenant.FromContext(ctx)
+	ipxeScript := h.Tenants.URL(name, h.Netboot.IPXEScriptURL(dp.Pkt))
+	if r, ok := h.OUIRules.Lookup(dp.Pkt.ClientHWAddr); ok && h.AutoProxyEnabled {
+		log = log.WithValues("ouiRule", r.Name)
+		if u := r.IPXEScriptURL(); u != nil {
+			ipxeScript = u
+		}
+	}
+	if u := prof.IPXEScriptURL(); u != nil {
+		ipxeScript = u
+	}
+	ipxeScript = h.Mirrors.SelectURL(dp.Pkt.ClientHWAddr, ipxeScript)
+	reply.BootFileName = i.Bootfile("", ipxeScript, h.Netboot.IPXEBinServerHTTP, h.Netboot.IPXEBinServerTFTP)
 
 	if !h.AutoProxyEnabled {
 		// check the backend, if PXE is NOT allowed, set the boot file name to "/<mac address>/not-allowed"
-		_, n, err := h.Backend.GetByMac(ctx, dp.Pkt.ClientHWAddr)
+		d, n, err := h.Backend.GetByMac(ctx, dp.Pkt.ClientHWAddr)
+		if err == nil && n != nil && h.Policy != nil {
+			n = h.authorize(log, reply, dp.Pkt, prof, d, n)
+		}
 		if err != nil || (n != nil && !n.AllowNetboot) {
 			l := log.V(1)
 			if err != nil {
@@ -201,8 +384,21 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 			}
 			l.Info("Ignoring packet")
 			span.SetStatus(codes.Ok, "netboot not allowed")
-			return
+			return nil, log
 		}
+	} else if h.Policy != nil {
+		n := h.authorize(log, reply, dp.Pkt, prof, &data.DHCP{MACAddress: dp.Pkt.ClientHWAddr}, &data.Netboot{AllowNetboot: true})
+		if !n.AllowNetboot {
+			log.V(1).Info("Ignoring packet", "netbootAllowed", false)
+			span.SetStatus(codes.Ok, "netboot not allowed")
+			return nil, log
+		}
+	}
+
+	if it, ok := h.Menu.Apply(reply, dp.Pkt); ok {
+		log = log.WithValues("pxeMenuItem", it.Description)
+	} else if h.WindowsCompat {
+		echoBootItem(reply, dp.Pkt)
 	}
 
 	log.Info(
@@ -212,28 +408,51 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		"userClass", i.UserClassFrom().String(),
 	)
 
-	dst := replyDestination(dp.Peer, dp.Pkt.GatewayIPAddr)
-	cm := &ipv4.ControlMessage{}
-	if dp.Md != nil {
-		cm.IfIndex = dp.Md.IfIndex
+	return reply, log
+}
+
+// authorize applies the netboot policy to the backend netboot data.
+// When the policy sets a boot target, the bootfile of reply is updated to it.
+// In auto proxy mode there is no backend data, so only MAC address,
+// source and time of day rules can match.
+func (h *Handler) authorize(log logr.Logger, reply, pkt *dhcpv4.DHCPv4, prof profile.Profile, d *data.DHCP, n *data.Netboot) *data.Netboot {
+	dec := h.Policy.Evaluate(policy.Request{
+		Source:       policy.SourceDHCP,
+		MAC:          pkt.ClientHWAddr,
+		Arch:         d.Arch,
+		IP:           d.IPAddress,
+		Labels:       n.Labels,
+		AllowNetboot: n.AllowNetboot,
+	})
+	if dec.Rule != "" {
+		log.Info("netboot policy rule matched", "rule", dec.Rule, "allow", dec.Allow)
+	}
+	an := *n
+	an.AllowNetboot = dec.Allow
+	if dec.BootTarget != nil {
+		i := h.info(pkt, prof)
+		reply.BootFileName = i.Bootfile("", dec.BootTarget, h.Netboot.IPXEBinServerHTTP, h.Netboot.IPXEBinServerTFTP)
 	}
-	log = log.WithValues(
-		"destination", dst.String(),
-		"bootFileName", reply.BootFileName,
-		"nextServer", reply.ServerIPAddr.String(),
-		"messageType", reply.MessageType().String(),
-		"serverHostname", reply.ServerHostName,
-	)
-	// send the DHCP packet
-	if _, err := conn.WriteTo(reply.ToBytes(), cm, dst); err != nil {
-		log.Error(err, "failed to send ProxyDHCP response")
-		span.SetStatus(codes.Error, err.Error())
 
-		return
+	return &an
+}
+
+// info returns the dhcp.Info of pkt, with the iPXE binary overrides, the binary of the boot profile,
+// secure boot and the ESXi boot loader of the boot profile applied.
+func (h *Handler) info(pkt *dhcpv4.DHCPv4, prof profile.Profile) dhcp.Info {
+	i := dhcp.NewInfo(pkt)
+	i.UseBinaries(h.Netboot.Binaries)
+	if prof.Binary != "" {
+		i.IPXEBinary = prof.Binary
 	}
-	log.Info("Sent ProxyDHCP response")
-	span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
-	span.SetStatus(codes.Ok, "sent DHCP response")
+	if h.Netboot.SecureBoot {
+		i.UseSecureBoot()
+	}
+	if prof.ESXi {
+		i.UseESXi()
+	}
+
+	return i
 }
 
 // encodeToAttributes takes a DHCP packet and returns opentelemetry key/value attributes.
@@ -243,6 +462,19 @@ func (h *Handler) encodeToAttributes(d *dhcpv4.DHCPv4, namespace string) []attri
 	return a.Encode(d, namespace, oteldhcp.AllEncoders()...)
 }
 
+// echoBootItem adds the PXE boot item (vendor sub option 71) of a boot server discovery request to its reply, firmware
+// that sends one ignores the replies without it.
+func echoBootItem(reply, pkt *dhcpv4.DHCPv4) {
+	req := dhcpv4.Options{}
+	if err := req.FromBytes(pkt.GetOneOption(dhcpv4.OptionVendorSpecificInformation)); err != nil || req[71] == nil {
+		return
+	}
+	vendor := dhcpv4.Options{}
+	_ = vendor.FromBytes(reply.GetOneOption(dhcpv4.OptionVendorSpecificInformation))
+	vendor[71] = req[71]
+	reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, vendor.ToBytes()))
+}
+
 func setMessageType(reply *dhcpv4.DHCPv4, reqMsg dhcpv4.MessageType) error {
 	switch mt := reqMsg; mt {
 	case dhcpv4.MessageTypeDiscover:
@@ -265,19 +497,3 @@ type IgnorePacketError struct {
 func (e IgnorePacketError) Error() string {
 	return fmt.Sprintf("Ignoring packet: message type %s: details %s", e.PacketType, e.Details)
 }
-
-// replyDestination determines the destination address for the DHCP reply.
-// If the giaddr is set, then the reply should be sent to the giaddr.
-// Otherwise, the reply should be sent to the direct peer.
-//
-// From page 22 of https://www.ietf.org/rfc/rfc2131.txt:
-// "If the 'giaddr' field in a DHCP message from a client is non-zero,
-// the server sends any return messages to the 'DHCP server' port on
-// the BOOTP relay agent whose address appears in 'giaddr'.".
-func replyDestination(directPeer net.Addr, giaddr net.IP) net.Addr {
-	if !giaddr.IsUnspecified() && giaddr != nil {
-		return &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}
-	}
-
-	return directPeer
-}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+func TestWindowsCompat(t *testing.T) {
+	h := &Handler{
+		IPAddr: netip.MustParseAddr("192.168.2.5"),
+		Log:    logr.Discard(),
+		Netboot: Netboot{
+			IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.5:69"),
+			IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "192.168.2.5:8080"},
+			IPXEScriptURL: func(*dhcpv4.DHCPv4) *url.URL {
+				return &url.URL{Scheme: "http", Host: "192.168.2.5", Path: "/auto.ipxe"}
+			},
+			Enabled: true,
+		},
+		AutoProxyEnabled: true,
+		WindowsCompat:    true,
+	}
+	request := func(mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		mods = append([]dhcpv4.Modifier{
+			dhcpv4.WithHwAddr(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}),
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003001")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+			dhcpv4.WithGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 1}),
+		}, mods...)
+		pkt, err := dhcpv4.New(mods...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkt
+	}
+
+	if _, err := h.Reply(context.Background(), request(dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 168, 2, 1))))); !errors.Is(err, ErrNoReply) {
+		t.Fatalf("got error %v, want the DHCPREQUEST for the DHCP server to be ignored", err)
+	}
+
+	bootItem := []byte{0x00, 0x00, 0x00, 0x00}
+	reply, err := h.Reply(context.Background(), request(
+		dhcpv4.WithClientIP(net.IPv4(192, 168, 2, 60)),
+		dhcpv4.WithGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{71: bootItem}.ToBytes()),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendor := dhcpv4.Options{}
+	if err := vendor.FromBytes(reply.GetOneOption(dhcpv4.OptionVendorSpecificInformation)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(dhcpv4.Options{6: []byte{8}, 71: bootItem}, vendor); diff != "" {
+		t.Fatal(diff)
+	}
+	if reply.MessageType() != dhcpv4.MessageTypeAck || reply.BootFileName != "ipxe.efi" {
+		t.Fatalf("got a %s with boot file %q, want an ACK with ipxe.efi", reply.MessageType(), reply.BootFileName)
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/tinkerbell/smee/internal/dhcp"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
 	oteldhcp "github.com/tinkerbell/smee/internal/dhcp/otel"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/policy"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -32,7 +37,7 @@ func (h *Handler) setDefaults() {
 }
 
 // Handle responds to DHCP messages with DHCP server options.
-func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Packet) {
+func (h *Handler) Handle(ctx context.Context, conn data.Conn, p data.Packet) {
 	h.setDefaults()
 	if p.Pkt == nil {
 		h.Log.Error(errors.New("incoming packet is nil"), "not able to respond when the incoming packet is nil")
@@ -51,6 +56,9 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		h.Log.Error(errors.New("connection is nil"), "not able to respond when the connection is nil")
 		return
 	}
+	if !h.LocalMACs.DHCP(p.Pkt) {
+		return
+	}
 
 	var ifName string
 	if p.Md != nil {
@@ -59,19 +67,31 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 	log := h.Log.WithValues("mac", p.Pkt.ClientHWAddr.String(), "xid", p.Pkt.TransactionID.String(), "interface", ifName)
 	tracer := otel.Tracer(tracerName)
 	var span trace.Span
-	ctx, span = tracer.Start(
-		ctx,
-		fmt.Sprintf("DHCP Packet Received: %v", p.Pkt.MessageType().String()),
-		trace.WithAttributes(h.encodeToAttributes(p.Pkt, "request")...),
-		trace.WithAttributes(attribute.String("DHCP.peer", p.Peer.String())),
-		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
-	)
-
+	var spanOpts []trace.SpanStartOption
+	if h.OTELNewRoot {
+		spanOpts = append(spanOpts, trace.WithNewRoot())
+	}
+	ctx, span = tracer.Start(ctx, "DHCP Packet Received: "+p.Pkt.MessageType().String(), spanOpts...)
 	defer span.End()
+	defer metric.ObserveDuration(ctx, metric.JobDuration.WithLabelValues("dhcp", "DHCP"+p.Pkt.MessageType().String()), time.Now())
+	ctx = h.Tenants.DHCPContext(ctx, p.Pkt)
+	handler.NotifyRequest(ctx, h.Observers, p.Pkt)
+	// Encoding the packet allocates, it is skipped when the span is not recorded.
+	if span.IsRecording() {
+		span.SetAttributes(h.encodeToAttributes(p.Pkt, "request")...)
+		span.SetAttributes(attribute.String("DHCP.peer", p.Peer.String()), attribute.String("DHCP.server.ifname", ifName))
+	}
 
 	var reply *dhcpv4.DHCPv4
+	var ack *data.DHCP
 	switch mt := p.Pkt.MessageType(); mt {
 	case dhcpv4.MessageTypeDiscover:
+		if reply = h.Transactions.Get(p); reply != nil {
+			log = log.WithValues("type", dhcpv4.MessageTypeOffer.String())
+			log.V(1).Info("received retransmitted DHCP packet, reusing the reply")
+			span.SetAttributes(attribute.Bool("DHCP.retransmission", true))
+			break
+		}
 		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr)
 		if err != nil {
 			if hardwareNotFound(err) {
@@ -90,9 +110,24 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 			return
 		}
 		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
+		n = h.authorize(log, d, n)
 		reply = h.updateMsg(ctx, p.Pkt, d, n, dhcpv4.MessageTypeOffer)
+		h.Transactions.Put(p, reply)
 		log = log.WithValues("type", dhcpv4.MessageTypeOffer.String())
 	case dhcpv4.MessageTypeRequest:
+		if h.forOtherServer(p.Pkt) {
+			log.Info("ignoring DHCP request for another server", "type", p.Pkt.MessageType().String(), "serverID", p.Pkt.ServerIdentifier().String())
+			span.SetStatus(codes.Ok, "request for another server")
+
+			return
+		}
+		// the DNS record of a retransmitted DHCPREQUEST was registered with its first reply.
+		if reply = h.Transactions.Get(p); reply != nil {
+			log = log.WithValues("type", dhcpv4.MessageTypeAck.String())
+			log.V(1).Info("received retransmitted DHCP packet, reusing the reply")
+			span.SetAttributes(attribute.Bool("DHCP.retransmission", true))
+			break
+		}
 		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr)
 		if err != nil {
 			if hardwareNotFound(err) {
@@ -111,7 +146,10 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 			return
 		}
 		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
+		n = h.authorize(log, d, n)
 		reply = h.updateMsg(ctx, p.Pkt, d, n, dhcpv4.MessageTypeAck)
+		h.Transactions.Put(p, reply)
+		ack = d
 		log = log.WithValues("type", dhcpv4.MessageTypeAck.String())
 	case dhcpv4.MessageTypeRelease:
 		// Since the design of this DHCP server is that all IP addresses are
@@ -136,13 +174,26 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		log = log.WithValues("nextServer", ns.String())
 	}
 
-	dst := replyDestination(p.Peer, p.Pkt.GatewayIPAddr)
+	h.ReplyPolicy.SetBroadcastFlag(reply)
+	dst := h.ReplyPolicy.Destination(p.Peer, p.Pkt, reply)
 	log = log.WithValues("ipAddress", reply.YourIPAddr.String(), "destination", dst.String())
 	cm := &ipv4.ControlMessage{}
 	if p.Md != nil {
 		cm.IfIndex = p.Md.IfIndex
 	}
 
+	if h.DryRun != nil && h.DryRun.Load() {
+		log.Info("dry run, DHCP response not sent")
+		span.SetStatus(codes.Ok, "dry run, DHCP response not sent")
+
+		return
+	}
+	if h.Faults.DropDHCP() {
+		log.Info("fault injection, DHCP response dropped")
+		span.SetStatus(codes.Ok, "fault injection, DHCP response dropped")
+
+		return
+	}
 	if _, err := conn.WriteTo(reply.ToBytes(), cm, dst); err != nil {
 		log.Error(err, "failed to send DHCP")
 		span.SetStatus(codes.Error, err.Error())
@@ -151,24 +202,58 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 	}
 
 	log.Info("sent DHCP response")
-	span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
+	handler.Notify(ctx, h.Observers, reply)
+	if span.IsRecording() {
+		span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
+	}
 	span.SetStatus(codes.Ok, "sent DHCP response")
+
+	if ack != nil && ack.Hostname != "" && h.DNS != nil {
+		if err := h.DNS.Register(ctx, ack.Hostname, ack.IPAddress, time.Duration(ack.LeaseTime)*time.Second); err != nil {
+			log.Info("unable to register dns record", "hostname", ack.Hostname, "error", err)
+		}
+	}
 }
 
-// replyDestination determines the destination address for the DHCP reply.
-// If the giaddr is set, then the reply should be sent to the giaddr.
-// Otherwise, the reply should be sent to the direct peer.
-//
-// From page 22 of https://www.ietf.org/rfc/rfc2131.txt:
-// "If the 'giaddr' field in a DHCP message from a client is non-zero,
-// the server sends any return messages to the 'DHCP server' port on
-// the BOOTP relay agent whose address appears in 'giaddr'.".
-func replyDestination(directPeer net.Addr, giaddr net.IP) net.Addr {
-	if !giaddr.IsUnspecified() && giaddr != nil {
-		return &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}
+// ErrNoReply is returned by Reply when no reply is sent for a DHCP message.
+var ErrNoReply = errors.New("no DHCP reply")
+
+// Reply returns the DHCPOFFER or DHCPACK that is sent for a DHCPDISCOVER or DHCPREQUEST, without sending it.
+// It returns an ErrNoReply error when the machine is not found in the backend, it has DHCP disabled or the
+// message type is not a DHCPDISCOVER or DHCPREQUEST.
+func (h *Handler) Reply(ctx context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	h.setDefaults()
+	if !h.LocalMACs.DHCP(pkt) {
+		return nil, fmt.Errorf("%w: %s is a locally administered MAC address", ErrNoReply, pkt.ClientHWAddr)
+	}
+	ctx = h.Tenants.DHCPContext(ctx, pkt)
+	var mt dhcpv4.MessageType
+	switch pkt.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		mt = dhcpv4.MessageTypeOffer
+	case dhcpv4.MessageTypeRequest:
+		if h.forOtherServer(pkt) {
+			return nil, fmt.Errorf("%w: request for another server %s", ErrNoReply, pkt.ServerIdentifier())
+		}
+		mt = dhcpv4.MessageTypeAck
+	default:
+		return nil, fmt.Errorf("%w: unhandled message type %s", ErrNoReply, pkt.MessageType())
+	}
+	d, n, err := h.readBackend(ctx, pkt.ClientHWAddr)
+	if err != nil {
+		if hardwareNotFound(err) {
+			return nil, fmt.Errorf("%w: %w", ErrNoReply, err)
+		}
+		return nil, err
 	}
+	if d.Disabled {
+		return nil, fmt.Errorf("%w: DHCP is disabled for this MAC address", ErrNoReply)
+	}
+	n = h.authorize(h.Log.WithValues("mac", pkt.ClientHWAddr.String()), d, n)
+	reply := h.updateMsg(ctx, pkt, d, n, mt)
+	h.ReplyPolicy.SetBroadcastFlag(reply)
 
-	return directPeer
+	return reply, nil
 }
 
 // readBackend encapsulates the backend read and opentelemetry handling.
@@ -186,25 +271,59 @@ func (h *Handler) readBackend(ctx context.Context, mac net.HardwareAddr) (*data.
 		return nil, nil, err
 	}
 
-	span.SetAttributes(d.EncodeToAttributes()...)
-	span.SetAttributes(n.EncodeToAttributes()...)
+	if span.IsRecording() {
+		span.SetAttributes(d.EncodeToAttributes()...)
+		span.SetAttributes(n.EncodeToAttributes()...)
+	}
 	span.SetStatus(codes.Ok, "done reading from backend")
 
 	return d, n, nil
 }
 
+// authorize applies the netboot policy, if any, to the backend netboot data.
+func (h *Handler) authorize(log logr.Logger, d *data.DHCP, n *data.Netboot) *data.Netboot {
+	if h.Policy == nil || n == nil {
+		return n
+	}
+	dec := h.Policy.Evaluate(policy.Request{
+		Source:       policy.SourceDHCP,
+		MAC:          d.MACAddress,
+		Arch:         d.Arch,
+		IP:           d.IPAddress,
+		Labels:       n.Labels,
+		AllowNetboot: n.AllowNetboot,
+	})
+	if dec.Rule != "" {
+		log.Info("netboot policy rule matched", "rule", dec.Rule, "allow", dec.Allow)
+	}
+	an := *n
+	an.AllowNetboot = dec.Allow
+	if dec.BootTarget != nil {
+		an.IPXEScriptURL = dec.BootTarget
+	}
+
+	return &an
+}
+
 // updateMsg handles updating DHCP packets with the data from the backend.
 func (h *Handler) updateMsg(ctx context.Context, pkt *dhcpv4.DHCPv4, d *data.DHCP, n *data.Netboot, msgType dhcpv4.MessageType) *dhcpv4.DHCPv4 {
 	h.setDefaults()
 	mods := []dhcpv4.Modifier{
 		dhcpv4.WithMessageType(msgType),
-		dhcpv4.WithGeneric(dhcpv4.OptionServerIdentifier, h.IPAddr.AsSlice()),
+		dhcpv4.WithGeneric(dhcpv4.OptionServerIdentifier, h.serverID().AsSlice()),
 		dhcpv4.WithServerIP(h.IPAddr.AsSlice()),
 	}
 	mods = append(mods, h.setDHCPOpts(ctx, pkt, d)...)
+	if n != nil {
+		if a := h.Facilities.Get(n.Facility).SyslogAddr(); a.IsValid() {
+			mods = append(mods, dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionLogServer, a.AsSlice())))
+		}
+	}
 
 	if h.Netboot.Enabled && dhcp.IsNetbootClient(pkt) == nil {
 		mods = append(mods, h.setNetworkBootOpts(ctx, pkt, n))
+	} else if h.Netboot.Enabled && h.Netboot.UBoot && dhcp.IsUBootClient(pkt) {
+		mods = append(mods, h.setUBootOpts(pkt, n))
 	}
 	// We ignore the error here because:
 	// 1. it's only non-nil if the generation of a transaction id (XID) fails.
@@ -214,6 +333,27 @@ func (h *Handler) updateMsg(ctx context.Context, pkt *dhcpv4.DHCPv4, d *data.DHC
 	return reply
 }
 
+// serverID returns the server identifier (option 54) of the replies.
+func (h *Handler) serverID() netip.Addr {
+	if h.ServerID.IsValid() {
+		return h.ServerID
+	}
+
+	return h.IPAddr
+}
+
+// forOtherServer returns whether pkt has the server identifier of another server.
+// A DHCPREQUEST in the INIT-REBOOT, RENEWING or REBINDING states has no server identifier and is for any server.
+func (h *Handler) forOtherServer(pkt *dhcpv4.DHCPv4) bool {
+	sid := pkt.ServerIdentifier()
+	if sid == nil || sid.IsUnspecified() {
+		return false
+	}
+	a, ok := netip.AddrFromSlice(sid.To4())
+
+	return !ok || a != h.serverID()
+}
+
 // encodeToAttributes takes a DHCP packet and returns opentelemetry key/value attributes.
 func (h *Handler) encodeToAttributes(d *dhcpv4.DHCPv4, namespace string) []attribute.KeyValue {
 	h.setDefaults()
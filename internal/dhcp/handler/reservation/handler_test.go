@@ -9,6 +9,7 @@ import (
 	"net/netip"
 	"net/url"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,8 +19,12 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/tinkerbell/smee/internal/chaos"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 	"github.com/tinkerbell/smee/internal/dhcp/otel"
+	"github.com/tinkerbell/smee/internal/facility"
+	"github.com/tinkerbell/smee/internal/localmac"
+	"github.com/tinkerbell/smee/internal/metric"
 	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/nettest"
@@ -27,6 +32,11 @@ import (
 
 var errBadBackend = fmt.Errorf("bad backend")
 
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
 type mockBackend struct {
 	err              error
 	allowNetboot     bool
@@ -81,6 +91,12 @@ func (m *mockBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboo
 }
 
 func TestHandle(t *testing.T) {
+	dryRun := &atomic.Bool{}
+	dryRun.Store(true)
+	faults := &chaos.Injector{}
+	if err := faults.Set(chaos.Faults{DHCPDropPercent: 100}); err != nil {
+		t.Fatal(err)
+	}
 	tests := map[string]struct {
 		server  Handler
 		req     *dhcpv4.DHCPv4
@@ -308,6 +324,44 @@ func TestHandle(t *testing.T) {
 			want:    nil,
 			wantErr: errBadBackend,
 		},
+		"dry run sends no reply": {
+			server: Handler{
+				Backend: &mockBackend{
+					allowNetboot: true,
+					ipxeScript:   &url.URL{Scheme: "http", Host: "localhost:8181", Path: "auto.ipxe"},
+				},
+				IPAddr: netip.MustParseAddr("127.0.0.1"),
+				DryRun: dryRun,
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+				),
+			},
+			want:    nil,
+			wantErr: errBadBackend,
+		},
+		"dropped reply fault sends no reply": {
+			server: Handler{
+				Backend: &mockBackend{
+					allowNetboot: true,
+					ipxeScript:   &url.URL{Scheme: "http", Host: "localhost:8181", Path: "auto.ipxe"},
+				},
+				IPAddr: netip.MustParseAddr("127.0.0.1"),
+				Faults: faults,
+			},
+			req: &dhcpv4.DHCPv4{
+				OpCode:       dhcpv4.OpcodeBootRequest,
+				ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+				),
+			},
+			want:    nil,
+			wantErr: errBadBackend,
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -435,6 +489,136 @@ func TestUpdateMsg(t *testing.T) {
 	}
 }
 
+func TestUpdateMsgFacilitySyslog(t *testing.T) {
+	h := &Handler{
+		Log:        stdr.New(log.New(os.Stdout, "", log.Lshortfile)),
+		IPAddr:     netip.MustParseAddr("127.0.0.1"),
+		SyslogAddr: netip.MustParseAddr("127.0.0.1"),
+		Facilities: &facility.Config{Facilities: map[string]facility.Override{"sjc1": {SyslogIP: "10.1.0.7"}}},
+	}
+	pkt, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		netboot *data.Netboot
+		want    net.IP
+	}{
+		"facility override": {netboot: &data.Netboot{Facility: "sjc1"}, want: net.IP{10, 1, 0, 7}},
+		"no override":       {netboot: &data.Netboot{Facility: "ams2"}, want: net.IP{127, 0, 0, 1}},
+		"no netboot data":   {want: net.IP{127, 0, 0, 1}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := h.updateMsg(context.Background(), pkt, &data.DHCP{IPAddress: netip.MustParseAddr("192.168.1.100")}, tt.netboot, dhcpv4.MessageTypeOffer)
+			if diff := cmp.Diff(net.IP(got.Options.Get(dhcpv4.OptionLogServer)), tt.want); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestReply(t *testing.T) {
+	tests := map[string]struct {
+		backend   *mockBackend
+		msgType   dhcpv4.MessageType
+		wantType  dhcpv4.MessageType
+		wantErr   error
+		shouldErr bool
+	}{
+		"discover":       {backend: &mockBackend{}, msgType: dhcpv4.MessageTypeDiscover, wantType: dhcpv4.MessageTypeOffer},
+		"request":        {backend: &mockBackend{}, msgType: dhcpv4.MessageTypeRequest, wantType: dhcpv4.MessageTypeAck},
+		"release":        {backend: &mockBackend{}, msgType: dhcpv4.MessageTypeRelease, wantErr: ErrNoReply},
+		"not found":      {backend: &mockBackend{hardwareNotFound: true}, msgType: dhcpv4.MessageTypeDiscover, wantErr: ErrNoReply},
+		"backend errors": {backend: &mockBackend{err: errBadBackend}, msgType: dhcpv4.MessageTypeDiscover, wantErr: errBadBackend},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Backend: tt.backend, IPAddr: netip.MustParseAddr("192.168.1.1"), Log: stdr.New(log.New(os.Stdout, "", log.Lshortfile))}
+			pkt, err := dhcpv4.New(dhcpv4.WithMessageType(tt.msgType), dhcpv4.WithHwAddr(net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := h.Reply(context.Background(), pkt)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.MessageType() != tt.wantType {
+				t.Fatalf("got message type %s, want %s", got.MessageType(), tt.wantType)
+			}
+			if got.YourIPAddr.String() != "192.168.1.100" {
+				t.Fatalf("got yiaddr %s, want 192.168.1.100", got.YourIPAddr)
+			}
+		})
+	}
+}
+
+func TestReplyLocalMAC(t *testing.T) {
+	h := &Handler{Backend: &mockBackend{}, IPAddr: netip.MustParseAddr("192.168.1.1"), LocalMACs: &localmac.Policy{Action: localmac.Deny}}
+	pkt, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Reply(context.Background(), pkt); !errors.Is(err, ErrNoReply) {
+		t.Fatalf("got error %v, want %v for a locally administered MAC address", err, ErrNoReply)
+	}
+	pkt.ClientHWAddr = net.HardwareAddr{0x00, 0x42, 0xac, 0x11, 0x00, 0x02}
+	if _, err := h.Reply(context.Background(), pkt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerID(t *testing.T) {
+	tests := map[string]struct {
+		serverID     netip.Addr
+		requestID    net.IP
+		wantErr      error
+		wantServerID string
+	}{
+		"ip addr":                       {wantServerID: "192.168.1.1"},
+		"server id":                     {serverID: netip.MustParseAddr("192.168.1.2"), wantServerID: "192.168.1.2"},
+		"request for this server":       {serverID: netip.MustParseAddr("192.168.1.2"), requestID: net.IPv4(192, 168, 1, 2), wantServerID: "192.168.1.2"},
+		"request for the ip addr":       {requestID: net.IPv4(192, 168, 1, 1), wantServerID: "192.168.1.1"},
+		"request for another server":    {requestID: net.IPv4(192, 168, 1, 3), wantErr: ErrNoReply},
+		"request for the ip addr by id": {serverID: netip.MustParseAddr("192.168.1.2"), requestID: net.IPv4(192, 168, 1, 1), wantErr: ErrNoReply},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Backend: &mockBackend{}, IPAddr: netip.MustParseAddr("192.168.1.1"), ServerID: tt.serverID}
+			mods := []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest), dhcpv4.WithHwAddr(net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06})}
+			if tt.requestID != nil {
+				mods = append(mods, dhcpv4.WithOption(dhcpv4.OptServerIdentifier(tt.requestID)))
+			}
+			pkt, err := dhcpv4.New(mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := h.Reply(context.Background(), pkt)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ServerIdentifier().String() != tt.wantServerID {
+				t.Fatalf("got server identifier %s, want %s", got.ServerIdentifier(), tt.wantServerID)
+			}
+			if got.ServerIPAddr.String() != "192.168.1.1" {
+				t.Fatalf("got siaddr %s, want 192.168.1.1", got.ServerIPAddr)
+			}
+		})
+	}
+}
+
 func TestOne(t *testing.T) {
 	t.Skip()
 	h := &Handler{}
@@ -548,3 +732,43 @@ func TestEncodeToAttributes(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkHandle(b *testing.B) {
+	s := Handler{
+		Backend: &mockBackend{allowNetboot: true},
+		IPAddr:  netip.MustParseAddr("127.0.0.1"),
+		Netboot: Netboot{
+			Enabled:           true,
+			IPXEBinServerTFTP: netip.MustParseAddrPort("127.0.0.1:69"),
+			IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "127.0.0.1:8080", Path: "/ipxe/"},
+		},
+	}
+	conn, err := nettest.NewLocalPacketListener("udp")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pc.Close()
+	req := &dhcpv4.DHCPv4{
+		OpCode:       dhcpv4.OpcodeBootRequest,
+		ClientHWAddr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+		Options: dhcpv4.OptionsFromList(
+			dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003001"),
+			dhcpv4.OptClientArch(iana.EFI_X86_64),
+			dhcpv4.OptGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 0}),
+		),
+	}
+	p := data.Packet{Peer: pc.LocalAddr(), Pkt: req, Md: &data.Metadata{}}
+	con := ipv4.NewPacketConn(conn)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		s.Handle(context.Background(), con, p)
+	}
+}
@@ -2,7 +2,6 @@ package reservation
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
@@ -12,7 +11,11 @@ import (
 	"github.com/tinkerbell/smee/internal/dhcp"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 	dhcpotel "github.com/tinkerbell/smee/internal/dhcp/otel"
+	"github.com/tinkerbell/smee/internal/metric"
 	"github.com/tinkerbell/smee/internal/otel"
+	"github.com/tinkerbell/smee/internal/profile"
+	"github.com/tinkerbell/smee/internal/tenant"
+	"github.com/tinkerbell/smee/internal/uboot"
 )
 
 // setDHCPOpts takes a client dhcp packet and data (typically from a backend) and creates a slice of DHCP packet modifiers.
@@ -79,19 +82,30 @@ func (h *Handler) setNetworkBootOpts(ctx context.Context, m *dhcpv4.DHCPv4, n *d
 		d.BootFileName = "/netboot-not-allowed"
 		d.ServerIPAddr = net.IPv4(0, 0, 0, 0)
 		if n.AllowNetboot {
-			i := dhcp.NewInfo(m)
+			i := h.info(m)
 			if i.IPXEBinary == "" {
+				h.Log.Info("no iPXE binary for the client architecture, not sending netboot options", "mac", i.Mac, "arch", i.Arch.String(), "archCode", uint16(i.Arch))
+				metric.DHCPUnsupportedArch.WithLabelValues(i.Arch.String()).Inc()
 				return
 			}
 			var ipxeScript *url.URL
 			// If the global IPXEScriptURL is set, use that.
 			if h.Netboot.IPXEScriptURL != nil {
-				ipxeScript = h.Netboot.IPXEScriptURL(m)
+				name, _ := tenant.FromContext(ctx)
+				ipxeScript = h.Tenants.URL(name, h.Netboot.IPXEScriptURL(m))
+			}
+			// If the boot profile of the client has an IPXE script URL, use that.
+			if p, ok := h.profile(i); ok {
+				h.Log.V(1).Info("boot profile selected", "mac", i.Mac, "profile", p.Name)
+				if u := p.IPXEScriptURL(); u != nil {
+					ipxeScript = u
+				}
 			}
 			// If the IPXE script URL is set on the hardware record, use that.
 			if n.IPXEScriptURL != nil {
 				ipxeScript = n.IPXEScriptURL
 			}
+			ipxeScript = h.Mirrors.SelectURL(m.ClientHWAddr, ipxeScript)
 			d.BootFileName, d.ServerIPAddr = h.bootfileAndNextServer(ctx, m, h.Netboot.UserClass, h.Netboot.IPXEBinServerTFTP, h.Netboot.IPXEBinServerHTTP, ipxeScript)
 			pxe := dhcpv4.Options{ // FYI, these are suboptions of option43. ref: https://datatracker.ietf.org/doc/html/rfc2132#section-8.4
 				// PXE Boot Server Discovery Control - bypass, just boot from filename.
@@ -99,24 +113,64 @@ func (h *Handler) setNetworkBootOpts(ctx context.Context, m *dhcpv4.DHCPv4, n *d
 				69: dhcpotel.TraceparentFromContext(ctx),
 			}
 			d.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, i.AddRPIOpt43(pxe)))
+			if it, ok := h.Menu.Apply(d, m); ok {
+				h.Log.Info("PXE menu item selected", "mac", i.Mac, "item", it.Description)
+			}
 		}
 	}
 
 	return withNetboot
 }
 
+// setUBootOpts sets the boot file and the next server of a U-Boot client to its boot script on the TFTP server.
+// U-Boot loads the boot file with TFTP from the next server, a machine that is not allowed to netboot is sent neither.
+func (h *Handler) setUBootOpts(m *dhcpv4.DHCPv4, n *data.Netboot) dhcpv4.Modifier {
+	return func(d *dhcpv4.DHCPv4) {
+		if n == nil || !n.AllowNetboot {
+			return
+		}
+		d.BootFileName = uboot.Bootfile(m.ClientHWAddr)
+		d.ServerIPAddr = net.IP(h.Netboot.IPXEBinServerTFTP.Addr().AsSlice())
+	}
+}
+
 // bootfileAndNextServer returns the bootfile (string) and next server (net.IP).
 // input arguments `tftp`, `ipxe` and `iscript` use non string types so as to attempt to be more clear about the expectation around what is wanted for these values.
 // It also helps us avoid having to validate a string in multiple ways.
 func (h *Handler) bootfileAndNextServer(ctx context.Context, pkt *dhcpv4.DHCPv4, customUC dhcp.UserClass, tftp netip.AddrPort, ipxe, iscript *url.URL) (string, net.IP) {
 	var nextServer net.IP
 	var bootfile string
-	i := dhcp.NewInfo(pkt)
-	if tp := otel.TraceparentStringFromContext(ctx); h.OTELEnabled && tp != "" {
-		i.IPXEBinary = fmt.Sprintf("%s-%v", i.IPXEBinary, tp)
+	i := h.info(pkt)
+	if h.Netboot.SecureBoot {
+		i.UseSecureBoot()
+	}
+	// mboot.efi is signed, the ESXi boot loader takes precedence over shim.
+	if p, ok := h.profile(i); ok && p.ESXi {
+		i.UseESXi()
+	}
+	if h.OTELEnabled {
+		if tp := otel.TraceparentStringFromContext(ctx); tp != "" {
+			i.IPXEBinary = i.IPXEBinary + "-" + tp
+		}
 	}
 	nextServer = i.NextServer(ipxe, tftp)
 	bootfile = i.Bootfile(customUC, iscript, ipxe, tftp)
 
 	return bootfile, nextServer
 }
+
+// info returns the dhcp.Info of pkt, with the iPXE binary overrides and the binary of the boot profile applied.
+func (h *Handler) info(pkt *dhcpv4.DHCPv4) dhcp.Info {
+	i := dhcp.NewInfo(pkt)
+	i.UseBinaries(h.Netboot.Binaries)
+	if p, ok := h.profile(i); ok && p.Binary != "" {
+		i.IPXEBinary = p.Binary
+	}
+
+	return i
+}
+
+// profile returns the boot profile of the client of i.
+func (h *Handler) profile(i dhcp.Info) (profile.Profile, bool) {
+	return h.Profiles.Select(i.Mac, i.UserClassFrom().String(), string(i.Pkt.GetOneOption(dhcpv4.OptionClassIdentifier)))
+}
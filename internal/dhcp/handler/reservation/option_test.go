@@ -18,6 +18,7 @@ import (
 	"github.com/tinkerbell/smee/internal/dhcp/data"
 	oteldhcp "github.com/tinkerbell/smee/internal/dhcp/otel"
 	dhcpotel "github.com/tinkerbell/smee/internal/otel"
+	"github.com/tinkerbell/smee/internal/profile"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 )
@@ -294,6 +295,56 @@ func TestSetNetworkBootOpts(t *testing.T) {
 				dhcpv4.OptClassIdentifier("HTTPClient"),
 			)},
 		},
+		"boot profile script": {
+			server: &Handler{Log: logr.Discard(), Profiles: mustParseProfiles(t, "profiles: [{name: rescue, match: {vendorClass: ['HTTPClient:*']}, scriptURL: 'http://localhost:8181/rescue.ipxe'}]"), Netboot: Netboot{IPXEScriptURL: func(*dhcpv4.DHCPv4) *url.URL {
+				return &url.URL{Scheme: "http", Host: "localhost:8181", Path: "/auto.ipxe"}
+			}}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptUserClass(dhcp.Tinkerbell.String()),
+						dhcpv4.OptClassIdentifier("HTTPClient:xxxxx"),
+						dhcpv4.OptClientArch(iana.EFI_X86_64_HTTP),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{BootFileName: "http://localhost:8181/rescue.ipxe", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+				dhcpv4.OptClassIdentifier("HTTPClient"),
+			)},
+		},
+		"esxi boot profile": {
+			server: &Handler{Log: logr.Discard(), Profiles: mustParseProfiles(t, "profiles: [{name: esxi, match: {vendorClass: ['HTTPClient:*']}, esxi: true}]"), Netboot: Netboot{
+				IPXEBinServerHTTP: &url.URL{Scheme: "http", Host: "localhost:8181", Path: "/ipxe"},
+				IPXEScriptURL: func(*dhcpv4.DHCPv4) *url.URL {
+					return &url.URL{Scheme: "http", Host: "localhost:8181", Path: "/auto.ipxe"}
+				},
+			}},
+			args: args{
+				in0: context.Background(),
+				m: &dhcpv4.DHCPv4{
+					ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+					Options: dhcpv4.OptionsFromList(
+						dhcpv4.OptClassIdentifier("HTTPClient:Arch:00016"),
+						dhcpv4.OptClientArch(iana.EFI_X86_64_HTTP),
+					),
+				},
+				n: &data.Netboot{AllowNetboot: true},
+			},
+			want: &dhcpv4.DHCPv4{BootFileName: "http://localhost:8181/ipxe/01:02:03:04:05:06/mboot.efi", Options: dhcpv4.OptionsFromList(
+				dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{
+					6:  []byte{8},
+					69: oteldhcp.TraceparentFromContext(context.Background()),
+				}.ToBytes()),
+				dhcpv4.OptClassIdentifier("HTTPClient"),
+			)},
+		},
 		"netboot not allowed, arch unknown": {
 			server: &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEScriptURL: func(*dhcpv4.DHCPv4) *url.URL {
 				return &url.URL{Scheme: "http", Host: "localhost:8181", Path: "/01:02:03:04:05:06/auto.ipxe"}
@@ -323,8 +374,9 @@ func TestSetNetworkBootOpts(t *testing.T) {
 					Enabled:           tt.server.Netboot.Enabled,
 					UserClass:         tt.server.Netboot.UserClass,
 				},
-				IPAddr:  tt.server.IPAddr,
-				Backend: tt.server.Backend,
+				IPAddr:   tt.server.IPAddr,
+				Backend:  tt.server.Backend,
+				Profiles: tt.server.Profiles,
 			}
 			gotFunc := s.setNetworkBootOpts(tt.args.in0, tt.args.m, tt.args.n)
 			got := new(dhcpv4.DHCPv4)
@@ -335,3 +387,31 @@ func TestSetNetworkBootOpts(t *testing.T) {
 		})
 	}
 }
+
+func TestSetUBootOpts(t *testing.T) {
+	h := &Handler{Log: logr.Discard(), Netboot: Netboot{IPXEBinServerTFTP: netip.MustParseAddrPort("192.168.2.5:69"), UBoot: true}}
+	m := &dhcpv4.DHCPv4{ClientHWAddr: net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}}
+
+	got := new(dhcpv4.DHCPv4)
+	h.setUBootOpts(m, &data.Netboot{AllowNetboot: true})(got)
+	want := &dhcpv4.DHCPv4{BootFileName: "01:02:03:04:05:06/boot.scr.uimg", ServerIPAddr: net.IP{192, 168, 2, 5}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	got = new(dhcpv4.DHCPv4)
+	h.setUBootOpts(m, &data.Netboot{AllowNetboot: false})(got)
+	if diff := cmp.Diff(new(dhcpv4.DHCPv4), got); diff != "" {
+		t.Fatalf("expected no boot file for a machine that is not allowed to netboot: %s", diff)
+	}
+}
+
+func mustParseProfiles(t *testing.T, config string) *profile.Config {
+	t.Helper()
+	c, err := profile.Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
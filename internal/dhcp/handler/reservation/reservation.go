@@ -2,13 +2,25 @@
 package reservation
 
 import (
+	"context"
 	"net/netip"
 	"net/url"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/chaos"
 	"github.com/tinkerbell/smee/internal/dhcp"
 	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/facility"
+	"github.com/tinkerbell/smee/internal/localmac"
+	"github.com/tinkerbell/smee/internal/mirror"
+	"github.com/tinkerbell/smee/internal/policy"
+	"github.com/tinkerbell/smee/internal/profile"
+	"github.com/tinkerbell/smee/internal/pxemenu"
+	"github.com/tinkerbell/smee/internal/tenant"
 )
 
 // Handler holds the configuration details for the running the DHCP server.
@@ -21,6 +33,11 @@ type Handler struct {
 	// This could be a load balancer IP address or an ingress IP address or a local IP address.
 	IPAddr netip.Addr
 
+	// ServerID, when valid, is the server identifier (option 54) of the replies, in place of IPAddr.
+	// A DHCPREQUEST with the server identifier of another server is ignored, the client selected the offer of that server.
+	// Set it when Smee shares a network with other DHCP servers and IPAddr isn't unique to this server, for example a load balancer IP address.
+	ServerID netip.Addr
+
 	// Log is used to log messages.
 	// `logr.Discard()` can be used if no logging is desired.
 	Log logr.Logger
@@ -34,8 +51,58 @@ type Handler struct {
 	// <original filename>-00-<trace id>-<span id>-<trace flags>
 	OTELEnabled bool
 
+	// OTELNewRoot starts a new trace for every DHCP message, in place of continuing the trace of the server context.
+	// With an otel.BootTraces Observer, the later requests of the boot chain of a machine continue this trace.
+	OTELNewRoot bool
+
 	// SyslogAddr is the address to send syslog messages to. DHCP Option 7.
 	SyslogAddr netip.Addr
+
+	// Facilities, when set, overrides SyslogAddr for machines by facility.
+	Facilities *facility.Config
+
+	// Tenants, when set, maps DHCP messages to their tenant, see tenant.Config.DHCPContext, and adds the URL prefix
+	// of the tenant to the iPXE script URL. The Backend should be scoped to the tenants, see tenant.Config.Scope.
+	Tenants *tenant.Config
+
+	// Profiles, when set, overrides the iPXE binary and script URL of clients by their user class and vendor class.
+	Profiles *profile.Config
+
+	// Mirrors, when set, answers the iPXE script URL of machines with a healthy candidate of its mirror group.
+	Mirrors *mirror.Config
+
+	// Menu, when set, is the PXE boot menu that the firmware of PXE clients shows before iPXE is loaded.
+	Menu *pxemenu.Menu
+
+	// Policy, when set, decides whether a machine is allowed to netboot and the iPXE script it is sent to.
+	Policy *policy.Policy
+
+	// LocalMACs, when set, is what is done with the DHCP messages of locally administered MAC addresses, see the
+	// localmac package. The Backend should have it applied too, see localmac.Policy.Backend.
+	LocalMACs *localmac.Policy
+
+	// DNS, when set, registers a DNS record for the hostname of machines that are sent a DHCPACK.
+	DNS DNSRegistrar
+
+	// DryRun, when set and true, handles DHCP messages as usual but doesn't send the replies.
+	DryRun *atomic.Bool
+
+	// Faults, when set, drops a percentage of the replies to test the resilience of provisioning.
+	Faults *chaos.Injector
+
+	// Transactions, when set, reuses the reply to a DHCPDISCOVER or DHCPREQUEST for its retransmissions.
+	Transactions *dhcp.Transactions
+
+	// ReplyPolicy decides the broadcast flag of the replies and the address they are sent to.
+	ReplyPolicy dhcp.ReplyPolicy
+
+	// Observers are notified after a DHCP reply has been sent to a machine.
+	Observers []handler.Observer
+}
+
+// DNSRegistrar registers hostname to IP address records for the duration of a lease.
+type DNSRegistrar interface {
+	Register(ctx context.Context, hostname string, ip netip.Addr, leaseTime time.Duration) error
 }
 
 // Netboot holds the netboot configuration details used in running a DHCP server.
@@ -54,4 +121,13 @@ type Netboot struct {
 
 	// UserClass (for network booting) allows a custom DHCP option 77 to be used to break out of an iPXE loop.
 	UserClass dhcp.UserClass
+
+	// SecureBoot, when true, points UEFI clients at the Secure Boot shim binary instead of the iPXE binary.
+	SecureBoot bool
+
+	// Binaries, when set, overrides the iPXE binary of client architectures, see dhcp.ArchToBootFile.
+	Binaries map[iana.Arch]string
+
+	// UBoot, when true, points U-Boot clients, see dhcp.IsUBootClient, at their boot script on the TFTP server, see the uboot package.
+	UBoot bool
 }
@@ -0,0 +1,122 @@
+// Package lease keeps a table of the IP addresses that DHCP replies leased to machines.
+package lease
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTime is how long a lease, whose DHCP reply has no lease time, is kept.
+const DefaultLeaseTime = time.Hour
+
+// pruneInterval is the minimum time between two prunings of the expired leases.
+const pruneInterval = time.Minute
+
+// Table records the MAC address of the machine that every IP address was leased to, until the lease expires.
+// It implements handler.LeaseObserver. The zero value is ready to use, a nil Table records nothing.
+type Table struct {
+	mu     sync.Mutex
+	leases map[netip.Addr]lease
+	pruned time.Time
+}
+
+type lease struct {
+	mac     net.HardwareAddr
+	expires time.Time
+}
+
+// DHCPServed implements handler.Observer.
+func (t *Table) DHCPServed(context.Context, net.HardwareAddr, string) {}
+
+// DHCPLeased implements handler.LeaseObserver. It records that ip was leased to mac for leaseTime.
+func (t *Table) DHCPLeased(_ context.Context, mac net.HardwareAddr, ip net.IP, leaseTime time.Duration) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if t == nil || !ok {
+		return
+	}
+	if leaseTime <= 0 {
+		leaseTime = DefaultLeaseTime
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.leases == nil {
+		t.leases = map[netip.Addr]lease{}
+	}
+	if now.Sub(t.pruned) > pruneInterval {
+		for k, l := range t.leases {
+			if now.After(l.expires) {
+				delete(t.leases, k)
+			}
+		}
+		t.pruned = now
+	}
+	t.leases[addr.Unmap()] = lease{mac: mac, expires: now.Add(leaseTime)}
+}
+
+// MAC returns the MAC address of the machine that ip is leased to.
+// ok is false when ip is not leased.
+func (t *Table) MAC(ip net.IP) (mac net.HardwareAddr, ok bool) {
+	addr, valid := netip.AddrFromSlice(ip)
+	if t == nil || !valid {
+		return nil, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, found := t.leases[addr.Unmap()]
+	if !found || time.Now().After(l.expires) {
+		return nil, false
+	}
+
+	return l.mac, true
+}
+
+// Lease is an IP address that was leased to a machine.
+type Lease struct {
+	IP      netip.Addr
+	MAC     net.HardwareAddr
+	Expires time.Time
+}
+
+// List returns the leases sorted by IP address, with the expired leases that were not pruned yet.
+func (t *Table) List() []Lease {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ls := make([]Lease, 0, len(t.leases))
+	for ip, l := range t.leases {
+		ls = append(ls, Lease{IP: ip, MAC: l.mac, Expires: l.expires})
+	}
+	slices.SortFunc(ls, func(a, b Lease) int { return a.IP.Compare(b.IP) })
+
+	return ls
+}
+
+// Merge records the leases of ls that expire after the lease of their IP address in t, like the leases of another
+// Smee replica. Expired leases are ignored.
+func (t *Table) Merge(ls ...Lease) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.leases == nil {
+		t.leases = map[netip.Addr]lease{}
+	}
+	for _, l := range ls {
+		if !l.IP.IsValid() || now.After(l.Expires) {
+			continue
+		}
+		if cur, ok := t.leases[l.IP.Unmap()]; ok && !l.Expires.After(cur.expires) {
+			continue
+		}
+		t.leases[l.IP.Unmap()] = lease{mac: append(net.HardwareAddr(nil), l.MAC...), expires: l.Expires}
+	}
+}
@@ -0,0 +1,70 @@
+package lease
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTable(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tb := &Table{}
+	if _, ok := tb.MAC(net.IPv4(192, 168, 2, 153)); ok {
+		t.Fatal("expected no lease before a DHCP reply")
+	}
+
+	tb.DHCPLeased(context.Background(), mac, net.IPv4(192, 168, 2, 153), 0)
+	got, ok := tb.MAC(net.IPv4(192, 168, 2, 153).To4())
+	if !ok {
+		t.Fatal("expected the lease of the DHCP reply")
+	}
+	if diff := cmp.Diff(mac, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	tb.DHCPLeased(context.Background(), mac, net.IPv4(192, 168, 2, 154), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := tb.MAC(net.IPv4(192, 168, 2, 154)); ok {
+		t.Fatal("expected an expired lease to not be returned")
+	}
+
+	ls := tb.List()
+	if len(ls) != 2 || ls[0].IP.String() != "192.168.2.153" || ls[1].IP.String() != "192.168.2.154" {
+		t.Fatalf("expected both leases sorted by IP address, got %v", ls)
+	}
+	if !ls[1].Expires.Before(time.Now()) {
+		t.Fatal("expected the second lease to be expired")
+	}
+
+	var nt *Table
+	nt.DHCPLeased(context.Background(), mac, net.IPv4(192, 168, 2, 153), 0)
+	if _, ok := nt.MAC(net.IPv4(192, 168, 2, 153)); ok {
+		t.Fatal("expected a nil Table to record nothing")
+	}
+	if nt.List() != nil {
+		t.Fatal("expected a nil Table to list nothing")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	mac, other := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+	ip := netip.MustParseAddr("192.168.2.153")
+	tb := &Table{}
+	tb.DHCPLeased(context.Background(), mac, ip.AsSlice(), time.Hour)
+
+	tb.Merge(Lease{IP: ip, MAC: other, Expires: time.Now().Add(time.Minute)}, Lease{IP: netip.MustParseAddr("192.168.2.154"), MAC: other, Expires: time.Now().Add(-time.Minute)})
+	if got, _ := tb.MAC(ip.AsSlice()); got.String() != mac.String() {
+		t.Fatalf("got %s, want the lease that expires last to win", got)
+	}
+	if len(tb.List()) != 1 {
+		t.Fatal("expected an expired lease not to be merged")
+	}
+	tb.Merge(Lease{IP: ip, MAC: other, Expires: time.Now().Add(2 * time.Hour)})
+	if got, _ := tb.MAC(ip.AsSlice()); got.String() != other.String() {
+		t.Fatalf("got %s, want the newer lease", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/go-logr/logr"
@@ -48,10 +49,13 @@ func (e *Encoder) Encode(pkt *dhcpv4.DHCPv4, namespace string, encoders ...func(
 		e.Log = logr.Discard()
 	}
 	var attrs []attribute.KeyValue
+	debug := e.Log.V(2).Enabled()
 	for _, elem := range encoders {
 		kv, err := elem(pkt, namespace)
 		if err != nil {
-			e.Log.V(2).Info("opentelemetry attribute not added", "error", fmt.Sprintf("%v", err))
+			if debug {
+				e.Log.V(2).Info("opentelemetry attribute not added", "error", err.Error())
+			}
 			continue
 		}
 		attrs = append(attrs, kv)
@@ -77,7 +81,7 @@ func AllEncoders() []func(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValu
 // EncodeFlags takes DHCP flags from a DHCP packet and returns an OTEL key/value pair.
 // key/value pair. See https://datatracker.ietf.org/doc/html/rfc2131#page-9
 func EncodeFlags(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Header.flags", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Header.flags"
 	if d != nil {
 		return attribute.String(key, d.FlagsToString()), nil
 	}
@@ -88,7 +92,7 @@ func EncodeFlags(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeTransactionID takes the Transaction ID header from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeTransactionID(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Header.transactionID", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Header.transactionID"
 	if d != nil {
 		return attribute.String(key, d.TransactionID.String()), nil
 	}
@@ -100,7 +104,7 @@ func EncodeTransactionID(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt1(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
 	opt := "Opt1.SubnetMask"
-	key := fmt.Sprintf("%v.%v.%v", keyNamespace, namespace, opt)
+	key := keyNamespace + "." + namespace + "." + opt
 	if d != nil && d.SubnetMask() != nil {
 		sm := net.IP(d.SubnetMask()).String()
 		return attribute.String(key, sm), nil
@@ -112,7 +116,7 @@ func EncodeOpt1(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt3 takes DHCP Opt 3 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt3(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt3.DefaultGateway", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt3.DefaultGateway"
 	if d != nil {
 		var routers []string
 		for _, e := range d.Router() {
@@ -129,7 +133,7 @@ func EncodeOpt3(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt6 takes DHCP Opt 6 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt6(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt6.NameServers", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt6.NameServers"
 	if d != nil {
 		var ns []string
 		for _, e := range d.DNS() {
@@ -146,7 +150,7 @@ func EncodeOpt6(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt12 takes DHCP Opt 12 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt12(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt12.Hostname", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt12.Hostname"
 	if d != nil && d.HostName() != "" {
 		return attribute.String(key, d.HostName()), nil
 	}
@@ -157,7 +161,7 @@ func EncodeOpt12(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt15 takes DHCP Opt 15 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt15(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt15.DomainName", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt15.DomainName"
 	if d != nil && d.DomainName() != "" {
 		return attribute.String(key, d.DomainName()), nil
 	}
@@ -168,7 +172,7 @@ func EncodeOpt15(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt28 takes DHCP Opt 28 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt28(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt28.BroadcastAddress", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt28.BroadcastAddress"
 	if d != nil && d.BroadcastAddress() != nil {
 		return attribute.String(key, d.BroadcastAddress().String()), nil
 	}
@@ -179,7 +183,7 @@ func EncodeOpt28(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt42 takes DHCP Opt 42 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt42(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt42.NTPServers", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt42.NTPServers"
 	if d != nil {
 		var ntp []string
 		for _, e := range d.NTPServers() {
@@ -196,7 +200,7 @@ func EncodeOpt42(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt51 takes DHCP Opt 51 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt51(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt51.LeaseTime", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt51.LeaseTime"
 	if d != nil && d.IPAddressLeaseTime(0) != 0 {
 		return attribute.Float64(key, d.IPAddressLeaseTime(0).Seconds()), nil
 	}
@@ -207,7 +211,7 @@ func EncodeOpt51(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt53 takes DHCP Opt 53 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt53(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt53.MessageType", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt53.MessageType"
 	if d != nil && d.MessageType() != dhcpv4.MessageTypeNone {
 		return attribute.String(key, d.MessageType().String()), nil
 	}
@@ -218,7 +222,7 @@ func EncodeOpt53(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt54 takes DHCP Opt 54 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt54(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt54.ServerIdentifier", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt54.ServerIdentifier"
 	if d != nil && d.ServerIdentifier() != nil {
 		return attribute.String(key, d.ServerIdentifier().String()), nil
 	}
@@ -229,7 +233,7 @@ func EncodeOpt54(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt60 takes DHCP Opt 60 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt60(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt60.ClassIdentifier", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt60.ClassIdentifier"
 	if d != nil && d.ClassIdentifier() != "" {
 		return attribute.String(key, d.ClassIdentifier()), nil
 	}
@@ -240,7 +244,7 @@ func EncodeOpt60(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt93 takes DHCP Opt 93 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt93(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt93.ClientIdentifier", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt93.ClientIdentifier"
 	if d != nil && len(d.ClientArch()) > 0 {
 		var r []string
 		for _, i := range d.ClientArch() {
@@ -256,11 +260,11 @@ func EncodeOpt93(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt94 takes DHCP Opt 94 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt94(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt94.ClientNetworkInterfaceIdentifier", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt94.ClientNetworkInterfaceIdentifier"
 	if d != nil && len(d.GetOneOption(dhcpv4.OptionClientNetworkInterfaceIdentifier)) > 0 {
 		var r []string
 		for _, i := range d.GetOneOption(dhcpv4.OptionClientNetworkInterfaceIdentifier) {
-			r = append(r, fmt.Sprintf("%v", i))
+			r = append(r, strconv.Itoa(int(i)))
 		}
 
 		// "." delimited follows the same format from tcpdump
@@ -273,11 +277,11 @@ func EncodeOpt94(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt97 takes DHCP Opt 97 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt97(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt97.ClientMachineIdentifier", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt97.ClientMachineIdentifier"
 	if d != nil && len(d.GetOneOption(dhcpv4.OptionClientMachineIdentifier)) > 0 {
 		var r []string
 		for _, i := range d.GetOneOption(dhcpv4.OptionClientMachineIdentifier) {
-			r = append(r, fmt.Sprintf("%v", i))
+			r = append(r, strconv.Itoa(int(i)))
 		}
 
 		// "." delimited follows the same format from tcpdump
@@ -290,7 +294,7 @@ func EncodeOpt97(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error)
 // EncodeOpt119 takes DHCP Opt 119 from a DHCP packet and returns an OTEL key/value pair.
 // See https://www.iana.org/assignments/bootp-dhcp-parameters/bootp-dhcp-parameters.xhtml
 func EncodeOpt119(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Opt119.DomainSearch", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Opt119.DomainSearch"
 	if d != nil {
 		if l := d.DomainSearch(); l != nil {
 			return attribute.String(key, strings.Join(l.Labels, ",")), nil
@@ -303,7 +307,7 @@ func EncodeOpt119(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error
 // EncodeYIADDR takes the yiaddr header from a DHCP packet and returns an OTEL
 // key/value pair. See https://datatracker.ietf.org/doc/html/rfc2131#page-9
 func EncodeYIADDR(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Header.yiaddr", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Header.yiaddr"
 	if d != nil && d.YourIPAddr != nil {
 		return attribute.String(key, d.YourIPAddr.String()), nil
 	}
@@ -314,7 +318,7 @@ func EncodeYIADDR(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error
 // EncodeSIADDR takes the siaddr header from a DHCP packet and returns an OTEL
 // key/value pair. See https://datatracker.ietf.org/doc/html/rfc2131#page-9
 func EncodeSIADDR(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Header.siaddr", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Header.siaddr"
 	if d != nil && d.ServerIPAddr != nil {
 		return attribute.String(key, d.ServerIPAddr.String()), nil
 	}
@@ -325,7 +329,7 @@ func EncodeSIADDR(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error
 // EncodeCHADDR takes the CHADDR header from a DHCP packet and returns an OTEL
 // key/value pair. See https://datatracker.ietf.org/doc/html/rfc2131#page-9
 func EncodeCHADDR(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Header.chaddr", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Header.chaddr"
 	if d != nil && d.ClientHWAddr != nil {
 		return attribute.String(key, d.ClientHWAddr.String()), nil
 	}
@@ -336,7 +340,7 @@ func EncodeCHADDR(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error
 // EncodeFILE takes the file header from a DHCP packet and returns an OTEL
 // key/value pair. See https://datatracker.ietf.org/doc/html/rfc2131#page-9
 func EncodeFILE(d *dhcpv4.DHCPv4, namespace string) (attribute.KeyValue, error) {
-	key := fmt.Sprintf("%v.%v.Header.file", keyNamespace, namespace)
+	key := keyNamespace + "." + namespace + ".Header.file"
 	if d != nil && d.BootFileName != "" {
 		return attribute.String(key, d.BootFileName), nil
 	}
@@ -0,0 +1,109 @@
+package dhcp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// ReplyMode is how DHCP replies to clients that are not behind a relay agent are addressed.
+type ReplyMode string
+
+const (
+	// ReplyModeAuto sends replies to the source IP address of the client message, broadcasts them when the client has no IP address.
+	ReplyModeAuto ReplyMode = "auto"
+	// ReplyModeRFC2131 follows section 4.1 of RFC 2131: replies are sent to the client IP address (ciaddr) when it is set,
+	// broadcast when the broadcast flag is set and sent to the offered IP address (yiaddr) otherwise.
+	ReplyModeRFC2131 ReplyMode = "rfc2131"
+	// ReplyModeBroadcast always broadcasts replies.
+	ReplyModeBroadcast ReplyMode = "broadcast"
+	// ReplyModeUnicast sends replies to the client IP address (ciaddr), or to the offered IP address (yiaddr) when the client has none.
+	ReplyModeUnicast ReplyMode = "unicast"
+)
+
+// BroadcastFlag overrides the broadcast flag of DHCP replies.
+type BroadcastFlag string
+
+const (
+	// BroadcastFlagKeep keeps the broadcast flag of the client message.
+	BroadcastFlagKeep BroadcastFlag = "keep"
+	// BroadcastFlagSet sets the broadcast flag.
+	BroadcastFlagSet BroadcastFlag = "set"
+	// BroadcastFlagClear clears the broadcast flag.
+	BroadcastFlagClear BroadcastFlag = "clear"
+)
+
+// ReplyPolicy decides the broadcast flag of DHCP replies and the address they are sent to.
+// Replies to clients behind a relay agent are always sent to the relay agent, which uses the broadcast flag
+// to deliver them. The zero value is the auto mode that keeps the broadcast flag.
+type ReplyPolicy struct {
+	Mode          ReplyMode
+	BroadcastFlag BroadcastFlag
+}
+
+// ParseReplyPolicy parses a reply mode and a broadcast flag override, empty values are the defaults.
+func ParseReplyPolicy(mode, flag string) (ReplyPolicy, error) {
+	p := ReplyPolicy{Mode: ReplyMode(mode), BroadcastFlag: BroadcastFlag(flag)}
+	switch p.Mode {
+	case "", ReplyModeAuto, ReplyModeRFC2131, ReplyModeBroadcast, ReplyModeUnicast:
+	default:
+		return ReplyPolicy{}, fmt.Errorf("unknown reply mode %q, must be one of %s, %s, %s or %s", mode, ReplyModeAuto, ReplyModeRFC2131, ReplyModeBroadcast, ReplyModeUnicast)
+	}
+	switch p.BroadcastFlag {
+	case "", BroadcastFlagKeep, BroadcastFlagSet, BroadcastFlagClear:
+	default:
+		return ReplyPolicy{}, fmt.Errorf("unknown broadcast flag %q, must be one of %s, %s or %s", flag, BroadcastFlagKeep, BroadcastFlagSet, BroadcastFlagClear)
+	}
+
+	return p, nil
+}
+
+// SetBroadcastFlag overrides the broadcast flag of reply.
+func (p ReplyPolicy) SetBroadcastFlag(reply *dhcpv4.DHCPv4) {
+	switch p.BroadcastFlag {
+	case BroadcastFlagSet:
+		reply.SetBroadcast()
+	case BroadcastFlagClear:
+		reply.SetUnicast()
+	}
+}
+
+// Destination returns the address that reply to req is sent to. peer is the source address of req, the broadcast
+// address when the client has no IP address. Replies to clients are sent to the UDP port of peer.
+//
+// From page 22 of https://www.ietf.org/rfc/rfc2131.txt:
+// "If the 'giaddr' field in a DHCP message from a client is non-zero,
+// the server sends any return messages to the 'DHCP server' port on
+// the BOOTP relay agent whose address appears in 'giaddr'.".
+func (p ReplyPolicy) Destination(peer net.Addr, req, reply *dhcpv4.DHCPv4) net.Addr {
+	if isSet(req.GatewayIPAddr) {
+		return &net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort}
+	}
+	port := dhcpv4.ClientPort
+	if u, ok := peer.(*net.UDPAddr); ok && u.Port != 0 {
+		port = u.Port
+	}
+	broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: port}
+	switch p.Mode {
+	case ReplyModeBroadcast:
+		return broadcast
+	case ReplyModeUnicast, ReplyModeRFC2131:
+		if isSet(req.ClientIPAddr) {
+			return &net.UDPAddr{IP: req.ClientIPAddr, Port: port}
+		}
+		if p.Mode == ReplyModeRFC2131 && reply.IsBroadcast() {
+			return broadcast
+		}
+		if isSet(reply.YourIPAddr) {
+			return &net.UDPAddr{IP: reply.YourIPAddr, Port: port}
+		}
+		return broadcast
+	}
+
+	return peer
+}
+
+func isSet(ip net.IP) bool {
+	return ip != nil && !ip.IsUnspecified()
+}
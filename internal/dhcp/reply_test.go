@@ -0,0 +1,78 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestParseReplyPolicy(t *testing.T) {
+	tests := map[string]struct {
+		mode    string
+		flag    string
+		want    ReplyPolicy
+		wantErr bool
+	}{
+		"defaults":     {want: ReplyPolicy{}},
+		"rfc2131 set":  {mode: "rfc2131", flag: "set", want: ReplyPolicy{Mode: ReplyModeRFC2131, BroadcastFlag: BroadcastFlagSet}},
+		"unicast":      {mode: "unicast", flag: "keep", want: ReplyPolicy{Mode: ReplyModeUnicast, BroadcastFlag: BroadcastFlagKeep}},
+		"unknown mode": {mode: "multicast", wantErr: true},
+		"unknown flag": {flag: "toggle", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseReplyPolicy(tt.mode, tt.flag)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseReplyPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestReplyPolicyDestination(t *testing.T) {
+	yiaddr := net.IP{192, 168, 2, 10}
+	bcastPeer := &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	tests := map[string]struct {
+		policy    ReplyPolicy
+		peer      net.Addr
+		giaddr    net.IP
+		ciaddr    net.IP
+		broadcast bool
+		want      net.Addr
+	}{
+		"auto":                        {peer: bcastPeer, want: bcastPeer},
+		"auto with client address":    {peer: &net.UDPAddr{IP: net.IP{192, 168, 2, 11}, Port: 68}, ciaddr: net.IP{192, 168, 2, 11}, want: &net.UDPAddr{IP: net.IP{192, 168, 2, 11}, Port: 68}},
+		"relay":                       {policy: ReplyPolicy{Mode: ReplyModeBroadcast}, peer: &net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: 67}, giaddr: net.IP{10, 0, 0, 1}, want: &net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: 67}},
+		"broadcast":                   {policy: ReplyPolicy{Mode: ReplyModeBroadcast}, peer: &net.UDPAddr{IP: net.IP{192, 168, 2, 11}, Port: 68}, ciaddr: net.IP{192, 168, 2, 11}, want: bcastPeer},
+		"broadcast keeps proxy port":  {policy: ReplyPolicy{Mode: ReplyModeBroadcast}, peer: &net.UDPAddr{IP: net.IP{192, 168, 2, 11}, Port: 4011}, want: &net.UDPAddr{IP: net.IPv4bcast, Port: 4011}},
+		"unicast to yiaddr":           {policy: ReplyPolicy{Mode: ReplyModeUnicast}, peer: bcastPeer, broadcast: true, want: &net.UDPAddr{IP: yiaddr, Port: 68}},
+		"unicast to ciaddr":           {policy: ReplyPolicy{Mode: ReplyModeUnicast}, peer: bcastPeer, ciaddr: net.IP{192, 168, 2, 11}, want: &net.UDPAddr{IP: net.IP{192, 168, 2, 11}, Port: 68}},
+		"rfc2131 broadcast flag":      {policy: ReplyPolicy{Mode: ReplyModeRFC2131}, peer: bcastPeer, broadcast: true, want: bcastPeer},
+		"rfc2131 without flag":        {policy: ReplyPolicy{Mode: ReplyModeRFC2131}, peer: bcastPeer, want: &net.UDPAddr{IP: yiaddr, Port: 68}},
+		"rfc2131 flag cleared":        {policy: ReplyPolicy{Mode: ReplyModeRFC2131, BroadcastFlag: BroadcastFlagClear}, peer: bcastPeer, broadcast: true, want: &net.UDPAddr{IP: yiaddr, Port: 68}},
+		"rfc2131 flag set":            {policy: ReplyPolicy{Mode: ReplyModeRFC2131, BroadcastFlag: BroadcastFlagSet}, peer: bcastPeer, want: bcastPeer},
+		"rfc2131 ciaddr despite flag": {policy: ReplyPolicy{Mode: ReplyModeRFC2131}, peer: bcastPeer, ciaddr: net.IP{192, 168, 2, 11}, broadcast: true, want: &net.UDPAddr{IP: net.IP{192, 168, 2, 11}, Port: 68}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := &dhcpv4.DHCPv4{OpCode: dhcpv4.OpcodeBootRequest, GatewayIPAddr: tt.giaddr, ClientIPAddr: tt.ciaddr}
+			if tt.broadcast {
+				req.SetBroadcast()
+			}
+			reply, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithYourIP(yiaddr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.policy.SetBroadcastFlag(reply)
+			got := tt.policy.Destination(tt.peer, req, reply)
+			if diff := cmp.Diff(tt.want.String(), got.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
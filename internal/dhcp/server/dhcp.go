@@ -3,12 +3,16 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/metric"
 	"golang.org/x/net/ipv4"
 )
 
@@ -16,14 +20,27 @@ import (
 // valid DHCPv4 message is received
 // type Handler func(ctx context.Context, conn net.PacketConn, d data.Packet).
 type Handler interface {
-	Handle(ctx context.Context, conn *ipv4.PacketConn, d data.Packet)
+	Handle(ctx context.Context, conn data.Conn, d data.Packet)
 }
 
 // DHCP represents a DHCPv4 server object.
 type DHCP struct {
-	Conn     net.PacketConn
+	Conn net.PacketConn
+	// Link, when set, is used instead of Conn to receive and send the DHCP packets on a raw socket.
+	Link     *Link
 	Handlers []Handler
 	Logger   logr.Logger
+
+	// Workers is the number of packets that are handled concurrently.
+	// When 0, every packet is handled in its own goroutine.
+	Workers int
+	// QueueSize is the number of received packets that can wait for a free worker.
+	// Packets received while the queue is full are dropped, DHCP clients retransmit them.
+	// It is only used when Workers is greater than 0.
+	QueueSize int
+	// Timeout is the deadline of every handler for a packet, including its backend lookups.
+	// When 0, handlers are only cancelled when the server stops.
+	Timeout time.Duration
 }
 
 // Serve serves requests.
@@ -32,22 +49,59 @@ func (s *DHCP) Serve(ctx context.Context) error {
 		<-ctx.Done()
 		_ = s.Close()
 	}()
-	s.Logger.Info("Server listening on", "addr", s.Conn.LocalAddr())
-
-	nConn := ipv4.NewPacketConn(s.Conn)
-	if err := nConn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
-		s.Logger.Info("error setting control message", "err", err)
-		return err
+	var nConn packetConn
+	if s.Link != nil {
+		s.Logger.Info("Server listening on a raw socket", "addr", s.Link.LocalAddr())
+		nConn = s.Link
+	} else {
+		s.Logger.Info("Server listening on", "addr", s.Conn.LocalAddr())
+		c := ipv4.NewPacketConn(s.Conn)
+		if err := c.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+			s.Logger.Info("error setting control message", "err", err)
+			return err
+		}
+		nConn = udpConn{c}
 	}
 
 	defer func() {
 		_ = nConn.Close()
 	}()
+	dispatch := func(p data.Packet) {
+		for _, handler := range s.Handlers {
+			go s.handle(ctx, handler, nConn, p)
+		}
+	}
+	if s.Workers > 0 {
+		queue := make(chan data.Packet, s.QueueSize)
+		var wg sync.WaitGroup
+		for range s.Workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.work(ctx, nConn, queue)
+			}()
+		}
+		defer func() {
+			close(queue)
+			wg.Wait()
+		}()
+		dispatch = func(p data.Packet) {
+			select {
+			case queue <- p:
+				metric.DHCPQueueDepth.Inc()
+			default:
+				metric.DHCPDropped.Inc()
+				s.Logger.V(1).Info("dropping DHCP packet, all workers are busy and the queue is full", "mac", p.Pkt.ClientHWAddr, "queueSize", s.QueueSize)
+			}
+		}
+	}
+	// Max UDP packet size is 65535. Max DHCPv4 packet size is 576. An ethernet frame is 1500 bytes.
+	// We use 4096 as a reasonable buffer size. dhcpv4.FromBytes will handle the rest.
+	// dhcpv4.FromBytes copies what it parses, so the buffer is reused for every packet.
+	rbuf := make([]byte, 4096)
+	ifNames := interfaceNames{}
 	for {
-		// Max UDP packet size is 65535. Max DHCPv4 packet size is 576. An ethernet frame is 1500 bytes.
-		// We use 4096 as a reasonable buffer size. dhcpv4.FromBytes will handle the rest.
-		rbuf := make([]byte, 4096)
-		n, cm, peer, err := nConn.ReadFrom(rbuf)
+		n, ifIndex, peer, err := nConn.read(rbuf)
 		if err != nil {
 			select {
 			case <-ctx.Done():
@@ -77,19 +131,91 @@ func (s *DHCP) Serve(ctx context.Context) error {
 			}
 		}
 
-		var ifName string
-		if n, err := net.InterfaceByIndex(cm.IfIndex); err == nil {
-			ifName = n.Name
-		}
+		dispatch(data.Packet{Peer: upeer, Pkt: m, Md: &data.Metadata{IfName: ifNames.name(ifIndex, time.Now()), IfIndex: ifIndex}})
+	}
+}
+
+// packetConn is the listener of a DHCP server, a UDP socket or a Link.
+type packetConn interface {
+	data.Conn
+	// read reads the payload of a packet, it returns the index of the interface that received it and its sender.
+	read(b []byte) (n int, ifIndex int, peer net.Addr, err error)
+	Close() error
+}
+
+// udpConn is a packetConn of a UDP socket.
+type udpConn struct {
+	*ipv4.PacketConn
+}
 
+func (c udpConn) read(b []byte) (int, int, net.Addr, error) {
+	n, cm, peer, err := c.ReadFrom(b)
+	if cm == nil {
+		return n, 0, peer, err
+	}
+
+	return n, cm.IfIndex, peer, err
+}
+
+// work handles the packets in queue until it is closed.
+func (s *DHCP) work(ctx context.Context, conn data.Conn, queue <-chan data.Packet) {
+	for p := range queue {
+		metric.DHCPQueueDepth.Dec()
 		for _, handler := range s.Handlers {
-			go handler.Handle(ctx, nConn, data.Packet{Peer: upeer, Pkt: m, Md: &data.Metadata{IfName: ifName, IfIndex: cm.IfIndex}})
+			s.handle(ctx, handler, conn, p)
 		}
 	}
 }
 
-// Close sends a termination request to the server, and closes the UDP listener.
+// handle calls h for p with the deadline of Timeout, counting the packets whose deadline was exceeded.
+func (s *DHCP) handle(ctx context.Context, h Handler, conn data.Conn, p data.Packet) {
+	if s.Timeout <= 0 {
+		h.Handle(ctx, conn, p)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	h.Handle(ctx, conn, p)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		metric.Timeouts.WithLabelValues("dhcp").Inc()
+		s.Logger.V(1).Info("DHCP packet handling timed out", "mac", p.Pkt.ClientHWAddr, "timeout", s.Timeout)
+	}
+}
+
+// interfaceNameTTL is how long an interface name is cached. The index of an interface that is removed can be reused by
+// a new one, like a VLAN or bridge interface that is recreated, so the names are looked up again once they expire.
+const interfaceNameTTL = time.Minute
+
+// interfaceNames caches interface names by index, looking them up is a syscall for every packet otherwise.
+// Interfaces that are not found are not cached, and a cached name is forgotten when its interface is no longer
+// found, so new and renamed interfaces are picked up.
+type interfaceNames map[int]interfaceName
+
+type interfaceName struct {
+	name    string
+	expires time.Time
+}
+
+func (c interfaceNames) name(index int, now time.Time) string {
+	if n, ok := c[index]; ok && now.Before(n.expires) {
+		return n.name
+	}
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		delete(c, index)
+		return ""
+	}
+	c[index] = interfaceName{name: iface.Name, expires: now.Add(interfaceNameTTL)}
+
+	return iface.Name
+}
+
+// Close sends a termination request to the server, and closes the UDP listener or the raw socket.
 func (s *DHCP) Close() error {
+	if s.Link != nil {
+		return s.Link.Close()
+	}
+
 	return s.Conn.Close()
 }
 
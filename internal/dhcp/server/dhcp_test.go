@@ -4,16 +4,25 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/metric"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/nettest"
 )
 
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
 type mock struct {
 	Log         logr.Logger
 	ServerIP    net.IP
@@ -24,7 +33,7 @@ type mock struct {
 	Router      net.IP
 }
 
-func (m *mock) Handle(_ context.Context, conn *ipv4.PacketConn, d data.Packet) {
+func (m *mock) Handle(_ context.Context, conn data.Conn, d data.Packet) {
 	if m.Log.GetSink() == nil {
 		m.Log = logr.Discard()
 	}
@@ -114,3 +123,119 @@ func TestServe(t *testing.T) {
 		})
 	}
 }
+
+// blocking is a Handler that blocks until release is closed.
+type blocking struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blocking) Handle(_ context.Context, _ data.Conn, _ data.Packet) {
+	b.started <- struct{}{}
+	<-b.release
+}
+
+func TestServeWorkers(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &blocking{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := &DHCP{Conn: conn, Handlers: []Handler{b}, Logger: logr.Discard(), Workers: 1, QueueSize: 1}
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	go s.Serve(ctx)
+
+	client, err := net.Dial("udp4", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	pkt, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func() {
+		if _, err := client.Write(pkt.ToBytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first packet occupies the only worker.
+	send()
+	<-b.started
+	// the second packet is queued and the remaining three are dropped.
+	for range 4 {
+		send()
+	}
+	deadline := time.After(5 * time.Second)
+	for testutil.ToFloat64(metric.DHCPDropped) != 3 {
+		select {
+		case <-deadline:
+			t.Fatalf("got %v dropped packets, want 3", testutil.ToFloat64(metric.DHCPDropped))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if got := testutil.ToFloat64(metric.DHCPQueueDepth); got != 1 {
+		t.Fatalf("got queue depth %v, want 1", got)
+	}
+	close(b.release)
+	<-b.started
+}
+
+type stuck struct{}
+
+func (stuck) Handle(ctx context.Context, _ data.Conn, _ data.Packet) {
+	<-ctx.Done()
+}
+
+func TestHandleTimeout(t *testing.T) {
+	pkt, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DHCP{Logger: logr.Discard(), Timeout: 10 * time.Millisecond}
+	before := testutil.ToFloat64(metric.Timeouts.WithLabelValues("dhcp"))
+	done := make(chan struct{})
+	go func() {
+		s.handle(context.Background(), stuck{}, nil, data.Packet{Pkt: pkt})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not cancelled at its deadline")
+	}
+	if got := testutil.ToFloat64(metric.Timeouts.WithLabelValues("dhcp")) - before; got != 1 {
+		t.Fatalf("got %v dhcp timeouts, want 1", got)
+	}
+}
+
+func TestInterfaceNames(t *testing.T) {
+	lo, err := nettest.LoopbackInterface()
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Now()
+	c := interfaceNames{}
+	if got := c.name(lo.Index, now); got != lo.Name {
+		t.Fatalf("got %q, want %q", got, lo.Name)
+	}
+	c[lo.Index] = interfaceName{name: "cached", expires: now.Add(time.Second)}
+	if got := c.name(lo.Index, now); got != "cached" {
+		t.Fatalf("got %q, want the cached name", got)
+	}
+	if got := c.name(lo.Index, now.Add(time.Second)); got != lo.Name {
+		t.Fatalf("got %q, want %q looked up again once the cached name expired", got, lo.Name)
+	}
+
+	// the interface of a cached name was removed.
+	const removed = 1 << 20
+	c[removed] = interfaceName{name: "vlan100", expires: now}
+	if got := c.name(removed, now); got != "" {
+		t.Fatalf("got %q, want no name for a removed interface", got)
+	}
+	if _, ok := c[removed]; ok {
+		t.Fatal("want the name of a removed interface forgotten")
+	}
+}
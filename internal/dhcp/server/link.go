@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/mdlayher/packet"
+	"golang.org/x/net/bpf"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	etherTypeIPv4   = 0x0800
+	etherHeaderLen  = 14
+	ipv4HeaderLen   = 20
+	udpHeaderLen    = 8
+	ipProtocolUDP   = 17
+	ipDefaultTTL    = 64
+	ipFragmentMask  = 0x1fff
+	ipMoreFragments = 0x2000
+)
+
+// Link is a DHCP listener on a raw (AF_PACKET) socket of an interface. It receives the IPv4 UDP packets to its port
+// whatever their destination IP, and sends its replies as Ethernet frames with its source MAC and IP, instead of the
+// ones that the routing table of the host picks. It needs CAP_NET_RAW.
+//
+// The destination MAC of a reply is the MAC that its destination IP was last seen with, like the one of a DHCP relay,
+// or else the client hardware address of the DHCP message for a unicast reply, or else the broadcast address.
+type Link struct {
+	conn   linkConn
+	ifi    *net.Interface
+	port   int
+	srcMAC net.HardwareAddr
+	srcIP  netip.Addr
+	// frame is the buffer of the received frames.
+	frame []byte
+
+	mu         sync.Mutex
+	neighbours map[netip.Addr]net.HardwareAddr
+}
+
+// linkConn is the raw socket of a Link.
+type linkConn interface {
+	ReadFrom(b []byte) (int, net.Addr, error)
+	WriteTo(b []byte, addr net.Addr) (int, error)
+	SyscallConn() (syscall.RawConn, error)
+	Close() error
+}
+
+// ListenLink listens for the DHCP packets to port on the interface ifname. srcMAC defaults to the MAC of the interface,
+// and srcIP to its first IPv4 address, when they are empty.
+func ListenLink(ifname string, port int, srcMAC net.HardwareAddr, srcIP netip.Addr) (*Link, error) {
+	l, err := newLink(ifname, port, srcMAC, srcIP)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := linkFilter(port)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := packet.Listen(l.ifi, packet.Raw, etherTypeIPv4, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetBPF(filter); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to attach the dhcp filter to the raw socket: %w", err)
+	}
+	l.conn = conn
+
+	return l, nil
+}
+
+// FileLink is ListenLink on the raw socket f, that is inherited already bound to the interface ifname, so that Smee
+// needs no CAP_NET_RAW. f stays open, the Link uses a duplicate of it.
+func FileLink(f *os.File, ifname string, port int, srcMAC net.HardwareAddr, srcIP netip.Addr) (*Link, error) {
+	l, err := newLink(ifname, port, srcMAC, srcIP)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := linkFilter(port)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newFileConn(f, l.ifi.Index)
+	if err != nil {
+		return nil, fmt.Errorf("the inherited raw socket: %w", err)
+	}
+	if err := conn.setBPF(filter); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to attach the dhcp filter to the inherited raw socket: %w", err)
+	}
+	l.conn = conn
+
+	return l, nil
+}
+
+// newLink returns a Link on the interface ifname, without its raw socket.
+func newLink(ifname string, port int, srcMAC net.HardwareAddr, srcIP netip.Addr) (*Link, error) {
+	ifi, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	if len(srcMAC) == 0 {
+		srcMAC = ifi.HardwareAddr
+	}
+	if len(srcMAC) != 6 {
+		return nil, fmt.Errorf("interface %s has no ethernet address, a source mac is required", ifname)
+	}
+	if !srcIP.IsValid() {
+		if srcIP, err = interfaceIPv4(ifi); err != nil {
+			return nil, err
+		}
+	}
+	if !srcIP.Is4() {
+		return nil, fmt.Errorf("invalid source ip %v, must be an IPv4 address", srcIP)
+	}
+
+	return &Link{
+		ifi:        ifi,
+		port:       port,
+		srcMAC:     srcMAC,
+		srcIP:      srcIP,
+		frame:      make([]byte, max(ifi.MTU, 1500)+etherHeaderLen),
+		neighbours: map[netip.Addr]net.HardwareAddr{},
+	}, nil
+}
+
+// LocalAddr returns the interface and the source address of the replies of l.
+func (l *Link) LocalAddr() string {
+	return fmt.Sprintf("%s %s %v:%d", l.ifi.Name, l.srcMAC, l.srcIP, l.port)
+}
+
+// Close closes the raw socket.
+func (l *Link) Close() error {
+	return l.conn.Close()
+}
+
+// File returns a duplicate of the raw socket of l, to pass it to the process that serves on it, see FileLink.
+func (l *Link) File() (*os.File, error) {
+	rc, err := l.conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := dup(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), "dhcp-raw"), nil
+}
+
+// read reads the payload of the next UDP packet to the port of l, it returns the IP and port that sent it.
+func (l *Link) read(b []byte) (int, int, net.Addr, error) {
+	for {
+		n, _, err := l.conn.ReadFrom(l.frame)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		src, mac, payload, ok := parseFrame(l.frame[:n], l.port)
+		if !ok {
+			continue
+		}
+		if ip := src.Addr(); !ip.IsUnspecified() {
+			l.mu.Lock()
+			l.neighbours[ip] = slices.Clone(mac)
+			l.mu.Unlock()
+		}
+
+		return copy(b, payload), l.ifi.Index, net.UDPAddrFromAddrPort(src), nil
+	}
+}
+
+// WriteTo implements data.Conn, it sends b in a UDP packet to dst, a *net.UDPAddr. The control message is ignored,
+// the reply is always sent on the interface of l.
+func (l *Link) WriteTo(b []byte, _ *ipv4.ControlMessage, dst net.Addr) (int, error) {
+	u, ok := dst.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("invalid destination %v, must be a UDP address", dst)
+	}
+	ip, ok := netip.AddrFromSlice(u.IP)
+	if !ok || !ip.Unmap().Is4() {
+		return 0, fmt.Errorf("invalid destination %v, must be an IPv4 address", dst)
+	}
+	to := netip.AddrPortFrom(ip.Unmap(), uint16(u.Port))
+	frame := buildFrame(l.srcMAC, l.destinationMAC(to.Addr(), b), netip.AddrPortFrom(l.srcIP, uint16(l.port)), to, b)
+	if _, err := l.conn.WriteTo(frame, &packet.Addr{HardwareAddr: frame[:6]}); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// destinationMAC returns the MAC that a reply with the DHCP message b to ip is sent to.
+func (l *Link) destinationMAC(ip netip.Addr, b []byte) net.HardwareAddr {
+	if ip == netip.IPv4Unspecified() || ip.As4() == [4]byte{255, 255, 255, 255} {
+		return layer2Broadcast
+	}
+	l.mu.Lock()
+	mac, ok := l.neighbours[ip]
+	l.mu.Unlock()
+	if ok {
+		return mac
+	}
+	if m, err := dhcpv4.FromBytes(b); err == nil && len(m.ClientHWAddr) == 6 && m.YourIPAddr.Equal(net.IP(ip.AsSlice())) {
+		return m.ClientHWAddr
+	}
+
+	return layer2Broadcast
+}
+
+var layer2Broadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// linkFilter returns a BPF program that only accepts IPv4 UDP packets to port that are not fragments.
+func linkFilter(port int) ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		// ethertype
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: etherTypeIPv4, SkipTrue: 8},
+		// IP protocol
+		bpf.LoadAbsolute{Off: etherHeaderLen + 9, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: ipProtocolUDP, SkipTrue: 6},
+		// fragment offset and more fragments flag
+		bpf.LoadAbsolute{Off: etherHeaderLen + 6, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: ipFragmentMask | ipMoreFragments, SkipTrue: 4},
+		// UDP destination port, after the IP header of variable length
+		bpf.LoadMemShift{Off: etherHeaderLen},
+		bpf.LoadIndirect{Off: etherHeaderLen + 2, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(port), SkipTrue: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	})
+}
+
+// parseFrame returns the source MAC, IP and port, and the payload, of an Ethernet frame of an IPv4 UDP packet to port.
+func parseFrame(frame []byte, port int) (netip.AddrPort, net.HardwareAddr, []byte, bool) {
+	if len(frame) < etherHeaderLen+ipv4HeaderLen+udpHeaderLen || binary.BigEndian.Uint16(frame[12:14]) != etherTypeIPv4 {
+		return netip.AddrPort{}, nil, nil, false
+	}
+	ip := frame[etherHeaderLen:]
+	ihl := int(ip[0]&0x0f) * 4
+	if ip[0]>>4 != 4 || ihl < ipv4HeaderLen || ip[9] != ipProtocolUDP || binary.BigEndian.Uint16(ip[6:8])&(ipFragmentMask|ipMoreFragments) != 0 {
+		return netip.AddrPort{}, nil, nil, false
+	}
+	total := int(binary.BigEndian.Uint16(ip[2:4]))
+	if total > len(ip) || total < ihl+udpHeaderLen {
+		return netip.AddrPort{}, nil, nil, false
+	}
+	udp := ip[ihl:total]
+	if int(binary.BigEndian.Uint16(udp[2:4])) != port {
+		return netip.AddrPort{}, nil, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:6]))
+	if length < udpHeaderLen || length > len(udp) {
+		return netip.AddrPort{}, nil, nil, false
+	}
+	src := netip.AddrPortFrom(netip.AddrFrom4([4]byte(ip[12:16])), binary.BigEndian.Uint16(udp[0:2]))
+
+	return src, net.HardwareAddr(frame[6:12]), udp[udpHeaderLen:length], true
+}
+
+// buildFrame returns the Ethernet frame of an IPv4 UDP packet with payload.
+func buildFrame(srcMAC, dstMAC net.HardwareAddr, src, dst netip.AddrPort, payload []byte) []byte {
+	frame := make([]byte, etherHeaderLen+ipv4HeaderLen+udpHeaderLen+len(payload))
+	copy(frame[0:6], dstMAC)
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv4)
+
+	ip := frame[etherHeaderLen : etherHeaderLen+ipv4HeaderLen]
+	ip[0] = 4<<4 | ipv4HeaderLen/4
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderLen+udpHeaderLen+len(payload)))
+	ip[8] = ipDefaultTTL
+	ip[9] = ipProtocolUDP
+	s, d := src.Addr().As4(), dst.Addr().As4()
+	copy(ip[12:16], s[:])
+	copy(ip[16:20], d[:])
+	binary.BigEndian.PutUint16(ip[10:12], checksum(ip, 0))
+
+	udp := frame[etherHeaderLen+ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:2], src.Port())
+	binary.BigEndian.PutUint16(udp[2:4], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderLen+len(payload)))
+	copy(udp[udpHeaderLen:], payload)
+	// the UDP checksum covers a pseudo header of the addresses, the protocol and the UDP length.
+	pseudo := uint32(binary.BigEndian.Uint16(s[0:2])) + uint32(binary.BigEndian.Uint16(s[2:4])) +
+		uint32(binary.BigEndian.Uint16(d[0:2])) + uint32(binary.BigEndian.Uint16(d[2:4])) +
+		ipProtocolUDP + uint32(len(udp))
+	sum := checksum(udp, pseudo)
+	if sum == 0 {
+		// 0 means that there is no checksum.
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:8], sum)
+
+	return frame
+}
+
+// checksum returns the internet checksum of b, RFC 1071, starting from the partial sum initial.
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
+
+func interfaceIPv4(ifi *net.Interface) (netip.Addr, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			ip, _ := netip.AddrFromSlice(n.IP.To4())
+			return ip, nil
+		}
+	}
+
+	return netip.Addr{}, errors.New("interface " + ifi.Name + " has no IPv4 address, a source ip is required")
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/bpf"
+)
+
+func TestFrame(t *testing.T) {
+	srcMAC := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	dstMAC := net.HardwareAddr{0x00, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e}
+	src := netip.MustParseAddrPort("192.168.2.1:67")
+	dst := netip.MustParseAddrPort("192.168.2.153:68")
+	payload := []byte("dhcp reply")
+
+	frame := buildFrame(srcMAC, dstMAC, src, dst, payload)
+	if got := net.HardwareAddr(frame[0:6]); got.String() != dstMAC.String() {
+		t.Errorf("destination mac = %v, want %v", got, dstMAC)
+	}
+	ip := frame[etherHeaderLen : etherHeaderLen+ipv4HeaderLen]
+	if got := checksum(ip, 0); got != 0 {
+		t.Errorf("the IP header checksum doesn't verify, got %#x", got)
+	}
+
+	gotSrc, gotMAC, gotPayload, ok := parseFrame(frame, 68)
+	if !ok {
+		t.Fatal("expected the frame to be parsed")
+	}
+	if gotSrc != src {
+		t.Errorf("source = %v, want %v", gotSrc, src)
+	}
+	if gotMAC.String() != srcMAC.String() {
+		t.Errorf("source mac = %v, want %v", gotMAC, srcMAC)
+	}
+	if diff := cmp.Diff(payload, gotPayload); diff != "" {
+		t.Error(diff)
+	}
+	if _, _, _, ok := parseFrame(frame, 67); ok {
+		t.Error("expected a frame to another port not to be parsed")
+	}
+	if _, _, _, ok := parseFrame(frame[:30], 68); ok {
+		t.Error("expected a truncated frame not to be parsed")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// the example of RFC 1071.
+	b := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	if got, want := checksum(b, 0), ^uint16(0xddf2); got != want {
+		t.Errorf("checksum = %#x, want %#x", got, want)
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	filter, err := linkFilter(67)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prog []bpf.Instruction
+	for _, r := range filter {
+		prog = append(prog, r.Disassemble())
+	}
+	vm, err := bpf.NewVM(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	request := func() []byte {
+		return buildFrame(mac, layer2Broadcast, netip.MustParseAddrPort("0.0.0.0:68"), netip.MustParseAddrPort("255.255.255.255:67"), []byte("dhcp request"))
+	}
+	tests := map[string]struct {
+		frame  func() []byte
+		accept bool
+	}{
+		"dhcp request": {frame: request, accept: true},
+		"other port": {frame: func() []byte {
+			return buildFrame(mac, layer2Broadcast, netip.MustParseAddrPort("0.0.0.0:67"), netip.MustParseAddrPort("255.255.255.255:68"), []byte("dhcp reply"))
+		}},
+		"ip options": {frame: func() []byte {
+			// a 24 bytes IP header, the UDP header is 4 bytes further.
+			f := request()
+			opts := append(append([]byte{}, f[:etherHeaderLen+ipv4HeaderLen]...), 0x01, 0x01, 0x01, 0x00)
+			opts[etherHeaderLen] = 4<<4 | 6
+			return append(opts, f[etherHeaderLen+ipv4HeaderLen:]...)
+		}, accept: true},
+		"fragment": {frame: func() []byte {
+			f := request()
+			binary.BigEndian.PutUint16(f[etherHeaderLen+6:], ipMoreFragments)
+			return f
+		}},
+		"tcp": {frame: func() []byte {
+			f := request()
+			f[etherHeaderLen+9] = 6
+			return f
+		}},
+		"arp": {frame: func() []byte {
+			f := request()
+			binary.BigEndian.PutUint16(f[12:], 0x0806)
+			return f
+		}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			n, err := vm.Run(tt.frame())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n > 0; got != tt.accept {
+				t.Errorf("accepted = %v, want %v", got, tt.accept)
+			}
+		})
+	}
+}
+
+func TestDestinationMAC(t *testing.T) {
+	relay := net.HardwareAddr{0x00, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e}
+	l := &Link{neighbours: map[netip.Addr]net.HardwareAddr{netip.MustParseAddr("10.0.0.1"): relay}}
+
+	if got := l.destinationMAC(netip.MustParseAddr("255.255.255.255"), nil); !bytes.Equal(got, layer2Broadcast) {
+		t.Errorf("broadcast reply mac = %v, want %v", got, layer2Broadcast)
+	}
+	if got := l.destinationMAC(netip.MustParseAddr("10.0.0.1"), nil); !bytes.Equal(got, relay) {
+		t.Errorf("relay reply mac = %v, want %v", got, relay)
+	}
+	if got := l.destinationMAC(netip.MustParseAddr("10.0.0.2"), []byte("not dhcp")); !bytes.Equal(got, layer2Broadcast) {
+		t.Errorf("unknown neighbour reply mac = %v, want %v", got, layer2Broadcast)
+	}
+}
+
+func TestFileLink(t *testing.T) {
+	lo, err := net.InterfaceByIndex(1)
+	if err != nil || lo.Flags&net.FlagLoopback == 0 {
+		t.Skip("no loopback interface at index 1")
+	}
+	const port = 6767
+	// the loopback interface has no ethernet address.
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0}
+	bound, err := ListenLink(lo.Name, port, mac, netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Skipf("unable to open a raw socket: %v", err)
+	}
+	f, err := bound.File()
+	bound.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l, err := FileLink(f, lo.Name, port, mac, netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.WriteToUDP([]byte("discover"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1500)
+	n, _, peer, err := l.read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "discover" || peer.String() != client.LocalAddr().String() {
+		t.Fatalf("got %q from %v, want %q from %v", b[:n], peer, "discover", client.LocalAddr())
+	}
+
+	// the reply is captured on the loopback interface by a raw socket on the port of the client.
+	capture, err := ListenLink(lo.Name, client.LocalAddr().(*net.UDPAddr).Port, mac, netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+	if _, err := l.WriteTo([]byte("offer"), nil, peer); err != nil {
+		t.Fatal(err)
+	}
+	n, _, _, err = capture.read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "offer" {
+		t.Fatalf("got reply %q, want %q", b[:n], "offer")
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/mdlayher/packet"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// fileConn is the linkConn of an inherited raw socket, bound to the interface ifIndex.
+type fileConn struct {
+	f       *os.File
+	rc      syscall.RawConn
+	ifIndex int
+}
+
+// newFileConn returns a fileConn of a non-blocking duplicate of f, that is read and written with the runtime poller.
+func newFileConn(f *os.File, ifIndex int) (*fileConn, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := dup(rc)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	df := os.NewFile(uintptr(fd), f.Name())
+	drc, err := df.SyscallConn()
+	if err != nil {
+		_ = df.Close()
+		return nil, err
+	}
+
+	return &fileConn{f: df, rc: drc, ifIndex: ifIndex}, nil
+}
+
+func (c *fileConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	var n int
+	var err error
+	if rerr := c.rc.Read(func(fd uintptr) bool {
+		n, _, err = unix.Recvfrom(int(fd), b, 0)
+		return err != unix.EAGAIN
+	}); rerr != nil {
+		return 0, nil, rerr
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return n, nil, nil
+}
+
+// WriteTo sends the Ethernet frame b to addr, a *packet.Addr.
+func (c *fileConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	pa, ok := addr.(*packet.Addr)
+	if !ok || len(pa.HardwareAddr) != 6 {
+		return 0, fmt.Errorf("invalid destination %v, must be an ethernet address", addr)
+	}
+	sa := &unix.SockaddrLinklayer{Ifindex: c.ifIndex, Protocol: htons(etherTypeIPv4), Halen: 6}
+	copy(sa.Addr[:], pa.HardwareAddr)
+	var err error
+	if werr := c.rc.Write(func(fd uintptr) bool {
+		err = unix.Sendto(int(fd), b, 0, sa)
+		return err != unix.EAGAIN
+	}); werr != nil {
+		return 0, werr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *fileConn) SyscallConn() (syscall.RawConn, error) {
+	return c.rc, nil
+}
+
+func (c *fileConn) Close() error {
+	return c.f.Close()
+}
+
+// setBPF attaches the BPF program filter to the socket, replacing the one it may have been inherited with.
+func (c *fileConn) setBPF(filter []bpf.RawInstruction) error {
+	prog := make([]unix.SockFilter, len(filter))
+	for i, ins := range filter {
+		prog[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	var err error
+	if cerr := c.rc.Control(func(fd uintptr) {
+		err = unix.SetsockoptSockFprog(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]})
+	}); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+// dup returns a duplicate of the file descriptor of rc, that is closed on exec.
+func dup(rc syscall.RawConn) (int, error) {
+	fd := -1
+	var err error
+	if cerr := rc.Control(func(s uintptr) {
+		fd, err = unix.FcntlInt(s, unix.F_DUPFD_CLOEXEC, 0)
+	}); cerr != nil {
+		return -1, cerr
+	}
+
+	return fd, err
+}
+
+// htons returns v in network byte order, as the protocol of a link layer socket address is.
+func htons(v uint16) uint16 {
+	return binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, v))
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/net/bpf"
+)
+
+var errRawSocket = errors.New("raw sockets are only supported on linux")
+
+// fileConn is the linkConn of an inherited raw socket, only supported on linux.
+type fileConn struct {
+	linkConn
+}
+
+func newFileConn(*os.File, int) (*fileConn, error) {
+	return nil, errRawSocket
+}
+
+func (*fileConn) setBPF([]bpf.RawInstruction) error {
+	return errRawSocket
+}
+
+func dup(syscall.RawConn) (int, error) {
+	return -1, errRawSocket
+}
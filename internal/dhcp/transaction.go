@@ -0,0 +1,113 @@
+package dhcp
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+// DefaultTransactionTTL is how long the reply to a DHCP transaction is reused for retransmissions.
+const DefaultTransactionTTL = 10 * time.Second
+
+// Transactions caches the replies to DHCP client messages by transaction ID (xid), MAC address, message type,
+// receiving interface and relay agent address (giaddr), so that the retransmissions of a message reuse its reply in place of a backend lookup and a fresh reply.
+// The zero value is ready to use, a nil Transactions caches nothing.
+type Transactions struct {
+	// TTL is how long a reply is reused. The default is DefaultTransactionTTL.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	replies map[transactionKey]transaction
+	pruned  time.Time
+}
+
+type transactionKey struct {
+	xid dhcpv4.TransactionID
+	mac string
+	mt  dhcpv4.MessageType
+	// ifIndex and giaddr tell the networks of the messages apart, like VLANs and relayed subnets: a MAC address can
+	// be on more than one of them, and transaction IDs are not unique across clients.
+	ifIndex int
+	giaddr  netip.Addr
+}
+
+type transaction struct {
+	reply *dhcpv4.DHCPv4
+	time  time.Time
+}
+
+func newTransactionKey(p data.Packet) transactionKey {
+	k := transactionKey{xid: p.Pkt.TransactionID, mac: p.Pkt.ClientHWAddr.String(), mt: p.Pkt.MessageType()}
+	if p.Md != nil {
+		k.ifIndex = p.Md.IfIndex
+	}
+	if ip, ok := netip.AddrFromSlice(p.Pkt.GatewayIPAddr.To4()); ok && !ip.IsUnspecified() {
+		k.giaddr = ip
+	}
+
+	return k
+}
+
+// Get returns the reply to a previous transmission of the message of p, nil when there is none within the TTL.
+// The reply is a copy whose header can be modified, its options are shared and must not be modified.
+func (t *Transactions) Get(p data.Packet) *dhcpv4.DHCPv4 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tx, found := t.replies[newTransactionKey(p)]
+	if !found || time.Since(tx.time) > t.ttl() {
+		return nil
+	}
+	reply := *tx.reply
+
+	return &reply
+}
+
+// Put records reply as the reply to the message of p.
+func (t *Transactions) Put(p data.Packet, reply *dhcpv4.DHCPv4) {
+	if t == nil || reply == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.replies == nil {
+		t.replies = map[transactionKey]transaction{}
+	}
+	// expired replies are pruned at most once per TTL.
+	if now.Sub(t.pruned) > t.ttl() {
+		for k, tx := range t.replies {
+			if now.Sub(tx.time) > t.ttl() {
+				delete(t.replies, k)
+			}
+		}
+		t.pruned = now
+	}
+	r := *reply
+	t.replies[newTransactionKey(p)] = transaction{reply: &r, time: now}
+}
+
+// Flush removes all the cached replies, so that backend changes apply to retransmissions right away.
+func (t *Transactions) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.replies = nil
+
+	return nil
+}
+
+func (t *Transactions) ttl() time.Duration {
+	if t.TTL <= 0 {
+		return DefaultTransactionTTL
+	}
+
+	return t.TTL
+}
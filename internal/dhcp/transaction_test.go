@@ -0,0 +1,89 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+func TestTransactions(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	discover, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := dhcpv4.NewReplyFromRequest(discover, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer), dhcpv4.WithYourIP(net.IP{192, 168, 2, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transactions{}
+	if got := tx.Get(packet(discover)); got != nil {
+		t.Fatalf("got a reply before one was recorded: %v", got)
+	}
+	tx.Put(packet(discover), offer)
+
+	got := tx.Get(packet(discover))
+	if diff := cmp.Diff(offer.ToBytes(), got.ToBytes()); diff != "" {
+		t.Fatal(diff)
+	}
+	got.SetBroadcast()
+	if tx.Get(packet(discover)).IsBroadcast() {
+		t.Fatal("modifying a returned reply modified the cached reply")
+	}
+
+	request, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithTransactionID(discover.TransactionID), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tx.Get(packet(request)); got != nil {
+		t.Fatal("got the reply of a DHCPDISCOVER for a DHCPREQUEST of the same transaction")
+	}
+	other, err := dhcpv4.New(dhcpv4.WithHwAddr(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}), dhcpv4.WithTransactionID(discover.TransactionID), dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tx.Get(packet(other)); got != nil {
+		t.Fatal("got the reply of another MAC address with the same transaction ID")
+	}
+
+	vlan := data.Packet{Pkt: discover, Md: &data.Metadata{IfIndex: 7}}
+	if got := tx.Get(vlan); got != nil {
+		t.Fatal("got the reply of another interface with the same transaction ID and MAC address")
+	}
+	relayed, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithTransactionID(discover.TransactionID), dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover), dhcpv4.WithGatewayIP(net.IP{192, 168, 3, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tx.Get(packet(relayed)); got != nil {
+		t.Fatal("got the reply of another relay agent with the same transaction ID and MAC address")
+	}
+
+	if err := tx.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tx.Get(packet(discover)); got != nil {
+		t.Fatal("got a reply after a flush")
+	}
+
+	tx.TTL = time.Nanosecond
+	tx.Put(packet(discover), offer)
+	time.Sleep(time.Millisecond)
+	if got := tx.Get(packet(discover)); got != nil {
+		t.Fatal("got a reply after the TTL")
+	}
+
+	var ntx *Transactions
+	ntx.Put(packet(discover), offer)
+	if got := ntx.Get(packet(discover)); got != nil {
+		t.Fatal("got a reply from a nil Transactions")
+	}
+}
+
+// packet returns pkt as received on the interface with index 2.
+func packet(pkt *dhcpv4.DHCPv4) data.Packet {
+	return data.Packet{Pkt: pkt, Md: &data.Metadata{IfName: "eth0", IfIndex: 2}}
+}
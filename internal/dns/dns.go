@@ -0,0 +1,157 @@
+// Package dns registers DNS records for machines that are served a DHCP reservation.
+//
+// Records are managed as external-dns DNSEndpoint objects, https://github.com/kubernetes-sigs/external-dns,
+// and are removed when the lease they were registered for expires without being renewed.
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const tracerName = "github.com/tinkerbell/smee/internal/dns"
+
+// ManagedLabel is the label set on DNSEndpoint objects created by smee.
+const ManagedLabel = "smee.tinkerbell.org/managed-by"
+
+// EndpointGVK is the external-dns DNSEndpoint GroupVersionKind.
+var EndpointGVK = schema.GroupVersionKind{Group: "externaldns.k8s.io", Version: "v1alpha1", Kind: "DNSEndpoint"}
+
+// Registrar creates and removes DNSEndpoint objects for hostname to IP address records.
+type Registrar struct {
+	// Client is used to create, update and delete DNSEndpoint objects.
+	Client client.Client
+	// Namespace is the namespace in which DNSEndpoint objects are created.
+	Namespace string
+	// Domain is appended to hostnames that are not fully qualified.
+	Domain string
+	// Log is used to log messages.
+	Log logr.Logger
+
+	mu     sync.Mutex // protects leases
+	leases map[string]time.Time
+}
+
+// Register creates or updates the A (or AAAA) record of hostname to ip.
+// The record is removed by Start once leaseTime has passed without the record being registered again.
+func (r *Registrar) Register(ctx context.Context, hostname string, ip netip.Addr, leaseTime time.Duration) error {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "dns.Register")
+	defer span.End()
+
+	fqdn := r.fqdn(hostname)
+	ep := &unstructured.Unstructured{}
+	ep.SetGroupVersionKind(EndpointGVK)
+	ep.SetName(objectName(fqdn))
+	ep.SetNamespace(r.Namespace)
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, ep, func() error {
+		ep.SetLabels(map[string]string{ManagedLabel: "smee"})
+		ep.Object["spec"] = map[string]interface{}{
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"dnsName":    fqdn,
+					"recordType": recordType(ip),
+					"recordTTL":  int64(leaseTime.Seconds()),
+					"targets":    []interface{}{ip.String()},
+				},
+			},
+		}
+		return nil
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed registering dns record for (%v): %w", fqdn, err)
+	}
+
+	r.mu.Lock()
+	if r.leases == nil {
+		r.leases = make(map[string]time.Time)
+	}
+	r.leases[ep.GetName()] = time.Now().Add(leaseTime)
+	r.mu.Unlock()
+	span.SetStatus(codes.Ok, "")
+
+	return nil
+}
+
+// Start removes records whose lease has expired, checking every interval, until ctx is done.
+func (r *Registrar) Start(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			r.expire(ctx, now)
+		}
+	}
+}
+
+// expire deletes the DNSEndpoint objects of leases that expired before now.
+func (r *Registrar) expire(ctx context.Context, now time.Time) {
+	r.mu.Lock()
+	var expired []string
+	for name, exp := range r.leases {
+		if now.After(exp) {
+			expired = append(expired, name)
+			delete(r.leases, name)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, name := range expired {
+		ep := &unstructured.Unstructured{}
+		ep.SetGroupVersionKind(EndpointGVK)
+		ep.SetName(name)
+		ep.SetNamespace(r.Namespace)
+		if err := r.Client.Delete(ctx, ep); err != nil && !apierrors.IsNotFound(err) {
+			r.log().Info("unable to remove expired dns record", "name", name, "error", err)
+			continue
+		}
+		r.log().V(1).Info("removed expired dns record", "name", name)
+	}
+}
+
+func (r *Registrar) log() logr.Logger {
+	if r.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return r.Log
+}
+
+func (r *Registrar) fqdn(hostname string) string {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	if r.Domain == "" || strings.Contains(hostname, ".") {
+		return hostname
+	}
+
+	return fmt.Sprintf("%s.%s", hostname, strings.Trim(r.Domain, "."))
+}
+
+// objectName returns the DNSEndpoint object name for fqdn.
+func objectName(fqdn string) string {
+	return fmt.Sprintf("smee-%s", strings.ReplaceAll(fqdn, ".", "-"))
+}
+
+func recordType(ip netip.Addr) string {
+	if ip.Unmap().Is4() {
+		return "A"
+	}
+
+	return "AAAA"
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func getEndpoint(t *testing.T, c client.Client, name string) (*unstructured.Unstructured, error) {
+	t.Helper()
+	ep := &unstructured.Unstructured{}
+	ep.SetGroupVersionKind(EndpointGVK)
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "tink-system", Name: name}, ep)
+
+	return ep, err
+}
+
+func TestRegister(t *testing.T) {
+	tests := map[string]struct {
+		hostname string
+		domain   string
+		ip       netip.Addr
+		wantName string
+		want     map[string]interface{}
+	}{
+		"ipv4 with domain": {
+			hostname: "SM01",
+			domain:   "lab.example.com.",
+			ip:       netip.MustParseAddr("192.168.2.10"),
+			wantName: "smee-sm01-lab-example-com",
+			want:     map[string]interface{}{"dnsName": "sm01.lab.example.com", "recordType": "A", "recordTTL": int64(3600), "targets": []interface{}{"192.168.2.10"}},
+		},
+		"fully qualified ipv6": {
+			hostname: "sm01.example.com",
+			domain:   "lab.example.com",
+			ip:       netip.MustParseAddr("2001:db8::10"),
+			wantName: "smee-sm01-example-com",
+			want:     map[string]interface{}{"dnsName": "sm01.example.com", "recordType": "AAAA", "recordTTL": int64(3600), "targets": []interface{}{"2001:db8::10"}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build()
+			r := &Registrar{Client: c, Namespace: "tink-system", Domain: tt.domain}
+			if err := r.Register(context.Background(), tt.hostname, tt.ip, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			// registering again, as on lease renewal, updates the record.
+			if err := r.Register(context.Background(), tt.hostname, tt.ip, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			ep, err := getEndpoint(t, c, tt.wantName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, _ := unstructured.NestedSlice(ep.Object, "spec", "endpoints")
+			if diff := cmp.Diff([]interface{}{tt.want}, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build()
+	r := &Registrar{Client: c, Namespace: "tink-system"}
+	if err := r.Register(context.Background(), "sm01", netip.MustParseAddr("192.168.2.10"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(context.Background(), "sm02", netip.MustParseAddr("192.168.2.11"), 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	r.expire(context.Background(), time.Now().Add(2*time.Hour))
+
+	if _, err := getEndpoint(t, c, "smee-sm01"); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected expired record to be removed, got: %v", err)
+	}
+	if _, err := getEndpoint(t, c, "smee-sm02"); err != nil {
+		t.Fatalf("expected record to be kept, got: %v", err)
+	}
+}
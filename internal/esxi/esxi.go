@@ -0,0 +1,255 @@
+// Package esxi serves the VMware ESXi installer, and per machine boot.cfg files, to the machines of an ESXi boot
+// profile, so that ESXi can be netbooted without a separate PXE infrastructure.
+//
+// DHCP points the UEFI clients of an ESXi boot profile at mboot.efi, the ESXi boot loader, instead of an iPXE binary.
+// mboot.efi loads 01-<mac address>/boot.cfg, or boot.cfg, from the location it was loaded from. The boot.cfg of the
+// installer in Dir is rewritten per machine: its prefix is the HTTP URL of the installer files, so that the kernel
+// and the modules are downloaded over HTTP instead of TFTP, and the kernel options of the machine, like the
+// kickstart file URL, are appended to its kernel options.
+package esxi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/ipxedust/itftp"
+	"github.com/tinkerbell/smee/internal/httpfile"
+	"github.com/tinkerbell/smee/internal/limit"
+)
+
+// BootConfigName is the name of the boot config file that mboot.efi loads.
+const BootConfigName = "boot.cfg"
+
+// macDirPrefix is the prefix of the MAC specific directory that mboot.efi loads the boot config from first.
+const macDirPrefix = "01-"
+
+// traceparent matches a traceparent that was appended to a file name, see the OTELEnabled DHCP handler option.
+var traceparent = regexp.MustCompile(`^(.+)-00-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// Configurer returns the kernel options that are appended to the boot config of a machine.
+type Configurer interface {
+	ESXiKernelOpts(ctx context.Context, mac net.HardwareAddr) ([]string, error)
+}
+
+// Handler serves the ESXi installer files over HTTP and TFTP. Requests for any other file are passed to the
+// fallback handlers.
+type Handler struct {
+	// Dir is the directory holding the files of the ESXi installer ISO: mboot.efi, boot.cfg and the modules.
+	Dir string
+	// Prefix is the base URL of the installer files that the boot configs load the kernel and the modules from,
+	// for example http://192.168.2.111:8080/esxi/.
+	Prefix string
+	// Config returns the kernel options of the machines.
+	Config Configurer
+	Log    logr.Logger
+	// HTTPFallback serves HTTP requests for files that are not installer files.
+	HTTPFallback http.HandlerFunc
+	// TFTPFallback serves TFTP reads of files that are not installer files.
+	TFTPFallback func(filename string, rf io.ReaderFrom) error
+	// TFTPSessions, when set, bounds the number of concurrent TFTP sessions.
+	TFTPSessions *limit.Limiter
+}
+
+// file is a resolved installer file, either a generated boot config or a file from Dir.
+type file struct {
+	name   string
+	config []byte
+	path   string
+}
+
+// resolve returns the installer file for the requested path. ok is false if the path is not an installer file.
+// The MAC address of a boot config is taken from its parent directory, 01-<mac>/boot.cfg or <mac>/boot.cfg.
+// A boot config without a MAC address has no machine kernel options.
+func (h *Handler) resolve(ctx context.Context, p string) (f file, ok bool, err error) {
+	name := path.Base(p)
+	if m := traceparent.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	}
+	f.name = name
+	if h.Dir == "" || name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		return f, false, nil
+	}
+	f.path = filepath.Join(h.Dir, name)
+	if fi, err := os.Stat(f.path); err != nil || !fi.Mode().IsRegular() {
+		return f, false, nil
+	}
+	if name != BootConfigName {
+		return f, true, nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return f, true, err
+	}
+	f.path = ""
+	var opts []string
+	if mac, err := net.ParseMAC(strings.TrimPrefix(path.Base(path.Dir(p)), macDirPrefix)); err == nil {
+		if h.Config == nil {
+			return f, true, errors.New("no kernel options configurer is set")
+		}
+		if opts, err = h.Config.ESXiKernelOpts(ctx, mac); err != nil {
+			return f, true, fmt.Errorf("failed to generate the ESXi boot config for (%v): %w", mac, err)
+		}
+	}
+	f.config = BootConfig(b, h.Prefix, opts)
+
+	return f, true, nil
+}
+
+// BootConfig returns the boot.cfg of an ESXi installer, cfg, rewritten to load the kernel and the modules from prefix,
+// with opts appended to its kernel options. The leading slash of the kernel and module paths is removed, mboot.efi
+// loads absolute paths from the root of its server instead of prefix. The cdromBoot kernel option is removed, the
+// installer doesn't boot from a CD.
+func BootConfig(cfg []byte, prefix string, opts []string) []byte {
+	var out bytes.Buffer
+	hasPrefix := false
+	for _, line := range strings.Split(strings.TrimRight(strings.ReplaceAll(string(cfg), "\r\n", "\n"), "\n"), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "prefix":
+			value, hasPrefix = prefix, true
+		case "kernel":
+			value = strings.TrimPrefix(strings.TrimSpace(value), "/")
+		case "modules":
+			modules := strings.Split(value, "---")
+			for i, m := range modules {
+				modules[i] = strings.TrimPrefix(strings.TrimSpace(m), "/")
+			}
+			value = strings.Join(modules, " --- ")
+		case "kernelopt":
+			value = strings.Join(append(slices.DeleteFunc(strings.Fields(value), func(o string) bool {
+				return o == "cdromBoot"
+			}), opts...), " ")
+		default:
+			out.WriteString(line + "\n")
+			continue
+		}
+		out.WriteString(key + "=" + value + "\n")
+	}
+	if !hasPrefix {
+		out.WriteString("prefix=" + prefix + "\n")
+	}
+
+	return out.Bytes()
+}
+
+// ServeHTTP serves the installer files over HTTP.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, ok, err := h.resolve(r.Context(), r.URL.Path)
+	if !ok {
+		if h.HTTPFallback == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.HTTPFallback(w, r)
+		return
+	}
+	log := h.log().WithValues("path", r.URL.Path, "client", r.RemoteAddr)
+	if err != nil {
+		log.Info("not serving esxi file", "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	if !httpfile.Allowed(w, r) {
+		return
+	}
+	if f.path != "" {
+		if _, err := httpfile.ServeFile(w, r, f.path); err != nil {
+			log.Error(err, "unable to open esxi file", "file", f.name)
+			http.NotFound(w, r)
+			return
+		}
+		log.V(1).Info("served esxi file", "file", f.name)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	httpfile.ServeBytes(w, r, f.name, time.Time{}, f.config)
+	log.Info("served esxi boot config", "file", f.name)
+}
+
+// HandleRead serves the installer files over TFTP. The function signature satisfies the tftp.Server read handler
+// parameter type.
+func (h *Handler) HandleRead(filename string, rf io.ReaderFrom) error {
+	f, ok, err := h.resolve(context.Background(), filename)
+	if !ok {
+		if h.TFTPFallback == nil {
+			return fmt.Errorf("file [%v] unknown: %w", filename, os.ErrNotExist)
+		}
+		return h.TFTPFallback(filename, rf)
+	}
+	log := h.log().WithValues("filename", filename)
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		log = log.WithValues("client", ot.RemoteAddr())
+	}
+	if err != nil {
+		log.Info("not serving esxi file", "error", err)
+		return fmt.Errorf("%w: %w", os.ErrNotExist, err)
+	}
+
+	var r io.Reader = bytes.NewReader(f.config)
+	size := int64(len(f.config))
+	if f.path != "" {
+		fh, err := os.Open(f.path)
+		if err != nil {
+			log.Error(err, "unable to open esxi file")
+			return err
+		}
+		defer fh.Close()
+		fi, err := fh.Stat()
+		if err != nil {
+			return err
+		}
+		r, size = fh, fi.Size()
+	}
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		ot.SetSize(size)
+	}
+	b, err := rf.ReadFrom(r)
+	if err != nil {
+		log.Error(err, "file serve failed", "bytesSent", b, "contentSize", size)
+		return err
+	}
+	log.Info("served esxi file", "file", f.name, "bytesSent", b)
+
+	return nil
+}
+
+// ServeTFTP serves the installer files, and the files of the TFTPFallback, over TFTP on conn, that it closes when
+// ctx is done.
+func (h *Handler) ServeTFTP(ctx context.Context, conn net.PacketConn, timeout time.Duration, blockSize int) error {
+	w := itftp.Handler{Log: h.log()}
+	ts := tftp.NewServer(limit.TFTPReadHandler(h.TFTPSessions, h.HandleRead), w.HandleWrite)
+	ts.SetTimeout(timeout)
+	ts.SetBlockSize(blockSize)
+	ts.EnableSinglePort()
+	h.log().Info("serving esxi files via TFTP", "addr", conn.LocalAddr(), "dir", h.Dir)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+		ts.Shutdown()
+	}()
+
+	return itftp.Serve(ctx, conn, ts)
+}
+
+func (h *Handler) log() logr.Logger {
+	if h.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return h.Log
+}
@@ -0,0 +1,128 @@
+package esxi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const installerConfig = "bootstate=0\r\ntitle=Loading ESXi installer\r\ntimeout=5\r\nprefix=\r\nkernel=/b.b00\r\nkernelopt=runweasel cdromBoot\r\nmodules=/jumpstrt.gz --- /useropts.gz --- /features.gz --- /k.b00\r\nbuild=8.0.2-0.0.22380479\r\nupdated=0\r\n"
+
+type fakeConfigurer struct{}
+
+func (fakeConfigurer) ESXiKernelOpts(_ context.Context, mac net.HardwareAddr) ([]string, error) {
+	if mac.String() == "00:01:02:03:04:05" {
+		return []string{"ks=http://10.1.0.5/ks/" + mac.String()}, nil
+	}
+
+	return nil, errors.New("not found")
+}
+
+type fakeReaderFrom struct {
+	bytes.Buffer
+}
+
+func (f *fakeReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	return f.Buffer.ReadFrom(r)
+}
+
+func newHandler(t *testing.T) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{"mboot.efi": "mboot", "b.b00": "kernel", BootConfigName: installerConfig} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Handler{
+		Dir:    dir,
+		Prefix: "http://10.1.0.2:8080/esxi/",
+		Config: fakeConfigurer{},
+		HTTPFallback: func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("fallback"))
+		},
+		TFTPFallback: func(_ string, rf io.ReaderFrom) error {
+			_, err := rf.ReadFrom(bytes.NewReader([]byte("fallback")))
+			return err
+		},
+	}
+}
+
+func TestBootConfig(t *testing.T) {
+	want := `bootstate=0
+title=Loading ESXi installer
+timeout=5
+prefix=http://10.1.0.2:8080/esxi/
+kernel=b.b00
+kernelopt=runweasel ks=http://10.1.0.5/ks.cfg
+modules=jumpstrt.gz --- useropts.gz --- features.gz --- k.b00
+build=8.0.2-0.0.22380479
+updated=0
+`
+	got := BootConfig([]byte(installerConfig), "http://10.1.0.2:8080/esxi/", []string{"ks=http://10.1.0.5/ks.cfg"})
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Fatal(diff)
+	}
+	got = BootConfig([]byte("kernel=/b.b00\nkernelopt=runweasel\n"), "http://10.1.0.2:8080/esxi/", nil)
+	if diff := cmp.Diff("kernel=b.b00\nkernelopt=runweasel\nprefix=http://10.1.0.2:8080/esxi/\n", string(got)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := map[string]struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		"mboot":                  {path: "/ipxe/00:01:02:03:04:05/mboot.efi", wantCode: http.StatusOK, wantBody: "mboot"},
+		"mboot with traceparent": {path: "/ipxe/mboot.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", wantCode: http.StatusOK, wantBody: "mboot"},
+		"module":                 {path: "/esxi/b.b00", wantCode: http.StatusOK, wantBody: "kernel"},
+		"boot config in mac dir": {path: "/ipxe/00:01:02:03:04:05/boot.cfg", wantCode: http.StatusOK, wantBody: "ks=http://10.1.0.5/ks/00:01:02:03:04:05"},
+		"boot config in 01 dir":  {path: "/01-00-01-02-03-04-05/boot.cfg", wantCode: http.StatusOK, wantBody: "ks=http://10.1.0.5/ks/00:01:02:03:04:05"},
+		"unknown machine":        {path: "/01-00-01-02-03-04-06/boot.cfg", wantCode: http.StatusNotFound, wantBody: "404 page not found\n"},
+		"ipxe binary":            {path: "/ipxe/00:01:02:03:04:05/ipxe.efi", wantCode: http.StatusOK, wantBody: "fallback"},
+		"no traversal":           {path: "/esxi/../../etc/passwd", wantCode: http.StatusOK, wantBody: "fallback"},
+	}
+	h := newHandler(t)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			if !bytes.Contains(w.Body.Bytes(), []byte(tt.wantBody)) {
+				t.Fatalf("expected the body to contain %q, got %q", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleRead(t *testing.T) {
+	h := newHandler(t)
+	rf := &fakeReaderFrom{}
+	if err := h.HandleRead("boot.cfg", rf); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(BootConfig([]byte(installerConfig), h.Prefix, nil)), rf.String()); diff != "" {
+		t.Fatal(diff)
+	}
+	rf = &fakeReaderFrom{}
+	if err := h.HandleRead("snp.efi", rf); err != nil || rf.String() != "fallback" {
+		t.Fatalf("expected the fallback, got %q, %v", rf.String(), err)
+	}
+	if err := h.HandleRead("01-00-01-02-03-04-06/boot.cfg", &fakeReaderFrom{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+// Dnsmasq writes the dnsmasq configuration of records to w.
+//
+// Machines that are allowed to netboot are chainloaded to the iPXE binary of their architecture from the TFTP server.
+// Once running the iPXE binary, identified by the Tinkerbell user class, they are pointed at their iPXE script.
+func Dnsmasq(w io.Writer, c Config, records []data.Record) error {
+	p := newPlan(c, records)
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "# Generated by smee export.")
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "# machines running the iPXE binaries served by Smee identify with the Tinkerbell user class.")
+	fmt.Fprintf(bw, "dhcp-userclass=set:%s,Tinkerbell\n", tag("tinkerbell"))
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "# iPXE binaries, by client architecture (DHCP option 93).")
+	for _, b := range p.binaries {
+		for _, a := range b.archs {
+			fmt.Fprintf(bw, "dhcp-match=set:%s,option:client-arch,%d\n", tag(b.name), a)
+		}
+	}
+	for _, b := range p.binaries {
+		f := []string{"tag:" + tag("netboot"), "tag:!" + tag("tinkerbell"), "tag:" + tag(b.name), b.name}
+		if c.TFTPServer.IsValid() {
+			f = append(f, "", c.TFTPServer.String())
+		}
+		fmt.Fprintf(bw, "dhcp-boot=%s\n", strings.Join(f, ","))
+	}
+	if len(p.scripts) > 0 {
+		fmt.Fprintln(bw)
+		fmt.Fprintln(bw, "# iPXE scripts.")
+		for i, s := range p.scripts {
+			fmt.Fprintf(bw, "dhcp-boot=tag:%s,tag:%s,%s\n", scriptTag(i), tag("tinkerbell"), s)
+		}
+	}
+	if len(p.hosts) > 0 {
+		fmt.Fprintln(bw)
+		fmt.Fprintln(bw, "# machines.")
+		for _, h := range p.hosts {
+			f := []string{h.mac}
+			if h.script >= 0 {
+				f = append(f, "set:"+tag("netboot"), "set:"+scriptTag(h.script))
+			}
+			if h.ip != "" {
+				f = append(f, h.ip)
+			}
+			if h.hostname != "" {
+				f = append(f, h.hostname)
+			}
+			if h.leaseTime > 0 {
+				f = append(f, strconv.FormatUint(uint64(h.leaseTime), 10))
+			}
+			fmt.Fprintf(bw, "dhcp-host=%s\n", strings.Join(f, ","))
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,125 @@
+// Package export renders backend data into the configuration of external DHCP servers,
+// so that sites that keep their existing DHCP server can still network boot machines from Smee.
+package export
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/dhcp"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+// Config holds the Smee services that the exported configuration points machines at.
+type Config struct {
+	// TFTPServer is the IP address of the TFTP server that serves the iPXE binaries.
+	TFTPServer netip.Addr
+	// IPXEScriptURL returns the iPXE script URL of a machine.
+	// It is served to machines identifying with the Tinkerbell user class, the iPXE binaries served by Smee.
+	// The IPXEScriptURL of the netboot data of a machine takes precedence.
+	IPXEScriptURL func(net.HardwareAddr) *url.URL
+}
+
+// host is a record that is exported.
+type host struct {
+	mac       string
+	ip        string
+	hostname  string
+	leaseTime uint32
+	// script is the index, in scripts, of the iPXE script URL of the host, -1 when it is not allowed to netboot.
+	script int
+}
+
+// binary is an iPXE binary and the client architectures (DHCP option 93) that boot it.
+type binary struct {
+	name  string
+	archs []iana.Arch
+}
+
+// plan is the exported configuration, independent of the DHCP server.
+type plan struct {
+	hosts    []host
+	scripts  []string
+	binaries []binary
+}
+
+// newPlan returns the plan of records. Records that have DHCP disabled or no MAC address are skipped.
+func newPlan(c Config, records []data.Record) plan {
+	p := plan{binaries: binaries()}
+	scripts := map[string]int{}
+	for _, r := range records {
+		if r.DHCP == nil || r.DHCP.Disabled || len(r.DHCP.MACAddress) == 0 {
+			continue
+		}
+		h := host{mac: r.DHCP.MACAddress.String(), hostname: r.DHCP.Hostname, leaseTime: r.DHCP.LeaseTime, script: -1}
+		if r.DHCP.IPAddress.IsValid() {
+			h.ip = r.DHCP.IPAddress.String()
+		}
+		if r.Netboot != nil && r.Netboot.AllowNetboot {
+			u := scriptURL(c, r)
+			i, ok := scripts[u]
+			if !ok {
+				i = len(p.scripts)
+				scripts[u] = i
+				p.scripts = append(p.scripts, u)
+			}
+			h.script = i
+		}
+		p.hosts = append(p.hosts, h)
+	}
+
+	return p
+}
+
+func scriptURL(c Config, r data.Record) string {
+	if r.Netboot.IPXEScriptURL != nil {
+		return r.Netboot.IPXEScriptURL.String()
+	}
+	if c.IPXEScriptURL == nil {
+		return ""
+	}
+
+	return c.IPXEScriptURL(r.DHCP.MACAddress).String()
+}
+
+// binaries returns the iPXE binaries that are served over TFTP, by client architecture.
+// HTTP boot clients are not exported, they get the binary from a URL instead of TFTP.
+func binaries() []binary {
+	byName := map[string][]iana.Arch{}
+	for a, name := range dhcp.ArchToBootFile {
+		switch a {
+		case iana.EFI_X86_HTTP, iana.EFI_X86_64_HTTP, iana.EFI_ARM32_HTTP, iana.EFI_ARM64_HTTP:
+			continue
+		}
+		byName[name] = append(byName[name], a)
+	}
+	bins := make([]binary, 0, len(byName))
+	for name, archs := range byName {
+		slices.Sort(archs)
+		bins = append(bins, binary{name: name, archs: archs})
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].name < bins[j].name })
+
+	return bins
+}
+
+// tag returns a tag or class name for name, only letters, digits and dashes are kept.
+func tag(name string) string {
+	return "smee-" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+func scriptTag(i int) string {
+	return tag(fmt.Sprintf("script-%d", i))
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+var (
+	config = Config{
+		TFTPServer: netip.MustParseAddr("192.168.2.1"),
+		IPXEScriptURL: func(net.HardwareAddr) *url.URL {
+			return &url.URL{Scheme: "http", Host: "192.168.2.1:8080", Path: "/auto.ipxe"}
+		},
+	}
+	records = []data.Record{
+		{
+			DHCP:    &data.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, IPAddress: netip.MustParseAddr("192.168.2.10"), Hostname: "node1", LeaseTime: 86400},
+			Netboot: &data.Netboot{AllowNetboot: true},
+		},
+		{
+			DHCP:    &data.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}, IPAddress: netip.MustParseAddr("192.168.2.11")},
+			Netboot: &data.Netboot{AllowNetboot: true, IPXEScriptURL: &url.URL{Scheme: "https", Host: "boot.netboot.xyz"}},
+		},
+		{
+			DHCP:    &data.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x07}, IPAddress: netip.MustParseAddr("192.168.2.12"), Hostname: "node3"},
+			Netboot: &data.Netboot{},
+		},
+		{
+			DHCP:    &data.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x08}, Disabled: true},
+			Netboot: &data.Netboot{AllowNetboot: true},
+		},
+	}
+)
+
+func TestDnsmasq(t *testing.T) {
+	want := `# iPXE scripts.
+dhcp-boot=tag:smee-script-0,tag:smee-tinkerbell,http://192.168.2.1:8080/auto.ipxe
+dhcp-boot=tag:smee-script-1,tag:smee-tinkerbell,https://boot.netboot.xyz
+
+# machines.
+dhcp-host=00:01:02:03:04:05,set:smee-netboot,set:smee-script-0,192.168.2.10,node1,86400
+dhcp-host=00:01:02:03:04:06,set:smee-netboot,set:smee-script-1,192.168.2.11
+dhcp-host=00:01:02:03:04:07,192.168.2.12,node3
+`
+	out := &bytes.Buffer{}
+	if err := Dnsmasq(out, config, records); err != nil {
+		t.Fatal(err)
+	}
+	_, got, ok := strings.Cut(out.String(), "\n\n# iPXE scripts.")
+	if !ok {
+		t.Fatalf("no iPXE scripts in:\n%s", out)
+	}
+	if diff := cmp.Diff(want, "# iPXE scripts."+got); diff != "" {
+		t.Fatal(diff)
+	}
+	for _, line := range []string{
+		"dhcp-userclass=set:smee-tinkerbell,Tinkerbell",
+		"dhcp-match=set:smee-ipxe-efi,option:client-arch,7",
+		"dhcp-match=set:smee-snp-efi,option:client-arch,11",
+		"dhcp-match=set:smee-undionly-kpxe,option:client-arch,0",
+		"dhcp-boot=tag:smee-netboot,tag:!smee-tinkerbell,tag:smee-ipxe-efi,ipxe.efi,,192.168.2.1",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing line %q", line)
+		}
+	}
+}
+
+func TestKea(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := Kea(out, config, records); err != nil {
+		t.Fatal(err)
+	}
+	var got keaConfig
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	wantReservations := []keaReservation{
+		{HWAddress: "00:01:02:03:04:05", IPAddress: "192.168.2.10", Hostname: "node1", ClientClasses: []string{"smee-netboot", "smee-script-0"}},
+		{HWAddress: "00:01:02:03:04:06", IPAddress: "192.168.2.11", ClientClasses: []string{"smee-netboot", "smee-script-1"}},
+		{HWAddress: "00:01:02:03:04:07", IPAddress: "192.168.2.12", Hostname: "node3"},
+	}
+	if diff := cmp.Diff(wantReservations, got.Reservations); diff != "" {
+		t.Fatal(diff)
+	}
+
+	classes := map[string]keaClass{}
+	for _, c := range got.ClientClasses {
+		classes[c.Name] = c
+	}
+	wantScript := keaClass{
+		Name:         "smee-script-1-boot",
+		Test:         "member('KNOWN') and member('smee-script-1') and substring(option[77].hex,0,10) == 'Tinkerbell'",
+		BootFileName: "https://boot.netboot.xyz",
+	}
+	if diff := cmp.Diff(wantScript, classes["smee-script-1-boot"]); diff != "" {
+		t.Fatal(diff)
+	}
+	efi := classes["smee-ipxe-efi"]
+	if efi.NextServer != "192.168.2.1" || efi.BootFileName != "ipxe.efi" || !strings.Contains(efi.Test, "option[93].hex == 0x0007") {
+		t.Fatalf("unexpected ipxe.efi class: %+v", efi)
+	}
+}
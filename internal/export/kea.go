@@ -0,0 +1,78 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+// keaConfig is the part of a Kea Dhcp4 configuration that is exported.
+type keaConfig struct {
+	ClientClasses []keaClass       `json:"client-classes"`
+	Reservations  []keaReservation `json:"reservations"`
+}
+
+type keaClass struct {
+	Name         string `json:"name"`
+	Test         string `json:"test"`
+	NextServer   string `json:"next-server,omitempty"`
+	BootFileName string `json:"boot-file-name,omitempty"`
+}
+
+type keaReservation struct {
+	HWAddress     string   `json:"hw-address"`
+	IPAddress     string   `json:"ip-address,omitempty"`
+	Hostname      string   `json:"hostname,omitempty"`
+	ClientClasses []string `json:"client-classes,omitempty"`
+}
+
+// tinkerbellTest is the Kea expression matching the Tinkerbell user class of the iPXE binaries served by Smee.
+const tinkerbellTest = "substring(option[77].hex,0,10) == 'Tinkerbell'"
+
+// Kea writes the client-classes and reservations of a Kea Dhcp4 configuration of records to w, as JSON.
+//
+// Reservations of machines that are allowed to netboot are assigned to classes. The client classes test
+// for these classes, together with KNOWN so that Kea evaluates them after the host reservation lookup.
+func Kea(w io.Writer, c Config, records []data.Record) error {
+	p := newPlan(c, records)
+	kc := keaConfig{ClientClasses: []keaClass{}, Reservations: []keaReservation{}}
+
+	for _, b := range p.binaries {
+		archs := make([]string, 0, len(b.archs))
+		for _, a := range b.archs {
+			archs = append(archs, fmt.Sprintf("option[93].hex == 0x%04x", uint16(a)))
+		}
+		cl := keaClass{
+			Name:         tag(b.name),
+			Test:         fmt.Sprintf("member('KNOWN') and member('%s') and not (%s) and (%s)", tag("netboot"), tinkerbellTest, strings.Join(archs, " or ")),
+			BootFileName: b.name,
+		}
+		if c.TFTPServer.IsValid() {
+			cl.NextServer = c.TFTPServer.String()
+		}
+		kc.ClientClasses = append(kc.ClientClasses, cl)
+	}
+	for i, s := range p.scripts {
+		kc.ClientClasses = append(kc.ClientClasses, keaClass{
+			Name:         scriptTag(i) + "-boot",
+			Test:         fmt.Sprintf("member('KNOWN') and member('%s') and %s", scriptTag(i), tinkerbellTest),
+			BootFileName: s,
+		})
+	}
+	for _, h := range p.hosts {
+		r := keaReservation{HWAddress: h.mac, IPAddress: h.ip, Hostname: h.hostname}
+		if h.script >= 0 {
+			r.ClientClasses = []string{tag("netboot"), scriptTag(h.script)}
+		}
+		kc.Reservations = append(kc.Reservations, r)
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.SetEscapeHTML(false)
+
+	return e.Encode(kc)
+}
@@ -0,0 +1,112 @@
+// Package facility overrides Smee settings per facility, so that one Smee configuration can serve the machines of multiple sites.
+//
+// The facility of a machine comes from its backend record. Machines without a facility, or with a facility
+// that has no overrides, use the values Smee is configured with.
+//
+//	facilities:
+//	  sjc1:
+//	    osieURL: http://10.1.0.5:8080/hook
+//	    tinkServer: 10.1.0.6:42113
+//	    syslogIP: 10.1.0.7
+//	    extraKernelArgs: ["tink_worker_image=10.1.0.8/tinkerbell/tink-worker:latest"]
+//	  ams2:
+//	    tinkServer: 10.2.0.6:42113
+package facility
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/ghodss/yaml"
+)
+
+// Config holds the overrides of each facility.
+type Config struct {
+	Facilities map[string]Override `json:"facilities"`
+}
+
+// Override holds the values that a facility overrides. Empty fields are not overridden.
+type Override struct {
+	// OSIEURL is the URL where OSIE (HookOS) images are located.
+	OSIEURL string `json:"osieURL,omitempty"`
+	// TinkServer is the IP:port of the Tink server.
+	TinkServer string `json:"tinkServer,omitempty"`
+	// SyslogIP is the IP address of the syslog server, it is served in DHCP and the kernel args.
+	SyslogIP string `json:"syslogIP,omitempty"`
+	// ExtraKernelArgs are appended to the kernel cmdline, in place of the configured extra kernel args.
+	ExtraKernelArgs []string `json:"extraKernelArgs,omitempty"`
+}
+
+// Load reads and validates a YAML, or JSON, facility config file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, facility config.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse facility config: %w", err)
+	}
+	names := make([]string, 0, len(c.Facilities))
+	for name := range c.Facilities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if err := c.Facilities[name].validate(); err != nil {
+			errs = append(errs, fmt.Errorf("facility %s: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func (o Override) validate() error {
+	if o.OSIEURL != "" {
+		if _, err := url.ParseRequestURI(o.OSIEURL); err != nil {
+			return fmt.Errorf("invalid osieURL: %w", err)
+		}
+	}
+	if o.SyslogIP != "" {
+		a, err := netip.ParseAddr(o.SyslogIP)
+		if err != nil {
+			return fmt.Errorf("invalid syslogIP: %w", err)
+		}
+		if !a.Is4() {
+			return fmt.Errorf("invalid syslogIP %q, must be an IPv4 address", o.SyslogIP)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the overrides of a facility.
+// A nil Config, an empty facility or a facility without overrides returns the zero Override.
+func (c *Config) Get(facility string) Override {
+	if c == nil || facility == "" {
+		return Override{}
+	}
+
+	return c.Facilities[facility]
+}
+
+// SyslogAddr returns the parsed SyslogIP, it is the zero netip.Addr when SyslogIP is not overridden.
+func (o Override) SyslogAddr() netip.Addr {
+	a, _ := netip.ParseAddr(o.SyslogIP)
+
+	return a
+}
@@ -0,0 +1,81 @@
+package facility
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testConfig = `
+facilities:
+  sjc1:
+    osieURL: http://10.1.0.5:8080/hook
+    tinkServer: 10.1.0.6:42113
+    syslogIP: 10.1.0.7
+    extraKernelArgs: ["tink_worker_image=10.1.0.8/tink-worker:latest"]
+  ams2:
+    tinkServer: 10.2.0.6:42113
+`
+
+func TestGet(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		config   *Config
+		facility string
+		want     Override
+	}{
+		"all overrides": {
+			config:   c,
+			facility: "sjc1",
+			want: Override{
+				OSIEURL:         "http://10.1.0.5:8080/hook",
+				TinkServer:      "10.1.0.6:42113",
+				SyslogIP:        "10.1.0.7",
+				ExtraKernelArgs: []string{"tink_worker_image=10.1.0.8/tink-worker:latest"},
+			},
+		},
+		"some overrides":   {config: c, facility: "ams2", want: Override{TinkServer: "10.2.0.6:42113"}},
+		"unknown facility": {config: c, facility: "lab1"},
+		"no facility":      {config: c},
+		"nil config":       {facility: "sjc1"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tt.config.Get(tt.facility)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+	if got := c.Get("sjc1").SyslogAddr(); got != netip.MustParseAddr("10.1.0.7") {
+		t.Fatalf("got syslog address %v, want 10.1.0.7", got)
+	}
+	if got := c.Get("ams2").SyslogAddr(); got.IsValid() {
+		t.Fatalf("got syslog address %v, want none", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		config  string
+		wantErr string
+	}{
+		"invalid yaml":         {config: "facilities: [", wantErr: "failed to parse facility config"},
+		"invalid osie url":     {config: "facilities: {sjc1: {osieURL: not-a-url}}", wantErr: "facility sjc1: invalid osieURL"},
+		"invalid syslog ip":    {config: "facilities: {sjc1: {syslogIP: nope}}", wantErr: "facility sjc1: invalid syslogIP"},
+		"ipv6 syslog ip":       {config: "facilities: {sjc1: {syslogIP: '2001:db8::1'}}", wantErr: "must be an IPv4 address"},
+		"all invalid reported": {config: "facilities: {a: {syslogIP: nope}, b: {osieURL: nope}}", wantErr: "facility a: invalid syslogIP: ParseAddr(\"nope\"): unable to parse IP\nfacility b: invalid osieURL"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package handoff verifies that a machine hands off to the Tink server after being served a boot script.
+//
+// The common failure this catches is a machine that booted HookOS but whose tink-worker never connected
+// to the Tink server, leaving the machine's Workflow pending.
+package handoff
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Verifier checks that the pending Workflows of a machine have started within Timeout of its boot script being served.
+type Verifier struct {
+	// Client is used to read Workflow objects.
+	Client client.Client
+	// Timeout is how long a machine has to start its pending Workflows.
+	Timeout time.Duration
+	// Log is used to log messages.
+	Log logr.Logger
+
+	mu       sync.Mutex // protects inFlight
+	inFlight map[string]struct{}
+}
+
+// ScriptServed implements the script.Observer interface.
+// It records the pending Workflows for mac and verifies, in the background, that they have started after v.Timeout.
+func (v *Verifier) ScriptServed(ctx context.Context, mac net.HardwareAddr, _ string) {
+	v.mu.Lock()
+	if v.inFlight == nil {
+		v.inFlight = make(map[string]struct{})
+	}
+	if _, ok := v.inFlight[mac.String()]; ok {
+		v.mu.Unlock()
+		return
+	}
+	v.inFlight[mac.String()] = struct{}{}
+	v.mu.Unlock()
+
+	pending, err := v.pendingWorkflows(ctx, mac)
+	if err != nil || len(pending) == 0 {
+		if err != nil {
+			v.log().Info("unable to list workflows, skipping tink server handoff verification", "mac", mac, "error", err)
+		}
+		v.done(mac)
+		return
+	}
+
+	go func() {
+		defer v.done(mac)
+		time.Sleep(v.Timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		v.verify(ctx, mac, pending)
+	}()
+}
+
+func (v *Verifier) log() logr.Logger {
+	if v.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return v.Log
+}
+
+func (v *Verifier) done(mac net.HardwareAddr) {
+	v.mu.Lock()
+	delete(v.inFlight, mac.String())
+	v.mu.Unlock()
+}
+
+// verify checks that none of the pending Workflows are still pending.
+func (v *Verifier) verify(ctx context.Context, mac net.HardwareAddr, pending []client.ObjectKey) {
+	for _, key := range pending {
+		wf := &v1alpha1.Workflow{}
+		if err := v.Client.Get(ctx, key, wf); err != nil {
+			v.log().Info("unable to get workflow for tink server handoff verification", "mac", mac, "workflow", key.String(), "error", err)
+			continue
+		}
+		if isPending(wf) {
+			metric.TinkHandoffs.With(prometheus.Labels{"result": "not_started"}).Inc()
+			v.log().Info("workflow did not start after the boot script was served, tink worker may not have connected to the tink server", "mac", mac, "workflow", key.String(), "timeout", v.Timeout)
+			continue
+		}
+		metric.TinkHandoffs.With(prometheus.Labels{"result": "started"}).Inc()
+		v.log().V(1).Info("workflow started", "mac", mac, "workflow", key.String(), "state", wf.Status.State)
+	}
+}
+
+// pendingWorkflows returns the keys of pending Workflows that reference mac in their hardware map.
+func (v *Verifier) pendingWorkflows(ctx context.Context, mac net.HardwareAddr) ([]client.ObjectKey, error) {
+	wfl := &v1alpha1.WorkflowList{}
+	if err := v.Client.List(ctx, wfl); err != nil {
+		return nil, err
+	}
+	var keys []client.ObjectKey
+	for _, wf := range wfl.Items {
+		if !isPending(&wf) {
+			continue
+		}
+		for _, m := range wf.Spec.HardwareMap {
+			if strings.EqualFold(m, mac.String()) {
+				keys = append(keys, client.ObjectKeyFromObject(&wf))
+				break
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+func isPending(wf *v1alpha1.Workflow) bool {
+	return wf.Status.State == "" || wf.Status.State == v1alpha1.WorkflowStatePending
+}
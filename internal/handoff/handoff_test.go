@@ -0,0 +1,92 @@
+package handoff
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func workflow(name, mac string, state v1alpha1.WorkflowState) *v1alpha1.Workflow {
+	return &v1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tink"},
+		Spec:       v1alpha1.WorkflowSpec{HardwareMap: map[string]string{"device_1": mac}},
+		Status:     v1alpha1.WorkflowStatus{State: state},
+	}
+}
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(&v1alpha1.Workflow{}).Build()
+}
+
+func TestPendingWorkflows(t *testing.T) {
+	mac, _ := net.ParseMAC("00:00:5e:00:53:01")
+	c := newClient(t,
+		workflow("pending", "00:00:5E:00:53:01", v1alpha1.WorkflowStatePending),
+		workflow("new", "00:00:5e:00:53:01", ""),
+		workflow("running", "00:00:5e:00:53:01", v1alpha1.WorkflowStateRunning),
+		workflow("other", "00:00:5e:00:53:02", v1alpha1.WorkflowStatePending),
+	)
+	v := &Verifier{Client: c}
+	got, err := v.pendingWorkflows(context.Background(), mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []client.ObjectKey{{Namespace: "tink", Name: "new"}, {Namespace: "tink", Name: "pending"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestScriptServed(t *testing.T) {
+	metric.Init()
+	mac, _ := net.ParseMAC("00:00:5e:00:53:01")
+	started := workflow("started", mac.String(), v1alpha1.WorkflowStatePending)
+	c := newClient(t, started, workflow("stuck", mac.String(), v1alpha1.WorkflowStatePending))
+	v := &Verifier{Client: c, Timeout: 50 * time.Millisecond}
+
+	v.ScriptServed(context.Background(), mac, "auto.ipxe")
+	// a second boot script while a verification is in flight is ignored.
+	v.ScriptServed(context.Background(), mac, "auto.ipxe")
+
+	started.Status.State = v1alpha1.WorkflowStateRunning
+	if err := c.Status().Update(context.Background(), started); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v.mu.Lock()
+		n := len(v.inFlight)
+		v.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for verification")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := testutil.ToFloat64(metric.TinkHandoffs.WithLabelValues("started")); got != 1 {
+		t.Errorf("started: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metric.TinkHandoffs.WithLabelValues("not_started")); got != 1 {
+		t.Errorf("not_started: got %v, want 1", got)
+	}
+}
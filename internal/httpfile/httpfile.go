@@ -0,0 +1,212 @@
+// Package httpfile serves files and generated content over HTTP with the HEAD, Range and If-Range support that
+// firmware HTTP clients, like UEFI HTTP Boot and iPXE, rely on to size and resume downloads.
+//
+// Everything it serves has a strong ETag, so a resumed download with an If-Range of the ETag gets the rest of an
+// unchanged file, and the complete file when it changed in between. It also has the SHA-256 digest of the complete
+// content in the Digest and X-Checksum-SHA256 headers, so that clients can check the integrity of what they got.
+package httpfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// ChecksumSuffix is the suffix of the SHA-256 checksum sidecar of a file, in the sha256sum format.
+const ChecksumSuffix = ".sha256"
+
+// Digest is the SHA-256 digest of a content.
+type Digest [sha256.Size]byte
+
+// Hex returns the hex encoded digest, as in the sha256sum format.
+func (d Digest) Hex() string {
+	return hex.EncodeToString(d[:])
+}
+
+// ETag returns the strong entity tag of the content of the digest.
+func (d Digest) ETag() string {
+	return `"` + hex.EncodeToString(d[:16]) + `"`
+}
+
+// SetDigest sets the Digest, RFC 3230, and X-Checksum-SHA256 headers of the response to d, unless the handler
+// already set them. The digest is of the complete content, also in the responses to Range requests.
+func SetDigest(w http.ResponseWriter, d Digest) {
+	if w.Header().Get("Digest") == "" {
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(d[:]))
+	}
+	if w.Header().Get("X-Checksum-Sha256") == "" {
+		w.Header().Set("X-Checksum-Sha256", d.Hex())
+	}
+}
+
+// ServeChecksum serves the checksum sidecar name of the file target, whose digest is d, in the sha256sum format.
+func ServeChecksum(w http.ResponseWriter, r *http.Request, name, target string, modTime time.Time, d Digest) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	ServeBytes(w, r, name, modTime, []byte(fmt.Sprintf("%s  %s\n", d.Hex(), target)))
+}
+
+// fileDigest is the digest of a file, it is recomputed when the file changes.
+type fileDigest struct {
+	modTime time.Time
+	size    int64
+	digest  Digest
+}
+
+// digests caches the digests of the files by path.
+var digests = struct {
+	sync.Mutex
+	files map[string]fileDigest
+}{files: map[string]fileDigest{}}
+
+// FileDigest returns the digest and the modification time of the regular file at fp.
+// The digests are cached until the modification time or the size of the file change.
+func FileDigest(fp string) (Digest, time.Time, error) {
+	fi, err := os.Stat(fp)
+	if err != nil {
+		return Digest{}, time.Time{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return Digest{}, time.Time{}, &os.PathError{Op: "open", Path: fp, Err: os.ErrNotExist}
+	}
+	f, err := os.Open(fp)
+	if err != nil {
+		return Digest{}, time.Time{}, err
+	}
+	defer f.Close()
+	d, err := digestOf(fp, fi, f)
+
+	return d, fi.ModTime(), err
+}
+
+// digestOf returns the cached digest of the file at fp, or the digest of f, the opened file, when the file changed.
+func digestOf(fp string, fi os.FileInfo, f io.ReadSeeker) (Digest, error) {
+	digests.Lock()
+	c, ok := digests.files[fp]
+	digests.Unlock()
+	if ok && c.modTime.Equal(fi.ModTime()) && c.size == fi.Size() {
+		return c.digest, nil
+	}
+	s := sha256.New()
+	if _, err := io.Copy(s, f); err != nil {
+		return Digest{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Digest{}, err
+	}
+	c = fileDigest{modTime: fi.ModTime(), size: fi.Size()}
+	copy(c.digest[:], s.Sum(nil))
+	digests.Lock()
+	digests.files[fp] = c
+	digests.Unlock()
+
+	return c.digest, nil
+}
+
+// Allowed returns whether the method of r is GET or HEAD, the methods the file-serving endpoints support.
+// It responds with 405 Method Not Allowed otherwise.
+func Allowed(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	return false
+}
+
+// ServeFile serves the regular file at fp and returns its size.
+// It returns an error that satisfies os.IsNotExist, without writing to w, when there is no regular file at fp.
+func ServeFile(w http.ResponseWriter, r *http.Request, fp string) (int64, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !fi.Mode().IsRegular() {
+		return 0, &os.PathError{Op: "open", Path: fp, Err: os.ErrNotExist}
+	}
+	d, err := digestOf(fp, fi, f)
+	if err != nil {
+		return 0, err
+	}
+	setETag(w, fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+	SetDigest(w, d)
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+
+	return fi.Size(), nil
+}
+
+// ServeBytes serves b as the file name, the extension of name sets the Content-Type when it is not set yet.
+// A zero modTime omits the Last-Modified header, the ETag of b still validates conditional requests.
+func ServeBytes(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, b []byte) {
+	d := Digest(sha256.Sum256(b))
+	setETag(w, d.ETag())
+	SetDigest(w, d)
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(b))
+}
+
+// ETag returns the strong entity tag of the content b.
+func ETag(b []byte) string {
+	return Digest(sha256.Sum256(b)).ETag()
+}
+
+// setETag sets the ETag header of the response, unless the handler already set one.
+func setETag(w http.ResponseWriter, etag string) {
+	if w.Header().Get("Etag") == "" {
+		w.Header().Set("Etag", etag)
+	}
+}
+
+// Buffered serves the successful responses of next, which generates its content on every request like the iPXE
+// script handler, with ServeBytes. The responses are buffered, so they get a Content-Length and HEAD, Range and
+// If-Range support. Responses with any other status are passed through as next writes them.
+func Buffered(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferedWriter{ResponseWriter: w}
+		next(bw, r)
+		if bw.passthrough {
+			return
+		}
+		ServeBytes(w, r, path.Base(r.URL.Path), time.Time{}, bw.buf.Bytes())
+	}
+}
+
+// bufferedWriter buffers a 200 OK response, and writes a response with any other status to the ResponseWriter.
+type bufferedWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	wroteHeader bool
+	passthrough bool
+}
+
+func (b *bufferedWriter) WriteHeader(code int) {
+	if b.wroteHeader {
+		return
+	}
+	b.wroteHeader = true
+	if code != http.StatusOK {
+		b.passthrough = true
+		b.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	if b.passthrough {
+		return b.ResponseWriter.Write(p)
+	}
+
+	return b.buf.Write(p)
+}
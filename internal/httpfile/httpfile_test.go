@@ -0,0 +1,249 @@
+package httpfile
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// conditional is a request with its conditional and Range headers.
+type conditional struct {
+	method  string
+	rng     string
+	ifRange string
+}
+
+func (c conditional) request(target string) *http.Request {
+	method := c.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	r := httptest.NewRequest(method, target, nil)
+	if c.rng != "" {
+		r.Header.Set("Range", c.rng)
+	}
+	if c.ifRange != "" {
+		r.Header.Set("If-Range", c.ifRange)
+	}
+
+	return r
+}
+
+func TestServeFile(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "snp.efi")
+	if err := os.WriteFile(fp, []byte("0123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 16) + "-a" + `"`
+
+	tests := map[string]struct {
+		req        conditional
+		wantStatus int
+		wantBody   string
+		wantLength string
+	}{
+		"complete":         {wantStatus: http.StatusOK, wantBody: "0123456789", wantLength: "10"},
+		"head":             {req: conditional{method: http.MethodHead}, wantStatus: http.StatusOK, wantLength: "10"},
+		"range":            {req: conditional{rng: "bytes=4-"}, wantStatus: http.StatusPartialContent, wantBody: "456789", wantLength: "6"},
+		"if-range etag":    {req: conditional{rng: "bytes=4-", ifRange: etag}, wantStatus: http.StatusPartialContent, wantBody: "456789", wantLength: "6"},
+		"if-range changed": {req: conditional{rng: "bytes=4-", ifRange: `"changed"`}, wantStatus: http.StatusOK, wantBody: "0123456789", wantLength: "10"},
+		"if-range date":    {req: conditional{rng: "bytes=4-", ifRange: fi.ModTime().UTC().Format(http.TimeFormat)}, wantStatus: http.StatusPartialContent, wantBody: "456789", wantLength: "6"},
+		"if-range old date": {
+			req:        conditional{rng: "bytes=4-", ifRange: fi.ModTime().Add(-time.Hour).UTC().Format(http.TimeFormat)},
+			wantStatus: http.StatusOK, wantBody: "0123456789", wantLength: "10",
+		},
+		"unsatisfiable range": {req: conditional{rng: "bytes=20-"}, wantStatus: http.StatusRequestedRangeNotSatisfiable},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			size, err := ServeFile(w, tt.req.request("/snp.efi"), fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != 10 {
+				t.Fatalf("size = %d, want 10", size)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if got := w.Header().Get("Etag"); got != etag {
+				t.Fatalf("ETag = %q, want %q", got, etag)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Fatalf("Accept-Ranges = %q, want bytes", got)
+			}
+			if diff := cmp.Diff(tt.wantBody, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+			if got := w.Header().Get("Content-Length"); got != tt.wantLength {
+				t.Fatalf("Content-Length = %q, want %q", got, tt.wantLength)
+			}
+		})
+	}
+
+	for name, p := range map[string]string{"missing": filepath.Join(dir, "missing.efi"), "directory": dir} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if _, err := ServeFile(w, httptest.NewRequest(http.MethodGet, "/", nil), p); !os.IsNotExist(err) {
+				t.Fatalf("expected a not exist error, got %v", err)
+			}
+			if w.Body.Len() != 0 {
+				t.Fatalf("expected nothing to be written, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeBytes(t *testing.T) {
+	b := []byte("#!ipxe\nexit\n")
+	tests := map[string]struct {
+		req        conditional
+		wantStatus int
+		wantBody   string
+	}{
+		"complete":         {wantStatus: http.StatusOK, wantBody: string(b)},
+		"head":             {req: conditional{method: http.MethodHead}, wantStatus: http.StatusOK},
+		"if-range etag":    {req: conditional{rng: "bytes=7-", ifRange: ETag(b)}, wantStatus: http.StatusPartialContent, wantBody: "exit\n"},
+		"if-range changed": {req: conditional{rng: "bytes=7-", ifRange: ETag([]byte("changed"))}, wantStatus: http.StatusOK, wantBody: string(b)},
+		// without a modification time, an If-Range date never matches.
+		"if-range date": {req: conditional{rng: "bytes=7-", ifRange: time.Now().UTC().Format(http.TimeFormat)}, wantStatus: http.StatusOK, wantBody: string(b)},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ServeBytes(w, tt.req.request("/auto.ipxe"), "auto.ipxe", time.Time{}, b)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if diff := cmp.Diff(tt.wantBody, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+			if got := w.Header().Get("Last-Modified"); got != "" {
+				t.Fatalf("Last-Modified = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestServeBytesKeepsETag(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Etag", `"set"`)
+	r := conditional{rng: "bytes=1-", ifRange: `"set"`}.request("/ipxe.efi")
+	ServeBytes(w, r, "ipxe.efi", time.Time{}, []byte("ipxe"))
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if diff := cmp.Diff("pxe", w.Body.String()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	// the digest of "0123456789", also of the responses to Range requests.
+	const (
+		wantHex    = "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"
+		wantDigest = "sha-256=hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII="
+	)
+	fp := filepath.Join(t.TempDir(), "vmlinuz")
+	if err := os.WriteFile(fp, []byte("0123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	serve := map[string]func(http.ResponseWriter, *http.Request){
+		"file": func(w http.ResponseWriter, r *http.Request) { _, _ = ServeFile(w, r, fp) },
+		"bytes": func(w http.ResponseWriter, r *http.Request) {
+			ServeBytes(w, r, "vmlinuz", time.Time{}, []byte("0123456789"))
+		},
+	}
+	for name, serve := range serve {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serve(w, conditional{rng: "bytes=0-1"}.request("/vmlinuz"))
+			if got := w.Header().Get("X-Checksum-Sha256"); got != wantHex {
+				t.Fatalf("X-Checksum-SHA256 = %q, want %q", got, wantHex)
+			}
+			if got := w.Header().Get("Digest"); got != wantDigest {
+				t.Fatalf("Digest = %q, want %q", got, wantDigest)
+			}
+		})
+	}
+
+	d, _, err := FileDigest(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	ServeChecksum(w, httptest.NewRequest(http.MethodGet, "/vmlinuz.sha256", nil), "vmlinuz.sha256", "vmlinuz", time.Time{}, d)
+	if diff := cmp.Diff(wantHex+"  vmlinuz\n", w.Body.String()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	for _, m := range []string{http.MethodGet, http.MethodHead} {
+		if !Allowed(httptest.NewRecorder(), httptest.NewRequest(m, "/", nil)) {
+			t.Fatalf("expected %s to be allowed", m)
+		}
+	}
+	w := httptest.NewRecorder()
+	if Allowed(w, httptest.NewRequest(http.MethodPost, "/", nil)) {
+		t.Fatal("expected POST not to be allowed")
+	}
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Fatalf("status = %d, Allow = %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	script := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auto.ipxe" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("not found"))
+			return
+		}
+		_, _ = w.Write([]byte("#!ipxe\n"))
+		_, _ = w.Write([]byte("exit\n"))
+	}
+	tests := map[string]struct {
+		path       string
+		req        conditional
+		wantStatus int
+		wantBody   string
+		wantLength string
+	}{
+		"complete":  {path: "/auto.ipxe", wantStatus: http.StatusOK, wantBody: "#!ipxe\nexit\n", wantLength: "12"},
+		"head":      {path: "/auto.ipxe", req: conditional{method: http.MethodHead}, wantStatus: http.StatusOK, wantLength: "12"},
+		"range":     {path: "/auto.ipxe", req: conditional{rng: "bytes=7-"}, wantStatus: http.StatusPartialContent, wantBody: "exit\n", wantLength: "5"},
+		"not found": {path: "/hook.ipxe", req: conditional{rng: "bytes=7-"}, wantStatus: http.StatusNotFound, wantBody: "not found"},
+	}
+	h := Buffered(script)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h(w, tt.req.request(tt.path))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if diff := cmp.Diff(tt.wantBody, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+			if got := w.Header().Get("Content-Length"); got != tt.wantLength {
+				t.Fatalf("Content-Length = %q, want %q", got, tt.wantLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir writes facts to a JSON file per machine, named after its MAC address, in a directory.
+type Dir struct {
+	Path string
+}
+
+// WriteFacts implements Writer. The file is replaced atomically so that readers never see a partial file.
+func (d *Dir) WriteFacts(_ context.Context, r Record) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(d.Path, ".facts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), d.file(r.MAC))
+}
+
+// file returns the path of the facts file of a MAC address, 00-01-02-03-04-05.json.
+func (d *Dir) file(mac string) string {
+	return filepath.Join(d.Path, strings.ReplaceAll(mac, ":", "-")+".json")
+}
@@ -0,0 +1,95 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/phonehome"
+)
+
+// maxBodySize is the maximum size in bytes of a facts request body.
+const maxBodySize = 1 << 20
+
+// Handler validates the facts that agents submit and writes them with its Writer. The phone home token of the machine
+// authorizes the request, a machine can only submit its own facts.
+type Handler struct {
+	Writer Writer
+	Tokens *phonehome.Tokens
+	Log    logr.Logger
+}
+
+// HandlerFunc returns a http.HandlerFunc for submitting the facts of a machine.
+// It is expected that the request is POST /inventory/<mac address>?token=<token>, where the token is the phone home
+// token of the machine, the last element of its phone_home_url kernel arg, and the body is the JSON encoded Facts.
+//
+// POST writes the facts and responds with 204 No Content.
+// Only clients with a source IP in allowed are served.
+func (h *Handler) HandlerFunc(allowed []netip.Prefix) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !clientAllowed(r.RemoteAddr, allowed) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		mac, err := net.ParseMAC(path.Base(r.URL.Path))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// the token is bound to the MAC address of a machine, a machine can only write its own facts.
+		tm, err := h.Tokens.MAC(r.URL.Query().Get("token"), time.Now())
+		if err != nil || tm.String() != mac.String() {
+			h.Log.Info("rejected facts", "client", r.RemoteAddr, "mac", mac, "error", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var f Facts
+		d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&f); err != nil {
+			http.Error(w, "invalid facts: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := f.Validate(); err != nil {
+			http.Error(w, "invalid facts: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rec := Record{MAC: mac.String(), ReceivedAt: time.Now().UTC(), Facts: f}
+		if err := h.Writer.WriteFacts(r.Context(), rec); err != nil {
+			h.Log.Info("unable to write facts", "mac", mac, "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, errNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		h.Log.Info("facts written", "mac", mac, "disks", len(f.Disks), "nics", len(f.NICs))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func clientAllowed(remoteAddr string, allowed []netip.Prefix) bool {
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, p := range allowed {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+// Package inventory ingests the hardware facts that in-band agents, like HookOS, discover on a machine
+// and writes them to the backend, closing the loop between discovering and enrolling a machine.
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Limits of the facts that are accepted for a machine.
+const (
+	maxItems    = 256
+	maxFieldLen = 256
+)
+
+var errNotFound = errors.New("machine not found")
+
+// Facts are the hardware facts of a machine.
+type Facts struct {
+	// Serial is the serial number of the machine.
+	Serial string `json:"serial,omitempty"`
+	// BMCAddress is the IP address of the BMC of the machine.
+	BMCAddress string `json:"bmcAddress,omitempty"`
+	Disks      []Disk `json:"disks,omitempty"`
+	NICs       []NIC  `json:"nics,omitempty"`
+}
+
+// Disk is a block device of a machine.
+type Disk struct {
+	// Name is the kernel name of the device, for example sda or nvme0n1.
+	Name      string `json:"name"`
+	SizeBytes uint64 `json:"sizeBytes,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Serial    string `json:"serial,omitempty"`
+}
+
+// NIC is a network interface of a machine.
+type NIC struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+}
+
+// Record is the facts of a machine and when they were received, as written to the backend.
+type Record struct {
+	MAC        string    `json:"mac"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	Facts      Facts     `json:"facts"`
+}
+
+// Writer writes the facts of a machine to a backend.
+type Writer interface {
+	WriteFacts(ctx context.Context, r Record) error
+}
+
+// Validate returns an error when the facts are malformed. MAC addresses are normalized.
+func (f *Facts) Validate() error {
+	if len(f.Disks) > maxItems || len(f.NICs) > maxItems {
+		return fmt.Errorf("at most %d disks and %d nics are accepted", maxItems, maxItems)
+	}
+	if err := checkLen("serial", f.Serial); err != nil {
+		return err
+	}
+	if f.BMCAddress != "" {
+		if _, err := netip.ParseAddr(f.BMCAddress); err != nil {
+			return fmt.Errorf("invalid bmcAddress: %w", err)
+		}
+	}
+	for i, d := range f.Disks {
+		if d.Name == "" {
+			return fmt.Errorf("disks[%d]: name is required", i)
+		}
+		for _, fv := range [][2]string{{"name", d.Name}, {"model", d.Model}, {"serial", d.Serial}} {
+			if err := checkLen(fmt.Sprintf("disks[%d].%s", i, fv[0]), fv[1]); err != nil {
+				return err
+			}
+		}
+	}
+	for i, n := range f.NICs {
+		if err := checkLen(fmt.Sprintf("nics[%d].name", i), n.Name); err != nil {
+			return err
+		}
+		mac, err := net.ParseMAC(n.MAC)
+		if err != nil {
+			return fmt.Errorf("nics[%d]: invalid mac: %w", i, err)
+		}
+		f.NICs[i].MAC = mac.String()
+	}
+
+	return nil
+}
+
+func checkLen(name, v string) error {
+	if len(v) > maxFieldLen {
+		return fmt.Errorf("%s is longer than %d characters", name, maxFieldLen)
+	}
+
+	return nil
+}
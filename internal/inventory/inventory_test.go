@@ -0,0 +1,158 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/smee/internal/phonehome"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var all = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		facts     Facts
+		want      Facts
+		shouldErr bool
+	}{
+		"valid": {
+			facts: Facts{Serial: "S1", BMCAddress: "192.168.2.5", Disks: []Disk{{Name: "sda", SizeBytes: 1 << 30}}, NICs: []NIC{{Name: "eth0", MAC: "3C-EC-EF-4C-4F-54"}}},
+			want:  Facts{Serial: "S1", BMCAddress: "192.168.2.5", Disks: []Disk{{Name: "sda", SizeBytes: 1 << 30}}, NICs: []NIC{{Name: "eth0", MAC: "3c:ec:ef:4c:4f:54"}}},
+		},
+		"empty":           {},
+		"bad bmc address": {facts: Facts{BMCAddress: "bmc.local"}, shouldErr: true},
+		"disk name":       {facts: Facts{Disks: []Disk{{SizeBytes: 1}}}, shouldErr: true},
+		"bad nic mac":     {facts: Facts{NICs: []NIC{{Name: "eth0", MAC: "nope"}}}, shouldErr: true},
+		"long serial":     {facts: Facts{Serial: strings.Repeat("a", maxFieldLen+1)}, shouldErr: true},
+		"too many disks":  {facts: Facts{Disks: make([]Disk, maxItems+1)}, shouldErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.facts.Validate()
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, tt.facts); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+	tokens := &phonehome.Tokens{Key: []byte("0123456789abcdef0123456789abcdef"), TTL: time.Hour}
+	token := "?token=" + tokens.Token(net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}, time.Now())
+	other := "?token=" + tokens.Token(net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x55}, time.Now())
+	tests := map[string]struct {
+		method     string
+		path       string
+		body       string
+		allowed    []netip.Prefix
+		wantStatus int
+		wantFile   bool
+	}{
+		"written":         {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54" + token, body: `{"serial":"S1","nics":[{"name":"eth0","mac":"3c:ec:ef:4c:4f:54"}]}`, allowed: all, wantStatus: http.StatusNoContent, wantFile: true},
+		"not allowed":     {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54" + token, body: `{}`, wantStatus: http.StatusForbidden},
+		"no token":        {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54", body: `{}`, allowed: all, wantStatus: http.StatusForbidden},
+		"other machine":   {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54" + other, body: `{}`, allowed: all, wantStatus: http.StatusForbidden},
+		"bad token":       {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54?token=3cecef4c4f54.1.00", body: `{}`, allowed: all, wantStatus: http.StatusForbidden},
+		"bad method":      {method: http.MethodGet, path: "/inventory/3c:ec:ef:4c:4f:54", allowed: all, wantStatus: http.StatusMethodNotAllowed},
+		"bad mac":         {method: http.MethodPost, path: "/inventory/nope", body: `{}`, allowed: all, wantStatus: http.StatusBadRequest},
+		"unknown field":   {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54" + token, body: `{"ram":1}`, allowed: all, wantStatus: http.StatusBadRequest},
+		"invalid facts":   {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54" + token, body: `{"bmcAddress":"nope"}`, allowed: all, wantStatus: http.StatusBadRequest},
+		"malformed json":  {method: http.MethodPost, path: "/inventory/3c:ec:ef:4c:4f:54" + token, body: `{`, allowed: all, wantStatus: http.StatusBadRequest},
+		"normalizes path": {method: http.MethodPost, path: "/inventory/3C-EC-EF-4C-4F-54" + token, body: `{}`, allowed: all, wantStatus: http.StatusNoContent, wantFile: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &Dir{Path: t.TempDir()}
+			h := &Handler{Writer: d, Tokens: tokens, Log: logr.Discard()}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.HandlerFunc(tt.allowed)(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			b, err := os.ReadFile(filepath.Join(d.Path, "3c-ec-ef-4c-4f-54.json"))
+			if !tt.wantFile {
+				if err == nil {
+					t.Fatal("expected no facts file")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rec Record
+			if err := json.Unmarshal(b, &rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec.MAC != "3c:ec:ef:4c:4f:54" || rec.ReceivedAt.IsZero() {
+				t.Fatalf("unexpected record: %+v", rec)
+			}
+		})
+	}
+}
+
+func TestKubeWriteFacts(t *testing.T) {
+	rs := runtime.NewScheme()
+	if err := scheme.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	hw := &v1alpha1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine1", Namespace: "tink-system", Annotations: map[string]string{"keep": "me"}},
+		Spec:       v1alpha1.HardwareSpec{Interfaces: []v1alpha1.Interface{{DHCP: &v1alpha1.DHCP{MAC: "3c:ec:ef:4c:4f:54"}}}},
+	}
+	c := fake.NewClientBuilder().WithScheme(rs).WithObjects(hw).WithIndex(&v1alpha1.Hardware{}, kube.MACAddrIndex, kube.MACAddrs).Build()
+	k := &Kube{Client: c}
+
+	rec := Record{MAC: "3c:ec:ef:4c:4f:54", Facts: Facts{Serial: "S1"}}
+	if err := k.WriteFacts(context.Background(), rec); err != nil {
+		t.Fatal(err)
+	}
+	got := &v1alpha1.Hardware{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "tink-system", Name: "machine1"}, got); err != nil {
+		t.Fatal(err)
+	}
+	var gotRec Record
+	if err := json.Unmarshal([]byte(got.Annotations[Annotation]), &gotRec); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rec, gotRec); diff != "" {
+		t.Fatal(diff)
+	}
+	if got.Annotations["keep"] != "me" {
+		t.Fatal("existing annotations were not kept")
+	}
+
+	if err := k.WriteFacts(context.Background(), Record{MAC: "00:00:00:00:00:01"}); err == nil {
+		t.Fatal("expected an error for an unknown machine")
+	}
+}
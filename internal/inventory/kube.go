@@ -0,0 +1,67 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const tracerName = "github.com/tinkerbell/smee/internal/inventory"
+
+// Annotation is the Hardware annotation key holding the JSON encoded Record of a machine.
+const Annotation = "smee.tinkerbell.org/inventory"
+
+// Kube writes facts to an annotation of the Hardware object of a machine.
+type Kube struct {
+	// Client is used to read and patch Hardware objects.
+	// It must have the kube.MACAddrIndex field index registered.
+	Client client.Client
+}
+
+// WriteFacts implements Writer.
+func (k *Kube) WriteFacts(ctx context.Context, r Record) error {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "inventory.Kube.WriteFacts")
+	defer span.End()
+
+	hl := &v1alpha1.HardwareList{}
+	if err := k.Client.List(ctx, hl, &client.MatchingFields{kube.MACAddrIndex: r.MAC}); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed listing hardware for (%v): %w", r.MAC, err)
+	}
+	switch len(hl.Items) {
+	case 0:
+		span.SetStatus(codes.Error, errNotFound.Error())
+		return fmt.Errorf("%w: %s", errNotFound, r.MAC)
+	case 1:
+	default:
+		err := fmt.Errorf("got %d hardware objects for mac %s, expected only 1", len(hl.Items), r.MAC)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	hw := &hl.Items[0]
+	patch := client.MergeFrom(hw.DeepCopy())
+	if hw.Annotations == nil {
+		hw.Annotations = map[string]string{}
+	}
+	hw.Annotations[Annotation] = string(b)
+	if err := k.Client.Patch(ctx, hw, patch); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed patching hardware %s/%s: %w", hw.Namespace, hw.Name, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package ipmac resolves the IP address that a request comes from to the MAC address of the machine that sent it.
+// It is shared by the DHCP handlers, that populate it, and the HTTP and syslog servers, that use it to attribute the
+// requests of machines that don't send their MAC address, like in proxy mode where Smee never leased their IP address.
+package ipmac
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a Cache, whose TTL is not set, remembers a seen IP address.
+const DefaultTTL = time.Hour
+
+// pruneInterval is the minimum time between two prunings of the expired entries.
+const pruneInterval = time.Minute
+
+// Resolver returns the MAC address of the machine that uses an IP address.
+type Resolver interface {
+	MAC(ip net.IP) (net.HardwareAddr, bool)
+}
+
+// Cache is a Resolver of the IP addresses that machines were seen using, in the DHCP messages that they send and in
+// the HTTP requests that carry their MAC address, like the iPXE script and ISO requests.
+// It implements handler.AddressObserver. The zero value is ready to use, a nil Cache resolves nothing.
+type Cache struct {
+	// TTL is how long a seen IP address is remembered, DefaultTTL when 0.
+	TTL time.Duration
+	// Resolvers are asked before the seen IP addresses, in order, the first one that resolves an IP address wins.
+	// They are the authoritative sources, like the leases of the DHCP replies of Smee.
+	Resolvers []Resolver
+
+	mu      sync.Mutex
+	entries map[netip.Addr]entry
+	pruned  time.Time
+}
+
+type entry struct {
+	mac     net.HardwareAddr
+	expires time.Time
+}
+
+// DHCPServed implements handler.Observer.
+func (c *Cache) DHCPServed(context.Context, net.HardwareAddr, string) {}
+
+// DHCPAddressSeen implements handler.AddressObserver.
+func (c *Cache) DHCPAddressSeen(_ context.Context, mac net.HardwareAddr, ip net.IP) {
+	c.Learn(ip, mac)
+}
+
+// Learn records that the machine with mac uses ip.
+func (c *Cache) Learn(ip net.IP, mac net.HardwareAddr) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if c == nil || !ok || len(mac) == 0 || addr.IsUnspecified() {
+		return
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[netip.Addr]entry{}
+	}
+	if now.Sub(c.pruned) > pruneInterval {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.pruned = now
+	}
+	c.entries[addr.Unmap()] = entry{mac: append(net.HardwareAddr(nil), mac...), expires: now.Add(ttl)}
+}
+
+// MAC implements Resolver. It returns the MAC address that the Resolvers resolve ip to, or that was last seen using ip.
+// ok is false when ip is not resolved.
+func (c *Cache) MAC(ip net.IP) (mac net.HardwareAddr, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	for _, r := range c.Resolvers {
+		if mac, ok := r.MAC(ip); ok {
+			return mac, true
+		}
+	}
+	addr, valid := netip.AddrFromSlice(ip)
+	if !valid {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[addr.Unmap()]
+	if !found || time.Now().After(e.expires) {
+		return nil, false
+	}
+
+	return e.mac, true
+}
+
+// Address is an IP address that a machine was seen using.
+type Address struct {
+	IP      netip.Addr
+	MAC     net.HardwareAddr
+	Expires time.Time
+}
+
+// List returns the IP addresses that machines were seen using, with the expired ones that were not pruned yet.
+// The addresses of the Resolvers are not listed.
+func (c *Cache) List() []Address {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	as := make([]Address, 0, len(c.entries))
+	for ip, e := range c.entries {
+		as = append(as, Address{IP: ip, MAC: e.mac, Expires: e.expires})
+	}
+
+	return as
+}
+
+// Merge records the addresses of as that expire after the entry of their IP address in c, like the addresses that
+// another Smee replica saw. Expired addresses are ignored.
+func (c *Cache) Merge(as ...Address) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[netip.Addr]entry{}
+	}
+	for _, a := range as {
+		if !a.IP.IsValid() || len(a.MAC) == 0 || now.After(a.Expires) {
+			continue
+		}
+		if cur, ok := c.entries[a.IP.Unmap()]; ok && !a.Expires.After(cur.expires) {
+			continue
+		}
+		c.entries[a.IP.Unmap()] = entry{mac: append(net.HardwareAddr(nil), a.MAC...), expires: a.Expires}
+	}
+}
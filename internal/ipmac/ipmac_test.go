@@ -0,0 +1,68 @@
+package ipmac
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/dhcp/lease"
+)
+
+func TestCache(t *testing.T) {
+	seen, leased := net.HardwareAddr{0, 1, 2, 3, 4, 5}, net.HardwareAddr{0, 1, 2, 3, 4, 6}
+	leases := &lease.Table{}
+	c := &Cache{TTL: time.Minute, Resolvers: []Resolver{leases}}
+	ip := net.IPv4(192, 168, 2, 153)
+	if _, ok := c.MAC(ip); ok {
+		t.Fatal("expected an unseen IP address not to be resolved")
+	}
+
+	c.DHCPAddressSeen(context.Background(), seen, ip)
+	got, ok := c.MAC(ip.To4())
+	if !ok {
+		t.Fatal("expected the seen IP address to be resolved")
+	}
+	if diff := cmp.Diff(seen, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	leases.DHCPLeased(context.Background(), leased, ip, 0)
+	if got, _ := c.MAC(ip); got.String() != leased.String() {
+		t.Fatalf("got %s, want the lease to win over the seen IP address", got)
+	}
+
+	c.TTL = time.Nanosecond
+	c.Learn(net.IPv4(192, 168, 2, 154), seen)
+	time.Sleep(time.Millisecond)
+	if _, ok := c.MAC(net.IPv4(192, 168, 2, 154)); ok {
+		t.Fatal("expected an expired IP address not to be resolved")
+	}
+
+	var nilCache *Cache
+	nilCache.Learn(ip, seen)
+	if _, ok := nilCache.MAC(ip); ok {
+		t.Fatal("expected a nil cache to resolve nothing")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	mac, other := net.HardwareAddr{0, 1, 2, 3, 4, 5}, net.HardwareAddr{0, 1, 2, 3, 4, 6}
+	ip := netip.MustParseAddr("192.168.2.153")
+	c := &Cache{TTL: time.Hour}
+	c.Learn(ip.AsSlice(), mac)
+
+	c.Merge(Address{IP: ip, MAC: other, Expires: time.Now().Add(time.Minute)}, Address{IP: netip.MustParseAddr("192.168.2.154"), MAC: other, Expires: time.Now().Add(-time.Minute)})
+	if got, _ := c.MAC(ip.AsSlice()); got.String() != mac.String() {
+		t.Fatalf("got %s, want the address that expires last to win", got)
+	}
+	if len(c.List()) != 1 {
+		t.Fatal("expected an expired address not to be merged")
+	}
+	c.Merge(Address{IP: ip, MAC: other, Expires: time.Now().Add(2 * time.Hour)})
+	if got, _ := c.MAC(ip.AsSlice()); got.String() != other.String() {
+		t.Fatalf("got %s, want the newer address", got)
+	}
+}
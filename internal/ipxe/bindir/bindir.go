@@ -0,0 +1,288 @@
+// Package bindir serves iPXE binaries from a directory over HTTP and TFTP, in front of the binaries embedded in Smee.
+// It serves the binaries that are not embedded, for example snp-riscv64.efi, or that replace the embedded ones.
+package bindir
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/smee/internal/httpfile"
+	"github.com/tinkerbell/smee/internal/ipxe/trust"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/useragent"
+)
+
+// traceparent matches a traceparent that was appended to a file name, see the OTELEnabled DHCP handler option.
+var traceparent = regexp.MustCompile(`^(.+)-00-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// Handler serves the iPXE binaries in Dir. Requests for any other file are passed to the fallback handlers.
+// HTTP requests for the embedded binaries are passed to HTTPFallback with the ETag and the digest headers of the
+// patched binary set, so that the downloads of the embedded binaries can be resumed and checked too.
+// The SHA-256 checksum sidecar of a binary, like snp.efi.sha256, is served over HTTP for the binaries in Dir and
+// the embedded ones.
+// With Trust set, the embedded binaries are patched to trust it and served by the Handler itself, the fallback
+// handlers only serve the files that are not iPXE binaries.
+type Handler struct {
+	// Dir is the directory holding the iPXE binaries.
+	Dir string
+	// Patch is the iPXE script fragment that is patched into the binaries, like the embedded ones.
+	Patch []byte
+	// Trust is the CA that the served binaries are patched to trust in place of the iPXE root CA, see the trust package.
+	// Binaries without the iPXE root CA fingerprint, like the compressed undionly.kpxe, are served without it.
+	Trust *trust.CA
+	Log   logr.Logger
+	// HTTPFallback serves HTTP requests for files that are not in Dir.
+	HTTPFallback http.HandlerFunc
+	// TFTPFallback serves TFTP reads of files that are not in Dir.
+	TFTPFallback func(filename string, rf io.ReaderFrom) error
+
+	mu      sync.Mutex
+	digests map[string]httpfile.Digest
+	trusted map[string][]byte
+}
+
+// file returns the name and path of the requested binary, ok is false if Dir doesn't hold it.
+// The requested path can be prefixed with the MAC address directory and suffixed with a traceparent.
+func (h *Handler) file(p string) (name, fp string, ok bool) {
+	name = path.Base(p)
+	if m := traceparent.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	}
+	if h.Dir == "" || name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		return name, "", false
+	}
+	fp = filepath.Join(h.Dir, name)
+	if fi, err := os.Stat(fp); err != nil || !fi.Mode().IsRegular() {
+		return name, "", false
+	}
+
+	return name, fp, true
+}
+
+// embeddedDigest returns the digest of the patched embedded binary name, it is computed once per binary.
+func (h *Handler) embeddedDigest(name string) (httpfile.Digest, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d, ok := h.digests[name]; ok {
+		return d, true
+	}
+	b, ok := binary.Files[name]
+	if !ok {
+		return httpfile.Digest{}, false
+	}
+	b, err := h.patch(b)
+	if err != nil {
+		return httpfile.Digest{}, false
+	}
+	if h.digests == nil {
+		h.digests = map[string]httpfile.Digest{}
+	}
+	h.digests[name] = sha256.Sum256(b)
+
+	return h.digests[name], true
+}
+
+// embedded returns the patched embedded binary name when Trust is set, it is patched once per binary. ok is false
+// without Trust, the fallback handlers then serve the embedded binaries, or when there is no such binary.
+func (h *Handler) embedded(name string) ([]byte, bool) {
+	if h.Trust == nil {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if b, ok := h.trusted[name]; ok {
+		return b, true
+	}
+	b, ok := binary.Files[name]
+	if !ok {
+		return nil, false
+	}
+	b, err := h.patch(b)
+	if err != nil {
+		return nil, false
+	}
+	if h.trusted == nil {
+		h.trusted = map[string][]byte{}
+	}
+	h.trusted[name] = b
+
+	return b, true
+}
+
+// serveChecksum serves the checksum sidecar of the binary, in Dir or embedded, that the request path p without the
+// checksum suffix names. It returns false, without writing to w, when there is no such binary.
+func (h *Handler) serveChecksum(w http.ResponseWriter, r *http.Request, p string) bool {
+	target, fp, ok := h.file(p)
+	if ok {
+		fi, err := os.Stat(fp)
+		if err != nil {
+			return false
+		}
+		b, err := h.read(fp)
+		if err != nil {
+			return false
+		}
+		httpfile.ServeChecksum(w, r, target+httpfile.ChecksumSuffix, target, fi.ModTime(), sha256.Sum256(b))
+		return true
+	}
+	d, ok := h.embeddedDigest(target)
+	if !ok {
+		return false
+	}
+	httpfile.ServeChecksum(w, r, target+httpfile.ChecksumSuffix, target, time.Time{}, d)
+
+	return true
+}
+
+// read returns the patched contents of the binary at fp.
+func (h *Handler) read(fp string) ([]byte, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.patch(b)
+}
+
+// patch patches the iPXE script and the trusted CA into the binary b. A binary that can't be patched to trust the CA
+// is returned with the script patched only.
+func (h *Handler) patch(b []byte) ([]byte, error) {
+	b, err := binary.Patch(b, h.Patch)
+	if err != nil {
+		return nil, err
+	}
+	t, err := h.Trust.Patch(b)
+	if errors.Is(err, trust.ErrNotPatchable) {
+		return b, nil
+	}
+
+	return t, err
+}
+
+// contentType sets the Content-Type of the binary name for the kind of client ua. UEFI HTTP boot firmware is served
+// EFI binaries as application/efi, the media type it boots. curl is told the file name, so that curl -OJ saves the binary.
+func contentType(w http.ResponseWriter, ua useragent.Client, name string) {
+	switch {
+	case ua == useragent.UEFIHTTP && strings.HasSuffix(name, ".efi"):
+		w.Header().Set("Content-Type", "application/efi")
+	case ua == useragent.Curl:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+}
+
+// ServeHTTP serves the iPXE binaries over HTTP.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, fp, ok := h.file(r.URL.Path)
+	ua := useragent.Classify(r.UserAgent())
+	metric.HTTPClientRequests.WithLabelValues("binary", string(ua)).Inc()
+	contentType(w, ua, name)
+	if p, isSum := strings.CutSuffix(r.URL.Path, httpfile.ChecksumSuffix); !ok && isSum {
+		if !httpfile.Allowed(w, r) {
+			return
+		}
+		if !h.serveChecksum(w, r, p) {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	if !ok {
+		if b, ok := h.embedded(name); ok {
+			if !httpfile.Allowed(w, r) {
+				return
+			}
+			httpfile.ServeBytes(w, r, name, time.Time{}, b)
+			h.log().Info("served iPXE binary", "path", r.URL.Path, "client", r.RemoteAddr, "file", name, "fileSize", len(b))
+			return
+		}
+		if h.HTTPFallback == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if d, ok := h.embeddedDigest(name); ok {
+			w.Header().Set("Etag", d.ETag())
+			httpfile.SetDigest(w, d)
+		}
+		h.HTTPFallback(w, r)
+		return
+	}
+	if !httpfile.Allowed(w, r) {
+		return
+	}
+	log := h.log().WithValues("path", r.URL.Path, "client", r.RemoteAddr)
+	fi, err := os.Stat(fp)
+	if err != nil {
+		log.Error(err, "unable to read iPXE binary", "file", name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	b, err := h.read(fp)
+	if err != nil {
+		log.Error(err, "unable to read iPXE binary", "file", name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	httpfile.ServeBytes(w, r, name, fi.ModTime(), b)
+	log.Info("served iPXE binary", "file", name, "fileSize", len(b))
+}
+
+// HandleRead serves the iPXE binaries over TFTP. The function signature satisfies the tftp.Server read handler parameter type.
+func (h *Handler) HandleRead(filename string, rf io.ReaderFrom) error {
+	name, fp, ok := h.file(filename)
+	var b []byte
+	if !ok {
+		var embedded bool
+		if b, embedded = h.embedded(name); !embedded {
+			if h.TFTPFallback == nil {
+				return fmt.Errorf("file [%v] unknown: %w", filename, os.ErrNotExist)
+			}
+			return h.TFTPFallback(filename, rf)
+		}
+	}
+	log := h.log().WithValues("filename", filename)
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		log = log.WithValues("client", ot.RemoteAddr())
+	}
+	if ok {
+		var err error
+		if b, err = h.read(fp); err != nil {
+			log.Error(err, "unable to read iPXE binary", "file", name)
+			return err
+		}
+	}
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		ot.SetSize(int64(len(b)))
+	}
+	n, err := rf.ReadFrom(bytes.NewReader(b))
+	if err != nil {
+		log.Error(err, "file serve failed", "bytesSent", n, "contentSize", len(b))
+		return err
+	}
+	log.Info("served iPXE binary", "file", name, "bytesSent", n)
+
+	return nil
+}
+
+func (h *Handler) log() logr.Logger {
+	if h.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return h.Log
+}
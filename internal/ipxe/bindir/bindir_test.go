@@ -0,0 +1,220 @@
+package bindir
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/ipxedust/binary"
+	"github.com/tinkerbell/smee/internal/httpfile"
+	"github.com/tinkerbell/smee/internal/ipxe/trust"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+type fakeReaderFrom struct {
+	bytes.Buffer
+}
+
+func (f *fakeReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	return f.Buffer.ReadFrom(r)
+}
+
+func newHandler(t *testing.T) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "snp-riscv64.efi"), []byte("riscv64"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".hidden"), []byte("hidden"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Handler{
+		Dir: dir,
+		HTTPFallback: func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("fallback"))
+		},
+		TFTPFallback: func(_ string, rf io.ReaderFrom) error {
+			_, err := rf.ReadFrom(bytes.NewReader([]byte("fallback")))
+			return err
+		},
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	embedded, err := binary.Patch(binary.Files["ipxe.efi"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		path     string
+		wantBody string
+		wantETag string
+	}{
+		"binary":                  {path: "/ipxe/snp-riscv64.efi", wantBody: "riscv64", wantETag: httpfile.ETag([]byte("riscv64"))},
+		"binary with mac":         {path: "/ipxe/00:01:02:03:04:05/snp-riscv64.efi", wantBody: "riscv64", wantETag: httpfile.ETag([]byte("riscv64"))},
+		"binary with traceparent": {path: "/ipxe/snp-riscv64.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", wantBody: "riscv64", wantETag: httpfile.ETag([]byte("riscv64"))},
+		"embedded binary":         {path: "/ipxe/ipxe.efi", wantBody: "fallback", wantETag: httpfile.ETag(embedded)},
+		"hidden file":             {path: "/ipxe/.hidden", wantBody: "fallback"},
+	}
+	h := newHandler(t)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+			}
+			if diff := cmp.Diff(tt.wantBody, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+			if got := w.Header().Get("Etag"); got != tt.wantETag {
+				t.Fatalf("got ETag %q, want %q", got, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	embedded, err := binary.Patch(binary.Files["ipxe.efi"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("riscv64"))
+	esum := sha256.Sum256(embedded)
+	tests := map[string]struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		"binary":          {path: "/ipxe/snp-riscv64.efi.sha256", wantStatus: http.StatusOK, wantBody: hex.EncodeToString(sum[:]) + "  snp-riscv64.efi\n"},
+		"binary with mac": {path: "/ipxe/00:01:02:03:04:05/snp-riscv64.efi.sha256", wantStatus: http.StatusOK, wantBody: hex.EncodeToString(sum[:]) + "  snp-riscv64.efi\n"},
+		"embedded binary": {path: "/ipxe/ipxe.efi.sha256", wantStatus: http.StatusOK, wantBody: hex.EncodeToString(esum[:]) + "  ipxe.efi\n"},
+		"unknown binary":  {path: "/ipxe/unknown.efi.sha256", wantStatus: http.StatusNotFound},
+	}
+	h := newHandler(t)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if diff := cmp.Diff(tt.wantBody, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipxe/ipxe.efi", nil))
+	if got := w.Header().Get("X-Checksum-Sha256"); got != hex.EncodeToString(esum[:]) {
+		t.Fatalf("got X-Checksum-SHA256 %q of the embedded binary, want %q", got, hex.EncodeToString(esum[:]))
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]struct {
+		userAgent       string
+		path            string
+		wantType        string
+		wantDisposition string
+	}{
+		"ipxe":               {userAgent: "iPXE/1.21.1", path: "/ipxe/snp-riscv64.efi", wantType: "application/octet-stream"},
+		"uefi http":          {userAgent: "UefiHttpBoot/1.0", path: "/ipxe/snp-riscv64.efi", wantType: "application/efi"},
+		"uefi http kpxe":     {userAgent: "UefiHttpBoot/1.0", path: "/ipxe/undionly.kpxe", wantType: "application/octet-stream"},
+		"uefi http embedded": {userAgent: "UefiHttpBoot/1.0", path: "/ipxe/ipxe.efi", wantType: "application/efi"},
+		"curl":               {userAgent: "curl/8.5.0", path: "/ipxe/snp-riscv64.efi", wantType: "application/octet-stream", wantDisposition: `attachment; filename="snp-riscv64.efi"`},
+	}
+	h := newHandler(t)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			h.ServeHTTP(w, r)
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Fatalf("got Content-Type %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Fatalf("got Content-Disposition %q, want %q", got, tt.wantDisposition)
+			}
+		})
+	}
+}
+
+func TestHandleRead(t *testing.T) {
+	tests := map[string]struct {
+		filename string
+		want     string
+	}{
+		"binary":          {filename: "snp-riscv64.efi", want: "riscv64"},
+		"binary with mac": {filename: "00:01:02:03:04:05/snp-riscv64.efi", want: "riscv64"},
+		"embedded binary": {filename: "ipxe.efi", want: "fallback"},
+	}
+	h := newHandler(t)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rf := &fakeReaderFrom{}
+			if err := h.HandleRead(tt.filename, rf); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, rf.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	h.TFTPFallback = nil
+	if err := h.HandleRead("ipxe.efi", &fakeReaderFrom{}); err == nil {
+		t.Fatal("expected an error without a fallback")
+	}
+}
+
+func TestTrust(t *testing.T) {
+	h := newHandler(t)
+	h.Trust = &trust.CA{Fingerprint: sha256.Sum256([]byte("ca"))}
+	want, err := h.Trust.Patch(binary.Files["ipxe.efi"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipxe/ipxe.efi", nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), want) {
+		t.Fatalf("expected the embedded binary patched to trust the CA, got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipxe/ipxe.efi.sha256", nil))
+	if sum := sha256.Sum256(want); !strings.HasPrefix(w.Body.String(), hex.EncodeToString(sum[:])) {
+		t.Fatalf("expected the checksum of the patched binary, got %q", w.Body.String())
+	}
+
+	rf := &fakeReaderFrom{}
+	if err := h.HandleRead("undionly.kpxe", rf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rf.Bytes(), binary.Files["undionly.kpxe"]) {
+		t.Fatal("expected undionly.kpxe, that can't be patched, to be served as is")
+	}
+	rf = &fakeReaderFrom{}
+	if err := h.HandleRead("auto.ipxe", rf); err != nil || rf.String() != "fallback" {
+		t.Fatalf("expected the fallback for a file that is not an iPXE binary, got %q, %v", rf.String(), err)
+	}
+}
@@ -3,16 +3,22 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tinkerbell/smee/internal/supervise"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/netutil"
 )
 
 // Config is the configuration for the http server.
@@ -21,6 +27,34 @@ type Config struct {
 	StartTime      time.Time
 	Logger         logr.Logger
 	TrustedProxies []string
+	// Ready, when set, is served by the /readyz endpoint. Smee is live but degraded while it returns false,
+	// for example while the backend is unavailable.
+	Ready func() bool
+	// Services, when set, returns the status of the services of Smee, they are listed by /readyz?verbose.
+	Services func() []supervise.Status
+	// MaxConnections, when greater than 0, is the maximum number of open connections.
+	// Connections over the limit wait to be accepted.
+	MaxConnections int
+	// Wrap, when set, wraps the handlers of the routes, for example to map the requests to their tenant.
+	// It sees the requests before they are routed, with their source address from a trusted proxy.
+	Wrap func(http.Handler) http.Handler
+	// Listen, when set, returns the listener of the addr of ServeHTTP instead of net.Listen, for example an inherited
+	// socket. It is called every time ServeHTTP is.
+	Listen func(addr string) (net.Listener, error)
+	// TLS, when set, serves HTTPS with it instead of HTTP.
+	TLS *tls.Config
+}
+
+type peerAddrKey struct{}
+
+// PeerAddr returns the address of the peer of the connection of r.
+// It differs from r.RemoteAddr when the X-Forwarded-For header of a request from a trusted proxy replaced it.
+func PeerAddr(r *http.Request) string {
+	if v, ok := r.Context().Value(peerAddrKey{}).(string); ok {
+		return v
+	}
+
+	return r.RemoteAddr
 }
 
 // HandlerMapping is a map of routes to http.HandlerFuncs.
@@ -34,11 +68,17 @@ func (s *Config) ServeHTTP(ctx context.Context, addr string, handlers HandlerMap
 		mux.Handle(otelFuncWrapper(pattern, handler))
 	}
 
-	mux.Handle("/metrics", promhttp.Handler())
+	// OpenMetrics is negotiated for the exemplars, the trace IDs of the observations of the duration histograms.
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
 	mux.HandleFunc("/healthcheck", s.serveHealthchecker(s.GitRev, s.StartTime))
+	mux.HandleFunc("/readyz", s.serveReadiness)
 
+	var h http.Handler = mux
+	if s.Wrap != nil {
+		h = s.Wrap(mux)
+	}
 	// wrap the mux with an OpenTelemetry interceptor
-	otelHandler := otelhttp.NewHandler(mux, "smee-http")
+	otelHandler := otelhttp.NewHandler(h, "smee-http")
 
 	// add X-Forwarded-For support if trusted proxies are configured
 	var xffHandler http.Handler
@@ -77,7 +117,22 @@ func (s *Config) ServeHTTP(ctx context.Context, addr string, handlers HandlerMap
 		s.Logger.Info("shutting down http server")
 		_ = server.Shutdown(ctx)
 	}()
-	if err := server.ListenAndServe(); err != nil {
+	listen := s.Listen
+	if listen == nil {
+		listen = func(addr string) (net.Listener, error) { return net.Listen("tcp", addr) }
+	}
+	l, err := listen(addr)
+	if err != nil {
+		s.Logger.Error(err, "listen http")
+		return err
+	}
+	if s.MaxConnections > 0 {
+		l = netutil.LimitListener(l, s.MaxConnections)
+	}
+	if s.TLS != nil {
+		l = tls.NewListener(l, s.TLS)
+	}
+	if err := server.Serve(l); err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			return nil
 		}
@@ -107,6 +162,44 @@ func (s *Config) serveHealthchecker(rev string, start time.Time) http.HandlerFun
 	}
 }
 
+// serveReadiness responds with 200 when Smee is ready and 503 while it is degraded.
+// With the verbose query parameter the status of every service is listed, one per line.
+func (s *Config) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	ready := s.Ready == nil || s.Ready()
+	if !r.URL.Query().Has("verbose") || s.Services == nil {
+		if !ready {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+		return
+	}
+	var b strings.Builder
+	for _, st := range s.Services() {
+		mark := "+"
+		if st.State == supervise.StateRestarting || st.State == supervise.StateFailed {
+			mark = "-"
+		}
+		fmt.Fprintf(&b, "[%s]%s %s", mark, st.Name, st.State)
+		if st.Restarts > 0 {
+			fmt.Fprintf(&b, ", %d restarts", st.Restarts)
+		}
+		if st.Err != nil {
+			fmt.Fprintf(&b, ", last error: %v", st.Err)
+		}
+		b.WriteString("\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		b.WriteString("not ready\n")
+	} else {
+		b.WriteString("ok\n")
+	}
+	_, _ = w.Write([]byte(b.String()))
+}
+
 // otelFuncWrapper takes a route and an http handler function, wraps the function
 // with otelhttp, and returns the route again and http.Handler all set for mux.Handle().
 func otelFuncWrapper(route string, h func(w http.ResponseWriter, req *http.Request)) (string, http.Handler) {
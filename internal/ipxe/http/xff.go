@@ -24,6 +24,7 @@ WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 package http
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"strings"
@@ -62,8 +63,10 @@ func newXFF(options xffOptions) (*xff, error) {
 }
 
 // Handler updates RemoteAdd from X-Fowarded-For Headers.
+// The original RemoteAddr is kept in the request context, see PeerAddr.
 func (xff *xff) Handler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr))
 		r.RemoteAddr = getRemoteAddrIfAllowed(r, xff.allowed)
 		h.ServeHTTP(w, r)
 	})
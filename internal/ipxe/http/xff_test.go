@@ -25,6 +25,8 @@ package http
 
 import (
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -156,3 +158,19 @@ func TestParseMany(t *testing.T) {
 	res := parse("1.1.1.1, 127.0.0.1, 127.0.0.2, 127.0.0.3", m.allowed)
 	assert.Equal(t, "1.1.1.1", res)
 }
+
+func TestHandler_peerAddr(t *testing.T) {
+	m, _ := newXFF(xffOptions{
+		AllowedSubnets: []string{"127.0.0.0/16"},
+	})
+	var remoteAddr, peerAddr string
+	h := m.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		remoteAddr, peerAddr = r.RemoteAddr, PeerAddr(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/auto.ipxe", nil)
+	r.RemoteAddr = "127.0.0.1:4242"
+	r.Header.Set("X-Forwarded-For", "192.168.2.153")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "192.168.2.153:4242", remoteAddr)
+	assert.Equal(t, "127.0.0.1:4242", peerAddr)
+}
@@ -0,0 +1,164 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	dhcpdata "github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+// ConsoleRule sets the kernel consoles of the machines whose iPXE reports its platform, build architecture and SMBIOS
+// manufacturer. Empty conditions match any machine.
+type ConsoleRule struct {
+	// Name identifies the rule in the console query parameter of the boot script requests.
+	Name string `json:"name"`
+	// Platform is the ${platform} of iPXE, pcbios or efi.
+	Platform string `json:"platform,omitempty"`
+	// BuildArch is the ${buildarch} of iPXE, like x86_64, i386 or arm64. The undionly.kpxe binary is i386 on
+	// x86_64 machines.
+	BuildArch string `json:"buildarch,omitempty"`
+	// Manufacturer is the SMBIOS system manufacturer, the ${manufacturer} of iPXE, like "Dell Inc.", matched exactly.
+	Manufacturer string `json:"manufacturer,omitempty"`
+	// Console is a space separated list of consoles, like the console of a backend record.
+	Console string `json:"console"`
+}
+
+// DefaultConsoleRules are the ConsoleRules that are used when none are configured.
+// Dell iDRAC, HPE iLO and Supermicro BMCs redirect the serial console to COM2 by default.
+var DefaultConsoleRules = []ConsoleRule{
+	{Name: "arm64", BuildArch: "arm64", Console: "tty0 ttyAMA0,115200"},
+	{Name: "qemu", Manufacturer: "QEMU", Console: "tty0 ttyS0,115200"},
+	{Name: "dell", Manufacturer: "Dell Inc.", Console: "tty0 ttyS1,115200n8"},
+	{Name: "hpe", Manufacturer: "HPE", Console: "tty0 ttyS1,115200n8"},
+	{Name: "hp", Manufacturer: "HP", Console: "tty0 ttyS1,115200n8"},
+	{Name: "supermicro", Manufacturer: "Supermicro", Console: "tty0 ttyS1,115200n8"},
+}
+
+// consoleRuleName are the names of rules, they are used in iPXE scripts and URLs unquoted.
+var consoleRuleName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// LoadConsoleRules reads and validates a YAML, or JSON, file with a list of ConsoleRules.
+func LoadConsoleRules(file string) ([]ConsoleRule, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConsoleRules(b)
+}
+
+// ParseConsoleRules parses and validates a YAML, or JSON, list of ConsoleRules.
+func ParseConsoleRules(b []byte) ([]ConsoleRule, error) {
+	var rules []ConsoleRule
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse console rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("no console rules")
+	}
+	var errs []error
+	names := map[string]bool{}
+	for i, r := range rules {
+		switch {
+		case !consoleRuleName.MatchString(r.Name):
+			errs = append(errs, fmt.Errorf("rule %d: name %q must be letters, digits, '_', '.' or '-'", i, r.Name))
+		case names[r.Name]:
+			errs = append(errs, fmt.Errorf("rule %d: name %q is used by another rule", i, r.Name))
+		}
+		names[r.Name] = true
+		if r.Platform != "" && r.Platform != "pcbios" && r.Platform != "efi" {
+			errs = append(errs, fmt.Errorf("rule %d (%s): platform %q must be pcbios or efi", i, r.Name, r.Platform))
+		}
+		if strings.ContainsAny(r.BuildArch, " \t${}") {
+			errs = append(errs, fmt.Errorf("rule %d (%s): invalid buildarch %q", i, r.Name, r.BuildArch))
+		}
+		if strings.TrimSpace(r.Console) == "" {
+			errs = append(errs, fmt.Errorf("rule %d (%s): a console is required", i, r.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return rules, nil
+}
+
+// ConsoleStageScript returns the auto.ipxe script that is served when the Handler has ConsoleRules. It sets the name of
+// the first rule that matches the machine, and chains, relative to its own URL, to auto.ipxe, or to the hook.ipxe
+// second stage when twoStage is set, with the name in the console query parameter.
+func ConsoleStageScript(rules []ConsoleRule, twoStage bool) string {
+	var s strings.Builder
+	s.WriteString("#!ipxe\n\necho Detecting the console of the machine...\n")
+	for _, r := range rules {
+		var conds []string
+		if r.Platform != "" {
+			conds = append(conds, "iseq ${platform} "+r.Platform)
+		}
+		if r.BuildArch != "" {
+			conds = append(conds, "iseq ${buildarch} "+r.BuildArch)
+		}
+		if r.Manufacturer != "" {
+			// the manufacturer is compared URI encoded, its spaces and special characters need no quoting.
+			conds = append(conds, "iseq ${manufacturer:uristring} "+uriString(r.Manufacturer))
+		}
+		conds = append(conds, "set console-rule "+r.Name, "goto console-detected")
+		s.WriteString(strings.Join(conds, " && ") + " ||\n")
+	}
+	s.WriteString(":console-detected\n")
+	if twoStage {
+		s.WriteString("chain --autofree " + secondStage + "&console=${console-rule}\n")
+	} else {
+		s.WriteString("chain --autofree auto.ipxe?console=${console-rule}\n")
+	}
+
+	return s.String()
+}
+
+// uriString encodes s like the uristring setting type of iPXE, all but the unreserved characters of RFC 3986 are
+// percent encoded.
+func uriString(s string) string {
+	var b strings.Builder
+	for i := range len(s) {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
+
+// serveConsoleStage serves the ConsoleStageScript.
+func (h *Handler) serveConsoleStage(w http.ResponseWriter, r *http.Request) {
+	if _, err := w.Write([]byte(ConsoleStageScript(h.ConsoleRules, h.TwoStage))); err != nil {
+		h.Logger.Error(err, "unable to send the console detection ipxe script", "client", r.RemoteAddr)
+		return
+	}
+	h.Logger.V(1).Info("served the console detection ipxe script", "client", r.RemoteAddr)
+}
+
+// detectedConsole returns hw with the console of the rule in the console query parameter of q, when hw has no console
+// of its own.
+func (h *Handler) detectedConsole(q url.Values, hw data) data {
+	name := q.Get("console")
+	if hw.Console != "" || name == "" {
+		return hw
+	}
+	i := slices.IndexFunc(h.ConsoleRules, func(r ConsoleRule) bool { return r.Name == name })
+	if i < 0 {
+		h.Logger.V(1).Info("unknown console rule", "rule", name, "mac", hw.MACAddress)
+		return hw
+	}
+	hw.Console = strings.Join((&dhcpdata.Netboot{Console: h.ConsoleRules[i].Console}).ConsoleArgs(), " ")
+
+	return hw
+}
@@ -0,0 +1,115 @@
+package script
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	dhcpdata "github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+func TestConsoleStage(t *testing.T) {
+	tests := map[string]struct {
+		twoStage  bool
+		wantChain string
+	}{
+		"auto.ipxe": {wantChain: "chain --autofree auto.ipxe?console=${console-rule}\n"},
+		"two stage": {twoStage: true, wantChain: "chain --autofree hook.ipxe?mac=${mac}&uuid=${uuid:uristring}&serial=${serial:uristring}&hostname=${hostname:uristring}&arch=${buildarch}&console=${console-rule}\n"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Logger: logr.Discard(), ConsoleRules: DefaultConsoleRules, TwoStage: tt.twoStage}
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, httptest.NewRequest(http.MethodGet, "/00:01:02:03:04:05/auto.ipxe", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+			}
+			got := w.Body.String()
+			if err := ValidateScript(got, DefaultMaxScriptSize); err != nil {
+				t.Fatalf("%v:\n%s", err, got)
+			}
+			for _, want := range []string{
+				"iseq ${buildarch} arm64 && set console-rule arm64 && goto console-detected ||\n",
+				"iseq ${manufacturer:uristring} Dell%20Inc. && set console-rule dell && goto console-detected ||\n",
+				tt.wantChain,
+			} {
+				if !strings.Contains(got, want) {
+					t.Fatalf("expected %q in the script, got:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectedConsole(t *testing.T) {
+	tests := map[string]struct {
+		path    string
+		console string
+		want    string
+	}{
+		"rule":            {path: "/00:01:02:03:04:05/auto.ipxe?console=dell", want: "console=tty0 console=ttyS1,115200n8"},
+		"no rule matched": {path: "/00:01:02:03:04:05/auto.ipxe?console=", want: "console=tty0 console=ttyS1,115200 "},
+		"unknown rule":    {path: "/00:01:02:03:04:05/auto.ipxe?console=console=ttyS9", want: "console=tty0 console=ttyS1,115200 "},
+		"machine console": {path: "/00:01:02:03:04:05/auto.ipxe?console=dell", console: "ttyS0,9600", want: "console=ttyS0,9600"},
+		"second stage":    {path: "/hook.ipxe?mac=00:01:02:03:04:05&uuid=&console=arm64", want: "console=tty0 console=ttyAMA0,115200"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			be := fakeBackend{netboot: &dhcpdata.Netboot{AllowNetboot: true, Console: tt.console}}
+			h := &Handler{Logger: logr.Discard(), Backend: be, OSIEURL: "http://127.0.0.1", ConsoleRules: DefaultConsoleRules}
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Fatalf("expected %q in the script, got:\n%s", tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseConsoleRules(t *testing.T) {
+	got, err := ParseConsoleRules([]byte(`
+- name: lenovo
+  manufacturer: Lenovo
+  console: ttyS0,115200
+- name: efi-arm
+  platform: efi
+  buildarch: arm64
+  console: ttyAMA0,115200
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConsoleRule{
+		{Name: "lenovo", Manufacturer: "Lenovo", Console: "ttyS0,115200"},
+		{Name: "efi-arm", Platform: "efi", BuildArch: "arm64", Console: "ttyAMA0,115200"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+	for name, rules := range map[string]string{
+		"no name":        `[{console: ttyS0}]`,
+		"name in a url":  `[{name: "a&b", console: ttyS0}]`,
+		"same name":      `[{name: a, console: ttyS0}, {name: a, console: ttyS1}]`,
+		"platform":       `[{name: a, platform: uefi, console: ttyS0}]`,
+		"no console":     `[{name: a, buildarch: arm64}]`,
+		"not a list":     `name: a`,
+		"no rules":       `[]`,
+		"buildarch expr": `[{name: a, buildarch: "${x}", console: ttyS0}]`,
+	} {
+		if _, err := ParseConsoleRules([]byte(rules)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestURIString(t *testing.T) {
+	if got, want := uriString("Dell Inc./A&B~x"), "Dell%20Inc.%2FA%26B~x"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
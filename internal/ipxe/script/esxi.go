@@ -0,0 +1,44 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errNotESXiProfile = errors.New("the boot profile of the machine is not an ESXi profile")
+
+// ESXiKernelOpts returns the kernel options that are appended to the ESXi installer boot.cfg of the machine with the
+// given MAC address, the kernel args of its ESXi boot profile with their templates executed. It has the same netboot
+// gating as the auto.ipxe script.
+func (h *Handler) ESXiKernelOpts(ctx context.Context, mac net.HardwareAddr) ([]string, error) {
+	ctx, end := h.continueBootTrace(ctx, "boot.cfg", mac)
+	defer end()
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("smee.script_name", "boot.cfg"))
+	hw, err := getByMac(ctx, mac, h.Backend)
+	if err != nil {
+		return nil, err
+	}
+	hw = h.authorize(hw)
+	if !hw.AllowNetboot || !h.settings().MACAllowed(hw.MACAddress) {
+		return nil, errNetbootNotAllowed
+	}
+	p, ok := h.profile(hw)
+	if !ok || !p.ESXi {
+		return nil, errNotESXiProfile
+	}
+	span.SetAttributes(attribute.String("smee.boot_profile", p.Name))
+	opts, err := h.kernelParams(ctx, hw, p.KernelArgs)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range h.Observers {
+		o.ScriptServed(ctx, hw.MACAddress, "boot.cfg")
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,88 @@
+package script
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net"
+	"net/http"
+
+	"github.com/tinkerbell/smee/internal/tmpl"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Reasons for serving the fallback script.
+const (
+	FallbackReasonLookup = "lookup"
+	FallbackReasonRender = "render"
+)
+
+// Fallback holds the values used to generate the fallback script from the FallbackScript template of the Handler.
+type Fallback struct {
+	// MAC is the MAC address of the machine, it is empty when the script was requested without one.
+	MAC string
+	// IP is the IP address of the machine, from the backend or the script request.
+	IP string
+	// Reason is why the fallback script is served, FallbackReasonLookup or FallbackReasonRender.
+	Reason string
+	// Error is the error of the backend lookup or of rendering the script.
+	Error string
+}
+
+// ValidateFallback returns an error when the fallback script template can't be parsed or executed.
+// env is the allowlist of the environment variables that the template can read, lookups find a zero machine.
+func ValidateFallback(script string, env []string) error {
+	_, err := generateFallback(Fallback{}, script, tmpl.Options{
+		Env:    env,
+		Lookup: func(net.HardwareAddr) (tmpl.Machine, error) { return tmpl.Machine{}, nil },
+	})
+
+	return err
+}
+
+// generateFallback generates the fallback script, the template can use the functions of the tmpl package.
+func generateFallback(f Fallback, script string, o tmpl.Options) (string, error) {
+	t, err := template.New("fallback.ipxe").Funcs(template.FuncMap(tmpl.Funcs(o))).Parse(script)
+	if err != nil {
+		return "", err
+	}
+	buffer := new(bytes.Buffer)
+	if err := t.Execute(buffer, f); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+// renderFallback returns the Fallback values of a machine whose script could not be rendered.
+func renderFallback(hw data, err error) Fallback {
+	f := Fallback{MAC: hw.MACAddress.String(), Reason: FallbackReasonRender, Error: err.Error()}
+	if hw.IPAddress.IsValid() {
+		f.IP = hw.IPAddress.String()
+	}
+
+	return f
+}
+
+// serveFallbackScript serves the fallback script in place of an HTTP error.
+// It returns false, without writing to w, when there is no fallback script or it could not be generated.
+func (h *Handler) serveFallbackScript(ctx context.Context, w http.ResponseWriter, f Fallback) bool {
+	if h.FallbackScript == "" {
+		return false
+	}
+	log := h.Logger.WithValues("mac", f.MAC, "client", f.IP, "reason", f.Reason)
+	script, err := generateFallback(f, h.FallbackScript, h.templateOptions(ctx))
+	if err != nil {
+		log.Error(err, "error generating the fallback ipxe script")
+		return false
+	}
+	if _, err := w.Write([]byte(script)); err != nil {
+		log.Error(err, "unable to send the fallback ipxe script")
+		return true
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("smee.fallback_reason", f.Reason))
+	log.Info("served the fallback ipxe script", "error", f.Error)
+
+	return true
+}
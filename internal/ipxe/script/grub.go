@@ -0,0 +1,145 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tinkerbell/smee/internal/useragent"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GRUBScript is the GRUB config for loading Hook on machines that boot with Secure Boot enabled.
+// It is loaded by a signed GRUB that was chainloaded by shim. The kernel must be signed with a key
+// that is trusted by shim, either the vendor key built into shim or an enrolled Machine Owner Key (MOK).
+var GRUBScript = `set timeout=0
+set default=0
+
+echo 'Loading the Tinkerbell Hook GRUB config...'
+{{- if .TraceID }}
+echo 'Debug TraceID: {{ .TraceID }}'
+{{- end }}
+
+menuentry 'Tinkerbell Hook' {
+	linux {{ .DownloadPath }}/{{ if .Kernel }}{{ .Kernel }}{{ else }}vmlinuz-{{ .Arch }}{{ end }} {{- if ne .VLANID "" }} vlan_id={{ .VLANID }} {{- end }} {{- if .Traceparent }} traceparent={{ .Traceparent }} {{- end }} {{- if .PhoneHomeURL }} phone_home_url={{ .PhoneHomeURL }} {{- end }} {{- range .ExtraKernelParams}} {{.}} {{- end}} facility={{ .Facility }} syslog_host={{ .SyslogHost }} {{- if not .NoWorkflow }} grpc_authority={{ .TinkGRPCAuthority }} tinkerbell_tls={{ .TinkerbellTLS }} tinkerbell_insecure_tls={{ .TinkerbellInsecureTLS }} worker_id={{ .WorkerID }} {{- end }} hw_addr={{ .HWAddr }} modules=loop,squashfs,sd-mod,usb-storage intel_iommu=on iommu=pt {{ if .Console }}{{ .Console }}{{ else }}console=tty0 console=ttyS1,115200{{ end }}
+	initrd {{ .DownloadPath }}/{{ if .Initrd }}{{ .Initrd }}{{ else }}initramfs-{{ .Arch }}{{ end }}
+}
+`
+
+// GRUB holds the values used to generate the GRUB config that loads the Hook OS.
+type GRUB struct {
+	Hook
+	// DownloadPath is the DownloadURL in GRUB device syntax, for example (http,192.168.2.111,8080)/hook.
+	DownloadPath string
+}
+
+var errNetbootNotAllowed = errors.New("the hardware data for this machine, or lack there of, does not allow it to netboot")
+
+// GRUBConfig returns the GRUB config that loads Hook for the machine with the given MAC address.
+// It has the same netboot gating as the auto.ipxe script. Custom iPXE scripts are not supported,
+// as GRUB can't run them.
+func (h *Handler) GRUBConfig(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	ctx, end := h.continueBootTrace(ctx, "grub.cfg", mac)
+	defer end()
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("smee.script_name", "grub.cfg"))
+	hw, err := getByMac(ctx, mac, h.Backend)
+	if err != nil {
+		return "", err
+	}
+	hw = h.authorize(hw)
+	if !hw.AllowNetboot || !h.settings().MACAllowed(hw.MACAddress) {
+		return "", errNetbootNotAllowed
+	}
+	cfg, ok := h.pinned(span, hw.MACAddress, "grub.cfg")
+	if !ok {
+		auto, err := h.bootHook(span, hw)
+		if err != nil {
+			return "", err
+		}
+		g := GRUB{Hook: h.mirrors(hw.MACAddress, h.guard(span, hw, auto))}
+		if g.DownloadPath, err = grubPath(g.DownloadURL); err != nil {
+			return "", err
+		}
+		if cfg, err = GenerateTemplate(g, GRUBScript); err != nil {
+			return "", err
+		}
+		h.record(hw.MACAddress, "grub.cfg", cfg)
+	}
+	for _, o := range h.Observers {
+		o.ScriptServed(ctx, hw.MACAddress, "grub.cfg")
+	}
+
+	return cfg, nil
+}
+
+const (
+	formatIPXE = "ipxe"
+	formatGRUB = "grub"
+)
+
+// scriptFormat returns the format of the auto.ipxe script that r is served, the GRUB config to GRUB and the iPXE
+// script to any other client. The format query parameter, ipxe or grub, overrides it, for example to check the
+// GRUB config of a machine with curl.
+func scriptFormat(r *http.Request, ua useragent.Client) string {
+	switch f := r.URL.Query().Get("format"); f {
+	case formatIPXE, formatGRUB:
+		return f
+	}
+	if ua == useragent.GRUB {
+		return formatGRUB
+	}
+
+	return formatIPXE
+}
+
+// serveGRUBConfig serves the GRUB config that loads Hook in place of the auto.ipxe script, GRUB can't run iPXE scripts.
+// The machine must be identified by a MAC address, or by an IP address that the backend has the MAC address of.
+func (h *Handler) serveGRUBConfig(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var mac net.HardwareAddr
+	if clients := h.identify(r); len(clients) > 0 {
+		mac = clients[0].mac
+		if mac == nil {
+			if hw, err := getByIP(ctx, clients[0].ip, h.Backend); err == nil {
+				mac = hw.MACAddress
+			}
+		}
+	}
+	if mac == nil {
+		w.WriteHeader(http.StatusNotFound)
+		h.Logger.Info("unable to identify the machine of the GRUB config request", "client", r.RemoteAddr, "urlPath", r.URL.Path)
+		return
+	}
+	cfg, err := h.GRUBConfig(ctx, mac)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		h.Logger.Info("not serving the GRUB config", "client", mac, "error", err)
+		return
+	}
+	if _, err := w.Write([]byte(cfg)); err != nil {
+		h.Logger.Error(err, "unable to write the GRUB config", "client", mac)
+	}
+}
+
+// grubPath converts an http or tftp URL into GRUB device syntax, (<protocol>,<host>[,<port>])<path>.
+// GRUB does not support https.
+func grubPath(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid download URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "tftp" {
+		return "", fmt.Errorf("download URL scheme %q is not supported by GRUB, must be http or tftp", u.Scheme)
+	}
+	dev := u.Scheme + "," + u.Hostname()
+	if p := u.Port(); p != "" {
+		dev += "," + p
+	}
+
+	return "(" + dev + ")" + strings.TrimSuffix(u.Path, "/"), nil
+}
@@ -14,12 +14,15 @@ set kernel {{ if .Kernel }}{{ .Kernel }}{{ else }}vmlinuz-${arch}{{ end }}
 set initrd {{ if .Initrd }}{{ .Initrd }}{{ else }}initramfs-${arch}{{ end }}
 set retries:int32 {{ .Retries }}
 set retry_delay:int32 {{ .RetryDelay }}
+{{- if .ISOURL }}
+set iso-url {{ .ISOURL }}
+{{- end }}
 
 set idx:int32 0
 :retry_kernel
-kernel ${download-url}/${kernel} {{- if ne .VLANID "" }} vlan_id={{ .VLANID }} {{- end }} {{- range .ExtraKernelParams}} {{.}} {{- end}} \
-facility={{ .Facility }} syslog_host={{ .SyslogHost }} grpc_authority={{ .TinkGRPCAuthority }} tinkerbell_tls={{ .TinkerbellTLS }} tinkerbell_insecure_tls={{ .TinkerbellInsecureTLS }} worker_id={{ .WorkerID }} hw_addr={{ .HWAddr }} \
-modules=loop,squashfs,sd-mod,usb-storage intel_iommu=on iommu=pt initrd=initramfs-${arch} console=tty0 console=ttyS1,115200 && goto download_initrd || iseq ${idx} ${retries} && goto kernel-error || inc idx && echo retry in ${retry_delay} seconds ; sleep ${retry_delay} ; goto retry_kernel
+kernel ${download-url}/${kernel} {{- if ne .VLANID "" }} vlan_id={{ .VLANID }} {{- end }} {{- if .Traceparent }} traceparent={{ .Traceparent }} {{- end }} {{- if .PhoneHomeURL }} phone_home_url={{ .PhoneHomeURL }} {{- end }} {{- range .ExtraKernelParams}} {{.}} {{- end}} \
+facility={{ .Facility }} syslog_host={{ .SyslogHost }} {{- if not .NoWorkflow }} grpc_authority={{ .TinkGRPCAuthority }} tinkerbell_tls={{ .TinkerbellTLS }} tinkerbell_insecure_tls={{ .TinkerbellInsecureTLS }} worker_id={{ .WorkerID }} {{- end }} hw_addr={{ .HWAddr }} \
+modules=loop,squashfs,sd-mod,usb-storage intel_iommu=on iommu=pt initrd=initramfs-${arch} {{ if .Console }}{{ .Console }}{{ else }}console=tty0 console=ttyS1,115200{{ end }} && goto download_initrd || iseq ${idx} ${retries} && goto kernel-error || inc idx && echo retry in ${retry_delay} seconds ; sleep ${retry_delay} ; goto retry_kernel
 
 :download_initrd
 set idx:int32 0
@@ -50,7 +53,7 @@ exit
 // Hook holds the values used to generate the iPXE script that loads the Hook OS.
 type Hook struct {
 	Arch                  string   // example x86_64
-	Console               string   // example ttyS1,115200
+	Console               string   // kernel console args, example console=tty0 console=ttyS1,115200n8, empty for the defaults
 	DownloadURL           string   // example https://location:8080/to/kernel/and/initrd
 	ExtraKernelParams     []string // example tink_worker_image=quay.io/tinkerbell/tink-worker:v0.8.0
 	Facility              string
@@ -60,10 +63,14 @@ type Hook struct {
 	TinkerbellInsecureTLS bool
 	TinkGRPCAuthority     string // example 192.168.2.111:42113
 	TraceID               string
+	Traceparent           string // W3C traceparent passed to Hook, example 00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01
 	VLANID                string // string number between 1-4095
 	WorkerID              string // example 3c:ec:ef:4c:4f:54 or worker1
 	Retries               int    // number of retries to attempt when fetching kernel and initrd files
 	RetryDelay            int    // number of seconds to wait between retries
 	Kernel                string // name of the kernel file
 	Initrd                string // name of the initrd file
+	ISOURL                string // signed, expiring, URL of the Hook ISO for this machine
+	PhoneHomeURL          string // URL, with an expiring token, that the machine POSTs to at the end of provisioning
+	NoWorkflow            bool   // leave out the Tink server kernel args, so that Hook doesn't run a Tink workflow
 }
@@ -0,0 +1,107 @@
+package script
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	ipxehttp "github.com/tinkerbell/smee/internal/ipxe/http"
+)
+
+// ClientIdentifier is a strategy that identifies the machine of an iPXE script request.
+type ClientIdentifier string
+
+const (
+	// IdentifyURL identifies a machine by the MAC address in the URL path, for example /00:01:02:03:04:05/auto.ipxe.
+	IdentifyURL ClientIdentifier = "url"
+	// IdentifyQuery identifies a machine by the mac query parameter, for example /auto.ipxe?mac=00:01:02:03:04:05.
+	IdentifyQuery ClientIdentifier = "query"
+	// IdentifyXFF identifies a machine by the client IP address in the X-Forwarded-For header of a request from a trusted proxy.
+	IdentifyXFF ClientIdentifier = "xff"
+	// IdentifyLease identifies a machine by the MAC address that the IP address of the request was leased to by DHCP.
+	IdentifyLease ClientIdentifier = "lease"
+	// IdentifyIP identifies a machine by the IP address of the request.
+	IdentifyIP ClientIdentifier = "ip"
+)
+
+// DefaultClientIdentifiers are the strategies used when Handler.ClientIdentifiers is empty.
+var DefaultClientIdentifiers = []ClientIdentifier{IdentifyURL, IdentifyQuery, IdentifyIP}
+
+// ParseClientIdentifiers parses a comma separated, ordered list of client identification strategies.
+// For example "url,xff,lease,ip".
+func ParseClientIdentifiers(s string) ([]ClientIdentifier, error) {
+	var ids []ClientIdentifier
+	for _, v := range strings.Split(s, ",") {
+		id := ClientIdentifier(strings.TrimSpace(v))
+		switch id {
+		case "":
+			continue
+		case IdentifyURL, IdentifyQuery, IdentifyXFF, IdentifyLease, IdentifyIP:
+			ids = append(ids, id)
+		default:
+			return nil, fmt.Errorf("unknown client identifier %q, must be one of %s, %s, %s, %s, %s", v, IdentifyURL, IdentifyQuery, IdentifyXFF, IdentifyLease, IdentifyIP)
+		}
+	}
+
+	return ids, nil
+}
+
+// LeaseReader returns the MAC address of the machine that an IP address is leased to.
+type LeaseReader interface {
+	MAC(ip net.IP) (net.HardwareAddr, bool)
+}
+
+// LeaseLearner is a LeaseReader that also learns the IP address of the machines whose script requests carry their
+// MAC address, so that their requests that don't are identified by the lease strategy.
+type LeaseLearner interface {
+	LeaseReader
+	Learn(ip net.IP, mac net.HardwareAddr)
+}
+
+// client is the identity of the machine of a script request, either mac or ip is set.
+type client struct {
+	mac net.HardwareAddr
+	ip  net.IP
+	by  ClientIdentifier
+}
+
+// identify returns the identities of the machine of r, in the order of the client identification strategies.
+// Strategies that don't identify the machine are skipped.
+func (h *Handler) identify(r *http.Request) []client {
+	ids := h.ClientIdentifiers
+	if len(ids) == 0 {
+		ids = DefaultClientIdentifiers
+	}
+	var clients []client
+	for _, id := range ids {
+		c := client{by: id}
+		switch id {
+		case IdentifyURL:
+			c.mac, _ = getMAC(r.URL.Path)
+		case IdentifyQuery:
+			c.mac, _ = net.ParseMAC(r.URL.Query().Get("mac"))
+		case IdentifyXFF:
+			// the X-Forwarded-For middleware only replaces the remote address of requests from trusted proxies.
+			if ip, err := getIP(r.RemoteAddr); err == nil && remoteIP(ipxehttp.PeerAddr(r)) != remoteIP(r.RemoteAddr) {
+				c.ip = ip
+			}
+		case IdentifyLease:
+			if ip, err := getIP(r.RemoteAddr); err == nil && h.Leases != nil {
+				c.mac, _ = h.Leases.MAC(ip)
+			}
+		case IdentifyIP:
+			c.ip, _ = getIP(r.RemoteAddr)
+		}
+		if len(c.mac) > 0 || c.ip != nil {
+			clients = append(clients, c)
+		}
+		if l, ok := h.Leases.(LeaseLearner); ok && len(c.mac) > 0 && (id == IdentifyURL || id == IdentifyQuery) {
+			if ip, err := getIP(r.RemoteAddr); err == nil {
+				l.Learn(ip, c.mac)
+			}
+		}
+	}
+
+	return clients
+}
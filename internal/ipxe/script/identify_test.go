@@ -0,0 +1,93 @@
+package script
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeLeases map[string]net.HardwareAddr
+
+func (f fakeLeases) MAC(ip net.IP) (net.HardwareAddr, bool) {
+	mac, ok := f[ip.String()]
+	return mac, ok
+}
+
+func TestIdentify(t *testing.T) {
+	urlMAC := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	queryMAC := net.HardwareAddr{0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+	leaseMAC := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	tests := map[string]struct {
+		ids  []ClientIdentifier
+		path string
+		want []client
+	}{
+		"default": {
+			path: "/00:01:02:03:04:05/auto.ipxe?mac=0a:0b:0c:0d:0e:0f",
+			want: []client{{mac: urlMAC, by: IdentifyURL}, {mac: queryMAC, by: IdentifyQuery}, {ip: net.IPv4(192, 168, 2, 153), by: IdentifyIP}},
+		},
+		"query first": {
+			ids:  []ClientIdentifier{IdentifyQuery, IdentifyURL},
+			path: "/00:01:02:03:04:05/auto.ipxe?mac=0a:0b:0c:0d:0e:0f",
+			want: []client{{mac: queryMAC, by: IdentifyQuery}, {mac: urlMAC, by: IdentifyURL}},
+		},
+		"lease": {
+			ids:  []ClientIdentifier{IdentifyURL, IdentifyLease, IdentifyIP},
+			path: "/auto.ipxe",
+			want: []client{{mac: leaseMAC, by: IdentifyLease}, {ip: net.IPv4(192, 168, 2, 153), by: IdentifyIP}},
+		},
+		"not forwarded": {
+			ids:  []ClientIdentifier{IdentifyXFF},
+			path: "/auto.ipxe",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{ClientIdentifiers: tt.ids, Leases: fakeLeases{"192.168.2.153": leaseMAC}}
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = "192.168.2.153:4242"
+			if diff := cmp.Diff(tt.want, h.identify(r), cmp.AllowUnexported(client{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+type learningLeases struct {
+	fakeLeases
+}
+
+func (l learningLeases) Learn(ip net.IP, mac net.HardwareAddr) {
+	l.fakeLeases[ip.String()] = mac
+}
+
+func TestIdentifyLearns(t *testing.T) {
+	urlMAC := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	h := &Handler{ClientIdentifiers: []ClientIdentifier{IdentifyURL, IdentifyLease}, Leases: learningLeases{fakeLeases{}}}
+	r := httptest.NewRequest(http.MethodGet, "/00:01:02:03:04:05/auto.ipxe", nil)
+	r.RemoteAddr = "192.168.2.153:4242"
+	h.identify(r)
+
+	r = httptest.NewRequest(http.MethodGet, "/auto.ipxe", nil)
+	r.RemoteAddr = "192.168.2.153:4242"
+	want := []client{{mac: urlMAC, by: IdentifyLease}}
+	if diff := cmp.Diff(want, h.identify(r), cmp.AllowUnexported(client{})); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestParseClientIdentifiers(t *testing.T) {
+	got, err := ParseClientIdentifiers("url, xff,lease,ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]ClientIdentifier{IdentifyURL, IdentifyXFF, IdentifyLease, IdentifyIP}, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := ParseClientIdentifiers("url,arp"); err == nil {
+		t.Fatal("expected an error for an unknown client identifier")
+	}
+}
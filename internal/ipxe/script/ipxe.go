@@ -2,22 +2,43 @@ package script
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"path"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
+	dhcpdata "github.com/tinkerbell/smee/internal/dhcp/data"
 	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/facility"
 	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/mirror"
+	"github.com/tinkerbell/smee/internal/otel"
+	"github.com/tinkerbell/smee/internal/oui"
+	"github.com/tinkerbell/smee/internal/policy"
+	"github.com/tinkerbell/smee/internal/profile"
+	"github.com/tinkerbell/smee/internal/rollout"
+	"github.com/tinkerbell/smee/internal/settings"
+	"github.com/tinkerbell/smee/internal/snapshot"
+	"github.com/tinkerbell/smee/internal/tenant"
+	"github.com/tinkerbell/smee/internal/tmpl"
+	"github.com/tinkerbell/smee/internal/useragent"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
+const tracerName = "github.com/tinkerbell/smee/internal/ipxe/script"
+
 type Handler struct {
 	Logger                logr.Logger
 	Backend               handler.BackendReader
@@ -30,6 +51,102 @@ type Handler struct {
 	IPXEScriptRetries     int
 	IPXEScriptRetryDelay  int
 	StaticIPXEEnabled     bool
+	// Settings, when set, provides runtime overrides for OSIEURL, ExtraKernelParams and the MAC addresses allowed to netboot.
+	Settings settings.Reader
+	// Enroller, when set, is used to create a backend record for unknown machines that are served the static iPXE script.
+	Enroller Enroller
+	// Observers are notified after a boot script has been served to a machine.
+	Observers []Observer
+	// Policy, when set, decides whether a machine is allowed to netboot and the iPXE script it is chained to.
+	Policy *policy.Policy
+	// ISOURL, when set, returns the signed Hook ISO URL for a machine.
+	// It is set as the iso-url variable in the auto.ipxe script.
+	ISOURL func(net.HardwareAddr) string
+	// PhoneHomeURL, when set, returns the phone home URL for a machine.
+	// It is passed to Hook in the phone_home_url kernel arg.
+	PhoneHomeURL func(net.HardwareAddr) string
+	// Generator, when set, generates the auto.ipxe script in place of the Hook script.
+	Generator Generator
+	// Facilities, when set, overrides the OSIE URL, extra kernel params, syslog host and Tink server of machines by facility.
+	Facilities *facility.Config
+	// Tenants, when set, overrides the OSIE URL and Tink server of machines by their tenant, and adds the URL prefix of
+	// the tenant to the ISO and phone home URLs. The Backend should be scoped to the tenants, see tenant.Config.Scope.
+	Tenants *tenant.Config
+	// Rollout, when set, selects the OSIE URL of machines from weighted tracks, in place of the configured and runtime OSIE URL.
+	Rollout *rollout.Config
+	// Guard, when set, limits how many machines per window boot a changed configuration, the others are served the
+	// configuration that they last booted with.
+	Guard *rollout.Guard
+	// Mirrors, when set, answers the OSIE URL and Tink server of machines with a healthy candidate of their mirror group,
+	// whatever they come from. It is applied after the Guard, a failover is not a changed configuration.
+	Mirrors *mirror.Config
+	// Snapshots, when set, keeps the last renderings of the auto.ipxe, hook.ipxe and grub.cfg scripts of machines, and
+	// serves the snapshot that a machine is pinned to in place of a new rendering.
+	Snapshots *snapshot.Store
+	// Validate checks the auto.ipxe and hook.ipxe scripts against the syntax rules of iPXE, and MaxScriptSize, before
+	// they are served, see ValidateScript. An invalid script is not served, the fallback script or an HTTP error is.
+	Validate bool
+	// MaxScriptSize is the maximum size in bytes of the validated scripts, 0 is unlimited.
+	MaxScriptSize int
+	// Profiles, when set, overrides the OSIE URL, extra kernel params and workflow handoff of machines by the boot
+	// profile that their backend record names, or that their DHCP client matched.
+	Profiles *profile.Config
+	// OUIRules, when set, overrides the OSIE URL, and adds to the extra kernel params, of the static iPXE script
+	// by the OUI of the MAC address of machines.
+	OUIRules *oui.Config
+	// TwoStage serves the FirstStageScript as auto.ipxe, it chains to the hook.ipxe second stage with the system UUID,
+	// serial number and build architecture that iPXE reports, so that machines can be matched where MAC addresses are unreliable.
+	TwoStage bool
+	// ConsoleRules, when set, serves the ConsoleStageScript as auto.ipxe, it detects the console of the machine from its
+	// platform, build architecture and manufacturer, and chains to auto.ipxe, or to the hook.ipxe second stage when
+	// TwoStage is set, with the rule that matched. Its console applies to the machines without a console of their own.
+	ConsoleRules []ConsoleRule
+	// FallbackScript, when set, is the template of the iPXE script that is served when the backend lookup of a machine,
+	// or the rendering of its script, fails, in place of an HTTP error. The template is executed with a Fallback.
+	FallbackScript string
+	// BootTraces, when set, continues the trace of the last DHCP reply sent to a machine, and passes
+	// the traceparent of the script span to Hook in the traceparent kernel arg.
+	BootTraces *otel.BootTraces
+	// ClientIdentifiers are the strategies, evaluated in order, that identify the machine of a script request.
+	// DefaultClientIdentifiers are used when empty.
+	ClientIdentifiers []ClientIdentifier
+	// TemplateEnv is the allowlist of the environment variables that the env function of the extra kernel params,
+	// and fallback script, templates can read.
+	TemplateEnv []string
+	// Leases, when set, is used by the IdentifyLease strategy to find the MAC address of the machine that the request IP address is leased to.
+	// When it is a LeaseLearner, it learns the IP address of the requests identified by the URL or query strategies.
+	Leases LeaseReader
+}
+
+// Generator generates the auto.ipxe script of a machine.
+type Generator interface {
+	// GenerateScript returns the script to serve, an empty script serves the Hook script.
+	GenerateScript(ctx context.Context, r GenerateRequest) (string, error)
+}
+
+// GenerateRequest is a request for the auto.ipxe script of a machine.
+type GenerateRequest struct {
+	MAC      net.HardwareAddr
+	Arch     string
+	IP       netip.Addr
+	Facility string
+	Labels   map[string]string
+	// Script is the Hook script that is served when the Generator returns an empty script.
+	Script string
+}
+
+// ErrNetbootNotAllowed is returned by Render when a machine is not allowed to netboot.
+var ErrNetbootNotAllowed = errors.New("the hardware data for this machine does not allow it to netboot")
+
+// Observer is notified after a boot script has been served to a machine.
+// ctx is the HTTP request context, implementations doing background work must not depend on it.
+type Observer interface {
+	ScriptServed(ctx context.Context, mac net.HardwareAddr, name string)
+}
+
+// Enroller creates a backend record for a machine that is unknown to the backend.
+type Enroller interface {
+	Enroll(ctx context.Context, mac net.HardwareAddr, arch string) error
 }
 
 type data struct {
@@ -40,9 +157,14 @@ type data struct {
 	VLANID        string
 	WorkflowID    string
 	Facility      string
+	Profile       string
 	IPXEScript    string
 	IPXEScriptURL *url.URL
 	OSIE          OSIE
+	IPAddress     netip.Addr
+	Labels        map[string]string
+	// Machine is the backend data that the extra kernel params templates are executed with.
+	Machine tmpl.Machine
 }
 
 // OSIE or OS Installation Environment is the data about where the OSIE parts are located.
@@ -66,18 +188,27 @@ func getByMac(ctx context.Context, mac net.HardwareAddr, br handler.BackendReade
 		return data{}, err
 	}
 
+	return toData(d, n), nil
+}
+
+// toData translates the backend data of a machine to the script.Data struct.
+func toData(d *dhcpdata.DHCP, n *dhcpdata.Netboot) data {
 	return data{
 		AllowNetboot:  n.AllowNetboot,
-		Console:       "",
+		Console:       strings.Join(n.ConsoleArgs(), " "),
 		MACAddress:    d.MACAddress,
 		Arch:          d.Arch,
 		VLANID:        d.VLANID,
 		WorkflowID:    d.MACAddress.String(),
 		Facility:      n.Facility,
+		Profile:       n.Profile,
 		IPXEScript:    n.IPXEScript,
 		IPXEScriptURL: n.IPXEScriptURL,
 		OSIE:          OSIE(n.OSIE),
-	}, nil
+		IPAddress:     d.IPAddress,
+		Labels:        n.Labels,
+		Machine:       tmpl.FromBackend(d, n),
+	}
 }
 
 func getByIP(ctx context.Context, ip net.IP, br handler.BackendReader) (data, error) {
@@ -89,25 +220,15 @@ func getByIP(ctx context.Context, ip net.IP, br handler.BackendReader) (data, er
 		return data{}, err
 	}
 
-	return data{
-		AllowNetboot:  n.AllowNetboot,
-		Console:       "",
-		MACAddress:    d.MACAddress,
-		Arch:          d.Arch,
-		VLANID:        d.VLANID,
-		WorkflowID:    d.MACAddress.String(),
-		Facility:      n.Facility,
-		IPXEScript:    n.IPXEScript,
-		IPXEScriptURL: n.IPXEScriptURL,
-		OSIE:          OSIE(n.OSIE),
-	}, nil
+	return toData(d, n), nil
 }
 
 // HandlerFunc returns a http.HandlerFunc that serves the ipxe script.
-// It is expected that the request path is /<mac address>/auto.ipxe.
+// It is expected that the request path is /<mac address>/auto.ipxe, or /<mac address>/hook.ipxe for the second stage script.
 func (h *Handler) HandlerFunc() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if path.Base(r.URL.Path) != "auto.ipxe" {
+		name := path.Base(r.URL.Path)
+		if name != "auto.ipxe" && name != "hook.ipxe" {
 			h.Logger.Info("URL path not supported", "path", r.URL.Path)
 			w.WriteHeader(http.StatusNotFound)
 
@@ -117,10 +238,32 @@ func (h *Handler) HandlerFunc() http.HandlerFunc {
 		metric.JobsTotal.With(labels).Inc()
 		metric.JobsInProgress.With(labels).Inc()
 		defer metric.JobsInProgress.With(labels).Dec()
-		timer := prometheus.NewTimer(metric.JobDuration.With(labels))
-		defer timer.ObserveDuration()
-
-		ctx := r.Context()
+		// the duration is linked to the boot trace that the request continues, or to the trace of the request.
+		boot := &bootSpan{}
+		ctx := context.WithValue(r.Context(), bootSpanKey{}, boot)
+		defer func(start time.Time) {
+			metric.ObserveDuration(boot.context(r.Context()), metric.JobDuration.With(labels), start)
+		}(time.Now())
+		ua := useragent.Classify(r.UserAgent())
+		metric.HTTPClientRequests.With(prometheus.Labels{"handler": "script", "client": string(ua)}).Inc()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if name == "auto.ipxe" && scriptFormat(r, ua) == formatGRUB {
+			h.serveGRUBConfig(ctx, w, r)
+			return
+		}
+		if name == "hook.ipxe" || hasIdentity(r.URL.Query()) {
+			h.serveIdentityScript(ctx, w, r)
+			return
+		}
+		if len(h.ConsoleRules) > 0 && !r.URL.Query().Has("console") {
+			h.serveConsoleStage(w, r)
+			return
+		}
+		if h.TwoStage {
+			h.serveFirstStage(w, r)
+			return
+		}
 
 		// Should we serve a custom ipxe script?
 		// This gates serving PXE file by
@@ -130,56 +273,144 @@ func (h *Handler) HandlerFunc() http.HandlerFunc {
 		// This allows serving custom ipxe scripts, starting up into OSIE or other installation environments
 		// without a tink workflow present.
 
-		// Try to get the MAC address from the URL path, if not available get the source IP address.
-		if ha, err := getMAC(r.URL.Path); err == nil {
+		// Identify the machine by the first client identification strategy that yields a MAC address or an IP address.
+		clients := h.identify(r)
+		if len(clients) > 0 {
+			h.Logger.V(1).Info("identified client", "client", r.RemoteAddr, "mac", clients[0].mac, "ip", clients[0].ip, "identifiedBy", clients[0].by)
+		}
+		if len(clients) > 0 && clients[0].mac != nil {
+			ha := clients[0].mac
 			hw, err := getByMac(ctx, ha, h.Backend)
 			if err != nil && h.StaticIPXEEnabled {
 				h.Logger.Info("serving static ipxe script", "mac", ha, "error", err)
-				h.serveStaticIPXEScript(w)
+				h.serveStaticIPXEScript(w, ha)
+				h.enroll(ctx, ha, r.URL.Query().Get("arch"), err)
+				return
+			}
+			if err != nil && h.serveFallbackScript(ctx, w, Fallback{MAC: ha.String(), IP: remoteIP(r.RemoteAddr), Reason: FallbackReasonLookup, Error: err.Error()}) {
 				return
 			}
-			if err != nil || !hw.AllowNetboot {
+			if err == nil {
+				hw = h.authorize(hw)
+			}
+			if err != nil || !hw.AllowNetboot || !h.settings().MACAllowed(hw.MACAddress) {
 				w.WriteHeader(http.StatusNotFound)
 				h.Logger.Info("the hardware data for this machine, or lack there of, does not allow it to pxe", "client", ha, "error", err)
 
 				return
 			}
-			h.serveBootScript(ctx, w, path.Base(r.URL.Path), hw)
+			h.serveBootScript(ctx, w, path.Base(r.URL.Path), h.detectedConsole(r.URL.Query(), hw))
 			return
 		}
-		if ip, err := getIP(r.RemoteAddr); err == nil {
+		if len(clients) > 0 {
+			ip := clients[0].ip
 			hw, err := getByIP(ctx, ip, h.Backend)
 			if err != nil && h.StaticIPXEEnabled {
 				h.Logger.Info("serving static ipxe script", "client", r.RemoteAddr, "error", err)
-				h.serveStaticIPXEScript(w)
+				h.serveStaticIPXEScript(w, nil)
+				return
+			}
+			if err != nil && h.serveFallbackScript(ctx, w, Fallback{IP: ip.String(), Reason: FallbackReasonLookup, Error: err.Error()}) {
 				return
 			}
-			if err != nil || !hw.AllowNetboot {
+			if err == nil {
+				hw = h.authorize(hw)
+			}
+			if err != nil || !hw.AllowNetboot || !h.settings().MACAllowed(hw.MACAddress) {
 				w.WriteHeader(http.StatusNotFound)
 				h.Logger.Info("the hardware data for this machine, or lack there of, does not allow it to pxe", "client", r.RemoteAddr, "error", err)
 
 				return
 			}
-			h.serveBootScript(ctx, w, path.Base(r.URL.Path), hw)
+			h.serveBootScript(ctx, w, path.Base(r.URL.Path), h.detectedConsole(r.URL.Query(), hw))
 			return
 		}
 
-		// If we get here, we were unable to get the MAC address from the URL path or the source IP address.
+		// If we get here, none of the client identification strategies identified the machine.
 		w.WriteHeader(http.StatusNotFound)
-		h.Logger.Info("unable to get the MAC address from the URL path or the source IP address", "client", r.RemoteAddr, "urlPath", r.URL.Path)
+		h.Logger.Info("unable to identify the machine of the request", "client", r.RemoteAddr, "urlPath", r.URL.Path, "identifiers", h.ClientIdentifiers)
+	}
+}
+
+// Render returns the auto.ipxe script that the machine with the MAC address is served, without serving it.
+// The same checks as for a script request apply, ErrNetbootNotAllowed is returned when they don't allow the machine to netboot.
+func (h *Handler) Render(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	b, err := h.Boot(ctx, mac)
+
+	return b.Script, err
+}
+
+// Boot is what a machine boots with, as decided by its auto.ipxe script.
+type Boot struct {
+	Script string
+	// Custom is whether the script is the custom script of the machine, the other fields are not set then.
+	Custom bool
+	// OSIEURL is the URL that Hook is downloaded from.
+	OSIEURL string
+	// TinkServer is the address of the Tink server that Hook connects to.
+	TinkServer string
+	// KernelParams are the extra kernel params of Hook, their templates executed.
+	KernelParams []string
+	// Held is whether the rollout Guard holds the changed configuration of the machine, the fields are those of the
+	// configuration that it last booted with then.
+	Held bool
+	// Pinned is the version of the snapshot of auto.ipxe that the machine is pinned to, 0 when it isn't pinned. The
+	// script is the snapshot and the other fields are not set then.
+	Pinned int
+}
+
+// Boot returns what the machine with the MAC address boots with, like Render.
+func (h *Handler) Boot(ctx context.Context, mac net.HardwareAddr) (Boot, error) {
+	hw, err := getByMac(ctx, mac, h.Backend)
+	if err != nil {
+		return Boot{}, err
+	}
+	hw = h.authorize(hw)
+	if !hw.AllowNetboot || !h.settings().MACAllowed(hw.MACAddress) {
+		return Boot{}, ErrNetbootNotAllowed
 	}
+	if sn, ok := h.Snapshots.Pinned(hw.MACAddress, "auto.ipxe"); ok {
+		return Boot{Script: sn.Content, Pinned: sn.Version}, nil
+	}
+	if hw.IPXEScriptURL != nil || hw.IPXEScript != "" {
+		s, err := h.customScript(hw)
+		return Boot{Script: s, Custom: true}, err
+	}
+	auto, err := h.bootHook(trace.SpanFromContext(ctx), hw)
+	if err != nil {
+		return Boot{}, err
+	}
+	// the configuration held by the rollout guard is previewed, the change is not admitted.
+	auto, held := h.held(hw, auto, h.Guard.Peek)
+	auto = h.mirrors(mac, auto)
+	s, err := GenerateTemplate(auto, HookScript)
+	if err == nil && h.Generator != nil {
+		s, err = h.generate(ctx, hw, s)
+	}
+	if err != nil {
+		return Boot{}, err
+	}
+
+	return Boot{Script: s, OSIEURL: auto.DownloadURL, TinkServer: auto.TinkGRPCAuthority, KernelParams: auto.ExtraKernelParams, Held: held}, nil
 }
 
-func (h *Handler) serveStaticIPXEScript(w http.ResponseWriter) {
-	// Serve static iPXE script.
+// serveStaticIPXEScript serves the static iPXE script, with the OSIE URL and kernel args of the OUI rule of mac, if any.
+// mac is nil when the machine was identified by its IP address.
+func (h *Handler) serveStaticIPXEScript(w http.ResponseWriter, mac net.HardwareAddr) {
 	auto := Hook{
-		DownloadURL:       h.OSIEURL,
-		ExtraKernelParams: h.ExtraKernelParams,
+		DownloadURL:       h.osieURL(),
+		ExtraKernelParams: h.extraKernelParams(),
 		SyslogHost:        h.PublicSyslogFQDN,
 		TinkerbellTLS:     h.TinkServerTLS,
 		TinkGRPCAuthority: h.TinkServerGRPCAddr,
 	}
-	script, err := GenerateTemplate(auto, StaticScript)
+	if r, ok := h.OUIRules.Lookup(mac); ok {
+		if r.OSIEURL != "" {
+			auto.DownloadURL = r.OSIEURL
+		}
+		auto.ExtraKernelParams = append(slices.Clone(auto.ExtraKernelParams), r.KernelArgs...)
+	}
+	script, err := GenerateTemplate(h.mirrors(mac, auto), StaticScript)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		h.Logger.Error(err, "error generating the static ipxe script")
@@ -192,6 +423,93 @@ func (h *Handler) serveStaticIPXEScript(w http.ResponseWriter) {
 	}
 }
 
+// enroll creates a backend record for a machine when the backend lookup error is a not found error.
+// The arch is optional and comes from the "arch" query parameter of the iPXE script request.
+func (h *Handler) enroll(ctx context.Context, mac net.HardwareAddr, arch string, lookupErr error) {
+	if h.Enroller == nil || !apierrors.IsNotFound(lookupErr) {
+		return
+	}
+	if err := h.Enroller.Enroll(ctx, mac, arch); err != nil {
+		h.Logger.Error(err, "unable to enroll discovered machine", "mac", mac)
+		return
+	}
+	h.Logger.Info("enrolled discovered machine", "mac", mac, "arch", arch)
+}
+
+// authorize applies the netboot policy, if any, to the hardware data.
+func (h *Handler) authorize(hw data) data {
+	if h.Policy == nil {
+		return hw
+	}
+	dec := h.Policy.Evaluate(policy.Request{
+		Source:       policy.SourceScript,
+		MAC:          hw.MACAddress,
+		Arch:         hw.Arch,
+		IP:           hw.IPAddress,
+		Labels:       hw.Labels,
+		AllowNetboot: hw.AllowNetboot,
+	})
+	if dec.Rule != "" {
+		h.Logger.Info("netboot policy rule matched", "mac", hw.MACAddress, "rule", dec.Rule, "allow", dec.Allow)
+	}
+	hw.AllowNetboot = dec.Allow
+	if dec.BootTarget != nil {
+		hw.IPXEScriptURL = dec.BootTarget
+	}
+
+	return hw
+}
+
+// settings returns the runtime settings, if any.
+func (h *Handler) settings() settings.Settings {
+	if h.Settings == nil {
+		return settings.Settings{}
+	}
+
+	return h.Settings.Get()
+}
+
+// osieURL returns the runtime OSIE URL override, if set, otherwise h.OSIEURL.
+func (h *Handler) osieURL() string {
+	if u := h.settings().OSIEURL; u != "" {
+		return u
+	}
+
+	return h.OSIEURL
+}
+
+// extraKernelParams returns the runtime extra kernel params override, if set, otherwise h.ExtraKernelParams.
+func (h *Handler) extraKernelParams() []string {
+	if p := h.settings().ExtraKernelArgs; len(p) > 0 {
+		return p
+	}
+
+	return h.ExtraKernelParams
+}
+
+// kernelParams executes the extra kernel params, when they are a template, with the backend data of the machine.
+func (h *Handler) kernelParams(ctx context.Context, hw data, params []string) ([]string, error) {
+	return tmpl.KernelArgs(params, hw.Machine, tmpl.Funcs(h.templateOptions(ctx)))
+}
+
+// templateOptions returns the options of the functions of operator defined templates, lookups use the backend.
+func (h *Handler) templateOptions(ctx context.Context) tmpl.Options {
+	return tmpl.Options{
+		Env: h.TemplateEnv,
+		Lookup: func(mac net.HardwareAddr) (tmpl.Machine, error) {
+			if h.Backend == nil {
+				return tmpl.Machine{}, errors.New("backend is nil")
+			}
+			d, n, err := h.Backend.GetByMac(ctx, mac)
+			if err != nil {
+				return tmpl.Machine{}, err
+			}
+
+			return tmpl.FromBackend(d, n), nil
+		},
+	}
+}
+
 func getIP(remoteAddr string) (net.IP, error) {
 	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
@@ -202,6 +520,16 @@ func getIP(remoteAddr string) (net.IP, error) {
 	return ip, nil
 }
 
+// remoteIP returns the IP address of a request remote address, it is empty when the address can't be parsed.
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return ""
+	}
+
+	return host
+}
+
 func getMAC(urlPath string) (net.HardwareAddr, error) {
 	mac := path.Base(path.Dir(urlPath))
 	ha, err := net.ParseMAC(mac)
@@ -213,20 +541,33 @@ func getMAC(urlPath string) (net.HardwareAddr, error) {
 }
 
 func (h *Handler) serveBootScript(ctx context.Context, w http.ResponseWriter, name string, hw data) {
+	ctx, end := h.continueBootTrace(ctx, name, hw.MACAddress)
+	defer end()
 	span := trace.SpanFromContext(ctx)
 	span.SetAttributes(attribute.String("smee.script_name", name))
+	if s, ok := h.pinned(span, hw.MACAddress, name); ok {
+		h.writeScript(ctx, w, span, name, hw, []byte(s))
+		return
+	}
+	requested := name
 	var script []byte
 	// check if the custom script should be used
 	if hw.IPXEScriptURL != nil || hw.IPXEScript != "" {
 		name = "custom.ipxe"
 	}
 	switch name {
-	case "auto.ipxe":
+	case "auto.ipxe", "hook.ipxe":
 		s, err := h.defaultScript(span, hw)
+		if err == nil && h.Generator != nil {
+			s, err = h.generate(ctx, hw, s)
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
 			h.Logger.Error(err, "error with default ipxe script", "script", name)
 			span.SetStatus(codes.Error, err.Error())
+			if h.serveFallbackScript(ctx, w, renderFallback(hw, err)) {
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
 
 			return
 		}
@@ -234,9 +575,12 @@ func (h *Handler) serveBootScript(ctx context.Context, w http.ResponseWriter, na
 	case "custom.ipxe":
 		cs, err := h.customScript(hw)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
 			h.Logger.Error(err, "error with custom ipxe script", "script", name)
 			span.SetStatus(codes.Error, err.Error())
+			if h.serveFallbackScript(ctx, w, renderFallback(hw, err)) {
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
 
 			return
 		}
@@ -249,6 +593,37 @@ func (h *Handler) serveBootScript(ctx context.Context, w http.ResponseWriter, na
 
 		return
 	}
+	if err := h.validate(span, hw, name, string(script)); err != nil {
+		if h.serveFallbackScript(ctx, w, renderFallback(hw, err)) {
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+	h.record(hw.MACAddress, requested, string(script))
+	h.writeScript(ctx, w, span, name, hw, script)
+}
+
+// validate returns the ValidationError of the script name of the machine when the Handler validates scripts.
+func (h *Handler) validate(span trace.Span, hw data, name, script string) error {
+	if !h.Validate {
+		return nil
+	}
+	err := ValidateScript(script, h.MaxScriptSize)
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	h.Logger.Error(err, "not serving the invalid ipxe script", "mac", hw.MACAddress.String(), "script", name, "reason", ve.Reason)
+	metric.ScriptsInvalid.WithLabelValues(ve.Reason).Inc()
+	span.SetStatus(codes.Error, err.Error())
+
+	return err
+}
+
+// writeScript writes the boot script name of the machine and notifies the Observers.
+func (h *Handler) writeScript(ctx context.Context, w http.ResponseWriter, span trace.Span, name string, hw data, script []byte) {
 	span.SetAttributes(attribute.String("ipxe-script", string(script)))
 
 	if _, err := w.Write(script); err != nil {
@@ -257,9 +632,121 @@ func (h *Handler) serveBootScript(ctx context.Context, w http.ResponseWriter, na
 
 		return
 	}
+	for _, o := range h.Observers {
+		o.ScriptServed(ctx, hw.MACAddress, name)
+	}
+}
+
+// pinned returns the snapshot of the script name that the machine is pinned to, it is served in place of a new rendering.
+func (h *Handler) pinned(span trace.Span, mac net.HardwareAddr, name string) (string, bool) {
+	sn, ok := h.Snapshots.Pinned(mac, name)
+	if !ok {
+		return "", false
+	}
+	h.Logger.Info("the machine is pinned to a snapshot of its boot script, serving the snapshot", "mac", mac.String(), "script", name, "version", sn.Version)
+	span.SetAttributes(attribute.Int("smee.snapshot_pinned", sn.Version))
+
+	return sn.Content, true
+}
+
+// record keeps the rendering of the script name that the machine is served as its latest snapshot.
+func (h *Handler) record(mac net.HardwareAddr, name, script string) {
+	if _, err := h.Snapshots.Record(mac, name, script); err != nil {
+		h.Logger.Error(err, "unable to save the snapshot of the boot script", "mac", mac.String(), "script", name)
+	}
 }
 
 func (h *Handler) defaultScript(span trace.Span, hw data) (string, error) {
+	auto, err := h.bootHook(span, hw)
+	if err != nil {
+		return "", err
+	}
+
+	return GenerateTemplate(h.mirrors(hw.MACAddress, h.guard(span, hw, auto)), HookScript)
+}
+
+// bootHook returns the values used to generate the script that loads Hook, with the extra kernel params templates executed.
+func (h *Handler) bootHook(span trace.Span, hw data) (Hook, error) {
+	auto := h.hook(span, hw)
+	p, err := h.kernelParams(trace.ContextWithSpan(context.Background(), span), hw, auto.ExtraKernelParams)
+	if err != nil {
+		return Hook{}, err
+	}
+	auto.ExtraKernelParams = p
+
+	return auto, nil
+}
+
+// guard returns auto with the configuration that the Guard admits for the machine, the configuration that it last
+// booted with when its change is held.
+func (h *Handler) guard(span trace.Span, hw data, auto Hook) Hook {
+	last, held := h.held(hw, auto, h.Guard.Admit)
+	if !held {
+		return auto
+	}
+	h.Logger.Info("the changed boot configuration of the machine is held by the rollout guard, serving its previous configuration", "mac", hw.MACAddress.String(), "osieURL", last.DownloadURL, "changedOSIEURL", auto.DownloadURL)
+	metric.RolloutHeld.Inc()
+	span.SetAttributes(attribute.Bool("smee.rollout_held", true))
+
+	return last
+}
+
+// held returns the configuration that decide, Guard.Admit or Guard.Peek, returns for auto, and whether it is the held
+// configuration that the machine last booted with. The values that differ on every boot, the trace and the signed ISO
+// and phone home URLs, are not part of the configuration.
+func (h *Handler) held(hw data, auto Hook, decide func(net.HardwareAddr, string) (string, bool)) (Hook, bool) {
+	if h.Guard == nil {
+		return auto, false
+	}
+	c := auto
+	c.TraceID, c.Traceparent, c.ISOURL, c.PhoneHomeURL = "", "", "", ""
+	b, err := json.Marshal(c)
+	if err != nil {
+		return auto, false
+	}
+	boot, held := decide(hw.MACAddress, string(b))
+	if !held {
+		return auto, false
+	}
+	last := Hook{}
+	if err := json.Unmarshal([]byte(boot), &last); err != nil {
+		return auto, false
+	}
+	last.TraceID, last.Traceparent, last.ISOURL, last.PhoneHomeURL = auto.TraceID, auto.Traceparent, auto.ISOURL, auto.PhoneHomeURL
+
+	return last, true
+}
+
+// mirrors returns auto with the OSIE URL and Tink server that the Mirrors answer for the machine.
+func (h *Handler) mirrors(mac net.HardwareAddr, auto Hook) Hook {
+	auto.DownloadURL = h.Mirrors.Select(mac, auto.DownloadURL)
+	auto.TinkGRPCAuthority = h.Mirrors.Select(mac, auto.TinkGRPCAuthority)
+
+	return auto
+}
+
+// generate returns the script of the Generator, or hook when it returns an empty script.
+func (h *Handler) generate(ctx context.Context, hw data, hook string) (string, error) {
+	s, err := h.Generator.GenerateScript(ctx, GenerateRequest{
+		MAC:      hw.MACAddress,
+		Arch:     hw.Arch,
+		IP:       hw.IPAddress,
+		Facility: hw.Facility,
+		Labels:   hw.Labels,
+		Script:   hook,
+	})
+	if err != nil {
+		return "", fmt.Errorf("generating the auto.ipxe script: %w", err)
+	}
+	if s == "" {
+		return hook, nil
+	}
+
+	return s, nil
+}
+
+// hook returns the values used to generate the scripts that load Hook.
+func (h *Handler) hook(span trace.Span, hw data) Hook {
 	mac := hw.MACAddress
 	arch := hw.Arch
 	if arch == "" {
@@ -273,9 +760,9 @@ func (h *Handler) defaultScript(span trace.Span, hw data) (string, error) {
 
 	auto := Hook{
 		Arch:                  arch,
-		Console:               "",
-		DownloadURL:           h.OSIEURL,
-		ExtraKernelParams:     h.ExtraKernelParams,
+		Console:               hw.Console,
+		DownloadURL:           h.osieURL(),
+		ExtraKernelParams:     h.extraKernelParams(),
 		Facility:              hw.Facility,
 		HWAddr:                mac.String(),
 		SyslogHost:            h.PublicSyslogFQDN,
@@ -287,6 +774,53 @@ func (h *Handler) defaultScript(span trace.Span, hw data) (string, error) {
 		Retries:               h.IPXEScriptRetries,
 		RetryDelay:            h.IPXEScriptRetryDelay,
 	}
+	// the rollout track replaces the configured and runtime OSIE URL.
+	if t, ok := h.Rollout.Select(mac, hw.Labels); ok {
+		auto.DownloadURL = t.OSIEURL
+		span.SetAttributes(attribute.String("smee.osie_track", t.Name))
+	}
+	// the tenant replaces the rollout track, the configured and runtime OSIE URL and Tink server.
+	tn := hw.Labels[tenant.Label]
+	if t := h.Tenants.Get(tn); t.OSIEURL != "" || t.TinkServer != "" {
+		if t.OSIEURL != "" {
+			auto.DownloadURL = t.OSIEURL
+		}
+		if t.TinkServer != "" {
+			auto.TinkGRPCAuthority = t.TinkServer
+		}
+		span.SetAttributes(attribute.String("smee.tenant", tn))
+	}
+	// facility overrides take precedence over the rollout track, the configured and runtime values.
+	fo := h.Facilities.Get(hw.Facility)
+	if fo.OSIEURL != "" {
+		auto.DownloadURL = fo.OSIEURL
+	}
+	if len(fo.ExtraKernelArgs) > 0 {
+		auto.ExtraKernelParams = fo.ExtraKernelArgs
+	}
+	if fo.SyslogIP != "" {
+		auto.SyslogHost = fo.SyslogIP
+	}
+	if fo.TinkServer != "" {
+		auto.TinkGRPCAuthority = fo.TinkServer
+	}
+	// the boot profile takes precedence over the facility, the OSIE URL of the machine over both.
+	if p, ok := h.profile(hw); ok {
+		if p.OSIEURL != "" {
+			auto.DownloadURL = p.OSIEURL
+		}
+		if len(p.KernelArgs) > 0 {
+			auto.ExtraKernelParams = p.KernelArgs
+		}
+		auto.NoWorkflow = p.NoWorkflow()
+		span.SetAttributes(attribute.String("smee.boot_profile", p.Name))
+	}
+	if h.ISOURL != nil {
+		auto.ISOURL = h.tenantURL(tn, h.ISOURL(mac))
+	}
+	if h.PhoneHomeURL != nil {
+		auto.PhoneHomeURL = h.tenantURL(tn, h.PhoneHomeURL(mac))
+	}
 	if hw.OSIE.BaseURL != nil {
 		auto.DownloadURL = hw.OSIE.BaseURL.String()
 	}
@@ -299,8 +833,68 @@ func (h *Handler) defaultScript(span trace.Span, hw data) (string, error) {
 	if sc := span.SpanContext(); sc.IsSampled() {
 		auto.TraceID = sc.TraceID().String()
 	}
+	if h.BootTraces != nil && span.SpanContext().IsValid() {
+		auto.Traceparent = otel.TraceparentStringFromContext(trace.ContextWithSpan(context.Background(), span))
+	}
+
+	return auto
+}
+
+// profile returns the boot profile of the machine: the profile named by its backend record, or else the profile
+// that its DHCP client last matched.
+func (h *Handler) profile(hw data) (profile.Profile, bool) {
+	if hw.Profile == "" {
+		return h.Profiles.Get(hw.MACAddress)
+	}
+	p, ok := h.Profiles.Named(hw.Profile)
+	if !ok {
+		h.Logger.Info("the boot profile of the machine does not exist", "mac", hw.MACAddress.String(), "profile", hw.Profile)
+	}
+
+	return p, ok
+}
+
+// tenantURL returns the URL u of Smee with the URL prefix of the tenant of the name.
+func (h *Handler) tenantURL(name, u string) string {
+	pu, err := url.Parse(u)
+	if err != nil || h.Tenants.Get(name).URLPrefix == "" {
+		return u
+	}
+
+	return h.Tenants.URL(name, pu).String()
+}
+
+// continueBootTrace returns ctx with a new span in the trace of the last DHCP reply sent to mac, linked to the span of ctx.
+// The returned func ends the new span. ctx is returned unmodified when there is no trace to continue.
+func (h *Handler) continueBootTrace(ctx context.Context, name string, mac net.HardwareAddr) (context.Context, func()) {
+	pctx, ok := h.BootTraces.Context(ctx, mac)
+	if !ok {
+		return ctx, func() {}
+	}
+	link := trace.LinkFromContext(ctx)
+	b, _ := ctx.Value(bootSpanKey{}).(*bootSpan)
+	ctx, span := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(pctx, "Boot script served: "+name, trace.WithLinks(link))
+	if b != nil {
+		b.sc = span.SpanContext()
+	}
+
+	return ctx, func() { span.End() }
+}
+
+// bootSpan is the span of the boot trace that a script request continued, it is set by continueBootTrace.
+type bootSpan struct {
+	sc trace.SpanContext
+}
+
+type bootSpanKey struct{}
+
+// context returns ctx with the span of the boot trace when the request continued one.
+func (b *bootSpan) context(ctx context.Context) context.Context {
+	if !b.sc.IsValid() {
+		return ctx
+	}
 
-	return GenerateTemplate(auto, HookScript)
+	return trace.ContextWithSpanContext(ctx, b.sc)
 }
 
 // customScript returns the custom script or chain URL if defined in the hardware data otherwise an error.
@@ -2,16 +2,45 @@ package script
 
 import (
 	"context"
+	"errors"
 	"net"
+	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dhcpdata "github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/facility"
 	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/mirror"
+	"github.com/tinkerbell/smee/internal/otel"
+	"github.com/tinkerbell/smee/internal/oui"
+	"github.com/tinkerbell/smee/internal/policy"
+	"github.com/tinkerbell/smee/internal/profile"
+	"github.com/tinkerbell/smee/internal/rollout"
+	"github.com/tinkerbell/smee/internal/settings"
+	"github.com/tinkerbell/smee/internal/snapshot"
+	"github.com/tinkerbell/smee/internal/tenant"
+	gotel "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
 func TestCustomScript(t *testing.T) {
 	tests := map[string]struct {
 		ipxeURL    string
@@ -172,7 +201,6 @@ echo Failed to boot
 imgfree
 exit
 `
-	metric.Init()
 	h := &Handler{
 		OSIEURL:            "http://127.0.0.1",
 		ExtraKernelParams:  []string{"k=v", "k2=v2"},
@@ -192,3 +220,846 @@ exit
 		t.Fatalf("expected custom script, got %s", diff)
 	}
 }
+
+func TestStaticScriptOUIRules(t *testing.T) {
+	rules, err := oui.Parse([]byte("rules:\n- name: supermicro\n  prefixes: [\"3c:ec:ef\"]\n  osieURL: http://10.1.0.5/hook\n  kernelArgs: [\"console=ttyS1,115200n8\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{OSIEURL: "http://127.0.0.1", ExtraKernelParams: []string{"k=v"}, OUIRules: rules}
+	tests := map[string]struct {
+		mac      net.HardwareAddr
+		wantURL  string
+		wantArgs string
+	}{
+		"matching rule":    {mac: net.HardwareAddr{0x3c, 0xec, 0xef, 0x01, 0x02, 0x03}, wantURL: "http://10.1.0.5/hook", wantArgs: "k=v console=ttyS1,115200n8"},
+		"no matching rule": {mac: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, wantURL: "http://127.0.0.1", wantArgs: "k=v initrd"},
+		"unknown mac":      {wantURL: "http://127.0.0.1", wantArgs: "k=v initrd"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.serveStaticIPXEScript(w, tt.mac)
+			if !strings.Contains(w.Body.String(), "set download-url "+tt.wantURL+"\n") {
+				t.Fatalf("expected download-url %s, got %s", tt.wantURL, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantArgs) {
+				t.Fatalf("expected kernel args %q, got %s", tt.wantArgs, w.Body.String())
+			}
+		})
+	}
+	if len(h.ExtraKernelParams) != 1 {
+		t.Fatalf("expected the extra kernel params not to be modified, got %v", h.ExtraKernelParams)
+	}
+}
+
+type staticSettings settings.Settings
+
+func (s staticSettings) Get() settings.Settings { return settings.Settings(s) }
+
+func TestSettingsOverride(t *testing.T) {
+	tests := map[string]struct {
+		settings   settings.Reader
+		wantURL    string
+		wantParams []string
+	}{
+		"no settings":    {wantURL: "http://127.0.0.1", wantParams: []string{"k=v"}},
+		"empty settings": {settings: staticSettings{}, wantURL: "http://127.0.0.1", wantParams: []string{"k=v"}},
+		"overrides": {
+			settings:   staticSettings{OSIEURL: "http://10.1.1.1", ExtraKernelArgs: []string{"a=b"}},
+			wantURL:    "http://10.1.1.1",
+			wantParams: []string{"a=b"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{OSIEURL: "http://127.0.0.1", ExtraKernelParams: []string{"k=v"}, Settings: tt.settings}
+			if diff := cmp.Diff(tt.wantURL, h.osieURL()); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(tt.wantParams, h.extraKernelParams()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestFacilityOverride(t *testing.T) {
+	fc := &facility.Config{Facilities: map[string]facility.Override{
+		"sjc1": {OSIEURL: "http://10.1.0.5", TinkServer: "10.1.0.6:42113", SyslogIP: "10.1.0.7", ExtraKernelArgs: []string{"a=b"}},
+	}}
+	tests := map[string]struct {
+		hw   data
+		want Hook
+	}{
+		"facility overrides": {
+			hw:   data{Facility: "sjc1"},
+			want: Hook{DownloadURL: "http://10.1.0.5", TinkGRPCAuthority: "10.1.0.6:42113", SyslogHost: "10.1.0.7", ExtraKernelParams: []string{"a=b"}},
+		},
+		"no overrides for the facility": {
+			hw:   data{Facility: "ams2"},
+			want: Hook{DownloadURL: "http://127.0.0.1", TinkGRPCAuthority: "127.0.0.1:42113", SyslogHost: "127.0.0.1", ExtraKernelParams: []string{"k=v"}},
+		},
+		"backend osie url wins": {
+			hw:   data{Facility: "sjc1", OSIE: OSIE{BaseURL: &url.URL{Scheme: "http", Host: "10.9.9.9"}}},
+			want: Hook{DownloadURL: "http://10.9.9.9", TinkGRPCAuthority: "10.1.0.6:42113", SyslogHost: "10.1.0.7", ExtraKernelParams: []string{"a=b"}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{
+				OSIEURL:            "http://127.0.0.1",
+				ExtraKernelParams:  []string{"k=v"},
+				PublicSyslogFQDN:   "127.0.0.1",
+				TinkServerGRPCAddr: "127.0.0.1:42113",
+				Facilities:         fc,
+			}
+			hook := h.hook(trace.SpanFromContext(context.Background()), tt.hw)
+			got := Hook{DownloadURL: hook.DownloadURL, TinkGRPCAuthority: hook.TinkGRPCAuthority, SyslogHost: hook.SyslogHost, ExtraKernelParams: hook.ExtraKernelParams}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestTenantOverride(t *testing.T) {
+	tc, err := tenant.Parse([]byte(`{tenants: {acme: {urlPrefix: /acme, osieURL: "http://10.10.0.5", tinkServer: "10.10.0.6:42113"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &facility.Config{Facilities: map[string]facility.Override{"sjc1": {TinkServer: "10.1.0.6:42113"}}}
+	tests := map[string]struct {
+		hw   data
+		want Hook
+	}{
+		"tenant": {
+			hw:   data{Labels: map[string]string{tenant.Label: "acme"}},
+			want: Hook{DownloadURL: "http://10.10.0.5", TinkGRPCAuthority: "10.10.0.6:42113", ISOURL: "http://127.0.0.1/acme/iso/00:01:02:03:04:05/hook.iso"},
+		},
+		"no tenant": {
+			hw:   data{},
+			want: Hook{DownloadURL: "http://127.0.0.1", TinkGRPCAuthority: "127.0.0.1:42113", ISOURL: "http://127.0.0.1/iso/00:01:02:03:04:05/hook.iso"},
+		},
+		"facility wins": {
+			hw:   data{Facility: "sjc1", Labels: map[string]string{tenant.Label: "acme"}},
+			want: Hook{DownloadURL: "http://10.10.0.5", TinkGRPCAuthority: "10.1.0.6:42113", ISOURL: "http://127.0.0.1/acme/iso/00:01:02:03:04:05/hook.iso"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{
+				OSIEURL:            "http://127.0.0.1",
+				TinkServerGRPCAddr: "127.0.0.1:42113",
+				Facilities:         fc,
+				Tenants:            tc,
+				ISOURL:             func(mac net.HardwareAddr) string { return "http://127.0.0.1/iso/" + mac.String() + "/hook.iso" },
+			}
+			tt.hw.MACAddress = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+			hook := h.hook(trace.SpanFromContext(context.Background()), tt.hw)
+			got := Hook{DownloadURL: hook.DownloadURL, TinkGRPCAuthority: hook.TinkGRPCAuthority, ISOURL: hook.ISOURL}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestRollout(t *testing.T) {
+	rc := &rollout.Config{Tracks: []rollout.Track{
+		{Name: "stable", OSIEURL: "http://10.1.0.5/v1", Weight: 1},
+		{Name: "canary", OSIEURL: "http://10.1.0.5/v2"},
+	}}
+	fc := &facility.Config{Facilities: map[string]facility.Override{"sjc1": {OSIEURL: "http://10.1.0.6"}}}
+	tests := map[string]struct {
+		hw   data
+		want string
+	}{
+		"weighted track":   {hw: data{}, want: "http://10.1.0.5/v1"},
+		"track label":      {hw: data{Labels: map[string]string{rollout.TrackLabel: "canary"}}, want: "http://10.1.0.5/v2"},
+		"facility wins":    {hw: data{Facility: "sjc1"}, want: "http://10.1.0.6"},
+		"backend url wins": {hw: data{OSIE: OSIE{BaseURL: &url.URL{Scheme: "http", Host: "10.9.9.9"}}}, want: "http://10.9.9.9"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{OSIEURL: "http://127.0.0.1", Rollout: rc, Facilities: fc}
+			tt.hw.MACAddress = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+			if got := h.hook(trace.SpanFromContext(context.Background()), tt.hw).DownloadURL; got != tt.want {
+				t.Fatalf("got osie url %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootProfile(t *testing.T) {
+	pc, err := profile.Parse([]byte(`{profiles: [{name: burnin, osieURL: "http://10.1.0.5/burnin", kernelArgs: [burnin_duration=4h], workflow: false}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		profile      string
+		wantOSIEURL  string
+		wantArgs     []string
+		wantWorkflow bool
+	}{
+		"burnin":          {profile: "burnin", wantOSIEURL: "http://10.1.0.5/burnin", wantArgs: []string{"burnin_duration=4h"}},
+		"unknown profile": {profile: "memtest", wantOSIEURL: "http://127.0.0.1", wantArgs: []string{"k=v"}, wantWorkflow: true},
+		"no profile":      {wantOSIEURL: "http://127.0.0.1", wantArgs: []string{"k=v"}, wantWorkflow: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{OSIEURL: "http://127.0.0.1", ExtraKernelParams: []string{"k=v"}, TinkServerGRPCAddr: "127.0.0.1:42113", Profiles: pc}
+			hw := toData(&dhcpdata.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}}, &dhcpdata.Netboot{Profile: tt.profile})
+			auto, err := h.bootHook(trace.SpanFromContext(context.Background()), hw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if auto.DownloadURL != tt.wantOSIEURL {
+				t.Fatalf("got osie url %q, want %q", auto.DownloadURL, tt.wantOSIEURL)
+			}
+			if diff := cmp.Diff(tt.wantArgs, auto.ExtraKernelParams); diff != "" {
+				t.Fatal(diff)
+			}
+			s, err := GenerateTemplate(auto, HookScript)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(s, "grpc_authority=127.0.0.1:42113"); got != tt.wantWorkflow {
+				t.Fatalf("got the Tink server kernel args %v, want %v, script:\n%s", got, tt.wantWorkflow, s)
+			}
+			if !strings.Contains(s, "syslog_host= hw_addr=00:01:02:03:04:05") && !tt.wantWorkflow {
+				t.Fatalf("expected the kernel args without a workflow to stay separated, got:\n%s", s)
+			}
+		})
+	}
+}
+
+func TestRolloutGuard(t *testing.T) {
+	g, err := rollout.NewGuard(1, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{OSIEURL: "http://10.1.0.5/v1", Guard: g}
+	machines := []data{
+		toData(&dhcpdata.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}}, &dhcpdata.Netboot{}),
+		toData(&dhcpdata.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}}, &dhcpdata.Netboot{}),
+	}
+	for _, hw := range machines {
+		if _, err := h.defaultScript(trace.SpanFromContext(context.Background()), hw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.OSIEURL = "http://10.1.0.5/v2"
+	for i, want := range []string{"http://10.1.0.5/v2", "http://10.1.0.5/v1"} {
+		s, err := h.defaultScript(trace.SpanFromContext(context.Background()), machines[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(s, "set download-url "+want+"\n") {
+			t.Fatalf("expected machine %d to boot %s, got:\n%s", i, want, s)
+		}
+	}
+
+	// the boot configuration of the held machine is previewed without taking the slot of the window.
+	h.Logger, h.Backend = logr.Discard(), fakeBackend{netboot: &dhcpdata.Netboot{AllowNetboot: true}}
+	h.OSIEURL = "http://10.1.0.5/v3"
+	b, err := h.Boot(context.Background(), machines[1].MACAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.OSIEURL != "http://10.1.0.5/v1" || !b.Held {
+		t.Fatalf("got OSIE URL %s (held %v), want the held machine previewed on v1", b.OSIEURL, b.Held)
+	}
+	if b, err := h.Boot(context.Background(), machines[0].MACAddress); err != nil || b.OSIEURL != "http://10.1.0.5/v3" || b.Held {
+		t.Fatalf("got OSIE URL %s (held %v, %v), want the machine with the slot previewed on v3", b.OSIEURL, b.Held, err)
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	m, err := mirror.Parse([]byte(`
+groups:
+- name: osie
+  candidates: [{url: "http://10.1.0.5/hook"}, {url: "http://10.2.0.5/hook", weight: 100}]
+- name: tink
+  candidates: [{url: "10.1.0.6:42113"}, {url: "10.2.0.6:42113", weight: 100}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{OSIEURL: "http://127.0.0.1", TinkServerGRPCAddr: "10.1.0.6:42113", Mirrors: m}
+	hw := toData(&dhcpdata.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}}, &dhcpdata.Netboot{OSIE: dhcpdata.OSIE{BaseURL: &url.URL{Scheme: "http", Host: "10.1.0.5", Path: "/hook"}}})
+	s, err := h.defaultScript(trace.SpanFromContext(context.Background()), hw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"set download-url http://10.2.0.5/hook\n",
+		"grpc_authority=10.2.0.6:42113 ",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("expected the script to contain %q, got:\n%s", want, s)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	store, err := snapshot.NewStore(5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{OSIEURL: "http://10.1.0.5/v1", Snapshots: store}
+	hw := toData(&dhcpdata.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}}, &dhcpdata.Netboot{})
+	serve := func() string {
+		w := httptest.NewRecorder()
+		h.serveBootScript(context.Background(), w, "auto.ipxe", hw)
+		return w.Body.String()
+	}
+	serve()
+	h.OSIEURL = "http://10.1.0.5/v2"
+	serve()
+	if list, _ := store.List(hw.MACAddress); len(list) != 2 || !strings.Contains(list[1].Content, "set download-url http://10.1.0.5/v2\n") {
+		t.Fatalf("got snapshots %+v, want both renderings", list)
+	}
+
+	// a machine pinned to its first snapshot is served it in place of a new rendering.
+	if _, err := store.Pin(hw.MACAddress, 1); err != nil {
+		t.Fatal(err)
+	}
+	if s := serve(); !strings.Contains(s, "set download-url http://10.1.0.5/v1\n") {
+		t.Fatalf("expected the pinned snapshot, got:\n%s", s)
+	}
+	if list, _ := store.List(hw.MACAddress); len(list) != 2 {
+		t.Fatalf("got %d snapshots, want the pinned snapshot not recorded again", len(list))
+	}
+
+	// the boot configuration of the pinned machine is its pinned snapshot.
+	h.Logger, h.Backend = logr.Discard(), fakeBackend{netboot: &dhcpdata.Netboot{AllowNetboot: true}}
+	b, err := h.Boot(context.Background(), hw.MACAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn, _ := store.Get(hw.MACAddress, 1); b.Script != sn.Content || b.Pinned != 1 || b.OSIEURL != "" {
+		t.Fatalf("got %+v, want the pinned snapshot 1", b)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		script   string
+		validate bool
+		wantCode int
+	}{
+		"valid":          {script: "chain http://10.1.0.5/boot.ipxe", validate: true, wantCode: http.StatusOK},
+		"invalid":        {script: "chian http://10.1.0.5/boot.ipxe", validate: true, wantCode: http.StatusInternalServerError},
+		"not validating": {script: "chian http://10.1.0.5/boot.ipxe", wantCode: http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Validate: tt.validate}
+			before := testutil.ToFloat64(metric.ScriptsInvalid.WithLabelValues(InvalidCommand))
+			w := httptest.NewRecorder()
+			h.serveBootScript(context.Background(), w, "auto.ipxe", data{MACAddress: mac, IPXEScript: tt.script})
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			want := 0.0
+			if tt.wantCode != http.StatusOK {
+				want = 1
+			}
+			if got := testutil.ToFloat64(metric.ScriptsInvalid.WithLabelValues(InvalidCommand)) - before; got != want {
+				t.Fatalf("got %v invalid scripts counted, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestConsole(t *testing.T) {
+	h := &Handler{}
+	hw := toData(&dhcpdata.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}}, &dhcpdata.Netboot{Console: "tty0 ttyS1,115200n8"})
+	s, err := h.defaultScript(trace.SpanFromContext(context.Background()), hw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(s, "iommu=pt initrd=initramfs-${arch} console=tty0 console=ttyS1,115200n8 && goto download_initrd") {
+		t.Fatalf("expected the console of the backend record in the script, got:\n%s", s)
+	}
+
+	hw.Console = ""
+	if s, err = h.defaultScript(trace.SpanFromContext(context.Background()), hw); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(s, "console=tty0 console=ttyS1,115200 && goto download_initrd") {
+		t.Fatalf("expected the default consoles in the script, got:\n%s", s)
+	}
+}
+
+type fakeEnroller struct {
+	mac  net.HardwareAddr
+	arch string
+}
+
+func (f *fakeEnroller) Enroll(_ context.Context, mac net.HardwareAddr, arch string) error {
+	f.mac = mac
+	f.arch = arch
+	return nil
+}
+
+func TestEnroll(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		lookupErr error
+		want      *fakeEnroller
+	}{
+		"not found":   {lookupErr: apierrors.NewNotFound(schema.GroupResource{}, ""), want: &fakeEnroller{mac: mac, arch: "x86_64"}},
+		"other error": {lookupErr: errors.New("backend unavailable"), want: &fakeEnroller{}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fe := &fakeEnroller{}
+			h := &Handler{Enroller: fe}
+			h.enroll(context.Background(), mac, "x86_64", tt.lookupErr)
+			if diff := cmp.Diff(tt.want, fe, cmp.AllowUnexported(fakeEnroller{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+type fakeObserver struct {
+	mac  net.HardwareAddr
+	name string
+}
+
+func (f *fakeObserver) ScriptServed(_ context.Context, mac net.HardwareAddr, name string) {
+	f.mac = mac
+	f.name = name
+}
+
+func TestObservers(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	fo := &fakeObserver{}
+	h := &Handler{Observers: []Observer{fo}}
+	w := httptest.NewRecorder()
+	h.serveBootScript(context.Background(), w, "auto.ipxe", data{MACAddress: mac, IPXEScript: "exit"})
+	if diff := cmp.Diff(&fakeObserver{mac: mac, name: "custom.ipxe"}, fo, cmp.AllowUnexported(fakeObserver{})); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestBootTraceparent(t *testing.T) {
+	gotel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { gotel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator()) })
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x23, 0xb1, 0xe3, 0x07, 0xbb, 0x35, 0x48, 0x4f, 0x53, 0x5a, 0x1f, 0x77, 0x2c, 0x06, 0x91, 0x0e},
+		SpanID:     trace.SpanID{0xd8, 0x87, 0xdc, 0x39, 0x12, 0x24, 0x04, 0x34},
+		TraceFlags: trace.FlagsSampled,
+	})
+	tests := map[string]struct {
+		traces *otel.BootTraces
+		want   string
+	}{
+		"dhcp trace continued": {traces: &otel.BootTraces{}, want: " traceparent=00-23b1e307bb35484f535a1f772c06910e-"},
+		"disabled":             {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.traces.DHCPServed(trace.ContextWithSpanContext(context.Background(), sc), mac, "ACK")
+			h := &Handler{BootTraces: tt.traces}
+			w := httptest.NewRecorder()
+			boot := &bootSpan{}
+			h.serveBootScript(context.WithValue(context.Background(), bootSpanKey{}, boot), w, "auto.ipxe", data{MACAddress: mac})
+			got := w.Body.String()
+			if tt.want == "" {
+				if strings.Contains(got, "traceparent=") {
+					t.Fatalf("expected no traceparent kernel arg, got:\n%s", got)
+				}
+				if boot.sc.IsValid() {
+					t.Fatal("expected no boot trace for the duration exemplar")
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Fatalf("expected %q in the script, got:\n%s", tt.want, got)
+			}
+			if boot.sc.TraceID() != sc.TraceID() {
+				t.Fatalf("got the trace %s for the duration exemplar, want the DHCP trace %s", boot.sc.TraceID(), sc.TraceID())
+			}
+		})
+	}
+}
+
+type fakeGenerator struct {
+	script string
+	err    error
+	got    GenerateRequest
+}
+
+func (f *fakeGenerator) GenerateScript(_ context.Context, r GenerateRequest) (string, error) {
+	f.got = r
+	return f.script, f.err
+}
+
+func TestGenerator(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		gen      *fakeGenerator
+		wantCode int
+		want     string
+	}{
+		"generated script": {gen: &fakeGenerator{script: "#!ipxe\nexit"}, wantCode: 200, want: "#!ipxe\nexit"},
+		"hook script":      {gen: &fakeGenerator{}, wantCode: 200},
+		"error":            {gen: &fakeGenerator{err: errors.New("inventory unavailable")}, wantCode: 500},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Logger: logr.Discard(), Generator: tt.gen}
+			hw := data{MACAddress: mac, Arch: "x86_64", Facility: "onprem"}
+			w := httptest.NewRecorder()
+			h.serveBootScript(context.Background(), w, "auto.ipxe", hw)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status code %d, want %d", w.Code, tt.wantCode)
+			}
+			hook, err := h.defaultScript(trace.SpanFromContext(context.Background()), hw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(GenerateRequest{MAC: mac, Arch: "x86_64", Facility: "onprem", Script: hook}, tt.gen.got, cmpopts.EquateComparable(netip.Addr{})); diff != "" {
+				t.Fatal(diff)
+			}
+			if tt.wantCode != 200 {
+				return
+			}
+			want := tt.want
+			if want == "" {
+				want = hook
+			}
+			if diff := cmp.Diff(want, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+type fakeBackend struct {
+	netboot *dhcpdata.Netboot
+}
+
+func (f fakeBackend) GetByMac(_ context.Context, mac net.HardwareAddr) (*dhcpdata.DHCP, *dhcpdata.Netboot, error) {
+	if f.netboot == nil {
+		return nil, nil, apierrors.NewNotFound(schema.GroupResource{}, mac.String())
+	}
+	return &dhcpdata.DHCP{MACAddress: mac}, f.netboot, nil
+}
+
+func (f fakeBackend) GetByIP(context.Context, net.IP) (*dhcpdata.DHCP, *dhcpdata.Netboot, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func TestFallbackScript(t *testing.T) {
+	fallback := `#!ipxe
+echo {{ .Reason }} failed for {{ .MAC }}
+shell
+`
+	tests := map[string]struct {
+		fallback string
+		netboot  *dhcpdata.Netboot
+		wantCode int
+		want     string
+	}{
+		"lookup failure": {
+			fallback: fallback,
+			wantCode: http.StatusOK,
+			want:     "#!ipxe\necho lookup failed for 00:01:02:03:04:05\nshell\n",
+		},
+		"render failure": {
+			fallback: fallback,
+			netboot:  &dhcpdata.Netboot{AllowNetboot: true, IPXEScriptURL: &url.URL{Scheme: "tftp", Host: "10.1.1.1"}},
+			wantCode: http.StatusOK,
+			want:     "#!ipxe\necho render failed for 00:01:02:03:04:05\nshell\n",
+		},
+		"not allowed to netboot": {
+			fallback: fallback,
+			netboot:  &dhcpdata.Netboot{AllowNetboot: false},
+			wantCode: http.StatusNotFound,
+		},
+		"lookup failure without fallback": {
+			wantCode: http.StatusNotFound,
+		},
+		"render failure without fallback": {
+			netboot:  &dhcpdata.Netboot{AllowNetboot: true, IPXEScriptURL: &url.URL{Scheme: "tftp", Host: "10.1.1.1"}},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Logger: logr.Discard(), Backend: fakeBackend{netboot: tt.netboot}, FallbackScript: tt.fallback}
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, httptest.NewRequest(http.MethodGet, "/00:01:02:03:04:05/auto.ipxe", nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			if diff := cmp.Diff(tt.want, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestKernelParamsTemplate(t *testing.T) {
+	t.Setenv("SMEE_TEST_IMAGE", "10.1.0.8/tink-worker:latest")
+	h := &Handler{
+		ExtraKernelParams: strings.Split(`tink_worker_image={{ env "SMEE_TEST_IMAGE" }} leader={{ (lookup "00:01:02:03:04:06").MAC }} vlan={{ default "none" .VLANID }}`, " "),
+		TemplateEnv:       []string{"SMEE_TEST_IMAGE"},
+		Backend:           fakeBackend{netboot: &dhcpdata.Netboot{}},
+	}
+	s, err := h.defaultScript(trace.SpanFromContext(context.Background()), data{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "tink_worker_image=10.1.0.8/tink-worker:latest leader=00:01:02:03:04:06 vlan=none"; !strings.Contains(s, want) {
+		t.Fatalf("expected the script to contain %q, got:\n%s", want, s)
+	}
+
+	h.TemplateEnv = nil
+	if _, err := h.defaultScript(trace.SpanFromContext(context.Background()), data{}); err == nil {
+		t.Fatal("expected an error for an environment variable that is not in the allowlist")
+	}
+}
+
+func TestValidateFallback(t *testing.T) {
+	if err := ValidateFallback("#!ipxe\nchain http://10.1.1.1/rescue.ipxe?mac={{ .MAC }}\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateFallback("#!ipxe\necho {{ .Nope }}\n", nil); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
+
+func TestRender(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		netboot *dhcpdata.Netboot
+		want    string
+		wantErr error
+	}{
+		"custom script":   {netboot: &dhcpdata.Netboot{AllowNetboot: true, IPXEScript: "exit"}, want: "#!ipxe\n\necho Loading custom Tinkerbell iPXE script...\nexit\n"},
+		"not allowed":     {netboot: &dhcpdata.Netboot{}, wantErr: ErrNetbootNotAllowed},
+		"unknown machine": {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Logger: logr.Discard(), Backend: fakeBackend{netboot: tt.netboot}}
+			got, err := h.Render(context.Background(), mac)
+			if tt.netboot == nil {
+				if !apierrors.IsNotFound(err) {
+					t.Fatalf("got %v, want a not found error", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestBoot(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	h := &Handler{
+		Logger:             logr.Discard(),
+		Backend:            fakeBackend{netboot: &dhcpdata.Netboot{AllowNetboot: true}},
+		OSIEURL:            "http://10.0.0.5/hook",
+		TinkServerGRPCAddr: "10.0.0.6:42113",
+		ExtraKernelParams:  []string{"console=ttyS0"},
+	}
+	got, err := h.Boot(context.Background(), mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.Script, "http://10.0.0.5/hook") {
+		t.Fatalf("expected the script to download Hook from the OSIE URL, got %s", got.Script)
+	}
+	got.Script = ""
+	want := Boot{OSIEURL: "http://10.0.0.5/hook", TinkServer: "10.0.0.6:42113", KernelParams: []string{"console=ttyS0"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestESXiKernelOpts(t *testing.T) {
+	pc, err := profile.Parse([]byte(`{profiles: [{name: esxi, esxi: true, kernelArgs: ["ks=http://10.1.0.5/ks/{{ .MAC }}.cfg"]}, {name: burnin, workflow: false}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		netboot *dhcpdata.Netboot
+		want    []string
+		wantErr error
+	}{
+		"esxi profile":        {netboot: &dhcpdata.Netboot{AllowNetboot: true, Profile: "esxi"}, want: []string{"ks=http://10.1.0.5/ks/00:01:02:03:04:05.cfg"}},
+		"not an esxi profile": {netboot: &dhcpdata.Netboot{AllowNetboot: true, Profile: "burnin"}, wantErr: errNotESXiProfile},
+		"netboot not allowed": {netboot: &dhcpdata.Netboot{Profile: "esxi"}, wantErr: errNetbootNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Logger: logr.Discard(), Backend: fakeBackend{netboot: tt.netboot}, Profiles: pc}
+			got, err := h.ESXiKernelOpts(context.Background(), net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p, err := policy.Parse([]byte(`
+rules:
+- name: deny-lab
+  match: {macs: ["00:01:02:*"]}
+  action: deny
+- name: chain-arm
+  match: {arch: [arm64]}
+  action: allow
+  bootTarget: http://10.1.1.1/arm64.ipxe
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		hw        data
+		wantAllow bool
+		wantChain string
+	}{
+		"denied":      {hw: data{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, AllowNetboot: true}},
+		"boot target": {hw: data{MACAddress: net.HardwareAddr{0x00, 0x05, 0x02, 0x03, 0x04, 0x05}, Arch: "arm64"}, wantAllow: true, wantChain: "http://10.1.1.1/arm64.ipxe"},
+		"no match":    {hw: data{MACAddress: net.HardwareAddr{0x00, 0x05, 0x02, 0x03, 0x04, 0x05}, AllowNetboot: true}, wantAllow: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Logger: logr.Discard(), Policy: p}
+			got := h.authorize(tt.hw)
+			if got.AllowNetboot != tt.wantAllow {
+				t.Fatalf("allow: got %v, want %v", got.AllowNetboot, tt.wantAllow)
+			}
+			var chain string
+			if got.IPXEScriptURL != nil {
+				chain = got.IPXEScriptURL.String()
+			}
+			if chain != tt.wantChain {
+				t.Fatalf("chain: got %q, want %q", chain, tt.wantChain)
+			}
+		})
+	}
+}
+
+func TestGRUBPath(t *testing.T) {
+	tests := map[string]struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		"http":          {in: "http://10.1.1.1/hook/", want: "(http,10.1.1.1)/hook"},
+		"http and port": {in: "http://10.1.1.1:8080/hook", want: "(http,10.1.1.1,8080)/hook"},
+		"tftp":          {in: "tftp://10.1.1.1", want: "(tftp,10.1.1.1)"},
+		"https":         {in: "https://10.1.1.1/hook", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := grubPath(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestGRUBScript(t *testing.T) {
+	want := `set timeout=0
+set default=0
+
+echo 'Loading the Tinkerbell Hook GRUB config...'
+
+menuentry 'Tinkerbell Hook' {
+	linux (http,127.1.1.1,8080)/hook/vmlinuz-x86_64 vlan_id=1234 facility=onprem syslog_host= grpc_authority= tinkerbell_tls=false tinkerbell_insecure_tls=false worker_id=00:01:02:03:04:05 hw_addr=00:01:02:03:04:05 modules=loop,squashfs,sd-mod,usb-storage intel_iommu=on iommu=pt console=tty0 console=ttyS1,115200
+	initrd (http,127.1.1.1,8080)/hook/initramfs-x86_64
+}
+`
+	h := &Handler{OSIEURL: "http://127.1.1.1:8080/hook"}
+	d := data{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, VLANID: "1234", Facility: "onprem", Arch: "x86_64"}
+	g := GRUB{Hook: h.hook(trace.SpanFromContext(context.Background()), d)}
+	var err error
+	if g.DownloadPath, err = grubPath(g.DownloadURL); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GenerateTemplate(g, GRUBScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestScriptFormat(t *testing.T) {
+	tests := map[string]struct {
+		userAgent string
+		path      string
+		wantCode  int
+		wantGRUB  bool
+	}{
+		"ipxe":             {userAgent: "iPXE/1.21.1", path: "/00:01:02:03:04:05/auto.ipxe", wantCode: http.StatusOK},
+		"grub":             {userAgent: "GRUB 2.06", path: "/00:01:02:03:04:05/auto.ipxe", wantCode: http.StatusOK, wantGRUB: true},
+		"curl":             {userAgent: "curl/8.5.0", path: "/00:01:02:03:04:05/auto.ipxe", wantCode: http.StatusOK},
+		"curl grub format": {userAgent: "curl/8.5.0", path: "/00:01:02:03:04:05/auto.ipxe?format=grub", wantCode: http.StatusOK, wantGRUB: true},
+		"grub ipxe format": {userAgent: "GRUB 2.06", path: "/00:01:02:03:04:05/auto.ipxe?format=ipxe", wantCode: http.StatusOK},
+		"grub unknown mac": {userAgent: "GRUB 2.06", path: "/auto.ipxe", wantCode: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Logger: logr.Discard(), Backend: fakeBackend{netboot: &dhcpdata.Netboot{AllowNetboot: true}}, OSIEURL: "http://127.1.1.1:8080/hook"}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			h.HandlerFunc()(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Fatalf("got Content-Type %q", got)
+			}
+			if got := strings.HasPrefix(w.Body.String(), "set timeout=0"); got != tt.wantGRUB {
+				t.Fatalf("got the GRUB config %v, want %v:\n%s", got, tt.wantGRUB, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPhoneHomeURL(t *testing.T) {
+	h := &Handler{
+		OSIEURL:      "http://127.1.1.1:8080/hook",
+		PhoneHomeURL: func(mac net.HardwareAddr) string { return "http://127.1.1.1/phone-home/" + mac.String() },
+	}
+	d := data{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, AllowNetboot: true}
+	got, err := h.defaultScript(trace.SpanFromContext(context.Background()), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, " phone_home_url=http://127.1.1.1/phone-home/00:01:02:03:04:05 ") {
+		t.Fatalf("expected the phone home URL in the kernel args of the script:\n%s", got)
+	}
+}
@@ -0,0 +1,152 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	dhcpdata "github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+)
+
+// FirstStageScript is the auto.ipxe script that is served when the Handler is TwoStage.
+// It chains, relative to its own URL, to the hook.ipxe second stage with the identity that iPXE reports for the machine.
+var FirstStageScript = `#!ipxe
+
+echo Loading the Tinkerbell iPXE script...
+chain --autofree ` + secondStage + "\n"
+
+// secondStage is the hook.ipxe URL, relative to the first stage, with the identity that iPXE reports for the machine.
+const secondStage = "hook.ipxe?mac=${mac}&uuid=${uuid:uristring}&serial=${serial:uristring}&hostname=${hostname:uristring}&arch=${buildarch}"
+
+// placeholderIdentities are the system UUIDs and serial numbers that firmware reports when they are not set,
+// they are not used for lookups.
+var placeholderIdentities = map[string]bool{
+	"00000000-0000-0000-0000-000000000000": true,
+	"ffffffff-ffff-ffff-ffff-ffffffffffff": true,
+	"0":                                    true,
+	"none":                                 true,
+	"not specified":                        true,
+	"not applicable":                       true,
+	"default string":                       true,
+	"system serial number":                 true,
+	"to be filled by o.e.m.":               true,
+}
+
+// identity returns v, or an empty string when v is a placeholder.
+func identity(v string) string {
+	v = strings.TrimSpace(v)
+	if placeholderIdentities[strings.ToLower(v)] {
+		return ""
+	}
+
+	return v
+}
+
+// buildArch maps the iPXE build architecture to the Hook architecture.
+var buildArch = map[string]string{
+	"x86_64": "x86_64",
+	"i386":   "x86_64",
+	"arm64":  "aarch64",
+}
+
+// serveFirstStage serves the FirstStageScript.
+func (h *Handler) serveFirstStage(w http.ResponseWriter, r *http.Request) {
+	if _, err := w.Write([]byte(FirstStageScript)); err != nil {
+		h.Logger.Error(err, "unable to send the first stage ipxe script", "client", r.RemoteAddr)
+		return
+	}
+	h.Logger.V(1).Info("served the first stage ipxe script", "client", r.RemoteAddr)
+}
+
+// serveIdentityScript serves the boot script of the machine of a hook.ipxe request, or of an auto.ipxe request with identity query parameters.
+// The machine is looked up by the uuid, serial and hostname query parameters, and then by the MAC addresses
+// and IP addresses of the client identification strategies, in order.
+func (h *Handler) serveIdentityScript(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	clients := h.identify(r)
+	var mac net.HardwareAddr
+	for _, c := range clients {
+		if c.mac != nil {
+			mac = c.mac
+			break
+		}
+	}
+	id := identities{uuid: identity(q.Get("uuid")), serial: identity(q.Get("serial")), hostname: strings.TrimSpace(q.Get("hostname"))}
+	hw, err := h.lookup(ctx, id, clients)
+	log := h.Logger.WithValues("client", r.RemoteAddr, "mac", mac, "uuid", q.Get("uuid"), "serial", q.Get("serial"), "hostname", q.Get("hostname"))
+	if err != nil && h.StaticIPXEEnabled {
+		log.Info("serving static ipxe script", "error", err)
+		h.serveStaticIPXEScript(w, mac)
+		return
+	}
+	if err != nil && h.serveFallbackScript(ctx, w, Fallback{MAC: mac.String(), IP: remoteIP(r.RemoteAddr), Reason: FallbackReasonLookup, Error: err.Error()}) {
+		return
+	}
+	if err == nil {
+		hw = h.authorize(hw)
+	}
+	if err != nil || !hw.AllowNetboot || !h.settings().MACAllowed(hw.MACAddress) {
+		w.WriteHeader(http.StatusNotFound)
+		log.Info("the hardware data for this machine, or lack there of, does not allow it to pxe", "error", err)
+
+		return
+	}
+	if hw.Arch == "" {
+		hw.Arch = buildArch[q.Get("arch")]
+	}
+	h.serveBootScript(ctx, w, path.Base(r.URL.Path), h.detectedConsole(q, hw))
+}
+
+// identities are the identities that iPXE reports for a machine.
+type identities struct {
+	uuid     string
+	serial   string
+	hostname string
+}
+
+// hasIdentity returns true when q has a uuid, serial or hostname query parameter that can be used for a lookup.
+func hasIdentity(q url.Values) bool {
+	return identity(q.Get("uuid")) != "" || identity(q.Get("serial")) != "" || strings.TrimSpace(q.Get("hostname")) != ""
+}
+
+// lookup returns the data of a machine by its system UUID, serial number or hostname, when the backend is a
+// handler.BackendIdentityReader, and then by the MAC address or IP address of each of the clients, in order.
+// The error of the last lookup is returned when none of them finds the machine.
+func (h *Handler) lookup(ctx context.Context, id identities, clients []client) (data, error) {
+	err := errors.New("no system uuid, serial number, hostname, mac address or ip address to lookup")
+	if ir, ok := h.Backend.(handler.BackendIdentityReader); ok {
+		for _, l := range []struct {
+			value string
+			get   func(context.Context, string) (*dhcpdata.DHCP, *dhcpdata.Netboot, error)
+		}{{id.uuid, ir.GetByUUID}, {id.serial, ir.GetBySerial}, {id.hostname, ir.GetByHostname}} {
+			if l.value == "" {
+				continue
+			}
+			d, n, ierr := l.get(ctx, l.value)
+			if ierr == nil {
+				return toData(d, n), nil
+			}
+			err = ierr
+		}
+	}
+	for _, c := range clients {
+		var hw data
+		var cerr error
+		if c.mac != nil {
+			hw, cerr = getByMac(ctx, c.mac, h.Backend)
+		} else {
+			hw, cerr = getByIP(ctx, c.ip, h.Backend)
+		}
+		if cerr == nil {
+			return hw, nil
+		}
+		err = cerr
+	}
+
+	return data{}, err
+}
@@ -0,0 +1,134 @@
+package script
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	dhcpdata "github.com/tinkerbell/smee/internal/dhcp/data"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeIdentityBackend struct {
+	fakeBackend
+	mac      net.HardwareAddr
+	uuid     string
+	serial   string
+	hostname string
+	got      []string
+}
+
+func (f *fakeIdentityBackend) get(want, value string) (*dhcpdata.DHCP, *dhcpdata.Netboot, error) {
+	f.got = append(f.got, value)
+	if value == want {
+		return &dhcpdata.DHCP{MACAddress: f.mac}, &dhcpdata.Netboot{AllowNetboot: true}, nil
+	}
+
+	return nil, nil, apierrors.NewNotFound(schema.GroupResource{}, value)
+}
+
+func (f *fakeIdentityBackend) GetByUUID(_ context.Context, uuid string) (*dhcpdata.DHCP, *dhcpdata.Netboot, error) {
+	return f.get(f.uuid, uuid)
+}
+
+func (f *fakeIdentityBackend) GetBySerial(_ context.Context, serial string) (*dhcpdata.DHCP, *dhcpdata.Netboot, error) {
+	return f.get(f.serial, serial)
+}
+
+func (f *fakeIdentityBackend) GetByHostname(_ context.Context, hostname string) (*dhcpdata.DHCP, *dhcpdata.Netboot, error) {
+	return f.get(f.hostname, hostname)
+}
+
+func TestFirstStage(t *testing.T) {
+	h := &Handler{Logger: logr.Discard(), TwoStage: true}
+	w := httptest.NewRecorder()
+	h.HandlerFunc()(w, httptest.NewRequest(http.MethodGet, "/00:01:02:03:04:05/auto.ipxe", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if diff := cmp.Diff(FirstStageScript, w.Body.String()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestSecondStage(t *testing.T) {
+	machine := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		path         string
+		wantStatus   int
+		wantIdentity []string
+		wantHWAddr   string
+	}{
+		"by uuid": {
+			path:         "/hook.ipxe?mac=0a:0b:0c:0d:0e:0f&uuid=4c4c4544-0051-3410-8058-b4c04f4a5032&serial=&arch=x86_64",
+			wantStatus:   http.StatusOK,
+			wantIdentity: []string{"4c4c4544-0051-3410-8058-b4c04f4a5032"},
+			wantHWAddr:   "hw_addr=00:01:02:03:04:05",
+		},
+		"by serial": {
+			path:         "/0a:0b:0c:0d:0e:0f/hook.ipxe?uuid=00000000-0000-0000-0000-000000000000&serial=CZ2D3S0ABC",
+			wantStatus:   http.StatusOK,
+			wantIdentity: []string{"CZ2D3S0ABC"},
+			wantHWAddr:   "hw_addr=00:01:02:03:04:05",
+		},
+		"by hostname": {
+			path:         "/hook.ipxe?uuid=4c4c4544-0000-0000-0000-000000000000&hostname=sm01",
+			wantStatus:   http.StatusOK,
+			wantIdentity: []string{"4c4c4544-0000-0000-0000-000000000000", "sm01"},
+			wantHWAddr:   "hw_addr=00:01:02:03:04:05",
+		},
+		"auto.ipxe by serial": {
+			path:         "/0a:0b:0c:0d:0e:0f/auto.ipxe?serial=CZ2D3S0ABC",
+			wantStatus:   http.StatusOK,
+			wantIdentity: []string{"CZ2D3S0ABC"},
+			wantHWAddr:   "hw_addr=00:01:02:03:04:05",
+		},
+		"placeholder identity": {
+			path:       "/hook.ipxe?mac=0a:0b:0c:0d:0e:0f&uuid=00000000-0000-0000-0000-000000000000&serial=To%20be%20filled%20by%20O.E.M.",
+			wantStatus: http.StatusNotFound,
+		},
+		"not found": {
+			path:         "/hook.ipxe?uuid=4c4c4544-0000-0000-0000-000000000000",
+			wantStatus:   http.StatusNotFound,
+			wantIdentity: []string{"4c4c4544-0000-0000-0000-000000000000"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			be := &fakeIdentityBackend{mac: machine, uuid: "4c4c4544-0051-3410-8058-b4c04f4a5032", serial: "CZ2D3S0ABC", hostname: "sm01"}
+			h := &Handler{Logger: logr.Discard(), Backend: be, OSIEURL: "http://127.0.0.1"}
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if diff := cmp.Diff(tt.wantIdentity, be.got); diff != "" {
+				t.Fatal(diff)
+			}
+			if tt.wantHWAddr != "" && !strings.Contains(w.Body.String(), tt.wantHWAddr) {
+				t.Fatalf("expected %q in the script, got:\n%s", tt.wantHWAddr, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	tests := map[string]string{
+		"4c4c4544-0051-3410-8058-b4c04f4a5032": "4c4c4544-0051-3410-8058-b4c04f4a5032",
+		" CZ2D3S0ABC ":                         "CZ2D3S0ABC",
+		"00000000-0000-0000-0000-000000000000": "",
+		"To be filled by O.E.M.":               "",
+		"":                                     "",
+	}
+	for in, want := range tests {
+		if got := identity(in); got != want {
+			t.Errorf("identity(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
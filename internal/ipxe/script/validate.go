@@ -0,0 +1,200 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxScriptSize is the default maximum size in bytes of the validated iPXE scripts. Some firmware and HTTP
+// stacks truncate larger scripts.
+const DefaultMaxScriptSize = 64 << 10
+
+// Reasons of a ValidationError.
+const (
+	InvalidSize     = "size"
+	InvalidHeader   = "header"
+	InvalidQuote    = "quote"
+	InvalidVariable = "variable"
+	InvalidCommand  = "command"
+	InvalidLabel    = "label"
+)
+
+// ValidationError is returned by ValidateScript for a script that iPXE can't run, or that can be truncated.
+type ValidationError struct {
+	// Reason is the rule that the script breaks, like InvalidCommand.
+	Reason string
+	// Line is the line of the script that breaks the rule, 0 when the rule applies to the whole script.
+	Line int
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("invalid ipxe script, line %d: %s", e.Line, e.Msg)
+	}
+
+	return "invalid ipxe script: " + e.Msg
+}
+
+// commands are the commands of iPXE, see https://ipxe.org/cmd.
+var commands = map[string]bool{
+	"autoboot": true, "boot": true, "certfree": true, "certstat": true, "certstore": true, "chain": true, "choose": true,
+	"clear": true, "colour": true, "config": true, "console": true, "cpair": true, "cpuid": true, "dhcp": true,
+	"echo": true, "exit": true, "fcels": true, "fcstat": true, "form": true, "goto": true, "help": true, "ibstat": true,
+	"ifclose": true, "ifconf": true, "ifopen": true, "ifstat": true, "imgargs": true, "imgdecrypt": true, "imgexec": true,
+	"imgextract": true, "imgfetch": true, "imgfree": true, "imgload": true, "imgmem": true, "imgselect": true,
+	"imgstat": true, "imgtrust": true, "imgverify": true, "inc": true, "initrd": true, "ipstat": true, "iseq": true,
+	"isset": true, "item": true, "kernel": true, "login": true, "lotest": true, "md5sum": true, "menu": true,
+	"module": true, "neighbour": true, "nslookup": true, "nstat": true, "ntp": true, "param": true, "params": true,
+	"pciscan": true, "ping": true, "poweroff": true, "present": true, "profstat": true, "prompt": true, "pxebs": true,
+	"read": true, "reboot": true, "route": true, "sanboot": true, "sanhook": true, "sanunhook": true, "set": true,
+	"sha1sum": true, "shell": true, "show": true, "sleep": true, "sync": true, "time": true, "vcreate": true,
+	"vdestroy": true,
+}
+
+// ValidateScript returns a ValidationError when script breaks a syntax rule of iPXE: it must start with #!ipxe, its
+// quotes and ${} variable expansions must be closed, its commands must be iPXE commands, and its labels must be
+// defined once and exist when they are the target of a goto. Commands and goto targets that are expanded from a
+// variable are not checked. When maxSize is greater than 0, the script must be at most maxSize bytes.
+func ValidateScript(script string, maxSize int) error {
+	if maxSize > 0 && len(script) > maxSize {
+		return &ValidationError{Reason: InvalidSize, Msg: fmt.Sprintf("%d bytes, more than the maximum of %d bytes", len(script), maxSize)}
+	}
+	lines := strings.Split(script, "\n")
+	if h := strings.TrimSpace(lines[0]); !strings.HasPrefix(h, "#!ipxe") && !strings.HasPrefix(h, "#!gpxe") {
+		return &ValidationError{Reason: InvalidHeader, Line: 1, Msg: "the script must start with #!ipxe"}
+	}
+	labels := map[string]bool{}
+	type jump struct {
+		label string
+		line  int
+	}
+	var gotos []jump
+	for i := 1; i < len(lines); i++ {
+		start := i + 1
+		// a line that ends with a backslash continues on the next line.
+		line := strings.TrimRight(lines[i], "\r")
+		for strings.HasSuffix(line, `\`) && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, `\`) + " " + strings.TrimRight(lines[i], "\r")
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, ":"):
+			l := strings.TrimSpace(line[1:])
+			if l == "" {
+				return &ValidationError{Reason: InvalidLabel, Line: start, Msg: "the label has no name"}
+			}
+			if labels[l] {
+				return &ValidationError{Reason: InvalidLabel, Line: start, Msg: fmt.Sprintf("the label %q is defined twice", l)}
+			}
+			labels[l] = true
+			continue
+		}
+		cmds, err := splitCommands(line)
+		if err != nil {
+			err.Line = start
+			return err
+		}
+		for _, args := range cmds {
+			if strings.Contains(args[0], "${") {
+				continue
+			}
+			if !commands[args[0]] {
+				return &ValidationError{Reason: InvalidCommand, Line: start, Msg: fmt.Sprintf("unknown command %q", args[0])}
+			}
+			if args[0] == "goto" && len(args) > 1 && !strings.Contains(args[1], "${") {
+				gotos = append(gotos, jump{label: args[1], line: start})
+			}
+		}
+	}
+	for _, g := range gotos {
+		if !labels[g.label] {
+			return &ValidationError{Reason: InvalidLabel, Line: g.line, Msg: fmt.Sprintf("goto %q, the label is not defined", g.label)}
+		}
+	}
+
+	return nil
+}
+
+// splitCommands splits a line of a script into its commands, separated by ;, && and ||, and the commands into their
+// words. The words keep their quotes and variable expansions.
+func splitCommands(line string) ([][]string, *ValidationError) {
+	var cmds [][]string
+	var args []string
+	var word strings.Builder
+	endWord := func() {
+		if word.Len() > 0 {
+			args = append(args, word.String())
+			word.Reset()
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(args) > 0 {
+			cmds = append(cmds, args)
+		}
+		args = nil
+	}
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			word.WriteByte(c)
+			word.WriteByte(line[i+1])
+			i++
+		case c == '$' && i+1 < len(line) && line[i+1] == '{':
+			end := closeVariable(line, i)
+			if end < 0 {
+				return nil, &ValidationError{Reason: InvalidVariable, Msg: fmt.Sprintf("the variable expansion %q is not closed", line[i:])}
+			}
+			word.WriteString(line[i : end+1])
+			i = end
+		case quote != 0:
+			word.WriteByte(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			word.WriteByte(c)
+			quote = c
+		case c == ' ' || c == '\t':
+			endWord()
+		case c == ';':
+			endCommand()
+		case (c == '&' || c == '|') && i+1 < len(line) && line[i+1] == c:
+			endCommand()
+			i++
+		default:
+			word.WriteByte(c)
+		}
+	}
+	if quote != 0 {
+		return nil, &ValidationError{Reason: InvalidQuote, Msg: fmt.Sprintf("the quote %c is not closed", quote)}
+	}
+	endCommand()
+
+	return cmds, nil
+}
+
+// closeVariable returns the index of the } that closes the ${ at start of line, -1 when it is not closed.
+func closeVariable(line string, start int) int {
+	depth := 0
+	for i := start; i < len(line); i++ {
+		switch {
+		case line[i] == '$' && i+1 < len(line) && line[i+1] == '{':
+			depth++
+			i++
+		case line[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,68 @@
+package script
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateScript(t *testing.T) {
+	tests := map[string]struct {
+		script  string
+		maxSize int
+		reason  string
+		line    int
+	}{
+		"valid":                 {script: "#!ipxe\n:start\necho \"hello ${mac}\" && goto start || exit\n"},
+		"continued line":        {script: "#!ipxe\nkernel ${base}/vmlinuz \\\n  console=ttyS0 && boot\n"},
+		"variable command":      {script: "#!ipxe\nchoose target && goto ${target}\n${cmd} arg\n"},
+		"too large":             {script: "#!ipxe\n" + strings.Repeat("echo padding\n", 10), maxSize: 64, reason: InvalidSize},
+		"no header":             {script: "echo hello\n", reason: InvalidHeader, line: 1},
+		"unclosed quote":        {script: "#!ipxe\n\necho \"hello\n", reason: InvalidQuote, line: 3},
+		"unclosed variable":     {script: "#!ipxe\nchain ${base/auto.ipxe\n", reason: InvalidVariable, line: 2},
+		"unknown command":       {script: "#!ipxe\nkernal vmlinuz\n", reason: InvalidCommand, line: 2},
+		"unknown command in or": {script: "#!ipxe\nboot || rebooot\n", reason: InvalidCommand, line: 2},
+		"undefined label":       {script: "#!ipxe\ngoto retry\n", reason: InvalidLabel, line: 2},
+		"duplicate label":       {script: "#!ipxe\n:retry\n:retry\n", reason: InvalidLabel, line: 3},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateScript(tt.script, tt.maxSize)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) || ve.Reason != tt.reason || ve.Line != tt.line {
+				t.Fatalf("got %v, want a %s error on line %d", err, tt.reason, tt.line)
+			}
+		})
+	}
+}
+
+func TestValidateBuiltinScripts(t *testing.T) {
+	hook := Hook{Arch: "x86_64", DownloadURL: "http://10.1.0.5", ExtraKernelParams: []string{"a=b"}, Retries: 1, RetryDelay: 2, ISOURL: "http://10.1.0.5/iso"}
+	scripts := map[string]struct {
+		data   any
+		script string
+	}{
+		"hook":        {data: hook, script: HookScript},
+		"static":      {data: hook, script: StaticScript},
+		"first stage": {script: FirstStageScript},
+		"custom":      {data: Custom{Chain: &url.URL{Scheme: "http", Host: "10.1.0.5", Path: "/boot.ipxe"}}, script: CustomScript},
+	}
+	for name, tt := range scripts {
+		t.Run(name, func(t *testing.T) {
+			s, err := GenerateTemplate(tt.data, tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ValidateScript(s, DefaultMaxScriptSize); err != nil {
+				t.Fatalf("%v:\n%s", err, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Package trust makes the iPXE binaries trust the CA of a deployment, so that they can download iPXE scripts and
+// ISOs over HTTPS from servers with certificates of an internal CA.
+//
+// iPXE validates the certificate chain of an HTTPS server against the SHA-256 fingerprints of its trusted root
+// certificates, that are built into the binaries. The embedded binaries trust the iPXE root CA only. Patch replaces
+// its fingerprint in a binary with the fingerprint of the CA of the deployment, without a rebuild of iPXE. iPXE
+// doesn't have the root certificates themselves, the chain that the server sends must hold the CA certificate, see
+// ServerCertificate.
+package trust
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// IPXERootCA is the SHA-256 fingerprint of the iPXE root CA certificate, the root certificate that iPXE binaries
+// trust unless they were built with another one.
+var IPXERootCA = [sha256.Size]byte{
+	0x9f, 0xaf, 0x71, 0x7b, 0x7f, 0x8c, 0xa2, 0xf9, 0x3c, 0x25, 0x6c, 0x79, 0xf8, 0xac, 0x55, 0x91,
+	0x89, 0x5d, 0x66, 0xd1, 0xff, 0x3b, 0xee, 0x63, 0x97, 0xa7, 0x0d, 0x29, 0xc6, 0x5e, 0xed, 0x1a,
+}
+
+// ErrNotPatchable is returned for a binary without the fingerprint of the iPXE root CA, like a compressed binary
+// such as undionly.kpxe, or a binary that was built to trust another CA.
+var ErrNotPatchable = errors.New("the binary has no iPXE root CA fingerprint to replace")
+
+// CA is the certificate of the CA that the patched iPXE binaries trust.
+type CA struct {
+	Cert *x509.Certificate
+	// Fingerprint is the SHA-256 fingerprint of Cert.
+	Fingerprint [sha256.Size]byte
+}
+
+// LoadCA reads the first certificate of a PEM file, it must be a CA certificate.
+func LoadCA(file string) (*CA, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate in %s", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in %s: %w", file, err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("the certificate in %s, %s, is not a CA certificate", file, cert.Subject)
+	}
+
+	return &CA{Cert: cert, Fingerprint: sha256.Sum256(cert.Raw)}, nil
+}
+
+// Patch returns a copy of the iPXE binary b that trusts c in place of the iPXE root CA. A nil CA returns b.
+func (c *CA) Patch(b []byte) ([]byte, error) {
+	if c == nil {
+		return b, nil
+	}
+	if !bytes.Contains(b, IPXERootCA[:]) {
+		return nil, ErrNotPatchable
+	}
+
+	return bytes.ReplaceAll(b, IPXERootCA[:], c.Fingerprint[:]), nil
+}
+
+// ServerCertificate loads the certificate chain and key of an HTTPS server, the chain must be issued by c. The
+// certificate of c is appended to the chain when it doesn't hold it, iPXE only validates chains that hold their
+// trusted root. A nil CA loads the chain as is.
+func (c *CA) ServerCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if c == nil {
+		return cert, nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(c.Cert)
+	for _, der := range cert.Certificate[1:] {
+		if ic, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(ic)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}); err != nil {
+		return tls.Certificate{}, fmt.Errorf("the certificate in %s is not issued by the iPXE CA %s: %w", certFile, c.Cert.Subject, err)
+	}
+	for _, der := range cert.Certificate {
+		if bytes.Equal(der, c.Cert.Raw) {
+			return cert, nil
+		}
+	}
+	cert.Certificate = append(cert.Certificate, c.Cert.Raw)
+
+	return cert, nil
+}
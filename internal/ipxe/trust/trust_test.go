@@ -0,0 +1,135 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/ipxedust/binary"
+)
+
+// issue writes a certificate issued by parent, self-signed when parent is nil, and its key to dir and returns the
+// certificate, its key and their paths.
+func issue(t *testing.T, dir, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{name},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key, certFile, keyFile
+}
+
+func TestLoadCA(t *testing.T) {
+	dir := t.TempDir()
+	_, _, caFile, _ := issue(t, dir, "ca", true, nil, nil)
+	_, _, leafFile, _ := issue(t, dir, "smee", false, nil, nil)
+	ca, err := LoadCA(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Cert.Subject.CommonName != "ca" {
+		t.Fatalf("got the certificate of %s, want the ca", ca.Cert.Subject)
+	}
+	if _, err := LoadCA(leafFile); err == nil {
+		t.Fatal("expected an error for a certificate that is not a CA")
+	}
+	if _, err := LoadCA(filepath.Join(dir, "ca.key")); err == nil {
+		t.Fatal("expected an error for a file without a certificate")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	dir := t.TempDir()
+	_, _, caFile, _ := issue(t, dir, "ca", true, nil, nil)
+	ca, err := LoadCA(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ipxe.efi", "snp.efi", "ipxe.iso"} {
+		b, err := ca.Patch(binary.Files[name])
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if bytes.Contains(b, IPXERootCA[:]) || !bytes.Contains(b, ca.Fingerprint[:]) || len(b) != len(binary.Files[name]) {
+			t.Fatalf("%s: expected the iPXE root CA fingerprint to be replaced", name)
+		}
+		if !bytes.Contains(binary.Files[name], IPXERootCA[:]) {
+			t.Fatalf("%s: expected the embedded binary not to be modified", name)
+		}
+	}
+	if _, err := ca.Patch(binary.Undionly); !errors.Is(err, ErrNotPatchable) {
+		t.Fatalf("got error %v for the compressed undionly.kpxe, want %v", err, ErrNotPatchable)
+	}
+	var none *CA
+	if b, err := none.Patch(binary.Undionly); err != nil || !bytes.Equal(b, binary.Undionly) {
+		t.Fatalf("expected a nil CA to return the binary, got %v", err)
+	}
+}
+
+func TestServerCertificate(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey, caFile, _ := issue(t, dir, "ca", true, nil, nil)
+	_, _, certFile, keyFile := issue(t, dir, "smee", false, caCert, caKey)
+	_, _, otherFile, otherKeyFile := issue(t, dir, "other", false, nil, nil)
+	ca, err := LoadCA(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.ServerCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 || !bytes.Equal(cert.Certificate[1], caCert.Raw) {
+		t.Fatalf("expected the CA certificate to be appended to the chain, got %d certificates", len(cert.Certificate))
+	}
+	if _, err := ca.ServerCertificate(otherFile, otherKeyFile); err == nil {
+		t.Fatal("expected an error for a certificate that is not issued by the CA")
+	}
+	var none *CA
+	if cert, err := none.ServerCertificate(otherFile, otherKeyFile); err != nil || len(cert.Certificate) != 1 {
+		t.Fatalf("expected a nil CA to load the chain as is, got %d certificates, %v", len(cert.Certificate), err)
+	}
+}
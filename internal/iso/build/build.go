@@ -0,0 +1,277 @@
+// Package build builds a minimal bootable ISO from a kernel and an initrd, for the OSIEs that have no ISO of their
+// own to patch, like HookOS has. The ISO boots UEFI machines: its El Torito boot image is an EFI system partition with
+// a Boot Loader Specification boot loader, like systemd-boot, the kernel, the initrd and a single boot loader entry.
+//
+// The kernel cmdline of the entry is a placeholder of CmdlineSize bytes, the ISO is built once and the cmdline of each
+// machine is set in place as the ISO is read, see ISO.Reader.
+package build
+
+import (
+	"bytes"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+const (
+	// CmdlineSize is the maximum size of the kernel cmdline, the COMMAND_LINE_SIZE of x86_64 and arm64 kernels.
+	CmdlineSize = 2048
+	// espImage is the name of the EFI system partition image, the El Torito boot image, in the ISO.
+	espImage = "efiboot.img"
+	// espSlack is the free space of the EFI system partition, for the file system structures.
+	espSlack = 8 << 20
+	// espMinSize is the minimum size of the EFI system partition, smaller FAT32 file systems of 512 bytes clusters have
+	// too few clusters to be detected as FAT32 by the firmware.
+	espMinSize = 40 << 20
+	// placeholderPrefix starts the cmdline placeholder, it is padded to CmdlineSize bytes.
+	placeholderPrefix = "smee-iso-build-cmdline"
+)
+
+// ErrCmdlineTooLong is returned by ISO.Reader for a cmdline larger than CmdlineSize.
+var ErrCmdlineTooLong = fmt.Errorf("the kernel cmdline is larger than %d bytes", CmdlineSize)
+
+// Config is the kernel, initrd and boot loader of an ISO.
+type Config struct {
+	// Kernel and Initrd are the paths of the kernel and the initrd of the OSIE.
+	Kernel string
+	Initrd string
+	// Loader is the path of the EFI binary of a boot loader that reads Boot Loader Specification entries from its own
+	// partition, like systemd-bootx64.efi. Its architecture, x86_64 or arm64, is the architecture of the ISO.
+	Loader string
+	// Dir is the directory the ISO is built in, the default is the directory for temporary files.
+	Dir string
+}
+
+// ISO is a built ISO, its kernel cmdline is set per machine by Reader.
+type ISO struct {
+	f       *os.File
+	size    int64
+	offset  int64
+	modTime time.Time
+}
+
+// Build builds the ISO of c. Close removes it.
+func Build(c Config) (*ISO, error) {
+	name, err := loaderName(c.Loader)
+	if err != nil {
+		return nil, err
+	}
+	work, err := os.MkdirTemp(c.Dir, "smee-iso-build")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	files := map[string]string{
+		"/EFI/BOOT/" + name: c.Loader,
+		"/vmlinuz":          c.Kernel,
+		"/initrd.img":       c.Initrd,
+	}
+	size := int64(espSlack)
+	for _, src := range files {
+		fi, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		size += fi.Size()
+	}
+	if err := writeESP(filepath.Join(work, espImage), max(size, espMinSize), files); err != nil {
+		return nil, fmt.Errorf("failed to create the EFI system partition: %w", err)
+	}
+
+	f, err := os.CreateTemp(c.Dir, "smee-*.iso")
+	if err != nil {
+		return nil, err
+	}
+	i, err := finalize(f, work)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// finalize writes the ISO of the work directory, with the EFI system partition image as its El Torito boot image, to f.
+func finalize(f *os.File, work string) (*ISO, error) {
+	fs, err := iso9660.Create(f, 0, 0, 2048, work)
+	if err != nil {
+		return nil, err
+	}
+	esp := &iso9660.ElToritoEntry{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/" + espImage}
+	if fi, err := os.Stat(filepath.Join(work, espImage)); err == nil && fi.Size()/512 > 0xffff {
+		// the sector count of the boot image doesn't fit, EDK2 based firmware reads a count of 1 as the rest of the disc.
+		esp.LoadSize = 1
+	}
+	err = fs.Finalize(iso9660.FinalizeOptions{
+		VolumeIdentifier: "SMEE",
+		ElTorito:         &iso9660.ElTorito{Platform: iso9660.EFI, Entries: []*iso9660.ElToritoEntry{esp}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the ISO: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset, err := find(f, fi.Size(), placeholder())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ISO{f: f, size: fi.Size(), offset: offset, modTime: fi.ModTime()}, nil
+}
+
+// writeESP writes a FAT32 EFI system partition image of size to path, with files, the paths in the partition of the
+// files to copy in it, and the loader configuration and entry.
+func writeESP(path string, size int64, files map[string]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	fs, err := fat32.Create(f, size, 0, 512, "SMEE")
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"/EFI/BOOT", "/loader/entries"} {
+		if err := fs.Mkdir(dir); err != nil {
+			return err
+		}
+	}
+	for dst, src := range files {
+		if err := copyFile(fs, dst, src); err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+	}
+	entry := "title Smee\nlinux /vmlinuz\ninitrd /initrd.img\noptions " + string(placeholder()) + "\n"
+	for name, content := range map[string]string{
+		"/loader/loader.conf":       "default smee.conf\ntimeout 0\n",
+		"/loader/entries/smee.conf": entry,
+	} {
+		if err := writeFile(fs, name, strings.NewReader(content)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(fs filesystem.FileSystem, dst, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFile(fs, dst, f)
+}
+
+func writeFile(fs filesystem.FileSystem, name string, r io.Reader) error {
+	w, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+
+	return err
+}
+
+// loaderName returns the name of the default boot loader of the removable media of the architecture of the EFI
+// binary at path, UEFI specification section 3.5.1.1.
+func loaderName(path string) (string, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("the boot loader %s is not an EFI binary: %w", path, err)
+	}
+	defer f.Close()
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "BOOTX64.EFI", nil
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "BOOTAA64.EFI", nil
+	}
+
+	return "", fmt.Errorf("the boot loader %s is of an unsupported architecture, machine type %#x", path, f.Machine)
+}
+
+// placeholder returns the cmdline placeholder of the boot loader entry.
+func placeholder() []byte {
+	return []byte(placeholderPrefix + strings.Repeat("x", CmdlineSize-len(placeholderPrefix)))
+}
+
+// find returns the offset of b in r, of size.
+func find(r io.ReaderAt, size int64, b []byte) (int64, error) {
+	const chunk = 1 << 20
+	// the chunks overlap by len(b), b is found when it spans two of them.
+	buf := make([]byte, chunk+len(b))
+	for off := int64(0); off < size; off += chunk {
+		n, err := r.ReadAt(buf, off)
+		if i := bytes.Index(buf[:n], b); i >= 0 {
+			return off + int64(i), nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+	}
+
+	return 0, errors.New("the cmdline placeholder is not contiguous in the ISO")
+}
+
+// Reader returns a reader of the ISO with the kernel cmdline set to cmdline.
+func (i *ISO) Reader(cmdline string) (io.ReadSeeker, error) {
+	if len(cmdline) > CmdlineSize {
+		return nil, ErrCmdlineTooLong
+	}
+	// the rest of the placeholder is padded with spaces, the kernel ignores them.
+	b := bytes.Repeat([]byte{' '}, CmdlineSize)
+	copy(b, cmdline)
+
+	return io.NewSectionReader(&overlay{r: i.f, b: b, off: i.offset}, 0, i.size), nil
+}
+
+// ModTime returns the time the ISO was built.
+func (i *ISO) ModTime() time.Time {
+	return i.modTime
+}
+
+// Size returns the size of the ISO.
+func (i *ISO) Size() int64 {
+	return i.size
+}
+
+// Close removes the ISO.
+func (i *ISO) Close() error {
+	return errors.Join(i.f.Close(), os.Remove(i.f.Name()))
+}
+
+// overlay reads r with b in place of its bytes at off.
+type overlay struct {
+	r   io.ReaderAt
+	b   []byte
+	off int64
+}
+
+func (o *overlay) ReadAt(p []byte, off int64) (int, error) {
+	n, err := o.r.ReadAt(p, off)
+	// the part of p that overlaps b.
+	start, end := max(off, o.off), min(off+int64(n), o.off+int64(len(o.b)))
+	if start < end {
+		copy(p[start-off:end-off], o.b[start-o.off:end-o.off])
+	}
+
+	return n, err
+}
@@ -0,0 +1,162 @@
+package build
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+// efiBinary returns a PE header of the machine type, enough to be read as an EFI binary.
+func efiBinary(machine uint16) []byte {
+	b := make([]byte, 0x40)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], 0x40)
+	b = append(b, "PE\x00\x00"...)
+
+	return binary.LittleEndian.AppendUint16(b, machine)
+}
+
+func writeFiles(t *testing.T, machine uint16) Config {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"loader.efi": append(efiBinary(machine), make([]byte, 32)...),
+		"vmlinuz":    bytes.Repeat([]byte("kernel"), 1000),
+		"initrd.img": bytes.Repeat([]byte("initrd"), 1000),
+	}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return Config{
+		Kernel: filepath.Join(dir, "vmlinuz"),
+		Initrd: filepath.Join(dir, "initrd.img"),
+		Loader: filepath.Join(dir, "loader.efi"),
+		Dir:    dir,
+	}
+}
+
+func TestBuild(t *testing.T) {
+	c := writeFiles(t, pe.IMAGE_FILE_MACHINE_AMD64)
+	i, err := Build(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	const cmdline = "console=ttyS0 worker_id=00:01:02:03:04:05"
+	r, err := i.Reader(cmdline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := filepath.Join(c.Dir, "patched.iso")
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(b)) != i.Size() {
+		t.Fatalf("read %d bytes, want the size of the ISO %d", len(b), i.Size())
+	}
+	if err := os.WriteFile(patched, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	esp := readESP(t, patched)
+	for name, want := range map[string]string{
+		"/EFI/BOOT/BOOTX64.EFI":     "MZ",
+		"/vmlinuz":                  "kernelkernel",
+		"/initrd.img":               "initrdinitrd",
+		"/loader/loader.conf":       "default smee.conf",
+		"/loader/entries/smee.conf": "linux /vmlinuz\ninitrd /initrd.img\noptions " + cmdline + "  ",
+	} {
+		f, err := esp.OpenFile(name, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.Contains(string(got), want) {
+			t.Errorf("%s: got %.80q, want it to contain %q", name, got, want)
+		}
+	}
+
+	if _, err := i.Reader(strings.Repeat("x", CmdlineSize+1)); !errors.Is(err, ErrCmdlineTooLong) {
+		t.Fatalf("got error %v, want %v", err, ErrCmdlineTooLong)
+	}
+	name := i.f.Name()
+	if err := i.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected the ISO to be removed, got %v", err)
+	}
+}
+
+// readESP returns the EFI system partition of the ISO at path.
+func readESP(t *testing.T, path string) *fat32.FileSystem {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := iso9660.Read(f, fi.Size(), 0, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without Rock Ridge, the names of the ISO are upper case.
+	img, err := fs.OpenFile("/"+strings.ToUpper(espImage), os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	espPath := filepath.Join(t.TempDir(), espImage)
+	if err := os.WriteFile(espPath, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ef, err := os.Open(espPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ef.Close() })
+	esp, err := fat32.Read(ef, int64(len(b)), 0, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return esp
+}
+
+func TestBuildLoader(t *testing.T) {
+	if _, err := Build(writeFiles(t, pe.IMAGE_FILE_MACHINE_I386)); err == nil || !strings.Contains(err.Error(), "unsupported architecture") {
+		t.Fatalf("expected an error for an i386 boot loader, got %v", err)
+	}
+	c := writeFiles(t, pe.IMAGE_FILE_MACHINE_ARM64)
+	if name, err := loaderName(c.Loader); err != nil || name != "BOOTAA64.EFI" {
+		t.Fatalf("got %q, %v, want BOOTAA64.EFI", name, err)
+	}
+	c.Loader = c.Kernel
+	if _, err := Build(c); err == nil || !strings.Contains(err.Error(), "not an EFI binary") {
+		t.Fatalf("expected an error for a boot loader that is not an EFI binary, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package iso
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/iso/build"
+)
+
+// serveBuilt serves the Built ISO, with the kernel cmdline of the machine. Range requests are served from the local
+// ISO, the ETag is derived from the build time of the ISO and the cmdline, so that a client can resume a download.
+func (h *Handler) serveBuilt(w http.ResponseWriter, req *http.Request) {
+	log := h.Logger.WithValues("method", req.Method, "urlPath", req.URL.Path, "remoteAddr", req.RemoteAddr)
+	ha, cmdline, status := h.lookup(log, req)
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	r, err := h.Built.Reader(cmdline)
+	if err != nil {
+		if errors.Is(err, build.ErrCmdlineTooLong) {
+			log.Info("not serving the ISO, the kernel cmdline is too long", "mac", ha, "length", len(cmdline), "max", build.CmdlineSize)
+		} else {
+			log.Error(err, "unable to read the built ISO", "mac", ha)
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if req.Method == http.MethodGet && len(h.Observers) > 0 && h.mounts.request(ha, time.Now()) {
+		for _, o := range h.Observers {
+			o.ISOServed(req.Context(), ha)
+		}
+	}
+	// like the patched ISO, the range requests of a mount are not logged by the logging middleware.
+	w.Header().Set("X-Global-Logging", "false")
+	w.Header().Set("ETag", patchedETag(h.Built.ModTime().UTC().Format("20060102150405"), patchTag([]byte(cmdline))))
+	http.ServeContent(w, req, path.Base(req.URL.Path), h.Built.ModTime(), r)
+}
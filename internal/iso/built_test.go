@@ -0,0 +1,103 @@
+package iso
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/iso/build"
+)
+
+func builtISO(t *testing.T) *build.ISO {
+	t.Helper()
+	dir := t.TempDir()
+	loader := make([]byte, 0x40)
+	copy(loader, "MZ")
+	binary.LittleEndian.PutUint32(loader[0x3c:], 0x40)
+	loader = binary.LittleEndian.AppendUint16(append(loader, "PE\x00\x00"...), pe.IMAGE_FILE_MACHINE_AMD64)
+	files := map[string][]byte{"loader.efi": append(loader, make([]byte, 32)...), "vmlinuz": []byte("kernel"), "initrd.img": []byte("initrd")}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	i, err := build.Build(build.Config{
+		Kernel: filepath.Join(dir, "vmlinuz"),
+		Initrd: filepath.Join(dir, "initrd.img"),
+		Loader: filepath.Join(dir, "loader.efi"),
+		Dir:    dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { i.Close() })
+
+	return i
+}
+
+func TestServeBuilt(t *testing.T) {
+	h := &Handler{
+		Backend: &mockBackend{},
+		Logger:  logr.Discard(),
+		Built:   builtISO(t),
+		Cmdline: "console=ttyS0 facility={{ .Facility }}",
+	}
+	hf, err := h.HandlerFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	hf(w, httptest.NewRequest(http.MethodGet, "/iso/de:ed:be:ef:fe:ed/hook.iso", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.Bytes()
+	if int64(len(body)) != h.Built.Size() {
+		t.Fatalf("got %d bytes, want the size of the ISO %d", len(body), h.Built.Size())
+	}
+	i := bytes.Index(body, []byte("options console=ttyS0 facility=test "))
+	if i < 0 {
+		t.Fatal("expected the templated cmdline in the boot loader entry")
+	}
+	etag := w.Header().Get("ETag")
+
+	// a resumed download of the same cmdline.
+	req := httptest.NewRequest(http.MethodGet, "/iso/de:ed:be:ef:fe:ed/hook.iso", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	req.Header.Set("If-Range", etag)
+	w = httptest.NewRecorder()
+	hf(w, req)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if got, _ := io.ReadAll(w.Body); !bytes.Equal(got, body[100:200]) {
+		t.Fatal("the range is not the range of the ISO")
+	}
+
+	for path, want := range map[string]int{
+		"/iso/de:ed:be:ef:fe:ed/hook.img": http.StatusNotFound,
+		"/iso/not-a-mac/hook.iso":         http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		hf(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: got status %d, want %d", path, w.Code, want)
+		}
+	}
+
+	h.Cmdline = strings.Repeat("x", build.CmdlineSize+1)
+	w = httptest.NewRecorder()
+	hf(w, httptest.NewRequest(http.MethodGet, "/iso/de:ed:be:ef:fe:ed/hook.iso", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d for a cmdline that is too long, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
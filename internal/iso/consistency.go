@@ -0,0 +1,189 @@
+package iso
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// errInconsistent is returned when the source ISO answers a request inconsistently, for example with a different
+// ETag for a sub-range or fewer bytes than its Content-Length.
+var errInconsistent = errors.New("inconsistent response from the source ISO")
+
+// patchTag returns the tag of a kernel args patch that is appended to the ETag of the source ISO,
+// the patched ISO of every machine has its own ETag.
+func patchTag(patch []byte) string {
+	s := sha256.Sum256(patch)
+
+	return hex.EncodeToString(s[:6])
+}
+
+// patchedETag returns the ETag of the source ISO, with the strong ETag etag, patched with the patch of tag.
+func patchedETag(etag, tag string) string {
+	return `"` + strings.Trim(etag, `"`) + "-p" + tag + `"`
+}
+
+// upstreamETags replaces the ETags of the patched ISO in the conditional headers of req with the ETags of the source ISO,
+// so that conditional and resumed range requests are checked by the source ISO. An ETag of a patch other than the
+// one of tag is replaced with an ETag that doesn't match, the patched ISO that the client has is not the current one.
+func upstreamETags(req *http.Request, tag string) {
+	for _, hdr := range []string{"If-Range", "If-Match", "If-None-Match"} {
+		v := req.Header.Get(hdr)
+		if v == "" || v == "*" {
+			continue
+		}
+		var etags []string
+		for _, e := range strings.Split(v, ",") {
+			e = strings.TrimSpace(e)
+			if !strings.HasPrefix(e, `"`) {
+				// a date in If-Range, or a weak ETag, that the source ISO can check itself.
+				etags = append(etags, e)
+				continue
+			}
+			up, t, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(e, `"`), `"`), "-p"+tag)
+			if !ok || up == "" || t != "" {
+				etags = append(etags, `"smee-stale-patch"`)
+				continue
+			}
+			etags = append(etags, `"`+up+`"`)
+		}
+		req.Header.Set(hdr, strings.Join(etags, ", "))
+	}
+}
+
+// verify checks that resp, of the source ISO for req, can be served consistently from any replica of Smee:
+// it must have a strong ETag and a Content-Length, and a partial response must be of the range that req asked for.
+// The body of resp is checked to have the length of its Content-Length, and its ETag is replaced by the patched ETag.
+func verify(req *http.Request, resp *http.Response, tag string) error {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return fmt.Errorf("%w: no strong ETag, got %q", errInconsistent, etag)
+	}
+	if resp.ContentLength < 0 {
+		return fmt.Errorf("%w: no Content-Length", errInconsistent)
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		start, end, err := contentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if end-start+1 != resp.ContentLength {
+			return fmt.Errorf("%w: Content-Range %q doesn't match Content-Length %d", errInconsistent, resp.Header.Get("Content-Range"), resp.ContentLength)
+		}
+		if want, ok := rangeStart(req.Header.Get("Range")); ok && want != start {
+			return fmt.Errorf("%w: got Content-Range %q for Range %q", errInconsistent, resp.Header.Get("Content-Range"), req.Header.Get("Range"))
+		}
+	}
+	resp.Header.Set("ETag", patchedETag(etag, tag))
+	if req.Method != http.MethodHead {
+		resp.Body = &lengthBody{ReadCloser: resp.Body, left: resp.ContentLength}
+	}
+
+	return nil
+}
+
+// contentRange returns the first and last byte of a Content-Range header, for example 0 and 99 from "bytes 0-99/1234".
+func contentRange(cr string) (start, end int64, err error) {
+	spec, ok := strings.CutPrefix(cr, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: invalid Content-Range %q", errInconsistent, cr)
+	}
+	spec, _, _ = strings.Cut(spec, "/")
+	s, e, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: invalid Content-Range %q", errInconsistent, cr)
+	}
+	if start, err = strconv.ParseInt(s, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid Content-Range %q", errInconsistent, cr)
+	}
+	if end, err = strconv.ParseInt(e, 10, 64); err != nil || end < start {
+		return 0, 0, fmt.Errorf("%w: invalid Content-Range %q", errInconsistent, cr)
+	}
+
+	return start, end, nil
+}
+
+// rangeStart returns the first byte of a single range Range header that starts at a byte, for example 100 from "bytes=100-".
+func rangeStart(rng string) (int64, bool) {
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, false
+	}
+	s, _, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok || s == "" {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(s, 10, 64)
+
+	return start, err == nil
+}
+
+// lengthBody returns an error when the body ends before, or goes on after, its Content-Length, so that a truncated
+// response is not passed on as a complete one.
+type lengthBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *lengthBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	switch {
+	case b.left < 0:
+		return n, fmt.Errorf("%w: the body is longer than its Content-Length", errInconsistent)
+	case errors.Is(err, io.EOF) && b.left > 0:
+		return n, fmt.Errorf("%w: the body ended %d bytes before its Content-Length", errInconsistent, b.left)
+	}
+
+	return n, err
+}
+
+// replica returns the replica that the ISO of the machine with mac is served by, the one with the highest
+// rendezvous hash of mac and its URL, so that every replica sends a machine to the same one.
+func replica(replicas []*url.URL, mac string) *url.URL {
+	var best *url.URL
+	var bestScore uint64
+	for _, r := range replicas {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(mac + "|" + r.String()))
+		if s := h.Sum64(); best == nil || s > bestScore {
+			best, bestScore = r, s
+		}
+	}
+
+	return best
+}
+
+// redirectToReplica returns a handler that redirects the requests for the ISO of a machine, with a 307 Temporary Redirect,
+// to the replica that serves it, and passes the requests that this replica serves to next.
+func (h *Handler) redirectToReplica(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ha, err := getMAC(req.URL.Path)
+		if err != nil {
+			next(w, req)
+			return
+		}
+		r := replica(h.Replicas, ha.String())
+		if r == nil || r.String() == h.Self.String() {
+			next(w, req)
+			return
+		}
+		loc := *r
+		loc.Path = path.Join("/", r.Path, req.URL.Path)
+		loc.RawPath = ""
+		loc.RawQuery = req.URL.RawQuery
+		h.Logger.V(1).Info("redirecting the ISO request to its replica", "urlPath", req.URL.Path, "replica", r.Redacted())
+		http.Redirect(w, req, loc.String(), http.StatusTemporaryRedirect)
+	}
+}
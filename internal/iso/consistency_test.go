@@ -0,0 +1,222 @@
+package iso
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestVerify(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	tests := map[string]struct {
+		etag       string
+		rng        string
+		ifRange    func(etag string) string
+		wantStatus int
+	}{
+		"whole iso":      {etag: `"v1"`, wantStatus: http.StatusOK},
+		"resumed":        {etag: `"v1"`, rng: "bytes=10-19", ifRange: func(etag string) string { return etag }, wantStatus: http.StatusPartialContent},
+		"stale patch":    {etag: `"v1"`, rng: "bytes=10-19", ifRange: func(string) string { return `"v1-p000000000000"` }, wantStatus: http.StatusOK},
+		"changed source": {etag: `"v1"`, rng: "bytes=10-19", ifRange: func(etag string) string { return strings.Replace(etag, "v1", "v0", 1) }, wantStatus: http.StatusOK},
+		"no etag":        {wantStatus: http.StatusBadGateway},
+		"weak etag":      {etag: `W/"v1"`, wantStatus: http.StatusBadGateway},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+				}
+				http.ServeContent(w, r, "hook.iso", time.Time{}, bytes.NewReader(content))
+			}))
+			defer hs.Close()
+
+			h := &Handler{Logger: logr.Discard(), Backend: &mockBackend{}, SourceISO: hs.URL + "/hook.iso", MagicString: magicString, Verify: true}
+			hf, err := h.HandlerFunc()
+			if err != nil {
+				t.Fatal(err)
+			}
+			get := func(rng, ifRange string) *http.Response {
+				req := httptest.NewRequest(http.MethodGet, "/iso/de:ed:be:ef:fe:ed/hook.iso", nil)
+				if rng != "" {
+					req.Header.Set("Range", rng)
+					req.Header.Set("If-Range", ifRange)
+				}
+				w := httptest.NewRecorder()
+				hf(w, req)
+				return w.Result()
+			}
+
+			resp := get("", "")
+			defer resp.Body.Close()
+			etag := resp.Header.Get("ETag")
+			if tt.ifRange != nil {
+				resp = get(tt.rng, tt.ifRange(etag))
+				defer resp.Body.Close()
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status code %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusBadGateway {
+				return
+			}
+			if !strings.HasPrefix(etag, `"v1-p`) {
+				t.Fatalf("got ETag %s, want the ETag of the source ISO and the patch", etag)
+			}
+			if got := resp.Header.Get("ETag"); got != etag {
+				t.Fatalf("got ETag %s, want %s", got, etag)
+			}
+		})
+	}
+}
+
+func TestUpstreamETags(t *testing.T) {
+	tag := patchTag([]byte("facility=test"))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Range", patchedETag(`"v1"`, tag))
+	req.Header.Set("If-None-Match", `"v1-pother", `+patchedETag(`"v2"`, tag)+`, W/"v3"`)
+	req.Header.Set("If-Match", "*")
+	upstreamETags(req, tag)
+
+	want := map[string]string{
+		"If-Range":      `"v1"`,
+		"If-None-Match": `"smee-stale-patch", "v2", W/"v3"`,
+		"If-Match":      "*",
+	}
+	for hdr, v := range want {
+		if got := req.Header.Get(hdr); got != v {
+			t.Errorf("got %s %s, want %s", hdr, got, v)
+		}
+	}
+}
+
+func TestVerifyContentRange(t *testing.T) {
+	tests := map[string]struct {
+		rng          string
+		contentRange string
+		length       int64
+		wantErr      bool
+	}{
+		"match":           {rng: "bytes=100-199", contentRange: "bytes 100-199/1000", length: 100},
+		"other range":     {rng: "bytes=100-199", contentRange: "bytes 0-99/1000", length: 100, wantErr: true},
+		"length mismatch": {rng: "bytes=100-199", contentRange: "bytes 100-199/1000", length: 50, wantErr: true},
+		"invalid":         {rng: "bytes=100-199", contentRange: "100-199", length: 100, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Range", tt.rng)
+			resp := &http.Response{
+				StatusCode:    http.StatusPartialContent,
+				Header:        http.Header{"Etag": []string{`"v1"`}, "Content-Range": []string{tt.contentRange}},
+				ContentLength: tt.length,
+				Body:          http.NoBody,
+			}
+			err := verify(req, resp, "tag")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errInconsistent) {
+				t.Fatalf("got error %v, want %v", err, errInconsistent)
+			}
+		})
+	}
+}
+
+func TestLengthBody(t *testing.T) {
+	tests := map[string]struct {
+		body    string
+		length  int64
+		wantErr bool
+	}{
+		"exact":     {body: "0123456789", length: 10},
+		"truncated": {body: "01234", length: 10, wantErr: true},
+		"longer":    {body: "0123456789ab", length: 10, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &lengthBody{ReadCloser: io.NopCloser(strings.NewReader(tt.body)), left: tt.length}
+			_, err := io.ReadAll(b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoundTripParallelETagChanged(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the source ISO changes after the first sub-range fetch.
+		if requests.Add(1) == 1 {
+			w.Header().Set("ETag", `"v1"`)
+		} else {
+			w.Header().Set("ETag", `"v2"`)
+		}
+		http.ServeContent(w, r, "hook.iso", time.Time{}, bytes.NewReader(make([]byte, 5000)))
+	}))
+	defer srv.Close()
+
+	h := &Handler{Parallelism: 1, ChunkSize: 1000, Verify: true}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.roundTripParallel(http.DefaultTransport, req, chunk{start: 0, end: 4999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("expected an error reading the body")
+	}
+}
+
+func TestRedirectToReplica(t *testing.T) {
+	var replicas []*url.URL
+	for _, u := range []string{"http://smee-0:7171", "http://smee-1:7171", "http://smee-2:7171/base"} {
+		r, _ := url.Parse(u)
+		replicas = append(replicas, r)
+	}
+	const mac = "de:ed:be:ef:fe:ed"
+	r := replica(replicas, mac)
+	if got := replica([]*url.URL{replicas[2], replicas[0], replicas[1]}, mac); got != r {
+		t.Fatalf("got replica %s for another order of the replicas, want %s", got, r)
+	}
+
+	for _, self := range replicas {
+		t.Run(self.String(), func(t *testing.T) {
+			var served bool
+			h := &Handler{Logger: logr.Discard(), Replicas: replicas, Self: self}
+			hf := h.redirectToReplica(func(http.ResponseWriter, *http.Request) { served = true })
+			w := httptest.NewRecorder()
+			hf(w, httptest.NewRequest(http.MethodGet, "/iso/"+mac+"/hook.iso?s=sig", nil))
+
+			if self == r {
+				if !served {
+					t.Fatal("expected the replica of the machine to serve the ISO")
+				}
+				return
+			}
+			if served {
+				t.Fatal("expected the ISO to be redirected to the replica of the machine")
+			}
+			if w.Code != http.StatusTemporaryRedirect {
+				t.Fatalf("got status code %d, want %d", w.Code, http.StatusTemporaryRedirect)
+			}
+			want := r.JoinPath("/iso/"+mac+"/hook.iso").String() + "?s=sig"
+			if got := w.Header().Get("Location"); got != want {
+				t.Fatalf("got Location %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package iso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// DefaultIndexInterval is how often the release index is resolved when Index.Interval is not set.
+	DefaultIndexInterval = time.Hour
+	// DefaultPinIdle is how long a machine is pinned to a release after its last ISO request when Index.PinIdle is not set.
+	DefaultPinIdle = 10 * time.Minute
+	// LatestChannel selects the first release of the index that lists it, or the first release of the index when none does.
+	LatestChannel = "latest"
+)
+
+// maxIndexSize is the maximum size of a release index.
+const maxIndexSize = 4 << 20
+
+// Index resolves the source ISO from a release index, for example of HookOS releases, so that operators can track
+// releases without changing the Smee configuration. The release is pinned by Version, or by Channel, and the index is
+// resolved again every Interval.
+//
+// An ISO mount is thousands of range requests, a machine keeps the release it was first served until it has made no
+// ISO request for PinIdle, so that a new release never changes an ISO in the middle of a mount.
+//
+// The index is a JSON document that lists releases, newest first. A relative release URL is resolved against the index URL.
+//
+//	{
+//	  "releases": [
+//	    {"version": "v0.10.0", "channels": ["latest"], "url": "v0.10.0/hook-x86_64-efi-initrd.iso"},
+//	    {"version": "v0.9.0", "channels": ["stable"], "url": "https://example.com/hook/v0.9.0/hook-x86_64-efi-initrd.iso"}
+//	  ]
+//	}
+type Index struct {
+	// URL is the URL of the release index.
+	URL string
+	// Version, when set, pins the release with this version.
+	Version string
+	// Channel pins the first release that lists this channel, when Version is not set. The default is LatestChannel.
+	Channel string
+	// Interval is how often the index is resolved. The default is DefaultIndexInterval.
+	Interval time.Duration
+	// PinIdle is how long a machine keeps the release it was served after its last ISO request. The default is DefaultPinIdle.
+	PinIdle time.Duration
+	// Transport is used to get the index. The default is http.DefaultTransport.
+	Transport http.RoundTripper
+	Logger    logr.Logger
+
+	mu      sync.Mutex
+	current *url.URL
+	pins    map[string]pin
+	pruned  time.Time
+}
+
+type pin struct {
+	source   *url.URL
+	lastUsed time.Time
+}
+
+// IndexRelease is a release of an Index.
+type IndexRelease struct {
+	Version  string   `json:"version"`
+	Channels []string `json:"channels,omitempty"`
+	URL      string   `json:"url"`
+}
+
+type index struct {
+	Releases []IndexRelease `json:"releases"`
+}
+
+// Start resolves the index, and then resolves it again every Interval. Start is a blocking method. Use a context cancellation to exit.
+// When resolving fails the last resolved release is kept.
+func (i *Index) Start(ctx context.Context) error {
+	if i.Logger.GetSink() == nil {
+		i.Logger = logr.Discard()
+	}
+	interval := i.Interval
+	if interval <= 0 {
+		interval = DefaultIndexInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := i.Resolve(ctx); err != nil {
+			i.Logger.Error(err, "unable to resolve the ISO release index, keeping the last resolved release", "index", i.URL, "version", i.Version, "channel", i.channel())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// Resolve gets the index and pins the release of Version or Channel.
+func (i *Index) Resolve(ctx context.Context) error {
+	base, err := url.Parse(i.URL)
+	if err != nil {
+		return fmt.Errorf("invalid index URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, i.URL, nil)
+	if err != nil {
+		return err
+	}
+	transport := i.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return fmt.Errorf("getting the index: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting the index: unexpected status %s", resp.Status)
+	}
+	var idx index
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxIndexSize)).Decode(&idx); err != nil {
+		return fmt.Errorf("decoding the index: %w", err)
+	}
+	r, err := i.release(idx.Releases)
+	if err != nil {
+		return err
+	}
+	ref, err := url.Parse(r.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL of release %s: %w", r.Version, err)
+	}
+	source := base.ResolveReference(ref)
+	if source.Scheme != "http" && source.Scheme != "https" {
+		return fmt.Errorf("invalid URL scheme of release %s: %v", r.Version, source.Scheme)
+	}
+
+	i.mu.Lock()
+	changed := i.current == nil || i.current.String() != source.String()
+	i.current = source
+	i.mu.Unlock()
+	if changed {
+		i.Logger.Info("resolved the ISO release index", "index", i.URL, "release", r.Version, "sourceIso", source.Redacted())
+	}
+
+	return nil
+}
+
+// release returns the release of Version, or the first release of Channel.
+func (i *Index) release(releases []IndexRelease) (IndexRelease, error) {
+	if i.Version != "" {
+		for _, r := range releases {
+			if r.Version == i.Version {
+				return r, nil
+			}
+		}
+		return IndexRelease{}, fmt.Errorf("no release with version %q in the index", i.Version)
+	}
+	ch := i.channel()
+	for _, r := range releases {
+		if slices.Contains(r.Channels, ch) {
+			return r, nil
+		}
+	}
+	if ch == LatestChannel && len(releases) > 0 {
+		return releases[0], nil
+	}
+
+	return IndexRelease{}, fmt.Errorf("no release in channel %q in the index", ch)
+}
+
+func (i *Index) channel() string {
+	if i.Channel == "" {
+		return LatestChannel
+	}
+
+	return i.Channel
+}
+
+// Source returns the source ISO URL of a machine, the release it is pinned to or the resolved release.
+func (i *Index) Source(mac net.HardwareAddr) (*url.URL, error) {
+	now := time.Now()
+	idle := i.PinIdle
+	if idle <= 0 {
+		idle = DefaultPinIdle
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.pins == nil {
+		i.pins = map[string]pin{}
+	}
+	if now.Sub(i.pruned) > idle {
+		for k, p := range i.pins {
+			if now.Sub(p.lastUsed) > idle {
+				delete(i.pins, k)
+			}
+		}
+		i.pruned = now
+	}
+	p, ok := i.pins[mac.String()]
+	if !ok || now.Sub(p.lastUsed) > idle {
+		if i.current == nil {
+			return nil, errors.New("the ISO release index has not been resolved")
+		}
+		p.source = i.current
+	}
+	p.lastUsed = now
+	i.pins[mac.String()] = p
+
+	// a copy is returned, the caller may modify it.
+	u := *p.source
+
+	return &u, nil
+}
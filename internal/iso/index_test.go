@@ -0,0 +1,122 @@
+package iso
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestIndexResolve(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"releases": [
+			{"version": "v0.10.0", "url": "v0.10.0/hook.iso"},
+			{"version": "v0.9.0", "channels": ["stable"], "url": "https://example.com/v0.9.0/hook.iso"},
+			{"version": "v0.8.0", "channels": ["stable"], "url": "ftp://example.com/v0.8.0/hook.iso"}
+		]}`)
+	}))
+	defer hs.Close()
+	tests := map[string]struct {
+		version string
+		channel string
+		want    string
+		wantErr string
+	}{
+		"latest":          {want: hs.URL + "/releases/v0.10.0/hook.iso"},
+		"channel":         {channel: "stable", want: "https://example.com/v0.9.0/hook.iso"},
+		"version":         {version: "v0.9.0", channel: "unknown", want: "https://example.com/v0.9.0/hook.iso"},
+		"unknown version": {version: "v1.0.0", wantErr: "no release with version"},
+		"unknown channel": {channel: "beta", wantErr: "no release in channel"},
+		"invalid scheme":  {version: "v0.8.0", wantErr: "invalid URL scheme"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			idx := &Index{URL: hs.URL + "/releases/index.json", Version: tt.version, Channel: tt.channel}
+			err := idx.Resolve(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := idx.Source(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("got source %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexPinning(t *testing.T) {
+	var version atomic.Value
+	version.Store("v0.9.0")
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `{"releases": [{"version": %[1]q, "url": "%[1]s/hook.iso"}]}`, version.Load())
+	}))
+	defer hs.Close()
+	idx := &Index{URL: hs.URL + "/index.json", Logger: logr.Discard()}
+	if _, err := idx.Source(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}); err == nil {
+		t.Fatal("expected an error before the index is resolved")
+	}
+	if err := idx.Resolve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mounting := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	if _, err := idx.Source(mounting); err != nil {
+		t.Fatal(err)
+	}
+
+	version.Store("v0.10.0")
+	if err := idx.Resolve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := idx.Source(mounting)
+	if want := hs.URL + "/v0.9.0/hook.iso"; got.String() != want {
+		t.Fatalf("got source %q for a machine in the middle of a mount, want the pinned %q", got, want)
+	}
+	got, _ = idx.Source(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06})
+	if want := hs.URL + "/v0.10.0/hook.iso"; got.String() != want {
+		t.Fatalf("got source %q for a new machine, want %q", got, want)
+	}
+}
+
+func TestIndexHandler(t *testing.T) {
+	var gotPath string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.json" {
+			fmt.Fprint(w, `{"releases": [{"version": "v0.10.0", "url": "v0.10.0/hook.iso"}]}`)
+			return
+		}
+		gotPath = r.URL.Path
+	}))
+	defer hs.Close()
+	idx := &Index{URL: hs.URL + "/index.json"}
+	if err := idx.Resolve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{Logger: logr.Discard(), Backend: &mockBackend{}, Index: idx, MagicString: magicString}
+	hf, err := h.HandlerFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	hf.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/iso/de:ed:be:ef:fe:ed/hook.iso", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status code %d, want %d", w.Code, http.StatusOK)
+	}
+	if gotPath != "/v0.10.0/hook.iso" {
+		t.Fatalf("got source ISO path %q, want /v0.10.0/hook.iso", gotPath)
+	}
+}
@@ -14,12 +14,21 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 
 	"github.com/go-logr/logr"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/facility"
+	"github.com/tinkerbell/smee/internal/iso/build"
 	"github.com/tinkerbell/smee/internal/iso/internal"
+	"github.com/tinkerbell/smee/internal/limit"
+	"github.com/tinkerbell/smee/internal/otel"
+	"github.com/tinkerbell/smee/internal/policy"
+	"github.com/tinkerbell/smee/internal/settings"
+	"github.com/tinkerbell/smee/internal/tenant"
+	"github.com/tinkerbell/smee/internal/tmpl"
 )
 
 const (
@@ -51,18 +60,78 @@ type Handler struct {
 	TinkServerTLS      bool
 	TinkServerGRPCAddr string
 	StaticIPAMEnabled  bool
+	// Settings, when set, provides a runtime override for ExtraKernelParams.
+	Settings settings.Reader
+	// Policy, when set, decides whether a machine is allowed to be served the ISO.
+	Policy *policy.Policy
+	// Facilities, when set, overrides the extra kernel params, syslog host and Tink server of machines by facility.
+	Facilities *facility.Config
+	// Tenants, when set, overrides the Tink server of machines by their tenant, facility overrides take precedence.
+	// The Backend should be scoped to the tenants, see tenant.Config.Scope.
+	Tenants *tenant.Config
+	// BootTraces, when set, passes the traceparent of the last DHCP reply sent to a machine to Hook in the traceparent kernel arg.
+	BootTraces *otel.BootTraces
+	// Transport is used to get the source ISO. The default is http.DefaultTransport.
+	Transport http.RoundTripper
+	// Signer, when set, requires requests to use an unexpired URL signed for the MAC address in the URL path.
+	Signer *Signer
+	// BufferSize is the size of the buffers used to stream the ISO to clients. The default is DefaultBufferSize.
+	// Buffers are pooled and reused across requests.
+	BufferSize int
+	// Streams, when set, bounds the number of ISOs streamed concurrently. Requests over the limit are queued per MAC address
+	// and served in turn, those still queued after StreamWait get a 503 Service Unavailable.
+	Streams    *limit.FairQueue
+	StreamWait time.Duration
+	// Parallelism, when greater than 1, is the number of concurrent upstream sub-range fetches of a client range request
+	// larger than ChunkSize. The sub-ranges are reassembled in order, this better uses high latency links to the source ISO.
+	Parallelism int
+	// ChunkSize is the size of the upstream sub-range fetches. The default is DefaultChunkSize.
+	ChunkSize int64
+	// TemplateEnv is the allowlist of the environment variables that the env function of the extra kernel params template can read.
+	TemplateEnv []string
+	// Index, when set, resolves the source ISO of machines from a release index in place of SourceISO.
+	Index *Index
+	// Verify requires a strong ETag and a Content-Length from the source ISO, and checks that the partial responses
+	// are of the requested range, that bodies have the length of their Content-Length and that the sub-range fetches
+	// of a parallel range request have the ETag of the first one. Responses that fail the checks are not served.
+	// The ETag of the patched ISO is derived from the ETag of the source ISO and the patch, so that a client can
+	// resume a download, with If-Range, on any replica of Smee.
+	Verify bool
+	// Replicas, when set, are the base URLs of the replicas of Smee that serve the ISO, Self is the one of this replica.
+	// The requests for the ISO of a machine are redirected, with a 307 Temporary Redirect, to the replica that its
+	// MAC address hashes to, so that all of them are served by the same replica.
+	Replicas []*url.URL
+	Self     *url.URL
+	// Observers are notified when a machine starts to mount the ISO.
+	Observers []Observer
+	// Built, when set, is served in place of the source ISO, with the kernel cmdline of each machine set in its boot
+	// loader entry. See the build package.
+	Built *build.ISO
+	// Cmdline, when set, is the template of the kernel cmdline of machines, executed with their tmpl.Machine, in place of
+	// the kernel args of HookOS.
+	Cmdline string
 	// parsedURL derives a url.URL from the SourceISO field.
 	// It needed for validation of SourceISO and easier modification.
 	parsedURL       *url.URL
+	magicStr        []byte
 	magicStrPadding []byte
+	chunkPool       *bufferPool
+	mounts          mounts
 }
 
 // HandlerFunc returns a reverse proxy HTTP handler function that performs ISO patching.
 func (h *Handler) HandlerFunc() (http.HandlerFunc, error) {
+	if h.Built != nil {
+		return h.wrap(h.serveBuilt), nil
+	}
 	target, err := url.Parse(h.SourceISO)
 	if err != nil {
 		return nil, err
 	}
+	if h.Index != nil {
+		// the source ISO is set per request by RoundTrip.
+		target = &url.URL{}
+	}
 	h.parsedURL = target
 
 	proxy := internal.NewSingleHostReverseProxy(target)
@@ -70,10 +139,52 @@ func (h *Handler) HandlerFunc() (http.HandlerFunc, error) {
 	proxy.Transport = h
 	proxy.FlushInterval = -1
 	proxy.CopyBuffer = h
+	proxy.BufferPool = newBufferPool(h.bufferSize())
+	if h.Parallelism > 1 {
+		h.chunkPool = newBufferPool(int(h.chunkSize()))
+	}
 
+	h.magicStr = []byte(h.MagicString)
 	h.magicStrPadding = bytes.Repeat([]byte{' '}, len(h.MagicString))
 
-	return proxy.ServeHTTP, nil
+	return h.wrap(proxy.ServeHTTP), nil
+}
+
+// wrap returns hf with the stream limit and the redirects to the replicas.
+func (h *Handler) wrap(hf http.HandlerFunc) http.HandlerFunc {
+	if h.Streams != nil {
+		hf = h.limitStreams(hf)
+	}
+	if len(h.Replicas) > 0 {
+		hf = h.redirectToReplica(hf)
+	}
+
+	return hf
+}
+
+// limitStreams returns a handler that waits for a free stream before calling next.
+func (h *Handler) limitStreams(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := req.RemoteAddr
+		if ha, err := getMAC(req.URL.Path); err == nil {
+			key = ha.String()
+		}
+		ctx := req.Context()
+		if h.StreamWait > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.StreamWait)
+			defer cancel()
+		}
+		release, err := h.Streams.Acquire(ctx, key)
+		if err != nil {
+			h.Logger.Info("rejected ISO request, too many concurrent streams", "urlPath", req.URL.Path, "remoteAddr", req.RemoteAddr, "waiting", h.Streams.Waiting())
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		next(w, req)
+	}
 }
 
 // Copy implements the internal.CopyBuffer interface.
@@ -81,7 +192,7 @@ func (h *Handler) HandlerFunc() (http.HandlerFunc, error) {
 // in memory. This allows memory use to be constant regardless of the size of the response.
 func (h *Handler) Copy(ctx context.Context, dst io.Writer, src io.Reader, buf []byte) (int64, error) {
 	if len(buf) == 0 {
-		buf = make([]byte, 32*1024)
+		buf = make([]byte, h.bufferSize())
 	}
 	var written int64
 	for {
@@ -91,14 +202,11 @@ func (h *Handler) Copy(ctx context.Context, dst io.Writer, src io.Reader, buf []
 		}
 		if nr > 0 {
 			// This is the patching check and handling.
+			// buf is only used for this copy, so it is patched in place.
 			b := buf[:nr]
-			i := bytes.Index(b, []byte(h.MagicString))
-			if i != -1 {
-				dup := make([]byte, len(b))
-				copy(dup, b)
-				copy(dup[i:], h.magicStrPadding)
-				copy(dup[i:], internal.GetPatch(ctx))
-				b = dup
+			if i := bytes.Index(b, h.magicStr); i != -1 {
+				copy(b[i:], h.magicStrPadding)
+				copy(b[i:], internal.GetPatch(ctx))
 			}
 			nw, werr := dst.Write(b)
 			if nw > 0 {
@@ -120,6 +228,15 @@ func (h *Handler) Copy(ctx context.Context, dst io.Writer, src io.Reader, buf []
 	}
 }
 
+// bufferSize returns the size of the buffers used to stream the ISO.
+func (h *Handler) bufferSize() int {
+	if h.BufferSize > 0 {
+		return h.BufferSize
+	}
+
+	return DefaultBufferSize
+}
+
 // RoundTrip is a method on the Handler struct that implements the http.RoundTripper interface.
 // This method is called by the internal.NewSingleHostReverseProxy to handle the incoming request.
 // The method is responsible for validating the incoming request and getting the source ISO.
@@ -127,76 +244,81 @@ func (h *Handler) RoundTrip(req *http.Request) (*http.Response, error) {
 	log := h.Logger.WithValues("method", req.Method, "urlPath", req.URL.Path, "remoteAddr", req.RemoteAddr)
 	log.V(1).Info("starting the ISO patching HTTP handler")
 
-	if filepath.Ext(req.URL.Path) != ".iso" {
-		log.Info("extension not supported, only supported extension is '.iso'")
+	ha, patch, status := h.lookup(log, req)
+	if status != 0 {
 		return &http.Response{
-			Status:     fmt.Sprintf("%d %s", http.StatusNotFound, http.StatusText(http.StatusNotFound)),
-			StatusCode: http.StatusNotFound,
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode: status,
 			Body:       http.NoBody,
 			Request:    req,
 		}, nil
 	}
-
-	// The incoming request url is expected to have the mac address present.
-	// Fetch the mac and validate if there's a hardware object
-	// associated with the mac.
-	//
-	// We serve the iso only if this validation passes.
-	ha, err := getMAC(req.URL.Path)
-	if err != nil {
-		log.Info("unable to parse mac address in the URL path", "error", err)
-		return &http.Response{
-			Status:     fmt.Sprintf("%d %s", http.StatusBadRequest, http.StatusText(http.StatusBadRequest)),
-			StatusCode: http.StatusBadRequest,
-			Body:       http.NoBody,
-			Request:    req,
-		}, nil
+	if h.Signer != nil {
+		// the signature is not passed on to the source ISO.
+		req.URL.RawQuery = h.parsedURL.RawQuery
+	}
+	// The patch is added to the request context so that it can be used in the Copy method.
+	req = req.WithContext(internal.WithPatch(req.Context(), []byte(patch)))
+	tag := patchTag([]byte(patch))
+	if h.Verify {
+		upstreamETags(req, tag)
 	}
 
-	fac, dhcpData, err := h.getFacility(req.Context(), ha, h.Backend)
-	if err != nil {
-		log.Info("unable to get the hardware object", "error", err, "mac", ha)
-		if apierrors.IsNotFound(err) {
+	var err error
+	source := h.parsedURL
+	if h.Index != nil {
+		if source, err = h.Index.Source(ha); err != nil {
+			log.Info("unable to get the source ISO from the release index", "error", err, "mac", ha)
 			return &http.Response{
-				Status:     fmt.Sprintf("%d %s", http.StatusNotFound, http.StatusText(http.StatusNotFound)),
-				StatusCode: http.StatusNotFound,
+				Status:     fmt.Sprintf("%d %s", http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable)),
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     http.Header{"Retry-After": []string{"5"}},
 				Body:       http.NoBody,
 				Request:    req,
 			}, nil
 		}
-		return &http.Response{
-			Status:     fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)),
-			StatusCode: http.StatusInternalServerError,
-			Body:       http.NoBody,
-			Request:    req,
-		}, nil
-	}
-	// The hardware object doesn't contain a dedicated field for consoles right now and
-	// historically the facility is used as a way to define consoles on a per Hardware basis.
-	var consoles string
-	switch {
-	case fac != "" && strings.Contains(fac, "console="):
-		consoles = fmt.Sprintf("facility=%s", fac)
-	case fac != "":
-		consoles = fmt.Sprintf("facility=%s %s", fac, defaultConsoles)
-	default:
-		consoles = defaultConsoles
+		req.URL.Scheme, req.URL.Host, req.URL.RawQuery = source.Scheme, source.Host, source.RawQuery
+		req.Host = source.Host
 	}
-	// The patch is added to the request context so that it can be used in the Copy method.
-	req = req.WithContext(internal.WithPatch(req.Context(), []byte(h.constructPatch(consoles, ha.String(), dhcpData))))
 
 	// The internal.NewSingleHostReverseProxy takes the incoming request url and adds the path to the target (h.SourceISO).
 	// This function is more than a pass through proxy. The MAC address in the url path is required to do hardware lookups using the backend reader
 	// and is not used when making http calls to the target (h.SourceISO). All valid requests are passed through to the target.
-	req.URL.Path = h.parsedURL.Path
+	req.URL.Path, req.URL.RawPath = source.Path, source.RawPath
 
 	// RoundTripper needs a Transport to execute a HTTP transaction
 	// For our use case the default transport will suffice.
-	resp, err := http.DefaultTransport.RoundTrip(req)
+	transport := h.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	var resp *http.Response
+	if r, ok := h.parallelRange(req); ok {
+		resp, err = h.roundTripParallel(transport, req, r)
+	} else {
+		resp, err = transport.RoundTrip(req)
+	}
 	if err != nil {
-		log.Error(err, "issue getting the source ISO", "sourceIso", h.SourceISO)
+		log.Error(err, "issue getting the source ISO", "sourceIso", source.Redacted())
 		return nil, err
 	}
+	if h.Verify {
+		if err := verify(req, resp, tag); err != nil {
+			resp.Body.Close()
+			log.Info("not serving an inconsistent response of the source ISO", "error", err, "sourceIso", source.Redacted(), "status", resp.Status)
+			return &http.Response{
+				Status:     fmt.Sprintf("%d %s", http.StatusBadGateway, http.StatusText(http.StatusBadGateway)),
+				StatusCode: http.StatusBadGateway,
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		}
+	}
+	if len(h.Observers) > 0 && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent) && h.mounts.request(ha, time.Now()) {
+		for _, o := range h.Observers {
+			o.ISOServed(req.Context(), ha)
+		}
+	}
 	// by setting this header we are telling the logging middleware to not log its default log message.
 	// we do this because there are a lot of partial content requests and it allow this handler to take care of logging.
 	resp.Header.Set("X-Global-Logging", "false")
@@ -207,10 +329,10 @@ func (h *Handler) RoundTrip(req *http.Request) (*http.Response, error) {
 		// 0.002% gives us about 5 - 10, log messages per ISO mount.
 		// We're optimizing for showing "enough" log messages so that progress can be observed.
 		if p := randomPercentage(100000); p < 0.002 {
-			log.Info("206 status code response", "sourceIso", h.SourceISO, "status", resp.Status)
+			log.Info("206 status code response", "sourceIso", source.Redacted(), "status", resp.Status)
 		}
 	} else {
-		log.Info("response received", "sourceIso", h.SourceISO, "status", resp.Status)
+		log.Info("response received", "sourceIso", source.Redacted(), "status", resp.Status)
 	}
 
 	log.V(1).Info("roundtrip complete")
@@ -218,9 +340,94 @@ func (h *Handler) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
-func (h *Handler) constructPatch(console, mac string, d *data.DHCP) string {
-	syslogHost := fmt.Sprintf("syslog_host=%s", h.Syslog)
-	grpcAuthority := fmt.Sprintf("grpc_authority=%s", h.TinkServerGRPCAddr)
+// lookup validates the request of the ISO of a machine and returns its MAC address and its kernel args patch, or the
+// status code of the response when the request is not served.
+func (h *Handler) lookup(log logr.Logger, req *http.Request) (net.HardwareAddr, string, int) {
+	if filepath.Ext(req.URL.Path) != ".iso" {
+		log.Info("extension not supported, only supported extension is '.iso'")
+		return nil, "", http.StatusNotFound
+	}
+
+	// The incoming request url is expected to have the mac address present.
+	// Fetch the mac and validate if there's a hardware object
+	// associated with the mac.
+	//
+	// We serve the iso only if this validation passes.
+	ha, err := getMAC(req.URL.Path)
+	if err != nil {
+		log.Info("unable to parse mac address in the URL path", "error", err)
+		return nil, "", http.StatusBadRequest
+	}
+
+	if h.Signer != nil {
+		if err := h.Signer.Verify(ha, req.URL.Query(), time.Now()); err != nil {
+			log.Info("rejected ISO request without a valid signed URL", "error", err, "mac", ha)
+			return nil, "", http.StatusForbidden
+		}
+	}
+
+	dhcpData, netbootData, err := h.getHardware(req.Context(), ha, h.Backend)
+	if err != nil {
+		log.Info("unable to get the hardware object", "error", err, "mac", ha)
+		if apierrors.IsNotFound(err) {
+			return nil, "", http.StatusNotFound
+		}
+		return nil, "", http.StatusInternalServerError
+	}
+	if !h.authorize(log, dhcpData, netbootData) {
+		return nil, "", http.StatusNotFound
+	}
+	var patch string
+	if h.Cmdline != "" {
+		patch, err = tmpl.Execute("cmdline", h.Cmdline, tmpl.FromBackend(dhcpData, netbootData), tmpl.Funcs(h.templateOptions(req.Context())))
+	} else {
+		patch, err = h.constructPatch(req.Context(), consoles(netbootData), ha.String(), dhcpData, netbootData, h.Facilities.Get(netbootData.Facility))
+	}
+	if err != nil {
+		log.Error(err, "unable to construct the kernel args patch", "mac", ha)
+		return nil, "", http.StatusInternalServerError
+	}
+	if tp := h.bootTraceparent(ha); tp != "" {
+		patch += " traceparent=" + tp
+	}
+
+	return ha, patch, 0
+}
+
+// consoles returns the facility and console kernel args of a machine.
+// The console of the backend record replaces the default consoles. Historically the facility
+// is used as a way to define consoles on a per Hardware basis, so it is still honored.
+func consoles(n *data.Netboot) string {
+	fac := n.Facility
+	switch {
+	case n.Console != "" && fac != "":
+		return fmt.Sprintf("facility=%s %s", fac, strings.Join(n.ConsoleArgs(), " "))
+	case n.Console != "":
+		return strings.Join(n.ConsoleArgs(), " ")
+	case fac != "" && strings.Contains(fac, "console="):
+		return fmt.Sprintf("facility=%s", fac)
+	case fac != "":
+		return fmt.Sprintf("facility=%s %s", fac, defaultConsoles)
+	default:
+		return defaultConsoles
+	}
+}
+
+func (h *Handler) constructPatch(ctx context.Context, console, mac string, d *data.DHCP, n *data.Netboot, fo facility.Override) (string, error) {
+	syslog, tinkServer := h.Syslog, h.TinkServerGRPCAddr
+	if n != nil {
+		if t := h.Tenants.Get(n.Labels[tenant.Label]); t.TinkServer != "" {
+			tinkServer = t.TinkServer
+		}
+	}
+	if fo.SyslogIP != "" {
+		syslog = fo.SyslogIP
+	}
+	if fo.TinkServer != "" {
+		tinkServer = fo.TinkServer
+	}
+	syslogHost := fmt.Sprintf("syslog_host=%s", syslog)
+	grpcAuthority := fmt.Sprintf("grpc_authority=%s", tinkServer)
 	tinkerbellTLS := fmt.Sprintf("tinkerbell_tls=%v", h.TinkServerTLS)
 	workerID := fmt.Sprintf("worker_id=%s", mac)
 	vlanID := func() string {
@@ -230,12 +437,51 @@ func (h *Handler) constructPatch(console, mac string, d *data.DHCP) string {
 		return ""
 	}()
 	hwAddr := fmt.Sprintf("hw_addr=%s", mac)
-	all := []string{strings.Join(h.ExtraKernelParams, " "), console, vlanID, hwAddr, syslogHost, grpcAuthority, tinkerbellTLS, workerID}
+	extra := h.ExtraKernelParams
+	if h.Settings != nil {
+		if p := h.Settings.Get().ExtraKernelArgs; len(p) > 0 {
+			extra = p
+		}
+	}
+	if len(fo.ExtraKernelArgs) > 0 {
+		extra = fo.ExtraKernelArgs
+	}
+	extra, err := tmpl.KernelArgs(extra, tmpl.FromBackend(d, n), tmpl.Funcs(h.templateOptions(ctx)))
+	if err != nil {
+		return "", err
+	}
+	all := []string{strings.Join(extra, " "), console, vlanID, hwAddr, syslogHost, grpcAuthority, tinkerbellTLS, workerID}
 	if h.StaticIPAMEnabled {
 		all = append(all, parseIPAM(d))
 	}
 
-	return strings.Join(all, " ")
+	return strings.Join(all, " "), nil
+}
+
+// templateOptions returns the options of the functions of the extra kernel params template, lookups use the backend.
+func (h *Handler) templateOptions(ctx context.Context) tmpl.Options {
+	return tmpl.Options{
+		Env: h.TemplateEnv,
+		Lookup: func(mac net.HardwareAddr) (tmpl.Machine, error) {
+			d, n, err := h.getHardware(ctx, mac, h.Backend)
+			if err != nil {
+				return tmpl.Machine{}, err
+			}
+
+			return tmpl.FromBackend(d, n), nil
+		},
+	}
+}
+
+// bootTraceparent returns the traceparent of the last DHCP reply sent to mac, it is empty when there is none.
+// The DHCP span is used as is, all the range requests of an ISO mount get the same traceparent.
+func (h *Handler) bootTraceparent(mac net.HardwareAddr) string {
+	ctx, ok := h.BootTraces.Context(context.Background(), mac)
+	if !ok {
+		return ""
+	}
+
+	return otel.TraceparentStringFromContext(ctx)
 }
 
 func getMAC(urlPath string) (net.HardwareAddr, error) {
@@ -248,17 +494,33 @@ func getMAC(urlPath string) (net.HardwareAddr, error) {
 	return hw, nil
 }
 
-func (h *Handler) getFacility(ctx context.Context, mac net.HardwareAddr, br BackendReader) (string, *data.DHCP, error) {
+func (h *Handler) getHardware(ctx context.Context, mac net.HardwareAddr, br BackendReader) (*data.DHCP, *data.Netboot, error) {
 	if br == nil {
-		return "", nil, errors.New("backend is nil")
+		return nil, nil, errors.New("backend is nil")
 	}
 
-	d, n, err := br.GetByMac(ctx, mac)
-	if err != nil {
-		return "", nil, err
+	return br.GetByMac(ctx, mac)
+}
+
+// authorize returns whether the netboot policy, if any, allows the machine to be served the ISO.
+// The ISO has never been gated by allowPXE so, when no rule matches, the machine is allowed.
+func (h *Handler) authorize(log logr.Logger, d *data.DHCP, n *data.Netboot) bool {
+	if h.Policy == nil {
+		return true
+	}
+	dec := h.Policy.Evaluate(policy.Request{
+		Source:       policy.SourceISO,
+		MAC:          d.MACAddress,
+		Arch:         d.Arch,
+		IP:           d.IPAddress,
+		Labels:       n.Labels,
+		AllowNetboot: true,
+	})
+	if dec.Rule != "" {
+		log.Info("netboot policy rule matched", "mac", d.MACAddress, "rule", dec.Rule, "allow", dec.Allow)
 	}
 
-	return n.Facility, d, nil
+	return dec.Allow
 }
 
 func randomPercentage(precision int64) float64 {
@@ -8,9 +8,12 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	diskfs "github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/disk"
@@ -19,6 +22,12 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/facility"
+	"github.com/tinkerbell/smee/internal/limit"
+	"github.com/tinkerbell/smee/internal/otel"
+	gotel "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const magicString = `464vn90e7rbj08xbwdjejmdf4it17c5zfzjyfhthbh19eij201hjgit021bmpdb9ctrc87x2ymc8e7icu4ffi15x1hah9iyaiz38ckyap8hwx2vt5rm44ixv4hau8iw718q5yd019um5dt2xpqqa2rjtdypzr5v1gun8un110hhwp8cex7pqrh2ivh0ynpm4zkkwc8wcn367zyethzy7q8hzudyeyzx3cgmxqbkh825gcak7kxzjbgjajwizryv7ec1xm2h0hh7pz29qmvtgfjj1vphpgq1zcbiiehv52wrjy9yq473d9t1rvryy6929nk435hfx55du3ih05kn5tju3vijreru1p6knc988d4gfdz28eragvryq5x8aibe5trxd0t6t7jwxkde34v6pj1khmp50k6qqj3nzgcfzabtgqkmeqhdedbvwf3byfdma4nkv3rcxugaj2d0ru30pa2fqadjqrtjnv8bu52xzxv7irbhyvygygxu1nt5z4fh9w1vwbdcmagep26d298zknykf2e88kumt59ab7nq79d8amnhhvbexgh48e8qc61vq2e9qkihzt1twk1ijfgw70nwizai15iqyted2dt9gfmf2gg7amzufre79hwqkddc1cd935ywacnkrnak6r7xzcz7zbmq3kt04u2hg1iuupid8rt4nyrju51e6uejb2ruu36g9aibmz3hnmvazptu8x5tyxk820g2cdpxjdij766bt2n3djur7v623a2v44juyfgz80ekgfb9hkibpxh3zgknw8a34t4jifhf116x15cei9hwch0fye3xyq0acuym8uhitu5evc4rag3ui0fny3qg4kju7zkfyy8hwh537urd5uixkzwu5bdvafz4jmv7imypj543xg5em8jk8cgk7c4504xdd5e4e71ihaumt6u5u2t1w7um92fepzae8p0vq93wdrd1756npu1pziiur1payc7kmdwyxg3hj5n4phxbc29x0tcddamjrwt260b0w`
@@ -175,6 +184,87 @@ menuentry 'LinuxKit ISO Image' {
 	}
 }
 
+func TestConstructPatchFacility(t *testing.T) {
+	h := &Handler{ExtraKernelParams: []string{"k=v"}, Syslog: "127.0.0.1", TinkServerGRPCAddr: "127.0.0.1:42113"}
+	fo := facility.Override{TinkServer: "10.1.0.6:42113", SyslogIP: "10.1.0.7", ExtraKernelArgs: []string{"a=b"}}
+
+	want := "a=b facility=sjc1  hw_addr=de:ed:be:ef:fe:ed syslog_host=10.1.0.7 grpc_authority=10.1.0.6:42113 tinkerbell_tls=false worker_id=de:ed:be:ef:fe:ed"
+	got, err := h.constructPatch(context.Background(), "facility=sjc1", "de:ed:be:ef:fe:ed", &data.DHCP{}, nil, fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+	want = "k=v facility=ams2  hw_addr=de:ed:be:ef:fe:ed syslog_host=127.0.0.1 grpc_authority=127.0.0.1:42113 tinkerbell_tls=false worker_id=de:ed:be:ef:fe:ed"
+	got, err = h.constructPatch(context.Background(), "facility=ams2", "de:ed:be:ef:fe:ed", &data.DHCP{}, nil, facility.Override{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestConsoles(t *testing.T) {
+	tests := map[string]struct {
+		netboot *data.Netboot
+		want    string
+	}{
+		"defaults":          {netboot: &data.Netboot{}, want: defaultConsoles},
+		"facility":          {netboot: &data.Netboot{Facility: "sjc1"}, want: "facility=sjc1 " + defaultConsoles},
+		"facility consoles": {netboot: &data.Netboot{Facility: "sjc1 console=ttyS0"}, want: "facility=sjc1 console=ttyS0"},
+		"console":           {netboot: &data.Netboot{Console: "tty0 ttyS1,115200n8"}, want: "console=tty0 console=ttyS1,115200n8"},
+		"facility and console": {
+			netboot: &data.Netboot{Facility: "sjc1", Console: "ttyS1,115200n8"},
+			want:    "facility=sjc1 console=ttyS1,115200n8",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, consoles(tt.netboot)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestConstructPatchTemplate(t *testing.T) {
+	h := &Handler{ExtraKernelParams: []string{`home={{ env "HOME" }}`}}
+	d := &data.DHCP{IPAddress: netip.MustParseAddr("10.1.0.5"), SubnetMask: net.CIDRMask(24, 32), DefaultGateway: netip.MustParseAddr("10.1.0.1")}
+	if _, err := h.constructPatch(context.Background(), "", "de:ed:be:ef:fe:ed", d, nil, facility.Override{}); err == nil {
+		t.Fatal("expected an error for an environment variable that is not in the allowlist")
+	}
+	h.ExtraKernelParams = strings.Split(`ip={{ .IP }}::{{ .Gateway }}:{{ cidrnetmask .Subnet }}`, " ")
+	got, err := h.constructPatch(context.Background(), "", "de:ed:be:ef:fe:ed", d, nil, facility.Override{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ip=10.1.0.5::10.1.0.1:255.255.255.0 "; !strings.HasPrefix(got, want) {
+		t.Fatalf("got patch %q, want prefix %q", got, want)
+	}
+}
+
+func TestBootTraceparent(t *testing.T) {
+	gotel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { gotel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator()) })
+	mac := net.HardwareAddr{0xde, 0xed, 0xbe, 0xef, 0xfe, 0xed}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x23, 0xb1, 0xe3, 0x07, 0xbb, 0x35, 0x48, 0x4f, 0x53, 0x5a, 0x1f, 0x77, 0x2c, 0x06, 0x91, 0x0e},
+		SpanID:     trace.SpanID{0xd8, 0x87, 0xdc, 0x39, 0x12, 0x24, 0x04, 0x34},
+		TraceFlags: trace.FlagsSampled,
+	})
+	h := &Handler{}
+	if got := h.bootTraceparent(mac); got != "" {
+		t.Fatalf("expected no traceparent without boot traces, got %q", got)
+	}
+	h.BootTraces = &otel.BootTraces{}
+	h.BootTraces.DHCPServed(trace.ContextWithSpanContext(context.Background(), sc), mac, "ACK")
+	if diff := cmp.Diff("00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", h.bootTraceparent(mac)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
 type mockBackend struct{}
 
 func (m *mockBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
@@ -192,3 +282,37 @@ func (m *mockBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboo
 	}
 	return d, n, nil
 }
+
+func TestLimitStreams(t *testing.T) {
+	h := &Handler{Streams: limit.NewFairQueue(1), StreamWait: 10 * time.Millisecond}
+	started := make(chan struct{})
+	done := make(chan struct{})
+	hf := h.limitStreams(func(http.ResponseWriter, *http.Request) {
+		close(started)
+		<-done
+	})
+	go hf(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/iso/de:ed:be:ef:fe:ed/output.iso", nil))
+	<-started
+
+	w := httptest.NewRecorder()
+	hf(w, httptest.NewRequest(http.MethodGet, "/iso/de:ed:be:ef:fe:ee/output.iso", nil))
+	close(done)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status code: %d, want status code: %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMounts(t *testing.T) {
+	mac := net.HardwareAddr{0xde, 0xed, 0xbe, 0xef, 0xfe, 0xed}
+	now := time.Now()
+	m := &mounts{}
+	if !m.request(mac, now) {
+		t.Fatal("expected the first request to start a mount")
+	}
+	if m.request(mac, now.Add(time.Minute)) {
+		t.Fatal("expected a request during the mount not to start a mount")
+	}
+	if !m.request(mac, now.Add(time.Minute+DefaultPinIdle+time.Second)) {
+		t.Fatal("expected a request after the mount was idle to start a mount")
+	}
+}
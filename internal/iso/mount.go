@@ -0,0 +1,43 @@
+package iso
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Observer is notified when a machine starts to mount the ISO.
+// ctx is the HTTP request context, implementations doing background work must not depend on it.
+type Observer interface {
+	ISOServed(ctx context.Context, mac net.HardwareAddr)
+}
+
+// mounts tracks the ISO mounts of machines. An ISO mount is thousands of range requests, a mount is the requests
+// of a machine until it makes no ISO request for DefaultPinIdle, like the release pins of an Index.
+type mounts struct {
+	mu     sync.Mutex
+	last   map[string]time.Time
+	pruned time.Time
+}
+
+// request records an ISO request of mac at now and returns whether it starts a new mount.
+func (m *mounts) request(mac net.HardwareAddr, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last == nil {
+		m.last = map[string]time.Time{}
+	}
+	if now.Sub(m.pruned) > DefaultPinIdle {
+		for k, t := range m.last {
+			if now.Sub(t) > DefaultPinIdle {
+				delete(m.last, k)
+			}
+		}
+		m.pruned = now
+	}
+	t, ok := m.last[mac.String()]
+	m.last[mac.String()] = now
+
+	return !ok || now.Sub(t) > DefaultPinIdle
+}
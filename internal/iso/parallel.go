@@ -0,0 +1,257 @@
+package iso
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultChunkSize is the size of the upstream sub-range fetches when Handler.ChunkSize is not set.
+const DefaultChunkSize = 4 * 1024 * 1024
+
+// chunk is an inclusive byte range of the source ISO.
+type chunk struct {
+	start, end int64
+}
+
+func (c chunk) len() int64 {
+	return c.end - c.start + 1
+}
+
+func (c chunk) header() string {
+	return "bytes=" + strconv.FormatInt(c.start, 10) + "-" + strconv.FormatInt(c.end, 10)
+}
+
+// chunkResult is a fetched chunk, buf is returned to the pool once data is read.
+type chunkResult struct {
+	buf  []byte
+	data []byte
+	err  error
+}
+
+func (h *Handler) chunkSize() int64 {
+	if h.ChunkSize > 0 {
+		return h.ChunkSize
+	}
+
+	return DefaultChunkSize
+}
+
+// parallelRange returns the range of req when it is to be fetched in parallel sub-ranges.
+// Only GET requests for a single, closed range larger than a chunk are.
+func (h *Handler) parallelRange(req *http.Request) (chunk, bool) {
+	if h.Parallelism <= 1 || req.Method != http.MethodGet {
+		return chunk{}, false
+	}
+	spec, ok := strings.CutPrefix(req.Header.Get("Range"), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return chunk{}, false
+	}
+	s, e, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return chunk{}, false
+	}
+	start, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || start < 0 {
+		return chunk{}, false
+	}
+	end, err := strconv.ParseInt(e, 10, 64)
+	if err != nil || end < start {
+		return chunk{}, false
+	}
+	c := chunk{start: start, end: end}
+	if c.len() <= h.chunkSize() {
+		return chunk{}, false
+	}
+
+	return c, true
+}
+
+// roundTripParallel gets the range r of the source ISO as parallel sub-range fetches of at most ChunkSize bytes,
+// with at most Parallelism fetches in flight. The response body reassembles the chunks in order.
+// The first chunk is streamed, the others are buffered, so memory use per request is bounded by Parallelism * ChunkSize.
+func (h *Handler) roundTripParallel(transport http.RoundTripper, req *http.Request, r chunk) (*http.Response, error) {
+	size := h.chunkSize()
+	first := chunk{start: r.start, end: r.start + size - 1}
+	freq := req.Clone(req.Context())
+	freq.Header.Set("Range", first.header())
+	resp, err := transport.RoundTrip(freq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// the source doesn't serve ranges, or the range is not satisfiable, pass the response on as is.
+		return resp, nil
+	}
+	total, err := contentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if r.end >= total {
+		r.end = total - 1
+	}
+	if first.end >= r.end {
+		// the rest of the range is past the end of the ISO, the first chunk is all of it.
+		return resp, nil
+	}
+
+	var chunks []chunk
+	for s := first.end + 1; s <= r.end; s += size {
+		chunks = append(chunks, chunk{start: s, end: min(s+size-1, r.end)})
+	}
+	pool := h.chunkPool
+	if pool == nil {
+		pool = newBufferPool(int(size))
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	body := &orderedBody{
+		cur:    resp.Body,
+		first:  resp.Body,
+		order:  make(chan chan chunkResult, h.Parallelism),
+		slots:  make(chan struct{}, h.Parallelism),
+		pool:   pool,
+		cancel: cancel,
+	}
+	if h.Verify {
+		body.ifMatch = resp.Header.Get("ETag")
+	}
+	go body.fetch(ctx, transport, req, chunks)
+
+	resp.Header.Set("Content-Range", "bytes "+strconv.FormatInt(r.start, 10)+"-"+strconv.FormatInt(r.end, 10)+"/"+strconv.FormatInt(total, 10))
+	resp.Header.Set("Content-Length", strconv.FormatInt(r.len(), 10))
+	resp.ContentLength = r.len()
+	resp.Body = body
+
+	return resp, nil
+}
+
+// orderedBody reassembles the chunks of a parallel range fetch in order.
+type orderedBody struct {
+	cur   io.Reader
+	first io.ReadCloser
+	// order holds the results of the chunks in order, slots bounds the chunks that are fetched or buffered.
+	order  chan chan chunkResult
+	slots  chan struct{}
+	pool   *bufferPool
+	cancel context.CancelFunc
+	// held is the chunk currently being read, its slot and buffer are released once it is read.
+	held *chunkResult
+	// ifMatch, when set, is the ETag of the first chunk. The other chunks must have it and their Content-Range must be
+	// of their range, so that a range is not reassembled from different versions of the source ISO.
+	ifMatch string
+}
+
+// fetch starts the fetches of chunks, in order, as slots free up.
+func (b *orderedBody) fetch(ctx context.Context, transport http.RoundTripper, req *http.Request, chunks []chunk) {
+	defer close(b.order)
+	for _, c := range chunks {
+		select {
+		case b.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		res := make(chan chunkResult, 1)
+		go func() {
+			res <- b.get(ctx, transport, req, c)
+		}()
+		select {
+		case b.order <- res:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// get fetches the chunk c into a pooled buffer.
+func (b *orderedBody) get(ctx context.Context, transport http.RoundTripper, req *http.Request, c chunk) chunkResult {
+	creq := req.Clone(ctx)
+	creq.Header.Set("Range", c.header())
+	if b.ifMatch != "" {
+		creq.Header.Del("If-Range")
+		creq.Header.Set("If-Match", b.ifMatch)
+	}
+	resp, err := transport.RoundTrip(creq)
+	if err != nil {
+		return chunkResult{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return chunkResult{err: fmt.Errorf("unexpected status code %d getting the source ISO range %s", resp.StatusCode, c.header())}
+	}
+	if b.ifMatch != "" {
+		if etag := resp.Header.Get("ETag"); etag != b.ifMatch {
+			return chunkResult{err: fmt.Errorf("%w: got ETag %q for the source ISO range %s, want %q", errInconsistent, etag, c.header(), b.ifMatch)}
+		}
+		if start, end, err := contentRange(resp.Header.Get("Content-Range")); err != nil || start != c.start || end != c.end {
+			return chunkResult{err: fmt.Errorf("%w: got Content-Range %q for the source ISO range %s", errInconsistent, resp.Header.Get("Content-Range"), c.header())}
+		}
+	}
+	buf := b.pool.Get()
+	if _, err := io.ReadFull(resp.Body, buf[:c.len()]); err != nil {
+		b.pool.Put(buf)
+		return chunkResult{err: fmt.Errorf("getting the source ISO range %s: %w", c.header(), err)}
+	}
+
+	return chunkResult{buf: buf, data: buf[:c.len()]}
+}
+
+// Read implements io.Reader.
+func (b *orderedBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.cur.Read(p)
+		if !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		b.release()
+		res, ok := <-b.order
+		if !ok {
+			return n, io.EOF
+		}
+		r := <-res
+		if r.err != nil {
+			return n, r.err
+		}
+		b.held = &r
+		b.cur = bytes.NewReader(r.data)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// release frees the slot and buffer of the chunk that has been read.
+func (b *orderedBody) release() {
+	if b.held == nil {
+		return
+	}
+	b.pool.Put(b.held.buf)
+	b.held = nil
+	<-b.slots
+}
+
+// Close stops the fetches and closes the first chunk body.
+func (b *orderedBody) Close() error {
+	b.cancel()
+
+	return b.first.Close()
+}
+
+// contentRangeSize returns the complete length from a Content-Range header, for example 1234 from "bytes 0-99/1234".
+func contentRangeSize(cr string) (int64, error) {
+	_, size, ok := strings.Cut(cr, "/")
+	if !ok || !strings.HasPrefix(cr, "bytes ") {
+		return 0, fmt.Errorf("invalid Content-Range %q from the source ISO", cr)
+	}
+	total, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || total <= 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q from the source ISO, the complete length is required", cr)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,154 @@
+package iso
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParallelRange(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		rng    string
+		want   chunk
+		wantOK bool
+	}{
+		"large range":  {method: http.MethodGet, rng: "bytes=0-999", want: chunk{start: 0, end: 999}, wantOK: true},
+		"small range":  {method: http.MethodGet, rng: "bytes=0-99"},
+		"open range":   {method: http.MethodGet, rng: "bytes=0-"},
+		"suffix range": {method: http.MethodGet, rng: "bytes=-999"},
+		"multi range":  {method: http.MethodGet, rng: "bytes=0-499,500-999"},
+		"no range":     {method: http.MethodGet},
+		"head":         {method: http.MethodHead, rng: "bytes=0-999"},
+	}
+	h := &Handler{Parallelism: 4, ChunkSize: 100}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/iso/hook.iso", nil)
+			if tt.rng != "" {
+				req.Header.Set("Range", tt.rng)
+			}
+			got, ok := h.parallelRange(req)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(chunk{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestRoundTripParallel(t *testing.T) {
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "hook.iso", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := map[string]struct {
+		rng          string
+		want         []byte
+		wantRequests int32
+	}{
+		"whole iso":         {rng: "bytes=0-9999", want: content, wantRequests: 10},
+		"unaligned range":   {rng: "bytes=1500-4321", want: content[1500:4322], wantRequests: 3},
+		"past the end":      {rng: "bytes=9500-20000", want: content[9500:], wantRequests: 1},
+		"partly past end":   {rng: "bytes=8500-20000", want: content[8500:], wantRequests: 2},
+		"last byte chunked": {rng: "bytes=0-1000", want: content[:1001], wantRequests: 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests.Store(0)
+			h := &Handler{Parallelism: 3, ChunkSize: 1000}
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Range", tt.rng)
+			r, ok := h.parallelRange(req)
+			if !ok {
+				t.Fatal("expected a parallel range")
+			}
+			resp, err := h.roundTripParallel(http.DefaultTransport, req, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusPartialContent {
+				t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusPartialContent)
+			}
+			if resp.ContentLength != int64(len(tt.want)) {
+				t.Fatalf("got Content-Length %d, want %d", resp.ContentLength, len(tt.want))
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %d bytes that don't match the %d bytes of the range", len(got), len(tt.want))
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Fatalf("got %d upstream requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRoundTripParallelNoRanges(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 5000)))
+	}))
+	defer srv.Close()
+
+	h := &Handler{Parallelism: 3, ChunkSize: 1000}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.roundTripParallel(http.DefaultTransport, req, chunk{start: 0, end: 4999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestRoundTripParallelChunkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "bytes=0-999" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "hook.iso", time.Time{}, bytes.NewReader(make([]byte, 5000)))
+	}))
+	defer srv.Close()
+
+	h := &Handler{Parallelism: 3, ChunkSize: 1000}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.roundTripParallel(http.DefaultTransport, req, chunk{start: 0, end: 4999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("expected an error reading the body")
+	}
+}
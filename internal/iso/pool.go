@@ -0,0 +1,35 @@
+package iso
+
+import "sync"
+
+// DefaultBufferSize is the size of the buffers used to stream the ISO when Handler.BufferSize is not set.
+const DefaultBufferSize = 32 * 1024
+
+// bufferPool is a sync.Pool backed internal.BufferPool of fixed size buffers.
+// Reusing buffers across requests keeps the allocation rate, and so GC pressure, flat with many concurrent ISO streams.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{size: size}
+}
+
+// Get returns a buffer from the pool, or a new one if the pool is empty.
+func (p *bufferPool) Get() []byte {
+	if b, ok := p.pool.Get().(*[]byte); ok {
+		return *b
+	}
+
+	return make([]byte, p.size)
+}
+
+// Put returns b to the pool. Buffers that are not of the pool size are discarded.
+func (p *bufferPool) Put(b []byte) {
+	if cap(b) != p.size {
+		return
+	}
+	b = b[:p.size]
+	p.pool.Put(&b)
+}
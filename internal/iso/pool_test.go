@@ -0,0 +1,20 @@
+package iso
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	p := newBufferPool(16)
+	b := p.Get()
+	if len(b) != 16 {
+		t.Fatalf("got buffer length %d, want 16", len(b))
+	}
+	p.Put(b[:4])
+	if got := p.Get(); len(got) != 16 {
+		t.Fatalf("got reused buffer length %d, want 16", len(got))
+	}
+	// buffers of another size are not pooled.
+	p.Put(make([]byte, 8))
+	if got := p.Get(); len(got) != 16 {
+		t.Fatalf("got buffer length %d, want 16", len(got))
+	}
+}
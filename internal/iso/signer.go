@@ -0,0 +1,50 @@
+package iso
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/macsig"
+)
+
+// Query parameters of signed ISO URLs.
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+var errMissingSignature = errors.New("missing signature")
+
+// Signer creates and verifies expiring ISO URLs that are bound to a MAC address.
+// A signed URL is only valid for the MAC address in its path, so the ISO endpoint
+// can't be used as a file proxy by anything that has not been handed a URL.
+type Signer struct {
+	// Key is the HMAC key used to sign URLs.
+	Key []byte
+	// TTL is how long a signed URL is valid for.
+	TTL time.Duration
+}
+
+// URL returns a signed URL for mac, base is the URL of the ISO endpoint, for example http://192.168.2.111:8080/iso.
+func (s *Signer) URL(base *url.URL, mac net.HardwareAddr, now time.Time) *url.URL {
+	u := base.JoinPath(mac.String(), "hook.iso")
+	exp := macsig.Expires(now, s.TTL)
+	q := url.Values{}
+	q.Set(ExpiresParam, exp)
+	q.Set(SignatureParam, macsig.Sign(s.Key, mac, exp))
+	u.RawQuery = q.Encode()
+
+	return u
+}
+
+// Verify returns an error if q does not hold a valid, unexpired, signature for mac.
+func (s *Signer) Verify(mac net.HardwareAddr, q url.Values, now time.Time) error {
+	exp, sig := q.Get(ExpiresParam), q.Get(SignatureParam)
+	if exp == "" || sig == "" {
+		return errMissingSignature
+	}
+
+	return macsig.Verify(s.Key, mac, exp, sig, now)
+}
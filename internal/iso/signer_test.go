@@ -0,0 +1,45 @@
+package iso
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := &Signer{Key: []byte("secret"), TTL: time.Hour}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	now := time.Unix(1700000000, 0)
+	base, _ := url.Parse("http://192.168.2.111:8080/iso")
+	signed := s.URL(base, mac, now)
+
+	if want := "/iso/00:01:02:03:04:05/hook.iso"; signed.Path != want {
+		t.Fatalf("path: got %q, want %q", signed.Path, want)
+	}
+
+	tampered := signed.Query()
+	tampered.Set(ExpiresParam, "9999999999")
+
+	tests := map[string]struct {
+		mac     net.HardwareAddr
+		query   url.Values
+		now     time.Time
+		wantErr bool
+	}{
+		"valid":             {mac: mac, query: signed.Query(), now: now.Add(time.Minute)},
+		"expired":           {mac: mac, query: signed.Query(), now: now.Add(2 * time.Hour), wantErr: true},
+		"wrong mac":         {mac: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}, query: signed.Query(), now: now, wantErr: true},
+		"missing signature": {mac: mac, query: url.Values{}, now: now, wantErr: true},
+		"tampered expiry":   {mac: mac, query: tampered, now: now, wantErr: true},
+		"other key":         {mac: mac, query: (&Signer{Key: []byte("other"), TTL: time.Hour}).URL(base, mac, now).Query(), now: now, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := s.Verify(tt.mac, tt.query, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Package kea answers the host reservation lookups of a Kea DHCP server, so that Kea stays
+// authoritative for DHCP while Smee provides the Tinkerbell aware boot decisions and URLs.
+package kea
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/reservation"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const tracerName = "github.com/tinkerbell/smee/internal/kea"
+
+// Prefix is the URI prefix that the Handler is served from.
+const Prefix = "/kea"
+
+// Result codes of the Kea control channel.
+const (
+	ResultSuccess     = 0
+	ResultError       = 1
+	ResultUnsupported = 2
+	ResultEmpty       = 3
+)
+
+// maxBodySize is the maximum size of a command.
+const maxBodySize = 1 << 16
+
+// Replier returns the DHCP reply of Smee for a DHCP message.
+// It is implemented by the reservation DHCP handler.
+type Replier interface {
+	Reply(ctx context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error)
+}
+
+// Handler answers the reservation-get commands of the Kea control channel.
+type Handler struct {
+	Replier Replier
+	Log     logr.Logger
+}
+
+// Command is a command of the Kea control channel.
+type Command struct {
+	Command   string    `json:"command"`
+	Service   []string  `json:"service,omitempty"`
+	Arguments Arguments `json:"arguments"`
+}
+
+// Arguments are the arguments of a reservation-get command.
+//
+// ClientArch, VendorClass and UserClass are Smee extensions, they carry the DHCP options
+// 93, 60 and 77 of the client so that the boot file name matches what Smee would serve.
+type Arguments struct {
+	IdentifierType string `json:"identifier-type"`
+	Identifier     string `json:"identifier"`
+	SubnetID       int    `json:"subnet-id,omitempty"`
+	ClientArch     *int   `json:"client-arch,omitempty"`
+	VendorClass    string `json:"vendor-class,omitempty"`
+	UserClass      string `json:"user-class,omitempty"`
+}
+
+// Response is a response of the Kea control channel.
+type Response struct {
+	Result    int          `json:"result"`
+	Text      string       `json:"text"`
+	Arguments *Reservation `json:"arguments,omitempty"`
+}
+
+// Reservation is a Kea host reservation.
+type Reservation struct {
+	HWAddress      string       `json:"hw-address"`
+	SubnetID       int          `json:"subnet-id,omitempty"`
+	IPAddress      string       `json:"ip-address,omitempty"`
+	Hostname       string       `json:"hostname,omitempty"`
+	NextServer     string       `json:"next-server,omitempty"`
+	ServerHostname string       `json:"server-hostname,omitempty"`
+	BootFileName   string       `json:"boot-file-name,omitempty"`
+	OptionData     []OptionData `json:"option-data,omitempty"`
+}
+
+// OptionData is a DHCP option of a Kea host reservation.
+type OptionData struct {
+	Code      uint8  `json:"code"`
+	Data      string `json:"data"`
+	CSVFormat bool   `json:"csv-format"`
+}
+
+// skipOptions are the options of the Smee reply that Kea sets itself, or that are fields of the reservation.
+var skipOptions = map[uint8]bool{
+	dhcpv4.OptionDHCPMessageType.Code():    true,
+	dhcpv4.OptionServerIdentifier.Code():   true,
+	dhcpv4.OptionIPAddressLeaseTime.Code(): true,
+	dhcpv4.OptionHostName.Code():           true,
+}
+
+// HandlerFunc returns a http.HandlerFunc that answers POSTed Kea control channel commands.
+// Like the Kea Control Agent, the response is a list with the response of the command.
+func (h *Handler) HandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		tracer := otel.Tracer(tracerName)
+		ctx, span := tracer.Start(r.Context(), "kea.HandlerFunc")
+		defer span.End()
+
+		var c Command
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&c); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			http.Error(w, "invalid command", http.StatusBadRequest)
+			return
+		}
+		resp := h.handle(ctx, c)
+		if resp.Result == ResultError {
+			span.SetStatus(codes.Error, resp.Text)
+		} else {
+			span.SetStatus(codes.Ok, "")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]Response{resp})
+	}
+}
+
+func (h *Handler) handle(ctx context.Context, c Command) Response {
+	if c.Command != "reservation-get" {
+		return Response{Result: ResultUnsupported, Text: fmt.Sprintf("'%s' command not supported.", c.Command)}
+	}
+	if c.Arguments.IdentifierType != "hw-address" {
+		return Response{Result: ResultUnsupported, Text: fmt.Sprintf("identifier-type '%s' not supported.", c.Arguments.IdentifierType)}
+	}
+	pkt, err := discover(c.Arguments)
+	if err != nil {
+		return Response{Result: ResultError, Text: err.Error()}
+	}
+	log := h.Log.WithValues("mac", pkt.ClientHWAddr.String())
+	reply, err := h.Replier.Reply(ctx, pkt)
+	if err != nil {
+		if errors.Is(err, reservation.ErrNoReply) {
+			log.V(1).Info("no reservation", "reason", err)
+			return Response{Result: ResultEmpty, Text: "Host not found."}
+		}
+		log.Error(err, "failed to get the DHCP reply")
+		return Response{Result: ResultError, Text: "failed to get the DHCP reply"}
+	}
+	res := toReservation(reply)
+	res.SubnetID = c.Arguments.SubnetID
+
+	return Response{Result: ResultSuccess, Text: "Host found.", Arguments: &res}
+}
+
+// discover returns the DHCPDISCOVER of the client that the arguments identify.
+func discover(a Arguments) (*dhcpv4.DHCPv4, error) {
+	mac, err := net.ParseMAC(a.Identifier)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hw-address identifier: %w", err)
+	}
+	mods := []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover), dhcpv4.WithHwAddr(mac)}
+	vc := a.VendorClass
+	if a.ClientArch != nil {
+		if *a.ClientArch < 0 || *a.ClientArch > 0xffff {
+			return nil, fmt.Errorf("invalid client-arch %d", *a.ClientArch)
+		}
+		mods = append(mods,
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.Arch(*a.ClientArch))),
+			dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 0})),
+		)
+		if vc == "" {
+			vc = "PXEClient"
+		}
+	}
+	if vc != "" {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(vc)))
+	}
+	if a.UserClass != "" {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptUserClass(a.UserClass)))
+	}
+
+	return dhcpv4.New(mods...)
+}
+
+// toReservation returns the Kea host reservation of a DHCP reply.
+func toReservation(reply *dhcpv4.DHCPv4) Reservation {
+	r := Reservation{
+		HWAddress:      reply.ClientHWAddr.String(),
+		Hostname:       reply.HostName(),
+		ServerHostname: reply.ServerHostName,
+		BootFileName:   reply.BootFileName,
+	}
+	if ip := reply.YourIPAddr; ip != nil && !ip.IsUnspecified() {
+		r.IPAddress = ip.String()
+	}
+	if ip := reply.ServerIPAddr; ip != nil && !ip.IsUnspecified() {
+		r.NextServer = ip.String()
+	}
+	codes := make([]uint8, 0, len(reply.Options))
+	for c := range reply.Options {
+		if !skipOptions[c] {
+			codes = append(codes, c)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, c := range codes {
+		r.OptionData = append(r.OptionData, OptionData{Code: c, Data: hex.EncodeToString(reply.Options[c])})
+	}
+
+	return r
+}
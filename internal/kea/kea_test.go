@@ -0,0 +1,140 @@
+package kea
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/reservation"
+)
+
+type mockReplier struct {
+	err error
+}
+
+func (m *mockReplier) Reply(_ context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	reply, err := dhcpv4.NewReplyFromRequest(pkt,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.IP{192, 168, 2, 10}),
+		dhcpv4.WithServerIP(net.IP{192, 168, 2, 1}),
+		dhcpv4.WithLeaseTime(3600),
+		dhcpv4.WithOption(dhcpv4.OptHostName("node1")),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IP{192, 168, 2, 1})),
+		dhcpv4.WithOption(dhcpv4.OptRouter(net.IP{192, 168, 2, 254})),
+	)
+	if err != nil {
+		return nil, err
+	}
+	reply.BootFileName = "ipxe.efi"
+
+	return reply, nil
+}
+
+func TestHandlerFunc(t *testing.T) {
+	tests := map[string]struct {
+		method     string
+		body       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		"host found": {
+			method:     http.MethodPost,
+			body:       `{"command":"reservation-get","arguments":{"identifier-type":"hw-address","identifier":"00:01:02:03:04:05","subnet-id":1,"client-arch":7}}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"result":0,"text":"Host found.","arguments":{"hw-address":"00:01:02:03:04:05","subnet-id":1,"ip-address":"192.168.2.10","hostname":"node1","next-server":"192.168.2.1","boot-file-name":"ipxe.efi","option-data":[{"code":3,"data":"c0a802fe","csv-format":false}]}}]`,
+		},
+		"host not found": {
+			method:     http.MethodPost,
+			body:       `{"command":"reservation-get","arguments":{"identifier-type":"hw-address","identifier":"00:01:02:03:04:05"}}`,
+			err:        fmt.Errorf("%w: not found", reservation.ErrNoReply),
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"result":3,"text":"Host not found."}]`,
+		},
+		"backend error": {
+			method:     http.MethodPost,
+			body:       `{"command":"reservation-get","arguments":{"identifier-type":"hw-address","identifier":"00:01:02:03:04:05"}}`,
+			err:        errors.New("backend down"),
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"result":1,"text":"failed to get the DHCP reply"}]`,
+		},
+		"unsupported command": {
+			method:     http.MethodPost,
+			body:       `{"command":"lease4-get","arguments":{}}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"result":2,"text":"'lease4-get' command not supported."}]`,
+		},
+		"unsupported identifier type": {
+			method:     http.MethodPost,
+			body:       `{"command":"reservation-get","arguments":{"identifier-type":"client-id","identifier":"01:02"}}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"result":2,"text":"identifier-type 'client-id' not supported."}]`,
+		},
+		"invalid mac": {
+			method:     http.MethodPost,
+			body:       `{"command":"reservation-get","arguments":{"identifier-type":"hw-address","identifier":"nope"}}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"result":1,"text":"invalid hw-address identifier: address nope: invalid MAC address"}]`,
+		},
+		"invalid json": {
+			method:     http.MethodPost,
+			body:       `{`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid command",
+		},
+		"wrong method": {
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Replier: &mockReplier{err: tt.err}, Log: logr.Discard()}
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, httptest.NewRequest(tt.method, Prefix, strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if diff := cmp.Diff(tt.wantBody, strings.TrimSpace(w.Body.String())); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	arch := int(iana.EFI_X86_64)
+	pkt, err := discover(Arguments{Identifier: "00:01:02:03:04:05", ClientArch: &arch, UserClass: "Tinkerbell"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.MessageType() != dhcpv4.MessageTypeDiscover {
+		t.Fatalf("got message type %s, want DISCOVER", pkt.MessageType())
+	}
+	if got := pkt.ClassIdentifier(); got != "PXEClient" {
+		t.Fatalf("got vendor class %q, want PXEClient", got)
+	}
+	if got := dhcpv4.GetString(dhcpv4.OptionUserClassInformation, pkt.Options); got != "Tinkerbell" {
+		t.Fatalf("got user class %q, want Tinkerbell", got)
+	}
+	if got := pkt.ClientArch(); len(got) != 1 || got[0] != iana.EFI_X86_64 {
+		t.Fatalf("got client arch %v, want %v", got, iana.EFI_X86_64)
+	}
+
+	arch = 0x10000
+	if _, err := discover(Arguments{Identifier: "00:01:02:03:04:05", ClientArch: &arch}); err == nil {
+		t.Fatal("expected an error for an invalid client-arch")
+	}
+}
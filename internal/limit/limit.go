@@ -0,0 +1,190 @@
+// Package limit bounds the resources used by a subsystem, so load on one subsystem can't starve the others.
+// A nil limiter is unlimited.
+package limit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrLimited is returned when a limit is reached.
+var ErrLimited = errors.New("resource limit reached")
+
+// Limiter bounds the number of concurrent uses of a resource, new uses are rejected when the limit is reached.
+// It suits protocols whose clients retry, like TFTP.
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter returns a Limiter that allows max concurrent uses. It returns nil, unlimited, when max is 0 or less.
+func NewLimiter(max int) *Limiter {
+	if max <= 0 {
+		return nil
+	}
+
+	return &Limiter{sem: make(chan struct{}, max)}
+}
+
+// TryAcquire reserves a use, it returns false when the limit is reached.
+// Release must be called for every successful TryAcquire.
+func (l *Limiter) TryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a use reserved by TryAcquire.
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.sem
+}
+
+// TFTPReadHandler returns a TFTP read handler that rejects new sessions with ErrLimited while l is at its limit.
+// The rejection is sent to the client as a TFTP error packet.
+func TFTPReadHandler(l *Limiter, h func(filename string, rf io.ReaderFrom) error) func(filename string, rf io.ReaderFrom) error {
+	if l == nil {
+		return h
+	}
+
+	return func(filename string, rf io.ReaderFrom) error {
+		if !l.TryAcquire() {
+			return ErrLimited
+		}
+		defer l.Release()
+
+		return h(filename, rf)
+	}
+}
+
+// FairQueue bounds the number of concurrent uses of a resource. Uses over the limit wait in a queue per key,
+// the queues are served round-robin so a single key, for example a client, can't starve the others.
+type FairQueue struct {
+	max int
+
+	mu     sync.Mutex
+	active int
+	// keys holds the keys with waiters in the order they are served, queues the waiters of each key.
+	keys   []string
+	queues map[string][]chan struct{}
+}
+
+// NewFairQueue returns a FairQueue that allows max concurrent uses. It returns nil, unlimited, when max is 0 or less.
+func NewFairQueue(max int) *FairQueue {
+	if max <= 0 {
+		return nil
+	}
+
+	return &FairQueue{max: max, queues: map[string][]chan struct{}{}}
+}
+
+// Acquire waits for a use for key, it returns ctx.Err() when ctx is done first.
+// The returned func releases the use and must be called once the use is done.
+func (q *FairQueue) Acquire(ctx context.Context, key string) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+	q.mu.Lock()
+	if q.active < q.max && len(q.keys) == 0 {
+		q.active++
+		q.mu.Unlock()
+
+		return q.release, nil
+	}
+	ready := make(chan struct{})
+	if len(q.queues[key]) == 0 {
+		q.keys = append(q.keys, key)
+	}
+	q.queues[key] = append(q.queues[key], ready)
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if !q.remove(key, ready) {
+			// the use was handed over while ctx was done, pass it on.
+			q.active--
+			q.next()
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// Waiting returns the number of uses waiting in the queues.
+func (q *FairQueue) Waiting() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, w := range q.queues {
+		n += len(w)
+	}
+
+	return n
+}
+
+func (q *FairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	q.next()
+}
+
+// next hands free uses to the waiters of the next keys in turn. q.mu must be held.
+func (q *FairQueue) next() {
+	for q.active < q.max && len(q.keys) > 0 {
+		key := q.keys[0]
+		q.keys = q.keys[1:]
+		w := q.queues[key]
+		close(w[0])
+		q.active++
+		if len(w) == 1 {
+			delete(q.queues, key)
+			continue
+		}
+		q.queues[key] = w[1:]
+		// the key goes to the back of the line.
+		q.keys = append(q.keys, key)
+	}
+}
+
+// remove removes the waiter ready of key, it returns false when ready is not waiting. q.mu must be held.
+func (q *FairQueue) remove(key string, ready chan struct{}) bool {
+	w := q.queues[key]
+	for i, c := range w {
+		if c != ready {
+			continue
+		}
+		w = append(w[:i], w[i+1:]...)
+		if len(w) > 0 {
+			q.queues[key] = w
+			return true
+		}
+		delete(q.queues, key)
+		for j, k := range q.keys {
+			if k == key {
+				q.keys = append(q.keys[:j], q.keys[j+1:]...)
+				break
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+package limit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2)
+	if !l.TryAcquire() || !l.TryAcquire() {
+		t.Fatal("expected the first 2 uses to be allowed")
+	}
+	if l.TryAcquire() {
+		t.Fatal("expected the third use to be rejected")
+	}
+	l.Release()
+	if !l.TryAcquire() {
+		t.Fatal("expected a use to be allowed after a release")
+	}
+}
+
+func TestNilLimits(t *testing.T) {
+	var l *Limiter
+	if NewLimiter(0) != nil || NewFairQueue(0) != nil {
+		t.Fatal("expected a limit of 0 to be unlimited")
+	}
+	if !l.TryAcquire() {
+		t.Fatal("expected a nil Limiter to allow every use")
+	}
+	l.Release()
+	var q *FairQueue
+	release, err := q.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestTFTPReadHandler(t *testing.T) {
+	l := NewLimiter(1)
+	started := make(chan struct{})
+	done := make(chan struct{})
+	h := TFTPReadHandler(l, func(_ string, rf io.ReaderFrom) error {
+		close(started)
+		<-done
+		_, err := rf.ReadFrom(bytes.NewReader(nil))
+		return err
+	})
+	errc := make(chan error)
+	go func() { errc <- h("ipxe.efi", &bytes.Buffer{}) }()
+	<-started
+	if err := h("ipxe.efi", &bytes.Buffer{}); !errors.Is(err, ErrLimited) {
+		t.Fatalf("got %v, want %v", err, ErrLimited)
+	}
+	close(done)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFairQueue(t *testing.T) {
+	q := NewFairQueue(1)
+	release, err := q.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// key a queues 2 uses before key b queues 1, b must be served second.
+	order := make(chan string, 3)
+	wait := func(key string) {
+		r, err := q.Acquire(context.Background(), key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- key
+		r()
+	}
+	for i, key := range []string{"a", "a", "b"} {
+		go wait(key)
+		waitFor(t, func() bool { return q.Waiting() == i+1 })
+	}
+	release()
+
+	var got []string
+	for range 3 {
+		got = append(got, <-order)
+	}
+	if diff := cmp.Diff([]string{"a", "b", "a"}, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestFairQueueCancel(t *testing.T) {
+	q := NewFairQueue(1)
+	release, err := q.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Acquire(ctx, "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if q.Waiting() != 0 {
+		t.Fatalf("got %d waiting, want 0", q.Waiting())
+	}
+	release()
+	r, err := q.Acquire(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for range 100 {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
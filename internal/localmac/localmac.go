@@ -0,0 +1,268 @@
+// Package localmac is the policy of Smee for the locally administered MAC addresses, the MAC addresses with the U/L
+// bit set. Laptops, phones and some NIC firmware randomize their MAC address, a new one per network or per
+// connection, with a locally administered address. By default Smee treats them like any other MAC address, the Policy
+// keeps them from polluting the machines that are discovered, and from being sent accidental offers, in labs with such
+// devices.
+package localmac
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// Action is what is done with the locally administered MAC addresses.
+type Action string
+
+const (
+	// Allow treats locally administered MAC addresses like any other MAC address.
+	Allow Action = "allow"
+	// Ignore drops the DHCP messages of locally administered MAC addresses without a log, and their backend lookups
+	// find no machine. The messages are counted but not recorded as boot events.
+	Ignore Action = "ignore"
+	// Map looks up the machine of a locally administered MAC address by the stable identity that its DHCP messages
+	// report, the MAC address in the client identifier, option 61, or the system UUID of the client machine
+	// identifier, option 97. The DHCP messages without a stable identity are dropped.
+	Map Action = "map"
+	// Deny logs and drops the DHCP messages of locally administered MAC addresses, and their backend lookups find no
+	// machine.
+	Deny Action = "deny"
+)
+
+// ParseAction returns the Action named s.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case Allow, Ignore, Map, Deny:
+		return a, nil
+	}
+
+	return "", fmt.Errorf("unknown locally administered MAC address action %q, must be one of allow, ignore, map or deny", s)
+}
+
+// IsLocal returns whether mac is a locally administered unicast MAC address.
+func IsLocal(mac net.HardwareAddr) bool {
+	return len(mac) == 6 && mac[0]&0x02 != 0 && mac[0]&0x01 == 0
+}
+
+// mappingTTL is how long the identity of a locally administered MAC address is remembered after its last DHCP
+// message, for the lookups of its boot scripts.
+const mappingTTL = time.Hour
+
+// notFoundError is returned for the lookups of locally administered MAC addresses that are not allowed or not
+// mapped, the handlers treat them like machines that are not in the backend.
+type notFoundError struct {
+	mac net.HardwareAddr
+}
+
+func (notFoundError) NotFound() bool { return true }
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("hardware not found, %s is a locally administered MAC address", e.mac)
+}
+
+// identity is the stable identity that a client with a locally administered MAC address reports.
+type identity struct {
+	// mac is the universally administered MAC address of the client identifier.
+	mac net.HardwareAddr
+	// uuid is the system UUID of the client machine identifier.
+	uuid string
+}
+
+func (i identity) String() string {
+	if i.mac != nil {
+		return i.mac.String()
+	}
+
+	return i.uuid
+}
+
+// mapping is a remembered identity of a locally administered MAC address.
+type mapping struct {
+	id   identity
+	seen time.Time
+}
+
+// Policy is what is done with the locally administered MAC addresses. A nil Policy allows them.
+type Policy struct {
+	Action Action
+	Log    logr.Logger
+
+	mu     sync.Mutex
+	mapped map[string]mapping
+	// now is time.Now, it is replaced in tests.
+	now func() time.Time
+}
+
+// DHCP applies the policy to a DHCP message, it returns false when the message is dropped. With the Map action, the
+// identity of the client is remembered for the lookups of its MAC address, see Backend.
+func (p *Policy) DHCP(pkt *dhcpv4.DHCPv4) bool {
+	if p == nil || p.Action == Allow || !IsLocal(pkt.ClientHWAddr) {
+		return true
+	}
+	log := p.log().WithValues("mac", pkt.ClientHWAddr.String(), "xid", pkt.TransactionID.String())
+	switch p.Action {
+	case Ignore:
+		metric.LocalMACMessages.WithLabelValues("ignore").Inc()
+		return false
+	case Map:
+		id, ok := fromPacket(pkt)
+		if !ok {
+			metric.LocalMACMessages.WithLabelValues("unmapped").Inc()
+			log.Info("dropping DHCP message of a locally administered MAC address without a client identifier or machine identifier")
+			return false
+		}
+		metric.LocalMACMessages.WithLabelValues("map").Inc()
+		log.V(1).Info("mapped locally administered MAC address", "identity", id.String())
+		p.remember(pkt.ClientHWAddr, id)
+
+		return true
+	}
+	metric.LocalMACMessages.WithLabelValues("deny").Inc()
+	log.Info("denied DHCP message of a locally administered MAC address")
+
+	return false
+}
+
+// fromPacket returns the stable identity that pkt reports.
+func fromPacket(pkt *dhcpv4.DHCPv4) (identity, bool) {
+	// a client identifier of hardware type ethernet is the type followed by a MAC address, RFC 2132 section 9.14.
+	if ci := pkt.GetOneOption(dhcpv4.OptionClientIdentifier); len(ci) == 7 && ci[0] == byte(iana.HWTypeEthernet) {
+		mac := net.HardwareAddr(ci[1:])
+		if !IsLocal(mac) && mac[0]&0x01 == 0 {
+			return identity{mac: mac}, true
+		}
+	}
+	// a client machine identifier is type 0 followed by a 16 bytes UUID, RFC 4578 section 2.5.
+	if g := pkt.GetOneOption(dhcpv4.OptionClientMachineIdentifier); len(g) == 17 && g[0] == 0 {
+		if u := formatUUID(g[1:]); u != "00000000-0000-0000-0000-000000000000" && u != "ffffffff-ffff-ffff-ffff-ffffffffffff" {
+			return identity{uuid: u}, true
+		}
+	}
+
+	return identity{}, false
+}
+
+// formatUUID formats the UUID b like the SMBIOS system UUID is shown by dmidecode and iPXE, with the first three
+// fields little endian.
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%x-%x", b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6], b[8:10], b[10:16])
+}
+
+// remember records the identity of mac, for the lookups of mac without a DHCP message, like of its boot scripts.
+func (p *Policy) remember(mac net.HardwareAddr, id identity) {
+	now := p.clock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mapped == nil {
+		p.mapped = map[string]mapping{}
+	}
+	if len(p.mapped) >= 1024 {
+		for k, m := range p.mapped {
+			if now.Sub(m.seen) > mappingTTL {
+				delete(p.mapped, k)
+			}
+		}
+	}
+	p.mapped[mac.String()] = mapping{id: id, seen: now}
+}
+
+// lookup returns the remembered identity of mac.
+func (p *Policy) lookup(mac net.HardwareAddr) (identity, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m, ok := p.mapped[mac.String()]
+	if !ok || p.clock().Sub(m.seen) > mappingTTL {
+		return identity{}, false
+	}
+
+	return m.id, true
+}
+
+func (p *Policy) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+
+	return time.Now()
+}
+
+func (p *Policy) log() logr.Logger {
+	if p.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return p.Log
+}
+
+// Backend returns br with p applied to the lookups by MAC address: the lookups of locally administered MAC
+// addresses find no machine, or with the Map action, the machine of their stable identity. br is returned for a nil
+// Policy and with the Allow action. The returned backend is a handler.BackendIdentityReader when br is one.
+func (p *Policy) Backend(br handler.BackendReader) handler.BackendReader {
+	if p == nil || p.Action == Allow {
+		return br
+	}
+	b := &backend{reader: br, policy: p}
+	if ir, ok := br.(handler.BackendIdentityReader); ok {
+		return &identityBackend{backend: b, identity: ir}
+	}
+
+	return b
+}
+
+type backend struct {
+	reader handler.BackendReader
+	policy *Policy
+}
+
+func (b *backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if !IsLocal(mac) {
+		return b.reader.GetByMac(ctx, mac)
+	}
+	if b.policy.Action != Map {
+		return nil, nil, notFoundError{mac: mac}
+	}
+	id, ok := b.policy.lookup(mac)
+	switch {
+	case !ok:
+		return nil, nil, notFoundError{mac: mac}
+	case id.mac != nil:
+		return b.reader.GetByMac(ctx, id.mac)
+	}
+	ir, ok := b.reader.(handler.BackendIdentityReader)
+	if !ok {
+		// the backend can't look up machines by their system UUID.
+		return nil, nil, notFoundError{mac: mac}
+	}
+
+	return ir.GetByUUID(ctx, id.uuid)
+}
+
+func (b *backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return b.reader.GetByIP(ctx, ip)
+}
+
+type identityBackend struct {
+	*backend
+	identity handler.BackendIdentityReader
+}
+
+func (b *identityBackend) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	return b.identity.GetByUUID(ctx, uuid)
+}
+
+func (b *identityBackend) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	return b.identity.GetBySerial(ctx, serial)
+}
+
+func (b *identityBackend) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	return b.identity.GetByHostname(ctx, hostname)
+}
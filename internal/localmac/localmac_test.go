@@ -0,0 +1,162 @@
+package localmac
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+var (
+	random     = net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}
+	burnedIn   = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	systemUUID = "33221100-5544-7766-8899-aabbccddeeff"
+)
+
+// records is a backend with the machine of burnedIn and of systemUUID.
+type records struct{}
+
+func (records) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if mac.String() != burnedIn.String() && mac.String() != random.String() {
+		return nil, nil, errors.New("not found")
+	}
+
+	return &data.DHCP{MACAddress: mac, Hostname: "by-mac"}, &data.Netboot{AllowNetboot: true}, nil
+}
+
+func (records) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+type identityRecords struct{ records }
+
+func (identityRecords) GetByUUID(_ context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	if uuid != systemUUID {
+		return nil, nil, errors.New("not found")
+	}
+
+	return &data.DHCP{MACAddress: burnedIn, Hostname: "by-uuid"}, &data.Netboot{AllowNetboot: true}, nil
+}
+
+func (identityRecords) GetBySerial(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (identityRecords) GetByHostname(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func discover(t *testing.T, mac net.HardwareAddr, opts ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	pkt, err := dhcpv4.NewDiscovery(mac, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pkt
+}
+
+func TestIsLocal(t *testing.T) {
+	tests := map[string]struct {
+		mac  net.HardwareAddr
+		want bool
+	}{
+		"randomized":          {mac: random, want: true},
+		"universal":           {mac: burnedIn},
+		"multicast":           {mac: net.HardwareAddr{0x03, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		"infiniband":          {mac: make(net.HardwareAddr, 20)},
+		"local, second octet": {mac: net.HardwareAddr{0xda, 0xa1, 0x19, 0x00, 0x00, 0x01}, want: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := IsLocal(tt.mac); got != tt.want {
+				t.Fatalf("IsLocal(%v) = %v, want %v", tt.mac, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDHCP(t *testing.T) {
+	clientID := dhcpv4.WithOption(dhcpv4.OptClientIdentifier(append([]byte{1}, burnedIn...)))
+	machineID := dhcpv4.WithGeneric(dhcpv4.OptionClientMachineIdentifier, []byte{0, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	tests := map[string]struct {
+		policy *Policy
+		pkt    *dhcpv4.DHCPv4
+		want   bool
+	}{
+		"nil policy":            {pkt: discover(t, random), want: true},
+		"allow":                 {policy: &Policy{Action: Allow}, pkt: discover(t, random), want: true},
+		"ignore":                {policy: &Policy{Action: Ignore}, pkt: discover(t, random)},
+		"deny":                  {policy: &Policy{Action: Deny}, pkt: discover(t, random)},
+		"deny a universal MAC":  {policy: &Policy{Action: Deny}, pkt: discover(t, burnedIn), want: true},
+		"map a client id":       {policy: &Policy{Action: Map}, pkt: discover(t, random, clientID), want: true},
+		"map a machine id":      {policy: &Policy{Action: Map}, pkt: discover(t, random, machineID), want: true},
+		"map without identity":  {policy: &Policy{Action: Map}, pkt: discover(t, random)},
+		"map a local client id": {policy: &Policy{Action: Map}, pkt: discover(t, random, dhcpv4.WithOption(dhcpv4.OptClientIdentifier(append([]byte{1}, random...))))},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.policy.DHCP(tt.pkt); got != tt.want {
+				t.Fatalf("DHCP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	if _, _, err := (&Policy{Action: Deny}).Backend(records{}).GetByMac(ctx, random); err == nil {
+		t.Fatal("want the lookup of a locally administered MAC address to fail with the deny action")
+	}
+	if _, _, err := (&Policy{Action: Deny}).Backend(records{}).GetByMac(ctx, burnedIn); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := (&Policy{Action: Allow}).Backend(records{}).(records); !ok {
+		t.Fatal("want the backend as is with the allow action")
+	}
+
+	now := time.Now()
+	p := &Policy{Action: Map, now: func() time.Time { return now }}
+	br := p.Backend(identityRecords{})
+	if _, _, err := br.GetByMac(ctx, random); err == nil {
+		t.Fatal("want the lookup of a MAC address that was never mapped to fail")
+	}
+	p.DHCP(discover(t, random, dhcpv4.WithOption(dhcpv4.OptClientIdentifier(append([]byte{1}, burnedIn...)))))
+	d, _, err := br.GetByMac(ctx, random)
+	if err != nil || d.MACAddress.String() != burnedIn.String() {
+		t.Fatalf("got %v, %v, want the machine of the client identifier", d, err)
+	}
+
+	p.DHCP(discover(t, random, dhcpv4.WithGeneric(dhcpv4.OptionClientMachineIdentifier, []byte{0, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})))
+	d, _, err = br.GetByMac(ctx, random)
+	if err != nil || d.Hostname != "by-uuid" {
+		t.Fatalf("got %v, %v, want the machine of the system UUID", d, err)
+	}
+
+	now = now.Add(mappingTTL + time.Second)
+	if _, _, err := br.GetByMac(ctx, random); err == nil {
+		t.Fatal("want the mapping to expire")
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, s := range []string{"allow", "ignore", "map", "deny"} {
+		if a, err := ParseAction(s); err != nil || string(a) != s {
+			t.Fatalf("ParseAction(%q) = %q, %v", s, a, err)
+		}
+	}
+	if _, err := ParseAction("drop"); err == nil {
+		t.Fatal("want an error for an unknown action")
+	}
+}
@@ -0,0 +1,50 @@
+// Package macsig signs and verifies expiring HMAC signatures that are bound to a MAC address, like the phone home
+// tokens and the signed ISO URLs, so that what is handed to a machine can't be used by another one.
+package macsig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrBadSignature is returned by Verify for a signature that is not the one of the MAC address and expiry.
+	ErrBadSignature = errors.New("invalid signature")
+	// ErrExpired is returned by Verify for a valid signature that has expired.
+	ErrExpired = errors.New("signature has expired")
+)
+
+// Expires returns the expiry, in unix seconds, of a signature made at now that is valid for ttl.
+func Expires(now time.Time, ttl time.Duration) string {
+	return strconv.FormatInt(now.Add(ttl).Unix(), 10)
+}
+
+// Sign returns the hex encoded HMAC-SHA256, with key, of mac and expires.
+func Sign(key []byte, mac net.HardwareAddr, expires string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(mac.String() + "\n" + expires))
+
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Verify returns an error if signature is not the signature of mac and expires with key, or if it expired before now.
+func Verify(key []byte, mac net.HardwareAddr, expires, signature string, now time.Time) error {
+	e, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry: %w", err)
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(key, mac, expires))) {
+		return ErrBadSignature
+	}
+	if now.After(time.Unix(e, 0)) {
+		return ErrExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package macsig
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	now := time.Unix(1700000000, 0)
+	exp := Expires(now, time.Hour)
+	sig := Sign(key, mac, exp)
+
+	tests := map[string]struct {
+		key     []byte
+		mac     net.HardwareAddr
+		expires string
+		now     time.Time
+		wantErr bool
+		want    error
+	}{
+		"valid":          {key: key, mac: mac, expires: exp, now: now.Add(time.Minute)},
+		"expired":        {key: key, mac: mac, expires: exp, now: now.Add(2 * time.Hour), wantErr: true, want: ErrExpired},
+		"other mac":      {key: key, mac: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}, expires: exp, now: now, wantErr: true, want: ErrBadSignature},
+		"other key":      {key: []byte("fedcba9876543210fedcba9876543210"), mac: mac, expires: exp, now: now, wantErr: true, want: ErrBadSignature},
+		"other expiry":   {key: key, mac: mac, expires: "9999999999", now: now, wantErr: true, want: ErrBadSignature},
+		"invalid expiry": {key: key, mac: mac, expires: "soon", now: now, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := Verify(tt.key, tt.mac, tt.expires, sig, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Package maintenance is the maintenance switch of Smee. While it is on, for every machine or for the machines of
+// subnets, Smee stops netbooting the machines in maintenance without shutting down, for change freeze windows, and
+// keeps serving its logs, metrics and admin API.
+//
+// The switch is set with flags on start up and with the admin API, and the runtime settings ConfigMap can put more
+// machines in maintenance: a machine is in maintenance when any of them puts it in maintenance.
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/settings"
+)
+
+// ErrMaintenance is returned for the lookups of machines in maintenance, without LocalBoot.
+var ErrMaintenance = errors.New("smee is in maintenance")
+
+// State is what is in maintenance.
+type State struct {
+	// Enabled puts every machine in maintenance.
+	Enabled bool
+	// Subnets put the machines with an IP address in one of them in maintenance.
+	Subnets []netip.Prefix
+}
+
+// contains returns whether ip is in maintenance in s.
+func (s State) contains(ip netip.Addr) bool {
+	if s.Enabled {
+		return true
+	}
+	if !ip.IsValid() {
+		return false
+	}
+
+	return slices.ContainsFunc(s.Subnets, func(p netip.Prefix) bool { return p.Contains(ip.Unmap()) })
+}
+
+// Switch is the maintenance switch. The zero value is off.
+type Switch struct {
+	// LocalBoot, when true, keeps answering the DHCP requests of machines in maintenance, with their addresses and
+	// without netboot options, so that they boot from their local disks. Otherwise the backend lookups of the machines
+	// fail, they get no DHCP reply and no boot script.
+	LocalBoot bool
+	Log       logr.Logger
+
+	mu       sync.RWMutex
+	state    State
+	settings settings.Reader
+}
+
+// Set replaces the maintenance state of the switch.
+func (s *Switch) Set(st State) {
+	st.Subnets = slices.Clone(st.Subnets)
+	s.mu.Lock()
+	s.state = st
+	s.mu.Unlock()
+	s.log().Info("maintenance state set", "enabled", st.Enabled, "subnets", st.Subnets)
+}
+
+// Get returns the maintenance state of the switch, without the one of the runtime settings.
+func (s *Switch) Get() State {
+	if s == nil {
+		return State{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return State{Enabled: s.state.Enabled, Subnets: slices.Clone(s.state.Subnets)}
+}
+
+// UseSettings adds the maintenance state of the runtime settings of r to the switch.
+func (s *Switch) UseSettings(r settings.Reader) {
+	s.mu.Lock()
+	s.settings = r
+	s.mu.Unlock()
+}
+
+// Settings returns the maintenance state of the runtime settings.
+func (s *Switch) Settings() State {
+	if s == nil {
+		return State{}
+	}
+	s.mu.RLock()
+	r := s.settings
+	s.mu.RUnlock()
+	if r == nil {
+		return State{}
+	}
+	st := r.Get()
+
+	return State{Enabled: st.Maintenance, Subnets: st.MaintenanceSubnets}
+}
+
+// Active returns whether the machine with ip is in maintenance, ip can be the zero Addr when the machine has none.
+func (s *Switch) Active(ip netip.Addr) bool {
+	if s == nil {
+		return false
+	}
+
+	return s.Get().contains(ip) || s.Settings().contains(ip)
+}
+
+// Backend returns br with the maintenance of the switch applied to the records of every lookup.
+// The returned backend is a handler.BackendIdentityReader when br is one.
+func (s *Switch) Backend(br handler.BackendReader) handler.BackendReader {
+	if s == nil {
+		return br
+	}
+	b := &backend{reader: br, sw: s}
+	if ir, ok := br.(handler.BackendIdentityReader); ok {
+		return &identityBackend{backend: b, identity: ir}
+	}
+
+	return b
+}
+
+// enforce applies the maintenance to the record of a lookup.
+func (s *Switch) enforce(d *data.DHCP, n *data.Netboot, err error) (*data.DHCP, *data.Netboot, error) {
+	if err != nil || d == nil {
+		return d, n, err
+	}
+	if !s.Active(d.IPAddress) {
+		return d, n, nil
+	}
+	if !s.LocalBoot {
+		metric.MaintenanceLookups.WithLabelValues("refuse").Inc()
+		return nil, nil, ErrMaintenance
+	}
+	metric.MaintenanceLookups.WithLabelValues("local-boot").Inc()
+	if n == nil || !n.AllowNetboot {
+		return d, n, nil
+	}
+	ln := *n
+	ln.AllowNetboot = false
+
+	return d, &ln, nil
+}
+
+func (s *Switch) log() logr.Logger {
+	if s.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return s.Log
+}
+
+type backend struct {
+	reader handler.BackendReader
+	sw     *Switch
+}
+
+func (b *backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return b.sw.enforce(b.reader.GetByMac(ctx, mac))
+}
+
+func (b *backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return b.sw.enforce(b.reader.GetByIP(ctx, ip))
+}
+
+type identityBackend struct {
+	*backend
+	identity handler.BackendIdentityReader
+}
+
+func (b *identityBackend) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	return b.sw.enforce(b.identity.GetByUUID(ctx, uuid))
+}
+
+func (b *identityBackend) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	return b.sw.enforce(b.identity.GetBySerial(ctx, serial))
+}
+
+func (b *identityBackend) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	return b.sw.enforce(b.identity.GetByHostname(ctx, hostname))
+}
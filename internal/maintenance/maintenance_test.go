@@ -0,0 +1,117 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+	"github.com/tinkerbell/smee/internal/settings"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+// records is a backend whose records have the IP address 192.168.<mac[4]>.<mac[5]>.
+type records struct{}
+
+func (records) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return &data.DHCP{MACAddress: mac, IPAddress: netip.AddrFrom4([4]byte{192, 168, mac[4], mac[5]})}, &data.Netboot{AllowNetboot: true}, nil
+}
+
+func (records) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+type identity struct{ records }
+
+func (r identity) GetByUUID(ctx context.Context, _ string) (*data.DHCP, *data.Netboot, error) {
+	return r.GetByMac(ctx, net.HardwareAddr{0, 0, 0, 0, 2, 10})
+}
+
+func (identity) GetBySerial(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (identity) GetByHostname(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+type fixedSettings settings.Settings
+
+func (f fixedSettings) Get() settings.Settings { return settings.Settings(f) }
+
+func TestBackend(t *testing.T) {
+	lab := []netip.Prefix{netip.MustParsePrefix("192.168.2.0/24")}
+	tests := map[string]struct {
+		state       State
+		settings    settings.Settings
+		localBoot   bool
+		mac         net.HardwareAddr
+		wantErr     error
+		wantNetboot bool
+	}{
+		"off":                      {mac: net.HardwareAddr{0, 0, 0, 0, 2, 10}, wantNetboot: true},
+		"global refused":           {state: State{Enabled: true}, mac: net.HardwareAddr{0, 0, 0, 0, 2, 10}, wantErr: ErrMaintenance},
+		"global local boot":        {state: State{Enabled: true}, localBoot: true, mac: net.HardwareAddr{0, 0, 0, 0, 2, 10}},
+		"subnet refused":           {state: State{Subnets: lab}, mac: net.HardwareAddr{0, 0, 0, 0, 2, 10}, wantErr: ErrMaintenance},
+		"other subnet":             {state: State{Subnets: lab}, mac: net.HardwareAddr{0, 0, 0, 0, 3, 10}, wantNetboot: true},
+		"settings global":          {settings: settings.Settings{Maintenance: true}, mac: net.HardwareAddr{0, 0, 0, 0, 3, 10}, wantErr: ErrMaintenance},
+		"settings subnet":          {settings: settings.Settings{MaintenanceSubnets: lab}, localBoot: true, mac: net.HardwareAddr{0, 0, 0, 0, 2, 10}},
+		"settings in other subnet": {settings: settings.Settings{MaintenanceSubnets: lab}, mac: net.HardwareAddr{0, 0, 0, 0, 3, 10}, wantNetboot: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &Switch{LocalBoot: tt.localBoot}
+			s.Set(tt.state)
+			s.UseSettings(fixedSettings(tt.settings))
+			_, n, err := s.Backend(records{}).GetByMac(context.Background(), tt.mac)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && n.AllowNetboot != tt.wantNetboot {
+				t.Fatalf("got AllowNetboot %v, want %v", n.AllowNetboot, tt.wantNetboot)
+			}
+		})
+	}
+}
+
+func TestBackendIdentity(t *testing.T) {
+	s := &Switch{}
+	s.Set(State{Enabled: true})
+	br := s.Backend(identity{})
+	ir, ok := br.(handler.BackendIdentityReader)
+	if !ok {
+		t.Fatal("expected the backend to be a BackendIdentityReader")
+	}
+	before := testutil.ToFloat64(metric.MaintenanceLookups.WithLabelValues("refuse"))
+	if _, _, err := ir.GetByUUID(context.Background(), "uuid"); !errors.Is(err, ErrMaintenance) {
+		t.Fatalf("got error %v, want %v", err, ErrMaintenance)
+	}
+	if got := testutil.ToFloat64(metric.MaintenanceLookups.WithLabelValues("refuse")) - before; got != 1 {
+		t.Fatalf("got %v refused lookups, want 1", got)
+	}
+
+	s.Set(State{})
+	if _, n, err := ir.GetByUUID(context.Background(), "uuid"); err != nil || !n.AllowNetboot {
+		t.Fatalf("got %v, %v, want the record after maintenance is switched off", n, err)
+	}
+}
+
+func TestNilSwitch(t *testing.T) {
+	var s *Switch
+	if s.Active(netip.MustParseAddr("192.168.2.10")) {
+		t.Fatal("a nil switch is never in maintenance")
+	}
+	if _, ok := s.Backend(records{}).(records); !ok {
+		t.Fatal("expected a nil switch to return the backend as is")
+	}
+}
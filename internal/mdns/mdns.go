@@ -0,0 +1,307 @@
+// Package mdns advertises the services of Smee with multicast DNS service discovery, mDNS (RFC 6762) and DNS-SD
+// (RFC 6763), on the provisioning network. Tools and operators of a lab find the Smee instances, and the address of
+// their HTTP and admin endpoints, without configuring their IPs:
+//
+//	avahi-browse --resolve _http._tcp
+//	dns-sd -B _smee-admin._tcp
+//
+// Every service is advertised under the instance name of Smee, with a PTR record of its type, and SRV and TXT records
+// of the instance. The SRV records target <host>.local, whose A records are the advertised IPs of Smee.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultTTL is the default TTL of the advertised records.
+const DefaultTTL = 2 * time.Minute
+
+const (
+	domain = "local."
+	// servicesName is the name that lists the service types, RFC 6763 section 9.
+	servicesName = "_services._dns-sd._udp." + domain
+	// cacheFlush is set in the class of the records that only this responder answers, RFC 6762 section 10.2.
+	cacheFlush = 1 << 15
+	// unicastResponse is set in the class of the questions that request a unicast response, RFC 6762 section 5.4.
+	unicastResponse = 1 << 15
+	// legacyUnicastTTL is the maximum TTL of the records in the responses to legacy unicast queries, RFC 6762 section 6.7.
+	legacyUnicastTTL = 10
+)
+
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is a service that is advertised.
+type Service struct {
+	// Type is the DNS-SD service type, like _http._tcp.
+	Type string
+	// Port is the port of the service.
+	Port int
+	// TXT are the key=value pairs of the TXT record of the service.
+	TXT []string
+}
+
+// Responder answers the mDNS queries for the services of Smee, and announces them when it starts.
+type Responder struct {
+	// Instance is the instance name of the services, the hostname when empty.
+	Instance string
+	// Host is the host name that the SRV records target, without the .local domain, the hostname when empty.
+	Host string
+	// IPs are the addresses of Host.
+	IPs []netip.Addr
+	// Services are the advertised services.
+	Services []Service
+	// Interface is the name of the interface that queries are answered on, the interface of the default multicast
+	// route when empty.
+	Interface string
+	// TTL of the records, DefaultTTL when 0.
+	TTL time.Duration
+	Log logr.Logger
+}
+
+// Run answers the queries until ctx is done, then it sends goodbye packets so that the records of Smee are removed
+// from the caches of the browsers.
+func (r *Responder) Run(ctx context.Context) error {
+	var ifi *net.Interface
+	if r.Interface != "" {
+		i, err := net.InterfaceByName(r.Interface)
+		if err != nil {
+			return err
+		}
+		ifi = i
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if ifi != nil {
+		if err := ipv4.NewPacketConn(conn).SetMulticastInterface(ifi); err != nil {
+			return err
+		}
+	}
+
+	// announce twice, one second apart, RFC 6762 section 8.3.
+	r.send(conn, r.announcement(r.ttl()), group)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			r.send(conn, r.announcement(r.ttl()), group)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		r.send(conn, r.announcement(0), group)
+		_ = conn.Close()
+	}()
+	r.Log.Info("advertising services with mdns", "instance", r.instance(), "host", r.hostName(), "interface", r.Interface)
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		q := &dns.Msg{}
+		if err := q.Unpack(buf[:n]); err != nil {
+			r.Log.V(1).Info("unable to decode an mdns message", "from", from, "error", err)
+			continue
+		}
+		resp, unicast := r.answer(q, from.Port != group.Port)
+		if resp == nil {
+			continue
+		}
+		to := group
+		if unicast {
+			to = from
+		}
+		r.send(conn, resp, to)
+	}
+}
+
+func (r *Responder) send(conn *net.UDPConn, m *dns.Msg, to *net.UDPAddr) {
+	b, err := m.Pack()
+	if err != nil {
+		r.Log.Info("unable to encode an mdns response", "error", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(b, to); err != nil && !errors.Is(err, net.ErrClosed) {
+		r.Log.V(1).Info("unable to send an mdns response", "to", to, "error", err)
+	}
+}
+
+// answer returns the response to the query q, nil when none of its questions are for the records of r, and whether
+// it is sent to the querier rather than the multicast group. A legacy query, not sent from the mDNS port, is answered
+// like a unicast DNS query.
+func (r *Responder) answer(q *dns.Msg, legacy bool) (*dns.Msg, bool) {
+	if q.Response || q.Opcode != dns.OpcodeQuery {
+		return nil, false
+	}
+	ttl := r.ttl()
+	if legacy {
+		ttl = min(ttl, legacyUnicastTTL)
+	}
+	records := r.records(ttl)
+	resp := &dns.Msg{}
+	resp.Response, resp.Authoritative = true, true
+	unicast := legacy
+	seen := map[string]bool{}
+	for _, question := range q.Question {
+		matched := false
+		for _, rr := range records {
+			h := rr.Header()
+			if !strings.EqualFold(h.Name, question.Name) || (question.Qtype != dns.TypeANY && question.Qtype != h.Rrtype) {
+				continue
+			}
+			matched = true
+			if k := rr.String(); !seen[k] {
+				seen[k] = true
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		if matched && question.Qclass&unicastResponse != 0 {
+			unicast = true
+		}
+	}
+	if len(resp.Answer) == 0 {
+		return nil, false
+	}
+	// the records that a browser needs next are sent with the answers, RFC 6763 section 12.
+	for _, rr := range records {
+		if k := rr.String(); !seen[k] && r.additional(resp.Answer, rr) {
+			seen[k] = true
+			resp.Extra = append(resp.Extra, rr)
+		}
+	}
+	if legacy {
+		resp.Id = q.Id
+		resp.Question = q.Question
+		for _, rr := range slices.Concat(resp.Answer, resp.Extra) {
+			rr.Header().Class &^= cacheFlush
+		}
+	}
+
+	return resp, unicast
+}
+
+// additional returns whether rr is an additional record of the answers, the SRV and TXT records of the instances in
+// PTR answers, and the addresses of the host of the SRV records.
+func (r *Responder) additional(answers []dns.RR, rr dns.RR) bool {
+	h := rr.Header()
+	for _, a := range answers {
+		switch a := a.(type) {
+		case *dns.PTR:
+			if a.Hdr.Name == servicesName {
+				continue
+			}
+			switch h.Rrtype {
+			case dns.TypeSRV, dns.TypeTXT:
+				if strings.EqualFold(h.Name, a.Ptr) {
+					return true
+				}
+			case dns.TypeA, dns.TypeAAAA:
+				return true
+			}
+		case *dns.SRV:
+			if (h.Rrtype == dns.TypeA || h.Rrtype == dns.TypeAAAA) && strings.EqualFold(h.Name, a.Target) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// announcement returns the unsolicited response with the records of r, a goodbye when ttl is 0.
+func (r *Responder) announcement(ttl uint32) *dns.Msg {
+	m := &dns.Msg{}
+	m.Response, m.Authoritative = true, true
+	m.Answer = r.records(ttl)
+
+	return m
+}
+
+// records returns the records of the services of r.
+func (r *Responder) records(ttl uint32) []dns.RR {
+	host := r.hostName()
+	var rrs []dns.RR
+	for _, s := range r.Services {
+		typ := strings.TrimSuffix(s.Type, ".") + "." + domain
+		inst := escape(r.instance()) + "." + typ
+		txt := s.TXT
+		if len(txt) == 0 {
+			// a TXT record must have at least one string, RFC 6763 section 6.1.
+			txt = []string{""}
+		}
+		rrs = append(rrs,
+			&dns.PTR{Hdr: dns.RR_Header{Name: servicesName, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: typ},
+			&dns.PTR{Hdr: dns.RR_Header{Name: typ, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: inst},
+			&dns.SRV{Hdr: dns.RR_Header{Name: inst, Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlush, Ttl: ttl}, Port: uint16(s.Port), Target: host},
+			&dns.TXT{Hdr: dns.RR_Header{Name: inst, Rrtype: dns.TypeTXT, Class: dns.ClassINET | cacheFlush, Ttl: ttl}, Txt: txt},
+		)
+	}
+	for _, ip := range r.IPs {
+		if ip.Is4() {
+			rrs = append(rrs, &dns.A{Hdr: dns.RR_Header{Name: host, Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlush, Ttl: ttl}, A: ip.AsSlice()})
+		} else {
+			rrs = append(rrs, &dns.AAAA{Hdr: dns.RR_Header{Name: host, Rrtype: dns.TypeAAAA, Class: dns.ClassINET | cacheFlush, Ttl: ttl}, AAAA: ip.AsSlice()})
+		}
+	}
+
+	return rrs
+}
+
+func (r *Responder) ttl() uint32 {
+	if r.TTL <= 0 {
+		return uint32(DefaultTTL.Seconds())
+	}
+
+	return uint32(r.TTL.Seconds())
+}
+
+func (r *Responder) instance() string {
+	if r.Instance != "" {
+		return r.Instance
+	}
+
+	return hostname()
+}
+
+// hostName returns the fully qualified .local name of the host of r.
+func (r *Responder) hostName() string {
+	h := r.Host
+	if h == "" {
+		// the short name, the domain of the hostname is not the .local domain.
+		h, _, _ = strings.Cut(hostname(), ".")
+	}
+
+	return strings.TrimSuffix(h, ".") + "." + domain
+}
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "smee"
+	}
+
+	return h
+}
+
+// escape escapes the dots and backslashes of an instance name, which is a single DNS label.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ".", `\.`).Replace(s)
+}
@@ -0,0 +1,134 @@
+package mdns
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/miekg/dns"
+)
+
+func responder() *Responder {
+	return &Responder{
+		Instance: "smee.lab",
+		Host:     "smee01",
+		IPs:      []netip.Addr{netip.MustParseAddr("192.168.2.4")},
+		Services: []Service{
+			{Type: "_http._tcp", Port: 8080, TXT: []string{"app=smee", "path=/auto.ipxe"}},
+			{Type: "_smee-admin._tcp", Port: 50061},
+		},
+	}
+}
+
+func query(name string, qtype uint16, qclass uint16) *dns.Msg {
+	q := &dns.Msg{}
+	q.Id = 7
+	q.Question = []dns.Question{{Name: name, Qtype: qtype, Qclass: qclass}}
+
+	return q
+}
+
+// names returns the type and name of the records, as dig prints them.
+func names(rrs []dns.RR) []string {
+	var s []string
+	for _, rr := range rrs {
+		s = append(s, dns.TypeToString[rr.Header().Rrtype]+" "+rr.Header().Name)
+	}
+	slices.Sort(s)
+
+	return s
+}
+
+func TestAnswer(t *testing.T) {
+	tests := map[string]struct {
+		query       *dns.Msg
+		legacy      bool
+		wantAnswer  []string
+		wantExtra   []string
+		wantUnicast bool
+	}{
+		"browse": {
+			query:      query("_http._tcp.local.", dns.TypePTR, dns.ClassINET),
+			wantAnswer: []string{"PTR _http._tcp.local."},
+			wantExtra:  []string{"A smee01.local.", `SRV smee\.lab._http._tcp.local.`, `TXT smee\.lab._http._tcp.local.`},
+		},
+		"service types": {
+			query:      query("_services._dns-sd._udp.local.", dns.TypePTR, dns.ClassINET),
+			wantAnswer: []string{"PTR _services._dns-sd._udp.local.", "PTR _services._dns-sd._udp.local."},
+		},
+		"resolve with a unicast response": {
+			query:       query(`smee\.lab._smee-admin._tcp.local.`, dns.TypeSRV, dns.ClassINET|unicastResponse),
+			wantAnswer:  []string{`SRV smee\.lab._smee-admin._tcp.local.`},
+			wantExtra:   []string{"A smee01.local."},
+			wantUnicast: true,
+		},
+		"host any, case insensitive": {
+			query:      query("SMEE01.local.", dns.TypeANY, dns.ClassINET),
+			wantAnswer: []string{"A smee01.local."},
+		},
+		"legacy unicast": {
+			query:       query("smee01.local.", dns.TypeA, dns.ClassINET),
+			legacy:      true,
+			wantAnswer:  []string{"A smee01.local."},
+			wantUnicast: true,
+		},
+		"another host": {
+			query: query("printer.local.", dns.TypeA, dns.ClassINET),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, unicast := responder().answer(tt.query, tt.legacy)
+			if tt.wantAnswer == nil {
+				if resp != nil {
+					t.Fatalf("expected no response, got %v", resp)
+				}
+				return
+			}
+			if resp == nil {
+				t.Fatal("expected a response")
+			}
+			if diff := cmp.Diff(tt.wantAnswer, names(resp.Answer)); diff != "" {
+				t.Error(diff)
+			}
+			if diff := cmp.Diff(tt.wantExtra, names(resp.Extra)); diff != "" {
+				t.Error(diff)
+			}
+			if unicast != tt.wantUnicast {
+				t.Errorf("unicast = %v, want %v", unicast, tt.wantUnicast)
+			}
+			// the response must be a valid DNS message.
+			b, err := resp.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := (&dns.Msg{}).Unpack(b); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLegacyUnicast(t *testing.T) {
+	resp, _ := responder().answer(query("smee01.local.", dns.TypeA, dns.ClassINET), true)
+	if resp.Id != 7 || len(resp.Question) != 1 {
+		t.Errorf("expected the id and question of the query, got id %d and %d questions", resp.Id, len(resp.Question))
+	}
+	h := resp.Answer[0].Header()
+	if h.Ttl != legacyUnicastTTL || h.Class != dns.ClassINET {
+		t.Errorf("expected a TTL of %d without the cache flush bit, got TTL %d and class %#x", legacyUnicastTTL, h.Ttl, h.Class)
+	}
+}
+
+func TestGoodbye(t *testing.T) {
+	m := responder().announcement(0)
+	if len(m.Answer) != 9 {
+		t.Fatalf("expected 4 records per service and the address, got %d", len(m.Answer))
+	}
+	for _, rr := range m.Answer {
+		if rr.Header().Ttl != 0 {
+			t.Errorf("expected a TTL of 0 in a goodbye, got %v", rr)
+		}
+	}
+}
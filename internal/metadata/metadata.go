@@ -0,0 +1,199 @@
+// Package metadata serves an EC2 compatible instance metadata endpoint, and a cloud-init NoCloud seed.
+// Machines are identified by the source IP of their requests, so that workloads and installers
+// can discover their hostname, IP addresses and user data without a separate metadata service.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const tracerName = "github.com/tinkerbell/smee/internal/metadata"
+
+// Prefix is the URI prefix that the Handler is served from.
+const Prefix = "/2009-04-04/"
+
+// Handler serves the EC2 2009-04-04 meta-data and user-data, and the NoCloud seed, of the machine that makes the request.
+type Handler struct {
+	Backend handler.BackendReader
+	Log     logr.Logger
+	// VendorData is the template of the NoCloud vendor-data, executed with the tmpl.Machine of the machine.
+	VendorData string
+	// TemplateEnv is the allowlist of the environment variables that the env function of VendorData can read.
+	TemplateEnv []string
+}
+
+// HandlerFunc returns a http.HandlerFunc that serves the paths under Prefix.
+//
+//	/2009-04-04/                                       meta-data/ and user-data
+//	/2009-04-04/meta-data/                             the available meta-data keys
+//	/2009-04-04/meta-data/<key>                        a meta-data value
+//	/2009-04-04/meta-data/public-keys/<n>/openssh-key  an SSH public key
+//	/2009-04-04/user-data                              the user data
+func (h *Handler) HandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		tracer := otel.Tracer(tracerName)
+		ctx, span := tracer.Start(r.Context(), "metadata.HandlerFunc")
+		defer span.End()
+
+		d, n, ok := h.machine(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		v, ok := lookup(strings.TrimPrefix(r.URL.Path, Prefix), d, n)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		span.SetStatus(codes.Ok, "")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(v))
+	}
+}
+
+// machine returns the backend data of the machine that makes the request r, identified by its source IP. The
+// response is written, and false returned, when there is none.
+func (h *Handler) machine(ctx context.Context, w http.ResponseWriter, r *http.Request) (*data.DHCP, *data.Netboot, bool) {
+	span := trace.SpanFromContext(ctx)
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, "unable to parse client address", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	ip := net.IP(ap.Addr().Unmap().AsSlice())
+	d, n, err := h.Backend.GetByIP(ctx, ip)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		h.Log.V(1).Info("unable to get the hardware object", "error", err, "ip", ip)
+		if notFound(err) {
+			w.WriteHeader(http.StatusNotFound)
+			return nil, nil, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	return d, n, true
+}
+
+// lookup returns the value of the path p, relative to the version prefix, for a machine.
+// Directories list their entries one per line, sub-directories with a trailing slash.
+func lookup(p string, d *data.DHCP, n *data.Netboot) (string, bool) {
+	var in data.Instance
+	if n != nil && n.Instance != nil {
+		in = *n.Instance
+	}
+	switch p {
+	case "":
+		return "meta-data/\nuser-data", true
+	case "user-data":
+		return in.UserData, in.UserData != ""
+	}
+
+	p, ok := strings.CutPrefix(p, "meta-data")
+	if !ok || (p != "" && p[0] != '/') {
+		return "", false
+	}
+	p = strings.TrimPrefix(p, "/")
+	md := metaData(d, n, in)
+	if p == "" {
+		keys := make([]string, 0, len(keyOrder)+1)
+		for _, k := range keyOrder {
+			if md[k] != "" {
+				keys = append(keys, k)
+			}
+		}
+		if len(in.PublicKeys) > 0 {
+			keys = append(keys, "public-keys/")
+		}
+		return strings.Join(keys, "\n"), true
+	}
+	if p == "public-keys" || strings.HasPrefix(p, "public-keys/") {
+		return publicKey(strings.Trim(strings.TrimPrefix(p, "public-keys"), "/"), in.PublicKeys)
+	}
+	v := md[p]
+
+	return v, v != ""
+}
+
+// keyOrder is the order in which the meta-data keys are listed.
+var keyOrder = []string{"facility", "hostname", "instance-id", "local-hostname", "local-ipv4", "mac"}
+
+func metaData(d *data.DHCP, n *data.Netboot, in data.Instance) map[string]string {
+	md := map[string]string{
+		"instance-id": in.ID,
+		"hostname":    in.Hostname,
+	}
+	if d != nil {
+		if md["hostname"] == "" {
+			md["hostname"] = d.Hostname
+		}
+		md["local-hostname"] = d.Hostname
+		if d.IPAddress.IsValid() {
+			md["local-ipv4"] = d.IPAddress.String()
+		}
+		md["mac"] = d.MACAddress.String()
+	}
+	if n != nil {
+		md["facility"] = n.Facility
+	}
+
+	return md
+}
+
+// publicKey returns the public-keys directory, of the form "<n>=key-<n>", when p is empty,
+// "openssh-key" when p is "<n>" and the key when p is "<n>/openssh-key".
+func publicKey(p string, keys []string) (string, bool) {
+	if len(keys) == 0 {
+		return "", false
+	}
+	if p == "" {
+		entries := make([]string, 0, len(keys))
+		for i := range keys {
+			entries = append(entries, fmt.Sprintf("%d=key-%d", i, i))
+		}
+		return strings.Join(entries, "\n"), true
+	}
+	idx, rest, _ := strings.Cut(p, "/")
+	i, err := strconv.Atoi(idx)
+	if err != nil || i < 0 || i >= len(keys) {
+		return "", false
+	}
+	switch strings.Trim(rest, "/") {
+	case "":
+		return "openssh-key", true
+	case "openssh-key":
+		return keys[i], true
+	}
+
+	return "", false
+}
+
+func notFound(err error) bool {
+	type notFound interface {
+		NotFound() bool
+	}
+	var nf notFound
+
+	return (errors.As(err, &nf) && nf.NotFound()) || apierrors.IsNotFound(err)
+}
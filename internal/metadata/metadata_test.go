@@ -0,0 +1,229 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+type backend struct {
+	d   *data.DHCP
+	n   *data.Netboot
+	err error
+}
+
+func (b *backend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (b *backend) GetByIP(_ context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	if b.err != nil {
+		return nil, nil, b.err
+	}
+	if !ip.Equal(net.IP(b.d.IPAddress.AsSlice())) {
+		return nil, nil, notFoundError{}
+	}
+
+	return b.d, b.n, nil
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string  { return "not found" }
+func (notFoundError) NotFound() bool { return true }
+
+func TestHandlerFunc(t *testing.T) {
+	b := &backend{
+		d: &data.DHCP{
+			MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+			IPAddress:  netip.MustParseAddr("192.168.2.10"),
+			Hostname:   "node1",
+		},
+		n: &data.Netboot{
+			Facility: "onprem",
+			Instance: &data.Instance{ID: "i-1", Hostname: "web1", UserData: "#cloud-config", PublicKeys: []string{"ssh-ed25519 AAAA"}},
+		},
+	}
+	tests := map[string]struct {
+		backend    *backend
+		remoteAddr string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		"versions root":        {path: "/2009-04-04/", wantStatus: http.StatusOK, wantBody: "meta-data/\nuser-data"},
+		"meta-data listing":    {path: "/2009-04-04/meta-data/", wantStatus: http.StatusOK, wantBody: "facility\nhostname\ninstance-id\nlocal-hostname\nlocal-ipv4\nmac\npublic-keys/"},
+		"hostname":             {path: "/2009-04-04/meta-data/hostname", wantStatus: http.StatusOK, wantBody: "web1"},
+		"local-hostname":       {path: "/2009-04-04/meta-data/local-hostname", wantStatus: http.StatusOK, wantBody: "node1"},
+		"local-ipv4":           {path: "/2009-04-04/meta-data/local-ipv4", wantStatus: http.StatusOK, wantBody: "192.168.2.10"},
+		"mac":                  {path: "/2009-04-04/meta-data/mac", wantStatus: http.StatusOK, wantBody: "00:01:02:03:04:05"},
+		"public keys":          {path: "/2009-04-04/meta-data/public-keys/", wantStatus: http.StatusOK, wantBody: "0=key-0"},
+		"openssh key":          {path: "/2009-04-04/meta-data/public-keys/0/openssh-key", wantStatus: http.StatusOK, wantBody: "ssh-ed25519 AAAA"},
+		"unknown public key":   {path: "/2009-04-04/meta-data/public-keys/1/openssh-key", wantStatus: http.StatusNotFound},
+		"user-data":            {path: "/2009-04-04/user-data", wantStatus: http.StatusOK, wantBody: "#cloud-config"},
+		"unknown key":          {path: "/2009-04-04/meta-data/ami-id", wantStatus: http.StatusNotFound},
+		"ipv4 mapped client":   {remoteAddr: "[::ffff:192.168.2.10]:4000", path: "/2009-04-04/meta-data/mac", wantStatus: http.StatusOK, wantBody: "00:01:02:03:04:05"},
+		"unknown machine":      {remoteAddr: "192.168.2.11:4000", path: "/2009-04-04/meta-data/", wantStatus: http.StatusNotFound},
+		"backend error":        {backend: &backend{err: errors.New("unavailable")}, path: "/2009-04-04/meta-data/", wantStatus: http.StatusInternalServerError},
+		"no instance data":     {backend: &backend{d: b.d, n: &data.Netboot{}}, path: "/2009-04-04/meta-data/", wantStatus: http.StatusOK, wantBody: "hostname\nlocal-hostname\nlocal-ipv4\nmac"},
+		"no instance userdata": {backend: &backend{d: b.d, n: &data.Netboot{}}, path: "/2009-04-04/user-data", wantStatus: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			be := tt.backend
+			if be == nil {
+				be = b
+			}
+			h := &Handler{Backend: be, Log: logr.Discard()}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "192.168.2.10:4000"
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(res.Body)
+			if tt.wantStatus == http.StatusOK {
+				if diff := cmp.Diff(tt.wantBody, string(body)); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestNoCloudHandlerFunc(t *testing.T) {
+	d := &data.DHCP{
+		MACAddress:     net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		IPAddress:      netip.MustParseAddr("192.168.2.10"),
+		SubnetMask:     net.CIDRMask(24, 32),
+		DefaultGateway: netip.MustParseAddr("192.168.2.1"),
+		NameServers:    []net.IP{{1, 1, 1, 1}, {8, 8, 8, 8}},
+		DomainName:     "example.com",
+		Hostname:       "node1",
+	}
+	vlan := *d
+	vlan.VLANID = "100"
+	n := &data.Netboot{Instance: &data.Instance{ID: "i-1", UserData: "#cloud-config", PublicKeys: []string{"ssh-ed25519 AAAA"}}}
+	tests := map[string]struct {
+		d          *data.DHCP
+		n          *data.Netboot
+		vendorData string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		"meta-data": {
+			path: "/nocloud/meta-data", wantStatus: http.StatusOK,
+			wantBody: "instance-id: i-1\nlocal-hostname: node1\npublic-keys:\n- ssh-ed25519 AAAA\n",
+		},
+		"meta-data without instance": {
+			n: &data.Netboot{}, path: "/nocloud/meta-data", wantStatus: http.StatusOK,
+			wantBody: "instance-id: \"00:01:02:03:04:05\"\nlocal-hostname: node1\n",
+		},
+		"user-data":               {path: "/nocloud/user-data", wantStatus: http.StatusOK, wantBody: "#cloud-config"},
+		"user-data without any":   {n: &data.Netboot{}, path: "/nocloud/user-data", wantStatus: http.StatusOK, wantBody: ""},
+		"vendor-data":             {vendorData: "#cloud-config\nfqdn: {{ .Hostname }}.example.com\n", path: "/nocloud/vendor-data", wantStatus: http.StatusOK, wantBody: "#cloud-config\nfqdn: node1.example.com\n"},
+		"vendor-data without any": {path: "/nocloud/vendor-data", wantStatus: http.StatusNotFound},
+		"invalid vendor-data":     {vendorData: "{{ .Nope", path: "/nocloud/vendor-data", wantStatus: http.StatusInternalServerError},
+		"unknown document":        {path: "/nocloud/instance-data", wantStatus: http.StatusNotFound},
+		"network-config": {
+			path: "/nocloud/network-config", wantStatus: http.StatusOK,
+			wantBody: `ethernets:
+  id0:
+    addresses:
+    - 192.168.2.10/24
+    match:
+      macaddress: "00:01:02:03:04:05"
+    nameservers:
+      addresses:
+      - 1.1.1.1
+      - 8.8.8.8
+      search:
+      - example.com
+    routes:
+    - to: 0.0.0.0/0
+      via: 192.168.2.1
+version: 2
+`,
+		},
+		"network-config of a vlan": {
+			d: &vlan, path: "/nocloud/network-config", wantStatus: http.StatusOK,
+			wantBody: `ethernets:
+  id0:
+    match:
+      macaddress: "00:01:02:03:04:05"
+version: 2
+vlans:
+  id0.100:
+    addresses:
+    - 192.168.2.10/24
+    id: 100
+    link: id0
+    nameservers:
+      addresses:
+      - 1.1.1.1
+      - 8.8.8.8
+      search:
+      - example.com
+    routes:
+    - to: 0.0.0.0/0
+      via: 192.168.2.1
+`,
+		},
+		"network-config without a subnet mask": {
+			d: &data.DHCP{MACAddress: d.MACAddress, IPAddress: d.IPAddress}, path: "/nocloud/network-config", wantStatus: http.StatusOK,
+			wantBody: "ethernets:\n  id0:\n    addresses:\n    - 192.168.2.10/32\n    match:\n      macaddress: \"00:01:02:03:04:05\"\nversion: 2\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			be := &backend{d: d, n: n}
+			if tt.d != nil {
+				be.d = tt.d
+			}
+			if tt.n != nil {
+				be.n = tt.n
+			}
+			h := &Handler{Backend: be, Log: logr.Discard(), VendorData: tt.vendorData}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "192.168.2.10:4000"
+			w := httptest.NewRecorder()
+			h.NoCloudHandlerFunc()(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(res.Body)
+			if tt.wantStatus == http.StatusOK {
+				if diff := cmp.Diff(tt.wantBody, string(body)); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkConfigDHCP(t *testing.T) {
+	got := networkConfig(&data.DHCP{MACAddress: net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}})
+	if e := got.Ethernets["id0"]; !e.DHCP4 || len(e.Addresses) > 0 {
+		t.Fatalf("expected DHCP without an address, got %+v", e)
+	}
+}
@@ -0,0 +1,186 @@
+package metadata
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/tmpl"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// NoCloudPrefix is the URI prefix that the NoCloud seed is served from, the seedfrom URL of the cloud-init NoCloud
+// datasource: ds=nocloud;s=http://<smee>/nocloud/.
+const NoCloudPrefix = "/nocloud/"
+
+// NoCloudHandlerFunc returns a http.HandlerFunc that serves the NoCloud seed of the machine that makes the request.
+//
+//	/nocloud/meta-data       the instance-id, local-hostname and public-keys
+//	/nocloud/user-data       the user data, empty when the machine has none
+//	/nocloud/vendor-data     the VendorData template, executed for the machine
+//	/nocloud/network-config  the network configuration of the machine, version 2
+func (h *Handler) NoCloudHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		tracer := otel.Tracer(tracerName)
+		ctx, span := tracer.Start(r.Context(), "metadata.NoCloudHandlerFunc")
+		defer span.End()
+
+		d, n, ok := h.machine(ctx, w, r)
+		if !ok {
+			return
+		}
+		if d == nil {
+			d = &data.DHCP{}
+		}
+		var in data.Instance
+		if n != nil && n.Instance != nil {
+			in = *n.Instance
+		}
+
+		var b []byte
+		var err error
+		switch strings.TrimPrefix(r.URL.Path, NoCloudPrefix) {
+		case "meta-data":
+			b, err = yaml.Marshal(noCloudMetaData(d, in))
+		case "user-data":
+			b = []byte(in.UserData)
+		case "vendor-data":
+			if h.VendorData == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var s string
+			s, err = tmpl.Execute("vendor-data", h.VendorData, tmpl.FromBackend(d, n), tmpl.Funcs(tmpl.Options{Env: h.TemplateEnv}))
+			b = []byte(s)
+		case "network-config":
+			b, err = yaml.Marshal(networkConfig(d))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			h.Log.Error(err, "unable to render the NoCloud seed", "path", r.URL.Path, "mac", d.MACAddress)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		span.SetStatus(codes.Ok, "")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(b)
+	}
+}
+
+// noCloudMetaData returns the NoCloud meta-data of a machine, the instance-id is the MAC address when the instance
+// has no ID, cloud-init requires one.
+func noCloudMetaData(d *data.DHCP, in data.Instance) map[string]any {
+	md := map[string]any{"instance-id": in.ID}
+	if in.ID == "" {
+		md["instance-id"] = d.MACAddress.String()
+	}
+	if h := in.Hostname; h != "" {
+		md["local-hostname"] = h
+	} else if d.Hostname != "" {
+		md["local-hostname"] = d.Hostname
+	}
+	if len(in.PublicKeys) > 0 {
+		md["public-keys"] = in.PublicKeys
+	}
+
+	return md
+}
+
+// network is a network configuration, version 2, of cloud-init, a subset of the netplan format.
+type network struct {
+	Version   int                 `json:"version"`
+	Ethernets map[string]ethernet `json:"ethernets"`
+	VLANs     map[string]vlan     `json:"vlans,omitempty"`
+}
+
+// ethernet is a physical interface, matched by its MAC address.
+type ethernet struct {
+	Match match `json:"match"`
+	addressing
+}
+
+// match matches an interface.
+type match struct {
+	MACAddress string `json:"macaddress"`
+}
+
+// vlan is a VLAN interface on the Link interface.
+type vlan struct {
+	ID   int    `json:"id"`
+	Link string `json:"link"`
+	addressing
+}
+
+// addressing is the addresses, routes and name servers of an interface.
+type addressing struct {
+	DHCP4       bool         `json:"dhcp4,omitempty"`
+	Addresses   []string     `json:"addresses,omitempty"`
+	Routes      []route      `json:"routes,omitempty"`
+	Nameservers *nameservers `json:"nameservers,omitempty"`
+}
+
+// route is a route of an interface.
+type route struct {
+	To  string `json:"to"`
+	Via string `json:"via"`
+}
+
+// nameservers are the name servers and search domains of an interface.
+type nameservers struct {
+	Addresses []string `json:"addresses,omitempty"`
+	Search    []string `json:"search,omitempty"`
+}
+
+// networkConfig returns the network configuration of the interface of d. Its address, gateway and name servers are
+// static, on a VLAN of the interface when d has a VLAN ID. An interface without an address uses DHCP.
+func networkConfig(d *data.DHCP) network {
+	const id = "id0"
+	a := addressing{DHCP4: true}
+	if d.IPAddress.IsValid() {
+		a = addressing{}
+		ones, bits := d.SubnetMask.Size()
+		if bits == 0 {
+			ones = d.IPAddress.BitLen()
+		}
+		a.Addresses = []string{d.IPAddress.String() + "/" + strconv.Itoa(ones)}
+		if d.DefaultGateway.IsValid() {
+			to := "0.0.0.0/0"
+			if d.DefaultGateway.Is6() {
+				to = "::/0"
+			}
+			a.Routes = []route{{To: to, Via: d.DefaultGateway.String()}}
+		}
+		ns := &nameservers{Search: d.DomainSearch}
+		for _, ip := range d.NameServers {
+			ns.Addresses = append(ns.Addresses, ip.String())
+		}
+		if len(ns.Search) == 0 && d.DomainName != "" {
+			ns.Search = []string{d.DomainName}
+		}
+		if len(ns.Addresses) > 0 || len(ns.Search) > 0 {
+			a.Nameservers = ns
+		}
+	}
+
+	eth := ethernet{Match: match{MACAddress: d.MACAddress.String()}}
+	if vid, err := strconv.Atoi(d.VLANID); err == nil && vid > 0 && vid < 4095 {
+		return network{
+			Version:   2,
+			Ethernets: map[string]ethernet{id: eth},
+			VLANs:     map[string]vlan{id + "." + strconv.Itoa(vid): {ID: vid, Link: id, addressing: a}},
+		}
+	}
+	eth.addressing = a
+
+	return network{Version: 2, Ethernets: map[string]ethernet{id: eth}}
+}
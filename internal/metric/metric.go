@@ -1,8 +1,13 @@
 package metric
 
 import (
+	"context"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tinkerbell/smee/internal/useragent"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -15,6 +20,44 @@ var (
 	JobDuration    prometheus.ObserverVec
 	JobsTotal      *prometheus.CounterVec
 	JobsInProgress *prometheus.GaugeVec
+
+	TinkHandoffs *prometheus.CounterVec
+
+	DHCPQueueDepth prometheus.Gauge
+	DHCPDropped    prometheus.Counter
+
+	DHCPUnsupportedArch *prometheus.CounterVec
+
+	ShadowComparisons *prometheus.CounterVec
+
+	HTTPClientRequests *prometheus.CounterVec
+
+	SelfTestChecks *prometheus.GaugeVec
+	SelfTestRuns   *prometheus.CounterVec
+
+	UpstreamRequests    *prometheus.CounterVec
+	UpstreamRetries     *prometheus.CounterVec
+	UpstreamCircuitOpen *prometheus.GaugeVec
+
+	Timeouts *prometheus.CounterVec
+
+	StaleRecords *prometheus.CounterVec
+
+	MaintenanceLookups *prometheus.CounterVec
+
+	LocalMACMessages *prometheus.CounterVec
+
+	BootStageDuration *prometheus.HistogramVec
+	BootSLOResults    *prometheus.CounterVec
+
+	NetbootQuotaReached prometheus.Counter
+	NetbootQuotaLookups prometheus.Counter
+
+	RolloutHeld prometheus.Counter
+
+	ScriptsInvalid *prometheus.CounterVec
+
+	MirrorHealthy *prometheus.GaugeVec
 )
 
 func Init() {
@@ -84,6 +127,7 @@ func Init() {
 		{"from": "dhcp", "op": "DHCPREQUEST"},
 		{"from": "http", "op": "file"},
 		{"from": "http", "op": "hardware-components"},
+		{"from": "http", "op": "bootlog"},
 		{"from": "http", "op": "phone-home"},
 		{"from": "http", "op": "problem"},
 		{"from": "http", "op": "event"},
@@ -93,6 +137,173 @@ func Init() {
 	initObserverLabels(JobDuration, labelValues)
 	initCounterLabels(JobsTotal, labelValues)
 	initGaugeLabels(JobsInProgress, labelValues)
+
+	TinkHandoffs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tink_handoff_total",
+		Help: "Number of pending workflows verified after a boot script was served, by whether they started.",
+	}, []string{"result"})
+	initCounterLabels(TinkHandoffs, []prometheus.Labels{
+		{"result": "started"},
+		{"result": "not_started"},
+	})
+
+	DHCPQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dhcp_queue_depth",
+		Help: "Number of received DHCP packets waiting for a free worker.",
+	})
+	DHCPDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dhcp_dropped_total",
+		Help: "Number of received DHCP packets dropped because the worker queue was full.",
+	})
+	DHCPUnsupportedArch = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhcp_unsupported_arch_total",
+		Help: "Number of netboot DHCP requests not served an iPXE binary because no binary is known for their client architecture.",
+	}, []string{"arch"})
+
+	ShadowComparisons = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shadow_comparisons_total",
+		Help: "Number of requests mirrored to the shadow Smee, by protocol and whether the responses matched.",
+	}, []string{"protocol", "result"})
+	labelValues = []prometheus.Labels{}
+	for _, p := range []string{"dhcp", "http"} {
+		for _, r := range []string{"match", "mismatch", "error", "skipped"} {
+			labelValues = append(labelValues, prometheus.Labels{"protocol": p, "result": r})
+		}
+	}
+	initCounterLabels(ShadowComparisons, labelValues)
+
+	HTTPClientRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_requests_total",
+		Help: "Number of boot script and iPXE binary HTTP requests, by handler and the kind of client of their User-Agent.",
+	}, []string{"handler", "client"})
+	labelValues = []prometheus.Labels{}
+	for _, h := range []string{"script", "binary"} {
+		for _, c := range useragent.Clients {
+			labelValues = append(labelValues, prometheus.Labels{"handler": h, "client": string(c)})
+		}
+	}
+	initCounterLabels(HTTPClientRequests, labelValues)
+
+	SelfTestChecks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "self_test_check_passed",
+		Help: "Whether the last run of a self-test check passed, 1, or failed, 0.",
+	}, []string{"check"})
+	initGaugeLabels(SelfTestChecks, []prometheus.Labels{{"check": "dhcp"}, {"check": "script"}, {"check": "iso"}})
+	SelfTestRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "self_test_runs_total",
+		Help: "Number of runs of the self-test, by whether all of its checks passed.",
+	}, []string{"result"})
+	initCounterLabels(SelfTestRuns, []prometheus.Labels{{"result": "pass"}, {"result": "fail"}})
+
+	UpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_requests_total",
+		Help: "Number of outbound HTTP requests, by destination and whether they succeeded, failed after their retries or were failed by an open circuit breaker.",
+	}, []string{"destination", "result"})
+	UpstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_retries_total",
+		Help: "Number of retries of outbound HTTP requests, by destination.",
+	}, []string{"destination"})
+	UpstreamCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "upstream_circuit_open",
+		Help: "Whether the circuit breaker of a destination is open, 1, or closed, 0.",
+	}, []string{"destination"})
+	labelValues = []prometheus.Labels{}
+	for _, d := range []string{"iso", "shadow"} {
+		for _, r := range []string{"success", "failure", "circuit-open"} {
+			labelValues = append(labelValues, prometheus.Labels{"destination": d, "result": r})
+		}
+		initCounterLabels(UpstreamRetries, []prometheus.Labels{{"destination": d}})
+		initGaugeLabels(UpstreamCircuitOpen, []prometheus.Labels{{"destination": d}})
+	}
+	initCounterLabels(UpstreamRequests, labelValues)
+
+	Timeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "timeouts_total",
+		Help: "Number of requests whose deadline was exceeded, by the stage that timed out: dhcp, http, backend or upstream.",
+	}, []string{"stage"})
+	for _, s := range []string{"dhcp", "http", "backend", "upstream"} {
+		initCounterLabels(Timeouts, []prometheus.Labels{{"stage": s}})
+	}
+
+	StaleRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_stale_records_total",
+		Help: "Number of backend lookups that returned a record older than the staleness max age, by the action taken: continue, refuse or fallback.",
+	}, []string{"action"})
+	for _, a := range []string{"continue", "refuse", "fallback"} {
+		initCounterLabels(StaleRecords, []prometheus.Labels{{"action": a}})
+	}
+
+	MaintenanceLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "maintenance_lookups_total",
+		Help: "Number of backend lookups of machines in maintenance, by the action taken: refuse or local-boot.",
+	}, []string{"action"})
+	for _, a := range []string{"refuse", "local-boot"} {
+		initCounterLabels(MaintenanceLookups, []prometheus.Labels{{"action": a}})
+	}
+
+	LocalMACMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhcp_local_mac_messages_total",
+		Help: "Number of DHCP messages from locally administered, often randomized, MAC addresses, by the action taken: ignore, deny, map or unmapped.",
+	}, []string{"action"})
+	for _, a := range []string{"ignore", "deny", "map", "unmapped"} {
+		initCounterLabels(LocalMACMessages, []prometheus.Labels{{"action": a}})
+	}
+
+	BootStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "boot_stage_duration_seconds",
+		Help:    "Duration of the boot stages that have a service level objective: offer, script, iso or boot.",
+		Buckets: prometheus.ExponentialBuckets(.1, 2, 14),
+	}, []string{"stage"})
+	BootSLOResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "boot_slo_results_total",
+		Help: "Number of boot stages by their service level objective result: met, late or timeout.",
+	}, []string{"stage", "result"})
+	for _, s := range []string{"offer", "script", "iso", "boot"} {
+		initObserverLabels(BootStageDuration, []prometheus.Labels{{"stage": s}})
+		for _, r := range []string{"met", "late", "timeout"} {
+			initCounterLabels(BootSLOResults, []prometheus.Labels{{"stage": s, "result": r}})
+		}
+	}
+
+	NetbootQuotaReached = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "netboot_quota_reached_total",
+		Help: "Number of times a machine reached its netboot quota and was switched to local boot.",
+	})
+	NetbootQuotaLookups = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "netboot_quota_local_boot_lookups_total",
+		Help: "Number of backend lookups of machines that reached their netboot quota, served without netboot.",
+	})
+
+	RolloutHeld = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rollout_held_total",
+		Help: "Number of boot scripts served with the previous configuration of a machine, as its changed configuration was held by the rollout guard.",
+	})
+
+	ScriptsInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipxe_script_invalid_total",
+		Help: "Number of iPXE scripts not served because they failed validation, by the rule that they broke.",
+	}, []string{"reason"})
+	for _, r := range []string{"size", "header", "quote", "variable", "command", "label"} {
+		initCounterLabels(ScriptsInvalid, []prometheus.Labels{{"reason": r}})
+	}
+
+	MirrorHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mirror_candidate_healthy",
+		Help: "Whether a boot server candidate of a mirror group passed its last health check, 1, or failed it, 0.",
+	}, []string{"group", "candidate"})
+}
+
+// ObserveDuration observes the seconds since start with o. When the span of ctx is sampled, its trace ID is attached
+// to the observation as the trace_id exemplar, so that a latency spike links to the trace of the slow request.
+// Exemplars are only exposed in the OpenMetrics format.
+func ObserveDuration(ctx context.Context, o prometheus.Observer, start time.Time) {
+	d := time.Since(start).Seconds()
+	sc := trace.SpanContextFromContext(ctx)
+	if e, ok := o.(prometheus.ExemplarObserver); ok && sc.IsSampled() {
+		e.ObserveWithExemplar(d, prometheus.Labels{"trace_id": sc.TraceID().String()})
+		return
+	}
+	o.Observe(d)
 }
 
 func initCounterLabels(m *prometheus.CounterVec, l []prometheus.Labels) {
@@ -0,0 +1,43 @@
+package metric
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestObserveDuration(t *testing.T) {
+	tid := trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: trace.SpanID{0x01}, TraceFlags: trace.FlagsSampled})
+	tests := map[string]struct {
+		ctx  context.Context
+		want string
+	}{
+		"sampled":     {ctx: trace.ContextWithSpanContext(context.Background(), sc), want: tid.String()},
+		"not sampled": {ctx: trace.ContextWithSpanContext(context.Background(), sc.WithTraceFlags(0))},
+		"no span":     {ctx: context.Background()},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Buckets: []float64{1}})
+			reg.MustRegister(h)
+			ObserveDuration(tt.ctx, h, time.Now())
+			mfs, err := reg.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := mfs[0].GetMetric()[0]
+			var got string
+			if e := m.GetHistogram().GetBucket()[0].GetExemplar(); e != nil {
+				got = e.GetLabel()[0].GetValue()
+			}
+			if m.GetHistogram().GetSampleCount() != 1 || got != tt.want {
+				t.Fatalf("got %d observations with the exemplar %q, want 1 with %q", m.GetHistogram().GetSampleCount(), got, tt.want)
+			}
+		})
+	}
+}
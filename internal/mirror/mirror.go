@@ -0,0 +1,352 @@
+// Package mirror selects a healthy boot server among groups of candidates, like OSIE (HookOS) mirrors, Tink servers
+// or iPXE script servers, so that a dead mirror doesn't stall provisioning.
+//
+// A group lists interchangeable candidates. Wherever an OSIE URL, Tink server or iPXE script URL comes from, the flags,
+// a facility, tenant or boot profile, or the backend record of a machine, a value that is a candidate of a group is
+// answered with a healthy candidate of the group:
+//
+//	interval: 10s
+//	timeout: 2s
+//	groups:
+//	- name: osie
+//	  candidates:
+//	  - url: http://10.1.0.5:8080/hook
+//	    health: http://10.1.0.5:8080/hook/vmlinuz-x86_64
+//	  - url: http://10.2.0.5:8080/hook
+//	    priority: 1
+//	- name: tink
+//	  candidates:
+//	  - url: 10.1.0.6:42113
+//	    weight: 3
+//	  - url: 10.2.0.6:42113
+//
+// Like DNS SRV records, the healthy candidates with the lowest priority are answered, a higher priority is a failover,
+// and machines are spread over the candidates of the same priority by their weight. A machine is answered the same
+// candidate as long as the healthy candidates don't change, by a hash of its MAC address.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// Defaults of a Config.
+const (
+	DefaultInterval = 10 * time.Second
+	DefaultTimeout  = 2 * time.Second
+)
+
+// Config holds the groups of candidates and the health of the candidates.
+type Config struct {
+	// Interval is how often the candidates are health checked, like 30s, DefaultInterval when empty.
+	Interval string `json:"interval,omitempty"`
+	// Timeout is how long a health check waits, DefaultTimeout when empty.
+	Timeout string  `json:"timeout,omitempty"`
+	Groups  []Group `json:"groups"`
+	// Transport is used for the health checks of the http(s) candidates, http.DefaultTransport when nil.
+	Transport http.RoundTripper `json:"-"`
+
+	interval time.Duration
+	timeout  time.Duration
+	// groups are the groups by the URL of their candidates.
+	groups map[string]*Group
+
+	mu        sync.RWMutex
+	unhealthy map[string]bool
+}
+
+// Group is a set of interchangeable candidates.
+type Group struct {
+	// Name identifies the group in logs and metrics.
+	Name       string      `json:"name"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// Candidate is a boot server of a group.
+type Candidate struct {
+	// URL is the http(s) URL, like an OSIE URL or iPXE script URL, or the host:port, like a Tink server, of the candidate.
+	URL string `json:"url"`
+	// Health is the URL, or host:port, that is health checked, URL when empty. An http(s) URL is healthy when a HEAD
+	// request gets a response with a status below 500, a host:port when a TCP connection to it succeeds.
+	Health string `json:"health,omitempty"`
+	// Priority orders the candidates, the lowest priority is answered while one of its candidates is healthy.
+	Priority int `json:"priority,omitempty"`
+	// Weight is the share of the machines answered the candidate, among those of the same priority, 1 when 0.
+	Weight int `json:"weight,omitempty"`
+}
+
+// Load reads and validates a YAML, or JSON, mirror config file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, mirror config.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse mirror config: %w", err)
+	}
+	var errs []error
+	var err error
+	if c.interval, err = duration(c.Interval, DefaultInterval); err != nil {
+		errs = append(errs, fmt.Errorf("invalid interval: %w", err))
+	}
+	if c.timeout, err = duration(c.Timeout, DefaultTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("invalid timeout: %w", err))
+	}
+	c.groups = map[string]*Group{}
+	names := map[string]bool{}
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if err := g.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("group %d (%s): %w", i, g.Name, err))
+		}
+		if names[g.Name] {
+			errs = append(errs, fmt.Errorf("group %d: duplicate name %q", i, g.Name))
+		}
+		names[g.Name] = true
+		for _, cd := range g.Candidates {
+			if o, found := c.groups[cd.URL]; found {
+				errs = append(errs, fmt.Errorf("group %d (%s): candidate %q is also a candidate of group %q", i, g.Name, cd.URL, o.Name))
+			}
+			c.groups[cd.URL] = g
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func duration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%q must be positive", s)
+	}
+
+	return d, nil
+}
+
+func (g *Group) validate() error {
+	if g.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(g.Candidates) == 0 {
+		return errors.New("at least one candidate is required")
+	}
+	for i, cd := range g.Candidates {
+		if err := validAddress(cd.URL); err != nil {
+			return fmt.Errorf("candidate %d: invalid url: %w", i, err)
+		}
+		if cd.Health != "" {
+			if err := validAddress(cd.Health); err != nil {
+				return fmt.Errorf("candidate %d: invalid health: %w", i, err)
+			}
+		}
+		if cd.Priority < 0 || cd.Weight < 0 {
+			return fmt.Errorf("candidate %d: priority and weight must not be negative", i)
+		}
+	}
+
+	return nil
+}
+
+// validAddress returns an error when s is neither an http(s) URL nor a host:port.
+func validAddress(s string) error {
+	if isHTTP(s) {
+		_, err := url.ParseRequestURI(s)
+		return err
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return fmt.Errorf("%q is neither an http(s) URL nor a host:port", s)
+	}
+
+	return nil
+}
+
+func isHTTP(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+func (cd Candidate) weight() int {
+	if cd.Weight == 0 {
+		return 1
+	}
+
+	return cd.Weight
+}
+
+func (cd Candidate) health() string {
+	if cd.Health == "" {
+		return cd.URL
+	}
+
+	return cd.Health
+}
+
+// Select returns the candidate that is answered to the machine with the MAC address in place of value, when value is
+// a candidate of a group. It returns value for a nil Config, a value that is not a candidate, or when no candidate of
+// the group is healthy.
+func (c *Config) Select(mac net.HardwareAddr, value string) string {
+	if c == nil || value == "" {
+		return value
+	}
+	g, found := c.groups[value]
+	if !found {
+		return value
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var tier []Candidate
+	for _, cd := range g.Candidates {
+		switch {
+		case c.unhealthy[cd.URL]:
+		case len(tier) == 0 || cd.Priority < tier[0].Priority:
+			tier = []Candidate{cd}
+		case cd.Priority == tier[0].Priority:
+			tier = append(tier, cd)
+		}
+	}
+	if len(tier) == 0 {
+		return value
+	}
+	var total int
+	for _, cd := range tier {
+		total += cd.weight()
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(mac)
+	bucket := int(h.Sum32() % uint32(total)) //nolint:gosec // the total of the weights is positive.
+	for _, cd := range tier {
+		if bucket < cd.weight() {
+			return cd.URL
+		}
+		bucket -= cd.weight()
+	}
+
+	return tier[len(tier)-1].URL
+}
+
+// SelectURL is Select for a URL, it returns u when the selected candidate is u or isn't a valid URL.
+func (c *Config) SelectURL(mac net.HardwareAddr, u *url.URL) *url.URL {
+	if c == nil || u == nil {
+		return u
+	}
+	s := u.String()
+	sel := c.Select(mac, s)
+	if sel == s {
+		return u
+	}
+	su, err := url.Parse(sel)
+	if err != nil {
+		return u
+	}
+
+	return su
+}
+
+// Run health checks the candidates every interval until ctx is done. The candidates are healthy until they are checked.
+func (c *Config) Run(ctx context.Context, log logr.Logger) error {
+	if c == nil || len(c.Groups) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		c.check(ctx, log)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// check health checks every candidate concurrently and records the result.
+func (c *Config) check(ctx context.Context, log logr.Logger) {
+	var wg sync.WaitGroup
+	for _, g := range c.Groups {
+		for _, cd := range g.Candidates {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := c.probe(ctx, cd.health())
+				if ctx.Err() != nil {
+					return
+				}
+				c.mu.Lock()
+				was := !c.unhealthy[cd.URL]
+				if c.unhealthy == nil {
+					c.unhealthy = map[string]bool{}
+				}
+				c.unhealthy[cd.URL] = err != nil
+				c.mu.Unlock()
+				switch {
+				case err != nil && was:
+					log.Info("boot server candidate is unhealthy", "group", g.Name, "candidate", cd.URL, "error", err.Error())
+				case err == nil && !was:
+					log.Info("boot server candidate is healthy again", "group", g.Name, "candidate", cd.URL)
+				}
+				v := 1.0
+				if err != nil {
+					v = 0
+				}
+				metric.MirrorHealthy.WithLabelValues(g.Name, cd.URL).Set(v)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// probe returns an error when the health check of the address fails.
+func (c *Config) probe(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if !isHTTP(addr) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, addr, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: c.Transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
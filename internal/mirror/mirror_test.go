@@ -0,0 +1,187 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func init() {
+	metric.Init()
+}
+
+const testConfig = `
+groups:
+- name: osie
+  candidates:
+  - url: http://10.1.0.5:8080/hook
+  - url: http://10.2.0.5:8080/hook
+    weight: 3
+  - url: http://10.3.0.5:8080/hook
+    priority: 1
+- name: tink
+  candidates:
+  - url: 10.1.0.6:42113
+`
+
+func TestSelect(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	first := c.Select(mac, "http://10.3.0.5:8080/hook")
+	if first == "http://10.3.0.5:8080/hook" {
+		t.Fatal("expected a candidate of the lowest priority")
+	}
+	for range 10 {
+		if got := c.Select(mac, "http://10.1.0.5:8080/hook"); got != first {
+			t.Fatalf("got candidate %q, want the sticky candidate %q", got, first)
+		}
+	}
+
+	counts := map[string]int{}
+	for i := range 10000 {
+		m := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, byte(i >> 8), byte(i)}
+		counts[c.Select(m, "http://10.1.0.5:8080/hook")]++
+	}
+	if counts["http://10.3.0.5:8080/hook"] != 0 {
+		t.Fatalf("got %d machines on the failover candidate", counts["http://10.3.0.5:8080/hook"])
+	}
+	if n := counts["http://10.2.0.5:8080/hook"]; n < 7000 || n > 8000 {
+		t.Fatalf("got %d of 10000 machines on the candidate with a weight of 3, want about 7500", n)
+	}
+
+	// the failover candidate is answered when the candidates of the lowest priority are unhealthy, the value when
+	// no candidate is healthy.
+	c.unhealthy = map[string]bool{"http://10.1.0.5:8080/hook": true, "http://10.2.0.5:8080/hook": true}
+	if got := c.Select(mac, "http://10.1.0.5:8080/hook"); got != "http://10.3.0.5:8080/hook" {
+		t.Fatalf("got candidate %q, want the failover candidate", got)
+	}
+	c.unhealthy["http://10.3.0.5:8080/hook"] = true
+	if got := c.Select(mac, "http://10.2.0.5:8080/hook"); got != "http://10.2.0.5:8080/hook" {
+		t.Fatalf("got candidate %q, want the value when no candidate is healthy", got)
+	}
+
+	if got := c.Select(mac, "http://10.9.0.5:8080/hook"); got != "http://10.9.0.5:8080/hook" {
+		t.Fatalf("got %q for a value that is not a candidate", got)
+	}
+	u, _ := url.Parse("http://10.1.0.5:8080/hook")
+	c.unhealthy = map[string]bool{"http://10.1.0.5:8080/hook": true}
+	if got := c.SelectURL(mac, u); got.Host != "10.2.0.5:8080" {
+		t.Fatalf("got URL %v, want the healthy candidate", got)
+	}
+
+	var nc *Config
+	if got := nc.Select(mac, "10.1.0.6:42113"); got != "10.1.0.6:42113" {
+		t.Fatalf("got %q for a nil Config", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		want   string
+	}{
+		"no name":       {config: `groups: [{candidates: [{url: "http://a/hook"}]}]`, want: "name is required"},
+		"no candidates": {config: `groups: [{name: a}]`, want: "at least one candidate is required"},
+		"invalid url":   {config: `groups: [{name: a, candidates: [{url: "not a url"}]}]`, want: "neither an http(s) URL nor a host:port"},
+		"negative":      {config: `groups: [{name: a, candidates: [{url: "a:1", weight: -1}]}]`, want: "must not be negative"},
+		"duplicate":     {config: `groups: [{name: a, candidates: [{url: "a:1"}]}, {name: a, candidates: [{url: "b:1"}]}]`, want: "duplicate name"},
+		"shared":        {config: `groups: [{name: a, candidates: [{url: "a:1"}]}, {name: b, candidates: [{url: "a:1"}]}]`, want: `also a candidate of group "a"`},
+		"interval":      {config: `{interval: -1s, groups: []}`, want: "invalid interval"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNotFound) }))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusBadGateway) }))
+	defer down.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	c, err := Parse([]byte(`
+timeout: 1s
+groups:
+- name: osie
+  candidates:
+  - url: ` + down.URL + `/hook
+  - url: http://10.1.0.5/hook
+    health: ` + up.URL + `/hook
+    priority: 1
+- name: tink
+  candidates:
+  - url: ` + closed.Addr().String() + `
+  - url: ` + l.Addr().String() + `
+    priority: 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.check(context.Background(), logr.Discard())
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	if got := c.Select(mac, down.URL+"/hook"); got != "http://10.1.0.5/hook" {
+		t.Fatalf("got OSIE candidate %q, want the one with a healthy health URL", got)
+	}
+	if got := c.Select(mac, closed.Addr().String()); got != l.Addr().String() {
+		t.Fatalf("got Tink candidate %q, want the listening one", got)
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	up := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer up.Close()
+	up.Config.ErrorLog = log.New(io.Discard, "", 0)
+	failover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer failover.Close()
+
+	c, err := Parse([]byte(`
+timeout: 1s
+groups:
+- name: osie
+  candidates:
+  - url: ` + up.URL + `/hook
+  - url: http://10.1.0.5/hook
+    health: ` + failover.URL + `/hook
+    priority: 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	c.check(context.Background(), logr.Discard())
+	if got := c.Select(mac, up.URL+"/hook"); got != "http://10.1.0.5/hook" {
+		t.Fatalf("got OSIE candidate %q, want the failover without the certificate authority of the candidate", got)
+	}
+	c.Transport = up.Client().Transport
+	c.check(context.Background(), logr.Discard())
+	if got := c.Select(mac, up.URL+"/hook"); got != up.URL+"/hook" {
+		t.Fatalf("got OSIE candidate %q, want the one that is healthy with the Transport", got)
+	}
+}
@@ -0,0 +1,144 @@
+// Package osie serves the OSIE (HookOS) kernels and initrds of several versions and architectures from a directory,
+// with stable URLs that the OSIE URL of the auto.ipxe script can point at, and SHA-256 checksum sidecars.
+//
+//	<dir>/v0.10.0/vmlinuz-x86_64      GET /osie/v0.10.0/vmlinuz-x86_64
+//	<dir>/v0.10.0/initramfs-x86_64    GET /osie/v0.10.0/initramfs-x86_64.sha256
+//	<dir>/v0.9.0/vmlinuz-aarch64      GET /osie/latest/vmlinuz-x86_64, the highest version
+package osie
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/httpfile"
+)
+
+const (
+	// Latest is the version that stands for the highest version in the directory.
+	Latest = "latest"
+	// ChecksumSuffix is the suffix of the SHA-256 checksum sidecar of a file, in the sha256sum format.
+	ChecksumSuffix = httpfile.ChecksumSuffix
+)
+
+// Handler serves the files of the version directories in Dir, at /<prefix>/<version>/<file>.
+type Handler struct {
+	// Dir holds a directory per OSIE version, named like v0.10.0, with the kernels and initrds of the architectures.
+	Dir string
+	Log logr.Logger
+}
+
+// ServeHTTP serves the OSIE files, the last two elements of the request path are the version and the file name.
+// A file without a checksum sidecar in the directory is served one computed on request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !httpfile.Allowed(w, r) {
+		return
+	}
+	log := h.log().WithValues("path", r.URL.Path, "client", r.RemoteAddr)
+	version, name, ok := h.file(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	size, err := httpfile.ServeFile(w, r, filepath.Join(h.Dir, version, name))
+	if err == nil {
+		log.Info("served OSIE file", "version", version, "file", name, "fileSize", size)
+		return
+	}
+	if !os.IsNotExist(err) {
+		log.Error(err, "unable to open OSIE file", "version", version, "file", name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	target, isSum := strings.CutSuffix(name, ChecksumSuffix)
+	if !isSum {
+		http.NotFound(w, r)
+		return
+	}
+	d, modTime, err := httpfile.FileDigest(filepath.Join(h.Dir, version, target))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Error(err, "unable to compute the OSIE file checksum", "file", target)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	httpfile.ServeChecksum(w, r, name, target, modTime, d)
+}
+
+// file returns the version directory and the file name of the request path p, ok is false for a path that is not
+// a file of a version, or that names a parent directory.
+func (h *Handler) file(p string) (version, name string, ok bool) {
+	elems := strings.Split(strings.Trim(p, "/"), "/")
+	if h.Dir == "" || len(elems) < 2 {
+		return "", "", false
+	}
+	version, name = elems[len(elems)-2], elems[len(elems)-1]
+	if strings.HasPrefix(version, ".") || strings.HasPrefix(name, ".") {
+		return "", "", false
+	}
+	if version == Latest {
+		if version = h.latest(); version == "" {
+			return "", "", false
+		}
+	}
+
+	return version, name, true
+}
+
+// latest returns the highest version in Dir, a Latest directory or symlink in Dir takes precedence.
+func (h *Handler) latest() string {
+	if fi, err := os.Stat(filepath.Join(h.Dir, Latest)); err == nil && fi.IsDir() {
+		return Latest
+	}
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		return ""
+	}
+	var highest string
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if highest == "" || compareVersions(e.Name(), highest) > 0 {
+			highest = e.Name()
+		}
+	}
+
+	return highest
+}
+
+func (h *Handler) log() logr.Logger {
+	if h.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return h.Log
+}
+
+// compareVersions compares versions like v0.10.0 by their numeric dot separated elements, so that v0.10.0 is
+// higher than v0.9.0. Elements that are not numbers are compared as strings.
+func compareVersions(a, b string) int {
+	ae := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	be := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(ae) && i < len(be); i++ {
+		an, aErr := strconv.Atoi(ae[i])
+		bn, bErr := strconv.Atoi(be[i])
+		switch {
+		case aErr == nil && bErr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && ae[i] != be[i]:
+			return strings.Compare(ae[i], be[i])
+		}
+	}
+
+	return len(ae) - len(be)
+}
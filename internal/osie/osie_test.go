@@ -0,0 +1,99 @@
+package osie
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServeHTTP(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"v0.9.0/vmlinuz-x86_64":          "kernel 0.9",
+		"v0.10.0/vmlinuz-x86_64":         "kernel 0.10",
+		"v0.10.0/initramfs-x86_64":       "initrd 0.10",
+		"v0.10.0/vmlinuz-aarch64":        "arm kernel 0.10",
+		"v0.10.0/vmlinuz-aarch64.sha256": "provided  vmlinuz-aarch64\n",
+	}
+	for name, content := range files {
+		fp := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := sha256.Sum256([]byte("initrd 0.10"))
+
+	tests := map[string]struct {
+		method     string
+		path       string
+		rangeHdr   string
+		wantStatus int
+		wantBody   string
+	}{
+		"file":              {path: "/osie/v0.9.0/vmlinuz-x86_64", wantStatus: http.StatusOK, wantBody: "kernel 0.9"},
+		"latest":            {path: "/osie/latest/vmlinuz-x86_64", wantStatus: http.StatusOK, wantBody: "kernel 0.10"},
+		"computed checksum": {path: "/osie/v0.10.0/initramfs-x86_64.sha256", wantStatus: http.StatusOK, wantBody: hex.EncodeToString(sum[:]) + "  initramfs-x86_64\n"},
+		"provided checksum": {path: "/osie/v0.10.0/vmlinuz-aarch64.sha256", wantStatus: http.StatusOK, wantBody: "provided  vmlinuz-aarch64\n"},
+		"range":             {path: "/osie/v0.10.0/initramfs-x86_64", rangeHdr: "bytes=0-5", wantStatus: http.StatusPartialContent, wantBody: "initrd"},
+		"head":              {method: http.MethodHead, path: "/osie/v0.10.0/initramfs-x86_64", wantStatus: http.StatusOK},
+		"missing file":      {path: "/osie/v0.9.0/initramfs-x86_64", wantStatus: http.StatusNotFound},
+		"missing checksum":  {path: "/osie/v0.9.0/initramfs-x86_64.sha256", wantStatus: http.StatusNotFound},
+		"parent directory":  {path: "/osie/../v0.9.0", wantStatus: http.StatusNotFound},
+		"version directory": {path: "/osie/v0.9.0", wantStatus: http.StatusNotFound},
+		"method":            {method: http.MethodPost, path: "/osie/v0.9.0/vmlinuz-x86_64", wantStatus: http.StatusMethodNotAllowed},
+	}
+	h := &Handler{Dir: dir}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.rangeHdr != "" {
+				req.Header.Set("Range", tt.rangeHdr)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus >= 300 {
+				return
+			}
+			if diff := cmp.Diff(tt.wantBody, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := map[string]struct {
+		a, b string
+		want int
+	}{
+		"equal":       {a: "v0.10.0", b: "v0.10.0", want: 0},
+		"numeric":     {a: "v0.10.0", b: "v0.9.0", want: 1},
+		"lower":       {a: "v0.9.1", b: "v0.10.0", want: -1},
+		"longer":      {a: "v1.0.0.1", b: "v1.0.0", want: 1},
+		"not numeric": {a: "v1.0.0-rc2", b: "v1.0.0-rc1", want: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := compareVersions(tt.a, tt.b)
+			if (got > 0) != (tt.want > 0) || (got < 0) != (tt.want < 0) {
+				t.Fatalf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package otel
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultBootTraceTTL is how long the trace of a DHCP reply is continued by the later requests of a boot chain.
+const DefaultBootTraceTTL = 30 * time.Minute
+
+// BootTraces records the trace of the last DHCP reply sent to every machine, so that the later requests
+// of its boot chain, the iPXE script, the GRUB config and the Hook ISO, continue the same trace.
+// It implements handler.Observer. The zero value is ready to use, a nil BootTraces records nothing.
+type BootTraces struct {
+	// TTL is how long the trace of a DHCP reply is continued. The default is DefaultBootTraceTTL.
+	TTL time.Duration
+
+	mu     sync.Mutex
+	traces map[string]bootTrace
+	pruned time.Time
+}
+
+type bootTrace struct {
+	sc   trace.SpanContext
+	time time.Time
+}
+
+// DHCPServed implements handler.Observer. It records the span context of ctx for mac.
+func (b *BootTraces) DHCPServed(ctx context.Context, mac net.HardwareAddr, _ string) {
+	sc := trace.SpanContextFromContext(ctx)
+	if b == nil || !sc.IsValid() {
+		return
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.traces == nil {
+		b.traces = map[string]bootTrace{}
+	}
+	// expired traces are pruned at most once per TTL.
+	if now.Sub(b.pruned) > b.ttl() {
+		for k, t := range b.traces {
+			if now.Sub(t.time) > b.ttl() {
+				delete(b.traces, k)
+			}
+		}
+		b.pruned = now
+	}
+	b.traces[mac.String()] = bootTrace{sc: sc, time: now}
+}
+
+// SpanContext returns the span context of the last DHCP reply sent to mac.
+// ok is false when no DHCP reply was recorded for mac within the TTL.
+func (b *BootTraces) SpanContext(mac net.HardwareAddr) (sc trace.SpanContext, ok bool) {
+	if b == nil {
+		return trace.SpanContext{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, found := b.traces[mac.String()]
+	if !found || time.Since(t.time) > b.ttl() {
+		return trace.SpanContext{}, false
+	}
+
+	return t.sc, true
+}
+
+// Context returns ctx with the span context of the last DHCP reply sent to mac as its remote parent.
+// ok is false, and ctx is returned unmodified, when no DHCP reply was recorded for mac within the TTL.
+func (b *BootTraces) Context(ctx context.Context, mac net.HardwareAddr) (context.Context, bool) {
+	sc, ok := b.SpanContext(mac)
+	if !ok {
+		return ctx, false
+	}
+
+	return trace.ContextWithRemoteSpanContext(ctx, sc), true
+}
+
+func (b *BootTraces) ttl() time.Duration {
+	if b.TTL <= 0 {
+		return DefaultBootTraceTTL
+	}
+
+	return b.TTL
+}
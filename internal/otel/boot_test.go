@@ -0,0 +1,51 @@
+package otel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestBootTraces(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x23, 0xb1, 0xe3, 0x07, 0xbb, 0x35, 0x48, 0x4f, 0x53, 0x5a, 0x1f, 0x77, 0x2c, 0x06, 0x91, 0x0e},
+		SpanID:     trace.SpanID{0xd8, 0x87, 0xdc, 0x39, 0x12, 0x24, 0x04, 0x34},
+		TraceFlags: trace.FlagsSampled,
+	})
+	b := &BootTraces{}
+	if _, ok := b.SpanContext(mac); ok {
+		t.Fatal("expected no trace before a DHCP reply")
+	}
+
+	// a context without a span is not recorded.
+	b.DHCPServed(context.Background(), mac, "OFFER")
+	if _, ok := b.SpanContext(mac); ok {
+		t.Fatal("expected no trace for a context without a span")
+	}
+
+	b.DHCPServed(trace.ContextWithSpanContext(context.Background(), sc), mac, "OFFER")
+	ctx, ok := b.Context(context.Background(), mac)
+	if !ok {
+		t.Fatal("expected the trace of the DHCP reply")
+	}
+	got := trace.SpanContextFromContext(ctx)
+	if got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() || !got.IsRemote() {
+		t.Fatalf("got span context %v, want remote %v", got, sc)
+	}
+
+	b.TTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, ok := b.SpanContext(mac); ok {
+		t.Fatal("expected an expired trace to not be continued")
+	}
+
+	var nb *BootTraces
+	nb.DHCPServed(trace.ContextWithSpanContext(context.Background(), sc), mac, "OFFER")
+	if _, ok := nb.Context(context.Background(), mac); ok {
+		t.Fatal("expected a nil BootTraces to record nothing")
+	}
+}
@@ -0,0 +1,166 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+)
+
+// scopeName is the instrumentation scope of the emitted log records.
+const scopeName = "github.com/tinkerbell/smee"
+
+// logs emits to the global LoggerProvider, it is a no-op until Init sets up the logs pipeline.
+var logs = global.Logger(scopeName)
+
+// LogHandler is a slog.Handler that passes the records to Next and emits them to the OpenTelemetry logs pipeline that
+// Init sets up when Config.Logs is true, so that the logs flow into the same collector as the traces.
+type LogHandler struct {
+	Next slog.Handler
+	// ReplaceAttr, when set, is applied to the emitted attributes like slog.HandlerOptions.ReplaceAttr, so that they
+	// are redacted like those that Next writes.
+	ReplaceAttr func(groups []string, a slog.Attr) slog.Attr
+	// Provider, when set, is the LoggerProvider that the records are emitted to in place of the global one.
+	Provider log.LoggerProvider
+
+	attrs  []log.KeyValue
+	groups []string
+}
+
+// Enabled reports whether Next handles records of the level, the emitted records have the same level.
+func (h *LogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.Next.Enabled(ctx, level)
+}
+
+// Handle passes r to Next and emits it.
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.Next.Handle(ctx, r)
+
+	rec := log.Record{}
+	rec.SetTimestamp(r.Time)
+	rec.SetObservedTimestamp(time.Now())
+	rec.SetSeverity(severity(r.Level))
+	rec.SetSeverityText(r.Level.String())
+	rec.SetBody(log.StringValue(r.Message))
+	rec.AddAttributes(h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		if kv, ok := h.convert(h.groups, a); ok {
+			rec.AddAttributes(kv)
+		}
+		return true
+	})
+	h.logger().Emit(ctx, rec)
+
+	return err
+}
+
+// WithAttrs returns a LogHandler whose records have the attrs, in the current group.
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.Next = h.Next.WithAttrs(attrs)
+	c.attrs = append([]log.KeyValue{}, h.attrs...)
+	for _, a := range attrs {
+		if kv, ok := h.convert(h.groups, a); ok {
+			c.attrs = append(c.attrs, kv)
+		}
+	}
+
+	return &c
+}
+
+// WithGroup returns a LogHandler whose later attributes are in the group, their keys are prefixed by its name.
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.Next = h.Next.WithGroup(name)
+	c.groups = append(append([]string{}, h.groups...), name)
+
+	return &c
+}
+
+func (h *LogHandler) logger() log.Logger {
+	if h.Provider != nil {
+		return h.Provider.Logger(scopeName)
+	}
+
+	return logs
+}
+
+// convert returns a in the groups as a log attribute, with ReplaceAttr applied. The key is prefixed by the groups
+// like group.key, ok is false for an attribute that is dropped.
+func (h *LogHandler) convert(groups []string, a slog.Attr) (log.KeyValue, bool) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup && h.ReplaceAttr != nil {
+		a = h.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Key == "" && a.Value.Kind() != slog.KindGroup {
+		return log.KeyValue{}, false
+	}
+	key := a.Key
+	for i := len(groups) - 1; i >= 0; i-- {
+		key = groups[i] + "." + key
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		return log.KeyValue{Key: key, Value: value(a.Value)}, true
+	}
+	var kvs []log.KeyValue
+	for _, ga := range a.Value.Group() {
+		if kv, ok := h.convert(nil, ga); ok {
+			kvs = append(kvs, kv)
+		}
+	}
+	if len(kvs) == 0 {
+		return log.KeyValue{}, false
+	}
+
+	return log.Map(key, kvs...), true
+}
+
+func value(v slog.Value) log.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return log.StringValue(v.String())
+	case slog.KindInt64:
+		return log.Int64Value(v.Int64())
+	case slog.KindUint64:
+		return log.Int64Value(int64(v.Uint64())) //nolint:gosec // values above the int64 range wrap, like in JSON logs they wouldn't.
+	case slog.KindFloat64:
+		return log.Float64Value(v.Float64())
+	case slog.KindBool:
+		return log.BoolValue(v.Bool())
+	case slog.KindDuration:
+		return log.StringValue(v.Duration().String())
+	case slog.KindTime:
+		return log.StringValue(v.Time().Format(time.RFC3339Nano))
+	}
+	switch a := v.Any().(type) {
+	case error:
+		return log.StringValue(a.Error())
+	case fmt.Stringer:
+		return log.StringValue(a.String())
+	case []byte:
+		return log.BytesValue(a)
+	default:
+		return log.StringValue(fmt.Sprintf("%+v", a))
+	}
+}
+
+// severity returns the OpenTelemetry severity of the slog level: debug, info, warn and error map to their severity,
+// and the levels in between, like the verbosity levels of logr, to the severities in between.
+func severity(l slog.Level) log.Severity {
+	s := int(l) + int(log.SeverityInfo)
+	switch {
+	case s < int(log.SeverityTrace1):
+		return log.SeverityTrace1
+	case s > int(log.SeverityFatal4):
+		return log.SeverityFatal4
+	}
+
+	return log.Severity(s)
+}
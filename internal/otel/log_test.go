@@ -0,0 +1,109 @@
+package otel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/logtest"
+)
+
+func TestLogHandler(t *testing.T) {
+	rec := logtest.NewRecorder()
+	var out bytes.Buffer
+	redact := func(_ []string, a slog.Attr) slog.Attr {
+		if a.Key == "token" {
+			return slog.String(a.Key, "[REDACTED]")
+		}
+		return a
+	}
+	h := &LogHandler{
+		Next:        slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo, ReplaceAttr: redact}),
+		ReplaceAttr: redact,
+		Provider:    rec,
+	}
+	l := logr.FromSlogHandler(h).WithValues("service", "dhcp")
+	l.Info("lease sent", "token", "s3cr3t", "mac", "00:01:02:03:04:05", "attempt", 2)
+	l.V(1).Info("not enabled")
+	l.Error(errors.New("boom"), "reply failed")
+
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Fatalf("expected 2 lines written to the next handler, got %d:\n%s", n, out.String())
+	}
+	records := emitted(rec)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 emitted records, got %d", len(records))
+	}
+	type got struct {
+		Body     string
+		Severity log.Severity
+		Attrs    map[string]string
+	}
+	var gots []got
+	for _, r := range records {
+		g := got{Body: r.Body().AsString(), Severity: r.Severity(), Attrs: map[string]string{}}
+		r.WalkAttributes(func(kv log.KeyValue) bool {
+			g.Attrs[kv.Key] = kv.Value.String()
+			return true
+		})
+		gots = append(gots, g)
+	}
+	want := []got{
+		{Body: "lease sent", Severity: log.SeverityInfo, Attrs: map[string]string{"service": "dhcp", "token": "[REDACTED]", "mac": "00:01:02:03:04:05", "attempt": "2"}},
+		{Body: "reply failed", Severity: log.SeverityError, Attrs: map[string]string{"service": "dhcp", "err": "boom"}},
+	}
+	if diff := cmp.Diff(want, gots); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogHandlerGroup(t *testing.T) {
+	rec := logtest.NewRecorder()
+	h := &LogHandler{Next: slog.NewTextHandler(&bytes.Buffer{}, nil), Provider: rec}
+	slog.New(h).WithGroup("dhcp").Info("reply", "mac", "00:01:02:03:04:05", slog.Group("opts", "bootfile", "ipxe.efi"))
+	r := emitted(rec)[0]
+	keys := map[string]bool{}
+	r.WalkAttributes(func(kv log.KeyValue) bool {
+		keys[kv.Key] = true
+		return true
+	})
+	if diff := cmp.Diff(map[string]bool{"dhcp.mac": true, "dhcp.opts": true}, keys); diff != "" {
+		t.Fatal(diff)
+	}
+	if !h.Enabled(context.Background(), slog.LevelInfo) || h.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("expected the handler to be enabled like its next handler")
+	}
+}
+
+// emitted returns the records of rec, of every logger.
+func emitted(rec *logtest.Recorder) []logtest.EmittedRecord {
+	var records []logtest.EmittedRecord
+	for _, s := range rec.Result() {
+		records = append(records, s.Records...)
+	}
+
+	return records
+}
+
+func TestSeverity(t *testing.T) {
+	tests := map[slog.Level]log.Severity{
+		slog.LevelDebug: log.SeverityDebug,
+		slog.LevelInfo:  log.SeverityInfo,
+		slog.LevelWarn:  log.SeverityWarn,
+		slog.LevelError: log.SeverityError,
+		-1:              log.SeverityDebug4,
+		-20:             log.SeverityTrace1,
+		20:              log.SeverityFatal4,
+	}
+	for level, want := range tests {
+		if got := severity(level); got != want {
+			t.Errorf("severity(%v) = %v, want %v", level, got, want)
+		}
+	}
+}
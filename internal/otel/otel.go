@@ -18,14 +18,21 @@ package otel
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/go-logr/logr"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
@@ -104,7 +111,18 @@ type Config struct {
 	Servicename string `json:"service_name"`
 	Endpoint    string `json:"endpoint"`
 	Insecure    bool   `json:"insecure"`
-	Logger      logr.Logger
+	// TLS, when set, is the TLS configuration used to connect to the collector when Insecure is false.
+	TLS *tls.Config `json:"-"`
+	// ResourceAttributes are the attributes of the resource of the traces and logs, next to the service name, like
+	// deployment.environment.
+	ResourceAttributes map[string]string `json:"resource_attributes"`
+	// Logs exports the log records that a LogHandler emits to the collector, next to the traces.
+	Logs bool `json:"logs"`
+	// LogBatchSize is the maximum number of log records in an export, the SDK default when 0.
+	LogBatchSize int `json:"log_batch_size"`
+	// LogExportInterval is how long log records are batched before they are exported, the SDK default when 0.
+	LogExportInterval time.Duration `json:"log_export_interval"`
+	Logger            logr.Logger
 }
 
 // Init sets up the OpenTelemetry plumbing so it's ready to use.
@@ -121,8 +139,11 @@ func Init(ctx context.Context, c Config) (context.Context, context.CancelFunc, e
 
 func (c Config) initTracing(ctx context.Context) (context.Context, context.CancelFunc, error) {
 	// set the service name that will show up in tracing UIs
-	resAttrs := resource.WithAttributes(semconv.ServiceNameKey.String(c.Servicename))
-	res, err := resource.New(ctx, resAttrs)
+	attrs := []attribute.KeyValue{semconv.ServiceNameKey.String(c.Servicename)}
+	for _, k := range slices.Sorted(maps.Keys(c.ResourceAttributes)) {
+		attrs = append(attrs, attribute.String(k, c.ResourceAttributes[k]))
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(attrs...))
 	if err != nil {
 		return ctx, nil, fmt.Errorf("failed to create OpenTelemetry service name resource: %w", err)
 	}
@@ -153,9 +174,11 @@ func (c Config) initTracing(ctx context.Context) (context.Context, context.Cance
 		grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
 	} else {
 		creds := credentials.NewClientTLSFromCert(nil, "")
+		if c.TLS != nil {
+			creds = credentials.NewTLS(c.TLS)
+		}
 		grpcOpts = append(grpcOpts, otlptracegrpc.WithTLSCredentials(creds))
 	}
-	// TODO: add TLS client cert auth
 
 	exporter, err := otlptracegrpc.New(context.Background(), grpcOpts...)
 	if err != nil {
@@ -182,6 +205,13 @@ func (c Config) initTracing(ctx context.Context) (context.Context, context.Cance
 	// set a custom error handler so that we can use our own logger
 	otel.SetErrorHandler(c)
 
+	var loggerProvider *sdklog.LoggerProvider
+	if c.Logs {
+		if loggerProvider, err = c.initLogs(res, retryPolicy); err != nil {
+			return ctx, nil, err
+		}
+	}
+
 	// the public function will wrap this in its own shutdown function
 	return ctx, func() {
 		ctx1, done := context.WithTimeout(context.Background(), 5*time.Second)
@@ -197,9 +227,61 @@ func (c Config) initTracing(ctx context.Context) (context.Context, context.Cance
 			c.Logger.Info("shutdown of OpenTelemetry OTLP exporter failed: %s", err)
 		}
 		done()
+
+		if loggerProvider != nil {
+			// flushes the batched log records and shuts down the OTLP logs exporter.
+			ctx3, done := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := loggerProvider.Shutdown(ctx3); err != nil {
+				c.Logger.Info("shutdown of OpenTelemetry loggerProvider failed: %s", err)
+			}
+			done()
+		}
 	}, nil
 }
 
+// initLogs sets up the global LoggerProvider that LogHandlers emit to, it batches the log records and exports them
+// to the collector of the traces, with the resource of the traces.
+func (c Config) initLogs(res *resource.Resource, retryPolicy string) (*sdklog.LoggerProvider, error) {
+	opts := []otlploggrpc.Option{
+		otlploggrpc.WithEndpoint(c.Endpoint),
+		otlploggrpc.WithDialOption(grpc.WithDefaultServiceConfig(retryPolicy)),
+		otlploggrpc.WithRetry(otlploggrpc.RetryConfig{
+			Enabled:         true,
+			InitialInterval: time.Second * 5,
+			MaxInterval:     time.Second * 30,
+			MaxElapsedTime:  time.Minute * 5,
+		}),
+	}
+	if c.Insecure {
+		opts = append(opts, otlploggrpc.WithInsecure())
+	} else {
+		creds := credentials.NewClientTLSFromCert(nil, "")
+		if c.TLS != nil {
+			creds = credentials.NewTLS(c.TLS)
+		}
+		opts = append(opts, otlploggrpc.WithTLSCredentials(creds))
+	}
+	exporter, err := otlploggrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OTLP logs exporter: %w", err)
+	}
+
+	var batch []sdklog.BatchProcessorOption
+	if c.LogBatchSize > 0 {
+		batch = append(batch, sdklog.WithExportMaxBatchSize(c.LogBatchSize))
+	}
+	if c.LogExportInterval > 0 {
+		batch = append(batch, sdklog.WithExportInterval(c.LogExportInterval))
+	}
+	lp := sdklog.NewLoggerProvider(
+		sdklog.WithResource(res),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter, batch...)),
+	)
+	global.SetLoggerProvider(lp)
+
+	return lp, nil
+}
+
 func (c Config) Handle(err error) {
 	if err != nil {
 		c.Logger.Info("OpenTelemetry error", "err", err)
@@ -0,0 +1,155 @@
+// Package oui gives machines without a backend record, in the auto-proxy DHCP mode, default boot settings by the
+// vendor prefix (OUI) of their MAC address, so that heterogeneous lab hardware boots sensibly without records.
+//
+// The rule with the longest matching prefix applies, so that a 28 or 36 bit (MA-M, MA-S) assignment can refine
+// the 24 bit OUI of its vendor.
+//
+//	rules:
+//	- name: supermicro
+//	  prefixes: ["3c:ec:ef", "ac:1f:6b"]
+//	  osieURL: http://10.1.0.5:8080/hook
+//	  kernelArgs: ["console=ttyS1,115200n8"]
+//	- name: raspberry-pi
+//	  prefixes: ["dc:a6:32", "e4:5f:01"]
+//	  scriptURL: http://10.1.0.5:8080/rpi.ipxe
+package oui
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Config holds the per OUI rules.
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule holds the default boot settings of the machines whose MAC address starts with one of its prefixes.
+// Empty settings are left to the defaults of Smee.
+type Rule struct {
+	// Name identifies the rule in logs.
+	Name string `json:"name"`
+	// Prefixes are MAC address prefixes of at least 24 bits, like "3c:ec:ef", "3c-ec-ef" or "3cecef".
+	Prefixes []string `json:"prefixes"`
+	// ScriptURL is the iPXE script URL that is sent in DHCP, in place of the auto.ipxe script.
+	ScriptURL string `json:"scriptURL,omitempty"`
+	// OSIEURL is the URL where the OSIE (HookOS) images of the static auto.ipxe script are located.
+	OSIEURL string `json:"osieURL,omitempty"`
+	// KernelArgs are added to the extra kernel args of the static auto.ipxe script.
+	KernelArgs []string `json:"kernelArgs,omitempty"`
+
+	scriptURL *url.URL
+	prefixes  []string
+}
+
+// Load reads and validates a YAML, or JSON, rules file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, rules config.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse OUI rules: %w", err)
+	}
+	var errs []error
+	prefixes := map[string]string{}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if err := r.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d (%s): %w", i, r.Name, err))
+			continue
+		}
+		for _, p := range r.prefixes {
+			if other, ok := prefixes[p]; ok {
+				errs = append(errs, fmt.Errorf("rule %d (%s): prefix %s is also in rule %s", i, r.Name, p, other))
+			}
+			prefixes[p] = r.Name
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(r.Prefixes) == 0 {
+		return errors.New("at least one prefix is required")
+	}
+	for _, p := range r.Prefixes {
+		n, err := normalize(p)
+		if err != nil {
+			return err
+		}
+		r.prefixes = append(r.prefixes, n)
+	}
+	if r.ScriptURL != "" {
+		u, err := url.ParseRequestURI(r.ScriptURL)
+		if err != nil {
+			return fmt.Errorf("invalid scriptURL: %w", err)
+		}
+		r.scriptURL = u
+	}
+	if r.OSIEURL != "" {
+		if _, err := url.ParseRequestURI(r.OSIEURL); err != nil {
+			return fmt.Errorf("invalid osieURL: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// normalize returns the prefix p as lower case hex digits without separators.
+func normalize(p string) (string, error) {
+	n := strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(p))
+	if len(n) < 6 || len(n) > 12 {
+		return "", fmt.Errorf("invalid prefix %q: it must have 6 to 12 hex digits", p)
+	}
+	for _, c := range n {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", fmt.Errorf("invalid prefix %q: it must only have hex digits", p)
+		}
+	}
+
+	return n, nil
+}
+
+// IPXEScriptURL returns the parsed ScriptURL, nil when it is not set.
+func (r Rule) IPXEScriptURL() *url.URL {
+	return r.scriptURL
+}
+
+// Lookup returns the rule with the longest prefix of mac. ok is false for a nil Config or when no prefix matches.
+func (c *Config) Lookup(mac net.HardwareAddr) (r Rule, ok bool) {
+	if c == nil || len(mac) == 0 {
+		return Rule{}, false
+	}
+	hex := strings.ReplaceAll(mac.String(), ":", "")
+	longest := 0
+	for _, rule := range c.Rules {
+		for _, p := range rule.prefixes {
+			if len(p) > longest && strings.HasPrefix(hex, p) {
+				r, ok, longest = rule, true, len(p)
+			}
+		}
+	}
+
+	return r, ok
+}
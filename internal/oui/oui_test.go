@@ -0,0 +1,82 @@
+package oui
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLookup(t *testing.T) {
+	c, err := Parse([]byte(`
+rules:
+- name: supermicro
+  prefixes: ["3c:ec:ef", "AC-1F-6B"]
+  osieURL: http://10.1.0.5:8080/hook
+  kernelArgs: ["console=ttyS1,115200n8"]
+- name: supermicro-lab
+  prefixes: ["3cecef1"]
+  scriptURL: http://10.1.0.5:8080/lab.ipxe
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		mac       string
+		want      string
+		wantFound bool
+	}{
+		"oui":              {mac: "3c:ec:ef:01:02:03", want: "supermicro", wantFound: true},
+		"upper case rule":  {mac: "ac:1f:6b:01:02:03", want: "supermicro", wantFound: true},
+		"longest prefix":   {mac: "3c:ec:ef:12:34:56", want: "supermicro-lab", wantFound: true},
+		"no matching rule": {mac: "00:01:02:03:04:05"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mac, err := net.ParseMAC(tt.mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, ok := c.Lookup(mac)
+			if ok != tt.wantFound {
+				t.Fatalf("Lookup() found = %v, want %v", ok, tt.wantFound)
+			}
+			if diff := cmp.Diff(tt.want, r.Name); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	r, _ := c.Lookup(net.HardwareAddr{0x3c, 0xec, 0xef, 0x12, 0x34, 0x56})
+	if u := r.IPXEScriptURL(); u == nil || u.String() != "http://10.1.0.5:8080/lab.ipxe" {
+		t.Fatalf("got script URL %v", u)
+	}
+	var nilConfig *Config
+	if _, ok := nilConfig.Lookup(net.HardwareAddr{0x3c, 0xec, 0xef, 0x12, 0x34, 0x56}); ok {
+		t.Fatal("expected no rule from a nil config")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		rules   string
+		wantErr bool
+	}{
+		"valid":            {rules: "rules:\n- name: a\n  prefixes: [\"00:01:02\"]\n"},
+		"no name":          {rules: "rules:\n- prefixes: [\"00:01:02\"]\n", wantErr: true},
+		"no prefixes":      {rules: "rules:\n- name: a\n", wantErr: true},
+		"short prefix":     {rules: "rules:\n- name: a\n  prefixes: [\"00:01\"]\n", wantErr: true},
+		"not hex":          {rules: "rules:\n- name: a\n  prefixes: [\"00:01:0g\"]\n", wantErr: true},
+		"duplicate prefix": {rules: "rules:\n- name: a\n  prefixes: [\"00:01:02\"]\n- name: b\n  prefixes: [\"000102\"]\n", wantErr: true},
+		"invalid url":      {rules: "rules:\n- name: a\n  prefixes: [\"00:01:02\"]\n  scriptURL: rpi.ipxe\n", wantErr: true},
+		"invalid yaml":     {rules: "rules: [", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.rules))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
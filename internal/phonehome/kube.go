@@ -0,0 +1,69 @@
+package phonehome
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const tracerName = "github.com/tinkerbell/smee/internal/phonehome"
+
+// Kube disables the netboot of a machine by setting allowPXE to false on the interface of its MAC address
+// in its Hardware object.
+type Kube struct {
+	// Client is used to read and patch Hardware objects.
+	// It must have the kube.MACAddrIndex field index registered.
+	Client client.Client
+}
+
+// DisableNetboot implements Disabler.
+func (k *Kube) DisableNetboot(ctx context.Context, mac net.HardwareAddr) error {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "phonehome.Kube.DisableNetboot")
+	defer span.End()
+
+	hl := &v1alpha1.HardwareList{}
+	if err := k.Client.List(ctx, hl, &client.MatchingFields{kube.MACAddrIndex: mac.String()}); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed listing hardware for (%v): %w", mac, err)
+	}
+	switch len(hl.Items) {
+	case 0:
+		span.SetStatus(codes.Error, errNotFound.Error())
+		return fmt.Errorf("%w: %s", errNotFound, mac)
+	case 1:
+	default:
+		err := fmt.Errorf("got %d hardware objects for mac %s, expected only 1", len(hl.Items), mac)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	hw := &hl.Items[0]
+	patch := client.MergeFrom(hw.DeepCopy())
+	disallow := false
+	for i, iface := range hw.Spec.Interfaces {
+		if iface.DHCP == nil {
+			continue
+		}
+		if m, err := net.ParseMAC(iface.DHCP.MAC); err != nil || m.String() != mac.String() {
+			continue
+		}
+		if iface.Netboot == nil {
+			hw.Spec.Interfaces[i].Netboot = &v1alpha1.Netboot{}
+		}
+		hw.Spec.Interfaces[i].Netboot.AllowPXE = &disallow
+	}
+	if err := k.Client.Patch(ctx, hw, patch); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed patching hardware %s/%s: %w", hw.Namespace, hw.Name, err)
+	}
+	span.SetStatus(codes.Ok, "")
+
+	return nil
+}
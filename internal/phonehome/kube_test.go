@@ -0,0 +1,54 @@
+package phonehome
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tinkerbell/smee/internal/backend/kube"
+	"github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestKubeDisableNetboot(t *testing.T) {
+	allow := true
+	hw := &v1alpha1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine1", Namespace: "tink-system"},
+		Spec: v1alpha1.HardwareSpec{
+			Interfaces: []v1alpha1.Interface{
+				{DHCP: &v1alpha1.DHCP{MAC: "3C:EC:EF:4C:4F:54"}, Netboot: &v1alpha1.Netboot{AllowPXE: &allow}},
+				{DHCP: &v1alpha1.DHCP{MAC: "3c:ec:ef:4c:4f:55"}, Netboot: &v1alpha1.Netboot{AllowPXE: &allow}},
+			},
+		},
+	}
+	rs := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(rs); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(rs).WithObjects(hw).WithIndex(&v1alpha1.Hardware{}, kube.MACAddrIndex, func(client.Object) []string {
+		return []string{"3c:ec:ef:4c:4f:54", "3c:ec:ef:4c:4f:55"}
+	}).Build()
+	k := &Kube{Client: c}
+	if err := k.DisableNetboot(context.Background(), mac); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &v1alpha1.Hardware{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(hw), got); err != nil {
+		t.Fatal(err)
+	}
+	if *got.Spec.Interfaces[0].Netboot.AllowPXE {
+		t.Fatal("expected allowPXE to be false on the interface of the machine that phoned home")
+	}
+	if !*got.Spec.Interfaces[1].Netboot.AllowPXE {
+		t.Fatal("expected allowPXE of the other interfaces to be unchanged")
+	}
+
+	empty := fake.NewClientBuilder().WithScheme(rs).WithIndex(&v1alpha1.Hardware{}, kube.MACAddrIndex, kube.MACAddrs).Build()
+	if err := (&Kube{Client: empty}).DisableNetboot(context.Background(), mac); !errors.Is(err, errNotFound) {
+		t.Fatalf("got error %v, want %v", err, errNotFound)
+	}
+}
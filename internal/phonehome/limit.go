@@ -0,0 +1,67 @@
+package phonehome
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// ServeLimit disables the netboot of a machine once it has been served its boot script, or has started an ISO mount,
+// Max times, so that a machine that falls back to the network boot after provisioning is not re-provisioned in a loop.
+// A phone home resets the count of a machine. The counts are kept in memory, they start again when Smee restarts.
+//
+// It implements script.Observer, iso.Observer and Observer.
+type ServeLimit struct {
+	// Max is the number of serves after which the netboot of a machine is disabled.
+	Max      int
+	Disabler Disabler
+	Log      logr.Logger
+
+	mu     sync.Mutex
+	served map[string]int
+}
+
+// ScriptServed implements script.Observer.
+func (l *ServeLimit) ScriptServed(ctx context.Context, mac net.HardwareAddr, name string) {
+	l.serve(ctx, mac, name)
+}
+
+// ISOServed implements iso.Observer.
+func (l *ServeLimit) ISOServed(ctx context.Context, mac net.HardwareAddr) {
+	l.serve(ctx, mac, "iso")
+}
+
+// BootCompleted implements Observer.
+func (l *ServeLimit) BootCompleted(_ context.Context, mac net.HardwareAddr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.served, mac.String())
+}
+
+// serve counts a serve of what to mac and disables its netboot when it reaches Max.
+func (l *ServeLimit) serve(ctx context.Context, mac net.HardwareAddr, what string) {
+	if l.Max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	if l.served == nil {
+		l.served = map[string]int{}
+	}
+	l.served[mac.String()]++
+	n := l.served[mac.String()]
+	if n >= l.Max {
+		delete(l.served, mac.String())
+	}
+	l.mu.Unlock()
+	if n < l.Max {
+		return
+	}
+
+	if err := l.Disabler.DisableNetboot(ctx, mac); err != nil {
+		l.Log.Info("unable to disable netboot after the serve limit", "mac", mac, "served", what, "limit", l.Max, "error", err)
+		return
+	}
+	l.Log.Info("netboot disabled after the serve limit", "mac", mac, "served", what, "limit", l.Max)
+}
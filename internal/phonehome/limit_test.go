@@ -0,0 +1,45 @@
+package phonehome
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestServeLimit(t *testing.T) {
+	d := &disabler{}
+	l := &ServeLimit{Max: 2, Disabler: d, Log: logr.Discard()}
+	ctx := context.Background()
+
+	l.ScriptServed(ctx, mac, "auto.ipxe")
+	if len(d.disabled) != 0 {
+		t.Fatal("expected netboot to be enabled before the limit")
+	}
+	// a phone home resets the count.
+	l.BootCompleted(ctx, mac)
+	l.ISOServed(ctx, mac)
+	if len(d.disabled) != 0 {
+		t.Fatal("expected a phone home to reset the count")
+	}
+	l.ScriptServed(ctx, mac, "auto.ipxe")
+	if len(d.disabled) != 1 {
+		t.Fatalf("got netboot disabled %d times, want once at the limit", len(d.disabled))
+	}
+	// the count starts again after netboot is disabled, for a machine that is allowed to netboot again.
+	l.ScriptServed(ctx, mac, "auto.ipxe")
+	if len(d.disabled) != 1 {
+		t.Fatalf("got netboot disabled %d times, want once", len(d.disabled))
+	}
+}
+
+func TestServeLimitDisabled(t *testing.T) {
+	d := &disabler{}
+	l := &ServeLimit{Disabler: d, Log: logr.Discard()}
+	for range 3 {
+		l.ScriptServed(context.Background(), mac, "auto.ipxe")
+	}
+	if len(d.disabled) != 0 {
+		t.Fatal("expected no limit when Max is 0")
+	}
+}
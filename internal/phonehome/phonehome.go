@@ -0,0 +1,82 @@
+// Package phonehome serves the endpoint that HookOS, or the installed OS, calls at the end of provisioning
+// to signal that a machine booted successfully, and disables the netboot of machines that were provisioned.
+package phonehome
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+var errNotFound = errors.New("machine not found")
+
+// Observer is notified when a machine signals that it booted successfully.
+// ctx is the HTTP request context, implementations doing background work must not depend on it.
+type Observer interface {
+	BootCompleted(ctx context.Context, mac net.HardwareAddr)
+}
+
+// Disabler disables the netboot of a machine in the backend.
+type Disabler interface {
+	DisableNetboot(ctx context.Context, mac net.HardwareAddr) error
+}
+
+// Handler serves the phone home requests of machines, the token in the request path identifies the machine.
+type Handler struct {
+	Tokens *Tokens
+	// Observers are notified of the machines that booted successfully.
+	Observers []Observer
+	// Disabler, when set, disables the netboot of the machines that booted successfully,
+	// so that they boot from their disk from then on.
+	Disabler Disabler
+	Log      logr.Logger
+}
+
+// HandlerFunc returns a http.HandlerFunc for the phone home requests.
+// It is expected that the request path is /phone-home/<token>, see Tokens.Token.
+//
+// POST marks the boot of the machine complete and responds with 204 No Content.
+func (h *Handler) HandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		labels := prometheus.Labels{"from": "http", "op": "phone-home"}
+		metric.JobsTotal.With(labels).Inc()
+		metric.JobsInProgress.With(labels).Inc()
+		defer metric.JobsInProgress.With(labels).Dec()
+		timer := prometheus.NewTimer(metric.JobDuration.With(labels))
+		defer timer.ObserveDuration()
+
+		mac, err := h.Tokens.MAC(path.Base(r.URL.Path), time.Now())
+		if err != nil {
+			h.Log.Info("rejected phone home request", "client", r.RemoteAddr, "error", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for _, o := range h.Observers {
+			o.BootCompleted(r.Context(), mac)
+		}
+		if h.Disabler != nil {
+			if err := h.Disabler.DisableNetboot(r.Context(), mac); err != nil {
+				h.Log.Info("unable to disable netboot", "mac", mac, "error", err)
+				status := http.StatusInternalServerError
+				if errors.Is(err, errNotFound) {
+					status = http.StatusNotFound
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+		}
+		h.Log.Info("machine booted successfully", "mac", mac, "netbootDisabled", h.Disabler != nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
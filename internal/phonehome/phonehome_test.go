@@ -0,0 +1,113 @@
+package phonehome
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+var mac = net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}
+
+func TestTokens(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tk := &Tokens{Key: []byte("key"), TTL: time.Hour}
+	token := tk.Token(mac, now)
+
+	got, err := tk.MAC(token, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != mac.String() {
+		t.Fatalf("got mac %s, want %s", got, mac)
+	}
+
+	tests := map[string]struct {
+		token string
+		now   time.Time
+		want  error
+	}{
+		"expired":        {token: token, now: now.Add(2 * time.Hour), want: errExpired},
+		"other key":      {token: (&Tokens{Key: []byte("other"), TTL: time.Hour}).Token(mac, now), now: now, want: errBadSignature},
+		"other mac":      {token: "3cecef4c4f55" + token[12:], now: now, want: errBadSignature},
+		"malformed":      {token: "3cecef4c4f54", now: now, want: errInvalidToken},
+		"invalid mac":    {token: "zz." + token[13:], now: now, want: errInvalidToken},
+		"invalid expiry": {token: "3cecef4c4f54.soon.abc", now: now, want: errInvalidToken},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := tk.MAC(tt.token, tt.now); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+type observer struct {
+	completed []string
+}
+
+func (o *observer) BootCompleted(_ context.Context, mac net.HardwareAddr) {
+	o.completed = append(o.completed, mac.String())
+}
+
+type disabler struct {
+	err      error
+	disabled []string
+}
+
+func (d *disabler) DisableNetboot(_ context.Context, mac net.HardwareAddr) error {
+	d.disabled = append(d.disabled, mac.String())
+	return d.err
+}
+
+func TestHandlerFunc(t *testing.T) {
+	tk := &Tokens{Key: []byte("key"), TTL: time.Hour}
+	tests := map[string]struct {
+		method       string
+		token        string
+		disabler     *disabler
+		wantCode     int
+		wantComplete bool
+	}{
+		"phone home":             {method: http.MethodPost, token: tk.Token(mac, time.Now()), wantCode: http.StatusNoContent, wantComplete: true},
+		"disable netboot":        {method: http.MethodPost, token: tk.Token(mac, time.Now()), disabler: &disabler{}, wantCode: http.StatusNoContent, wantComplete: true},
+		"hardware not found":     {method: http.MethodPost, token: tk.Token(mac, time.Now()), disabler: &disabler{err: errNotFound}, wantCode: http.StatusNotFound, wantComplete: true},
+		"disable netboot failed": {method: http.MethodPost, token: tk.Token(mac, time.Now()), disabler: &disabler{err: errors.New("boom")}, wantCode: http.StatusInternalServerError, wantComplete: true},
+		"expired token":          {method: http.MethodPost, token: tk.Token(mac, time.Now().Add(-2*time.Hour)), wantCode: http.StatusForbidden},
+		"invalid token":          {method: http.MethodPost, token: "3cecef4c4f54", wantCode: http.StatusForbidden},
+		"get":                    {method: http.MethodGet, token: tk.Token(mac, time.Now()), wantCode: http.StatusMethodNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := &observer{}
+			h := &Handler{Tokens: tk, Observers: []Observer{o}, Log: logr.Discard()}
+			if tt.disabler != nil {
+				h.Disabler = tt.disabler
+			}
+			w := httptest.NewRecorder()
+			h.HandlerFunc()(w, httptest.NewRequest(tt.method, "/phone-home/"+tt.token, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := len(o.completed) == 1; got != tt.wantComplete {
+				t.Fatalf("got boot completed %v, want %v", got, tt.wantComplete)
+			}
+			if tt.disabler != nil && len(tt.disabler.disabled) != 1 {
+				t.Fatal("expected netboot to be disabled")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package phonehome
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/macsig"
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errExpired      = macsig.ErrExpired
+	errBadSignature = macsig.ErrBadSignature
+)
+
+// Tokens creates and verifies expiring phone home tokens that are bound to a MAC address, so that a machine
+// can only signal the boot of itself. A token is <mac address>.<expiry unix time>.<signature>.
+type Tokens struct {
+	// Key is the HMAC key used to sign tokens.
+	Key []byte
+	// TTL is how long a token is valid for, it must outlast the provisioning of a machine.
+	TTL time.Duration
+}
+
+// Token returns a token for mac.
+func (t *Tokens) Token(mac net.HardwareAddr, now time.Time) string {
+	exp := macsig.Expires(now, t.TTL)
+
+	return hex.EncodeToString(mac) + "." + exp + "." + macsig.Sign(t.Key, mac, exp)
+}
+
+// MAC returns the MAC address of token, it is an error if token is not a valid, unexpired, token.
+func (t *Tokens) MAC(token string, now time.Time) (net.HardwareAddr, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	m, exp, sig := parts[0], parts[1], parts[2]
+	mac, err := hex.DecodeString(m)
+	if err != nil || len(mac) == 0 {
+		return nil, fmt.Errorf("%w: invalid mac address", errInvalidToken)
+	}
+	if err := macsig.Verify(t.Key, mac, exp, sig, now); err != nil {
+		if errors.Is(err, errBadSignature) || errors.Is(err, errExpired) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", errInvalidToken, err)
+	}
+
+	return net.HardwareAddr(mac), nil
+}
@@ -0,0 +1,79 @@
+package pluginhost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/plugin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// notFoundError is returned when the backend of a plugin has no record for a machine.
+type notFoundError struct{}
+
+func (notFoundError) NotFound() bool { return true }
+
+func (notFoundError) Error() string { return "hardware not found" }
+
+// Status() implements the APIStatus interface from apimachinery/pkg/api/errors
+// so that IsNotFound function could be used against this error type.
+func (notFoundError) Status() metav1.Status {
+	return metav1.Status{
+		Reason: metav1.StatusReasonNotFound,
+		Code:   http.StatusNotFound,
+	}
+}
+
+// Backend returns the backend of the plugin, it implements handler.BackendReader.
+func (c *Client) Backend() (*Backend, error) {
+	if !c.implements().Backend {
+		return nil, fmt.Errorf("plugin %s has no backend", c.path)
+	}
+
+	return &Backend{client: c}, nil
+}
+
+// Backend is the backend of a plugin.
+type Backend struct {
+	client *Client
+}
+
+// GetByMac implements handler.BackendReader.
+func (b *Backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup(ctx, "GetByMac", plugin.LookupArgs{MAC: mac, Deadline: deadline(ctx)})
+}
+
+// GetByIP implements handler.BackendReader.
+func (b *Backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return b.lookup(ctx, "GetByIP", plugin.LookupArgs{IP: ip, Deadline: deadline(ctx)})
+}
+
+func (b *Backend) lookup(ctx context.Context, method string, args plugin.LookupArgs) (*data.DHCP, *data.Netboot, error) {
+	var reply plugin.LookupReply
+	if err := b.client.call(ctx, method, args, &reply); err != nil {
+		return nil, nil, err
+	}
+	if reply.NotFound {
+		return nil, nil, notFoundError{}
+	}
+	if reply.DHCP == nil || reply.Netboot == nil {
+		return nil, nil, errors.New("the plugin backend returned no DHCP or netboot data")
+	}
+	d := data.DHCP(*reply.DHCP)
+	n := &data.Netboot{
+		AllowNetboot:  reply.Netboot.AllowNetboot,
+		IPXEScriptURL: reply.Netboot.IPXEScriptURL,
+		IPXEScript:    reply.Netboot.IPXEScript,
+		Console:       reply.Netboot.Console,
+		Facility:      reply.Netboot.Facility,
+		Profile:       reply.Netboot.Profile,
+		OSIE:          data.OSIE(reply.Netboot.OSIE),
+		Labels:        reply.Netboot.Labels,
+	}
+
+	return &d, n, nil
+}
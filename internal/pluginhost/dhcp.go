@@ -0,0 +1,93 @@
+package pluginhost
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/chaos"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/plugin"
+	"golang.org/x/net/ipv4"
+)
+
+// dhcpTimeout is how long the DHCP handler of a plugin has to handle a message.
+// DHCP clients retransmit, an answer later than this is of no use.
+const dhcpTimeout = 5 * time.Second
+
+// DHCPHandler returns the DHCP handler of the plugin, it implements server.Handler.
+func (c *Client) DHCPHandler() (*DHCPHandler, error) {
+	if !c.implements().DHCP {
+		return nil, fmt.Errorf("plugin %s has no DHCP handler", c.path)
+	}
+
+	return &DHCPHandler{client: c}, nil
+}
+
+// DHCPHandler is the DHCP handler of a plugin.
+type DHCPHandler struct {
+	client *Client
+
+	// DryRun, when set and true, handles DHCP messages as usual but doesn't send the replies.
+	DryRun *atomic.Bool
+
+	// Faults, when set, drops a percentage of the replies to test the resilience of provisioning.
+	Faults *chaos.Injector
+	// Observers are notified after a DHCP reply has been sent to a machine.
+	Observers []handler.Observer
+}
+
+// Handle implements server.Handler. The reply of the plugin, if any, is sent to the relay agent
+// when the message was relayed, otherwise to the peer.
+func (h *DHCPHandler) Handle(ctx context.Context, conn data.Conn, p data.Packet) {
+	if p.Pkt == nil {
+		return
+	}
+	log := h.client.log.WithValues("mac", p.Pkt.ClientHWAddr.String(), "xid", p.Pkt.TransactionID.String())
+	pkt := plugin.Packet{Message: p.Pkt.ToBytes()}
+	if p.Peer != nil {
+		pkt.Peer = p.Peer.String()
+	}
+	cm := &ipv4.ControlMessage{}
+	if p.Md != nil {
+		pkt.IfName = p.Md.IfName
+		pkt.IfIndex = p.Md.IfIndex
+		cm.IfIndex = p.Md.IfIndex
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dhcpTimeout)
+	defer cancel()
+	var reply plugin.DHCPReply
+	if err := h.client.call(ctx, "HandleDHCP", plugin.DHCPArgs{Packet: pkt, Deadline: deadline(ctx)}, &reply); err != nil {
+		log.Error(err, "plugin DHCP handler failed")
+		return
+	}
+	if len(reply.Reply) == 0 {
+		return
+	}
+
+	dst := p.Peer
+	if giaddr := p.Pkt.GatewayIPAddr; giaddr != nil && !giaddr.IsUnspecified() {
+		dst = &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}
+	}
+	if h.DryRun != nil && h.DryRun.Load() {
+		log.Info("dry run, DHCP response from plugin not sent", "destination", dst)
+		return
+	}
+	if h.Faults.DropDHCP() {
+		log.Info("fault injection, DHCP response from plugin dropped", "destination", dst)
+		return
+	}
+	if _, err := conn.WriteTo(reply.Reply, cm, dst); err != nil {
+		log.Error(err, "failed to send DHCP response", "destination", dst)
+		return
+	}
+	log.V(1).Info("sent DHCP response from plugin", "destination", dst)
+	if r, err := dhcpv4.FromBytes(reply.Reply); err == nil {
+		handler.Notify(ctx, h.Observers, r)
+	}
+}
@@ -0,0 +1,231 @@
+// Package pluginhost starts Smee plugins and adapts them to the backend, DHCP handler and script generator interfaces.
+// See the github.com/tinkerbell/smee/plugin package for the plugin side of the protocol.
+package pluginhost
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/tinkerbell/smee/plugin"
+)
+
+const (
+	// startTimeout is how long a plugin has to complete the go-plugin handshake.
+	startTimeout = 10 * time.Second
+	// restartDelay is how long Smee waits after a failed start before starting an exited plugin again.
+	restartDelay = 5 * time.Second
+)
+
+// Host starts plugins, one process per plugin executable, and stops them on Close.
+type Host struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// Client returns the client of the plugin executable at path, the plugin is started on first use.
+// A plugin that exits is restarted by its client on the next call.
+func (h *Host) Client(ctx context.Context, log logr.Logger, path string) (*Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.clients[path]; ok {
+		return c, nil
+	}
+	c, err := Start(ctx, log, path)
+	if err != nil {
+		return nil, err
+	}
+	if h.clients == nil {
+		h.clients = map[string]*Client{}
+	}
+	h.clients[path] = c
+
+	return c, nil
+}
+
+// Close stops all started plugins.
+func (h *Host) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for p, c := range h.clients {
+		c.Close()
+		delete(h.clients, p)
+	}
+}
+
+// Client is the connection to a running plugin.
+type Client struct {
+	path string
+	log  logr.Logger
+
+	mu     sync.Mutex
+	info   plugin.Info
+	plugin *goplugin.Client
+	rpc    *rpc.Client
+	// failed is when the last restart of the plugin failed.
+	failed time.Time
+	closed bool
+}
+
+// Start starts the plugin executable at path and connects to it.
+// The output of the plugin is logged to log.
+func Start(ctx context.Context, log logr.Logger, path string) (*Client, error) {
+	c := &Client{path: path, log: log.WithValues("plugin", path)}
+	if err := c.start(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// start starts the plugin process, c.mu must be held or c not shared yet.
+func (c *Client) start(ctx context.Context) error {
+	pc := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  plugin.Handshake,
+		Plugins:          goplugin.PluginSet{plugin.Name: rpcPlugin{}},
+		Cmd:              exec.Command(c.path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolNetRPC},
+		StartTimeout:     startTimeout,
+		Logger:           hclog.NewNullLogger(),
+		Stderr:           &logWriter{log: c.log},
+		SyncStdout:       &logWriter{log: c.log},
+		SyncStderr:       &logWriter{log: c.log},
+	})
+	proto, err := pc.Client()
+	if err != nil {
+		pc.Kill()
+		return fmt.Errorf("starting plugin %s: %w", c.path, err)
+	}
+	raw, err := proto.Dispense(plugin.Name)
+	if err != nil {
+		pc.Kill()
+		return fmt.Errorf("connecting to plugin %s: %w", c.path, err)
+	}
+	rc, ok := raw.(*rpc.Client)
+	if !ok {
+		pc.Kill()
+		return fmt.Errorf("plugin %s: unexpected client %T", c.path, raw)
+	}
+	var info plugin.Info
+	if err := call(ctx, c.path, rc, "Info", struct{}{}, &info); err != nil {
+		pc.Kill()
+		return err
+	}
+	c.plugin, c.rpc, c.info = pc, rc, info
+	c.log.Info("started plugin", "backend", info.Backend, "dhcpHandler", info.DHCP, "scriptGenerator", info.Script)
+
+	return nil
+}
+
+// implements returns what the plugin serves.
+func (c *Client) implements() plugin.Info {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.info
+}
+
+// conn returns the net/rpc client of the plugin. A plugin that exited is started again,
+// at most once per restartDelay when it fails to start.
+func (c *Client) conn(ctx context.Context) (*rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("plugin %s is stopped", c.path)
+	}
+	if !c.plugin.Exited() {
+		return c.rpc, nil
+	}
+	if time.Since(c.failed) < restartDelay {
+		return nil, fmt.Errorf("plugin %s exited and failed to restart", c.path)
+	}
+	c.log.Info("plugin exited, restarting it")
+	c.plugin.Kill()
+	if err := c.start(ctx); err != nil {
+		c.failed = time.Now()
+		return nil, err
+	}
+
+	return c.rpc, nil
+}
+
+// Close disconnects from the plugin and waits for it to exit, it is killed if it doesn't exit in time.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.plugin.Kill()
+}
+
+// call calls the method of the plugin, it returns early with ctx.Err() when ctx is done.
+func (c *Client) call(ctx context.Context, method string, args, reply any) error {
+	rc, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	return call(ctx, c.path, rc, method, args, reply)
+}
+
+func call(ctx context.Context, path string, rc *rpc.Client, method string, args, reply any) error {
+	call := rc.Go("Plugin."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return fmt.Errorf("plugin %s: %s: %w", path, method, call.Error)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("plugin %s: %s: %w", path, method, ctx.Err())
+	}
+}
+
+// rpcPlugin is the go-plugin plugin of the host side, its client is the net/rpc client of the plugin.
+type rpcPlugin struct{}
+
+// Server implements goplugin.Plugin, plugins are not served by Smee.
+func (rpcPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return nil, errors.New("plugins are served by plugin.Serve")
+}
+
+// Client implements goplugin.Plugin.
+func (rpcPlugin) Client(_ *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return c, nil
+}
+
+// deadline returns the deadline of ctx, or the zero time when it has none.
+func deadline(ctx context.Context) time.Time {
+	d, _ := ctx.Deadline()
+	return d
+}
+
+// logWriter logs every line written to it, it is the output of a plugin.
+type logWriter struct {
+	log logr.Logger
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.Info("plugin output", "line", string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
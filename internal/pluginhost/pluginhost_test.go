@@ -0,0 +1,199 @@
+package pluginhost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/ipxe/script"
+	"github.com/tinkerbell/smee/plugin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// helperEnv makes the test binary run as a plugin, its value is the kind of plugin.
+const helperEnv = "SMEE_PLUGIN_TEST_HELPER"
+
+var (
+	known = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	// crash makes the plugin exit when it is looked up.
+	crash = net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x03}
+)
+
+type testBackend struct{}
+
+func (testBackend) GetByMac(_ context.Context, mac net.HardwareAddr) (*plugin.DHCP, *plugin.Netboot, error) {
+	switch mac.String() {
+	case known.String():
+		return &plugin.DHCP{MACAddress: mac, IPAddress: netip.MustParseAddr("192.168.2.10"), Arch: "x86_64"},
+			&plugin.Netboot{AllowNetboot: true, Facility: "onprem", OSIE: plugin.OSIE{Kernel: "vmlinuz"}}, nil
+	case "00:00:00:00:00:01":
+		return nil, nil, errors.New("inventory unavailable")
+	case crash.String():
+		os.Exit(1)
+	}
+
+	return nil, nil, plugin.ErrNotFound
+}
+
+func (testBackend) GetByIP(context.Context, net.IP) (*plugin.DHCP, *plugin.Netboot, error) {
+	return nil, nil, plugin.ErrNotFound
+}
+
+type testScript struct{}
+
+func (testScript) Script(_ context.Context, r plugin.ScriptRequest) (string, error) {
+	return fmt.Sprintf("#!ipxe\necho %s %s\n", r.MAC, r.Facility), nil
+}
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "all":
+		err := plugin.Serve(plugin.Plugins{Backend: testBackend{}, Script: testScript{}})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "bad-version":
+		fmt.Println("1|99|unix|/nonexistent|netrpc")
+		select {}
+	}
+	os.Exit(0)
+}
+
+func startHelper(t *testing.T, kind string) (*Client, error) {
+	t.Helper()
+	t.Setenv(helperEnv, kind)
+	return Start(context.Background(), logr.Discard(), os.Args[0])
+}
+
+func TestBackend(t *testing.T) {
+	c, err := startHelper(t, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	b, err := c.Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, n, err := b.GetByMac(context.Background(), known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantD := &data.DHCP{MACAddress: known, IPAddress: netip.MustParseAddr("192.168.2.10"), Arch: "x86_64"}
+	if diff := cmp.Diff(wantD, d, cmpopts.EquateComparable(netip.Addr{})); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(&data.Netboot{AllowNetboot: true, Facility: "onprem", OSIE: data.OSIE{Kernel: "vmlinuz"}}, n); diff != "" {
+		t.Fatal(diff)
+	}
+
+	_, _, err = b.GetByMac(context.Background(), net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x02})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("got %v, want a not found error", err)
+	}
+	_, _, err = b.GetByMac(context.Background(), net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01})
+	if err == nil || !strings.Contains(err.Error(), "inventory unavailable") {
+		t.Fatalf("got %v, want the error of the plugin", err)
+	}
+}
+
+func TestScriptGenerator(t *testing.T) {
+	c, err := startHelper(t, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	g, err := c.ScriptGenerator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := g.GenerateScript(context.Background(), script.GenerateRequest{MAC: known, Facility: "onprem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("#!ipxe\necho 00:01:02:03:04:05 onprem\n", got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestMissingImplementation(t *testing.T) {
+	c, err := startHelper(t, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.DHCPHandler(); err == nil {
+		t.Fatal("expected an error for a plugin without a DHCP handler")
+	}
+}
+
+func TestBadVersion(t *testing.T) {
+	_, err := startHelper(t, "bad-version")
+	if err == nil || !strings.Contains(err.Error(), "Plugin version: 99") {
+		t.Fatalf("got %v, want a protocol version error", err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	t.Setenv(helperEnv, "all")
+	h := &Host{}
+	defer h.Close()
+	a, err := h.Client(context.Background(), logr.Discard(), os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := h.Client(context.Background(), logr.Discard(), os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatal("expected one plugin process per executable")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	t.Setenv(helperEnv, "all")
+	h := &Host{}
+	defer h.Close()
+	c, err := h.Client(context.Background(), logr.Discard(), os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.GetByMac(context.Background(), crash); err == nil {
+		t.Fatal("expected an error from a plugin that exits")
+	}
+	for start := time.Now(); !c.plugin.Exited(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the plugin didn't exit")
+		}
+	}
+
+	if _, _, err := b.GetByMac(context.Background(), known); err != nil {
+		t.Fatalf("got %v, want the plugin to be restarted", err)
+	}
+	again, err := h.Client(context.Background(), logr.Discard(), os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != c {
+		t.Fatal("expected the client of the restarted plugin")
+	}
+}
@@ -0,0 +1,33 @@
+package pluginhost
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tinkerbell/smee/internal/ipxe/script"
+	"github.com/tinkerbell/smee/plugin"
+)
+
+// ScriptGenerator returns the script generator of the plugin, it implements script.Generator.
+func (c *Client) ScriptGenerator() (*ScriptGenerator, error) {
+	if !c.implements().Script {
+		return nil, fmt.Errorf("plugin %s has no script generator", c.path)
+	}
+
+	return &ScriptGenerator{client: c}, nil
+}
+
+// ScriptGenerator is the script generator of a plugin.
+type ScriptGenerator struct {
+	client *Client
+}
+
+// GenerateScript implements script.Generator.
+func (g *ScriptGenerator) GenerateScript(ctx context.Context, r script.GenerateRequest) (string, error) {
+	var reply plugin.ScriptReply
+	if err := g.client.call(ctx, "Script", plugin.ScriptArgs{Request: plugin.ScriptRequest(r), Deadline: deadline(ctx)}, &reply); err != nil {
+		return "", err
+	}
+
+	return reply.Script, nil
+}
@@ -0,0 +1,282 @@
+// Package policy decides whether a machine is allowed to netboot, and what it boots, from an ordered list of rules.
+//
+// A policy is evaluated for every DHCP, iPXE script and ISO request. The first rule whose match
+// conditions all hold decides the request. When no rule matches, the backend's allowPXE value decides.
+//
+// A machine whose backend record has the WindowLabel label is only allowed to netboot in the named
+// maintenance window of the label, whatever the rules decide. Outside of it the machine boots from its local disk.
+//
+//	windows:
+//	  change-window:
+//	  - {days: [sat], start: "22:00", end: "04:00", timeZone: Europe/Berlin}
+//	  - {from: "2024-06-01T08:00:00Z", until: "2024-06-01T12:00:00Z"}
+//	rules:
+//	- name: deny-lab-after-hours
+//	  match:
+//	    subnets: ["192.168.2.0/24"]
+//	    notBetween: {start: "08:00", end: "18:00"}
+//	  action: deny
+//	- name: arm-to-custom-installer
+//	  match:
+//	    arch: ["arm64"]
+//	    labels: {tinkerbell.org/role: worker}
+//	  action: allow
+//	  bootTarget: http://192.168.2.5/arm64.ipxe
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Action is the outcome of a matching rule.
+type Action string
+
+const (
+	// Allow allows the machine to netboot.
+	Allow Action = "allow"
+	// Deny does not allow the machine to netboot.
+	Deny Action = "deny"
+)
+
+// Source is the subsystem a request was received by.
+type Source string
+
+const (
+	SourceDHCP   Source = "dhcp"
+	SourceScript Source = "script"
+	SourceISO    Source = "iso"
+)
+
+// Policy is an ordered list of rules.
+type Policy struct {
+	// Windows are the named maintenance windows that the WindowLabel label of machines refers to.
+	// A machine is in its maintenance window when the request is received in any of the windows of the name.
+	Windows map[string][]Window `json:"windows,omitempty"`
+	Rules   []Rule              `json:"rules"`
+}
+
+// Rule allows or denies the requests it matches.
+type Rule struct {
+	// Name identifies the rule in logs.
+	Name string `json:"name"`
+	// Match holds the conditions that must all hold for the rule to apply.
+	Match Match `json:"match"`
+	// Action is allow or deny.
+	Action Action `json:"action"`
+	// BootTarget is an optional iPXE script URL that allowed machines are sent to,
+	// instead of the default or backend defined iPXE script. It is not used by ISO requests.
+	BootTarget string `json:"bootTarget,omitempty"`
+
+	bootTarget *url.URL
+	subnets    []netip.Prefix
+}
+
+// Match holds rule conditions. Empty conditions always hold.
+type Match struct {
+	// Sources are the subsystems the rule applies to: dhcp, script or iso.
+	Sources []Source `json:"sources,omitempty"`
+	// MACs are MAC address glob patterns, for example "3c:ec:ef:*".
+	MACs []string `json:"macs,omitempty"`
+	// Arch are machine architectures, for example "x86_64" or "arm64".
+	Arch []string `json:"arch,omitempty"`
+	// Subnets are CIDRs the machine IP address must be in.
+	Subnets []string `json:"subnets,omitempty"`
+	// Labels must all be set, with the same value, on the backend record of the machine.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Between is a time window the request must be received in.
+	Between *Window `json:"between,omitempty"`
+	// NotBetween is a time window the request must not be received in.
+	NotBetween *Window `json:"notBetween,omitempty"`
+}
+
+// Request holds the attributes of a request that rules match on.
+type Request struct {
+	Source Source
+	MAC    net.HardwareAddr
+	Arch   string
+	IP     netip.Addr
+	Labels map[string]string
+	Time   time.Time
+	// AllowNetboot is the backend's allowPXE value, it decides the request when no rule matches.
+	AllowNetboot bool
+}
+
+// Decision is the result of evaluating a Policy.
+type Decision struct {
+	Allow bool
+	// Rule is the name of the matching rule, empty when no rule matched.
+	Rule string
+	// BootTarget is the iPXE script URL of the matching rule, if any.
+	BootTarget *url.URL
+}
+
+// Load reads and validates a YAML, or JSON, policy file.
+func Load(file string) (*Policy, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, policy.
+func Parse(b []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	var errs []error
+	for name, ws := range p.Windows {
+		for i, w := range ws {
+			if _, err := w.parse(); err != nil {
+				errs = append(errs, fmt.Errorf("window %s %d: %w", name, i, err))
+			}
+		}
+	}
+	for i := range p.Rules {
+		if err := p.Rules[i].validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d (%s): %w", i, p.Rules[i].Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return p, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Action != Allow && r.Action != Deny {
+		return fmt.Errorf("invalid action %q, must be %q or %q", r.Action, Allow, Deny)
+	}
+	if r.BootTarget != "" {
+		u, err := url.ParseRequestURI(r.BootTarget)
+		if err != nil {
+			return fmt.Errorf("invalid bootTarget: %w", err)
+		}
+		r.bootTarget = u
+	}
+	for _, s := range r.Match.Sources {
+		if s != SourceDHCP && s != SourceScript && s != SourceISO {
+			return fmt.Errorf("invalid source %q", s)
+		}
+	}
+	for _, m := range r.Match.MACs {
+		if _, err := path.Match(strings.ToLower(m), ""); err != nil {
+			return fmt.Errorf("invalid mac pattern %q: %w", m, err)
+		}
+	}
+	r.subnets = nil
+	for _, s := range r.Match.Subnets {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("invalid subnet: %w", err)
+		}
+		r.subnets = append(r.subnets, p.Masked())
+	}
+	for _, w := range []*Window{r.Match.Between, r.Match.NotBetween} {
+		if w == nil {
+			continue
+		}
+		if _, err := w.parse(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Evaluate returns the decision of the first rule that matches req.
+// A nil Policy, or no matching rule, decides by req.AllowNetboot.
+// A machine outside of the maintenance window of its WindowLabel label is denied, with the rule "maintenance-window/<name>".
+// A zero req.Time is the current time.
+func (p *Policy) Evaluate(req Request) Decision {
+	if req.Time.IsZero() {
+		req.Time = time.Now()
+	}
+	if p != nil {
+		if name, ok := req.Labels[WindowLabel]; ok && !p.inWindow(name, req.Time) {
+			return Decision{Allow: false, Rule: "maintenance-window/" + name}
+		}
+		for _, r := range p.Rules {
+			if r.matches(req) {
+				return Decision{Allow: r.Action == Allow, Rule: r.Name, BootTarget: r.bootTarget}
+			}
+		}
+	}
+
+	return Decision{Allow: req.AllowNetboot}
+}
+
+func (r Rule) matches(req Request) bool {
+	m := r.Match
+	if len(m.Sources) > 0 && !contains(m.Sources, req.Source) {
+		return false
+	}
+	if len(m.MACs) > 0 && !macMatches(m.MACs, req.MAC) {
+		return false
+	}
+	if len(m.Arch) > 0 && !contains(m.Arch, req.Arch) {
+		return false
+	}
+	if len(r.subnets) > 0 && !inSubnets(r.subnets, req.IP) {
+		return false
+	}
+	for k, v := range m.Labels {
+		if got, ok := req.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	if m.Between != nil && !m.Between.contains(req.Time) {
+		return false
+	}
+	if m.NotBetween != nil && m.NotBetween.contains(req.Time) {
+		return false
+	}
+
+	return true
+}
+
+func contains[T comparable](s []T, v T) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func macMatches(patterns []string, mac net.HardwareAddr) bool {
+	if mac == nil {
+		return false
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), mac.String()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func inSubnets(subnets []netip.Prefix, ip netip.Addr) bool {
+	for _, s := range subnets {
+		if ip.IsValid() && s.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,208 @@
+package policy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testPolicy = `
+rules:
+- name: deny-after-hours
+  match:
+    subnets: ["192.168.2.0/24"]
+    notBetween: {start: "08:00", end: "18:00"}
+  action: deny
+- name: workers-to-installer
+  match:
+    sources: [dhcp, script]
+    arch: [arm64]
+    labels: {tinkerbell.org/role: worker}
+  action: allow
+  bootTarget: http://192.168.2.5/arm64.ipxe
+- name: lab-macs
+  match:
+    macs: ["3C:EC:EF:*"]
+  action: allow
+`
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	night := time.Date(2024, 1, 1, 22, 0, 0, 0, time.Local)
+	tests := map[string]struct {
+		req            Request
+		want           Decision
+		wantBootTarget string
+	}{
+		"after hours in subnet": {
+			req:  Request{Source: SourceDHCP, IP: netip.MustParseAddr("192.168.2.10"), Time: night, AllowNetboot: true},
+			want: Decision{Allow: false, Rule: "deny-after-hours"},
+		},
+		"working hours in subnet falls through to backend": {
+			req:  Request{Source: SourceDHCP, IP: netip.MustParseAddr("192.168.2.10"), Time: day, AllowNetboot: true},
+			want: Decision{Allow: true},
+		},
+		"worker label with boot target": {
+			req:            Request{Source: SourceScript, Arch: "arm64", Labels: map[string]string{"tinkerbell.org/role": "worker"}, Time: day},
+			want:           Decision{Allow: true, Rule: "workers-to-installer"},
+			wantBootTarget: "http://192.168.2.5/arm64.ipxe",
+		},
+		"worker label from iso source does not match": {
+			req:  Request{Source: SourceISO, Arch: "arm64", Labels: map[string]string{"tinkerbell.org/role": "worker"}, Time: day},
+			want: Decision{Allow: false},
+		},
+		"mac glob is case insensitive": {
+			req:  Request{Source: SourceISO, MAC: net.HardwareAddr{0x3c, 0xec, 0xef, 0x4c, 0x4f, 0x54}, Time: day},
+			want: Decision{Allow: true, Rule: "lab-macs"},
+		},
+		"no match uses backend": {
+			req:  Request{Source: SourceDHCP, MAC: net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01}, Time: day},
+			want: Decision{Allow: false},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := p.Evaluate(tt.req)
+			var gotBootTarget string
+			if got.BootTarget != nil {
+				gotBootTarget = got.BootTarget.String()
+			}
+			got.BootTarget = nil
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+			if gotBootTarget != tt.wantBootTarget {
+				t.Fatalf("boot target: got %q, want %q", gotBootTarget, tt.wantBootTarget)
+			}
+		})
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var p *Policy
+	if got := p.Evaluate(Request{AllowNetboot: true}); !got.Allow {
+		t.Fatal("expected a nil policy to use the backend value")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad action":      "rules: [{name: a, action: maybe}]",
+		"bad subnet":      "rules: [{name: a, action: allow, match: {subnets: [nope]}}]",
+		"bad source":      "rules: [{name: a, action: allow, match: {sources: [tftp]}}]",
+		"bad window":      "rules: [{name: a, action: allow, match: {between: {start: '8am', end: '18:00'}}}]",
+		"bad boot target": "rules: [{name: a, action: allow, bootTarget: 'not a url'}]",
+		"bad mac pattern": "rules: [{name: a, action: allow, match: {macs: ['[']}}]",
+		"empty window":    "rules: [{name: a, action: allow, match: {between: {}}}]",
+		"bad window day":  "windows: {w: [{days: [someday]}]}",
+		"bad time zone":   "windows: {w: [{days: [mon], timeZone: Mars/Olympus}]}",
+		"bad window from": "windows: {w: [{from: '2024-06-01 08:00'}]}",
+		"until not after": "windows: {w: [{from: '2024-06-01T08:00:00Z', until: '2024-06-01T08:00:00Z'}]}",
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(in)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	w := Window{Start: "22:00", End: "06:00"}
+	at := func(h int) time.Time { return time.Date(2024, 1, 1, h, 0, 0, 0, time.Local) }
+	if !w.contains(at(23)) || !w.contains(at(2)) {
+		t.Fatal("expected window wrapping midnight to contain 23:00 and 02:00")
+	}
+	if w.contains(at(12)) {
+		t.Fatal("expected window wrapping midnight to not contain 12:00")
+	}
+}
+
+func TestWindowContainsDays(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 2024-06-01 is a Saturday.
+	at := func(day, h int) time.Time { return time.Date(2024, 6, day, h, 0, 0, 0, berlin) }
+	tests := map[string]struct {
+		w    Window
+		t    time.Time
+		want bool
+	}{
+		"weekday range":                    {w: Window{Days: []string{"mon-fri"}}, t: at(3, 12), want: true},
+		"weekend outside range":            {w: Window{Days: []string{"mon-fri"}}, t: at(1, 12)},
+		"range wrapping the week":          {w: Window{Days: []string{"Fri-Mon"}}, t: at(2, 12), want: true},
+		"after midnight of the day":        {w: Window{Days: []string{"sat"}, Start: "22:00", End: "04:00", TimeZone: "Europe/Berlin"}, t: at(2, 3), want: true},
+		"after midnight of the day before": {w: Window{Days: []string{"sat"}, Start: "22:00", End: "04:00", TimeZone: "Europe/Berlin"}, t: at(1, 3)},
+		"time zone":                        {w: Window{Start: "08:00", End: "09:00", TimeZone: "UTC"}, t: at(1, 10), want: true},
+		"in absolute range":                {w: Window{From: "2024-06-01T08:00:00Z", Until: "2024-06-01T12:00:00Z"}, t: at(1, 12), want: true},
+		"after absolute range":             {w: Window{From: "2024-06-01T08:00:00Z", Until: "2024-06-01T12:00:00Z"}, t: at(1, 14)},
+		"open ended range":                 {w: Window{From: "2024-06-01T08:00:00Z"}, t: at(30, 0), want: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.w.contains(tt.t); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	p, err := Parse([]byte(`
+windows:
+  change-window:
+  - {days: [sat], start: "22:00", end: "04:00"}
+  - {from: "2024-06-05T08:00:00Z", until: "2024-06-05T12:00:00Z"}
+rules:
+- name: allow-all
+  action: allow
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := func(window string) map[string]string { return map[string]string{WindowLabel: window} }
+	tests := map[string]struct {
+		req  Request
+		want Decision
+	}{
+		"in daily window": {
+			req:  Request{Labels: labels("change-window"), Time: time.Date(2024, 6, 1, 23, 0, 0, 0, time.Local)},
+			want: Decision{Allow: true, Rule: "allow-all"},
+		},
+		"in absolute window": {
+			req:  Request{Labels: labels("change-window"), Time: time.Date(2024, 6, 5, 9, 0, 0, 0, time.UTC)},
+			want: Decision{Allow: true, Rule: "allow-all"},
+		},
+		"outside of window": {
+			req:  Request{Labels: labels("change-window"), Time: time.Date(2024, 6, 3, 23, 0, 0, 0, time.Local), AllowNetboot: true},
+			want: Decision{Allow: false, Rule: "maintenance-window/change-window"},
+		},
+		"unknown window": {
+			req:  Request{Labels: labels("nope"), Time: time.Date(2024, 6, 1, 23, 0, 0, 0, time.Local), AllowNetboot: true},
+			want: Decision{Allow: false, Rule: "maintenance-window/nope"},
+		},
+		"no label": {
+			req:  Request{Time: time.Date(2024, 6, 3, 23, 0, 0, 0, time.Local)},
+			want: Decision{Allow: true, Rule: "allow-all"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := p.Evaluate(tt.req)
+			got.BootTarget = nil
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WindowLabel is the backend record label that names the maintenance window of a machine,
+// one of the windows of the policy. Outside of its window the machine is not allowed to netboot.
+const WindowLabel = "smee.tinkerbell.org/maintenance-window"
+
+// Window is a time window that a request is received in. Its conditions must all hold:
+//
+//   - Start and End are a daily time of day window in 24 hour "15:04" format. A window with End before Start wraps around midnight.
+//   - Days are the days of the week, "mon" to "sun", or ranges of them like "mon-fri". A window that wraps around midnight
+//     belongs to the day it starts on.
+//   - From and Until are an absolute range in RFC 3339 format, either one can be left out for an open ended range.
+//
+// TimeZone is the IANA time zone of Start, End and Days, for example "Europe/Berlin". It is local time when empty.
+type Window struct {
+	Start    string   `json:"start,omitempty"`
+	End      string   `json:"end,omitempty"`
+	Days     []string `json:"days,omitempty"`
+	From     string   `json:"from,omitempty"`
+	Until    string   `json:"until,omitempty"`
+	TimeZone string   `json:"timeZone,omitempty"`
+}
+
+// weekdays are the day names of Window.Days.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// window is a parsed Window.
+type window struct {
+	daily       bool
+	start, end  time.Duration
+	days        [7]bool
+	anyDay      bool
+	from, until time.Time
+	loc         *time.Location
+}
+
+func (w Window) parse() (window, error) {
+	p := window{loc: time.Local, anyDay: len(w.Days) == 0}
+	if w.Start == "" && w.End == "" && len(w.Days) == 0 && w.From == "" && w.Until == "" {
+		return p, errors.New("empty window, it must have start and end, days, from or until")
+	}
+	if w.TimeZone != "" {
+		loc, err := time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return p, fmt.Errorf("invalid window time zone %q: %w", w.TimeZone, err)
+		}
+		p.loc = loc
+	}
+	if w.Start != "" || w.End != "" {
+		var err error
+		if p.start, p.end, err = w.bounds(); err != nil {
+			return p, err
+		}
+		p.daily = true
+	}
+	for _, d := range w.Days {
+		first, last, _ := strings.Cut(strings.ToLower(d), "-")
+		if last == "" {
+			last = first
+		}
+		f, l := dayIndex(first), dayIndex(last)
+		if f < 0 || l < 0 {
+			return p, fmt.Errorf("invalid window day %q, must be one of %s, or a range of them", d, strings.Join(weekdays, ", "))
+		}
+		for i := f; ; i = (i + 1) % 7 {
+			p.days[i] = true
+			if i == l {
+				break
+			}
+		}
+	}
+	for _, b := range []struct {
+		v string
+		t *time.Time
+	}{{w.From, &p.from}, {w.Until, &p.until}} {
+		if b.v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, b.v)
+		if err != nil {
+			return p, fmt.Errorf("invalid window time %q: %w", b.v, err)
+		}
+		*b.t = t
+	}
+	if !p.from.IsZero() && !p.until.IsZero() && !p.until.After(p.from) {
+		return p, fmt.Errorf("invalid window, until %q is not after from %q", w.Until, w.From)
+	}
+
+	return p, nil
+}
+
+func dayIndex(d string) int {
+	for i, wd := range weekdays {
+		if d == wd {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// bounds returns the window start and end as durations since midnight.
+func (w Window) bounds() (time.Duration, time.Duration, error) {
+	s, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window start %q: %w", w.Start, err)
+	}
+	e, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window end %q: %w", w.End, err)
+	}
+
+	return sinceMidnight(s), sinceMidnight(e), nil
+}
+
+func (w Window) contains(t time.Time) bool {
+	p, err := w.parse()
+	if err != nil {
+		return false
+	}
+	if !p.from.IsZero() && t.Before(p.from) {
+		return false
+	}
+	if !p.until.IsZero() && !t.Before(p.until) {
+		return false
+	}
+	t = t.In(p.loc)
+	day := t.Weekday()
+	if p.daily {
+		now := sinceMidnight(t)
+		switch {
+		case p.end < p.start && now < p.end:
+			// the part after midnight of the window that started the day before.
+			day = (day + 6) % 7
+		case p.end < p.start && now >= p.start:
+		case now < p.start || now >= p.end:
+			return false
+		}
+	}
+
+	return p.anyDay || p.days[day]
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+}
+
+// inWindow reports whether t is in any of the windows of the maintenance window name.
+// A name that is not a window of the policy is never in its window.
+func (p *Policy) inWindow(name string, t time.Time) bool {
+	for _, w := range p.Windows[name] {
+		if w.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
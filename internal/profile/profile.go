@@ -0,0 +1,264 @@
+// Package profile gives specialized clients, like thin clients, appliances and rescue media, distinct boot settings
+// by the user class (DHCP option 77) or vendor class (DHCP option 60) of their DHCP messages, or by the profile name
+// in their backend record, like a burn-in profile that boots machines into a stress image.
+//
+// The first profile whose match conditions all hold applies. The profile that a machine matched is remembered
+// for the TTL, so that the later DHCP and iPXE script requests of its boot chain, that are sent by iPXE with
+// its own user and vendor class, get the same profile.
+//
+//	profiles:
+//	- name: thin-client
+//	  match:
+//	    userClass: ["HPThin*"]
+//	  binary: ipxe-thin.efi
+//	  osieURL: http://10.1.0.5:8080/hook-thin
+//	- name: rescue
+//	  match:
+//	    vendorClass: ["PXEClient:Arch:00007:UNDI:003016"]
+//	    userClass: ["rescue"]
+//	  scriptURL: http://10.1.0.5:8080/rescue.ipxe
+//	- name: burnin
+//	  osieURL: http://10.1.0.5:8080/burnin
+//	  kernelArgs: ["burnin_duration=4h"]
+//	  workflow: false
+//	- name: esxi
+//	  match:
+//	    userClass: ["esxi"]
+//	  esxi: true
+//	  kernelArgs: ["ks=http://10.1.0.5:8080/ks/{{ .Hostname }}.cfg"]
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// DefaultTTL is how long the profile that a machine matched is remembered.
+const DefaultTTL = 30 * time.Minute
+
+// Config holds the ordered boot profiles.
+type Config struct {
+	Profiles []Profile `json:"profiles"`
+	// TTL is how long the profile that a machine matched is remembered. The default is DefaultTTL.
+	TTL time.Duration `json:"-"`
+
+	mu     sync.Mutex
+	seen   map[string]seen
+	pruned time.Time
+}
+
+type seen struct {
+	profile int
+	time    time.Time
+}
+
+// Profile holds the boot settings of the clients it matches. Empty settings are not overridden.
+type Profile struct {
+	// Name identifies the profile in logs.
+	Name string `json:"name"`
+	// Match holds the conditions that must all hold for the profile to apply.
+	Match Match `json:"match"`
+	// ScriptURL is the iPXE script URL that is sent in DHCP, in place of the auto.ipxe script.
+	// The iPXE script URL in the backend record of a machine takes precedence.
+	ScriptURL string `json:"scriptURL,omitempty"`
+	// Binary is the iPXE binary that is sent in DHCP, in place of the binary of the client architecture.
+	Binary string `json:"binary,omitempty"`
+	// OSIEURL is the URL where the OSIE (HookOS) images of the auto.ipxe script are located.
+	OSIEURL string `json:"osieURL,omitempty"`
+	// KernelArgs replace the extra kernel args of the auto.ipxe script, they are templates like the extra kernel args.
+	KernelArgs []string `json:"kernelArgs,omitempty"`
+	// Workflow is whether the auto.ipxe script hands the machine off to a Tink workflow. When false, the Tink server
+	// kernel args are left out, so that the OSIE runs on its own. The default is true.
+	Workflow *bool `json:"workflow,omitempty"`
+	// ESXi is whether the profile netboots the VMware ESXi installer: UEFI clients are sent mboot.efi in place of the
+	// iPXE binary and KernelArgs are appended to the kernel options of their boot.cfg.
+	ESXi bool `json:"esxi,omitempty"`
+
+	scriptURL *url.URL
+}
+
+// Match holds profile conditions. Empty conditions always hold, a profile without any condition is only selected by
+// the backend record of a machine.
+type Match struct {
+	// UserClass are glob patterns of the DHCP option 77 user class, for example "HPThin*".
+	UserClass []string `json:"userClass,omitempty"`
+	// VendorClass are glob patterns of the DHCP option 60 vendor class identifier, for example "PXEClient:Arch:00007:*".
+	VendorClass []string `json:"vendorClass,omitempty"`
+}
+
+// Load reads and validates a YAML, or JSON, profiles file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, profiles config.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse boot profiles: %w", err)
+	}
+	var errs []error
+	names := map[string]bool{}
+	for i := range c.Profiles {
+		p := &c.Profiles[i]
+		if err := p.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("profile %d (%s): %w", i, p.Name, err))
+		}
+		if names[p.Name] {
+			errs = append(errs, fmt.Errorf("profile %d: duplicate name %q", i, p.Name))
+		}
+		names[p.Name] = true
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func (p *Profile) validate() error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	for _, m := range append(append([]string{}, p.Match.UserClass...), p.Match.VendorClass...) {
+		if _, err := path.Match(m, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", m, err)
+		}
+	}
+	if p.ScriptURL != "" {
+		u, err := url.ParseRequestURI(p.ScriptURL)
+		if err != nil {
+			return fmt.Errorf("invalid scriptURL: %w", err)
+		}
+		p.scriptURL = u
+	}
+	if p.OSIEURL != "" {
+		if _, err := url.ParseRequestURI(p.OSIEURL); err != nil {
+			return fmt.Errorf("invalid osieURL: %w", err)
+		}
+	}
+	if p.ESXi && (p.Binary != "" || p.ScriptURL != "") {
+		return errors.New("esxi can't be combined with binary or scriptURL, mboot.efi is sent in place of iPXE")
+	}
+
+	return nil
+}
+
+// IPXEScriptURL returns the parsed ScriptURL, nil when it is not set.
+func (p Profile) IPXEScriptURL() *url.URL {
+	return p.scriptURL
+}
+
+// NoWorkflow returns whether the profile doesn't hand machines off to a Tink workflow.
+func (p Profile) NoWorkflow() bool {
+	return p.Workflow != nil && !*p.Workflow
+}
+
+func (p Profile) matches(userClass, vendorClass string) bool {
+	if len(p.Match.UserClass) == 0 && len(p.Match.VendorClass) == 0 {
+		return false
+	}
+
+	return matchAny(p.Match.UserClass, userClass) && matchAny(p.Match.VendorClass, vendorClass)
+}
+
+// matchAny returns whether s matches one of the patterns, it is true when there are no patterns.
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Select returns the profile of the DHCP client mac: the first profile that matches its user class and vendor class,
+// which is then remembered for the TTL, or else the profile that mac last matched. ok is false for a nil Config or
+// a client without a profile.
+func (c *Config) Select(mac net.HardwareAddr, userClass, vendorClass string) (p Profile, ok bool) {
+	if c == nil {
+		return Profile{}, false
+	}
+	for i, p := range c.Profiles {
+		if p.matches(userClass, vendorClass) {
+			c.remember(mac, i)
+			return p, true
+		}
+	}
+
+	return c.Get(mac)
+}
+
+// Named returns the profile with the name, which the backend record of a machine selects. ok is false for a nil
+// Config or when there is no such profile.
+func (c *Config) Named(name string) (p Profile, ok bool) {
+	if c == nil {
+		return Profile{}, false
+	}
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return Profile{}, false
+}
+
+// Get returns the profile that mac last matched. ok is false when it matched none within the TTL.
+func (c *Config) Get(mac net.HardwareAddr) (p Profile, ok bool) {
+	if c == nil {
+		return Profile{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, found := c.seen[mac.String()]
+	if !found || time.Since(s.time) > c.ttl() {
+		return Profile{}, false
+	}
+
+	return c.Profiles[s.profile], true
+}
+
+func (c *Config) remember(mac net.HardwareAddr, profile int) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = map[string]seen{}
+	}
+	// expired profiles are pruned at most once per TTL.
+	if now.Sub(c.pruned) > c.ttl() {
+		for k, s := range c.seen {
+			if now.Sub(s.time) > c.ttl() {
+				delete(c.seen, k)
+			}
+		}
+		c.pruned = now
+	}
+	c.seen[mac.String()] = seen{profile: profile, time: now}
+}
+
+func (c *Config) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+
+	return DefaultTTL
+}
@@ -0,0 +1,118 @@
+package profile
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+profiles:
+- name: thin-client
+  match:
+    userClass: ["HPThin*"]
+  binary: ipxe-thin.efi
+  osieURL: http://10.1.0.5:8080/hook-thin
+- name: rescue
+  match:
+    vendorClass: ["PXEClient:Arch:00007:*"]
+    userClass: ["rescue"]
+  scriptURL: http://10.1.0.5:8080/rescue.ipxe
+- name: burnin
+  osieURL: http://10.1.0.5:8080/burnin
+  kernelArgs: ["burnin_duration=4h"]
+  workflow: false
+`
+
+func TestSelect(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thin := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	rescue := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}
+
+	if p, ok := c.Select(thin, "HPThinPro", "PXEClient:Arch:00007:UNDI:003016"); !ok || p.Name != "thin-client" {
+		t.Fatalf("got profile %q (%v), want thin-client", p.Name, ok)
+	}
+	// the iPXE stage of the boot chain sends its own user class, the remembered profile applies.
+	if p, ok := c.Select(thin, "iPXE", "PXEClient:Arch:00007:UNDI:003016"); !ok || p.Name != "thin-client" {
+		t.Fatalf("got profile %q (%v) for the iPXE stage, want the remembered thin-client", p.Name, ok)
+	}
+	if p, ok := c.Get(thin); !ok || p.OSIEURL != "http://10.1.0.5:8080/hook-thin" {
+		t.Fatalf("got profile %q (%v), want thin-client", p.Name, ok)
+	}
+
+	p, ok := c.Select(rescue, "rescue", "PXEClient:Arch:00007:UNDI:003016")
+	if !ok || p.Name != "rescue" {
+		t.Fatalf("got profile %q (%v), want rescue", p.Name, ok)
+	}
+	if u := p.IPXEScriptURL(); u == nil || u.String() != "http://10.1.0.5:8080/rescue.ipxe" {
+		t.Fatalf("got script URL %v, want http://10.1.0.5:8080/rescue.ipxe", u)
+	}
+	if _, ok := c.Select(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x07}, "rescue", "PXEClient:Arch:00000:UNDI:002001"); ok {
+		t.Fatal("expected no profile when only one of the conditions holds")
+	}
+
+	c.TTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, ok := c.Get(thin); ok {
+		t.Fatal("expected the remembered profile to expire after the TTL")
+	}
+
+	var nc *Config
+	if _, ok := nc.Select(thin, "HPThinPro", ""); ok {
+		t.Fatal("expected no profile for a nil Config")
+	}
+	if _, ok := nc.Get(thin); ok {
+		t.Fatal("expected no profile for a nil Config")
+	}
+}
+
+func TestNamed(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := c.Named("burnin")
+	if !ok || p.OSIEURL != "http://10.1.0.5:8080/burnin" || !p.NoWorkflow() {
+		t.Fatalf("got profile %+v (%v), want burnin without a workflow", p, ok)
+	}
+	if p, _ := c.Named("thin-client"); p.NoWorkflow() {
+		t.Fatal("expected a profile to hand off to a workflow by default")
+	}
+	if _, ok := c.Named("memtest"); ok {
+		t.Fatal("expected no profile for an unknown name")
+	}
+	// a profile without match conditions is only selected by name.
+	if p, ok := c.Select(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x08}, "", ""); ok {
+		t.Fatalf("got profile %q, want none", p.Name)
+	}
+	var nc *Config
+	if _, ok := nc.Named("burnin"); ok {
+		t.Fatal("expected no profile for a nil Config")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		want   string
+	}{
+		"missing name":      {config: "profiles: [{match: {userClass: [a]}}]", want: "name is required"},
+		"invalid pattern":   {config: "profiles: [{name: a, match: {userClass: ['[']}}]", want: "invalid pattern"},
+		"invalid scriptURL": {config: "profiles: [{name: a, match: {userClass: [a]}, scriptURL: not-a-url}]", want: "invalid scriptURL"},
+		"invalid osieURL":   {config: "profiles: [{name: a, match: {userClass: [a]}, osieURL: not-a-url}]", want: "invalid osieURL"},
+		"duplicate name":    {config: "profiles: [{name: a, match: {userClass: [a]}}, {name: a, match: {userClass: [b]}}]", want: "duplicate name"},
+		"esxi with binary":  {config: "profiles: [{name: a, match: {userClass: [a]}, esxi: true, binary: snp.efi}]", want: "esxi can't be combined"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// Package pxemenu is a PXE boot menu, it is sent in the vendor options (option 43) of DHCP replies so that the
+// firmware of PXE clients presents its items before iPXE is even loaded.
+//
+// The items, other than a local boot item, are boot server types. When an item is selected the firmware sends a
+// boot server discovery DHCPREQUEST with the type of the item (PXE_BOOT_ITEM), and the reply has the boot file
+// of the item.
+//
+//	prompt: Press F8 for the boot menu
+//	timeout: 10
+//	items:
+//	- description: Tinkerbell
+//	- description: Memtest
+//	  bootfile: memtest.efi
+//	  server: 10.1.0.6
+//	- description: Local disk
+//	  local: true
+//
+// Reference: https://www.ipxe.org/_media/specs/pxespec.pdf, section 2.4.
+package pxemenu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// The PXE vendor sub options of option 43.
+const (
+	subDiscoveryControl = 6
+	subBootServers      = 8
+	subBootMenu         = 9
+	subMenuPrompt       = 10
+	subBootItem         = 71
+)
+
+const (
+	// typeLocal is the boot server type of the local boot item, the firmware boots from the local disk.
+	typeLocal = 0
+	// typeFirst is the boot server type of the first item, item types are in the vendor range of 0x8000 to 0xfffe.
+	typeFirst = 0x8001
+	// disableMulticast is the discovery control that disables the multicast discovery of boot servers,
+	// the firmware broadcasts the discovery request, to port 67, or sends it to the boot servers of the menu, to port 4011.
+	disableMulticast = 0x02
+)
+
+// Menu is a PXE boot menu.
+type Menu struct {
+	// Prompt is shown by the firmware, with the seconds left of Timeout, before the menu.
+	Prompt string `json:"prompt,omitempty"`
+	// Timeout is the number of seconds the prompt is shown for, the first item is booted after it.
+	// 0 boots the first item without showing the menu, 255 waits for a key press.
+	Timeout int `json:"timeout,omitempty"`
+	// Items are the items of the menu, in order.
+	Items []Item `json:"items"`
+}
+
+// Item is an item of a PXE boot menu.
+type Item struct {
+	Description string `json:"description"`
+	// Bootfile is the boot file of the item, the iPXE binary of the client is booted when empty.
+	Bootfile string `json:"bootfile,omitempty"`
+	// Server is the IPv4 address of the boot server of the item, Smee when empty.
+	Server string `json:"server,omitempty"`
+	// Local boots from the local disk, it can't have a boot file or a server.
+	Local bool `json:"local,omitempty"`
+
+	typ    uint16
+	server net.IP
+}
+
+// Load reads and validates a YAML, or JSON, menu file.
+func Load(file string) (*Menu, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, menu.
+func Parse(b []byte) (*Menu, error) {
+	m := &Menu{}
+	if err := yaml.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("failed to parse PXE menu: %w", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Menu) validate() error {
+	if len(m.Items) == 0 {
+		return errors.New("at least one item is required")
+	}
+	if m.Timeout < 0 || m.Timeout > 255 {
+		return fmt.Errorf("timeout %d must be between 0 and 255", m.Timeout)
+	}
+	if len(m.Prompt) > 254 {
+		return errors.New("prompt must be at most 254 bytes")
+	}
+	menuLen := 0
+	var errs []error
+	for i := range m.Items {
+		it := &m.Items[i]
+		if err := it.validate(uint16(typeFirst + i)); err != nil {
+			errs = append(errs, fmt.Errorf("item %d (%s): %w", i, it.Description, err))
+		}
+		menuLen += 3 + len(it.Description)
+	}
+	if menuLen > 255 {
+		errs = append(errs, errors.New("the descriptions of the items are too long, the menu must fit in 255 bytes"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (it *Item) validate(typ uint16) error {
+	if it.Description == "" {
+		return errors.New("description is required")
+	}
+	if it.Local {
+		if it.Bootfile != "" || it.Server != "" {
+			return errors.New("a local item can't have a bootfile or a server")
+		}
+		it.typ = typeLocal
+		return nil
+	}
+	it.typ = typ
+	if it.Server != "" {
+		ip := net.ParseIP(it.Server).To4()
+		if ip == nil {
+			return fmt.Errorf("server %q must be an IPv4 address", it.Server)
+		}
+		it.server = ip
+	}
+
+	return nil
+}
+
+// Type returns the boot server type of the item.
+func (it Item) Type() uint16 {
+	return it.typ
+}
+
+// ForClient returns whether the client of pkt is the PXE firmware, that can show the menu.
+// iPXE, which sends a user class (option 77), doesn't.
+func ForClient(pkt *dhcpv4.DHCPv4) bool {
+	return strings.HasPrefix(pkt.ClassIdentifier(), "PXEClient") && len(pkt.UserClass()) == 0
+}
+
+// Options returns the option 43 sub options of the menu. The items without a server are served by nextServer.
+func (m *Menu) Options(nextServer net.IP) dhcpv4.Options {
+	var servers, menu []byte
+	for _, it := range m.Items {
+		menu = binary.BigEndian.AppendUint16(menu, it.typ)
+		menu = append(menu, byte(len(it.Description)))
+		menu = append(menu, it.Description...)
+		if it.Local {
+			continue
+		}
+		ip := it.server
+		if ip == nil {
+			ip = nextServer.To4()
+		}
+		if ip == nil {
+			continue
+		}
+		servers = binary.BigEndian.AppendUint16(servers, it.typ)
+		servers = append(servers, 1)
+		servers = append(servers, ip...)
+	}
+	opts := dhcpv4.Options{
+		subDiscoveryControl: {disableMulticast},
+		subBootMenu:         menu,
+		subMenuPrompt:       append([]byte{byte(m.Timeout)}, m.Prompt...),
+	}
+	if len(servers) > 0 {
+		opts[subBootServers] = servers
+	}
+
+	return opts
+}
+
+// Selection returns the item that the boot server discovery request pkt selected with its PXE_BOOT_ITEM sub option,
+// and the layer of the item. ok is false for a nil Menu, or when pkt isn't a discovery request for an item of the menu.
+func (m *Menu) Selection(pkt *dhcpv4.DHCPv4) (it Item, layer uint16, ok bool) {
+	if m == nil || pkt.MessageType() != dhcpv4.MessageTypeRequest {
+		return Item{}, 0, false
+	}
+	vendor := dhcpv4.Options{}
+	if err := vendor.FromBytes(pkt.GetOneOption(dhcpv4.OptionVendorSpecificInformation)); err != nil {
+		return Item{}, 0, false
+	}
+	bi := vendor.Get(dhcpv4.GenericOptionCode(subBootItem))
+	if len(bi) != 4 {
+		return Item{}, 0, false
+	}
+	typ := binary.BigEndian.Uint16(bi[:2])
+	for _, it := range m.Items {
+		if it.typ == typ && !it.Local {
+			return it, binary.BigEndian.Uint16(bi[2:]), true
+		}
+	}
+
+	return Item{}, 0, false
+}
+
+// Apply updates reply, to the DHCP message pkt of a PXE firmware client, with the menu.
+// For a boot server discovery request, the boot file and next server of reply are set to those of the selected
+// item, if it has them, otherwise the sub options of the menu are added to the option 43 of reply.
+// It returns the selected item, if any, and does nothing for a nil Menu or another client.
+func (m *Menu) Apply(reply, pkt *dhcpv4.DHCPv4) (selected Item, ok bool) {
+	if m == nil || !ForClient(pkt) {
+		return Item{}, false
+	}
+	if it, layer, ok := m.Selection(pkt); ok {
+		if it.Bootfile != "" {
+			reply.BootFileName = it.Bootfile
+		}
+		if it.server != nil {
+			reply.ServerIPAddr = it.server
+			reply.ServerHostName = it.server.String()
+		}
+		bi := binary.BigEndian.AppendUint16(nil, it.typ)
+		bi = binary.BigEndian.AppendUint16(bi, layer)
+		reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{subBootItem: bi}.ToBytes()))
+
+		return it, true
+	}
+	vendor := dhcpv4.Options{}
+	_ = vendor.FromBytes(reply.GetOneOption(dhcpv4.OptionVendorSpecificInformation))
+	for code, v := range m.Options(reply.ServerIPAddr) {
+		vendor[code] = v
+	}
+	reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, vendor.ToBytes()))
+
+	return Item{}, false
+}
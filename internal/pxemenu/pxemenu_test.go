@@ -0,0 +1,150 @@
+package pxemenu
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+const menu = `
+prompt: Boot menu
+timeout: 10
+items:
+- description: Tinkerbell
+- description: Memtest
+  bootfile: memtest.efi
+  server: 10.1.0.6
+- description: Local
+  local: true
+`
+
+func TestOptions(t *testing.T) {
+	m, err := Parse([]byte(menu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.Options(net.IPv4(192, 168, 2, 4))
+	want := dhcpv4.Options{
+		subDiscoveryControl: {disableMulticast},
+		subBootServers:      {0x80, 0x01, 1, 192, 168, 2, 4, 0x80, 0x02, 1, 10, 1, 0, 6},
+		subBootMenu:         append(append(append([]byte{0x80, 0x01, 10}, "Tinkerbell"...), append([]byte{0x80, 0x02, 7}, "Memtest"...)...), append([]byte{0, 0, 5}, "Local"...)...),
+		subMenuPrompt:       append([]byte{10}, "Boot menu"...),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestApply(t *testing.T) {
+	m, err := Parse([]byte(menu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		mods         []dhcpv4.Modifier
+		wantSelected string
+		wantBootfile string
+		wantServer   string
+		wantVendor   dhcpv4.Options
+	}{
+		"offer": {
+			mods:         []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover)},
+			wantBootfile: "ipxe.efi",
+			wantServer:   "192.168.2.4",
+			wantVendor:   m.Options(net.IPv4(192, 168, 2, 4)),
+		},
+		"discovery request": {
+			mods: []dhcpv4.Modifier{
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+				dhcpv4.WithGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{subBootItem: {0x80, 0x02, 0, 0}}.ToBytes()),
+			},
+			wantSelected: "Memtest",
+			wantBootfile: "memtest.efi",
+			wantServer:   "10.1.0.6",
+			wantVendor:   dhcpv4.Options{subBootItem: {0x80, 0x02, 0, 0}},
+		},
+		"discovery request of the default item": {
+			mods: []dhcpv4.Modifier{
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+				dhcpv4.WithGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{subBootItem: {0x80, 0x01, 0, 0}}.ToBytes()),
+			},
+			wantSelected: "Tinkerbell",
+			wantBootfile: "ipxe.efi",
+			wantServer:   "192.168.2.4",
+			wantVendor:   dhcpv4.Options{subBootItem: {0x80, 0x01, 0, 0}},
+		},
+		"ipxe": {
+			mods:         []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover), dhcpv4.WithUserClass("iPXE", false)},
+			wantBootfile: "ipxe.efi",
+			wantServer:   "192.168.2.4",
+			wantVendor:   dhcpv4.Options{subDiscoveryControl: {8}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mods := append([]dhcpv4.Modifier{dhcpv4.WithGeneric(dhcpv4.OptionClassIdentifier, []byte("PXEClient:Arch:00007:UNDI:003000"))}, tt.mods...)
+			pkt, err := dhcpv4.New(mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := dhcpv4.NewReplyFromRequest(pkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply.BootFileName = "ipxe.efi"
+			reply.ServerIPAddr = net.IPv4(192, 168, 2, 4)
+			reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, dhcpv4.Options{subDiscoveryControl: {8}}.ToBytes()))
+
+			it, _ := m.Apply(reply, pkt)
+			if it.Description != tt.wantSelected {
+				t.Fatalf("got selected item %q, want %q", it.Description, tt.wantSelected)
+			}
+			if reply.BootFileName != tt.wantBootfile {
+				t.Fatalf("got bootfile %q, want %q", reply.BootFileName, tt.wantBootfile)
+			}
+			if reply.ServerIPAddr.String() != tt.wantServer {
+				t.Fatalf("got next server %s, want %s", reply.ServerIPAddr, tt.wantServer)
+			}
+			vendor := dhcpv4.Options{}
+			if err := vendor.FromBytes(reply.GetOneOption(dhcpv4.OptionVendorSpecificInformation)); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.wantVendor, vendor); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	var nilMenu *Menu
+	if _, ok := nilMenu.Apply(&dhcpv4.DHCPv4{}, &dhcpv4.DHCPv4{}); ok {
+		t.Fatal("expected a nil menu not to select an item")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		menu    string
+		wantErr bool
+	}{
+		"valid":              {menu: menu},
+		"no items":           {menu: "prompt: menu\n", wantErr: true},
+		"no description":     {menu: "items:\n- bootfile: a.efi\n", wantErr: true},
+		"timeout":            {menu: "timeout: 256\nitems:\n- description: a\n", wantErr: true},
+		"local with a file":  {menu: "items:\n- description: a\n  local: true\n  bootfile: a.efi\n", wantErr: true},
+		"ipv6 server":        {menu: "items:\n- description: a\n  server: \"::1\"\n", wantErr: true},
+		"too long":           {menu: "items:\n- description: " + strings.Repeat("a", 253) + "\n", wantErr: true},
+		"invalid yaml":       {menu: "items: [", wantErr: true},
+		"invalid local type": {menu: "items:\n- description: a\n  local: yes please\n", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.menu))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package quota caps how many times a machine is netbooted within a window of time. A machine that reaches its
+// quota, like one stuck in a PXE boot loop that re-images it again and again, is switched to local boot until its
+// oldest netboot leaves the window, and the switch is logged and counted so that it can be alerted on.
+//
+// A netboot is a boot script, like auto.ipxe, served to the machine.
+package quota
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// Quota is the netboot quota of every machine. It implements script.Observer.
+type Quota struct {
+	// Max is the number of netboots of a machine within Window, the machine is switched to local boot once it reaches it.
+	Max    int
+	Window time.Duration
+	Log    logr.Logger
+
+	mu sync.Mutex
+	// boots holds the times of the last netboots of the machines, up to Max per machine.
+	boots map[string][]time.Time
+	// now is time.Now, it is replaced in tests.
+	now func() time.Time
+}
+
+// ScriptServed implements script.Observer, it counts a netboot of the machine with mac.
+func (q *Quota) ScriptServed(_ context.Context, mac net.HardwareAddr, name string) {
+	if q == nil || q.Max <= 0 || mac == nil {
+		return
+	}
+	now := q.clock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.boots == nil {
+		q.boots = map[string][]time.Time{}
+	}
+	q.prune(now)
+	boots := append(q.boots[mac.String()], now)
+	if len(boots) > q.Max {
+		boots = boots[len(boots)-q.Max:]
+	}
+	q.boots[mac.String()] = boots
+	if len(boots) == q.Max {
+		metric.NetbootQuotaReached.Inc()
+		q.log().Info("machine reached its netboot quota, it is switched to local boot", "mac", mac, "script", name, "netboots", len(boots), "window", q.Window.String(), "until", boots[0].Add(q.Window))
+	}
+}
+
+// recent returns the netboots of mac within the window at now, and forgets the older ones, with q.mu held.
+func (q *Quota) recent(mac string, now time.Time) []time.Time {
+	boots := q.boots[mac]
+	i := 0
+	for i < len(boots) && now.Sub(boots[i]) >= q.Window {
+		i++
+	}
+	boots = boots[i:]
+	if len(boots) == 0 {
+		delete(q.boots, mac)
+	}
+
+	return boots
+}
+
+// prune forgets the netboots of every machine that left the window at now, with q.mu held, so that the machines that
+// are not netbooted again are not remembered forever.
+func (q *Quota) prune(now time.Time) {
+	for mac := range q.boots {
+		if boots := q.recent(mac, now); len(boots) > 0 {
+			q.boots[mac] = boots
+		}
+	}
+}
+
+// Reached returns whether the machine with mac reached its quota.
+func (q *Quota) Reached(mac net.HardwareAddr) bool {
+	if q == nil || q.Max <= 0 || mac == nil {
+		return false
+	}
+	now := q.clock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.recent(mac.String(), now)) >= q.Max
+}
+
+// Flush forgets the netboots of every machine, the machines that reached their quota are netbooted again.
+func (q *Quota) Flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	clear(q.boots)
+
+	return nil
+}
+
+// Backend returns br with the machines that reached their quota switched to local boot: their records are served
+// without netboot. The returned backend is a handler.BackendIdentityReader when br is one.
+func (q *Quota) Backend(br handler.BackendReader) handler.BackendReader {
+	if q == nil || q.Max <= 0 {
+		return br
+	}
+	b := &backend{reader: br, quota: q}
+	if ir, ok := br.(handler.BackendIdentityReader); ok {
+		return &identityBackend{backend: b, identity: ir}
+	}
+
+	return b
+}
+
+// enforce applies the quota to the record of a lookup.
+func (q *Quota) enforce(d *data.DHCP, n *data.Netboot, err error) (*data.DHCP, *data.Netboot, error) {
+	if err != nil || d == nil || n == nil || !n.AllowNetboot || !q.Reached(d.MACAddress) {
+		return d, n, err
+	}
+	metric.NetbootQuotaLookups.Inc()
+	ln := *n
+	ln.AllowNetboot = false
+
+	return d, &ln, nil
+}
+
+func (q *Quota) clock() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+
+	return time.Now()
+}
+
+func (q *Quota) log() logr.Logger {
+	if q.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return q.Log
+}
+
+type backend struct {
+	reader handler.BackendReader
+	quota  *Quota
+}
+
+func (b *backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return b.quota.enforce(b.reader.GetByMac(ctx, mac))
+}
+
+func (b *backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return b.quota.enforce(b.reader.GetByIP(ctx, ip))
+}
+
+type identityBackend struct {
+	*backend
+	identity handler.BackendIdentityReader
+}
+
+func (b *identityBackend) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	return b.quota.enforce(b.identity.GetByUUID(ctx, uuid))
+}
+
+func (b *identityBackend) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	return b.quota.enforce(b.identity.GetBySerial(ctx, serial))
+}
+
+func (b *identityBackend) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	return b.quota.enforce(b.identity.GetByHostname(ctx, hostname))
+}
@@ -0,0 +1,157 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+var (
+	looping = net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	other   = net.HardwareAddr{0, 1, 2, 3, 4, 6}
+)
+
+// records is a backend that allows every machine to netboot.
+type records struct{}
+
+func (records) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return &data.DHCP{MACAddress: mac}, &data.Netboot{AllowNetboot: true}, nil
+}
+
+func (records) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+type identity struct{ records }
+
+func (r identity) GetByUUID(ctx context.Context, _ string) (*data.DHCP, *data.Netboot, error) {
+	return r.GetByMac(ctx, looping)
+}
+
+func (identity) GetBySerial(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (identity) GetByHostname(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+// clock is a settable time.Now.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func netboot(t *testing.T, br handler.BackendReader, mac net.HardwareAddr) bool {
+	t.Helper()
+	_, n, err := br.GetByMac(context.Background(), mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n.AllowNetboot
+}
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Now()}
+	q := &Quota{Max: 3, Window: time.Hour, now: c.now}
+	br := q.Backend(records{})
+	reached := testutil.ToFloat64(metric.NetbootQuotaReached)
+
+	for range 2 {
+		if !netboot(t, br, looping) {
+			t.Fatal("want the machine netbooted within its quota")
+		}
+		q.ScriptServed(ctx, looping, "auto.ipxe")
+		c.advance(10 * time.Minute)
+	}
+	q.ScriptServed(ctx, looping, "auto.ipxe")
+	if netboot(t, br, looping) {
+		t.Fatal("want the machine that reached its quota switched to local boot")
+	}
+	if got := testutil.ToFloat64(metric.NetbootQuotaReached) - reached; got != 1 {
+		t.Fatalf("got %v machines that reached their quota, want 1", got)
+	}
+	if !netboot(t, br, other) {
+		t.Fatal("want the other machines netbooted")
+	}
+
+	// the first netboot leaves the window.
+	c.advance(41 * time.Minute)
+	if !netboot(t, br, looping) {
+		t.Fatal("want the machine netbooted once a netboot left the window")
+	}
+	q.ScriptServed(ctx, looping, "auto.ipxe")
+	if netboot(t, br, looping) {
+		t.Fatal("want the machine switched to local boot again")
+	}
+
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !netboot(t, br, looping) {
+		t.Fatal("want the machine netbooted after a flush")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Now()}
+	q := &Quota{Max: 3, Window: time.Hour, now: c.now}
+	for i := range 10 {
+		q.ScriptServed(ctx, net.HardwareAddr{0, 1, 2, 3, 5, byte(i)}, "auto.ipxe")
+	}
+	q.ScriptServed(ctx, looping, "auto.ipxe")
+	c.advance(30 * time.Minute)
+	q.ScriptServed(ctx, looping, "auto.ipxe")
+	if got := len(q.boots); got != 11 {
+		t.Fatalf("got the netboots of %d machines, want 11", got)
+	}
+
+	// the netboots of the other machines leave the window, they are not netbooted again.
+	c.advance(45 * time.Minute)
+	q.ScriptServed(ctx, other, "auto.ipxe")
+	if got := len(q.boots); got != 2 {
+		t.Fatalf("got the netboots of %d machines, want those of the 2 machines netbooted within the window", got)
+	}
+	if got := len(q.boots[looping.String()]); got != 1 {
+		t.Fatalf("got %d netboots of the machine, want the 1 within the window", got)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	if br := (*Quota)(nil).Backend(records{}); br != (records{}) {
+		t.Fatal("want the backend as is without a quota")
+	}
+	q := &Quota{Max: 1, Window: time.Hour}
+	br := q.Backend(identity{})
+	ir, ok := br.(handler.BackendIdentityReader)
+	if !ok {
+		t.Fatal("want a BackendIdentityReader for a backend that is one")
+	}
+	q.ScriptServed(context.Background(), looping, "hook.ipxe")
+	_, n, err := ir.GetByUUID(context.Background(), "4c4c4544-0051-3410-8058-b4c04f4a5032")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.AllowNetboot {
+		t.Fatal("want the quota applied to the identity lookups")
+	}
+	if _, ok := q.Backend(records{}).(handler.BackendIdentityReader); ok {
+		t.Fatal("want no BackendIdentityReader for a backend that isn't one")
+	}
+}
@@ -0,0 +1,195 @@
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultWindow is the default duration that the changes of a Guard are counted over.
+const DefaultWindow = time.Hour
+
+// Guard limits the blast radius of a change of the boot configuration, like a broken OSIE URL: at most max machines
+// per window boot a configuration that differs from the one they last booted with. The other machines are held on
+// the configuration they last booted with until the window has room for them, so a bad change only reaches a few
+// machines of a fleet that reboots at once. The first configuration of a machine is always admitted.
+//
+// A nil Guard admits every configuration.
+type Guard struct {
+	max    int
+	window time.Duration
+	file   string
+
+	mu sync.Mutex
+	// configs is the configuration that each machine last booted with, by MAC address.
+	configs map[string]string
+	// changes is the time of the last change admitted to each machine within the window, by MAC address.
+	changes map[string]time.Time
+	dirty   bool
+	now     func() time.Time
+}
+
+// state is the content of the file of a Guard.
+type state struct {
+	Configs map[string]string `json:"configs"`
+}
+
+// NewGuard returns a Guard that admits max changed configurations per window, DefaultWindow when window is 0.
+// When file is set, the configurations that the machines last booted with are loaded from it, when it exists, and
+// saved to it by Persist, so that they survive restarts. It returns nil, no limit, when max is 0 or less.
+func NewGuard(max int, window time.Duration, file string) (*Guard, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	g := &Guard{max: max, window: window, file: file, configs: map[string]string{}, changes: map[string]time.Time{}, now: time.Now}
+	if file == "" {
+		return g, nil
+	}
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := state{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	for mac, c := range s.Configs {
+		g.configs[mac] = c
+	}
+
+	return g, nil
+}
+
+// Admit returns the configuration that the machine mac boots with: config when it is the first configuration of
+// the machine, the one it last booted with, or a change that the window has room for, and otherwise the
+// configuration that it last booted with, held is true then. A machine that was admitted a change keeps booting
+// the configuration of its choice for the rest of the window.
+func (g *Guard) Admit(mac net.HardwareAddr, config string) (boot string, held bool) {
+	if g == nil {
+		return config, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := mac.String()
+	last, seen := g.configs[key]
+	if seen && last == config {
+		return config, false
+	}
+	if seen {
+		now := g.now()
+		for k, t := range g.changes {
+			if now.Sub(t) >= g.window {
+				delete(g.changes, k)
+			}
+		}
+		if _, ok := g.changes[key]; !ok && len(g.changes) >= g.max {
+			return last, true
+		}
+		g.changes[key] = now
+	}
+	g.configs[key] = config
+	g.dirty = true
+
+	return config, false
+}
+
+// Peek returns the configuration that Admit would return for config, without admitting it: a held change of the
+// machine is not admitted and doesn't count against the window. It previews the configuration of a machine.
+func (g *Guard) Peek(mac net.HardwareAddr, config string) (boot string, held bool) {
+	if g == nil {
+		return config, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := mac.String()
+	last, seen := g.configs[key]
+	if !seen || last == config {
+		return config, false
+	}
+	now := g.now()
+	if t, ok := g.changes[key]; ok && now.Sub(t) < g.window {
+		return config, false
+	}
+	changes := 0
+	for _, t := range g.changes {
+		if now.Sub(t) < g.window {
+			changes++
+		}
+	}
+	if changes >= g.max {
+		return last, true
+	}
+
+	return config, false
+}
+
+// Persist saves the configurations to the file of g every interval when they changed, and once more when ctx is
+// done. It returns nil right away for a nil Guard or a Guard without a file.
+func (g *Guard) Persist(ctx context.Context, interval time.Duration) error {
+	if g == nil || g.file == "" {
+		return nil
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return g.save()
+		case <-t.C:
+			if err := g.save(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// save writes the configurations to the file of g when they changed. The file is replaced atomically so that a
+// crash never leaves a partial file. The configurations are saved again by the next save when it fails.
+func (g *Guard) save() error {
+	g.mu.Lock()
+	if !g.dirty {
+		g.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(state{Configs: g.configs})
+	g.dirty = false
+	g.mu.Unlock()
+	if err == nil {
+		err = writeFile(g.file, b)
+	}
+	if err != nil {
+		g.mu.Lock()
+		g.dirty = true
+		g.mu.Unlock()
+	}
+
+	return err
+}
+
+func writeFile(name string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".rollout-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
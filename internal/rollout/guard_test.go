@@ -0,0 +1,90 @@
+package rollout
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGuard(t *testing.T) {
+	g, err := NewGuard(2, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	macs := []net.HardwareAddr{
+		{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		{0x00, 0x01, 0x02, 0x03, 0x04, 0x06},
+		{0x00, 0x01, 0x02, 0x03, 0x04, 0x07},
+	}
+	// the first configuration of a machine is not a change.
+	for _, mac := range macs {
+		if got, held := g.Admit(mac, "v1"); got != "v1" || held {
+			t.Fatalf("got %q (held %v), want the first configuration to be admitted", got, held)
+		}
+	}
+	for i, mac := range macs[:2] {
+		if got, held := g.Admit(mac, "v2"); got != "v2" || held {
+			t.Fatalf("got %q (held %v) for the change %d, want it to be admitted", got, held, i)
+		}
+	}
+	if got, held := g.Peek(macs[2], "v2"); got != "v1" || !held {
+		t.Fatalf("got %q (held %v), want the peek of the machine held on v1", got, held)
+	}
+	if got, held := g.Admit(macs[2], "v2"); got != "v1" || !held {
+		t.Fatalf("got %q (held %v), want the machine held on v1", got, held)
+	}
+	if got, held := g.Peek(macs[1], "v3"); got != "v3" || held {
+		t.Fatalf("got %q (held %v), want the peek of a machine with a slot admitted", got, held)
+	}
+	// a machine that was admitted a change keeps its slot for the window.
+	if got, held := g.Admit(macs[0], "v3"); got != "v3" || held {
+		t.Fatalf("got %q (held %v), want a machine with a slot to be admitted", got, held)
+	}
+
+	now = now.Add(time.Hour)
+	// a peek doesn't take the slot of the window.
+	if got, held := g.Peek(macs[1], "v4"); got != "v4" || held {
+		t.Fatalf("got %q (held %v), want the peek of the change admitted in the next window", got, held)
+	}
+	if got, held := g.Admit(macs[2], "v2"); got != "v2" || held {
+		t.Fatalf("got %q (held %v), want the change admitted in the next window", got, held)
+	}
+
+	var ng *Guard
+	if got, held := ng.Admit(macs[0], "v9"); got != "v9" || held {
+		t.Fatalf("got %q (held %v), want a nil Guard to admit every configuration", got, held)
+	}
+	if g, _ := NewGuard(0, 0, ""); g != nil {
+		t.Fatal("expected no Guard without a limit")
+	}
+}
+
+func TestGuardPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rollout.json")
+	g, err := NewGuard(1, 0, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	g.Admit(mac, "v1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.Persist(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted Smee knows the configuration that the machine last booted with.
+	g, err = NewGuard(1, 0, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Admit(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}, "v1")
+	g.Admit(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}, "v2")
+	if got, held := g.Admit(mac, "v2"); got != "v1" || !held {
+		t.Fatalf("got %q (held %v), want the machine held on its saved configuration", got, held)
+	}
+}
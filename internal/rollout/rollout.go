@@ -0,0 +1,133 @@
+// Package rollout selects the OSIE (HookOS) URL of machines from weighted tracks, so that HookOS upgrades can be
+// rolled out gradually across a fleet.
+//
+// Machines are assigned to a track by a hash of their MAC address, the assignment is sticky as long as the tracks
+// and their weights don't change. When the weight of the last track grows, machines only move into it, so list
+// the canary track last. A machine with the TrackLabel in its backend record uses the track it names, this can
+// select a track with a weight of 0.
+//
+//	tracks:
+//	- name: stable
+//	  osieURL: http://10.1.0.5:8080/hook/v0.9.0
+//	  weight: 90
+//	- name: canary
+//	  osieURL: http://10.1.0.5:8080/hook/v0.10.0
+//	  weight: 10
+//
+// A Guard limits how many machines per window boot a changed boot configuration, whatever changed it.
+package rollout
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/ghodss/yaml"
+)
+
+// TrackLabel is the backend label that pins a machine to a track by name.
+const TrackLabel = "smee.tinkerbell.org/osie-track"
+
+// Config holds the OSIE tracks.
+type Config struct {
+	Tracks []Track `json:"tracks"`
+}
+
+// Track is an OSIE URL and the share of the machines that use it.
+type Track struct {
+	// Name identifies the track in the TrackLabel, logs and traces.
+	Name string `json:"name"`
+	// OSIEURL is the URL where the OSIE (HookOS) images of the track are located.
+	OSIEURL string `json:"osieURL"`
+	// Weight is the share of the machines that use the track, relative to the sum of the weights of all tracks.
+	Weight int `json:"weight"`
+}
+
+// Load reads and validates a YAML, or JSON, rollout config file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, rollout config.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse rollout config: %w", err)
+	}
+	var errs []error
+	names := map[string]bool{}
+	for i, t := range c.Tracks {
+		if err := t.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("track %d: %w", i, err))
+		}
+		if names[t.Name] {
+			errs = append(errs, fmt.Errorf("track %d: duplicate name %q", i, t.Name))
+		}
+		names[t.Name] = true
+	}
+	if len(c.Tracks) > 0 && c.total() == 0 {
+		errs = append(errs, errors.New("the weight of at least one track must be greater than 0"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func (t Track) validate() error {
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if _, err := url.ParseRequestURI(t.OSIEURL); err != nil {
+		return fmt.Errorf("invalid osieURL: %w", err)
+	}
+	if t.Weight < 0 {
+		return fmt.Errorf("invalid weight %d, must not be negative", t.Weight)
+	}
+
+	return nil
+}
+
+func (c *Config) total() int {
+	var total int
+	for _, t := range c.Tracks {
+		total += t.Weight
+	}
+
+	return total
+}
+
+// Select returns the track of a machine, by the TrackLabel in labels when it names a track, otherwise by the hash of mac.
+// It returns false for a nil Config or a Config without weighted tracks.
+func (c *Config) Select(mac net.HardwareAddr, labels map[string]string) (Track, bool) {
+	if c == nil || c.total() <= 0 {
+		return Track{}, false
+	}
+	if name, found := labels[TrackLabel]; found {
+		for _, t := range c.Tracks {
+			if t.Name == name {
+				return t, true
+			}
+		}
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(mac)
+	bucket := int(h.Sum32() % uint32(c.total())) //nolint:gosec // the total of the weights is positive.
+	for _, t := range c.Tracks {
+		if bucket < t.Weight {
+			return t, true
+		}
+		bucket -= t.Weight
+	}
+
+	return c.Tracks[len(c.Tracks)-1], true
+}
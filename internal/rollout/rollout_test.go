@@ -0,0 +1,85 @@
+package rollout
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testConfig = `
+tracks:
+- name: stable
+  osieURL: http://10.1.0.5:8080/hook/v0.9.0
+  weight: 90
+- name: canary
+  osieURL: http://10.1.0.5:8080/hook/v0.10.0
+  weight: 10
+- name: pinned
+  osieURL: http://10.1.0.5:8080/hook/v0.8.0
+`
+
+func TestSelect(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	first, ok := c.Select(mac, nil)
+	if !ok {
+		t.Fatal("expected a track")
+	}
+	for range 10 {
+		if got, _ := c.Select(mac, nil); got.Name != first.Name {
+			t.Fatalf("got track %q, want the sticky track %q", got.Name, first.Name)
+		}
+	}
+
+	got, _ := c.Select(mac, map[string]string{TrackLabel: "pinned"})
+	if diff := cmp.Diff(c.Tracks[2], got); diff != "" {
+		t.Fatal(diff)
+	}
+	if got, _ := c.Select(mac, map[string]string{TrackLabel: "unknown"}); got.Name != first.Name {
+		t.Fatalf("got track %q for an unknown track label, want %q", got.Name, first.Name)
+	}
+
+	counts := map[string]int{}
+	for i := range 10000 {
+		m := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, byte(i >> 8), byte(i)}
+		tr, _ := c.Select(m, nil)
+		counts[tr.Name]++
+	}
+	if counts["pinned"] != 0 {
+		t.Fatalf("got %d machines on a track with a weight of 0", counts["pinned"])
+	}
+	if counts["canary"] < 700 || counts["canary"] > 1300 {
+		t.Fatalf("got %d of 10000 machines on the canary track, want about 1000", counts["canary"])
+	}
+
+	var nc *Config
+	if _, ok := nc.Select(mac, nil); ok {
+		t.Fatal("expected no track for a nil Config")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		want   string
+	}{
+		"missing name":   {config: "tracks: [{osieURL: http://a, weight: 1}]", want: "name is required"},
+		"invalid url":    {config: "tracks: [{name: a, osieURL: not-a-url, weight: 1}]", want: "invalid osieURL"},
+		"negative":       {config: "tracks: [{name: a, osieURL: http://a, weight: -1}]", want: "must not be negative"},
+		"duplicate name": {config: "tracks: [{name: a, osieURL: http://a, weight: 1}, {name: a, osieURL: http://b}]", want: "duplicate name"},
+		"no weight":      {config: "tracks: [{name: a, osieURL: http://a}]", want: "at least one track"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+// Package secureboot serves the shim, GRUB and MOK manager binaries, and per machine GRUB configs,
+// that are needed to netboot machines with UEFI Secure Boot enabled.
+//
+// With Secure Boot enabled, DHCP points UEFI clients at shimx64.efi (shimaa64.efi for arm64) instead of an iPXE binary.
+// Shim loads grubx64.efi (grubaa64.efi) and, when a Machine Owner Key needs to be enrolled, mmx64.efi (mmaa64.efi)
+// from the same location, these binaries are served from Dir. GRUB then loads its config from its prefix, grub.cfg
+// and grub.cfg-01-<mac address> are generated per machine, see script.GRUBScript.
+package secureboot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/ipxedust/itftp"
+	"github.com/tinkerbell/smee/internal/httpfile"
+	"github.com/tinkerbell/smee/internal/limit"
+)
+
+// grubConfigMACPrefix is the prefix of the MAC specific config file names that GRUB requests when netbooting.
+const grubConfigMACPrefix = "grub.cfg-01-"
+
+// bootstrapConfig is served as grub.cfg when the request does not identify the machine.
+// It loads the MAC specific config, for GRUB versions that don't request it on their own.
+var bootstrapConfig = []byte(`configfile ${prefix}/` + grubConfigMACPrefix + `${net_default_mac}
+`)
+
+// traceparent matches a traceparent that was appended to a file name, see the OTELEnabled DHCP handler option.
+var traceparent = regexp.MustCompile(`^(.+)-00-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+var errNoConfigurer = errors.New("no GRUB configurer is set")
+
+// Configurer generates the GRUB config of a machine.
+type Configurer interface {
+	GRUBConfig(ctx context.Context, mac net.HardwareAddr) (string, error)
+}
+
+// Handler serves Secure Boot files over HTTP and TFTP. Requests for any other file are passed to the fallback handlers.
+type Handler struct {
+	// Dir is the directory holding the signed shim, GRUB and MOK manager binaries.
+	Dir string
+	// Config generates the per machine GRUB configs.
+	Config Configurer
+	Log    logr.Logger
+	// HTTPFallback serves HTTP requests for files that are not Secure Boot files.
+	HTTPFallback http.HandlerFunc
+	// TFTPFallback serves TFTP reads of files that are not Secure Boot files.
+	TFTPFallback func(filename string, rf io.ReaderFrom) error
+	// TFTPSessions, when set, bounds the number of concurrent TFTP sessions.
+	TFTPSessions *limit.Limiter
+}
+
+// file is a resolved Secure Boot file, either a GRUB config or a binary from Dir.
+type file struct {
+	name   string
+	config []byte
+	path   string
+}
+
+// resolve returns the Secure Boot file for the requested path. ok is false if the path is not a Secure Boot file.
+// The MAC address is taken from a grub.cfg-01-<mac> file name, or from the parent directory of the file, <mac>/grub.cfg.
+func (h *Handler) resolve(ctx context.Context, p string) (f file, ok bool, err error) {
+	name := path.Base(p)
+	if m := traceparent.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	}
+	f.name = name
+
+	var mac net.HardwareAddr
+	switch {
+	case strings.HasPrefix(name, grubConfigMACPrefix):
+		if mac, err = net.ParseMAC(strings.TrimPrefix(name, grubConfigMACPrefix)); err != nil {
+			return f, true, fmt.Errorf("invalid mac address in GRUB config file name: %w", err)
+		}
+	case name == "grub.cfg":
+		if mac, err = net.ParseMAC(path.Base(path.Dir(p))); err != nil {
+			f.config = bootstrapConfig
+			return f, true, nil
+		}
+	default:
+		if h.Dir == "" || name == "." || name == "/" {
+			return f, false, nil
+		}
+		f.path = filepath.Join(h.Dir, name)
+		if fi, err := os.Stat(f.path); err != nil || !fi.Mode().IsRegular() {
+			return f, false, nil
+		}
+		return f, true, nil
+	}
+
+	if h.Config == nil {
+		return f, true, errNoConfigurer
+	}
+	cfg, err := h.Config.GRUBConfig(ctx, mac)
+	if err != nil {
+		return f, true, fmt.Errorf("failed to generate GRUB config for (%v): %w", mac, err)
+	}
+	f.config = []byte(cfg)
+
+	return f, true, nil
+}
+
+// ServeHTTP serves Secure Boot files over HTTP.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, ok, err := h.resolve(r.Context(), r.URL.Path)
+	if !ok {
+		if h.HTTPFallback == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.HTTPFallback(w, r)
+		return
+	}
+	log := h.log().WithValues("path", r.URL.Path, "client", r.RemoteAddr)
+	if err != nil {
+		log.Info("not serving secure boot file", "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	if !httpfile.Allowed(w, r) {
+		return
+	}
+	if f.path != "" {
+		if _, err := httpfile.ServeFile(w, r, f.path); err != nil {
+			log.Error(err, "unable to open secure boot file", "file", f.name)
+			http.NotFound(w, r)
+			return
+		}
+		log.Info("served secure boot file", "file", f.name)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	httpfile.ServeBytes(w, r, f.name, time.Time{}, f.config)
+	log.Info("served GRUB config", "file", f.name)
+}
+
+// HandleRead serves Secure Boot files over TFTP. The function signature satisfies the tftp.Server read handler parameter type.
+func (h *Handler) HandleRead(filename string, rf io.ReaderFrom) error {
+	f, ok, err := h.resolve(context.Background(), filename)
+	if !ok {
+		if h.TFTPFallback == nil {
+			return fmt.Errorf("file [%v] unknown: %w", filename, os.ErrNotExist)
+		}
+		return h.TFTPFallback(filename, rf)
+	}
+	log := h.log().WithValues("filename", filename)
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		log = log.WithValues("client", ot.RemoteAddr())
+	}
+	if err != nil {
+		log.Info("not serving secure boot file", "error", err)
+		return fmt.Errorf("%w: %w", os.ErrNotExist, err)
+	}
+
+	var r io.Reader = bytes.NewReader(f.config)
+	size := int64(len(f.config))
+	if f.path != "" {
+		fh, err := os.Open(f.path)
+		if err != nil {
+			log.Error(err, "unable to open secure boot file")
+			return err
+		}
+		defer fh.Close()
+		fi, err := fh.Stat()
+		if err != nil {
+			return err
+		}
+		r, size = fh, fi.Size()
+	}
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		ot.SetSize(size)
+	}
+	b, err := rf.ReadFrom(r)
+	if err != nil {
+		log.Error(err, "file serve failed", "bytesSent", b, "contentSize", size)
+		return err
+	}
+	log.Info("served secure boot file", "file", f.name, "bytesSent", b)
+
+	return nil
+}
+
+// ListenAndServeTFTP serves Secure Boot files, and the iPXE binaries through the TFTPFallback, over TFTP on addr.
+// It replaces the ipxedust TFTP server, which only serves its embedded iPXE binaries.
+func (h *Handler) ListenAndServeTFTP(ctx context.Context, addr netip.AddrPort, timeout time.Duration, blockSize int) error {
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(addr))
+	if err != nil {
+		return err
+	}
+
+	return h.ServeTFTP(ctx, conn, timeout, blockSize)
+}
+
+// ServeTFTP is like ListenAndServeTFTP, it serves on conn, that it closes when ctx is done.
+func (h *Handler) ServeTFTP(ctx context.Context, conn net.PacketConn, timeout time.Duration, blockSize int) error {
+	w := itftp.Handler{Log: h.log()}
+	ts := tftp.NewServer(limit.TFTPReadHandler(h.TFTPSessions, h.HandleRead), w.HandleWrite)
+	ts.SetTimeout(timeout)
+	ts.SetBlockSize(blockSize)
+	ts.EnableSinglePort()
+	h.log().Info("serving secure boot files via TFTP", "addr", conn.LocalAddr(), "dir", h.Dir)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+		ts.Shutdown()
+	}()
+
+	return itftp.Serve(ctx, conn, ts)
+}
+
+func (h *Handler) log() logr.Logger {
+	if h.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return h.Log
+}
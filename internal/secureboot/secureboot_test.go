@@ -0,0 +1,110 @@
+package secureboot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeConfigurer struct{}
+
+func (fakeConfigurer) GRUBConfig(_ context.Context, mac net.HardwareAddr) (string, error) {
+	if mac.String() == "00:01:02:03:04:05" {
+		return "config for " + mac.String(), nil
+	}
+
+	return "", errors.New("not found")
+}
+
+type fakeReaderFrom struct {
+	bytes.Buffer
+}
+
+func (f *fakeReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	return f.Buffer.ReadFrom(r)
+}
+
+func newHandler(t *testing.T) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "shimx64.efi"), []byte("shim"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Handler{
+		Dir:    dir,
+		Config: fakeConfigurer{},
+		HTTPFallback: func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("fallback"))
+		},
+		TFTPFallback: func(_ string, rf io.ReaderFrom) error {
+			_, err := rf.ReadFrom(bytes.NewReader([]byte("fallback")))
+			return err
+		},
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := map[string]struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		"shim":                   {path: "/ipxe/00:01:02:03:04:05/shimx64.efi", wantCode: http.StatusOK, wantBody: "shim"},
+		"shim with traceparent":  {path: "/ipxe/shimx64.efi-00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01", wantCode: http.StatusOK, wantBody: "shim"},
+		"grub config in mac dir": {path: "/ipxe/00:01:02:03:04:05/grub.cfg", wantCode: http.StatusOK, wantBody: "config for 00:01:02:03:04:05"},
+		"grub mac config":        {path: "/ipxe/grub.cfg-01-00-01-02-03-04-05", wantCode: http.StatusOK, wantBody: "config for 00:01:02:03:04:05"},
+		"bootstrap config":       {path: "/ipxe/grub.cfg", wantCode: http.StatusOK, wantBody: string(bootstrapConfig)},
+		"unknown machine":        {path: "/ipxe/grub.cfg-01-00-01-02-03-04-06", wantCode: http.StatusNotFound, wantBody: "404 page not found\n"},
+		"ipxe binary":            {path: "/ipxe/00:01:02:03:04:05/ipxe.efi", wantCode: http.StatusOK, wantBody: "fallback"},
+		"no traversal":           {path: "/ipxe/../../etc/passwd", wantCode: http.StatusOK, wantBody: "fallback"},
+	}
+	h := newHandler(t)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			if diff := cmp.Diff(tt.wantBody, w.Body.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestHandleRead(t *testing.T) {
+	tests := map[string]struct {
+		filename string
+		want     string
+		wantErr  bool
+	}{
+		"shim":            {filename: "shimx64.efi", want: "shim"},
+		"grub mac config": {filename: "grub.cfg-01-00:01:02:03:04:05", want: "config for 00:01:02:03:04:05"},
+		"unknown machine": {filename: "00:01:02:03:04:06/grub.cfg", wantErr: true},
+		"ipxe binary":     {filename: "ipxe.efi", want: "fallback"},
+	}
+	h := newHandler(t)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rf := &fakeReaderFrom{}
+			err := h.HandleRead(tt.filename, rf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, rf.String()); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
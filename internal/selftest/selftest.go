@@ -0,0 +1,278 @@
+// Package selftest checks a running Smee end to end, like a canary machine that network boots: a DHCP transaction
+// through the DHCP handler, the fetch of its auto.ipxe script and of a range of its ISO. The outcome is reported in
+// readiness and metrics, so that a broken configuration is caught before a real machine boots.
+package selftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/tinkerbell/smee/internal/dhcp/firmware"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+const (
+	// DefaultTimeout is the default timeout of each check.
+	DefaultTimeout = 30 * time.Second
+	// DefaultRetryInterval is how often the checks are retried, by default, until they pass.
+	DefaultRetryInterval = 10 * time.Second
+	// UserAgent is the User-Agent of the HTTP requests of the checks.
+	UserAgent = "smee-self-test"
+	// isoRange is the range of the ISO that is fetched, its first 2 KiB.
+	isoRange = 2048
+)
+
+// ErrSkipped is returned by a check that doesn't apply to the configuration of Smee, it doesn't fail the self-test.
+var ErrSkipped = errors.New("skipped")
+
+// Check is a check of the self-test.
+type Check struct {
+	// Name is the name of the check in logs, metrics and results, for example "dhcp".
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Check    string
+	Err      error
+	Duration time.Duration
+}
+
+// Passed returns whether the check passed, or was skipped.
+func (r Result) Passed() bool {
+	return r.Err == nil || errors.Is(r.Err, ErrSkipped)
+}
+
+// Runner runs the checks of the self-test.
+type Runner struct {
+	Checks []Check
+	Log    logr.Logger
+	// Timeout is the timeout of each check, DefaultTimeout when 0.
+	Timeout time.Duration
+	// Interval, when greater than 0, is how often the checks are run again after they passed.
+	Interval time.Duration
+	// RetryInterval is how often the checks are run again after they failed, DefaultRetryInterval when 0.
+	RetryInterval time.Duration
+
+	// run serializes the runs.
+	run    sync.Mutex
+	mu     sync.Mutex
+	last   []Result
+	passed bool
+}
+
+// Start runs the checks until ctx is done: they are retried every RetryInterval until they pass, and then run every
+// Interval, when it is set.
+func (r *Runner) Start(ctx context.Context) error {
+	for {
+		wait := r.RetryInterval
+		if wait <= 0 {
+			wait = DefaultRetryInterval
+		}
+		if passed(r.Run(ctx)) {
+			if r.Interval <= 0 {
+				<-ctx.Done()
+				return nil
+			}
+			wait = r.Interval
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Run runs the checks, one after the other, and returns their results.
+func (r *Runner) Run(ctx context.Context) []Result {
+	r.run.Lock()
+	defer r.run.Unlock()
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	results := make([]Result, 0, len(r.Checks))
+	for _, c := range r.Checks {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := c.Run(cctx)
+		cancel()
+		res := Result{Check: c.Name, Err: err, Duration: time.Since(start)}
+		results = append(results, res)
+		switch {
+		case errors.Is(err, ErrSkipped):
+			r.Log.V(1).Info("self-test check skipped", "check", c.Name, "reason", err.Error())
+		case err != nil:
+			r.Log.Info("self-test check failed", "check", c.Name, "error", err.Error())
+			metric.SelfTestChecks.WithLabelValues(c.Name).Set(0)
+		default:
+			metric.SelfTestChecks.WithLabelValues(c.Name).Set(1)
+		}
+	}
+	ok := passed(results)
+	if ok {
+		metric.SelfTestRuns.WithLabelValues("pass").Inc()
+	} else {
+		metric.SelfTestRuns.WithLabelValues("fail").Inc()
+	}
+	r.mu.Lock()
+	if ok != r.passed {
+		r.Log.Info("self-test result changed", "passed", ok)
+	}
+	r.last, r.passed = results, ok
+	r.mu.Unlock()
+
+	return results
+}
+
+// Ready returns whether the last run of the checks passed. It is false until the checks first ran.
+func (r *Runner) Ready() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.passed
+}
+
+// Last returns the results of the last run of the checks.
+func (r *Runner) Last() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last
+}
+
+func passed(results []Result) bool {
+	for _, res := range results {
+		if !res.Passed() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Replier returns the reply of the DHCP handler to a DHCP message, without sending it.
+type Replier interface {
+	Reply(ctx context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error)
+}
+
+// DHCP returns the check of a DHCP transaction of mac, as the PXE firmware of an x86_64 UEFI machine, through the
+// DHCP handler r. The offer must have an address and a boot file, and the request of it must be acknowledged.
+func DHCP(r Replier, mac net.HardwareAddr) Check {
+	return Check{Name: "dhcp", Run: func(ctx context.Context) error {
+		mods := []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier(fmt.Sprintf("PXEClient:Arch:%05d:UNDI:003001", iana.EFI_X86_64))),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+			dhcpv4.WithGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 1}),
+		}
+		discover, err := dhcpv4.NewDiscovery(mac, mods...)
+		if err != nil {
+			return err
+		}
+		offer, err := r.Reply(ctx, discover)
+		if err != nil {
+			return fmt.Errorf("DHCPDISCOVER: %w", err)
+		}
+		if offer.YourIPAddr == nil || offer.YourIPAddr.IsUnspecified() {
+			return errors.New("DHCPDISCOVER: the DHCPOFFER has no address")
+		}
+		if offer.BootFileName == "" {
+			return errors.New("DHCPDISCOVER: the DHCPOFFER has no boot file, is the machine allowed to netboot?")
+		}
+		request, err := dhcpv4.NewRequestFromOffer(offer, mods...)
+		if err != nil {
+			return err
+		}
+		ack, err := r.Reply(ctx, request)
+		if err != nil {
+			return fmt.Errorf("DHCPREQUEST: %w", err)
+		}
+		if ack.MessageType() != dhcpv4.MessageTypeAck {
+			return fmt.Errorf("DHCPREQUEST: got a %s, want a DHCPACK", ack.MessageType())
+		}
+
+		return nil
+	}}
+}
+
+// Firmware returns the check of the replies of the DHCP handler r to the requests of the synthetic firmware fixtures
+// cs, as mac. Every reply must be as the firmware of its fixture expects, see firmware.Fixture.Check.
+func Firmware(r Replier, mac net.HardwareAddr, cs []firmware.Fixture) Check {
+	return Check{Name: "firmware", Run: func(ctx context.Context) error {
+		return firmware.Replay(ctx, r, mac, cs)
+	}}
+}
+
+// Script returns the check of the fetch of the iPXE script at the URL that url returns.
+func Script(c *http.Client, url func() string) Check {
+	return Check{Name: "script", Run: func(ctx context.Context) error {
+		resp, err := get(ctx, c, url(), "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(string(b), "#!ipxe") {
+			return errors.New("the response is not an iPXE script")
+		}
+
+		return nil
+	}}
+}
+
+// ISO returns the check of the fetch of a range of the ISO at the URL that url returns.
+func ISO(c *http.Client, url func() string) Check {
+	return Check{Name: "iso", Run: func(ctx context.Context) error {
+		resp, err := get(ctx, c, url(), fmt.Sprintf("bytes=0-%d", isoRange-1))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusPartialContent)
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, isoRange))
+		if err != nil {
+			return err
+		}
+		if n != isoRange {
+			return fmt.Errorf("got %d bytes of the ISO, want %d", n, isoRange)
+		}
+
+		return nil
+	}}
+}
+
+func get(ctx context.Context, c *http.Client, url, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	return c.Do(req)
+}
@@ -0,0 +1,161 @@
+package selftest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/firmware"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+type replier struct {
+	bootfile string
+	err      error
+}
+
+func (r replier) Reply(_ context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	typ := dhcpv4.MessageTypeOffer
+	if pkt.MessageType() == dhcpv4.MessageTypeRequest {
+		typ = dhcpv4.MessageTypeAck
+	}
+
+	return dhcpv4.NewReplyFromRequest(pkt,
+		dhcpv4.WithMessageType(typ),
+		dhcpv4.WithYourIP(net.IP{192, 168, 2, 10}),
+		dhcpv4.WithServerIP(net.IP{192, 168, 2, 1}),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IP{192, 168, 2, 1})),
+		func(d *dhcpv4.DHCPv4) { d.BootFileName = r.bootfile },
+	)
+}
+
+func TestDHCP(t *testing.T) {
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	tests := map[string]struct {
+		r       replier
+		wantErr string
+	}{
+		"pass":        {r: replier{bootfile: "ipxe.efi"}},
+		"no bootfile": {r: replier{}, wantErr: "no boot file"},
+		"no reply":    {r: replier{err: errors.New("no reply")}, wantErr: "DHCPDISCOVER: no reply"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := DHCP(tt.r, mac).Run(context.Background())
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFirmware(t *testing.T) {
+	cs, err := firmware.Fixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Firmware(replier{bootfile: "ipxe.efi"}, net.HardwareAddr{0, 1, 2, 3, 4, 5}, cs).Run(context.Background())
+	// the replier sends ipxe.efi to every firmware, without a class identifier.
+	for _, want := range []string{"dell-poweredge-bios-pxe: got boot file", "hpe-proliant-uefi-http: got class identifier", "u-boot-arm64: got boot file"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the problem %q, got %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "dell-poweredge-uefi-pxe") {
+		t.Errorf("unexpected problem of dell-poweredge-uefi-pxe: %v", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	iso := strings.Repeat("x", 4096)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != UserAgent {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/auto.ipxe":
+			_, _ = w.Write([]byte("#!ipxe\nboot\n"))
+		case "/hook.iso":
+			http.ServeContent(w, r, "hook.iso", time.Time{}, strings.NewReader(iso))
+		case "/short.iso":
+			_, _ = w.Write([]byte("x"))
+		case "/not-ipxe":
+			_, _ = w.Write([]byte("<html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	url := func(p string) func() string { return func() string { return srv.URL + p } }
+
+	tests := map[string]struct {
+		check   Check
+		wantErr string
+	}{
+		"script":         {check: Script(srv.Client(), url("/auto.ipxe"))},
+		"script missing": {check: Script(srv.Client(), url("/missing")), wantErr: "got status code 404"},
+		"not a script":   {check: Script(srv.Client(), url("/not-ipxe")), wantErr: "not an iPXE script"},
+		"iso":            {check: ISO(srv.Client(), url("/hook.iso"))},
+		"iso missing":    {check: ISO(srv.Client(), url("/missing")), wantErr: "got status code 404"},
+		"iso short":      {check: ISO(srv.Client(), url("/short.iso")), wantErr: "got 1 bytes"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.check.Run(context.Background())
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunner(t *testing.T) {
+	fail := true
+	r := &Runner{Log: logr.Discard(), Checks: []Check{
+		{Name: "dhcp", Run: func(context.Context) error { return ErrSkipped }},
+		{Name: "script", Run: func(context.Context) error {
+			if fail {
+				return errors.New("boom")
+			}
+			return nil
+		}},
+	}}
+	if r.Ready() {
+		t.Fatal("ready before the first run")
+	}
+	res := r.Run(context.Background())
+	if len(res) != 2 || res[1].Passed() || !res[0].Passed() {
+		t.Fatalf("unexpected results: %+v", res)
+	}
+	if r.Ready() {
+		t.Fatal("ready after a failed run")
+	}
+	fail = false
+	r.Run(context.Background())
+	if !r.Ready() {
+		t.Fatalf("not ready after a passed run: %+v", r.Last())
+	}
+}
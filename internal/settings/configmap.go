@@ -0,0 +1,108 @@
+package settings
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ConfigMapWatcher watches a single Kubernetes ConfigMap and keeps an in memory copy of the Settings it holds.
+// When the ConfigMap is deleted, the zero value Settings are used. When it holds invalid data, the previous Settings
+// are kept.
+type ConfigMapWatcher struct {
+	// Client is the Kubernetes client used to watch the ConfigMap.
+	Client kubernetes.Interface
+	// Namespace is the namespace of the ConfigMap.
+	Namespace string
+	// Name is the name of the ConfigMap.
+	Name string
+	// Log is used to log messages.
+	Log logr.Logger
+
+	mu      sync.RWMutex // protects current
+	current Settings
+	synced  chan struct{}
+	once    sync.Once
+}
+
+// Get implements the Reader interface and returns the latest Settings.
+func (w *ConfigMapWatcher) Get() Settings {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.current
+}
+
+// Synced returns a channel that is closed once the initial state of the ConfigMap has been read.
+func (w *ConfigMapWatcher) Synced() <-chan struct{} {
+	w.once.Do(func() { w.synced = make(chan struct{}) })
+
+	return w.synced
+}
+
+// Start watches the ConfigMap for changes. Start is a blocking method. Use a context cancellation to exit.
+func (w *ConfigMapWatcher) Start(ctx context.Context) error {
+	if w.Log.GetSink() == nil {
+		w.Log = logr.Discard()
+	}
+	_ = w.Synced()
+	f := informers.NewSharedInformerFactoryWithOptions(w.Client, 0,
+		informers.WithNamespace(w.Namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.Name).String()
+		}),
+	)
+	inf := f.Core().V1().ConfigMaps().Informer()
+	if _, err := inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.update,
+		UpdateFunc: func(_, obj interface{}) { w.update(obj) },
+		DeleteFunc: w.remove,
+	}); err != nil {
+		return err
+	}
+
+	f.Start(ctx.Done())
+	f.WaitForCacheSync(ctx.Done())
+	close(w.synced)
+	w.Log.Info("watching configmap for settings", "namespace", w.Namespace, "name", w.Name)
+	<-ctx.Done()
+	f.Shutdown()
+
+	return nil
+}
+
+func (w *ConfigMapWatcher) update(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || cm.Name != w.Name {
+		return
+	}
+	s, err := Parse(cm.Data)
+	if err != nil {
+		w.Log.Error(err, "invalid settings in configmap, keeping the previous settings", "namespace", cm.Namespace, "name", cm.Name, "resourceVersion", cm.ResourceVersion)
+		return
+	}
+	w.mu.Lock()
+	w.current = s
+	w.mu.Unlock()
+	w.Log.Info("settings updated from configmap", "namespace", cm.Namespace, "name", cm.Name, "resourceVersion", cm.ResourceVersion)
+}
+
+func (w *ConfigMapWatcher) remove(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	if cm, ok := obj.(*corev1.ConfigMap); !ok || cm.Name != w.Name {
+		return
+	}
+	w.mu.Lock()
+	w.current = Settings{}
+	w.mu.Unlock()
+	w.Log.Info("settings configmap deleted, using startup settings", "namespace", w.Namespace, "name", w.Name)
+}
@@ -0,0 +1,79 @@
+package settings
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapWatcher(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "smee", Namespace: "tink-system"},
+		Data:       map[string]string{KeyOSIEURL: "http://10.1.1.1/hook"},
+	}
+	client := fake.NewSimpleClientset(cm)
+	w := &ConfigMapWatcher{Client: client, Namespace: "tink-system", Name: "smee"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = w.Start(ctx) }()
+	<-w.Synced()
+
+	if diff := cmp.Diff(Settings{OSIEURL: "http://10.1.1.1/hook"}, w.Get()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	cm.Data = map[string]string{KeyExtraKernelArgs: "a=b"}
+	if _, err := client.CoreV1().ConfigMaps("tink-system").Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return cmp.Equal(Settings{ExtraKernelArgs: []string{"a=b"}}, w.Get()) })
+
+	if err := client.CoreV1().ConfigMaps("tink-system").Delete(ctx, "smee", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return cmp.Equal(Settings{}, w.Get()) })
+}
+
+func TestConfigMapWatcherInvalid(t *testing.T) {
+	w := &ConfigMapWatcher{Name: "smee"}
+	good := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "smee", Namespace: "tink-system"},
+		Data:       map[string]string{KeyAllowedMACs: "00:01:02:03:04:05"},
+	}
+	w.update(good)
+	want := w.Get()
+	if len(want.AllowedMACs) != 1 {
+		t.Fatalf("got allowed MACs %v, want the one of the configmap", want.AllowedMACs)
+	}
+
+	for key, value := range map[string]string{
+		KeyAllowedMACs:        "00:01:02:03:04:05,not-a-mac",
+		KeyMaintenance:        "maybe",
+		KeyMaintenanceSubnets: "10.0.0.0/33",
+	} {
+		bad := good.DeepCopy()
+		bad.Data = map[string]string{KeyAllowedMACs: "00:01:02:03:04:05", key: value}
+		w.update(bad)
+		if diff := cmp.Diff(want, w.Get()); diff != "" {
+			t.Fatalf("invalid %s: want the previous settings kept:\n%s", key, diff)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
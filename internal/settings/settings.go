@@ -0,0 +1,99 @@
+// Package settings holds Smee settings that can be changed at runtime, without a restart.
+package settings
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ConfigMap data keys read by the ConfigMapWatcher.
+const (
+	// KeyOSIEURL is the key for the URL where OSIE (HookOS) images are located.
+	KeyOSIEURL = "osie-url"
+	// KeyExtraKernelArgs is the key for a space separated list of extra kernel args (k=v k=v).
+	KeyExtraKernelArgs = "extra-kernel-args"
+	// KeyAllowedMACs is the key for a comma separated list of MAC addresses that are allowed to netboot.
+	KeyAllowedMACs = "allowed-macs"
+	// KeyMaintenance is the key for "true" to put Smee in maintenance, see the maintenance package.
+	KeyMaintenance = "maintenance"
+	// KeyMaintenanceSubnets is the key for a comma separated list of the CIDRs of the subnets in maintenance.
+	KeyMaintenanceSubnets = "maintenance-subnets"
+)
+
+// Settings are the values that can be changed at runtime.
+// A zero value field means no override and the value configured at startup is used.
+type Settings struct {
+	// OSIEURL is the URL where OSIE (HookOS) images are located.
+	OSIEURL string
+	// ExtraKernelArgs are appended to the kernel cmdline.
+	ExtraKernelArgs []string
+	// AllowedMACs, when not empty, are the only MAC addresses that will be served boot scripts.
+	AllowedMACs []net.HardwareAddr
+	// Maintenance puts every machine in maintenance, in addition to the maintenance switch of the admin API and flags.
+	Maintenance bool
+	// MaintenanceSubnets put the machines of the subnets in maintenance.
+	MaintenanceSubnets []netip.Prefix
+}
+
+// Reader returns the current Settings.
+type Reader interface {
+	Get() Settings
+}
+
+// Parse converts ConfigMap style data into Settings.
+// Unknown keys are ignored.
+func Parse(d map[string]string) (Settings, error) {
+	s := Settings{OSIEURL: strings.TrimSpace(d[KeyOSIEURL])}
+	if args := strings.Fields(d[KeyExtraKernelArgs]); len(args) > 0 {
+		s.ExtraKernelArgs = args
+	}
+	for _, m := range strings.Split(d[KeyAllowedMACs], ",") {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		mac, err := net.ParseMAC(m)
+		if err != nil {
+			return Settings{}, fmt.Errorf("invalid %s entry %q: %w", KeyAllowedMACs, m, err)
+		}
+		s.AllowedMACs = append(s.AllowedMACs, mac)
+	}
+	if v := strings.TrimSpace(d[KeyMaintenance]); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Settings{}, fmt.Errorf("invalid %s value %q: %w", KeyMaintenance, v, err)
+		}
+		s.Maintenance = b
+	}
+	for _, c := range strings.Split(d[KeyMaintenanceSubnets], ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return Settings{}, fmt.Errorf("invalid %s entry %q: %w", KeyMaintenanceSubnets, c, err)
+		}
+		s.MaintenanceSubnets = append(s.MaintenanceSubnets, p.Masked())
+	}
+
+	return s, nil
+}
+
+// MACAllowed reports whether mac is allowed to netboot.
+// All MAC addresses are allowed when AllowedMACs is empty.
+func (s Settings) MACAllowed(mac net.HardwareAddr) bool {
+	if len(s.AllowedMACs) == 0 {
+		return true
+	}
+	for _, m := range s.AllowedMACs {
+		if m.String() == mac.String() {
+			return true
+		}
+	}
+
+	return false
+}
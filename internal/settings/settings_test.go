@@ -0,0 +1,69 @@
+package settings
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		input     map[string]string
+		want      Settings
+		shouldErr bool
+	}{
+		"empty": {input: map[string]string{}, want: Settings{}},
+		"all": {
+			input: map[string]string{
+				KeyOSIEURL:         " http://10.1.1.1/hook ",
+				KeyExtraKernelArgs: "a=b  c=d",
+				KeyAllowedMACs:     "00:01:02:03:04:05, 00:01:02:03:04:06,",
+			},
+			want: Settings{
+				OSIEURL:         "http://10.1.1.1/hook",
+				ExtraKernelArgs: []string{"a=b", "c=d"},
+				AllowedMACs:     []net.HardwareAddr{{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, {0x00, 0x01, 0x02, 0x03, 0x04, 0x06}},
+			},
+		},
+		"invalid mac": {input: map[string]string{KeyAllowedMACs: "not-a-mac"}, shouldErr: true},
+		"maintenance": {
+			input: map[string]string{KeyMaintenance: "true", KeyMaintenanceSubnets: "192.168.2.1/24, 10.0.0.0/8"},
+			want:  Settings{Maintenance: true, MaintenanceSubnets: []netip.Prefix{netip.MustParsePrefix("192.168.2.0/24"), netip.MustParsePrefix("10.0.0.0/8")}},
+		},
+		"invalid maintenance":        {input: map[string]string{KeyMaintenance: "yes please"}, shouldErr: true},
+		"invalid maintenance subnet": {input: map[string]string{KeyMaintenanceSubnets: "192.168.2.0"}, shouldErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.shouldErr != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tt.shouldErr, err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.EquateComparable(netip.Prefix{})); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestMACAllowed(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := map[string]struct {
+		settings Settings
+		want     bool
+	}{
+		"empty allow list":  {settings: Settings{}, want: true},
+		"in allow list":     {settings: Settings{AllowedMACs: []net.HardwareAddr{mac}}, want: true},
+		"not in allow list": {settings: Settings{AllowedMACs: []net.HardwareAddr{{0x00, 0x01, 0x02, 0x03, 0x04, 0x06}}}, want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.settings.MACAllowed(mac); got != tt.want {
+				t.Fatalf("want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
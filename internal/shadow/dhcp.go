@@ -0,0 +1,140 @@
+package shadow
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/reservation"
+	"github.com/tinkerbell/smee/internal/limit"
+)
+
+// Replier returns the DHCP reply to a DHCP message without sending it.
+// It is implemented by the reservation DHCP handler.
+type Replier interface {
+	Reply(ctx context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error)
+}
+
+// DHCP is a DHCP server handler that mirrors DHCPDISCOVER and DHCPREQUEST messages to the shadow
+// and compares its replies with the replies of Primary.
+//
+// The messages are sent to the shadow from an ephemeral port, without the relay agent IP address, so that
+// the shadow replies to Smee and never to the relay agent or the machine.
+type DHCP struct {
+	// Addr is the DHCP address of the shadow.
+	Addr netip.AddrPort
+	// Primary returns the replies of this Smee.
+	Primary Replier
+	// Timeout is how long to wait for the reply of the shadow, DefaultTimeout when 0.
+	// A machine that the shadow doesn't reply to within the timeout is not served by the shadow.
+	Timeout time.Duration
+	// Ignore are removed from the replies before they are compared, for example the server identifier of the shadow.
+	Ignore []*regexp.Regexp
+	Logger logr.Logger
+
+	once     sync.Once
+	inFlight *limit.Limiter
+}
+
+// Handle mirrors the DHCP message in p to the shadow in the background. conn is not used, nothing is sent to the machine.
+func (s *DHCP) Handle(ctx context.Context, _ data.Conn, p data.Packet) {
+	if p.Pkt == nil {
+		return
+	}
+	if mt := p.Pkt.MessageType(); mt != dhcpv4.MessageTypeDiscover && mt != dhcpv4.MessageTypeRequest {
+		return
+	}
+	s.once.Do(func() { s.inFlight = limit.NewLimiter(maxInFlight) })
+	if !s.inFlight.TryAcquire() {
+		observe("dhcp", ResultSkipped)
+		return
+	}
+	go func() {
+		defer s.inFlight.Release()
+		s.compare(ctx, p.Pkt)
+	}()
+}
+
+func (s *DHCP) compare(ctx context.Context, pkt *dhcpv4.DHCPv4) {
+	log := s.Logger.WithValues("mac", pkt.ClientHWAddr.String(), "xid", pkt.TransactionID.String(), "type", pkt.MessageType().String())
+	primary, err := s.Primary.Reply(ctx, pkt)
+	if err != nil && !errors.Is(err, reservation.ErrNoReply) {
+		log.Info("unable to get the DHCP reply to compare with the shadow", "error", err)
+		observe("dhcp", ResultError)
+		return
+	}
+	shadow, err := s.mirror(pkt)
+	if err != nil {
+		log.Info("unable to mirror the DHCP message to the shadow", "error", err)
+		observe("dhcp", ResultError)
+		return
+	}
+	if d := diff(summary(primary), summary(shadow), s.Ignore); d != "" {
+		log.Info("the DHCP reply of the shadow differs (-smee +shadow)", "diff", d)
+		observe("dhcp", ResultMismatch)
+		return
+	}
+	log.V(1).Info("the DHCP reply of the shadow matches")
+	observe("dhcp", ResultMatch)
+}
+
+// mirror sends pkt to the shadow and returns its reply, nil when it doesn't reply within the timeout.
+func (s *DHCP) mirror(pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	m, err := dhcpv4.FromBytes(pkt.ToBytes())
+	if err != nil {
+		return nil, err
+	}
+	giaddr := m.GatewayIPAddr
+	m.GatewayIPAddr = net.IPv4zero
+	conn, err := net.DialUDP("udp4", nil, net.UDPAddrFromAddrPort(s.Addr))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(m.ToBytes()); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		reply, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil || reply.OpCode != dhcpv4.OpcodeBootReply || reply.TransactionID != m.TransactionID {
+			continue
+		}
+		// the replies of Smee keep the relay agent IP address of the message.
+		reply.GatewayIPAddr = giaddr
+
+		return reply, nil
+	}
+}
+
+// summary returns the lines of the summary of a DHCP reply, nil when there is no reply.
+func summary(reply *dhcpv4.DHCPv4) []string {
+	if reply == nil {
+		return nil
+	}
+
+	return strings.Split(reply.Summary(), "\n")
+}
@@ -0,0 +1,106 @@
+package shadow
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/reservation"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+type fakeReplier struct {
+	bootFile string
+}
+
+func (f fakeReplier) Reply(_ context.Context, pkt *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	if f.bootFile == "" {
+		return nil, fmt.Errorf("%w: not found", reservation.ErrNoReply)
+	}
+	return reply(pkt, f.bootFile)
+}
+
+func reply(pkt *dhcpv4.DHCPv4, bootFile string) (*dhcpv4.DHCPv4, error) {
+	return dhcpv4.NewReplyFromRequest(pkt, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer), dhcpv4.WithYourIP(net.IP{10, 1, 0, 20}), func(d *dhcpv4.DHCPv4) {
+		d.BootFileName = bootFile
+	})
+}
+
+// fakeShadow replies to the DHCP messages that it receives with bootFile, the relay agent IP addresses of the messages are sent to giaddrs.
+func fakeShadow(t *testing.T, bootFile string, giaddrs chan<- net.IP) netip.AddrPort {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, peer, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pkt, err := dhcpv4.FromBytes(buf[:n])
+			if err != nil {
+				continue
+			}
+			giaddrs <- pkt.GatewayIPAddr
+			r, _ := reply(pkt, bootFile)
+			_, _ = conn.WriteToUDP(r.ToBytes(), peer)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func TestDHCP(t *testing.T) {
+	giaddrs := make(chan net.IP, 10)
+	addr := fakeShadow(t, "snp.efi", giaddrs)
+	pkt, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, dhcpv4.WithGatewayIP(net.IP{10, 1, 0, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		primary string
+		result  string
+	}{
+		"match":    {primary: "snp.efi", result: ResultMatch},
+		"mismatch": {primary: "ipxe.efi", result: ResultMismatch},
+		"no reply": {result: ResultMismatch},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &DHCP{Addr: addr, Primary: fakeReplier{bootFile: tt.primary}, Timeout: time.Second, Logger: logr.Discard()}
+			want := testutil.ToFloat64(metric.ShadowComparisons.WithLabelValues("dhcp", tt.result)) + 1
+			s.Handle(context.Background(), nil, data.Packet{Pkt: pkt})
+			if got := <-giaddrs; !got.IsUnspecified() {
+				t.Fatalf("got relay agent IP address %v mirrored to the shadow, want none", got)
+			}
+			waitFor(t, "dhcp", tt.result, want)
+		})
+	}
+}
+
+func TestDHCPNoShadowReply(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := &DHCP{Addr: conn.LocalAddr().(*net.UDPAddr).AddrPort(), Primary: fakeReplier{}, Timeout: 50 * time.Millisecond, Logger: logr.Discard()}
+	pkt, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testutil.ToFloat64(metric.ShadowComparisons.WithLabelValues("dhcp", ResultMatch)) + 1
+	s.Handle(context.Background(), nil, data.Packet{Pkt: pkt})
+	waitFor(t, "dhcp", ResultMatch, want)
+}
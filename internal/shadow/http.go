@@ -0,0 +1,147 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/limit"
+)
+
+// maxBody is the size of the largest response that is compared, iPXE scripts are much smaller.
+const maxBody = 1 << 20
+
+// HTTP mirrors the GET requests of an iPXE script handler to the shadow and compares its responses.
+//
+// The shadow is sent the IP address of the machine in the X-Forwarded-For header, the shadow must
+// trust Smee as a proxy to identify machines by IP address.
+type HTTP struct {
+	// URL is the base URL of the shadow, the path and query of a request are appended to it.
+	URL *url.URL
+	// Transport sends the requests to the shadow, http.DefaultTransport when nil.
+	Transport http.RoundTripper
+	// Timeout is how long to wait for the response of the shadow, DefaultTimeout when 0.
+	Timeout time.Duration
+	// Ignore are removed from the responses before they are compared, for example the OSIE URL of the shadow.
+	Ignore []*regexp.Regexp
+	Logger logr.Logger
+
+	once     sync.Once
+	inFlight *limit.Limiter
+}
+
+// Handler returns next with its GET requests mirrored to the shadow in the background.
+// The response of next is sent to the machine unchanged.
+func (s *HTTP) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next(w, r)
+			return
+		}
+		rec := &recorder{ResponseWriter: w}
+		next(rec, r)
+		s.once.Do(func() { s.inFlight = limit.NewLimiter(maxInFlight) })
+		if rec.truncated || !s.inFlight.TryAcquire() {
+			observe("http", ResultSkipped)
+			return
+		}
+		req := s.request(r)
+		go func() {
+			defer s.inFlight.Release()
+			s.compare(req, rec)
+		}()
+	}
+}
+
+// request returns the request to mirror r to the shadow, without a context.
+func (s *HTTP) request(r *http.Request) *http.Request {
+	u := s.URL.JoinPath(r.URL.Path)
+	u.RawQuery = r.URL.RawQuery
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}, Host: u.Host}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+	if ua := r.UserAgent(); ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+
+	return req
+}
+
+func (s *HTTP) compare(req *http.Request, primary *recorder) {
+	log := s.Logger.WithValues("path", req.URL.Path, "client", req.Header.Get("X-Forwarded-For"))
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c := &http.Client{Transport: s.Transport}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		log.Info("unable to mirror the request to the shadow", "error", err)
+		observe("http", ResultError)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		log.Info("unable to read the response of the shadow", "error", err)
+		observe("http", ResultError)
+		return
+	}
+	if d := diff(lines(primary.code(), primary.body.Bytes()), lines(resp.StatusCode, body), s.Ignore); d != "" {
+		log.Info("the response of the shadow differs (-smee +shadow)", "diff", d)
+		observe("http", ResultMismatch)
+		return
+	}
+	log.V(1).Info("the response of the shadow matches")
+	observe("http", ResultMatch)
+}
+
+// lines returns the status code and the lines of the body of a response.
+func lines(status int, body []byte) []string {
+	return append([]string{fmt.Sprintf("status: %d", status)}, strings.Split(string(body), "\n")...)
+}
+
+// recorder records the status code and the body of a response as it is written.
+type recorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.body.Len()+len(b) > maxBody {
+		r.truncated = true
+	} else {
+		r.body.Write(b)
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
+}
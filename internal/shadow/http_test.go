@@ -0,0 +1,44 @@
+package shadow
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestHTTP(t *testing.T) {
+	var gotPath, gotXFF string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotXFF = r.URL.RequestURI(), r.Header.Get("X-Forwarded-For")
+		fmt.Fprintf(w, "#!ipxe\nkernel vmlinuz %s\n", r.URL.Query().Get("args"))
+	}))
+	defer hs.Close()
+	u, _ := url.Parse(hs.URL + "/shadow")
+	s := &HTTP{URL: u, Logger: logr.Discard()}
+	hf := s.Handler(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "#!ipxe\nkernel vmlinuz a=b\n")
+	})
+
+	match := testutil.ToFloat64(metric.ShadowComparisons.WithLabelValues("http", ResultMatch))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/00:01:02:03:04:05/auto.ipxe?args=a=b", nil)
+	r.RemoteAddr = "10.1.0.20:4321"
+	hf(w, r)
+	if w.Body.String() != "#!ipxe\nkernel vmlinuz a=b\n" {
+		t.Fatalf("got response %q, want the response of the handler", w.Body.String())
+	}
+	waitFor(t, "http", ResultMatch, match+1)
+	if gotPath != "/shadow/00:01:02:03:04:05/auto.ipxe?args=a=b" || gotXFF != "10.1.0.20" {
+		t.Fatalf("got shadow request %q from %q", gotPath, gotXFF)
+	}
+
+	mismatch := testutil.ToFloat64(metric.ShadowComparisons.WithLabelValues("http", ResultMismatch))
+	hf(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/auto.ipxe?args=c=d", nil))
+	waitFor(t, "http", ResultMismatch, mismatch+1)
+}
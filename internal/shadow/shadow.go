@@ -0,0 +1,78 @@
+// Package shadow mirrors DHCP and iPXE script requests to a shadow, a secondary Smee, and logs the differences of
+// its responses. The responses of the shadow are never sent to machines, so a new Smee version or configuration can be
+// validated against real traffic before switching to it.
+package shadow
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// DefaultTimeout is how long to wait for the response of the shadow when no timeout is configured.
+const DefaultTimeout = 5 * time.Second
+
+// maxInFlight is the number of mirrored requests waiting for the shadow, requests over the limit are not mirrored.
+const maxInFlight = 128
+
+// Results of comparing the responses of Smee and the shadow.
+const (
+	ResultMatch    = "match"
+	ResultMismatch = "mismatch"
+	ResultError    = "error"
+	ResultSkipped  = "skipped"
+)
+
+// traceIgnore matches the trace IDs and W3C traceparents that are unique to every response.
+var traceIgnore = []*regexp.Regexp{
+	regexp.MustCompile(`[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}`),
+	regexp.MustCompile(`TraceID: [0-9a-f]{32}`),
+}
+
+// ParseIgnore parses a comma separated list of regular expressions.
+func ParseIgnore(s string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// diff returns the differences of the lines of the primary and shadow responses, it is empty when they match.
+// The matches of the ignore expressions and of trace IDs are removed from the lines before they are compared.
+func diff(primary, shadow []string, ignore []*regexp.Regexp) string {
+	ignore = append(slices.Clone(traceIgnore), ignore...)
+
+	return cmp.Diff(normalize(primary, ignore), normalize(shadow, ignore))
+}
+
+func normalize(lines []string, ignore []*regexp.Regexp) []string {
+	res := make([]string, 0, len(lines))
+	for _, l := range lines {
+		for _, re := range ignore {
+			l = re.ReplaceAllString(l, "")
+		}
+		if l = strings.TrimSpace(l); l != "" {
+			res = append(res, l)
+		}
+	}
+
+	return res
+}
+
+func observe(protocol, result string) {
+	metric.ShadowComparisons.With(prometheus.Labels{"protocol": protocol, "result": result}).Inc()
+}
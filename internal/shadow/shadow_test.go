@@ -0,0 +1,69 @@
+package shadow
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+func TestDiff(t *testing.T) {
+	ignore, err := ParseIgnore(`Server Identifier: .*, 10\.1\.0\.[56]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		primary []string
+		shadow  []string
+		want    bool
+	}{
+		"equal":       {primary: []string{"a", "b"}, shadow: []string{"a", "b"}},
+		"different":   {primary: []string{"a", "b"}, shadow: []string{"a", "c"}, want: true},
+		"no reply":    {primary: []string{"a"}, want: true},
+		"blank lines": {primary: []string{"a", "", "b  "}, shadow: []string{"a", "b"}},
+		"trace": {
+			primary: []string{"echo Debug TraceID: 23b1e307bb35484f535a1f772c06910e", "kernel traceparent=00-23b1e307bb35484f535a1f772c06910e-d887dc3912240434-01"},
+			shadow:  []string{"echo Debug TraceID: 0af7651916cd43dd8448eb211c80319c", "kernel traceparent=00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		},
+		"ignored": {
+			primary: []string{"Server Identifier: 10.1.0.5", "set download-url http://10.1.0.5/hook"},
+			shadow:  []string{"Server Identifier: 10.1.0.6", "set download-url http://10.1.0.6/hook"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := diff(tt.primary, tt.shadow, ignore); (got != "") != tt.want {
+				t.Fatalf("got diff %q, want a diff %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIgnore(t *testing.T) {
+	if _, err := ParseIgnore("a,(b"); err == nil {
+		t.Fatal("expected an error for an invalid regular expression")
+	}
+	got, err := ParseIgnore("")
+	if err != nil || len(got) != 0 {
+		t.Fatalf("got %v, %v, want no expressions", got, err)
+	}
+}
+
+// waitFor waits for the comparisons of protocol with result to reach want.
+func waitFor(t *testing.T, protocol, result string, want float64) {
+	t.Helper()
+	c := metric.ShadowComparisons.WithLabelValues(protocol, result)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if testutil.ToFloat64(c) >= want {
+			return
+		}
+	}
+	t.Fatalf("got %v %s %s comparisons, want %v", testutil.ToFloat64(c), protocol, result, want)
+}
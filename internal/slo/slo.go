@@ -0,0 +1,319 @@
+// Package slo tracks the service level objectives of the boot stages of machines, like an OFFER within 2s of the
+// DISCOVER and the boot script served within 30s of the OFFER, turning the boot events of Smee into provisioning
+// health signals: every stage is counted as met, late or timed out, and the violations are POSTed to a webhook.
+package slo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// Stage is a boot stage of a machine.
+type Stage string
+
+const (
+	// Offer is from the DISCOVER of a netboot client to the OFFER that is sent to it.
+	Offer Stage = "offer"
+	// Script is from the OFFER of a boot file to the boot script that is served to the machine.
+	Script Stage = "script"
+	// ISO is from the start of an ISO mount of the machine to its phone home.
+	ISO Stage = "iso"
+	// Boot is the whole netboot, from the first DISCOVER of the machine to its phone home.
+	Boot Stage = "boot"
+)
+
+// Stages are the boot stages, in boot order.
+var Stages = []Stage{Offer, Script, ISO, Boot}
+
+// ParseThresholds parses a comma separated list of <stage>=<duration>, for example offer=2s,script=30s,iso=10m.
+func ParseThresholds(s string) (map[Stage]time.Duration, error) {
+	t := map[Stage]time.Duration{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid threshold %q, must be <stage>=<duration>", e)
+		}
+		st := Stage(strings.TrimSpace(k))
+		if !slices.Contains(Stages, st) {
+			return nil, fmt.Errorf("unknown boot stage %q, must be one of offer, script, iso or boot", st)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold of %v: %w", st, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("threshold of %v must be positive", st)
+		}
+		t[st] = d
+	}
+
+	return t, nil
+}
+
+// Violation is a boot stage of a machine that missed its objective, the body of the webhook alerts.
+type Violation struct {
+	MAC   string `json:"mac"`
+	Stage Stage  `json:"stage"`
+	// Result is late when the stage ended after its threshold, timeout when it didn't end within its threshold.
+	Result           string    `json:"result"`
+	ThresholdSeconds float64   `json:"thresholdSeconds"`
+	ElapsedSeconds   float64   `json:"elapsedSeconds"`
+	StartedAt        time.Time `json:"startedAt"`
+}
+
+// alertBuffer is the number of violations that wait to be sent to the webhook, violations are dropped when it is full.
+const alertBuffer = 64
+
+// sessionIdle is how long a machine without a stage in progress is remembered after its last DISCOVER.
+const sessionIdle = time.Hour
+
+// session is the boot session of a machine.
+type session struct {
+	// discover is the time of the first DISCOVER of the session, the start of the Boot stage, zero between sessions.
+	discover time.Time
+	// started holds the stages in progress and their start.
+	started map[Stage]time.Time
+}
+
+// Tracker tracks the boot stages of machines against their thresholds. It implements handler.MessageObserver,
+// script.Observer, iso.Observer and phonehome.Observer.
+type Tracker struct {
+	// Thresholds are the objectives of the stages, the stages without one are not tracked.
+	Thresholds map[Stage]time.Duration
+	// Webhook, when set, is the URL that every Violation is POSTed to as JSON.
+	Webhook string
+	// Transport sends the webhook requests, http.DefaultTransport when nil.
+	Transport http.RoundTripper
+	Log       logr.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	alerts   chan Violation
+	once     sync.Once
+	// now is time.Now, it is replaced in tests.
+	now func() time.Time
+}
+
+// DHCPServed implements handler.Observer, the replies are tracked by DHCPReplied.
+func (t *Tracker) DHCPServed(context.Context, net.HardwareAddr, string) {}
+
+// DHCPReceived implements handler.MessageObserver, the DISCOVER of a netboot client starts the Offer stage.
+func (t *Tracker) DHCPReceived(_ context.Context, req *dhcpv4.DHCPv4) {
+	if req.MessageType() != dhcpv4.MessageTypeDiscover || (dhcp.IsNetbootClient(req) != nil && !dhcp.IsUBootClient(req)) {
+		return
+	}
+	t.update(req.ClientHWAddr, func(s *session, now time.Time) {
+		if s.discover.IsZero() {
+			s.discover = now
+		}
+		t.start(s, Offer, now)
+	})
+}
+
+// DHCPReplied implements handler.MessageObserver, an OFFER ends the Offer stage, and an OFFER with a boot file starts
+// the Script and Boot stages.
+func (t *Tracker) DHCPReplied(_ context.Context, reply *dhcpv4.DHCPv4) {
+	if reply.MessageType() != dhcpv4.MessageTypeOffer {
+		return
+	}
+	netboot := reply.BootFileName != "" || reply.Options.Has(dhcpv4.OptionBootfileName)
+	t.update(reply.ClientHWAddr, func(s *session, now time.Time) {
+		t.end(reply.ClientHWAddr, s, Offer, now)
+		if !netboot {
+			// the machine is not netbooted, like one that is not allowed to, its next DISCOVER starts a new session.
+			s.discover = time.Time{}
+			return
+		}
+		t.start(s, Script, now)
+		if s.discover.IsZero() {
+			s.discover = now
+		}
+		t.start(s, Boot, s.discover)
+	})
+}
+
+// ScriptServed implements script.Observer, it ends the Script stage.
+func (t *Tracker) ScriptServed(_ context.Context, mac net.HardwareAddr, _ string) {
+	t.update(mac, func(s *session, now time.Time) {
+		t.end(mac, s, Script, now)
+	})
+}
+
+// ISOServed implements iso.Observer, it starts the ISO stage.
+func (t *Tracker) ISOServed(_ context.Context, mac net.HardwareAddr) {
+	t.update(mac, func(s *session, now time.Time) {
+		t.start(s, ISO, now)
+	})
+}
+
+// BootCompleted implements phonehome.Observer, it ends the ISO and Boot stages and the boot session of the machine.
+func (t *Tracker) BootCompleted(_ context.Context, mac net.HardwareAddr) {
+	t.update(mac, func(s *session, now time.Time) {
+		t.end(mac, s, ISO, now)
+		t.end(mac, s, Boot, now)
+		s.discover = time.Time{}
+	})
+}
+
+// update runs f with the session of mac, with t.mu held.
+func (t *Tracker) update(mac net.HardwareAddr, f func(s *session, now time.Time)) {
+	if len(t.Thresholds) == 0 {
+		return
+	}
+	now := t.clock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessions == nil {
+		t.sessions = map[string]*session{}
+	}
+	s, ok := t.sessions[mac.String()]
+	if !ok {
+		s = &session{started: map[Stage]time.Time{}}
+		t.sessions[mac.String()] = s
+	}
+	f(s, now)
+}
+
+// start starts the stage at now, unless it is in progress or not tracked.
+func (t *Tracker) start(s *session, st Stage, now time.Time) {
+	if _, ok := t.Thresholds[st]; !ok {
+		return
+	}
+	if _, ok := s.started[st]; !ok {
+		s.started[st] = now
+	}
+}
+
+// end ends the stage at now, if it is in progress.
+func (t *Tracker) end(mac net.HardwareAddr, s *session, st Stage, now time.Time) {
+	started, ok := s.started[st]
+	if !ok {
+		return
+	}
+	delete(s.started, st)
+	elapsed := now.Sub(started)
+	metric.BootStageDuration.WithLabelValues(string(st)).Observe(elapsed.Seconds())
+	if elapsed <= t.Thresholds[st] {
+		metric.BootSLOResults.WithLabelValues(string(st), "met").Inc()
+		return
+	}
+	t.violate(Violation{MAC: mac.String(), Stage: st, Result: "late", ElapsedSeconds: elapsed.Seconds(), StartedAt: started})
+}
+
+// Check times out the stages in progress for longer than their threshold, and forgets the idle sessions.
+func (t *Tracker) Check() {
+	now := t.clock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for mac, s := range t.sessions {
+		for st, started := range s.started {
+			if elapsed := now.Sub(started); elapsed > t.Thresholds[st] {
+				delete(s.started, st)
+				if st == Boot {
+					s.discover = time.Time{}
+				}
+				t.violate(Violation{MAC: mac, Stage: st, Result: "timeout", ElapsedSeconds: elapsed.Seconds(), StartedAt: started})
+			}
+		}
+		if len(s.started) == 0 && now.Sub(s.discover) > sessionIdle {
+			delete(t.sessions, mac)
+		}
+	}
+}
+
+// violate counts v and queues it for the webhook, with t.mu held.
+func (t *Tracker) violate(v Violation) {
+	v.ThresholdSeconds = t.Thresholds[v.Stage].Seconds()
+	metric.BootSLOResults.WithLabelValues(string(v.Stage), v.Result).Inc()
+	t.log().Info("boot stage missed its objective", "mac", v.MAC, "stage", v.Stage, "result", v.Result, "elapsed", v.ElapsedSeconds, "threshold", v.ThresholdSeconds)
+	if t.Webhook == "" {
+		return
+	}
+	select {
+	case t.queue() <- v:
+	default:
+		t.log().Info("dropping the alert of a boot stage, the webhook is too slow", "mac", v.MAC, "stage", v.Stage)
+	}
+}
+
+func (t *Tracker) queue() chan Violation {
+	t.once.Do(func() { t.alerts = make(chan Violation, alertBuffer) })
+
+	return t.alerts
+}
+
+// Run checks the stages in progress every second and sends the violations to the webhook, until ctx is done.
+func (t *Tracker) Run(ctx context.Context) error {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	alerts := t.queue()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			t.Check()
+		case v := <-alerts:
+			if err := t.send(ctx, v); err != nil {
+				t.log().Error(err, "failed to send the alert of a boot stage to the webhook", "mac", v.MAC, "stage", v.Stage)
+			}
+		}
+	}
+}
+
+// send POSTs v to the webhook.
+func (t *Tracker) send(ctx context.Context, v Violation) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Webhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Transport: t.Transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+
+	return nil
+}
+
+func (t *Tracker) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+
+	return time.Now()
+}
+
+func (t *Tracker) log() logr.Logger {
+	if t.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return t.Log
+}
@@ -0,0 +1,199 @@
+package slo
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+var mac = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+
+// discover returns the DISCOVER of a PXE client, or of a client that doesn't netboot.
+func discover(t *testing.T, pxe bool) *dhcpv4.DHCPv4 {
+	t.Helper()
+	var mods []dhcpv4.Modifier
+	if pxe {
+		mods = append(mods,
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003001")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+			dhcpv4.WithGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 1}),
+		)
+	}
+	pkt, err := dhcpv4.NewDiscovery(mac, mods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pkt
+}
+
+// offer returns an OFFER to mac, with the boot file name.
+func offer(t *testing.T, bootfile string) *dhcpv4.DHCPv4 {
+	t.Helper()
+	pkt, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt.BootFileName = bootfile
+
+	return pkt
+}
+
+// clock is a settable time.Now.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func results(st Stage, result string) float64 {
+	return testutil.ToFloat64(metric.BootSLOResults.WithLabelValues(string(st), result))
+}
+
+func TestStages(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Now()}
+	tr := &Tracker{Thresholds: map[Stage]time.Duration{Offer: 2 * time.Second, Script: 30 * time.Second, ISO: 10 * time.Minute, Boot: 15 * time.Minute}, now: c.now}
+	before := map[string]float64{}
+	for _, st := range Stages {
+		for _, r := range []string{"met", "late", "timeout"} {
+			before[string(st)+"/"+r] = results(st, r)
+		}
+	}
+
+	tr.DHCPReceived(ctx, discover(t, true))
+	c.advance(time.Second)
+	tr.DHCPReplied(ctx, offer(t, "ipxe.efi"))
+	c.advance(40 * time.Second)
+	tr.ScriptServed(ctx, mac, "auto.ipxe")
+	c.advance(time.Minute)
+	tr.ISOServed(ctx, mac)
+	c.advance(5 * time.Minute)
+	tr.BootCompleted(ctx, mac)
+
+	got := map[string]float64{}
+	for _, st := range Stages {
+		for _, r := range []string{"met", "late", "timeout"} {
+			if d := results(st, r) - before[string(st)+"/"+r]; d != 0 {
+				got[string(st)+"/"+r] = d
+			}
+		}
+	}
+	want := map[string]float64{"offer/met": 1, "script/late": 1, "iso/met": 1, "boot/met": 1}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Now()}
+	tr := &Tracker{Thresholds: map[Stage]time.Duration{Script: 30 * time.Second}, Webhook: "http://example.invalid", now: c.now}
+	before := results(Script, "timeout")
+
+	tr.DHCPReceived(ctx, discover(t, true))
+	tr.DHCPReplied(ctx, offer(t, "ipxe.efi"))
+	c.advance(20 * time.Second)
+	tr.Check()
+	if got := results(Script, "timeout") - before; got != 0 {
+		t.Fatalf("got %v timeouts before the threshold, want 0", got)
+	}
+	c.advance(20 * time.Second)
+	tr.Check()
+	if got := results(Script, "timeout") - before; got != 1 {
+		t.Fatalf("got %v timeouts, want 1", got)
+	}
+	select {
+	case v := <-tr.queue():
+		want := Violation{MAC: mac.String(), Stage: Script, Result: "timeout", ThresholdSeconds: 30, ElapsedSeconds: 40, StartedAt: c.t.Add(-40 * time.Second)}
+		if diff := cmp.Diff(want, v); diff != "" {
+			t.Fatal(diff)
+		}
+	default:
+		t.Fatal("want the violation queued for the webhook")
+	}
+
+	// the script served after the timeout is not counted again.
+	late := results(Script, "late")
+	tr.ScriptServed(ctx, mac, "auto.ipxe")
+	if got := results(Script, "late") - late; got != 0 {
+		t.Fatalf("got %v late results after a timeout, want 0", got)
+	}
+}
+
+func TestNotNetbooted(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Now()}
+	tr := &Tracker{Thresholds: map[Stage]time.Duration{Script: 30 * time.Second, Boot: time.Minute}, now: c.now}
+	before := results(Script, "timeout") + results(Boot, "timeout")
+
+	// a DHCP client that doesn't netboot, and a PXE client that is offered no boot file.
+	tr.DHCPReceived(ctx, discover(t, false))
+	tr.DHCPReplied(ctx, offer(t, ""))
+	tr.DHCPReceived(ctx, discover(t, true))
+	tr.DHCPReplied(ctx, offer(t, ""))
+	c.advance(time.Hour)
+	tr.Check()
+	if got := results(Script, "timeout") + results(Boot, "timeout") - before; got != 0 {
+		t.Fatalf("got %v timeouts, want none for machines that are not netbooted", got)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	got := make(chan Violation, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v Violation
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Error(err)
+		}
+		got <- v
+	}))
+	defer srv.Close()
+
+	tr := &Tracker{Thresholds: map[Stage]time.Duration{Offer: time.Second}, Webhook: srv.URL}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = tr.Run(ctx) }()
+	tr.mu.Lock()
+	tr.violate(Violation{MAC: mac.String(), Stage: Offer, Result: "late", ElapsedSeconds: 3})
+	tr.mu.Unlock()
+	select {
+	case v := <-got:
+		if v.Stage != Offer || v.Result != "late" || v.ThresholdSeconds != 1 {
+			t.Fatalf("got %+v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the violation POSTed to the webhook")
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	got, err := ParseThresholds("offer=2s, script=30s,iso=10m,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Stage]time.Duration{Offer: 2 * time.Second, Script: 30 * time.Second, ISO: 10 * time.Minute}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+	for _, s := range []string{"offer", "pxe=2s", "offer=soon", "offer=0s"} {
+		if _, err := ParseThresholds(s); err == nil {
+			t.Fatalf("want an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package snapshot keeps the last renderings of the boot scripts of machines, like auto.ipxe and grub.cfg, so that
+// what changed between two boots of a machine can be compared, and a machine can be pinned to a previous rendering
+// to roll it back.
+//
+// The renderings are kept in memory, and in a JSON file per machine in a directory when one is set, so that they
+// survive restarts.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a machine has no snapshot of the requested version.
+var ErrNotFound = errors.New("snapshot not found")
+
+// Snapshot is a rendering of a boot script that was served to a machine.
+type Snapshot struct {
+	// Version numbers the snapshots of a machine, in the order they were served, starting at 1.
+	Version int `json:"version"`
+	// Name is the name of the boot script, like auto.ipxe, hook.ipxe or grub.cfg.
+	Name    string    `json:"name"`
+	Time    time.Time `json:"time"`
+	Content string    `json:"content"`
+}
+
+// Store holds the last snapshots of every machine. A nil Store keeps no snapshot.
+type Store struct {
+	max int
+	dir string
+
+	mu       sync.Mutex
+	machines map[string]*machine
+	now      func() time.Time
+}
+
+// machine is the snapshots of a machine, it is the content of its file.
+type machine struct {
+	Snapshots []Snapshot `json:"snapshots"`
+	// Pinned is the snapshot that the machine is served in place of a new rendering of the script of its name.
+	Pinned *Snapshot `json:"pinned,omitempty"`
+}
+
+// NewStore returns a Store that keeps the last max snapshots of every machine. When dir is set, the snapshots are
+// loaded from, and saved to, a JSON file per machine in it. It returns nil, no snapshots, when max is 0 or less.
+func NewStore(max int, dir string) (*Store, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	s := &Store{max: max, dir: dir, machines: map[string]*machine{}, now: time.Now}
+	if dir == "" {
+		return s, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		mac, err := net.ParseMAC(strings.ReplaceAll(strings.TrimSuffix(filepath.Base(f), ".json"), "-", ":"))
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		m := &machine{}
+		if err := json.Unmarshal(b, m); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		s.machines[mac.String()] = m
+	}
+
+	return s, nil
+}
+
+// Record adds the rendering of the script name that mac was served as its latest snapshot, the oldest snapshots
+// over the maximum are dropped.
+func (s *Store) Record(mac net.HardwareAddr, name, content string) (Snapshot, error) {
+	if s == nil {
+		return Snapshot{}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.machine(mac)
+	sn := Snapshot{Version: 1, Name: name, Time: s.now(), Content: content}
+	if n := len(m.Snapshots); n > 0 {
+		sn.Version = m.Snapshots[n-1].Version + 1
+	}
+	m.Snapshots = append(m.Snapshots, sn)
+	if len(m.Snapshots) > s.max {
+		m.Snapshots = m.Snapshots[len(m.Snapshots)-s.max:]
+	}
+
+	return sn, s.save(mac, m)
+}
+
+// List returns the snapshots of mac, oldest first, and the snapshot it is pinned to, nil when it isn't pinned.
+func (s *Store) List(mac net.HardwareAddr) ([]Snapshot, *Snapshot) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[mac.String()]
+	if !ok {
+		return nil, nil
+	}
+	list := append([]Snapshot(nil), m.Snapshots...)
+	if m.Pinned == nil {
+		return list, nil
+	}
+	p := *m.Pinned
+
+	return list, &p
+}
+
+// Get returns the snapshot of mac with the version, the latest snapshot when version is 0, and negative versions
+// count back from the latest, -1 is the one before the latest.
+func (s *Store) Get(mac net.HardwareAddr, version int) (Snapshot, error) {
+	list, _ := s.List(mac)
+	if version <= 0 {
+		if i := len(list) - 1 + version; i >= 0 && i < len(list) {
+			return list[i], nil
+		}
+		return Snapshot{}, ErrNotFound
+	}
+	for _, sn := range list {
+		if sn.Version == version {
+			return sn, nil
+		}
+	}
+
+	return Snapshot{}, ErrNotFound
+}
+
+// Pin pins mac to its snapshot with the version, as numbered by Get: the machine is served the snapshot in place of
+// a new rendering of the script of its name until it is unpinned.
+func (s *Store) Pin(mac net.HardwareAddr, version int) (Snapshot, error) {
+	sn, err := s.Get(mac, version)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.machine(mac)
+	m.Pinned = &sn
+
+	return sn, s.save(mac, m)
+}
+
+// Unpin unpins mac, it is served new renderings of its scripts again.
+func (s *Store) Unpin(mac net.HardwareAddr) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[mac.String()]
+	if !ok || m.Pinned == nil {
+		return nil
+	}
+	m.Pinned = nil
+
+	return s.save(mac, m)
+}
+
+// Pinned returns the snapshot that mac is pinned to when it is a rendering of the script name.
+func (s *Store) Pinned(mac net.HardwareAddr, name string) (Snapshot, bool) {
+	if s == nil {
+		return Snapshot{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[mac.String()]
+	if !ok || m.Pinned == nil || m.Pinned.Name != name {
+		return Snapshot{}, false
+	}
+
+	return *m.Pinned, true
+}
+
+// Diff returns the differences of the lines of the snapshots from and to, it is empty when they match. The removed
+// lines are prefixed with "- ", the added lines with "+ " and the unchanged lines with two spaces.
+func Diff(from, to Snapshot) string {
+	if from.Content == to.Content {
+		return ""
+	}
+	a, b := strings.Split(from.Content, "\n"), strings.Split(to.Content, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var d strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			d.WriteString("  " + a[i] + "\n")
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			d.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			d.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+
+	return d.String()
+}
+
+func (s *Store) machine(mac net.HardwareAddr) *machine {
+	m, ok := s.machines[mac.String()]
+	if !ok {
+		m = &machine{}
+		s.machines[mac.String()] = m
+	}
+
+	return m
+}
+
+// save writes the file of mac when the Store has a directory. The file is replaced atomically so that readers never
+// see a partial file.
+func (s *Store) save(mac net.HardwareAddr, m *machine) error {
+	if s.dir == "" {
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(s.dir, strings.ReplaceAll(mac.String(), ":", "-")+".json"))
+}
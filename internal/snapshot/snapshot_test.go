@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	for _, c := range []string{"#!ipxe\nset download-url http://10.1.0.5/v1", "#!ipxe\nset download-url http://10.1.0.5/v2", "#!ipxe\nset download-url http://10.1.0.5/v3"} {
+		if _, err := s.Record(mac, "auto.ipxe", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, pinned := s.List(mac)
+	if len(list) != 2 || list[0].Version != 2 || list[1].Version != 3 || pinned != nil {
+		t.Fatalf("got snapshots %+v, pinned %v, want versions 2 and 3, not pinned", list, pinned)
+	}
+	if _, err := s.Get(mac, 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got error %v, want the dropped version not found", err)
+	}
+	previous, err := s.Get(mac, -1)
+	if err != nil || previous.Version != 2 {
+		t.Fatalf("got version %d (%v), want the one before the latest", previous.Version, err)
+	}
+	latest, _ := s.Get(mac, 0)
+	if d, want := Diff(previous, latest), "  #!ipxe\n- set download-url http://10.1.0.5/v2\n+ set download-url http://10.1.0.5/v3\n"; d != want {
+		t.Fatalf("got diff %q, want %q", d, want)
+	}
+	if d := Diff(latest, latest); d != "" {
+		t.Fatalf("got diff %q, want none", d)
+	}
+
+	if _, err := s.Pin(mac, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Pinned(mac, "grub.cfg"); ok {
+		t.Fatal("expected a pin to apply to the script of its name only")
+	}
+
+	// the snapshots and the pin survive a restart.
+	s, err = NewStore(2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sn, ok := s.Pinned(mac, "auto.ipxe")
+	if !ok || sn.Content != "#!ipxe\nset download-url http://10.1.0.5/v2" {
+		t.Fatalf("got pinned %+v (%v), want version 2", sn, ok)
+	}
+	if sn, _ := s.Record(mac, "auto.ipxe", "#!ipxe"); sn.Version != 4 {
+		t.Fatalf("got version %d, want 4", sn.Version)
+	}
+	if err := s.Unpin(mac); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Pinned(mac, "auto.ipxe"); ok {
+		t.Fatal("expected the machine to be unpinned")
+	}
+
+	var ns *Store
+	if _, err := ns.Record(mac, "auto.ipxe", "#!ipxe"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.Pin(mac, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got error %v, want not found for a nil Store", err)
+	}
+}
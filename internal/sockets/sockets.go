@@ -0,0 +1,226 @@
+// Package sockets acquires the sockets that Smee serves on. A socket is either inherited, already bound, from the
+// process that started Smee, like systemd socket activation or smee bind, or bound by Smee. Inherited sockets let Smee
+// run as a non-root user without any capability, even though DHCP, TFTP and syslog use privileged ports.
+package sockets
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The names of the sockets that Smee serves on, the names of the inherited sockets in LISTEN_FDNAMES.
+const (
+	DHCP = "dhcp"
+	// DHCPRaw is the raw (AF_PACKET) socket of the DHCP server on its interface, with -dhcp-raw-enabled.
+	DHCPRaw = "dhcp-raw"
+	// PXE is the UDP socket of the PXE boot server discovery, on port 4011.
+	PXE    = "dhcp-pxe"
+	TFTP   = "tftp"
+	HTTP   = "http"
+	Syslog = "syslog"
+)
+
+// VLAN returns the name of the UDP socket of the DHCP server on the subinterface of the VLAN id.
+func VLAN(id int) string {
+	return "dhcp-vlan-" + strconv.Itoa(id)
+}
+
+// listenFDsStart is the first inherited file descriptor of the systemd socket activation protocol.
+const listenFDsStart = 3
+
+// capNetBindService is the number of the CAP_NET_BIND_SERVICE capability.
+const capNetBindService = 10
+
+// Set is the sockets that Smee inherited, by name. A nil or empty Set binds every socket.
+type Set struct {
+	files map[string]*os.File
+}
+
+// FromEnv returns the sockets passed with the systemd socket activation protocol: LISTEN_FDS inherited file
+// descriptors, starting at 3, named by the colon separated LISTEN_FDNAMES. The sockets are ignored when LISTEN_PID is
+// set to another process. The variables are unset, so that the processes that Smee starts don't inherit them.
+func FromEnv() (*Set, error) {
+	fds, pid, names := os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDNAMES")
+	for _, k := range []string{"LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES"} {
+		os.Unsetenv(k)
+	}
+	if fds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return &Set{}, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	s := &Set{files: map[string]*os.File{}}
+	split := strings.Split(names, ":")
+	for i := range n {
+		var name string
+		if i < len(split) {
+			name = split[i]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("the inherited socket %d has no name in LISTEN_FDNAMES", listenFDsStart+i)
+		}
+		if _, ok := s.files[name]; ok {
+			return nil, fmt.Errorf("the inherited socket %q is passed more than once in LISTEN_FDNAMES", name)
+		}
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		s.files[name] = os.NewFile(uintptr(fd), name)
+	}
+
+	return s, nil
+}
+
+// Names returns the sorted names of the inherited sockets.
+func (s *Set) Names() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Has returns whether the socket named name is inherited.
+func (s *Set) Has(name string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.files[name]
+
+	return ok
+}
+
+// PacketConn returns a connection of the inherited socket named name, or else the connection returned by bind.
+// port is the port that bind binds, an error binding a privileged port says which capability is missing.
+// The inherited socket stays open, so that a restarted service gets it again.
+func (s *Set) PacketConn(name string, port int, bind func() (net.PacketConn, error)) (net.PacketConn, error) {
+	if f, ok := s.file(name); ok {
+		c, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, fmt.Errorf("the inherited %s socket is not a packet socket: %w", name, err)
+		}
+		if name == DHCP {
+			// DHCP replies are broadcast to clients without an address, the socket may not have been set up for it.
+			if err := setBroadcast(c); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("failed to allow broadcasting on the inherited %s socket: %w", name, err)
+			}
+		}
+		return c, nil
+	}
+	c, err := bind()
+	if err != nil {
+		return nil, bindError(err, port)
+	}
+
+	return c, nil
+}
+
+// Listener returns a listener of the inherited socket named name, or else the listener returned by bind.
+// It is like PacketConn, for stream sockets.
+func (s *Set) Listener(name string, port int, bind func() (net.Listener, error)) (net.Listener, error) {
+	if f, ok := s.file(name); ok {
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("the inherited %s socket is not a listening stream socket: %w", name, err)
+		}
+		return l, nil
+	}
+	l, err := bind()
+	if err != nil {
+		return nil, bindError(err, port)
+	}
+
+	return l, nil
+}
+
+// Inherited returns the inherited socket named name, for the sockets that are neither packet connections nor
+// listeners, like raw sockets. The inherited socket stays open, a service uses a duplicate of it.
+func (s *Set) Inherited(name string) (*os.File, bool) {
+	return s.file(name)
+}
+
+func (s *Set) file(name string) (*os.File, bool) {
+	if s == nil {
+		return nil, false
+	}
+	f, ok := s.files[name]
+
+	return f, ok
+}
+
+func setBroadcast(c net.PacketConn) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return errors.New("not a socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	}); err != nil {
+		return err
+	}
+
+	return serr
+}
+
+// bindError returns err, saying that binding port requires CAP_NET_BIND_SERVICE when it is a privileged port and the
+// process doesn't have the capability.
+func bindError(err error, port int) error {
+	if port <= 0 || port >= unprivilegedPortStart() {
+		return err
+	}
+	status, rerr := os.ReadFile("/proc/self/status")
+	if rerr != nil {
+		return err
+	}
+	if effective, ok := effectiveCapabilities(string(status)); !ok || effective&(1<<capNetBindService) != 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w: binding port %d requires root, the CAP_NET_BIND_SERVICE capability or an inherited socket, see docs/Non-Root.md", err, port)
+}
+
+// unprivilegedPortStart returns the first port that doesn't require CAP_NET_BIND_SERVICE to be bound.
+func unprivilegedPortStart() int {
+	b, err := os.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start")
+	if err != nil {
+		return 1024
+	}
+	p, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 1024
+	}
+
+	return p
+}
+
+// effectiveCapabilities returns the CapEff bit mask of a /proc/<pid>/status file.
+func effectiveCapabilities(status string) (uint64, bool) {
+	sc := bufio.NewScanner(strings.NewReader(status))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "CapEff:"); ok {
+			c, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+			return c, err == nil
+		}
+	}
+
+	return 0, false
+}
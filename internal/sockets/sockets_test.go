@@ -0,0 +1,115 @@
+package sockets
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFromEnv(t *testing.T) {
+	tests := map[string]struct {
+		fds, pid, names string
+		want            []string
+		wantErr         bool
+	}{
+		"no sockets":      {want: []string{}},
+		"another process": {fds: "1", pid: strconv.Itoa(os.Getpid() + 1), names: "dhcp", want: []string{}},
+		"invalid count":   {fds: "x", wantErr: true},
+		"no name":         {fds: "1", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("LISTEN_FDS", tt.fds)
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDNAMES", tt.names)
+			s, err := FromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if diff := cmp.Diff(tt.want, s.Names()); diff != "" {
+					t.Fatal(diff)
+				}
+			}
+			if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+				t.Fatal("expected LISTEN_FDS to be unset")
+			}
+		})
+	}
+}
+
+func TestInherited(t *testing.T) {
+	uc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	uf, err := uc.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	tf, err := tl.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Set{files: map[string]*os.File{DHCP: uf, HTTP: tf}}
+	notBound := errors.New("bound")
+
+	// a restarted service gets the inherited socket again.
+	for range 2 {
+		c, err := s.PacketConn(DHCP, 67, func() (net.PacketConn, error) { return nil, notBound })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.LocalAddr().String() != uc.LocalAddr().String() {
+			t.Fatalf("got address %v, want the inherited %v", c.LocalAddr(), uc.LocalAddr())
+		}
+		c.Close()
+	}
+	l, err := s.Listener(HTTP, 80, func() (net.Listener, error) { return nil, notBound })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().String() != tl.Addr().String() {
+		t.Fatalf("got address %v, want the inherited %v", l.Addr(), tl.Addr())
+	}
+	if _, err := s.PacketConn(HTTP, 80, nil); err == nil {
+		t.Fatal("expected an error for a stream socket")
+	}
+	if _, err := s.PacketConn(TFTP, 0, func() (net.PacketConn, error) { return nil, notBound }); !errors.Is(err, notBound) {
+		t.Fatalf("got error %v, want the socket to be bound", err)
+	}
+	if diff := cmp.Diff([]string{DHCP, HTTP}, s.Names()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestEffectiveCapabilities(t *testing.T) {
+	tests := map[string]struct {
+		status string
+		want   uint64
+		wantOK bool
+	}{
+		"bind service": {status: "Name:\tsmee\nCapInh:\t0000000000000000\nCapEff:\t0000000000000400\n", want: 1 << capNetBindService, wantOK: true},
+		"no CapEff":    {status: "Name:\tsmee\n"},
+		"invalid":      {status: "CapEff:\tnope\n"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := effectiveCapabilities(tt.status)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("got %x, %v, want %x, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Package staleness enforces a maximum age of the backend records, the time since their backend last confirmed them
+// with its source, see data.Netboot.Validated. It keeps Smee from booting machines from stale inventory data, for
+// example while the Kubernetes API is unreachable and the records are served from the client-side cache.
+package staleness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// Action is what is done with a stale record.
+type Action string
+
+const (
+	// Continue serves stale records as they are, they are only logged and counted.
+	Continue Action = "continue"
+	// Refuse fails the lookups of stale records with ErrStale, the machines get no DHCP reply and no boot script.
+	Refuse Action = "refuse"
+	// Fallback serves stale records with netboot disabled, the machines keep their addresses and boot from their disks.
+	Fallback Action = "fallback"
+)
+
+// ErrStale is returned for the lookups of stale records with the Refuse action.
+var ErrStale = errors.New("the backend record is stale")
+
+// ParseAction returns the Action named s.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case Continue, Refuse, Fallback:
+		return a, nil
+	}
+
+	return "", fmt.Errorf("unknown staleness action %q, must be one of continue, refuse or fallback", s)
+}
+
+// Policy is the maximum age of the backend records and what is done with the records that are older.
+// Records with the zero Validated time, of the backends that read their source on every lookup, are never stale.
+type Policy struct {
+	// MaxAge is the maximum time since the backend last confirmed a record with its source, 0 disables the policy.
+	MaxAge time.Duration
+	Action Action
+	Log    logr.Logger
+
+	// now is time.Now, it is replaced in tests.
+	now func() time.Time
+}
+
+// Backend returns br with p enforced on the records of every lookup.
+// br is returned when MaxAge is 0. The returned backend is a handler.BackendIdentityReader when br is one.
+func (p *Policy) Backend(br handler.BackendReader) handler.BackendReader {
+	if p == nil || p.MaxAge <= 0 {
+		return br
+	}
+	b := &backend{reader: br, policy: p}
+	if ir, ok := br.(handler.BackendIdentityReader); ok {
+		return &identityBackend{backend: b, identity: ir}
+	}
+
+	return b
+}
+
+// enforce applies the policy to the record of a lookup.
+func (p *Policy) enforce(d *data.DHCP, n *data.Netboot, err error) (*data.DHCP, *data.Netboot, error) {
+	if err != nil || n == nil || n.Validated.IsZero() {
+		return d, n, err
+	}
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+	age := now().Sub(n.Validated)
+	if age <= p.MaxAge {
+		return d, n, nil
+	}
+	action := p.Action
+	if action == "" {
+		action = Continue
+	}
+	metric.StaleRecords.WithLabelValues(string(action)).Inc()
+	var mac string
+	if d != nil {
+		mac = d.MACAddress.String()
+	}
+	p.log().Info("stale backend record", "mac", mac, "age", age.Round(time.Second).String(), "maxAge", p.MaxAge.String(), "action", action)
+	switch action {
+	case Refuse:
+		return nil, nil, fmt.Errorf("%w: last validated %s ago", ErrStale, age.Round(time.Second))
+	case Fallback:
+		fn := *n
+		fn.AllowNetboot = false
+		return d, &fn, nil
+	}
+
+	return d, n, nil
+}
+
+func (p *Policy) log() logr.Logger {
+	if p.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return p.Log
+}
+
+type backend struct {
+	reader handler.BackendReader
+	policy *Policy
+}
+
+func (b *backend) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return b.policy.enforce(b.reader.GetByMac(ctx, mac))
+}
+
+func (b *backend) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return b.policy.enforce(b.reader.GetByIP(ctx, ip))
+}
+
+type identityBackend struct {
+	*backend
+	identity handler.BackendIdentityReader
+}
+
+func (b *identityBackend) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	return b.policy.enforce(b.identity.GetByUUID(ctx, uuid))
+}
+
+func (b *identityBackend) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	return b.policy.enforce(b.identity.GetBySerial(ctx, serial))
+}
+
+func (b *identityBackend) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	return b.policy.enforce(b.identity.GetByHostname(ctx, hostname))
+}
@@ -0,0 +1,113 @@
+package staleness
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+var now = time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+// records is a backend whose records were validated at the time that is their MAC address index minutes ago,
+// 00:00:00:00:00:00 was never validated.
+type records struct{}
+
+func (records) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	n := &data.Netboot{AllowNetboot: true}
+	if mac[5] > 0 {
+		n.Validated = now.Add(-time.Duration(mac[5]) * time.Minute)
+	}
+
+	return &data.DHCP{MACAddress: mac}, n, nil
+}
+
+func (records) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+type identity struct{ records }
+
+func (r identity) GetByUUID(ctx context.Context, _ string) (*data.DHCP, *data.Netboot, error) {
+	return r.GetByMac(ctx, net.HardwareAddr{0, 0, 0, 0, 0, 90})
+}
+
+func (identity) GetBySerial(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (identity) GetByHostname(context.Context, string) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func TestBackend(t *testing.T) {
+	tests := map[string]struct {
+		action      Action
+		mac         net.HardwareAddr
+		wantErr     error
+		wantNetboot bool
+	}{
+		"fresh":               {action: Refuse, mac: net.HardwareAddr{0, 0, 0, 0, 0, 30}, wantNetboot: true},
+		"never validated":     {action: Refuse, mac: net.HardwareAddr{0, 0, 0, 0, 0, 0}, wantNetboot: true},
+		"stale refused":       {action: Refuse, mac: net.HardwareAddr{0, 0, 0, 0, 0, 90}, wantErr: ErrStale},
+		"stale fallback":      {action: Fallback, mac: net.HardwareAddr{0, 0, 0, 0, 0, 90}},
+		"stale continued":     {action: Continue, mac: net.HardwareAddr{0, 0, 0, 0, 0, 90}, wantNetboot: true},
+		"continue by default": {mac: net.HardwareAddr{0, 0, 0, 0, 0, 90}, wantNetboot: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := &Policy{MaxAge: time.Hour, Action: tt.action, now: func() time.Time { return now }}
+			_, n, err := p.Backend(records{}).GetByMac(context.Background(), tt.mac)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && n.AllowNetboot != tt.wantNetboot {
+				t.Fatalf("got AllowNetboot %v, want %v", n.AllowNetboot, tt.wantNetboot)
+			}
+		})
+	}
+}
+
+func TestBackendIdentity(t *testing.T) {
+	before := testutil.ToFloat64(metric.StaleRecords.WithLabelValues("refuse"))
+	p := &Policy{MaxAge: time.Hour, Action: Refuse, now: func() time.Time { return now }}
+	ir, ok := p.Backend(identity{}).(handler.BackendIdentityReader)
+	if !ok {
+		t.Fatal("expected a handler.BackendIdentityReader")
+	}
+	if _, _, err := ir.GetByUUID(context.Background(), "uuid"); !errors.Is(err, ErrStale) {
+		t.Fatalf("got error %v, want %v", err, ErrStale)
+	}
+	if got := testutil.ToFloat64(metric.StaleRecords.WithLabelValues("refuse")) - before; got != 1 {
+		t.Fatalf("got %v stale records refused, want 1", got)
+	}
+	if _, ok := p.Backend(records{}).(handler.BackendIdentityReader); ok {
+		t.Fatal("expected a backend that is not a handler.BackendIdentityReader")
+	}
+	if b := (&Policy{}).Backend(records{}); b != (records{}) {
+		t.Fatal("expected the backend without a max age")
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, s := range []string{"continue", "refuse", "fallback"} {
+		if a, err := ParseAction(s); err != nil || string(a) != s {
+			t.Fatalf("ParseAction(%q) = %q, %v", s, a, err)
+		}
+	}
+	if _, err := ParseAction("ignore"); err == nil {
+		t.Fatal("expected an error for an unknown action")
+	}
+}
@@ -0,0 +1,188 @@
+// Package supervise runs the services of Smee, like the DHCP, TFTP, HTTP and syslog servers, in a group.
+//
+// By default it behaves like an errgroup, the first service that fails stops the others, but Wait returns the
+// failures of all the services rather than only the first one. With Restart, a failed service is restarted with
+// exponential backoff while the other services keep running, so that a transient failure, like the TFTP port being
+// taken, doesn't take the DHCP server down with it.
+package supervise
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The states of a service.
+const (
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+	StateStopped    = "stopped"
+	StateFailed     = "failed"
+)
+
+// Options configures the restarts of the failed services of a Group.
+type Options struct {
+	// Restart restarts a failed service, instead of stopping all the services.
+	Restart bool
+	// InitialInterval is the delay before the first restart, it doubles on every failure up to MaxInterval.
+	// The delay is reset once a service has run for MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// MaxRestarts, when greater than 0, is the number of consecutive restarts of a service after which
+	// it is considered failed and all the services are stopped.
+	MaxRestarts int
+	Log         logr.Logger
+}
+
+// Status is the status of a service.
+type Status struct {
+	Name  string
+	State string
+	// Restarts is the number of times the service has been restarted.
+	Restarts int
+	// Err is the last error of the service, if any.
+	Err error
+}
+
+// Group runs services until they all return, or one fails.
+type Group struct {
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	services []*Status
+	errs     []error
+}
+
+// WithContext returns a new Group and a derived Context, it is canceled the first time a service fails
+// without being restarted, or when Wait returns, whichever occurs first.
+func WithContext(ctx context.Context, opts Options) (*Group, context.Context) {
+	if opts.InitialInterval <= 0 {
+		opts.InitialInterval = time.Second
+	}
+	if opts.MaxInterval <= 0 {
+		opts.MaxInterval = time.Minute
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Group{opts: opts, ctx: ctx, cancel: cancel}, ctx
+}
+
+// Go runs the named service fn in a new goroutine. fn must stop when the Context of the Group is done,
+// with Restart it must be safe to call it again after it failed.
+func (g *Group) Go(name string, fn func() error) {
+	s := &Status{Name: name, State: StateRunning}
+	g.mu.Lock()
+	g.services = append(g.services, s)
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.run(s, fn)
+	}()
+}
+
+func (g *Group) run(s *Status, fn func() error) {
+	b := g.backoff()
+	consecutive := 0
+	for {
+		start := time.Now()
+		err := fn()
+		if err == nil || g.ctx.Err() != nil {
+			g.set(s, StateStopped, err)
+			return
+		}
+		if !g.opts.Restart || (g.opts.MaxRestarts > 0 && consecutive >= g.opts.MaxRestarts) {
+			g.set(s, StateFailed, err)
+			g.fail(fmt.Errorf("%s: %w", s.Name, err))
+			return
+		}
+		if time.Since(start) >= g.opts.MaxInterval {
+			b, consecutive = g.backoff(), 0
+		}
+		consecutive++
+		d := b.Step()
+		g.set(s, StateRestarting, err)
+		g.opts.Log.Error(err, "service failed, restarting", "service", s.Name, "attempt", consecutive, "retryIn", d.String())
+		select {
+		case <-g.ctx.Done():
+			g.set(s, StateStopped, err)
+			return
+		case <-time.After(d):
+		}
+		g.mu.Lock()
+		s.State = StateRunning
+		s.Restarts++
+		g.mu.Unlock()
+	}
+}
+
+func (g *Group) backoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: g.opts.InitialInterval,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      g.opts.MaxInterval,
+	}
+}
+
+func (g *Group) set(s *Status, state string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s.State = state
+	if err != nil {
+		s.Err = err
+	}
+}
+
+// fail records the failure of a service and stops all the services.
+func (g *Group) fail(err error) {
+	g.mu.Lock()
+	g.errs = append(g.errs, err)
+	g.mu.Unlock()
+	g.cancel()
+}
+
+// Wait blocks until all the services have returned, it returns the failures of all the services, joined.
+// Errors that services return once they are stopped aren't failures.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return errors.Join(g.errs...)
+}
+
+// Status returns the status of every service, in the order they were started.
+func (g *Group) Status() []Status {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := make([]Status, 0, len(g.services))
+	for _, s := range g.services {
+		st = append(st, *s)
+	}
+
+	return st
+}
+
+// Ready returns whether all the services are running, or have stopped without failing.
+func (g *Group) Ready() bool {
+	for _, s := range g.Status() {
+		if s.State == StateRestarting || s.State == StateFailed {
+			return false
+		}
+	}
+
+	return true
+}
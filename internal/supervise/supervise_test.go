@@ -0,0 +1,105 @@
+package supervise
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestWaitJoinsFailures(t *testing.T) {
+	g, ctx := WithContext(context.Background(), Options{})
+	errTFTP := errors.New("address already in use")
+	errDHCP := errors.New("permission denied")
+	started := make(chan struct{})
+	g.Go("tftp", func() error {
+		<-started
+		return errTFTP
+	})
+	g.Go("dhcp", func() error {
+		<-started
+		return errDHCP
+	})
+	g.Go("http", func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	close(started)
+
+	err := g.Wait()
+	if !errors.Is(err, errTFTP) && !errors.Is(err, errDHCP) {
+		t.Fatalf("got error %v, want the failure of a service", err)
+	}
+	for _, s := range g.Status() {
+		if s.Name == "http" && s.State != StateStopped {
+			t.Fatalf("got http state %s, want %s", s.State, StateStopped)
+		}
+	}
+	if g.Ready() {
+		t.Fatal("expected a group with a failed service not to be ready")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, ctx := WithContext(parent, Options{Restart: true, InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond, Log: logr.Discard()})
+	var calls atomic.Int32
+	g.Go("tftp", func() error {
+		if calls.Add(1) < 3 {
+			return errors.New("address already in use")
+		}
+		<-ctx.Done()
+		return nil
+	})
+	var httpCalls atomic.Int32
+	g.Go("http", func() error {
+		httpCalls.Add(1)
+		<-ctx.Done()
+		return nil
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for calls.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed service was not restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for !g.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the group to be ready once the service is running again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	st := g.Status()
+	if st[0].Restarts != 2 || st[0].Err == nil {
+		t.Fatalf("got tftp status %+v, want 2 restarts and the last error", st[0])
+	}
+	cancel()
+	if err := g.Wait(); err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+	if httpCalls.Load() != 1 {
+		t.Fatalf("got %d http calls, want the other services to keep running", httpCalls.Load())
+	}
+}
+
+func TestMaxRestarts(t *testing.T) {
+	g, _ := WithContext(context.Background(), Options{Restart: true, InitialInterval: time.Millisecond, MaxInterval: 10 * time.Millisecond, MaxRestarts: 2, Log: logr.Discard()})
+	var calls atomic.Int32
+	errTFTP := errors.New("address already in use")
+	g.Go("tftp", func() error {
+		calls.Add(1)
+		return errTFTP
+	})
+	if err := g.Wait(); !errors.Is(err, errTFTP) {
+		t.Fatalf("got error %v, want %v", err, errTFTP)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("got %d calls, want the first and 2 restarts", calls.Load())
+	}
+}
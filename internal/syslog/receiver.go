@@ -24,13 +24,39 @@ type Receiver struct {
 	err   error
 
 	Logger logr.Logger
+	// Observers are notified of every syslog message that is parsed.
+	Observers []Observer
+	// Resolver, when set, attributes the messages to the MAC address of the machine that their host IP address resolves to.
+	Resolver Resolver
 }
 
-func StartReceiver(ctx context.Context, logger logr.Logger, laddr string, parsers int) error {
-	if parsers < 1 {
-		parsers = 1
-	}
+// Resolver returns the MAC address of the machine that uses an IP address.
+type Resolver interface {
+	MAC(ip net.IP) (net.HardwareAddr, bool)
+}
+
+// Observer is notified of the syslog messages that are received.
+type Observer interface {
+	SyslogReceived(Message)
+}
+
+// Message is a parsed syslog message.
+type Message struct {
+	Time time.Time
+	// Host is the IP address the message was sent from.
+	Host net.IP
+	// MAC is the MAC address of the machine that Host resolves to, nil when it is not resolved.
+	MAC      net.HardwareAddr
+	Hostname string
+	Facility string
+	Severity string
+	AppName  string
+	ProcID   string
+	MsgID    string
+	Msg      string
+}
 
+func StartReceiver(ctx context.Context, logger logr.Logger, laddr string, parsers int, resolver Resolver, observers ...Observer) error {
 	addr, err := net.ResolveUDPAddr("udp4", laddr)
 	if err != nil {
 		return fmt.Errorf("resolve syslog udp listen address: %w", err)
@@ -41,11 +67,22 @@ func StartReceiver(ctx context.Context, logger logr.Logger, laddr string, parser
 		return fmt.Errorf("listen on syslog udp address: %w", err)
 	}
 
+	return StartReceiverConn(ctx, logger, c, parsers, resolver, observers...)
+}
+
+// StartReceiverConn is like StartReceiver, it receives the syslog messages on c, that it closes when ctx is done.
+func StartReceiverConn(ctx context.Context, logger logr.Logger, c *net.UDPConn, parsers int, resolver Resolver, observers ...Observer) error {
+	if parsers < 1 {
+		parsers = 1
+	}
+
 	s := &Receiver{
-		c:      c,
-		parse:  make(chan *message, parsers),
-		done:   make(chan struct{}),
-		Logger: logger,
+		c:         c,
+		parse:     make(chan *message, parsers),
+		done:      make(chan struct{}),
+		Logger:    logger,
+		Observers: observers,
+		Resolver:  resolver,
 	}
 
 	for i := 0; i < parsers; i++ {
@@ -154,11 +191,24 @@ func (r *Receiver) runParser() {
 		if m.parse() {
 			structured := parse(m)
 			sl := r.Logger.WithValues("msg", structured)
+			var mac net.HardwareAddr
+			if r.Resolver != nil {
+				if mac, _ = r.Resolver.MAC(m.host); mac != nil {
+					sl = sl.WithValues("mac", mac)
+				}
+			}
 			if m.Severity() == DEBUG {
 				sl.V(1).Info("msg")
 			} else {
 				sl.Info("msg")
 			}
+			if len(r.Observers) > 0 {
+				msg := m.export()
+				msg.MAC = mac
+				for _, o := range r.Observers {
+					o.SyslogReceived(msg)
+				}
+			}
 		} else {
 			r.Logger.V(1).Info("msg", "msg", m)
 		}
@@ -166,3 +216,18 @@ func (r *Receiver) runParser() {
 		syslogMessagePool.Put(m)
 	}
 }
+
+// export returns a copy of the parsed message m, m is reused once it is parsed.
+func (m *message) export() Message {
+	return Message{
+		Time:     m.time,
+		Host:     append(net.IP(nil), m.host...),
+		Hostname: string(m.hostname),
+		Facility: m.Facility().String(),
+		Severity: m.Severity().String(),
+		AppName:  string(m.app),
+		ProcID:   string(m.procid),
+		MsgID:    string(m.msgid),
+		Msg:      msgCleanup.Replace(string(m.msg)),
+	}
+}
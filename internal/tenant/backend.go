@@ -0,0 +1,90 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+)
+
+// notFoundError is returned for the records of other tenants, the handlers treat them like machines that are not
+// in the backend.
+type notFoundError struct {
+	tenant string
+}
+
+func (notFoundError) NotFound() bool { return true }
+
+func (e notFoundError) Error() string {
+	if e.tenant == "" {
+		return "hardware not found, it belongs to a tenant"
+	}
+
+	return fmt.Sprintf("hardware not found in tenant %s", e.tenant)
+}
+
+// Scope returns br scoped to the tenant of the context of every lookup: only the records with the name of the tenant
+// in their Label are found. Lookups with a context without a tenant, see FromContext, find all records.
+// br is returned when c is nil. The scoped backend is a handler.BackendIdentityReader when br is one.
+func (c *Config) Scope(br handler.BackendReader) handler.BackendReader {
+	if c == nil {
+		return br
+	}
+	s := &scoped{reader: br}
+	if ir, ok := br.(handler.BackendIdentityReader); ok {
+		return &scopedIdentity{scoped: s, identity: ir}
+	}
+
+	return s
+}
+
+type scoped struct {
+	reader handler.BackendReader
+}
+
+func (s *scoped) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return check(ctx)(s.reader.GetByMac(ctx, mac))
+}
+
+func (s *scoped) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return check(ctx)(s.reader.GetByIP(ctx, ip))
+}
+
+type scopedIdentity struct {
+	*scoped
+	identity handler.BackendIdentityReader
+}
+
+func (s *scopedIdentity) GetByUUID(ctx context.Context, uuid string) (*data.DHCP, *data.Netboot, error) {
+	return check(ctx)(s.identity.GetByUUID(ctx, uuid))
+}
+
+func (s *scopedIdentity) GetBySerial(ctx context.Context, serial string) (*data.DHCP, *data.Netboot, error) {
+	return check(ctx)(s.identity.GetBySerial(ctx, serial))
+}
+
+func (s *scopedIdentity) GetByHostname(ctx context.Context, hostname string) (*data.DHCP, *data.Netboot, error) {
+	return check(ctx)(s.identity.GetByHostname(ctx, hostname))
+}
+
+// check returns a func that returns the result of a lookup, or a notFoundError when the record found does not belong
+// to the tenant of ctx.
+func check(ctx context.Context) func(*data.DHCP, *data.Netboot, error) (*data.DHCP, *data.Netboot, error) {
+	return func(d *data.DHCP, n *data.Netboot, err error) (*data.DHCP, *data.Netboot, error) {
+		name, ok := FromContext(ctx)
+		if err != nil || !ok {
+			return d, n, err
+		}
+		var owner string
+		if n != nil {
+			owner = n.Labels[Label]
+		}
+		if owner != name {
+			return nil, nil, notFoundError{tenant: name}
+		}
+
+		return d, n, nil
+	}
+}
@@ -0,0 +1,41 @@
+package tenant
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// Handler maps the requests to their tenant, by their URL prefix or the subnet of their source address, and serves
+// them with next, without the URL prefix. A request with the URL prefix of a tenant from the subnet of another tenant
+// is forbidden, a machine can't reach the machines of another tenant.
+func (c *Config) Handler(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		byPath, p := c.forPath(r.URL.Path)
+		bySource := ""
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			if a, err := netip.ParseAddr(host); err == nil {
+				bySource = c.forAddr(a)
+			}
+		}
+		name := byPath
+		switch {
+		case byPath == "":
+			name = bySource
+		case bySource != "" && bySource != byPath:
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(NewContext(r.Context(), name))
+		if p != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = p, ""
+			r.URL = &u
+		}
+		next.ServeHTTP(w, r)
+	})
+}
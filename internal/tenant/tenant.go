@@ -0,0 +1,276 @@
+// Package tenant maps the requests of machines to tenants, so that one Smee can serve several isolated customer
+// environments, for example in a bare metal cloud.
+//
+// A request belongs to a tenant by the DHCP relay agent it was relayed through, by the subnet of the machine or by the
+// URL prefix of an HTTP request. Each tenant only sees the backend records with its name in the
+// smee.tinkerbell.org/tenant label, and boots with its own OSIE URL and Tink server. Requests that belong to no tenant
+// only see the records without the label.
+//
+//	tenants:
+//	  acme:
+//	    relays: ["10.10.0.1"]
+//	    subnets: ["10.10.0.0/16"]
+//	    urlPrefix: /acme
+//	    osieURL: http://10.10.0.5:8080/hook
+//	    tinkServer: 10.10.0.6:42113
+//	  globex:
+//	    subnets: ["10.20.0.0/16"]
+//	    tinkServer: 10.20.0.6:42113
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Label is the backend record label that names the tenant of a machine.
+const Label = "smee.tinkerbell.org/tenant"
+
+// Config holds the tenants by name.
+type Config struct {
+	Tenants map[string]Tenant `json:"tenants"`
+}
+
+// Tenant is how the requests of a tenant are recognized and the values its machines boot with.
+// Empty values are not overridden.
+type Tenant struct {
+	// Relays are the addresses of the DHCP relay agents (giaddr) of the tenant.
+	Relays []string `json:"relays,omitempty"`
+	// Subnets are the CIDRs of the machines of the tenant, they are matched against the relay agent address,
+	// or the client address, of DHCP messages and the source address of HTTP requests.
+	Subnets []string `json:"subnets,omitempty"`
+	// URLPrefix is the HTTP path prefix of the tenant, for example "/acme". It is added to the iPXE script URL of
+	// the machines of the tenant and removed from the requests before they are served.
+	URLPrefix string `json:"urlPrefix,omitempty"`
+	// OSIEURL is the URL where OSIE (HookOS) images of the tenant are located.
+	OSIEURL string `json:"osieURL,omitempty"`
+	// TinkServer is the IP:port of the Tink server of the tenant.
+	TinkServer string `json:"tinkServer,omitempty"`
+
+	relays  []netip.Addr
+	subnets []netip.Prefix
+}
+
+// Load reads and validates a YAML, or JSON, tenant config file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, tenant config.
+// A relay, subnet or URL prefix can only belong to one tenant, so that every request belongs to at most one tenant.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse tenant config: %w", err)
+	}
+	var errs []error
+	for _, name := range c.names() {
+		t := c.Tenants[name]
+		if err := t.parse(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+		c.Tenants[name] = t
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	names := c.names()
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			if err := c.Tenants[a].overlaps(c.Tenants[b]); err != nil {
+				errs = append(errs, fmt.Errorf("tenants %s and %s: %w", a, b, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func (c *Config) names() []string {
+	names := make([]string, 0, len(c.Tenants))
+	for name := range c.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (t *Tenant) parse() error {
+	t.relays, t.subnets = nil, nil
+	for _, r := range t.Relays {
+		a, err := netip.ParseAddr(r)
+		if err != nil {
+			return fmt.Errorf("invalid relay: %w", err)
+		}
+		t.relays = append(t.relays, a)
+	}
+	for _, s := range t.Subnets {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("invalid subnet: %w", err)
+		}
+		t.subnets = append(t.subnets, p.Masked())
+	}
+	if t.URLPrefix != "" {
+		if !strings.HasPrefix(t.URLPrefix, "/") || path.Clean(t.URLPrefix) != t.URLPrefix || t.URLPrefix == "/" {
+			return fmt.Errorf("invalid urlPrefix %q, must be an absolute path like /acme, without a trailing slash", t.URLPrefix)
+		}
+	}
+	if t.OSIEURL != "" {
+		if _, err := url.ParseRequestURI(t.OSIEURL); err != nil {
+			return fmt.Errorf("invalid osieURL: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// overlaps returns an error when t and o share a relay, subnet or URL prefix.
+func (t Tenant) overlaps(o Tenant) error {
+	for _, a := range t.relays {
+		for _, b := range o.relays {
+			if a == b {
+				return fmt.Errorf("both have the relay %s", a)
+			}
+		}
+	}
+	for _, a := range t.subnets {
+		for _, b := range o.subnets {
+			if a.Overlaps(b) {
+				return fmt.Errorf("the subnets %s and %s overlap", a, b)
+			}
+		}
+	}
+	if t.URLPrefix != "" && o.URLPrefix != "" && (hasPathPrefix(t.URLPrefix, o.URLPrefix) || hasPathPrefix(o.URLPrefix, t.URLPrefix)) {
+		return fmt.Errorf("the URL prefixes %s and %s overlap", t.URLPrefix, o.URLPrefix)
+	}
+
+	return nil
+}
+
+// Get returns the tenant of the name.
+// A nil Config, an empty name or an unknown name returns the zero Tenant.
+func (c *Config) Get(name string) Tenant {
+	if c == nil || name == "" {
+		return Tenant{}
+	}
+
+	return c.Tenants[name]
+}
+
+// ForDHCP returns the name of the tenant of a DHCP message, it is empty when the message belongs to no tenant.
+// A relayed message belongs to the tenant of its relay agent, or of the subnet of the relay agent address,
+// any other message to the tenant of the subnet of its client address.
+func (c *Config) ForDHCP(pkt *dhcpv4.DHCPv4) string {
+	if c == nil {
+		return ""
+	}
+	gi, _ := netip.AddrFromSlice(pkt.GatewayIPAddr.To4())
+	if gi.IsValid() && !gi.IsUnspecified() {
+		for _, name := range c.names() {
+			for _, r := range c.Tenants[name].relays {
+				if r == gi {
+					return name
+				}
+			}
+		}
+
+		return c.forAddr(gi)
+	}
+	ci, _ := netip.AddrFromSlice(pkt.ClientIPAddr.To4())
+
+	return c.forAddr(ci)
+}
+
+// forAddr returns the name of the tenant whose subnets contain a.
+func (c *Config) forAddr(a netip.Addr) string {
+	if !a.IsValid() || a.IsUnspecified() {
+		return ""
+	}
+	a = a.Unmap()
+	for _, name := range c.names() {
+		for _, s := range c.Tenants[name].subnets {
+			if s.Contains(a) {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// forPath returns the name of the tenant whose URL prefix p has, and p without the prefix.
+func (c *Config) forPath(p string) (string, string) {
+	for _, name := range c.names() {
+		prefix := c.Tenants[name].URLPrefix
+		if prefix != "" && hasPathPrefix(p, prefix) {
+			rest := strings.TrimPrefix(p, prefix)
+			if rest == "" {
+				rest = "/"
+			}
+			return name, rest
+		}
+	}
+
+	return "", p
+}
+
+func hasPathPrefix(p, prefix string) bool {
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// URL returns u with the URL prefix of the tenant of the name, u when the tenant has no URL prefix.
+func (c *Config) URL(name string, u *url.URL) *url.URL {
+	prefix := c.Get(name).URLPrefix
+	if u == nil || prefix == "" {
+		return u
+	}
+	pu := *u
+	pu.Path = path.Join(prefix, u.Path)
+	pu.RawPath = ""
+
+	return &pu
+}
+
+type tenantKey struct{}
+
+// NewContext returns ctx with the name of the tenant of a request, empty for a request that belongs to no tenant.
+func NewContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, name)
+}
+
+// FromContext returns the name of the tenant of the request of ctx. ok is false when ctx is not the context of a
+// request that was mapped to its tenant, for example of a call of the admin API.
+func FromContext(ctx context.Context) (name string, ok bool) {
+	name, ok = ctx.Value(tenantKey{}).(string)
+
+	return name, ok
+}
+
+// DHCPContext returns ctx with the tenant of the DHCP message pkt, ctx when c is nil.
+func (c *Config) DHCPContext(ctx context.Context, pkt *dhcpv4.DHCPv4) context.Context {
+	if c == nil {
+		return ctx
+	}
+
+	return NewContext(ctx, c.ForDHCP(pkt))
+}
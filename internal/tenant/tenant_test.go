@@ -0,0 +1,178 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+const config = `
+tenants:
+  acme:
+    relays: ["10.10.0.1"]
+    subnets: ["10.10.0.0/16"]
+    urlPrefix: /acme
+    osieURL: http://10.10.0.5:8080/hook
+    tinkServer: 10.10.0.6:42113
+  globex:
+    relays: ["192.168.1.1"]
+    subnets: ["10.20.0.0/16"]
+    urlPrefix: /globex
+`
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid relay":      "tenants: {a: {relays: [nope]}}",
+		"invalid subnet":     "tenants: {a: {subnets: [10.0.0.0/33]}}",
+		"relative prefix":    "tenants: {a: {urlPrefix: acme}}",
+		"trailing slash":     "tenants: {a: {urlPrefix: /acme/}}",
+		"invalid osie url":   "tenants: {a: {osieURL: nope}}",
+		"shared relay":       "tenants: {a: {relays: [10.0.0.1]}, b: {relays: [10.0.0.1]}}",
+		"overlapping subnet": "tenants: {a: {subnets: [10.0.0.0/8]}, b: {subnets: [10.1.0.0/16]}}",
+		"nested url prefix":  "tenants: {a: {urlPrefix: /acme}, b: {urlPrefix: /acme/lab}}",
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestForDHCP(t *testing.T) {
+	c, err := Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		giaddr, ciaddr net.IP
+		want           string
+	}{
+		"relay":                 {giaddr: net.IP{192, 168, 1, 1}, want: "globex"},
+		"relay address subnet":  {giaddr: net.IP{10, 10, 4, 1}, want: "acme"},
+		"client address subnet": {ciaddr: net.IP{10, 20, 0, 9}, want: "globex"},
+		"unknown relay":         {giaddr: net.IP{172, 16, 0, 1}, ciaddr: net.IP{10, 20, 0, 9}},
+		"not relayed":           {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pkt, err := dhcpv4.New(dhcpv4.WithGatewayIP(tt.giaddr), dhcpv4.WithClientIP(tt.ciaddr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.ForDHCP(pkt); got != tt.want {
+				t.Fatalf("got tenant %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c, err := Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _ := FromContext(r.Context())
+		_, _ = w.Write([]byte(name + " " + r.URL.Path))
+	}))
+	tests := map[string]struct {
+		remote, path string
+		wantCode     int
+		want         string
+	}{
+		"url prefix":               {remote: "172.16.0.9:1234", path: "/acme/00:01:02:03:04:05/auto.ipxe", wantCode: http.StatusOK, want: "acme /00:01:02:03:04:05/auto.ipxe"},
+		"subnet":                   {remote: "10.20.0.9:1234", path: "/iso/00:01:02:03:04:05/hook.iso", wantCode: http.StatusOK, want: "globex /iso/00:01:02:03:04:05/hook.iso"},
+		"url prefix of own subnet": {remote: "10.10.0.9:1234", path: "/acme/auto.ipxe", wantCode: http.StatusOK, want: "acme /auto.ipxe"},
+		"url prefix of other":      {remote: "10.20.0.9:1234", path: "/acme/auto.ipxe", wantCode: http.StatusForbidden},
+		"no tenant":                {remote: "172.16.0.9:1234", path: "/acmecorp/auto.ipxe", wantCode: http.StatusOK, want: " /acmecorp/auto.ipxe"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = tt.remote
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status code %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.want != "" && w.Body.String() != tt.want {
+				t.Fatalf("got %q, want %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestURL(t *testing.T) {
+	c, err := Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &url.URL{Scheme: "http", Host: "10.0.0.1", Path: "/auto.ipxe"}
+	if got, want := c.URL("acme", u).String(), "http://10.0.0.1/acme/auto.ipxe"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if got := c.URL("", u); got != u {
+		t.Fatalf("got %s, want %s", got, u)
+	}
+}
+
+type backend map[string]string
+
+func (b backend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	t, ok := b[mac.String()]
+	if !ok {
+		return nil, nil, errors.New("not found")
+	}
+	n := &data.Netboot{}
+	if t != "" {
+		n.Labels = map[string]string{Label: t}
+	}
+
+	return &data.DHCP{MACAddress: mac}, n, nil
+}
+
+func (b backend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func TestScope(t *testing.T) {
+	c, err := Parse([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, none := net.HardwareAddr{0, 1, 2, 3, 4, 5}, net.HardwareAddr{0, 1, 2, 3, 4, 6}
+	br := c.Scope(backend{acme.String(): "acme", none.String(): ""})
+	tests := map[string]struct {
+		ctx     context.Context
+		mac     net.HardwareAddr
+		wantErr string
+	}{
+		"own tenant":          {ctx: NewContext(context.Background(), "acme"), mac: acme},
+		"other tenant":        {ctx: NewContext(context.Background(), "globex"), mac: acme, wantErr: "not found in tenant globex"},
+		"no tenant":           {ctx: NewContext(context.Background(), ""), mac: none},
+		"tenant of no tenant": {ctx: NewContext(context.Background(), ""), mac: acme, wantErr: "it belongs to a tenant"},
+		"unmapped context":    {ctx: context.Background(), mac: acme},
+		"lookup error":        {ctx: NewContext(context.Background(), "acme"), mac: net.HardwareAddr{0, 0, 0, 0, 0, 0}, wantErr: "not found"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := br.GetByMac(tt.ctx, tt.mac)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Package tlsconfig builds the TLS client configuration used for outbound connections.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Config is the TLS configuration of outbound connections to a destination.
+type Config struct {
+	// CAFile is a PEM encoded CA bundle that is trusted in addition to the system CAs.
+	CAFile string
+	// CertFile and KeyFile are a PEM encoded client certificate and key.
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version, "1.2" or "1.3".
+	MinVersion string
+	// InsecureSkipVerify disables verification of the server certificate.
+	InsecureSkipVerify bool
+}
+
+// Merge returns c with the fields that are set in override replacing those of c.
+// It is used to apply per-destination configuration over the global configuration.
+func (c Config) Merge(override Config) Config {
+	if override.CAFile != "" {
+		c.CAFile = override.CAFile
+	}
+	if override.CertFile != "" || override.KeyFile != "" {
+		c.CertFile, c.KeyFile = override.CertFile, override.KeyFile
+	}
+	if override.MinVersion != "" {
+		c.MinVersion = override.MinVersion
+	}
+	if override.InsecureSkipVerify {
+		c.InsecureSkipVerify = true
+	}
+
+	return c
+}
+
+// IsZero returns whether no TLS configuration is set.
+func (c Config) IsZero() bool {
+	return c == Config{}
+}
+
+// TLS returns the tls.Config for c.
+func (c Config) TLS() (*tls.Config, error) {
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec // InsecureSkipVerify is an explicit user choice.
+	}
+	switch c.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		tc.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported minimum TLS version %q, must be 1.2 or 1.3", c.MinVersion)
+	}
+
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in CA file %s", c.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	switch {
+	case c.CertFile != "" && c.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	case c.CertFile != "" || c.KeyFile != "":
+		return nil, errors.New("both a client certificate file and key file are required")
+	}
+
+	return tc, nil
+}
+
+// Transport returns a clone of http.DefaultTransport that uses the tls.Config for c.
+func (c Config) Transport() (*http.Transport, error) {
+	tc, err := c.TLS()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("http.DefaultTransport is not an *http.Transport")
+	}
+	t = t.Clone()
+	t.TLSClientConfig = tc
+
+	return t, nil
+}
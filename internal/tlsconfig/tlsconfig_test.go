@@ -0,0 +1,102 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeCert writes a self-signed certificate and its key to dir and returns their paths.
+func writeCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestMerge(t *testing.T) {
+	global := Config{CAFile: "/ca.pem", CertFile: "/c.pem", KeyFile: "/k.pem", MinVersion: "1.2"}
+	got := global.Merge(Config{CertFile: "/iso.pem", KeyFile: "/iso.key", InsecureSkipVerify: true})
+	want := Config{CAFile: "/ca.pem", CertFile: "/iso.pem", KeyFile: "/iso.key", MinVersion: "1.2", InsecureSkipVerify: true}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir())
+	notPEM := filepath.Join(t.TempDir(), "bad.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		cfg            Config
+		wantErr        bool
+		wantMinVersion uint16
+		wantCerts      int
+	}{
+		"defaults":           {wantMinVersion: tls.VersionTLS12},
+		"tls 1.3":            {cfg: Config{MinVersion: "1.3"}, wantMinVersion: tls.VersionTLS13},
+		"bad version":        {cfg: Config{MinVersion: "1.0"}, wantErr: true},
+		"ca and client":      {cfg: Config{CAFile: certFile, CertFile: certFile, KeyFile: keyFile}, wantMinVersion: tls.VersionTLS12, wantCerts: 1},
+		"missing ca file":    {cfg: Config{CAFile: "/does/not/exist"}, wantErr: true},
+		"ca file not pem":    {cfg: Config{CAFile: notPEM}, wantErr: true},
+		"cert without key":   {cfg: Config{CertFile: certFile}, wantErr: true},
+		"bad client keypair": {cfg: Config{CertFile: certFile, KeyFile: notPEM}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.cfg.TLS()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.MinVersion != tt.wantMinVersion {
+				t.Errorf("min version: got %v, want %v", got.MinVersion, tt.wantMinVersion)
+			}
+			if len(got.Certificates) != tt.wantCerts {
+				t.Errorf("client certificates: got %d, want %d", len(got.Certificates), tt.wantCerts)
+			}
+			if tt.cfg.CAFile != "" && got.RootCAs == nil {
+				t.Error("expected RootCAs to be set")
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Package tmpl holds the functions and data of the operator defined templates, like extra kernel args and the fallback
+// iPXE script, so that real world kernel cmdline and config logic can be expressed without pre-processing outside Smee.
+//
+//	ip={{ .IP }}::{{ .Gateway }}:{{ cidrnetmask .Subnet }}:{{ .Hostname }}
+//	tink_worker_image={{ env "TINK_WORKER_IMAGE" }}
+//	leader={{ (lookup "00:01:02:03:04:05").IP }}
+package tmpl
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+// Machine holds the backend data of a machine that templates are executed with.
+type Machine struct {
+	MAC         string
+	IP          string
+	Subnet      string // IP address and prefix length, for example 192.168.2.153/24.
+	Gateway     string
+	Hostname    string
+	DomainName  string
+	NameServers []string
+	Arch        string
+	VLANID      string
+	Facility    string
+	Labels      map[string]string
+}
+
+// FromBackend returns the Machine of the backend data of a machine, n can be nil.
+func FromBackend(d *data.DHCP, n *data.Netboot) Machine {
+	if d == nil {
+		return Machine{}
+	}
+	m := Machine{
+		MAC:        d.MACAddress.String(),
+		Hostname:   d.Hostname,
+		DomainName: d.DomainName,
+		Arch:       d.Arch,
+		VLANID:     d.VLANID,
+	}
+	if d.IPAddress.IsValid() {
+		m.IP = d.IPAddress.String()
+		if ones, bits := d.SubnetMask.Size(); bits != 0 {
+			m.Subnet = netip.PrefixFrom(d.IPAddress, ones).String()
+		}
+	}
+	if d.DefaultGateway.IsValid() {
+		m.Gateway = d.DefaultGateway.String()
+	}
+	for _, ns := range d.NameServers {
+		m.NameServers = append(m.NameServers, ns.String())
+	}
+	if n != nil {
+		m.Facility = n.Facility
+		m.Labels = n.Labels
+	}
+
+	return m
+}
+
+// Options configures the functions of a template.
+type Options struct {
+	// Env is the allowlist of the environment variables that the env function can read.
+	Env []string
+	// Lookup, when set, returns the Machine with a MAC address for the lookup function.
+	Lookup func(mac net.HardwareAddr) (Machine, error)
+}
+
+// Funcs returns the functions of a template:
+//
+//	cidrhost PREFIX N     the Nth address of PREFIX, "10.0.0.0/24" 5 is 10.0.0.5
+//	cidrnetmask PREFIX    the netmask of an IPv4 PREFIX, "10.0.0.0/24" is 255.255.255.0
+//	cidrnetwork PREFIX    the network address of PREFIX, "10.0.0.17/24" is 10.0.0.0
+//	cidrprefixlen PREFIX  the prefix length of PREFIX, "10.0.0.0/24" is 24
+//	maskbits NETMASK      the prefix length of an IPv4 netmask, "255.255.255.0" is 24
+//	b64enc, b64dec        standard base64 encoding
+//	sha256sum             hex encoded SHA-256 digest
+//	env NAME              the environment variable NAME, it must be in Options.Env
+//	lookup MAC            the Machine with the MAC address in the backend
+//	join SEP LIST, default DEFAULT VALUE, lower, upper, trim
+func Funcs(o Options) template.FuncMap {
+	return template.FuncMap{
+		"cidrhost":      cidrHost,
+		"cidrnetmask":   cidrNetmask,
+		"cidrnetwork":   cidrNetwork,
+		"cidrprefixlen": cidrPrefixLen,
+		"maskbits":      maskBits,
+		"b64enc":        func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":        b64dec,
+		"sha256sum":     func(s string) string { h := sha256.Sum256([]byte(s)); return hex.EncodeToString(h[:]) },
+		"env":           o.env,
+		"lookup":        o.lookup,
+		"join":          func(sep string, l []string) string { return strings.Join(l, sep) },
+		"default":       func(d, v string) string { return cmp.Or(v, d) },
+		"lower":         strings.ToLower,
+		"upper":         strings.ToUpper,
+		"trim":          strings.TrimSpace,
+	}
+}
+
+// Execute executes the text template with the data and functions.
+// A text without an action is returned as is.
+func Execute(name, text string, d any, funcs template.FuncMap) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := template.New(name).Option("missingkey=zero").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, d); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func (o Options) env(name string) (string, error) {
+	if !slices.Contains(o.Env, name) {
+		return "", fmt.Errorf("environment variable %q is not in the allowlist", name)
+	}
+
+	return os.Getenv(name), nil
+}
+
+func (o Options) lookup(mac string) (Machine, error) {
+	if o.Lookup == nil {
+		return Machine{}, errors.New("lookup is not available")
+	}
+	ha, err := net.ParseMAC(mac)
+	if err != nil {
+		return Machine{}, err
+	}
+
+	return o.Lookup(ha)
+}
+
+func cidrHost(prefix string, n int) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	p = p.Masked()
+	hostBits := p.Addr().BitLen() - p.Bits()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(hostBits)) //nolint:gosec // hostBits is not negative.
+	if n < 0 || big.NewInt(int64(n)).Cmp(size) >= 0 {
+		return "", fmt.Errorf("prefix %s has no host number %d", prefix, n)
+	}
+	a := new(big.Int).SetBytes(p.Addr().AsSlice())
+	a.Add(a, big.NewInt(int64(n)))
+	b := make([]byte, p.Addr().BitLen()/8)
+	addr, _ := netip.AddrFromSlice(a.FillBytes(b))
+
+	return addr.String(), nil
+}
+
+func cidrNetmask(prefix string) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	if !p.Addr().Is4() {
+		return "", fmt.Errorf("prefix %s is not an IPv4 prefix", prefix)
+	}
+
+	return net.IP(net.CIDRMask(p.Bits(), 32)).String(), nil
+}
+
+func cidrNetwork(prefix string) (string, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Masked().Addr().String(), nil
+}
+
+func cidrPrefixLen(prefix string) (int, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	return p.Bits(), nil
+}
+
+func maskBits(mask string) (int, error) {
+	ip := net.ParseIP(mask).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("invalid IPv4 netmask %q", mask)
+	}
+	ones, bits := net.IPMask(ip).Size()
+	if bits == 0 {
+		return 0, fmt.Errorf("non canonical netmask %q", mask)
+	}
+
+	return ones, nil
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// KernelArgs executes extra kernel args, that were split on spaces, as a single template with the Machine.
+// The args are returned as is when they have no action.
+func KernelArgs(args []string, m Machine, funcs template.FuncMap) ([]string, error) {
+	text := strings.Join(args, " ")
+	if !strings.Contains(text, "{{") {
+		return args, nil
+	}
+	s, err := Execute("extra-kernel-args", text, m, funcs)
+	if err != nil {
+		return nil, fmt.Errorf("executing the extra kernel args: %w", err)
+	}
+
+	return strings.Fields(s), nil
+}
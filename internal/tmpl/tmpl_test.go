@@ -0,0 +1,76 @@
+package tmpl
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+func TestExecute(t *testing.T) {
+	t.Setenv("SMEE_TEST_IMAGE", "10.1.0.8/tink-worker:latest")
+	t.Setenv("SMEE_TEST_SECRET", "secret")
+	o := Options{
+		Env: []string{"SMEE_TEST_IMAGE"},
+		Lookup: func(mac net.HardwareAddr) (Machine, error) {
+			if mac.String() != "00:01:02:03:04:05" {
+				return Machine{}, errors.New("not found")
+			}
+			return Machine{MAC: mac.String(), IP: "10.1.0.2"}, nil
+		},
+	}
+	m := Machine{IP: "10.1.0.5", Subnet: "10.1.0.5/24", NameServers: []string{"1.1.1.1", "8.8.8.8"}, Labels: map[string]string{"rack": "r1"}}
+	tests := map[string]struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		"no action":        {text: "console=ttyS0", want: "console=ttyS0"},
+		"cidrhost":         {text: `{{ cidrhost "10.1.0.0/24" 5 }}`, want: "10.1.0.5"},
+		"cidrhost ipv6":    {text: `{{ cidrhost "fd00::/64" 257 }}`, want: "fd00::101"},
+		"cidrhost outside": {text: `{{ cidrhost "10.1.0.0/30" 4 }}`, wantErr: true},
+		"cidrnetmask":      {text: `{{ cidrnetmask .Subnet }}`, want: "255.255.255.0"},
+		"cidrnetwork":      {text: `{{ cidrnetwork .Subnet }}`, want: "10.1.0.0"},
+		"cidrprefixlen":    {text: `{{ cidrprefixlen .Subnet }}`, want: "24"},
+		"maskbits":         {text: `{{ maskbits "255.255.252.0" }}`, want: "22"},
+		"b64":              {text: `{{ b64enc "smee" }} {{ b64dec "c21lZQ==" }}`, want: "c21lZQ== smee"},
+		"sha256sum":        {text: `{{ sha256sum "smee" }}`, want: "02b3de0d60f9a4d7c19635e6dbb7e9f227e7aacbbd4be413b3b62143d108df86"},
+		"env allowed":      {text: `{{ env "SMEE_TEST_IMAGE" }}`, want: "10.1.0.8/tink-worker:latest"},
+		"env not allowed":  {text: `{{ env "SMEE_TEST_SECRET" }}`, wantErr: true},
+		"lookup":           {text: `{{ (lookup "00:01:02:03:04:05").IP }}`, want: "10.1.0.2"},
+		"lookup not found": {text: `{{ (lookup "00:01:02:03:04:06").IP }}`, wantErr: true},
+		"strings":          {text: `{{ join "," .NameServers }} {{ default "none" .Hostname }} {{ upper .Labels.rack }} {{ .Labels.row }}`, want: "1.1.1.1,8.8.8.8 none R1 "},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Execute("test", tt.text, m, Funcs(o))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestKernelArgs(t *testing.T) {
+	m := FromBackend(&data.DHCP{
+		MACAddress:     net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		IPAddress:      netip.MustParseAddr("192.168.2.153"),
+		SubnetMask:     net.CIDRMask(24, 32),
+		DefaultGateway: netip.MustParseAddr("192.168.2.1"),
+		Hostname:       "sm01",
+	}, nil)
+	args := []string{"ip={{", ".IP", "}}::{{", ".Gateway", "}}:{{", "cidrnetmask", ".Subnet", "}}:{{", ".Hostname", "}}", "a=b"}
+	got, err := KernelArgs(args, m, Funcs(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"ip=192.168.2.153::192.168.2.1:255.255.255.0:sm01", "a=b"}, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
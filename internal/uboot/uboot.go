@@ -0,0 +1,263 @@
+// Package uboot serves per machine boot scripts, and extlinux.conf style PXE configs, to U-Boot, so that the fleets
+// of single board computers that boot U-Boot, not just Raspberry Pis, can be netbooted by Smee.
+//
+// DHCP points U-Boot clients, see dhcp.IsUBootClient, at <mac>/boot.scr.uimg on the TFTP server. The boot script is
+// a template executed with the Data of the machine and served as a legacy U-Boot script image, the format of mkimage
+// -T script, that the source command runs. The distro boot command of U-Boot loads boot.scr.uimg without the MAC
+// address directory, the machine is then resolved from its IP address. The default boot script chain loads the iPXE
+// binary with the UEFI implementation of U-Boot, iPXE then boots the machine like any other UEFI client.
+//
+// The pxe get command of U-Boot loads pxelinux.cfg/01-<mac> from the directory of the boot file. It is served from
+// the Extlinux template, for the boards that boot a kernel and an initrd without UEFI.
+package uboot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/smee/internal/dhcp/handler"
+	"github.com/tinkerbell/smee/internal/httpfile"
+	"github.com/tinkerbell/smee/internal/tmpl"
+)
+
+// Prefix is the URI prefix that the boot scripts and the PXE configs are served from over HTTP, for example
+// /uboot/00:01:02:03:04:05/boot.scr.uimg.
+const Prefix = "/uboot/"
+
+// ScriptName is the name of the boot script that U-Boot clients are sent in DHCP, the boot_script_dhcp of the U-Boot
+// distro boot command.
+const ScriptName = "boot.scr.uimg"
+
+// pxeConfigDir is the directory that the pxe get command loads the PXE config of a machine from.
+const pxeConfigDir = "pxelinux.cfg"
+
+// DefaultScript is the boot script when Handler.Script is empty. It chain loads the arm64 iPXE binary with bootefi,
+// that requires U-Boot to be built with UEFI support, the default of arm64 boards.
+const DefaultScript = `echo "Smee: chain loading iPXE for {{ .MAC }}"
+tftpboot ${kernel_addr_r} {{ .TFTPServer }}:{{ .MAC }}/{{ .IPXEBinary }}
+bootefi ${kernel_addr_r} ${fdtcontroladdr}
+`
+
+// Bootfile returns the boot file of a U-Boot client, its boot script in its MAC address directory.
+func Bootfile(mac net.HardwareAddr) string {
+	return path.Join(mac.String(), ScriptName)
+}
+
+// Data is what the boot script and the PXE config templates are executed with.
+type Data struct {
+	tmpl.Machine
+	// TFTPServer is the IP address of the TFTP server.
+	TFTPServer string
+	// HTTPServer is the base URL of the HTTP server, for example http://192.168.2.111:8080.
+	HTTPServer string
+	// IPXEBinary is the iPXE binary that the default boot script chain loads.
+	IPXEBinary string
+}
+
+// MACResolver resolves the IP address of a client to the MAC address of its machine.
+type MACResolver interface {
+	MAC(ip net.IP) (net.HardwareAddr, bool)
+}
+
+// Handler serves the boot scripts and the PXE configs of machines over HTTP and TFTP.
+type Handler struct {
+	Backend handler.BackendReader
+	// Addresses, when set, resolves the machine of the requests without a MAC address from their source IP.
+	Addresses MACResolver
+	// Script is the template of the boot script, DefaultScript when empty.
+	Script string
+	// Extlinux is the template of the PXE config, it is not served when empty.
+	Extlinux string
+	// TFTPServer and HTTPServer are the servers that the templates load the next files from, see Data.
+	TFTPServer string
+	HTTPServer string
+	// IPXEBinary is the iPXE binary of the default boot script, snp.efi when empty.
+	IPXEBinary string
+	// TemplateEnv is the allow list of the environment variables that the templates can read, see tmpl.Options.
+	TemplateEnv []string
+	Log         logr.Logger
+}
+
+// errNotAllowed is returned for the machines that are not allowed to netboot.
+var errNotAllowed = errors.New("netboot not allowed")
+
+// file returns what the requested path is, script or extlinux, and the MAC address in the path. ok is false if the
+// path is not a boot script or a PXE config.
+//
+//	[<mac>/]boot.scr.uimg
+//	[<mac>/]boot.scr
+//	[<dir>/]pxelinux.cfg/01-<mac>
+func file(p string) (kind string, mac net.HardwareAddr, ok bool) {
+	name := path.Base(p)
+	dir := path.Dir(strings.TrimPrefix(p, "/"))
+	switch {
+	case name == ScriptName || name == "boot.scr":
+		mac, _ = net.ParseMAC(path.Base(dir))
+		return "script", mac, true
+	case path.Base(dir) == pxeConfigDir && strings.HasPrefix(name, "01-"):
+		mac, err := net.ParseMAC(strings.TrimPrefix(name, "01-"))
+		if err != nil {
+			return "", nil, false
+		}
+		return "extlinux", mac, true
+	}
+
+	return "", nil, false
+}
+
+// render returns the boot script or the PXE config of the machine with mac, or with the source IP when mac is nil.
+func (h *Handler) render(ctx context.Context, kind string, mac net.HardwareAddr, src net.IP) ([]byte, error) {
+	if mac == nil && h.Addresses != nil {
+		mac, _ = h.Addresses.MAC(src)
+	}
+	if mac == nil {
+		return nil, fmt.Errorf("no machine for the source address %v", src)
+	}
+	if kind == "extlinux" && h.Extlinux == "" {
+		return nil, os.ErrNotExist
+	}
+	d, n, err := h.Backend.GetByMac(ctx, mac)
+	if err != nil {
+		return nil, err
+	}
+	if n != nil && !n.AllowNetboot {
+		return nil, errNotAllowed
+	}
+	data := Data{
+		Machine:    tmpl.FromBackend(d, n),
+		TFTPServer: h.TFTPServer,
+		HTTPServer: h.HTTPServer,
+		IPXEBinary: h.IPXEBinary,
+	}
+	// the MAC address of the request, the backend record can be keyed on another one of the machine.
+	data.MAC = mac.String()
+	if data.IPXEBinary == "" {
+		data.IPXEBinary = "snp.efi"
+	}
+	funcs := tmpl.Funcs(tmpl.Options{Env: h.TemplateEnv})
+	if kind == "extlinux" {
+		s, err := tmpl.Execute("extlinux", h.Extlinux, data, funcs)
+		return []byte(s), err
+	}
+	text := h.Script
+	if text == "" {
+		text = DefaultScript
+	}
+	s, err := tmpl.Execute("boot script", text, data, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScriptImage(s), nil
+}
+
+// ScriptImage returns script as a legacy U-Boot script image, like mkimage -A arm -O linux -T script -C none. The
+// data of a script image is a list of the lengths of its parts, terminated by a zero length, followed by the parts,
+// a script has a single part.
+func ScriptImage(script string) []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(script)))
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = append(data, script...)
+
+	const (
+		magic     = 0x27051956
+		osLinux   = 5
+		archARM   = 2
+		typScript = 6
+		compNone  = 0
+	)
+	h := make([]byte, 64)
+	binary.BigEndian.PutUint32(h[0:], magic)
+	binary.BigEndian.PutUint32(h[12:], uint32(len(data)))
+	binary.BigEndian.PutUint32(h[24:], crc32.ChecksumIEEE(data))
+	h[28], h[29], h[30], h[31] = osLinux, archARM, typScript, compNone
+	copy(h[32:], "smee boot script")
+	// the header checksum is computed with its own field zeroed.
+	binary.BigEndian.PutUint32(h[4:], crc32.ChecksumIEEE(h))
+
+	return append(h, data...)
+}
+
+// ServeHTTP serves the boot scripts and the PXE configs over HTTP, under Prefix.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kind, mac, ok := file(strings.TrimPrefix(r.URL.Path, Prefix))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !httpfile.Allowed(w, r) {
+		return
+	}
+	log := h.log().WithValues("path", r.URL.Path, "client", r.RemoteAddr)
+	var src net.IP
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		src = net.ParseIP(host)
+	}
+	b, err := h.render(r.Context(), kind, mac, src)
+	if err != nil {
+		log.Info("not serving u-boot file", "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	if kind == "extlinux" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	httpfile.ServeBytes(w, r, path.Base(r.URL.Path), time.Time{}, b)
+	log.Info("served u-boot file", "kind", kind)
+}
+
+// TFTPReadHandler returns a TFTP read handler that serves the boot scripts and the PXE configs, and passes the reads
+// of any other file to fallback.
+func (h *Handler) TFTPReadHandler(fallback func(filename string, rf io.ReaderFrom) error) func(string, io.ReaderFrom) error {
+	return func(filename string, rf io.ReaderFrom) error {
+		kind, mac, ok := file(filename)
+		if !ok {
+			return fallback(filename, rf)
+		}
+		log := h.log().WithValues("filename", filename)
+		var src net.IP
+		ot, isOT := rf.(tftp.OutgoingTransfer)
+		if isOT {
+			a := ot.RemoteAddr()
+			src = a.IP
+			log = log.WithValues("client", a.String())
+		}
+		b, err := h.render(context.Background(), kind, mac, src)
+		if err != nil {
+			log.Info("not serving u-boot file", "error", err)
+			return fmt.Errorf("%w: %w", os.ErrNotExist, err)
+		}
+		if isOT {
+			ot.SetSize(int64(len(b)))
+		}
+		n, err := rf.ReadFrom(bytes.NewReader(b))
+		if err != nil {
+			log.Error(err, "file serve failed", "bytesSent", n, "contentSize", len(b))
+			return err
+		}
+		log.Info("served u-boot file", "kind", kind, "bytesSent", n)
+
+		return nil
+	}
+}
+
+func (h *Handler) log() logr.Logger {
+	if h.Log.GetSink() == nil {
+		return logr.Discard()
+	}
+
+	return h.Log
+}
@@ -0,0 +1,182 @@
+package uboot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+)
+
+var known = net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+
+type fakeBackend struct {
+	allow bool
+}
+
+func (b fakeBackend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if mac.String() != known.String() {
+		return nil, nil, errors.New("not found")
+	}
+
+	return &data.DHCP{MACAddress: mac, IPAddress: netip.MustParseAddr("192.168.2.10"), Hostname: "sbc1"}, &data.Netboot{AllowNetboot: b.allow}, nil
+}
+
+func (fakeBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+type fakeResolver map[string]net.HardwareAddr
+
+func (r fakeResolver) MAC(ip net.IP) (net.HardwareAddr, bool) {
+	mac, ok := r[ip.String()]
+	return mac, ok
+}
+
+type fakeReaderFrom struct {
+	bytes.Buffer
+}
+
+func (f *fakeReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	return f.Buffer.ReadFrom(r)
+}
+
+func newHandler() *Handler {
+	return &Handler{
+		Backend:    fakeBackend{allow: true},
+		Addresses:  fakeResolver{"192.168.2.10": known},
+		Extlinux:   "LABEL {{ .Hostname }}\n  KERNEL {{ .HTTPServer }}/vmlinuz\n",
+		TFTPServer: "192.168.2.5",
+		HTTPServer: "http://192.168.2.5:8080",
+		Log:        logr.Discard(),
+	}
+}
+
+// script returns the script of a script image, after checking its header and checksums.
+func script(t *testing.T, img []byte) string {
+	t.Helper()
+	if len(img) < 72 {
+		t.Fatalf("got a %d bytes image, too short for a script image", len(img))
+	}
+	h := bytes.Clone(img[:64])
+	if got := binary.BigEndian.Uint32(h); got != 0x27051956 {
+		t.Fatalf("got magic %#x", got)
+	}
+	hcrc := binary.BigEndian.Uint32(h[4:])
+	binary.BigEndian.PutUint32(h[4:], 0)
+	if hcrc != crc32.ChecksumIEEE(h) {
+		t.Fatal("the header checksum is wrong")
+	}
+	if h[30] != 6 {
+		t.Fatalf("got image type %d, want a script", h[30])
+	}
+	d := img[64:]
+	if int(binary.BigEndian.Uint32(h[12:])) != len(d) || binary.BigEndian.Uint32(h[24:]) != crc32.ChecksumIEEE(d) {
+		t.Fatal("the data size or checksum is wrong")
+	}
+	n := binary.BigEndian.Uint32(d)
+	if binary.BigEndian.Uint32(d[4:]) != 0 || int(n) != len(d)-8 {
+		t.Fatal("the image is not a single part script")
+	}
+
+	return string(d[8:])
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := newHandler()
+	tests := map[string]struct {
+		path   string
+		remote string
+		want   int
+		body   string
+	}{
+		"script":                  {path: "/uboot/00:01:02:03:04:05/boot.scr.uimg", want: http.StatusOK, body: "tftpboot ${kernel_addr_r} 192.168.2.5:00:01:02:03:04:05/snp.efi"},
+		"script by source IP":     {path: "/uboot/boot.scr.uimg", remote: "192.168.2.10:1234", want: http.StatusOK, body: "bootefi"},
+		"extlinux":                {path: "/uboot/00:01:02:03:04:05/pxelinux.cfg/01-00-01-02-03-04-05", want: http.StatusOK, body: "LABEL sbc1\n  KERNEL http://192.168.2.5:8080/vmlinuz"},
+		"unknown machine":         {path: "/uboot/00:00:00:00:00:01/boot.scr.uimg", want: http.StatusNotFound},
+		"unknown source IP":       {path: "/uboot/boot.scr", remote: "192.168.2.11:1234", want: http.StatusNotFound},
+		"pxe config of an IP":     {path: "/uboot/pxelinux.cfg/C0A8020A", want: http.StatusNotFound},
+		"not a u-boot file":       {path: "/uboot/00:01:02:03:04:05/snp.efi", want: http.StatusNotFound},
+		"default pxe config":      {path: "/uboot/pxelinux.cfg/default", want: http.StatusNotFound},
+		"pxe config of a bad MAC": {path: "/uboot/pxelinux.cfg/01-zz", want: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.remote != "" {
+				req.RemoteAddr = tt.remote
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+			if tt.body == "" {
+				return
+			}
+			got := w.Body.String()
+			if strings.HasSuffix(tt.path, ".uimg") {
+				got = script(t, w.Body.Bytes())
+			}
+			if !strings.Contains(got, tt.body) {
+				t.Fatalf("got %q, want it to contain %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestNotAllowed(t *testing.T) {
+	h := newHandler()
+	h.Backend = fakeBackend{allow: false}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/uboot/00:01:02:03:04:05/boot.scr.uimg", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d for a machine that is not allowed to netboot, want %d", w.Code, http.StatusNotFound)
+	}
+
+	h = newHandler()
+	h.Extlinux = ""
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/uboot/pxelinux.cfg/01-00-01-02-03-04-05", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d without an extlinux template, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestTFTPReadHandler(t *testing.T) {
+	h := newHandler()
+	h.Script = "echo {{ .Hostname }} {{ .IP }}"
+	read := h.TFTPReadHandler(func(_ string, rf io.ReaderFrom) error {
+		_, err := rf.ReadFrom(strings.NewReader("fallback"))
+		return err
+	})
+
+	rf := &fakeReaderFrom{}
+	if err := read("00:01:02:03:04:05/boot.scr.uimg", rf); err != nil {
+		t.Fatal(err)
+	}
+	if got := script(t, rf.Bytes()); got != "echo sbc1 192.168.2.10" {
+		t.Fatalf("got script %q", got)
+	}
+
+	rf = &fakeReaderFrom{}
+	if err := read("00:01:02:03:04:05/snp.efi", rf); err != nil || rf.String() != "fallback" {
+		t.Fatalf("got %q, %v, want the read to be passed to the fallback", rf.String(), err)
+	}
+
+	// without a TFTP transfer there is no source address to resolve the machine from.
+	if err := read("boot.scr.uimg", &fakeReaderFrom{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
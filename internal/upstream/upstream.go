@@ -0,0 +1,271 @@
+// Package upstream wraps the outbound HTTP requests of Smee, like those to the source ISO, with retries and a circuit
+// breaker, so that a flaky or failing upstream is retried with backoff and a failed one is not hammered.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+// ErrCircuitOpen is returned for the requests to a destination whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Policy is the retry and circuit breaker policy of the requests to a destination.
+type Policy struct {
+	// Retries is the number of times a failed idempotent request is retried, 0 disables retries.
+	// A request fails with a connection error or a 429, 502, 503 or 504 status.
+	Retries int
+	// Backoff is the wait before the first retry, it doubles on every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BreakerFailures is the number of consecutive failed requests that opens the circuit breaker, 0 disables it.
+	// A request fails with a connection error or a 5xx status.
+	BreakerFailures int
+	// BreakerOpen is how long the circuit breaker stays open, failing the requests right away, before it lets a trial
+	// request through. The breaker closes when the trial request succeeds.
+	BreakerOpen time.Duration
+	// Timeout is how long every attempt of a request waits for the response headers, 0 waits as long as the context of
+	// the request allows. An attempt that times out fails, and is retried like one with a connection error.
+	Timeout time.Duration
+}
+
+// Merge returns p with the fields that are set in override replacing those of p, the counts are set when they are
+// not negative and the durations when they are positive.
+// It is used to apply per-destination policies over the global policy.
+func (p Policy) Merge(override Policy) Policy {
+	if override.Retries >= 0 {
+		p.Retries = override.Retries
+	}
+	if override.Backoff > 0 {
+		p.Backoff = override.Backoff
+	}
+	if override.MaxBackoff > 0 {
+		p.MaxBackoff = override.MaxBackoff
+	}
+	if override.BreakerFailures >= 0 {
+		p.BreakerFailures = override.BreakerFailures
+	}
+	if override.BreakerOpen > 0 {
+		p.BreakerOpen = override.BreakerOpen
+	}
+	if override.Timeout > 0 {
+		p.Timeout = override.Timeout
+	}
+
+	return p
+}
+
+// Validate returns an error when the policy is invalid.
+func (p Policy) Validate() error {
+	var errs []error
+	if p.Retries < 0 {
+		errs = append(errs, errors.New("retries must not be negative"))
+	}
+	if p.Retries > 0 && p.Backoff <= 0 {
+		errs = append(errs, errors.New("retry backoff must be positive"))
+	}
+	if p.MaxBackoff > 0 && p.MaxBackoff < p.Backoff {
+		errs = append(errs, errors.New("retry max backoff must not be less than the retry backoff"))
+	}
+	if p.BreakerFailures < 0 {
+		errs = append(errs, errors.New("breaker failures must not be negative"))
+	}
+	if p.BreakerFailures > 0 && p.BreakerOpen <= 0 {
+		errs = append(errs, errors.New("breaker open duration must be positive"))
+	}
+	if p.Timeout < 0 {
+		errs = append(errs, errors.New("timeout must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Transport is an http.RoundTripper that sends the requests to a destination with Base, retrying them and breaking
+// the circuit as set by Policy. Its requests are counted in the upstream metrics by Destination.
+type Transport struct {
+	// Destination names the upstream in the metrics and errors, like "iso".
+	Destination string
+	Policy      Policy
+	// Base sends the requests, http.DefaultTransport when nil.
+	Base http.RoundTripper
+
+	mu sync.Mutex
+	// failures is the number of consecutive failed requests.
+	failures int
+	// openUntil is when the open circuit breaker lets a trial request through.
+	openUntil time.Time
+	// trial is whether a trial request of the half-open breaker is in flight.
+	trial bool
+}
+
+// New returns a Transport of the destination, with base as its Base.
+func New(destination string, p Policy, base http.RoundTripper) *Transport {
+	return &Transport{Destination: destination, Policy: p, Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	wait := t.Policy.Backoff
+	for attempt := 0; ; attempt++ {
+		if err := t.allow(); err != nil {
+			metric.UpstreamRequests.WithLabelValues(t.Destination, "circuit-open").Inc()
+			return nil, err
+		}
+		r := req
+		if attempt > 0 {
+			var err error
+			if r, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.send(base, r)
+		t.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		if attempt >= t.Policy.Retries || !retryable(req, resp, err) {
+			result := "success"
+			if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+				result = "failure"
+			}
+			metric.UpstreamRequests.WithLabelValues(t.Destination, result).Inc()
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		metric.UpstreamRetries.WithLabelValues(t.Destination).Inc()
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			metric.UpstreamRequests.WithLabelValues(t.Destination, "failure").Inc()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		if wait *= 2; t.Policy.MaxBackoff > 0 && wait > t.Policy.MaxBackoff {
+			wait = t.Policy.MaxBackoff
+		}
+	}
+}
+
+// send sends a single attempt of r with base, failing it when its response headers do not arrive within the Timeout
+// of the policy. The body of the response is bound to the attempt, it is released when the body is closed.
+func (t *Transport) send(base http.RoundTripper, r *http.Request) (*http.Response, error) {
+	if t.Policy.Timeout <= 0 {
+		return base.RoundTrip(r)
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(t.Policy.Timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	resp, err := base.RoundTrip(r.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		cancel()
+		if timedOut.Load() {
+			metric.Timeouts.WithLabelValues("upstream").Inc()
+			return nil, fmt.Errorf("no response from %s within %s: %w", t.Destination, t.Policy.Timeout, context.DeadlineExceeded)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// cancelBody cancels the context of its request when it is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
+
+// allow returns ErrCircuitOpen when the circuit breaker is open, a half-open breaker lets a single trial request through.
+func (t *Transport) allow() error {
+	if t.Policy.BreakerFailures <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failures < t.Policy.BreakerFailures {
+		return nil
+	}
+	if t.trial || time.Now().Before(t.openUntil) {
+		return fmt.Errorf("%w for %s", ErrCircuitOpen, t.Destination)
+	}
+	t.trial = true
+
+	return nil
+}
+
+// record records the result of a request in the circuit breaker.
+func (t *Transport) record(ok bool) {
+	if t.Policy.BreakerFailures <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trial = false
+	if ok {
+		t.failures = 0
+		metric.UpstreamCircuitOpen.WithLabelValues(t.Destination).Set(0)
+		return
+	}
+	t.failures++
+	if t.failures >= t.Policy.BreakerFailures {
+		t.openUntil = time.Now().Add(t.Policy.BreakerOpen)
+		metric.UpstreamCircuitOpen.WithLabelValues(t.Destination).Set(1)
+	}
+}
+
+// retryable returns whether a request that got resp, or err, is retried: only idempotent requests whose body can be
+// sent again are retried, after a connection error or a 429, 502, 503 or 504 status.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		return req.Context().Err() == nil && !errors.Is(err, ErrCircuitOpen)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// rewind returns a clone of req with a new body, to send it again.
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	return r, nil
+}
@@ -0,0 +1,166 @@
+package upstream
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+// flaky returns a server that responds with the statuses in order, and then with 200 OK.
+func flaky(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var n atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		i := int(n.Add(1)) - 1
+		if i < len(statuses) {
+			w.WriteHeader(statuses[i])
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(s.Close)
+
+	return s, &n
+}
+
+func TestRetries(t *testing.T) {
+	tests := map[string]struct {
+		statuses   []int
+		method     string
+		retries    int
+		wantStatus int
+		wantCalls  int32
+	}{
+		"retried":                 {statuses: []int{503, 502}, method: http.MethodGet, retries: 2, wantStatus: 200, wantCalls: 3},
+		"out of retries":          {statuses: []int{503, 503, 503}, method: http.MethodGet, retries: 2, wantStatus: 503, wantCalls: 3},
+		"not retryable status":    {statuses: []int{500}, method: http.MethodGet, retries: 2, wantStatus: 500, wantCalls: 1},
+		"not idempotent":          {statuses: []int{503}, method: http.MethodPost, retries: 2, wantStatus: 503, wantCalls: 1},
+		"retries disabled":        {statuses: []int{503}, method: http.MethodGet, wantStatus: 503, wantCalls: 1},
+		"client error not failed": {statuses: []int{404}, method: http.MethodHead, retries: 2, wantStatus: 404, wantCalls: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, calls := flaky(t, tt.statuses...)
+			c := &http.Client{Transport: New("iso", Policy{Retries: tt.retries, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}, nil)}
+			req, err := http.NewRequest(tt.method, s.URL, strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status code %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Fatalf("got %d requests, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s, calls := flaky(t, 500, 500, 500)
+	tr := New("iso", Policy{BreakerFailures: 2, BreakerOpen: 20 * time.Millisecond}, nil)
+	c := &http.Client{Transport: tr}
+	get := func() (int, error) {
+		resp, err := c.Get(s.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	for range 2 {
+		if code, err := get(); err != nil || code != 500 {
+			t.Fatalf("got %d, %v, want 500", code, err)
+		}
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want the circuit to be open", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("got %d requests, want 2", calls.Load())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	// the trial request fails and opens the circuit again.
+	if code, err := get(); err != nil || code != 500 {
+		t.Fatalf("got %d, %v, want the trial request", code, err)
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want the circuit to be open again", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if code, err := get(); err != nil || code != 200 {
+		t.Fatalf("got %d, %v, want the trial request to succeed", code, err)
+	}
+	if code, err := get(); err != nil || code != 200 {
+		t.Fatalf("got %d, %v, want the circuit to be closed", code, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	global := Policy{Retries: 2, Backoff: time.Second, MaxBackoff: 10 * time.Second, BreakerFailures: 5, BreakerOpen: time.Minute}
+	got := global.Merge(Policy{Retries: 0, BreakerFailures: -1, BreakerOpen: time.Hour, Timeout: time.Second})
+	want := Policy{Retries: 0, Backoff: time.Second, MaxBackoff: 10 * time.Second, BreakerFailures: 5, BreakerOpen: time.Hour, Timeout: time.Second}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if err := (Policy{Retries: 1}).Validate(); err == nil {
+		t.Fatal("expected retries without a backoff to be invalid")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var n atomic.Int32
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(s.Close)
+	t.Cleanup(func() { close(release) })
+	c := &http.Client{Transport: New("iso", Policy{Retries: 1, Backoff: time.Millisecond, Timeout: 20 * time.Millisecond}, nil)}
+	before := testutil.ToFloat64(metric.Timeouts.WithLabelValues("upstream"))
+
+	resp, err := c.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "ok" {
+		t.Fatalf("got body %q, want the retried request to succeed", body)
+	}
+	if got := testutil.ToFloat64(metric.Timeouts.WithLabelValues("upstream")) - before; got != 1 {
+		t.Fatalf("got %v upstream timeouts, want 1", got)
+	}
+}
@@ -0,0 +1,39 @@
+// Package useragent classifies the HTTP clients of the boot paths by their User-Agent header.
+package useragent
+
+import "strings"
+
+// Client is the kind of HTTP client of a boot path request.
+type Client string
+
+const (
+	// IPXE is iPXE, for example "iPXE/1.21.1".
+	IPXE Client = "ipxe"
+	// GRUB is GRUB with its http module, for example "GRUB 2.06".
+	GRUB Client = "grub"
+	// UEFIHTTP is the UEFI HTTP boot firmware of EDK II based firmware, for example "UefiHttpBoot/1.0".
+	UEFIHTTP Client = "uefi-http"
+	// Curl is curl, used by operators to check what a machine is served, for example "curl/8.5.0".
+	Curl Client = "curl"
+	// Unknown is any other client, or a request without a User-Agent.
+	Unknown Client = "unknown"
+)
+
+// Clients are all the kinds of clients, in the order they are matched.
+var Clients = []Client{IPXE, GRUB, UEFIHTTP, Curl, Unknown}
+
+// Classify returns the kind of client of the User-Agent ua.
+func Classify(ua string) Client {
+	switch {
+	case strings.HasPrefix(ua, "iPXE/"):
+		return IPXE
+	case strings.HasPrefix(ua, "GRUB "), strings.HasPrefix(ua, "GRUB/"):
+		return GRUB
+	case strings.HasPrefix(ua, "UefiHttpBoot/"):
+		return UEFIHTTP
+	case strings.HasPrefix(ua, "curl/"):
+		return Curl
+	}
+
+	return Unknown
+}
@@ -0,0 +1,21 @@
+package useragent
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	tests := map[string]Client{
+		"iPXE/1.21.1":      IPXE,
+		"GRUB 2.06":        GRUB,
+		"GRUB/2.12":        GRUB,
+		"UefiHttpBoot/1.0": UEFIHTTP,
+		"curl/8.5.0":       Curl,
+		"Mozilla/5.0":      Unknown,
+		"":                 Unknown,
+		"ipxe":             Unknown,
+	}
+	for ua, want := range tests {
+		if got := Classify(ua); got != want {
+			t.Errorf("Classify(%q) = %s, want %s", ua, got, want)
+		}
+	}
+}
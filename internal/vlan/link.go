@@ -0,0 +1,55 @@
+package vlan
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Ensure creates the subinterface of v on its parent interface when it doesn't exist, assigns it the Address of v,
+// and sets it up. It returns whether the subinterface was created, it is not changed when it exists.
+// Creating a subinterface requires the CAP_NET_ADMIN capability.
+func Ensure(v VLAN) (bool, error) {
+	if _, err := net.InterfaceByName(v.Name()); err == nil {
+		return false, nil
+	}
+	if v.Parent == "" {
+		return false, fmt.Errorf("interface %s does not exist, set the parent of vlan %d to create it", v.Name(), v.ID)
+	}
+	parent, err := netlink.LinkByName(v.Parent)
+	if err != nil {
+		return false, fmt.Errorf("parent interface %s: %w", v.Parent, err)
+	}
+	link := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: v.Name(), ParentIndex: parent.Attrs().Index}, VlanId: v.ID}
+	if err := netlink.LinkAdd(link); err != nil {
+		return false, fmt.Errorf("create interface %s: %w", v.Name(), err)
+	}
+	if v.Address != "" {
+		addr, err := netlink.ParseAddr(v.Address)
+		if err != nil {
+			_ = netlink.LinkDel(link)
+			return false, err
+		}
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			_ = netlink.LinkDel(link)
+			return false, fmt.Errorf("assign %s to interface %s: %w", v.Address, v.Name(), err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		_ = netlink.LinkDel(link)
+		return false, fmt.Errorf("set interface %s up: %w", v.Name(), err)
+	}
+
+	return true, nil
+}
+
+// Remove removes the subinterface of v.
+func Remove(v VLAN) error {
+	link, err := netlink.LinkByName(v.Name())
+	if err != nil {
+		return err
+	}
+
+	return netlink.LinkDel(link)
+}
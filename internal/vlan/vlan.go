@@ -0,0 +1,146 @@
+// Package vlan configures the DHCP listeners of Smee on VLAN subinterfaces, so that one Smee serves the machines of
+// several VLAN segmented provisioning networks.
+//
+// Every VLAN gets its own DHCP listener, bound to its subinterface, and DHCP handler, that advertises the address of
+// Smee on the VLAN. A subinterface that doesn't exist is created on its parent interface, and removed when Smee stops.
+//
+//	vlans:
+//	  - id: 100
+//	    parent: eth0
+//	    address: 10.100.0.2/24
+//	  - id: 200
+//	    interface: bond0.200
+//	    mode: proxy
+//	    syslogIP: 10.200.0.7
+package vlan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+
+	"github.com/ghodss/yaml"
+)
+
+// Config holds the VLANs that DHCP is served on.
+type Config struct {
+	VLANs []VLAN `json:"vlans"`
+}
+
+// VLAN is a VLAN that DHCP is served on and the values of its DHCP replies. Empty values are not overridden.
+type VLAN struct {
+	// ID is the 802.1Q VLAN ID, 1 to 4094.
+	ID int `json:"id"`
+	// Interface is the name of the subinterface of the VLAN, <parent>.<id> when empty.
+	Interface string `json:"interface,omitempty"`
+	// Parent is the interface that the subinterface is created on when it doesn't exist.
+	Parent string `json:"parent,omitempty"`
+	// Address is the CIDR of Smee on the VLAN, it is assigned to a created subinterface. Its IP replaces the advertised
+	// IP in the DHCP replies on the VLAN, the IP of the subinterface is used when it is empty.
+	Address string `json:"address,omitempty"`
+	// Mode is the DHCP mode on the VLAN, reservation, proxy or auto-proxy.
+	Mode string `json:"mode,omitempty"`
+	// SyslogIP, TFTPIP and HTTPHost are the addresses of the syslog, TFTP and HTTP servers in the DHCP replies on
+	// the VLAN, they default to the IP of the VLAN.
+	SyslogIP string `json:"syslogIP,omitempty"`
+	TFTPIP   string `json:"tftpIP,omitempty"`
+	HTTPHost string `json:"httpHost,omitempty"`
+}
+
+// Load reads and validates a YAML, or JSON, VLAN config file.
+func Load(file string) (*Config, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML, or JSON, VLAN config.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse vlan config: %w", err)
+	}
+	var errs []error
+	names := map[string]bool{}
+	for _, v := range c.VLANs {
+		if err := v.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("vlan %d: %w", v.ID, err))
+			continue
+		}
+		if names[v.Name()] {
+			errs = append(errs, fmt.Errorf("vlan %d: the interface %s is used by another vlan", v.ID, v.Name()))
+		}
+		names[v.Name()] = true
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return c, nil
+}
+
+func (v VLAN) validate() error {
+	if v.ID < 1 || v.ID > 4094 {
+		return errors.New("invalid id, must be 1 to 4094")
+	}
+	if v.Interface == "" && v.Parent == "" {
+		return errors.New("interface or parent is required")
+	}
+	if v.Address != "" {
+		p, err := netip.ParsePrefix(v.Address)
+		if err != nil {
+			return fmt.Errorf("invalid address, must be a CIDR: %w", err)
+		}
+		if !p.Addr().Is4() {
+			return fmt.Errorf("invalid address %q, must be an IPv4 CIDR", v.Address)
+		}
+	}
+	for name, ip := range map[string]string{"syslogIP": v.SyslogIP, "tftpIP": v.TFTPIP} {
+		if ip == "" {
+			continue
+		}
+		if a, err := netip.ParseAddr(ip); err != nil || !a.Is4() {
+			return fmt.Errorf("invalid %s %q, must be an IPv4 address", name, ip)
+		}
+	}
+
+	return nil
+}
+
+// Name returns the name of the subinterface of v.
+func (v VLAN) Name() string {
+	if v.Interface != "" {
+		return v.Interface
+	}
+
+	return fmt.Sprintf("%s.%d", v.Parent, v.ID)
+}
+
+// IP returns the IP of Smee on v, the IP of Address, or else the first IPv4 address of its subinterface. It is the zero
+// netip.Addr when neither is known.
+func (v VLAN) IP() netip.Addr {
+	if p, err := netip.ParsePrefix(v.Address); err == nil {
+		return p.Addr()
+	}
+	iface, err := net.InterfaceByName(v.Name())
+	if err != nil {
+		return netip.Addr{}
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			ip, _ := netip.AddrFromSlice(n.IP.To4())
+			return ip
+		}
+	}
+
+	return netip.Addr{}
+}
@@ -0,0 +1,74 @@
+package vlan
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testConfig = `
+vlans:
+  - id: 100
+    parent: eth0
+    address: 10.100.0.2/24
+  - id: 200
+    interface: bond0.200
+    mode: proxy
+    syslogIP: 10.200.0.7
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VLAN{
+		{ID: 100, Parent: "eth0", Address: "10.100.0.2/24"},
+		{ID: 200, Interface: "bond0.200", Mode: "proxy", SyslogIP: "10.200.0.7"},
+	}
+	if diff := cmp.Diff(want, c.VLANs); diff != "" {
+		t.Fatal(diff)
+	}
+	if got := c.VLANs[0].Name(); got != "eth0.100" {
+		t.Fatalf("got interface %q, want eth0.100", got)
+	}
+	if got := c.VLANs[0].IP(); got != netip.MustParseAddr("10.100.0.2") {
+		t.Fatalf("got ip %v, want the ip of the address", got)
+	}
+	if got := (VLAN{ID: 1, Interface: "lo"}).IP(); got != netip.MustParseAddr("127.0.0.1") {
+		t.Fatalf("got ip %v, want the ip of the interface", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		want   string
+	}{
+		"invalid id":          {config: "vlans: [{id: 4095, parent: eth0}]", want: "vlan 4095: invalid id"},
+		"no interface":        {config: "vlans: [{id: 100}]", want: "vlan 100: interface or parent is required"},
+		"invalid address":     {config: "vlans: [{id: 100, parent: eth0, address: 10.100.0.2}]", want: "vlan 100: invalid address"},
+		"invalid syslog ip":   {config: "vlans: [{id: 100, parent: eth0, syslogIP: nope}]", want: `vlan 100: invalid syslogIP "nope"`},
+		"duplicate interface": {config: "vlans: [{id: 100, parent: eth0}, {id: 101, interface: eth0.100}]", want: "vlan 101: the interface eth0.100 is used by another vlan"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureExisting(t *testing.T) {
+	created, err := Ensure(VLAN{ID: 1, Interface: "lo"})
+	if err != nil || created {
+		t.Fatalf("got created %v (%v), want an existing interface to be left as is", created, err)
+	}
+	if _, err := Ensure(VLAN{ID: 1, Interface: "smee-missing"}); err == nil {
+		t.Fatal("expected an error for a missing interface without a parent")
+	}
+}
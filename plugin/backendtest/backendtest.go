@@ -0,0 +1,277 @@
+// Package backendtest is the conformance test suite of Smee backends.
+//
+// A backend, of a plugin or built into Smee, loads the Machines fixtures and runs the suite from a test:
+//
+//	func TestConformance(t *testing.T) {
+//		b := newBackend(backendtest.Machines())
+//		backendtest.Run(t, b, backendtest.Options{})
+//	}
+//
+// The suite checks the lookups by MAC and IP address, that machines which aren't in the backend are not found,
+// that lookups are safe for concurrent use and that they return once their context is canceled.
+// The MAC address 52:54:00:00:00:ff and the IP address 192.168.2.250 must not be in the backend.
+package backendtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/smee/plugin"
+)
+
+var (
+	unknownMAC = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0xff}
+	unknownIP  = net.IPv4(192, 168, 2, 250)
+)
+
+// Options are the options of the suite.
+type Options struct {
+	// NotFound reports whether err is the error of the backend for a machine that it has no record for.
+	// The default is errors.Is(err, plugin.ErrNotFound).
+	NotFound func(err error) bool
+	// NoIPLookup skips the lookups by IP address, for backends that only identify machines by their MAC address.
+	NoIPLookup bool
+	// Timeout is how long a lookup can take, 5 seconds by default.
+	Timeout time.Duration
+	// Concurrency is the number of goroutines that look up the machines at the same time, 8 by default.
+	Concurrency int
+}
+
+func (o Options) withDefaults() Options {
+	if o.NotFound == nil {
+		o.NotFound = func(err error) bool { return errors.Is(err, plugin.ErrNotFound) }
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 8
+	}
+
+	return o
+}
+
+// Machine is a machine of the fixtures.
+type Machine struct {
+	DHCP    plugin.DHCP
+	Netboot plugin.Netboot
+}
+
+// Machines returns the fixtures that the backend under test must have, a new copy on every call.
+// The third machine is not allowed to netboot.
+func Machines() []Machine {
+	machine := func(n byte, hostname, arch, facility string, allowNetboot bool) Machine {
+		return Machine{
+			DHCP: plugin.DHCP{
+				MACAddress:     net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, n},
+				IPAddress:      netip.AddrFrom4([4]byte{192, 168, 2, 10 + n}),
+				SubnetMask:     net.IPv4Mask(255, 255, 255, 0),
+				DefaultGateway: netip.MustParseAddr("192.168.2.1"),
+				NameServers:    []net.IP{net.IPv4(1, 1, 1, 1).To4()},
+				Hostname:       hostname,
+				LeaseTime:      86400,
+				Arch:           arch,
+			},
+			Netboot: plugin.Netboot{
+				AllowNetboot: allowNetboot,
+				Facility:     facility,
+			},
+		}
+	}
+
+	return []Machine{
+		machine(1, "conformance-1", "x86_64", "onprem", true),
+		machine(2, "conformance-2", "aarch64", "lab", true),
+		machine(3, "conformance-3", "x86_64", "onprem", false),
+	}
+}
+
+// Run runs the suite against b, which must have the Machines fixtures.
+func Run(t *testing.T, b plugin.Backend, opts Options) {
+	t.Helper()
+	opts = opts.withDefaults()
+	machines := Machines()
+
+	t.Run("GetByMac", func(t *testing.T) {
+		for _, m := range machines {
+			d, n, err := lookup(context.Background(), t, opts, func(ctx context.Context) (*plugin.DHCP, *plugin.Netboot, error) {
+				return b.GetByMac(ctx, m.DHCP.MACAddress)
+			})
+			if err := check(m, d, n, err); err != nil {
+				t.Errorf("GetByMac(%s): %v", m.DHCP.MACAddress, err)
+			}
+		}
+	})
+
+	t.Run("GetByIP", func(t *testing.T) {
+		if opts.NoIPLookup {
+			t.Skip("the backend doesn't look up machines by IP address")
+		}
+		for _, m := range machines {
+			ip := net.IP(m.DHCP.IPAddress.AsSlice())
+			d, n, err := lookup(context.Background(), t, opts, func(ctx context.Context) (*plugin.DHCP, *plugin.Netboot, error) {
+				return b.GetByIP(ctx, ip)
+			})
+			if err := check(m, d, n, err); err != nil {
+				t.Errorf("GetByIP(%s): %v", ip, err)
+			}
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		d, n, err := lookup(context.Background(), t, opts, func(ctx context.Context) (*plugin.DHCP, *plugin.Netboot, error) {
+			return b.GetByMac(ctx, unknownMAC)
+		})
+		if err := checkNotFound(opts, d, n, err); err != nil {
+			t.Errorf("GetByMac(%s): %v", unknownMAC, err)
+		}
+		if opts.NoIPLookup {
+			return
+		}
+		d, n, err = lookup(context.Background(), t, opts, func(ctx context.Context) (*plugin.DHCP, *plugin.Netboot, error) {
+			return b.GetByIP(ctx, unknownIP)
+		})
+		if err := checkNotFound(opts, d, n, err); err != nil {
+			t.Errorf("GetByIP(%s): %v", unknownIP, err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make(chan error, opts.Concurrency*len(machines)*2)
+		for range opts.Concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+				defer cancel()
+				for _, m := range machines {
+					d, n, err := b.GetByMac(ctx, m.DHCP.MACAddress)
+					if err := check(m, d, n, err); err != nil {
+						errs <- fmt.Errorf("GetByMac(%s): %w", m.DHCP.MACAddress, err)
+					}
+					if opts.NoIPLookup {
+						continue
+					}
+					d, n, err = b.GetByIP(ctx, m.DHCP.IPAddress.AsSlice())
+					if err := check(m, d, n, err); err != nil {
+						errs <- fmt.Errorf("GetByIP(%s): %w", m.DHCP.IPAddress, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		// A backend can answer from memory without looking at the context, but it must not return other data or hang.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		m := machines[0]
+		d, n, err := lookup(ctx, t, opts, func(ctx context.Context) (*plugin.DHCP, *plugin.Netboot, error) {
+			return b.GetByMac(ctx, m.DHCP.MACAddress)
+		})
+		if err == nil {
+			if err := check(m, d, n, nil); err != nil {
+				t.Errorf("GetByMac(%s) with a canceled context: %v", m.DHCP.MACAddress, err)
+			}
+		}
+		_, _, err = lookup(ctx, t, opts, func(ctx context.Context) (*plugin.DHCP, *plugin.Netboot, error) {
+			return b.GetByMac(ctx, unknownMAC)
+		})
+		if err == nil {
+			t.Errorf("GetByMac(%s) with a canceled context: got no error for an unknown machine", unknownMAC)
+		}
+	})
+}
+
+// lookup calls fn, failing the test when it doesn't return within the timeout of opts.
+func lookup(ctx context.Context, t *testing.T, opts Options, fn func(context.Context) (*plugin.DHCP, *plugin.Netboot, error)) (*plugin.DHCP, *plugin.Netboot, error) {
+	t.Helper()
+	type result struct {
+		d   *plugin.DHCP
+		n   *plugin.Netboot
+		err error
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		d, n, err := fn(ctx)
+		done <- result{d: d, n: n, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.d, r.n, r.err
+	case <-time.After(opts.Timeout + time.Second):
+		t.Fatalf("the lookup didn't return within %s", opts.Timeout)
+		return nil, nil, nil
+	}
+}
+
+// check returns an error when the lookup of m failed or returned the data of another machine.
+// Only the fields that every backend can store are compared.
+func check(m Machine, d *plugin.DHCP, n *plugin.Netboot, err error) error {
+	if err != nil {
+		return err
+	}
+	if d == nil || n == nil {
+		return errors.New("got no DHCP or netboot data and no error")
+	}
+	if got, want := view(d, n), view(&m.DHCP, &m.Netboot); got != want {
+		return fmt.Errorf("got %+v, want %+v", got, want)
+	}
+
+	return nil
+}
+
+// machineView is the data of a machine that is compared.
+type machineView struct {
+	MAC          string
+	IP           string
+	SubnetMask   string
+	Gateway      string
+	Hostname     string
+	LeaseTime    uint32
+	AllowNetboot bool
+	Facility     string
+}
+
+func view(d *plugin.DHCP, n *plugin.Netboot) machineView {
+	return machineView{
+		MAC:          d.MACAddress.String(),
+		IP:           d.IPAddress.String(),
+		SubnetMask:   net.IP(d.SubnetMask).String(),
+		Gateway:      d.DefaultGateway.String(),
+		Hostname:     d.Hostname,
+		LeaseTime:    d.LeaseTime,
+		AllowNetboot: n.AllowNetboot,
+		Facility:     n.Facility,
+	}
+}
+
+// checkNotFound returns an error when the lookup of a machine that isn't in the backend didn't fail with the
+// not found error of the backend, or returned data.
+func checkNotFound(opts Options, d *plugin.DHCP, n *plugin.Netboot, err error) error {
+	if err == nil {
+		return errors.New("got no error for an unknown machine")
+	}
+	if !opts.NotFound(err) {
+		return fmt.Errorf("got error %q, want a not found error", err)
+	}
+	if d != nil || n != nil {
+		return errors.New("got data with the not found error")
+	}
+
+	return nil
+}
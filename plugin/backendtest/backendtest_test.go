@@ -0,0 +1,62 @@
+package backendtest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/tinkerbell/smee/plugin"
+)
+
+// mapBackend is an in memory backend, the reference implementation of the suite.
+type mapBackend []Machine
+
+func (b mapBackend) GetByMac(_ context.Context, mac net.HardwareAddr) (*plugin.DHCP, *plugin.Netboot, error) {
+	for _, m := range b {
+		if m.DHCP.MACAddress.String() == mac.String() {
+			return &m.DHCP, &m.Netboot, nil
+		}
+	}
+
+	return nil, nil, plugin.ErrNotFound
+}
+
+func (b mapBackend) GetByIP(_ context.Context, ip net.IP) (*plugin.DHCP, *plugin.Netboot, error) {
+	for _, m := range b {
+		if m.DHCP.IPAddress.String() == ip.String() {
+			return &m.DHCP, &m.Netboot, nil
+		}
+	}
+
+	return nil, nil, plugin.ErrNotFound
+}
+
+func TestRun(t *testing.T) {
+	Run(t, mapBackend(Machines()), Options{})
+}
+
+func TestMachinesAreCopies(t *testing.T) {
+	m := Machines()
+	m[0].DHCP.MACAddress[5] = 0xff
+	if Machines()[0].DHCP.MACAddress[5] != 1 {
+		t.Fatal("expected Machines to return a new copy of the fixtures")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	m := Machines()
+	other := m[1]
+	if err := check(m[0], &other.DHCP, &other.Netboot, nil); err == nil {
+		t.Fatal("expected an error for the data of another machine")
+	}
+	if err := check(m[0], nil, nil, nil); err == nil {
+		t.Fatal("expected an error for no data and no error")
+	}
+	opts := Options{}.withDefaults()
+	if err := checkNotFound(opts, nil, nil, context.Canceled); err == nil {
+		t.Fatal("expected an error for an error other than not found")
+	}
+	if err := checkNotFound(opts, nil, nil, plugin.ErrNotFound); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+// Package plugin is the SDK for Smee plugins.
+//
+// A plugin is an executable that Smee starts and talks to with HashiCorp go-plugin, over net/rpc.
+// It lets out-of-tree code provide a backend, a DHCP handler or an auto.ipxe script generator without forking Smee.
+// A plugin implements one or more of the Backend, DHCPHandler and ScriptGenerator interfaces and calls Serve from its main function.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+)
+
+// ProtocolVersion is the version of the plugin protocol, the application protocol version of the go-plugin handshake.
+// Smee only talks to plugins that serve the same version.
+const ProtocolVersion = 1
+
+// MagicCookieKey and MagicCookieValue are set in the environment of a plugin by Smee.
+// They are not a security measure, they only make sure that a plugin is started by Smee and not directly by a user.
+const (
+	MagicCookieKey   = "SMEE_PLUGIN_MAGIC_COOKIE"
+	MagicCookieValue = "d2b8e2c1f05e4a7b9c3f6a1e8d4b7c20"
+)
+
+// ErrNotFound is returned by a Backend when it has no record for a machine.
+var ErrNotFound = errors.New("machine not found")
+
+// DHCP holds the DHCP headers and options to be set in a DHCP response.
+type DHCP struct {
+	MACAddress       net.HardwareAddr // chaddr DHCP header.
+	IPAddress        netip.Addr       // yiaddr DHCP header.
+	SubnetMask       net.IPMask       // DHCP option 1.
+	DefaultGateway   netip.Addr       // DHCP option 3.
+	NameServers      []net.IP         // DHCP option 6.
+	Hostname         string           // DHCP option 12.
+	DomainName       string           // DHCP option 15.
+	BroadcastAddress netip.Addr       // DHCP option 28.
+	NTPServers       []net.IP         // DHCP option 42.
+	VLANID           string           // DHCP option 43.116.
+	LeaseTime        uint32           // DHCP option 51.
+	Arch             string           // DHCP option 93.
+	DomainSearch     []string         // DHCP option 119.
+	Disabled         bool             // If true, no DHCP response should be sent.
+}
+
+// Netboot holds info used in netbooting a machine.
+type Netboot struct {
+	AllowNetboot  bool     // If true, the machine will be provided netboot options in the DHCP offer/ack.
+	IPXEScriptURL *url.URL // Overrides the default iPXE script URL.
+	IPXEScript    string   // Overrides the default iPXE script.
+	Console       string
+	Facility      string
+	Profile       string // Name of the boot profile of the machine.
+	OSIE          OSIE
+	Labels        map[string]string // Labels of the backend record, used by netboot policies.
+}
+
+// OSIE or OS Installation Environment is the data about where the OSIE parts are located.
+type OSIE struct {
+	// BaseURL is the URL where the OSIE parts are located.
+	BaseURL *url.URL
+	// Kernel is the name of the kernel file.
+	Kernel string
+	// Initrd is the name of the initrd file.
+	Initrd string
+}
+
+// Backend provides the DHCP and netboot data of machines.
+type Backend interface {
+	// GetByMac returns the data of the machine with the MAC address, or ErrNotFound.
+	GetByMac(context.Context, net.HardwareAddr) (*DHCP, *Netboot, error)
+	// GetByIP returns the data of the machine with the IP address, or ErrNotFound.
+	GetByIP(context.Context, net.IP) (*DHCP, *Netboot, error)
+}
+
+// ScriptRequest is a request for the auto.ipxe script of a machine.
+type ScriptRequest struct {
+	MAC      net.HardwareAddr
+	Arch     string
+	IP       netip.Addr
+	Facility string
+	Labels   map[string]string
+	// Script is the Hook script that Smee serves when the generator returns an empty script.
+	Script string
+}
+
+// ScriptGenerator generates the auto.ipxe script of a machine.
+type ScriptGenerator interface {
+	// Script returns the script to serve, an empty script serves the Hook script.
+	Script(context.Context, ScriptRequest) (string, error)
+}
+
+// Packet is a DHCP message received by Smee.
+type Packet struct {
+	// Peer is the address of the client that sent the message.
+	Peer string
+	// IfName and IfIndex are the name and index of the interface the message was received on.
+	IfName  string
+	IfIndex int
+	// Message is the DHCPv4 message, as received on the wire.
+	Message []byte
+}
+
+// DHCPHandler handles the DHCP messages received by Smee, in place of the built-in handlers.
+type DHCPHandler interface {
+	// Handle returns the DHCPv4 reply to send, on the wire format, or nil to not reply.
+	// Smee sends the reply to the relay agent when the message was relayed, otherwise to the peer.
+	Handle(context.Context, Packet) ([]byte, error)
+}
+
+// Plugins are the implementations served by a plugin, a nil implementation is not served.
+type Plugins struct {
+	Backend Backend
+	Script  ScriptGenerator
+	DHCP    DHCPHandler
+}
@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+// Name is the name of the Smee plugin in the go-plugin plugin set.
+const Name = "smee"
+
+// Handshake is the go-plugin handshake of Smee plugins.
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  ProtocolVersion,
+	MagicCookieKey:   MagicCookieKey,
+	MagicCookieValue: MagicCookieValue,
+}
+
+// The types below are the messages of the plugin protocol. They are only used by Serve and the Smee plugin host.
+
+// Info describes a plugin, it is the reply to the Plugin.Info call.
+type Info struct {
+	Backend bool
+	Script  bool
+	DHCP    bool
+}
+
+// LookupArgs are the arguments of the Plugin.GetByMac and Plugin.GetByIP calls.
+type LookupArgs struct {
+	MAC      net.HardwareAddr
+	IP       net.IP
+	Deadline time.Time
+}
+
+// LookupReply is the reply to the Plugin.GetByMac and Plugin.GetByIP calls.
+type LookupReply struct {
+	DHCP     *DHCP
+	Netboot  *Netboot
+	NotFound bool
+}
+
+// ScriptArgs are the arguments of the Plugin.Script call.
+type ScriptArgs struct {
+	Request  ScriptRequest
+	Deadline time.Time
+}
+
+// ScriptReply is the reply to the Plugin.Script call.
+type ScriptReply struct {
+	Script string
+}
+
+// DHCPArgs are the arguments of the Plugin.HandleDHCP call.
+type DHCPArgs struct {
+	Packet   Packet
+	Deadline time.Time
+}
+
+// DHCPReply is the reply to the Plugin.HandleDHCP call.
+type DHCPReply struct {
+	Reply []byte
+}
+
+// Serve serves the plugins to Smee, it is called from the main function of a plugin.
+// The plugins are served with go-plugin, over net/rpc. Anything written to stdout and stderr is logged by Smee.
+// Serve returns once Smee disconnects.
+func Serve(p Plugins) error {
+	if os.Getenv(MagicCookieKey) != MagicCookieValue {
+		return errors.New("this binary is a Smee plugin, it is started by Smee and can't be run directly")
+	}
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         goplugin.PluginSet{Name: &rpcPlugin{plugins: p}},
+		Logger:          hclog.New(&hclog.LoggerOptions{Level: hclog.Warn, Output: os.Stderr}),
+	})
+
+	return nil
+}
+
+// rpcPlugin is the go-plugin net/rpc plugin that serves the plugins.
+type rpcPlugin struct {
+	plugins Plugins
+}
+
+// Server implements goplugin.Plugin.
+func (p *rpcPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return &service{plugins: p.plugins}, nil
+}
+
+// Client implements goplugin.Plugin, the client is the net/rpc client of the service.
+func (*rpcPlugin) Client(_ *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return c, nil
+}
+
+// service is the net/rpc service of a plugin.
+type service struct {
+	plugins Plugins
+}
+
+// Info returns the implementations of the plugin.
+func (s *service) Info(_ struct{}, reply *Info) error {
+	*reply = Info{
+		Backend: s.plugins.Backend != nil,
+		Script:  s.plugins.Script != nil,
+		DHCP:    s.plugins.DHCP != nil,
+	}
+
+	return nil
+}
+
+// GetByMac calls the GetByMac method of the backend.
+func (s *service) GetByMac(args LookupArgs, reply *LookupReply) error {
+	if s.plugins.Backend == nil {
+		return errors.New("the plugin has no backend")
+	}
+	ctx, cancel := callContext(args.Deadline)
+	defer cancel()
+
+	return lookupReply(reply)(s.plugins.Backend.GetByMac(ctx, args.MAC))
+}
+
+// GetByIP calls the GetByIP method of the backend.
+func (s *service) GetByIP(args LookupArgs, reply *LookupReply) error {
+	if s.plugins.Backend == nil {
+		return errors.New("the plugin has no backend")
+	}
+	ctx, cancel := callContext(args.Deadline)
+	defer cancel()
+
+	return lookupReply(reply)(s.plugins.Backend.GetByIP(ctx, args.IP))
+}
+
+// Script calls the script generator.
+func (s *service) Script(args ScriptArgs, reply *ScriptReply) error {
+	if s.plugins.Script == nil {
+		return errors.New("the plugin has no script generator")
+	}
+	ctx, cancel := callContext(args.Deadline)
+	defer cancel()
+	script, err := s.plugins.Script.Script(ctx, args.Request)
+	if err != nil {
+		return err
+	}
+	reply.Script = script
+
+	return nil
+}
+
+// HandleDHCP calls the DHCP handler.
+func (s *service) HandleDHCP(args DHCPArgs, reply *DHCPReply) error {
+	if s.plugins.DHCP == nil {
+		return errors.New("the plugin has no DHCP handler")
+	}
+	ctx, cancel := callContext(args.Deadline)
+	defer cancel()
+	r, err := s.plugins.DHCP.Handle(ctx, args.Packet)
+	if err != nil {
+		return err
+	}
+	reply.Reply = r
+
+	return nil
+}
+
+// lookupReply returns a func that sets reply from the results of a backend lookup.
+// ErrNotFound is sent as reply.NotFound, other errors as the error of the call.
+func lookupReply(reply *LookupReply) func(*DHCP, *Netboot, error) error {
+	return func(d *DHCP, n *Netboot, err error) error {
+		if errors.Is(err, ErrNotFound) {
+			reply.NotFound = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply.DHCP = d
+		reply.Netboot = n
+
+		return nil
+	}
+}
+
+// callContext returns the context of a call, with the deadline of the caller if it has one.
+func callContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithDeadline(context.Background(), deadline)
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"context"
+	"net/rpc"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+type fakeDHCP struct{}
+
+func (fakeDHCP) Handle(_ context.Context, p Packet) ([]byte, error) {
+	return append([]byte("reply to "), p.Message...), nil
+}
+
+func TestServeNoCookie(t *testing.T) {
+	t.Setenv(MagicCookieKey, "")
+	if err := Serve(Plugins{}); err == nil {
+		t.Fatal("expected an error when not started by Smee")
+	}
+}
+
+func TestServe(t *testing.T) {
+	client, _ := goplugin.TestPluginRPCConn(t, goplugin.PluginSet{Name: &rpcPlugin{plugins: Plugins{DHCP: fakeDHCP{}}}}, nil)
+	defer client.Close()
+	raw, err := client.Dispense(Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := raw.(*rpc.Client)
+
+	var info Info
+	if err := c.Call("Plugin.Info", struct{}{}, &info); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Info{DHCP: true}, info); diff != "" {
+		t.Fatal(diff)
+	}
+	var reply DHCPReply
+	if err := c.Call("Plugin.HandleDHCP", DHCPArgs{Packet: Packet{Message: []byte("discover")}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("reply to discover", string(reply.Reply)); diff != "" {
+		t.Fatal(diff)
+	}
+	if err := c.Call("Plugin.GetByMac", LookupArgs{}, &LookupReply{}); err == nil {
+		t.Fatal("expected an error calling the backend of a plugin without one")
+	}
+}
@@ -0,0 +1,432 @@
+// Package pxeclient is a virtual network boot client. It speaks DHCPv4, ProxyDHCP, TFTP and HTTP like the firmware
+// and the iPXE binary of a machine, so that a Smee deployment can be checked without booting a machine.
+//
+// The client reproduces the behavior of PXE firmware that deployments trip over: it sets the broadcast flag, sends
+// the PXE options (60, 93, 94 and 97), waits for ProxyDHCP offers next to the offer with an address, and gives up
+// after the short retransmission timeouts of the PXE specification.
+//
+//	c := &pxeclient.Client{MAC: mac}
+//	b, err := c.Boot(ctx, pxeclient.StagePXE)
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Printf("booted %s, got the iPXE script:\n%s", b.Firmware.Bootfile, b.Script)
+//
+// Listening on the DHCP client port, 68, needs root privileges and no other DHCP client on the host.
+package pxeclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/pin/tftp/v3"
+)
+
+// Stage is the program of a machine that network boots, it decides the DHCP options that the client sends.
+type Stage int
+
+const (
+	// StagePXE is the PXE firmware of a machine, it boots the iPXE binary over TFTP.
+	StagePXE Stage = iota
+	// StageHTTP is the UEFI HTTP boot firmware of a machine, it boots the iPXE binary over HTTP.
+	StageHTTP
+	// StageIPXE is the iPXE binary, it boots the iPXE script.
+	StageIPXE
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StagePXE:
+		return "pxe"
+	case StageHTTP:
+		return "http"
+	case StageIPXE:
+		return "ipxe"
+	}
+
+	return "unknown"
+}
+
+// DefaultTimeouts are the retransmission timeouts of PXE firmware, the client gives up after the last one.
+var DefaultTimeouts = []time.Duration{4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second}
+
+var (
+	// ErrNoOffer is returned when no DHCP server offered an address before the last timeout.
+	ErrNoOffer = errors.New("no DHCP offer")
+	// ErrNoBootfile is returned when neither the DHCP offer nor a ProxyDHCP offer have a boot file.
+	ErrNoBootfile = errors.New("no boot file offered")
+)
+
+// Client is a virtual network boot client. The zero value, with MAC set, behaves like the PXE firmware of an
+// x86_64 UEFI machine, on the network of the host.
+type Client struct {
+	// MAC is the MAC address of the client.
+	MAC net.HardwareAddr
+	// Arch is the client system architecture (option 93) of the PXE firmware, EFI x86_64 by default.
+	// The HTTP boot firmware sends the HTTP boot architecture that matches it.
+	Arch iana.Arch
+	// UUID is the client machine identifier (option 97). Some PXE ROMs don't send one, none is sent when it is empty.
+	UUID [16]byte
+	// Server is the address the DHCP messages are sent to, 255.255.255.255:67 by default.
+	Server netip.AddrPort
+	// LocalAddr is the address the client listens on, 0.0.0.0:68 by default.
+	LocalAddr netip.AddrPort
+	// Timeouts are the retransmission timeouts of the DHCP messages, DefaultTimeouts by default.
+	// The client waits for a ProxyDHCP offer until the timeout of the message when the first offer has no boot file.
+	Timeouts []time.Duration
+	// Unicast clears the broadcast flag that PXE firmware sets, as it can't receive a unicast reply before it has an address.
+	Unicast bool
+	// UserClass is the user class (option 77) of the iPXE binary, "Tinkerbell", that of the iPXE binaries of Smee, by default.
+	UserClass string
+	// TFTPPort is the port of the TFTP servers, 69 by default. A boot file that is a tftp:// URL sets its own port.
+	TFTPPort int
+	// TFTPBlockSize is the TFTP block size that is negotiated, 1456 by default, what most firmware asks for.
+	TFTPBlockSize int
+	// HTTPClient fetches the boot files that are http:// or https:// URLs, http.DefaultClient by default.
+	HTTPClient *http.Client
+}
+
+// Lease is the outcome of the DHCP exchange of a stage.
+type Lease struct {
+	Stage Stage
+	// Offer and Ack are the DHCPOFFER with an address and the DHCPACK of it.
+	Offer *dhcpv4.DHCPv4
+	Ack   *dhcpv4.DHCPv4
+	// ProxyOffer is the ProxyDHCP offer, an offer without an address, nil when there was none.
+	ProxyOffer *dhcpv4.DHCPv4
+	// IP is the address of the client.
+	IP net.IP
+	// Bootfile and NextServer are the boot file and the server it is fetched from, of the DHCPACK or else
+	// of the ProxyDHCP offer.
+	Bootfile   string
+	NextServer net.IP
+}
+
+// Boot is the outcome of a network boot, from the firmware to the iPXE script.
+type Boot struct {
+	// Firmware is the lease of the firmware and Binary the boot file that it fetched, the iPXE binary.
+	Firmware *Lease
+	Binary   []byte
+	// IPXE is the lease of the iPXE binary and Script the boot file that it fetched, the iPXE script.
+	IPXE   *Lease
+	Script []byte
+}
+
+// Boot network boots like a machine: the firmware fetches the iPXE binary and the iPXE binary fetches the iPXE script.
+// firmware is StagePXE or StageHTTP.
+func (c *Client) Boot(ctx context.Context, firmware Stage) (*Boot, error) {
+	if firmware == StageIPXE {
+		return nil, errors.New("the firmware stage must be pxe or http")
+	}
+	b := &Boot{}
+	var err error
+	if b.Firmware, err = c.DHCP(ctx, firmware); err != nil {
+		return b, fmt.Errorf("%s DHCP: %w", firmware, err)
+	}
+	if b.Binary, err = c.Fetch(ctx, b.Firmware); err != nil {
+		return b, fmt.Errorf("%s fetch of %s: %w", firmware, b.Firmware.Bootfile, err)
+	}
+	if b.IPXE, err = c.DHCP(ctx, StageIPXE); err != nil {
+		return b, fmt.Errorf("%s DHCP: %w", StageIPXE, err)
+	}
+	if b.Script, err = c.Fetch(ctx, b.IPXE); err != nil {
+		return b, fmt.Errorf("%s fetch of %s: %w", StageIPXE, b.IPXE.Bootfile, err)
+	}
+
+	return b, nil
+}
+
+// DHCP does the DHCP exchange of stage: it broadcasts a DHCPDISCOVER, selects the offer with an address, keeps
+// the ProxyDHCP offer if any, and requests the offered address.
+func (c *Client) DHCP(ctx context.Context, stage Stage) (*Lease, error) {
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(c.localAddr()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	mods := c.options(stage)
+
+	discover, err := dhcpv4.NewDiscovery(c.MAC, mods...)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lease{Stage: stage}
+	err = c.exchange(ctx, conn, discover, func(reply *dhcpv4.DHCPv4) bool {
+		if reply.MessageType() != dhcpv4.MessageTypeOffer {
+			return false
+		}
+		switch {
+		case isSet(reply.YourIPAddr) && l.Offer == nil:
+			l.Offer = reply
+		case !isSet(reply.YourIPAddr) && l.ProxyOffer == nil:
+			l.ProxyOffer = reply
+		}
+		// done when the boot file is known, otherwise wait for a ProxyDHCP offer until the timeout.
+		return l.Offer != nil && (l.Offer.BootFileName != "" || l.ProxyOffer != nil)
+	})
+	if l.Offer == nil {
+		if err == nil || errors.Is(err, errTimeout) {
+			err = ErrNoOffer
+		}
+		return l, fmt.Errorf("DHCPDISCOVER: %w", err)
+	}
+
+	request, err := dhcpv4.NewRequestFromOffer(l.Offer, mods...)
+	if err != nil {
+		return l, err
+	}
+	serverID := l.Offer.ServerIdentifier()
+	err = c.exchange(ctx, conn, request, func(reply *dhcpv4.DHCPv4) bool {
+		// a ProxyDHCP server can answer the DHCPREQUEST too, with an ACK without an address.
+		// Only the reply of the selected server is the lease.
+		if serverID != nil && !serverID.Equal(reply.ServerIdentifier()) {
+			return false
+		}
+		switch reply.MessageType() {
+		case dhcpv4.MessageTypeAck:
+			if !isSet(reply.YourIPAddr) {
+				return false
+			}
+			l.Ack = reply
+			return true
+		case dhcpv4.MessageTypeNak:
+			l.Ack = reply
+			return true
+		}
+		return false
+	})
+	if err == nil && l.Ack == nil {
+		err = errors.New("no DHCPACK")
+	}
+	if err != nil {
+		return l, fmt.Errorf("DHCPREQUEST: %w", err)
+	}
+	if l.Ack.MessageType() == dhcpv4.MessageTypeNak {
+		return l, fmt.Errorf("DHCPREQUEST: got a DHCPNAK: %s", l.Ack.Message())
+	}
+	l.IP = l.Ack.YourIPAddr
+
+	switch {
+	case l.Ack.BootFileName != "":
+		l.Bootfile, l.NextServer = l.Ack.BootFileName, l.Ack.ServerIPAddr
+	case l.ProxyOffer != nil && l.ProxyOffer.BootFileName != "":
+		l.Bootfile, l.NextServer = l.ProxyOffer.BootFileName, l.ProxyOffer.ServerIPAddr
+	default:
+		return l, ErrNoBootfile
+	}
+
+	return l, nil
+}
+
+var errTimeout = errors.New("timeout")
+
+// exchange sends pkt, retransmitting it after each of the timeouts, and passes the replies with its transaction ID to
+// done until done returns true. When the timeout of an attempt elapses after done accepted a reply, exchange returns
+// without retransmitting, so that an attempt waits for more replies.
+func (c *Client) exchange(ctx context.Context, conn *net.UDPConn, pkt *dhcpv4.DHCPv4, done func(*dhcpv4.DHCPv4) bool) error {
+	buf := make([]byte, 4096)
+	got := false
+	for _, timeout := range c.timeouts() {
+		if _, err := conn.WriteToUDPAddrPort(pkt.ToBytes(), c.server()); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, err := conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			reply, err := dhcpv4.FromBytes(buf[:n])
+			if err != nil || reply.OpCode != dhcpv4.OpcodeBootReply || reply.TransactionID != pkt.TransactionID {
+				continue
+			}
+			got = true
+			if done(reply) {
+				return nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if got {
+			return nil
+		}
+	}
+
+	return errTimeout
+}
+
+// options returns the DHCP options of stage.
+func (c *Client) options(stage Stage) []dhcpv4.Modifier {
+	arch := c.Arch
+	if arch == 0 {
+		arch = iana.EFI_X86_64
+	}
+	class := fmt.Sprintf("PXEClient:Arch:%05d:UNDI:003001", arch)
+	switch stage {
+	case StageHTTP:
+		if arch == iana.EFI_X86_64 {
+			arch = iana.EFI_X86_64_HTTP
+		}
+		class = fmt.Sprintf("HTTPClient:Arch:%05d:UNDI:003001", arch)
+	case StageIPXE:
+		class = fmt.Sprintf("PXEClient:Arch:%05d:UNDI:003010", arch)
+	}
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithBroadcast(!c.Unicast),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier(class)),
+		dhcpv4.WithOption(dhcpv4.OptClientArch(arch)),
+		// UNDI version 3.1, what most firmware sends.
+		dhcpv4.WithGeneric(dhcpv4.OptionClientNetworkInterfaceIdentifier, []byte{1, 3, 1}),
+		// the maximum DHCP message size of many PXE ROMs, replies must fit in it.
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(1260)),
+		dhcpv4.WithRequestedOptions(
+			dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter, dhcpv4.OptionDomainNameServer, dhcpv4.OptionHostName,
+			dhcpv4.OptionDomainName, dhcpv4.OptionVendorSpecificInformation, dhcpv4.OptionClassIdentifier,
+			dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName,
+		),
+	}
+	if c.UUID != [16]byte{} {
+		mods = append(mods, dhcpv4.WithGeneric(dhcpv4.OptionClientMachineIdentifier, append([]byte{0}, c.UUID[:]...)))
+	}
+	if stage == StageIPXE {
+		uc := c.UserClass
+		if uc == "" {
+			uc = "Tinkerbell"
+		}
+		mods = append(mods, dhcpv4.WithUserClass(uc, false))
+	}
+
+	return mods
+}
+
+// Fetch fetches the boot file of l: over HTTP when it is an http:// or https:// URL, otherwise over TFTP from the URL
+// when it is a tftp:// URL, or from the next server of l.
+func (c *Client) Fetch(ctx context.Context, l *Lease) ([]byte, error) {
+	u, err := url.Parse(l.Bootfile)
+	if err == nil {
+		switch u.Scheme {
+		case "http", "https":
+			return c.fetchHTTP(ctx, u)
+		case "tftp":
+			return c.fetchTFTP(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+		}
+	}
+	if l.NextServer == nil || l.NextServer.IsUnspecified() {
+		return nil, errors.New("no next server to fetch the boot file from")
+	}
+
+	return c.fetchTFTP(ctx, net.JoinHostPort(l.NextServer.String(), strconv.Itoa(c.tftpPort())), l.Bootfile)
+}
+
+func (c *Client) fetchHTTP(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "iPXE/1.21.1")
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got HTTP status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) fetchTFTP(ctx context.Context, addr, file string) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(c.tftpPort()))
+	}
+	tc, err := tftp.NewClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	bs := c.TFTPBlockSize
+	if bs == 0 {
+		bs = 1456
+	}
+	tc.SetBlockSize(bs)
+	tc.RequestTSize(true)
+	if d, ok := ctx.Deadline(); ok {
+		tc.SetTimeout(time.Until(d))
+	}
+	wt, err := tc.Receive(file, "octet")
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	if _, err := wt.WriteTo(b); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (c *Client) localAddr() netip.AddrPort {
+	if c.LocalAddr.IsValid() {
+		return c.LocalAddr
+	}
+
+	return netip.AddrPortFrom(netip.IPv4Unspecified(), dhcpv4.ClientPort)
+}
+
+func (c *Client) server() netip.AddrPort {
+	if c.Server.IsValid() {
+		return c.Server
+	}
+
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte{255, 255, 255, 255}), dhcpv4.ServerPort)
+}
+
+func (c *Client) timeouts() []time.Duration {
+	if len(c.Timeouts) > 0 {
+		return c.Timeouts
+	}
+
+	return DefaultTimeouts
+}
+
+func (c *Client) tftpPort() int {
+	if c.TFTPPort > 0 {
+		return c.TFTPPort
+	}
+
+	return 69
+}
+
+func isSet(ip net.IP) bool {
+	return ip != nil && !ip.IsUnspecified()
+}
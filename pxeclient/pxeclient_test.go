@@ -0,0 +1,276 @@
+package pxeclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/pin/tftp/v3"
+	"github.com/tinkerbell/smee/internal/dhcp/data"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/proxy"
+	"github.com/tinkerbell/smee/internal/dhcp/handler/reservation"
+	"github.com/tinkerbell/smee/internal/dhcp/server"
+	"github.com/tinkerbell/smee/internal/metric"
+)
+
+var mac = net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+
+func TestMain(m *testing.M) {
+	metric.Init()
+	os.Exit(m.Run())
+}
+
+type backend struct{}
+
+func (backend) GetByMac(_ context.Context, m net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if m.String() != mac.String() {
+		return nil, nil, errors.New("not found")
+	}
+
+	return &data.DHCP{
+		MACAddress: mac,
+		IPAddress:  netip.MustParseAddr("192.168.2.50"),
+		SubnetMask: net.IPv4Mask(255, 255, 255, 0),
+		LeaseTime:  3600,
+	}, &data.Netboot{AllowNetboot: true}, nil
+}
+
+func (backend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+// addressServer offers addresses without a boot file, like the DHCP server of a network with a ProxyDHCP server.
+type addressServer struct{}
+
+func (addressServer) Handle(_ context.Context, conn data.Conn, p data.Packet) {
+	mt := dhcpv4.MessageTypeOffer
+	if p.Pkt.MessageType() == dhcpv4.MessageTypeRequest {
+		mt = dhcpv4.MessageTypeAck
+	}
+	reply, err := dhcpv4.NewReplyFromRequest(p.Pkt,
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithYourIP(net.IPv4(192, 168, 2, 60)),
+		dhcpv4.WithServerIP(net.IPv4(192, 168, 2, 1)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 168, 2, 1))),
+	)
+	if err != nil {
+		return
+	}
+	_, _ = conn.WriteTo(reply.ToBytes(), nil, p.Peer)
+}
+
+// dropFirst drops the first message of every transaction, so that the client retransmits it.
+type dropFirst struct {
+	server.Handler
+	seen      atomic.Int32
+	broadcast atomic.Bool
+}
+
+func (d *dropFirst) Handle(ctx context.Context, conn data.Conn, p data.Packet) {
+	d.broadcast.Store(p.Pkt.IsBroadcast())
+	if d.seen.Add(1) == 1 {
+		return
+	}
+	d.Handler.Handle(ctx, conn, p)
+}
+
+// smee serves the handlers over DHCP, the iPXE binary over TFTP and HTTP, and the iPXE script over HTTP, on localhost.
+type smee struct {
+	dhcp netip.AddrPort
+	tftp netip.AddrPort
+	http *url.URL
+}
+
+func startSmee(t *testing.T, handlers func(s smee) []server.Handler) smee {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	ts := tftp.NewServer(func(filename string, rf io.ReaderFrom) error {
+		_, err := rf.ReadFrom(strings.NewReader("binary " + filename))
+		return err
+	}, nil)
+	tc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = ts.Serve(tc) }()
+	t.Cleanup(ts.Shutdown)
+
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "auto.ipxe") {
+			fmt.Fprintf(w, "#!ipxe\necho %s\n", r.UserAgent())
+			return
+		}
+		fmt.Fprintf(w, "binary %s", r.URL.Path)
+	}))
+	t.Cleanup(hs.Close)
+	hu, _ := url.Parse(hs.URL)
+
+	dc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := smee{
+		dhcp: dc.LocalAddr().(*net.UDPAddr).AddrPort(),
+		tftp: tc.LocalAddr().(*net.UDPAddr).AddrPort(),
+		http: hu,
+	}
+	ds := &server.DHCP{Logger: logr.Discard(), Conn: dc, Handlers: handlers(s)}
+	go func() { _ = ds.Serve(ctx) }()
+
+	return s
+}
+
+func (s smee) reservation() *reservation.Handler {
+	return &reservation.Handler{
+		Backend: backend{},
+		IPAddr:  netip.MustParseAddr("127.0.0.1"),
+		Log:     logr.Discard(),
+		Netboot: reservation.Netboot{
+			IPXEBinServerTFTP: s.tftp,
+			IPXEBinServerHTTP: s.http,
+			IPXEScriptURL:     func(*dhcpv4.DHCPv4) *url.URL { return s.http.JoinPath("auto.ipxe") },
+			Enabled:           true,
+		},
+	}
+}
+
+func (s smee) client() *Client {
+	return &Client{
+		MAC:       mac,
+		Server:    s.dhcp,
+		LocalAddr: netip.MustParseAddrPort("127.0.0.1:0"),
+		Timeouts:  []time.Duration{200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond},
+		TFTPPort:  int(s.tftp.Port()),
+	}
+}
+
+func TestBoot(t *testing.T) {
+	tests := map[string]struct {
+		firmware   Stage
+		handlers   func(s smee) []server.Handler
+		wantIP     string
+		wantBinary string
+		wantProxy  bool
+	}{
+		"pxe": {
+			firmware:   StagePXE,
+			handlers:   func(s smee) []server.Handler { return []server.Handler{s.reservation()} },
+			wantIP:     "192.168.2.50",
+			wantBinary: "binary ipxe.efi",
+		},
+		"http boot": {
+			firmware:   StageHTTP,
+			handlers:   func(s smee) []server.Handler { return []server.Handler{s.reservation()} },
+			wantIP:     "192.168.2.50",
+			wantBinary: "binary /52:54:00:12:34:56/ipxe.efi",
+		},
+		"proxy": {
+			firmware: StagePXE,
+			handlers: func(s smee) []server.Handler {
+				return []server.Handler{addressServer{}, &proxy.Handler{
+					IPAddr: netip.MustParseAddr("127.0.0.1"),
+					Log:    logr.Discard(),
+					Netboot: proxy.Netboot{
+						IPXEBinServerTFTP: s.tftp,
+						IPXEBinServerHTTP: s.http,
+						IPXEScriptURL:     func(*dhcpv4.DHCPv4) *url.URL { return s.http.JoinPath("auto.ipxe") },
+						Enabled:           true,
+					},
+					AutoProxyEnabled: true,
+				}}
+			},
+			wantIP:     "192.168.2.60",
+			wantBinary: "binary ipxe.efi",
+			wantProxy:  true,
+		},
+		"proxy windows compat": {
+			firmware: StagePXE,
+			handlers: func(s smee) []server.Handler {
+				return []server.Handler{addressServer{}, &proxy.Handler{
+					IPAddr: netip.MustParseAddr("127.0.0.1"),
+					Log:    logr.Discard(),
+					Netboot: proxy.Netboot{
+						IPXEBinServerTFTP: s.tftp,
+						IPXEBinServerHTTP: s.http,
+						IPXEScriptURL:     func(*dhcpv4.DHCPv4) *url.URL { return s.http.JoinPath("auto.ipxe") },
+						Enabled:           true,
+					},
+					AutoProxyEnabled: true,
+					WindowsCompat:    true,
+				}}
+			},
+			wantIP:     "192.168.2.60",
+			wantBinary: "binary ipxe.efi",
+			wantProxy:  true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := startSmee(t, tt.handlers)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			b, err := s.client().Boot(ctx, tt.firmware)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Firmware.IP.String() != tt.wantIP {
+				t.Fatalf("got IP %s, want %s", b.Firmware.IP, tt.wantIP)
+			}
+			if (b.Firmware.ProxyOffer != nil) != tt.wantProxy {
+				t.Fatalf("got ProxyDHCP offer %v, want %v", b.Firmware.ProxyOffer != nil, tt.wantProxy)
+			}
+			if string(b.Binary) != tt.wantBinary {
+				t.Fatalf("got binary %q, want %q", b.Binary, tt.wantBinary)
+			}
+			if want := "#!ipxe\necho iPXE/1.21.1\n"; string(b.Script) != want {
+				t.Fatalf("got script %q, want %q", b.Script, want)
+			}
+		})
+	}
+}
+
+func TestRetransmit(t *testing.T) {
+	var d *dropFirst
+	s := startSmee(t, func(s smee) []server.Handler {
+		d = &dropFirst{Handler: s.reservation()}
+		return []server.Handler{d}
+	})
+	l, err := s.client().DHCP(context.Background(), StagePXE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Bootfile != "ipxe.efi" {
+		t.Fatalf("got boot file %q, want ipxe.efi", l.Bootfile)
+	}
+	if !d.broadcast.Load() {
+		t.Fatal("expected the broadcast flag to be set, like PXE firmware")
+	}
+}
+
+func TestNoOffer(t *testing.T) {
+	s := startSmee(t, func(smee) []server.Handler { return nil })
+	c := s.client()
+	c.Timeouts = []time.Duration{50 * time.Millisecond, 50 * time.Millisecond}
+	start := time.Now()
+	if _, err := c.DHCP(context.Background(), StagePXE); !errors.Is(err, ErrNoOffer) {
+		t.Fatalf("got error %v, want %v", err, ErrNoOffer)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("expected the client to give up after its timeouts")
+	}
+}